@@ -0,0 +1,180 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the at-least-once delivery of topic events, retaining each event
+// until the subscription handler acknowledges it and redelivering it locally if
+// the acknowledgement does not arrive within a time window.
+//
+// Since the relay delivers events at most once, the redelivery protects only
+// against the handler failing (panicking, hanging or bailing out), not against
+// the events lost in transit or buffered at subscription end.
+
+package iris
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Optional extension of TopicHandler, switching the subscription to at-least-once
+// delivery: every event has to be acknowledged via Delivery.Ack within the
+// TopicLimits.AckWindow, otherwise it's redelivered (possibly concurrently with
+// the running attempt, so handlers should be idempotent).
+type AckTopicHandler interface {
+	// Callback invoked whenever an event (or a redelivery of one) is received.
+	HandleDelivery(delivery *Delivery)
+}
+
+// Single delivery attempt of a topic event to an AckTopicHandler.
+type Delivery struct {
+	Topic   string // Topic the event was published to (concrete for pattern subscriptions)
+	Event   []byte // Payload of the delivered event
+	Attempt int    // Number of the delivery attempt, starting from one
+
+	ack  func()    // Callback releasing the event from redelivery
+	once sync.Once // Guard against acking the delivery multiple times
+}
+
+// Acknowledges the event, releasing it from redelivery. It may be called from
+// any goroutine, at any time after the handler returned; repeated calls (or
+// acks of any attempt of an already acked event) are no-ops.
+func (d *Delivery) Ack() {
+	d.once.Do(d.ack)
+}
+
+// Event retained until acknowledged, along with its redelivery state.
+type pendingDelivery struct {
+	id      uint64      // Index of the tracked event within the subscription
+	topic   string      // Topic the event was published to
+	event   []byte      // Payload to redeliver
+	attempt int         // Number of the latest delivery attempt
+	timer   *time.Timer // Timer triggering the redelivery of the event
+}
+
+// Starts tracking a freshly received event for acknowledgement, evicting the
+// oldest unacknowledged one if the buffer is full, and returns its first
+// delivery attempt.
+func (t *topic) trackDelivery(topic string, event []byte) *Delivery {
+	t.ackLock.Lock()
+	defer t.ackLock.Unlock()
+
+	t.ackIdx++
+	pend := &pendingDelivery{id: t.ackIdx, topic: topic, event: event, attempt: 1}
+	if !t.ackTerm {
+		if t.ackPend == nil {
+			t.ackPend = make(map[uint64]*list.Element)
+			t.ackList = list.New()
+		}
+		for t.ackList.Len() >= t.limits.AckBuffer {
+			old := t.ackList.Remove(t.ackList.Front()).(*pendingDelivery)
+			delete(t.ackPend, old.id)
+			old.timer.Stop()
+
+			t.logger.Warn("ack buffer full, dropping unacked event", "topic", old.topic, "attempt", old.attempt)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+		}
+		pend.timer = time.AfterFunc(t.limits.AckWindow, func() { t.expireDelivery(pend.id) })
+		t.ackPend[pend.id] = t.ackList.PushBack(pend)
+	}
+	return t.newDelivery(pend)
+}
+
+// Assembles a delivery attempt of a tracked event. The ack lock must be held.
+func (t *topic) newDelivery(pend *pendingDelivery) *Delivery {
+	id := pend.id
+	return &Delivery{
+		Topic:   pend.topic,
+		Event:   pend.event,
+		Attempt: pend.attempt,
+		ack:     func() { t.ackDelivery(id) },
+	}
+}
+
+// Releases an acknowledged event from redelivery.
+func (t *topic) ackDelivery(id uint64) {
+	t.ackLock.Lock()
+	defer t.ackLock.Unlock()
+
+	if elem, ok := t.ackPend[id]; ok {
+		pend := t.ackList.Remove(elem).(*pendingDelivery)
+		delete(t.ackPend, id)
+		pend.timer.Stop()
+	}
+}
+
+// Schedules the redelivery of an event whose acknowledgement window expired,
+// or drops it if the delivery attempts are exhausted.
+func (t *topic) expireDelivery(id uint64) {
+	t.ackLock.Lock()
+	defer t.ackLock.Unlock()
+
+	elem, ok := t.ackPend[id]
+	if !ok {
+		return
+	}
+	pend := elem.Value.(*pendingDelivery)
+	if t.limits.AckAttempts > 0 && pend.attempt >= t.limits.AckAttempts {
+		t.ackList.Remove(elem)
+		delete(t.ackPend, id)
+
+		t.logger.Warn("event unacked after all attempts, dropping", "topic", pend.topic, "attempts", pend.attempt)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		return
+	}
+	if err := t.eventPool.Schedule(func() { t.redeliver(id) }); err != nil {
+		t.ackList.Remove(elem)
+		delete(t.ackPend, id)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+	}
+}
+
+// Redelivers a still unacknowledged event to the subscription handler, restarting
+// its acknowledgement window.
+func (t *topic) redeliver(id uint64) {
+	t.ackLock.Lock()
+	elem, ok := t.ackPend[id]
+	if !ok {
+		t.ackLock.Unlock()
+		return
+	}
+	pend := elem.Value.(*pendingDelivery)
+	pend.attempt++
+	pend.timer = time.AfterFunc(t.limits.AckWindow, func() { t.expireDelivery(id) })
+	delivery := t.newDelivery(pend)
+	t.ackLock.Unlock()
+
+	atomic.AddInt32(&t.conn.stats.eventActive, 1)
+	defer atomic.AddInt32(&t.conn.stats.eventActive, -1)
+
+	t.logger.Debug("redelivering unacked event", "topic", pend.topic, "attempt", delivery.Attempt)
+
+	// The payload already passed the inbound interceptors, only guard the handler
+	t.conn.guard(func(context.Context, TraceOp, string, []byte) ([]byte, error) {
+		t.handler.(AckTopicHandler).HandleDelivery(delivery)
+		return nil, nil
+	})(context.Background(), TracePublish, t.name, delivery.Event)
+}
+
+// Stops tracking all unacknowledged events, as the subscription ended.
+func (t *topic) abandonDeliveries() {
+	t.ackLock.Lock()
+	defer t.ackLock.Unlock()
+
+	t.ackTerm = true
+	if t.ackList == nil {
+		return
+	}
+	for elem := t.ackList.Front(); elem != nil; elem = elem.Next() {
+		elem.Value.(*pendingDelivery).timer.Stop()
+	}
+	if n := t.ackList.Len(); n > 0 {
+		t.logger.Warn("abandoning unacked events", "count", n)
+	}
+	t.ackPend, t.ackList = nil, nil
+}
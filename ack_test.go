@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Topic handler for the acknowledgement tests, forwarding the deliveries to a
+// channel and acking only the ones past the failing attempts. The first attempts
+// alternately panic and bail out silently.
+type ackTestHandler struct {
+	fails      int
+	deliveries chan *Delivery
+}
+
+func (h *ackTestHandler) HandleEvent(event []byte) { panic("not implemented") }
+
+func (h *ackTestHandler) HandleDelivery(delivery *Delivery) {
+	h.deliveries <- delivery
+	if delivery.Attempt <= h.fails {
+		if delivery.Attempt%2 == 1 {
+			panic("simulated handler crash")
+		}
+		return
+	}
+	delivery.Ack()
+}
+
+// Tests that unacked events are redelivered until acknowledged.
+func TestTopicAckRedelivery(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &ackTestHandler{fails: 2, deliveries: make(chan *Delivery, 16)}
+	limits := &TopicLimits{AckWindow: 50 * time.Millisecond}
+	if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	// Verify the event is redelivered until the acking attempt
+	for attempt := 1; attempt <= handler.fails+1; attempt++ {
+		select {
+		case delivery := <-handler.deliveries:
+			if delivery.Attempt != attempt {
+				t.Fatalf("attempt mismatch: have %d, want %d.", delivery.Attempt, attempt)
+			}
+			if string(delivery.Event) != "event" || delivery.Topic != config.topic {
+				t.Fatalf("delivery mismatch: have %s/%s, want %s/event.", delivery.Topic, delivery.Event, config.topic)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("attempt %d: delivery timed out.", attempt)
+		}
+	}
+	// Verify that no further redeliveries happen after the ack
+	select {
+	case delivery := <-handler.deliveries:
+		t.Fatalf("acked event redelivered: attempt %d.", delivery.Attempt)
+	case <-time.After(4 * limits.AckWindow):
+	}
+}
+
+// Tests that redelivery gives up after the allowed attempts, and that the ack
+// buffer evicts the oldest unacked events when full.
+func TestTopicAckLimits(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Never ack anything, verify the attempts are capped
+	handler := &ackTestHandler{fails: 1 << 30, deliveries: make(chan *Delivery, 64)}
+	limits := &TopicLimits{AckWindow: 25 * time.Millisecond, AckAttempts: 3, AckBuffer: 1}
+	if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	time.Sleep(10 * limits.AckWindow)
+	if n := len(handler.deliveries); n != limits.AckAttempts {
+		t.Fatalf("delivery count mismatch: have %d, want %d.", n, limits.AckAttempts)
+	}
+	// Publish two events back to back, verify the first is evicted by the second
+	evict := config.topic + "-evict"
+	limits = &TopicLimits{AckWindow: 250 * time.Millisecond, AckAttempts: 2, AckBuffer: 1}
+	if err := conn.Subscribe(evict, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(evict)
+	time.Sleep(100 * time.Millisecond)
+
+	for len(handler.deliveries) > 0 {
+		<-handler.deliveries
+	}
+	for _, event := range []string{"first", "second"} {
+		if err := conn.Publish(evict, []byte(event)); err != nil {
+			t.Fatalf("publish failed: %v.", err)
+		}
+		select {
+		case <-handler.deliveries:
+		case <-time.After(time.Second):
+			t.Fatalf("%s: delivery timed out.", event)
+		}
+	}
+	time.Sleep(3 * limits.AckWindow)
+	if n := len(handler.deliveries); n != 1 {
+		t.Fatalf("redelivery count mismatch: have %d, want %d.", n, 1)
+	}
+	for len(handler.deliveries) > 0 {
+		if delivery := <-handler.deliveries; string(delivery.Event) != "second" {
+			t.Fatalf("evicted event redelivered: %s, attempt %d.", delivery.Event, delivery.Attempt)
+		}
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the audit log of the inbound requests and tunnels, recording who
+// called the service, with what and to what outcome, for compliance purposes.
+//
+// Requests are recorded once their reply is sent (or once they are rejected or
+// shed without reaching the handler), tunnels once they are torn down. The
+// irisaudit subpackage provides file and syslog backed sinks.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Audit log entry of an inbound request or tunnel.
+type AuditRecord struct {
+	Time          time.Time // Time instance the request or tunnel arrived at
+	Op            TraceOp   // Audited operation (TraceRequest or TraceTunnel)
+	Cluster       string    // Cluster of the caller, empty for clients or if not advertised
+	Node          string    // Connection identifier of the caller, empty if not advertised
+	CorrelationID string    // Correlation identifier of the request, empty if none
+
+	Size    int           // Size of the request payload, or the total bytes received through the tunnel
+	Elapsed time.Duration // Time it took to serve the request, or the tunnel was open for
+	Code    ErrorCode     // Category of the failure, only meaningful if Err is set
+	Err     error         // Failure of the operation, nil if it succeeded
+}
+
+// Destination of the audit log entries of a connection. The sink is invoked
+// synchronously on the go-routine of the audited operation, so it should return
+// swiftly and needs to be safe for concurrent use.
+type AuditSink interface {
+	Audit(record *AuditRecord)
+}
+
+// Adapter to use an ordinary function as an audit sink.
+type AuditFunc func(record *AuditRecord)
+
+// Records the audit log entry by calling the function.
+func (f AuditFunc) Audit(record *AuditRecord) {
+	f(record)
+}
+
+// Sets the sink to record the inbound requests and tunnels of the connection
+// into. A nil sink disables auditing.
+func (c *Connection) SetAuditSink(sink AuditSink) {
+	c.auditLock.Lock()
+	defer c.auditLock.Unlock()
+
+	c.audit = sink
+}
+
+// Retrieves the currently configured audit sink, nil if auditing is disabled.
+func (c *Connection) getAuditSink() AuditSink {
+	c.auditLock.RLock()
+	defer c.auditLock.RUnlock()
+
+	return c.audit
+}
+
+// Records the outcome of an inbound request if auditing is enabled.
+func (c *Connection) auditRequest(arrived time.Time, peer *Peer, corr string, size int, err error) {
+	sink := c.getAuditSink()
+	if sink == nil {
+		return
+	}
+	record := &AuditRecord{
+		Time:          arrived,
+		Op:            TraceRequest,
+		CorrelationID: corr,
+		Size:          size,
+		Elapsed:       time.Since(arrived),
+		Code:          auditCode(err),
+		Err:           err,
+	}
+	if peer != nil {
+		record.Cluster, record.Node = peer.Cluster, peer.Node
+	}
+	sink.Audit(record)
+}
+
+// Starts auditing an accepted inbound tunnel if auditing is enabled, retaining
+// the sink to record the tear-down to.
+func (t *Tunnel) auditOpen() {
+	sink := t.conn.getAuditSink()
+	if sink == nil {
+		return
+	}
+	t.itoaLock.Lock()
+	t.auditSink, t.auditTime = sink, time.Now()
+	t.itoaLock.Unlock()
+}
+
+// Records the reason an inbound tunnel was rejected by the inbound interceptors
+// or the encryption setup, overriding the tear-down reason in the audit log.
+func (t *Tunnel) auditReject(err error) {
+	t.itoaLock.Lock()
+	t.auditFail = err
+	t.itoaLock.Unlock()
+}
+
+// Records the tear-down of an audited inbound tunnel.
+func (t *Tunnel) auditDown() {
+	t.itoaLock.Lock()
+	sink, opened, peer, err := t.auditSink, t.auditTime, t.peer, t.auditFail
+	t.auditSink = nil
+	t.itoaLock.Unlock()
+
+	if sink == nil {
+		return
+	}
+	if err == nil {
+		err = t.stat
+	}
+	record := &AuditRecord{
+		Time:    opened,
+		Op:      TraceTunnel,
+		Size:    int(atomic.LoadUint64(&t.stats.bytesIn)),
+		Elapsed: time.Since(opened),
+		Code:    auditCode(err),
+		Err:     err,
+	}
+	if peer != nil {
+		record.Cluster, record.Node = peer.Cluster, peer.Node
+	}
+	sink.Audit(record)
+}
+
+// Classifies the failure of an inbound operation into an error code.
+func auditCode(err error) ErrorCode {
+	var structured *Error
+	switch {
+	case err == nil:
+		return CodeUnknown
+	case errors.As(err, &structured):
+		return structured.Code
+	case errors.Is(err, ErrInvalidArgument):
+		return CodeInvalidArgument
+	case errors.Is(err, ErrOverloaded), errors.Is(err, ErrDraining), errors.Is(err, ErrTimeout), errors.Is(err, context.DeadlineExceeded):
+		return CodeUnavailable
+	default:
+		return CodeUnknown
+	}
+}
@@ -0,0 +1,102 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Service handler for the audit tests, echoing requests (failing the ones asking
+// for it) and consuming a message on tunnels.
+type auditTestHandler struct{}
+
+func (a *auditTestHandler) Init(conn *Connection) error { return nil }
+func (a *auditTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (a *auditTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (a *auditTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if string(req) == "fail" {
+		return nil, &Error{Code: CodeNotFound, Message: "missing"}
+	}
+	return req, nil
+}
+
+func (a *auditTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+	tun.Recv(time.Second)
+}
+
+// Tests that inbound requests and tunnels are recorded into the audit sink along
+// with their callers and outcomes.
+func TestAudit(t *testing.T) {
+	// Register a new service recording into an audit channel
+	records := make(chan *AuditRecord, 8)
+	serv, err := Register(config.relay, config.cluster, new(auditTestHandler), nil,
+		WithAudit(AuditFunc(func(record *AuditRecord) { records <- record })))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect an identified client and issue a successful and a failing request
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	conn.SetAdvertiseIdentity(true)
+	conn.SetRequestCorrelation(true)
+
+	if _, err := conn.Request(config.cluster, []byte("hello"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if _, err := conn.Request(config.cluster, []byte("fail"), time.Second); err == nil {
+		t.Fatalf("failing request succeeded.")
+	}
+	// Open a tunnel, send some data and tear it down
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	if err := tun.Send([]byte("tunnel"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	tun.Close()
+
+	// Verify the audit records
+	wants := []struct {
+		op   TraceOp
+		size int // Lower bound, tunnels also count the control messages
+		code ErrorCode
+		fail bool
+	}{
+		{TraceRequest, 5, CodeUnknown, false},
+		{TraceRequest, 4, CodeNotFound, true},
+		{TraceTunnel, 6, CodeUnknown, false},
+	}
+	for i, want := range wants {
+		select {
+		case record := <-records:
+			if record.Op != want.op || record.Size < want.size || record.Code != want.code || (record.Err != nil) != want.fail {
+				t.Fatalf("record %d mismatch: have %s/%d/%v/%v, want %s/%d/%v/failed=%v.", i, record.Op, record.Size, record.Code, record.Err, want.op, want.size, want.code, want.fail)
+			}
+			if record.Cluster != "" || record.Node != conn.envId {
+				t.Fatalf("record %d caller mismatch: have %s/%s, want /%s.", i, record.Cluster, record.Node, conn.envId)
+			}
+			if want.op == TraceRequest && record.CorrelationID == "" {
+				t.Fatalf("record %d missing correlation identifier.", i)
+			}
+			if record.Time.IsZero() || record.Elapsed <= 0 {
+				t.Fatalf("record %d timing mismatch: have %v/%v.", i, record.Time, record.Elapsed)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("record %d not delivered.", i)
+		}
+	}
+}
@@ -0,0 +1,229 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the batched publishing of events, coalescing many small publishes
+// into a single relay write.
+
+package iris
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// User limits of an asynchronous batching publisher.
+type PublisherConfig struct {
+	BatchSize     int           // Queued events triggering an immediate flush
+	FlushInterval time.Duration // Maximum time an event may wait in the queue
+}
+
+// Default limits of an asynchronous batching publisher.
+var defaultPublisherConfig = PublisherConfig{
+	BatchSize:     128,
+	FlushInterval: 10 * time.Millisecond,
+}
+
+// Asynchronous publisher queueing events and forwarding them to the relay in
+// batches, either when enough accumulate or when the oldest one times out.
+type Publisher struct {
+	conn   *Connection
+	config *PublisherConfig
+
+	topics []string    // Topics of the queued events
+	events [][]byte    // Events queued for the next flush
+	timer  *time.Timer // Timer flushing the queue when the oldest event expires
+	closed bool        // Flag whether the publisher was closed
+	lock   sync.Mutex  // Mutex to protect the event queue
+	flush  sync.Mutex  // Mutex to serialize flushes, preserving event order
+}
+
+// Publishes a batch of events to topic, forwarding them to the local Iris node
+// in a single write. No guarantees are made that all subscribers receive the
+// events (best effort).
+//
+// The method blocks until the whole batch is forwarded to the local Iris node.
+func (c *Connection) PublishBatch(topic string, events [][]byte) error {
+	// Sanity check on the arguments
+	if len(topic) == 0 {
+		return invalidArgument("empty topic identifier")
+	}
+	for _, event := range events {
+		if len(event) == 0 {
+			return invalidArgument("nil or empty event")
+		}
+	}
+	if _, _, err := c.checkPublish(topic); err != nil {
+		return err
+	}
+	topics := make([]string, len(events))
+	for i := range topics {
+		topics[i] = topic
+	}
+	return c.publishBatch(topics, events)
+}
+
+// Runs each event of a batch through the outbound interceptors and tracing,
+// forwarding the results to the relay in a single write.
+func (c *Connection) publishBatch(topics []string, events [][]byte) error {
+	c.subLock.RLock()
+	fanout, envelope := c.patPublish, c.envPublish
+	c.subLock.RUnlock()
+
+	// Assemble the wire packets of all the events
+	var (
+		packTopics []string
+		packEvents [][]byte
+		finishes   []func(error)
+	)
+	for i, event := range events {
+		_, err := c.interceptOutbound(context.Background(), TracePublish, topics[i], event, func(ctx context.Context, _ TraceOp, topic string, event []byte) ([]byte, error) {
+			c.Log.Debug("publishing batched event", "topic", topic, "data", logLazyBlob(event))
+			if envelope {
+				event = c.wrapEnvelope("", event)
+			}
+			done := c.hookPublish(topic, event)
+			event, trace := c.traceOutbound(ctx, TracePublish, topic, event)
+			finish := func(err error) { trace(err); done(err) }
+
+			packTopics, packEvents = append(packTopics, topic), append(packEvents, event)
+			if fanout {
+				for _, fanin := range topicFanIns(topic) {
+					packTopics, packEvents = append(packTopics, fanin), append(packEvents, wrapFanIn(topic, event))
+				}
+			}
+			finishes = append(finishes, finish)
+			return nil, nil
+		})
+		if err != nil {
+			for _, finish := range finishes {
+				finish(err)
+			}
+			return err
+		}
+	}
+	// Wait for the rate limiter and forward the whole batch, reporting the outcome
+	err := c.throttle(context.Background(), c.limiters().publishes, len(finishes), nil)
+	if err == nil {
+		err = c.sendPublishBatch(packTopics, packEvents)
+	}
+	for _, finish := range finishes {
+		finish(err)
+	}
+	if err == nil {
+		atomic.AddUint64(&c.stats.pubSent, uint64(len(finishes)))
+	}
+	return err
+}
+
+// Creates an asynchronous publisher, coalescing the events published through it
+// into batches according to the given limits.
+//
+// Any unset fields (i.e. value of zero) will default to the preset ones.
+func (c *Connection) NewPublisher(config *PublisherConfig) *Publisher {
+	return &Publisher{
+		conn:   c,
+		config: finalizePublisherConfig(config),
+	}
+}
+
+// Merges the user requested limits with the defaults.
+func finalizePublisherConfig(user *PublisherConfig) *PublisherConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultPublisherConfig
+	}
+	// Check each field and merge only non-specified ones
+	config := new(PublisherConfig)
+	*config = *user
+
+	if user.BatchSize <= 0 {
+		config.BatchSize = defaultPublisherConfig.BatchSize
+	}
+	if user.FlushInterval <= 0 {
+		config.FlushInterval = defaultPublisherConfig.FlushInterval
+	}
+	return config
+}
+
+// Queues an event for publishing to topic. If the queue reaches the batch size,
+// it is flushed synchronously, otherwise at the latest after the flush interval.
+// The event is copied, so the caller may reuse its buffer right away.
+//
+// Failures of the background flushes are logged and the events discarded, in
+// line with the best effort delivery of publishes.
+func (p *Publisher) Publish(topic string, event []byte) error {
+	// Sanity check on the arguments
+	if len(topic) == 0 {
+		return invalidArgument("empty topic identifier")
+	}
+	if event == nil || len(event) == 0 {
+		return invalidArgument("nil or empty event")
+	}
+	if _, _, err := p.conn.checkPublish(topic); err != nil {
+		return err
+	}
+	// Detach the event from the caller's buffer, queue it and flush if the batch
+	// filled up
+	event = append([]byte(nil), event...)
+
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return ErrClosed
+	}
+	p.topics = append(p.topics, topic)
+	p.events = append(p.events, event)
+
+	full := len(p.events) >= p.config.BatchSize
+	if !full && p.timer == nil {
+		p.timer = time.AfterFunc(p.config.FlushInterval, func() {
+			if err := p.Flush(); err != nil {
+				p.conn.Log.Error("failed to flush publish batch", "reason", err)
+			}
+		})
+	}
+	p.lock.Unlock()
+
+	if full {
+		return p.Flush()
+	}
+	return nil
+}
+
+// Forwards all the queued events to the relay.
+//
+// The method blocks until the batch is forwarded to the local Iris node.
+func (p *Publisher) Flush() error {
+	p.flush.Lock()
+	defer p.flush.Unlock()
+
+	// Swap out the queued events
+	p.lock.Lock()
+	topics, events := p.topics, p.events
+	p.topics, p.events = nil, nil
+	if p.timer != nil {
+		p.timer.Stop()
+		p.timer = nil
+	}
+	p.lock.Unlock()
+
+	if len(events) == 0 {
+		return nil
+	}
+	return p.conn.publishBatch(topics, events)
+}
+
+// Flushes any queued events and closes the publisher, rejecting any further
+// publishes.
+func (p *Publisher) Close() error {
+	p.lock.Lock()
+	p.closed = true
+	p.lock.Unlock()
+
+	return p.Flush()
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Tests that batched and asynchronously coalesced publishes are all delivered.
+func TestPublishBatch(t *testing.T) {
+	// Connect to the local relay and subscribe to a topic
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, 100)}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish a few events in a single batch and a few more asynchronously
+	var batch [][]byte
+	for i := 0; i < 5; i++ {
+		batch = append(batch, []byte(fmt.Sprintf("batch-%d", i)))
+	}
+	if err := conn.PublishBatch(config.topic, batch); err != nil {
+		t.Fatalf("batch publish failed: %v.", err)
+	}
+	pub := conn.NewPublisher(&PublisherConfig{BatchSize: 4, FlushInterval: 50 * time.Millisecond})
+
+	buf := make([]byte, 0, 16) // Reused across the publishes, must be detached
+	for i := 0; i < 10; i++ {
+		buf = append(buf[:0], fmt.Sprintf("async-%d", i)...)
+		if err := pub.Publish(config.topic, buf); err != nil {
+			t.Fatalf("async publish failed: %v.", err)
+		}
+	}
+	// Wait for the interval flush of the remainder, then close the publisher
+	time.Sleep(100 * time.Millisecond)
+	if err := pub.Close(); err != nil {
+		t.Fatalf("publisher close failed: %v.", err)
+	}
+	if err := pub.Publish(config.topic, []byte{0x00}); err != ErrClosed {
+		t.Fatalf("publish after close error mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	// Verify that all events arrived (handlers run concurrently, so unordered)
+	want := make(map[string]bool)
+	for i := 0; i < 5; i++ {
+		want[fmt.Sprintf("batch-%d", i)] = true
+	}
+	for i := 0; i < 10; i++ {
+		want[fmt.Sprintf("async-%d", i)] = true
+	}
+	for i := 0; i < len(want); i++ {
+		select {
+		case event := <-handler.delivers:
+			if !want[string(event)] {
+				t.Fatalf("unexpected or duplicate event: %s.", event)
+			}
+			want[string(event)] = false
+		case <-time.After(time.Second):
+			t.Fatalf("event %d delivery timed out.", i)
+		}
+	}
+	if sent := conn.Metrics().PublishesSent; sent != uint64(len(want)) {
+		t.Fatalf("sent publish count mismatch: have %v, want %v.", sent, len(want))
+	}
+}
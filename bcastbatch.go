@@ -0,0 +1,108 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the batched broadcast delivery, collecting the inbound broadcasts
+// over a short window and handing them to the service handler in one go, to cut
+// the per message scheduling and locking overhead of high rate services.
+
+package iris
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Optional extension of ServiceHandler, receiving the inbound broadcasts in
+// batches instead of one by one if ServiceLimits.BroadcastBatch is set.
+//
+// Batched broadcasts bypass the inbound interceptors and tracing, as those
+// operate on individual messages.
+type BatchBroadcastHandler interface {
+	HandleBroadcastBatch(messages [][]byte)
+}
+
+// Default window over which broadcasts are collected into a batch.
+const defaultBroadcastWindow = time.Millisecond
+
+// Checks whether inbound broadcasts should be delivered in batches.
+func (c *Connection) batchingBroadcasts() (BatchBroadcastHandler, bool) {
+	if c.limits.BroadcastBatch <= 0 {
+		return nil, false
+	}
+	handler, ok := c.handler.(BatchBroadcastHandler)
+	return handler, ok
+}
+
+// Adds an inbound broadcast to the batch being collected, scheduling the batch
+// for delivery if it filled up, or arming the window timer if it's the first.
+// The memory allowance of the message is assumed to be already reserved.
+func (c *Connection) collectBroadcast(handler BatchBroadcastHandler, payload []byte, size int) {
+	c.batchLock.Lock()
+	defer c.batchLock.Unlock()
+
+	c.batchMsgs = append(c.batchMsgs, payload)
+	c.batchUsed += size
+
+	switch {
+	case len(c.batchMsgs) >= c.limits.BroadcastBatch:
+		c.flushBroadcasts(handler)
+
+	case c.batchTimer == nil:
+		var timer *time.Timer
+		timer = time.AfterFunc(c.limits.BroadcastWindow, func() {
+			c.batchLock.Lock()
+			defer c.batchLock.Unlock()
+
+			// Skip if the batch was delivered meanwhile due to filling up
+			if c.batchTimer == timer {
+				c.flushBroadcasts(handler)
+			}
+		})
+		c.batchTimer = timer
+	}
+}
+
+// Schedules the collected broadcasts for delivery as a single batch. The batch
+// lock is assumed to be held.
+func (c *Connection) flushBroadcasts(handler BatchBroadcastHandler) {
+	if c.batchTimer != nil {
+		c.batchTimer.Stop()
+		c.batchTimer = nil
+	}
+	batch, used := c.batchMsgs, c.batchUsed
+	c.batchMsgs, c.batchUsed = nil, 0
+
+	if len(batch) == 0 {
+		return
+	}
+	err := c.bcastPool.Schedule(func() {
+		// Start the processing by decrementing the memory usage
+		atomic.AddInt32(&c.bcastUsed, -int32(used))
+		atomic.AddInt32(&c.stats.bcastActive, 1)
+		defer atomic.AddInt32(&c.stats.bcastActive, -1)
+
+		c.Log.Debug("handling batched broadcasts", "count", len(batch), "size", used)
+		c.guard(func(context.Context, TraceOp, string, []byte) ([]byte, error) {
+			handler.HandleBroadcastBatch(batch)
+			return nil, nil
+		})(context.Background(), TraceBroadcast, c.cluster, nil)
+	})
+	if err == ErrOverloaded {
+		// The bounded broadcast queue is full, release the memory and drop
+		atomic.AddInt32(&c.bcastUsed, -int32(used))
+		atomic.AddUint64(&c.stats.dropped, uint64(len(batch)))
+		c.Log.Error("broadcast batch exceeded bounded queue", "count", len(batch), "queued", c.bcastPool.Pending())
+	}
+}
+
+// Checks whether there are broadcasts collected but not yet scheduled.
+func (c *Connection) broadcastsBatched() bool {
+	c.batchLock.Lock()
+	defer c.batchLock.Unlock()
+
+	return len(c.batchMsgs) > 0
+}
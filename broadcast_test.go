@@ -205,6 +205,55 @@ func TestBroadcastThreadLimit(t *testing.T) {
 	}
 }
 
+// Tests that expiring broadcasts are discarded if they time out while queued.
+func TestBroadcastTTL(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		sleep time.Duration
+		ttl   time.Duration
+	}{100 * time.Millisecond, 50 * time.Millisecond}
+
+	// Register a new single threaded service to the relay
+	handler := &broadcastLimitTestHandler{
+		delivers: make(chan []byte, 3),
+		sleep:    conf.sleep,
+	}
+	serv, err := Register(config.relay, config.cluster, handler, &ServiceLimits{BroadcastThreads: 1})
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if err := handler.conn.BroadcastTTL(config.cluster, []byte{0x00}, 0); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("zero ttl mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	// Occupy the handler, and queue a short and a long lived broadcast behind it
+	dropped := handler.conn.Metrics().MessagesDropped
+	if err := handler.conn.Broadcast(config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	if err := handler.conn.BroadcastTTL(config.cluster, []byte{0x01}, conf.ttl); err != nil {
+		t.Fatalf("short lived broadcast failed: %v.", err)
+	}
+	if err := handler.conn.BroadcastTTL(config.cluster, []byte{0x02}, time.Second); err != nil {
+		t.Fatalf("long lived broadcast failed: %v.", err)
+	}
+	// Verify that only the expired broadcast is discarded
+	for _, want := range []byte{0x00, 0x02} {
+		select {
+		case msg := <-handler.delivers:
+			if msg[0] != want {
+				t.Fatalf("broadcast mismatch: have %v, want %v.", msg, []byte{want})
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("broadcast %v not received.", want)
+		}
+	}
+	if have := handler.conn.Metrics().MessagesDropped - dropped; have != 1 {
+		t.Fatalf("dropped count mismatch: have %d, want %d.", have, 1)
+	}
+}
+
 // Tests the broadcast memory limitation.
 func TestBroadcastMemoryLimit(t *testing.T) {
 	// Create the service handler and limiter
@@ -252,6 +301,74 @@ func TestBroadcastMemoryLimit(t *testing.T) {
 	}
 }
 
+// Service handler for the batched broadcast tests.
+type broadcastBatchTestHandler struct {
+	conn    *Connection
+	batches chan [][]byte
+}
+
+func (b *broadcastBatchTestHandler) Init(conn *Connection) error          { b.conn = conn; return nil }
+func (b *broadcastBatchTestHandler) HandleBroadcast(msg []byte)           { panic("not implemented") }
+func (b *broadcastBatchTestHandler) HandleRequest([]byte) ([]byte, error) { panic("not implemented") }
+func (b *broadcastBatchTestHandler) HandleTunnel(tun *Tunnel)             { panic("not implemented") }
+func (b *broadcastBatchTestHandler) HandleDrop(reason error)              { panic("not implemented") }
+
+func (b *broadcastBatchTestHandler) HandleBroadcastBatch(msgs [][]byte) { b.batches <- msgs }
+
+// Tests that broadcasts are delivered in batches if requested.
+func TestBroadcastBatching(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		batch    int
+		window   time.Duration
+		messages int
+	}{8, 50 * time.Millisecond, 20}
+
+	// Create the service handler and limiter
+	handler := &broadcastBatchTestHandler{
+		batches: make(chan [][]byte, conf.messages),
+	}
+	limits := &ServiceLimits{BroadcastThreads: 1, BroadcastBatch: conf.batch, BroadcastWindow: conf.window}
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, limits)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Send a stream of broadcasts and collect the batches
+	for i := 0; i < conf.messages; i++ {
+		if err := handler.conn.Broadcast(config.cluster, []byte{byte(i)}); err != nil {
+			t.Fatalf("broadcast failed: %v.", err)
+		}
+	}
+	var sizes []int
+	for arrived := 0; arrived < conf.messages; {
+		select {
+		case batch := <-handler.batches:
+			for i, msg := range batch {
+				if len(msg) != 1 || int(msg[0]) != arrived+i {
+					t.Fatalf("broadcast #%d mismatch: have %v, want %v.", arrived+i, msg, []byte{byte(arrived + i)})
+				}
+			}
+			sizes = append(sizes, len(batch))
+			arrived += len(batch)
+		case <-time.After(time.Second):
+			t.Fatalf("batch receive timeout, arrived %d.", arrived)
+		}
+	}
+	// Verify that the batches were capped and the remainder flushed by the window
+	if len(sizes) >= conf.messages {
+		t.Fatalf("broadcasts not batched: %v.", sizes)
+	}
+	for i, size := range sizes {
+		if size > conf.batch {
+			t.Fatalf("batch #%d too large: have %d, want at most %d.", i, size, conf.batch)
+		}
+	}
+}
+
 // Benchmarks broadcasting a single message.
 func BenchmarkBroadcastLatency(b *testing.B) {
 	// Create the service handler
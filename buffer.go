@@ -0,0 +1,56 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the recycling of inbound tunnel message buffers.
+
+package iris
+
+import (
+	"math/bits"
+	"sync"
+)
+
+// Largest buffer size class recycled by the pool (64MB).
+const bufferMaxClass = 26
+
+// Size classed pool of message buffers, each class holding buffers with a power
+// of two capacity.
+type bufferPool struct {
+	classes [bufferMaxClass + 1]sync.Pool
+}
+
+// Pool of the buffers assembling inbound tunnel messages.
+var tunnelBuffers = new(bufferPool)
+
+// Retrieves a buffer of the given length, recycling an old one if available.
+func (p *bufferPool) get(size int) []byte {
+	class := bits.Len(uint(size - 1))
+	if size <= 1 {
+		class = 0
+	}
+	if class > bufferMaxClass {
+		return make([]byte, size)
+	}
+	if buf, ok := p.classes[class].Get().(*[]byte); ok {
+		return (*buf)[:size]
+	}
+	return make([]byte, size, 1<<class)
+}
+
+// Returns a buffer to the pool for recycling. Buffers not originating from the
+// pool are silently dropped.
+func (p *bufferPool) put(buf []byte) {
+	size := cap(buf)
+	if size == 0 || size&(size-1) != 0 {
+		return
+	}
+	class := bits.Len(uint(size - 1))
+	if class > bufferMaxClass {
+		return
+	}
+	buf = buf[:0]
+	p.classes[class].Put(&buf)
+}
@@ -0,0 +1,158 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the piecewise delivery of large tunnel messages, handing each chunk
+// to the application as it arrives instead of assembling the whole message.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// Returned by the whole message receive operations of a tunnel if the next
+// message is being streamed, requiring RecvChunks to consume it.
+var ErrChunked = errors.New("message streamed in chunks")
+
+// Retrieves the next message from the tunnel piecewise, invoking yield with each
+// chunk as it arrives instead of waiting for the whole message to be assembled,
+// and returns the number of bytes successfully yielded. Only multi-chunk messages
+// of tunnels with StreamChunks enabled are streamed, others are yielded whole in
+// a single call. The chunks are only valid for the duration of the yield call.
+//
+// The timeout bounds the wait for each chunk, not for the whole message. If the
+// wait or the yield fails midway, the next call resumes with the remainder of
+// the message. A message cut short by the remote side fails with
+// io.ErrUnexpectedEOF.
+//
+// Infinite blocking is supported with by setting the timeout to zero (0).
+func (t *Tunnel) RecvChunks(timeout time.Duration, yield func(chunk []byte) error) (int, error) {
+	var recvd int
+	for {
+		msg, err := t.recv(context.Background(), timeout, -1, true)
+		if err != nil {
+			return recvd, err
+		}
+		err = yield(msg.data)
+		tunnelBuffers.put(msg.buf)
+		if err != nil {
+			return recvd, err
+		}
+		recvd += len(msg.data)
+		if !msg.more {
+			return recvd, nil
+		}
+	}
+}
+
+// Checks whether an inbound message starting with the given chunk can be queued
+// piecewise: streaming needs to be enabled and the message neither encrypted,
+// compressed (the raw messages of compressing tunnels are fine) nor encoded by a
+// payload codec.
+func (t *Tunnel) streamable(head []byte) bool {
+	if !t.limits.StreamChunks || t.sealedIn || t.conn.codec != nil {
+		return false
+	}
+	return t.decompress == nil || head[0] == compressRaw
+}
+
+// Queues a chunk of a streamed message for the application: the head chunk (with
+// a non-zero total size) in message order, continuations for the reader of the
+// head to pick up.
+func (t *Tunnel) streamChunk(size int, chunk []byte) {
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	msg := &inboundMessage{data: chunk, size: len(chunk)}
+	if size != 0 {
+		// Strip any compression flag from the head
+		if t.decompress != nil {
+			msg.data = chunk[1:]
+		}
+		msg.meta, msg.data = unwrapMessageMeta(msg.data)
+		msg.more = true
+
+		// Discard the whole message if it arrived unencrypted on a secured tunnel
+		if t.open != nil {
+			t.Log.Error("dropping unencrypted message on secured tunnel", "size", size)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			t.chunkSkip = size - len(chunk)
+			t.grant(len(chunk))
+			return
+		}
+		// Discard the whole message if the bounded buffer is full. Continuations
+		// are queued into an unbounded one, as a lost chunk would corrupt the stream.
+		if !t.itoaBuf.Push(msg) {
+			t.Log.Error("streamed message exceeded bounded buffer", "queued", t.itoaBuf.Size(), "size", size)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			t.chunkSkip = size - len(chunk)
+			t.grant(len(chunk))
+			return
+		}
+		t.chunkLeft = size - len(chunk)
+		atomic.AddUint64(&t.stats.msgsIn, 1)
+	} else {
+		// Continuation, make sure a misbehaving sender cannot overflow the message
+		if t.chunkLeft -= len(chunk); t.chunkLeft < 0 {
+			t.Log.Warn("streamed message overflowed", "excess", -t.chunkLeft)
+			t.chunkLeft = 0
+		}
+		msg.more = t.chunkLeft > 0
+		t.streamBuf.Push(msg)
+	}
+	t.itoaUsed += len(chunk)
+	t.Log.Debug("queuing arrived chunk", "data", logLazyBlob(msg.data), "more", msg.more)
+
+	select {
+	case t.itoaSign <- struct{}{}:
+	default:
+	}
+}
+
+// Cuts short a partially arrived streamed message, if any, signalling the
+// truncation to its reader.
+func (t *Tunnel) abortStream() {
+	if t.chunkLeft == 0 {
+		return
+	}
+	t.Log.Warn("incomplete streamed message aborted", "missing", t.chunkLeft)
+	t.chunkLeft = 0
+
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	t.streamBuf.Push(&inboundMessage{abort: true})
+	select {
+	case t.itoaSign <- struct{}{}:
+	default:
+	}
+}
+
+// Fetches the next continuation chunk of the partially consumed streamed message,
+// or nil if none arrived yet. The inbound lock is assumed to be held.
+func (t *Tunnel) fetchContinuation() (*inboundMessage, error) {
+	if t.streamBuf.Empty() {
+		// No chunk, reset arrival flag
+		select {
+		case <-t.itoaSign:
+		default:
+		}
+		return nil, nil
+	}
+	chunk := t.streamBuf.Pop().(*inboundMessage)
+	t.itoaUsed -= chunk.size
+	t.streamOpen = chunk.more
+
+	if chunk.abort {
+		return nil, io.ErrUnexpectedEOF
+	}
+	t.grant(chunk.size)
+	return chunk, nil
+}
@@ -0,0 +1,95 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the close callbacks of connections and tunnels, letting application
+// components react to a tear-down without inspecting the errors of every call.
+
+package iris
+
+import "sync/atomic"
+
+// Registers a callback to notify when the connection is torn down, with a nil
+// reason after a graceful Close, or the failure that dropped the link otherwise
+// (after any reconnection attempts gave up). The callbacks run sequentially in
+// registration order on a separate goroutine, after all the tunnels were closed.
+// Callbacks registered after the tear-down are invoked right away.
+func (c *Connection) OnClose(callback func(reason error)) {
+	c.closeLock.Lock()
+	if !c.closeDone {
+		c.closeFuncs = append(c.closeFuncs, callback)
+		c.closeLock.Unlock()
+		return
+	}
+	reason := c.closeErr
+	c.closeLock.Unlock()
+
+	go callback(reason)
+}
+
+// Notifies the registered callbacks of the connection tear-down.
+func (c *Connection) notifyClose(reason error) {
+	c.closeLock.Lock()
+	callbacks := c.closeFuncs
+	c.closeFuncs, c.closeDone, c.closeErr = nil, true, reason
+	c.closeLock.Unlock()
+
+	runCloseFuncs(callbacks, reason)
+}
+
+// Registers a callback to notify when the tunnel is torn down, with a nil reason
+// after a graceful close (by either side), ErrPeerDead if the keepalive deemed
+// the peer unresponsive, ErrIdleClosed if it exceeded its idle timeout, or the
+// failure that dropped it otherwise. The callbacks run sequentially in
+// registration order on a separate goroutine. Callbacks registered after the
+// tear-down are invoked right away.
+func (t *Tunnel) OnClose(callback func(reason error)) {
+	t.itoaLock.Lock()
+	select {
+	case <-t.term:
+	default:
+		t.closeFuncs = append(t.closeFuncs, callback)
+		t.itoaLock.Unlock()
+		return
+	}
+	t.itoaLock.Unlock()
+
+	go callback(t.closeReason())
+}
+
+// Notifies the registered callbacks of the tunnel tear-down. The termination
+// channel is assumed to be closed already.
+func (t *Tunnel) notifyClose() {
+	t.itoaLock.Lock()
+	callbacks := t.closeFuncs
+	t.closeFuncs = nil
+	t.itoaLock.Unlock()
+
+	runCloseFuncs(callbacks, t.closeReason())
+}
+
+// Returns the reason of the tunnel tear-down, nil if it was graceful.
+func (t *Tunnel) closeReason() error {
+	if atomic.LoadInt32(&t.dead) == 1 {
+		return ErrPeerDead
+	}
+	if atomic.LoadInt32(&t.idle) == 1 {
+		return ErrIdleClosed
+	}
+	return t.stat
+}
+
+// Invokes the close callbacks sequentially on a separate goroutine, keeping the
+// tear-down (which might hold connection wide locks) unblocked.
+func runCloseFuncs(callbacks []func(reason error), reason error) {
+	if len(callbacks) == 0 {
+		return
+	}
+	go func() {
+		for _, callback := range callbacks {
+			callback(reason)
+		}
+	}()
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Waits for a close callback to report, failing the test on timeout.
+func waitClose(t *testing.T, what string, reasons chan error) error {
+	select {
+	case reason := <-reasons:
+		return reason
+	case <-time.After(time.Second):
+		t.Fatalf("%s close callback not invoked.", what)
+		return nil
+	}
+}
+
+// Tests that the close callbacks of tunnels and connections are notified of
+// graceful tear-downs, including ones registered afterwards.
+func TestCloseCallbacks(t *testing.T) {
+	// Register a new service to the relay and open a tunnel to it
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	tunnel, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	remote := <-handler.tunnels
+
+	// Close the tunnel and verify both ends are notified gracefully
+	local, peer, conns := make(chan error, 2), make(chan error, 1), make(chan error, 1)
+	tunnel.OnClose(func(reason error) { local <- reason })
+	remote.OnClose(func(reason error) { peer <- reason })
+	conn.OnClose(func(reason error) { conns <- reason })
+
+	if err := tunnel.Close(); err != nil {
+		t.Fatalf("tunnel close failed: %v.", err)
+	}
+	if reason := waitClose(t, "local tunnel", local); reason != nil {
+		t.Fatalf("local tunnel close reason mismatch: have %v, want nil.", reason)
+	}
+	if reason := waitClose(t, "remote tunnel", peer); reason != nil {
+		t.Fatalf("remote tunnel close reason mismatch: have %v, want nil.", reason)
+	}
+	tunnel.OnClose(func(reason error) { local <- reason })
+	if reason := waitClose(t, "late tunnel", local); reason != nil {
+		t.Fatalf("late tunnel close reason mismatch: have %v, want nil.", reason)
+	}
+	// Close the connection and verify its callback is notified gracefully
+	select {
+	case reason := <-conns:
+		t.Fatalf("connection close callback invoked early: %v.", reason)
+	default:
+	}
+	if err := conn.Close(); err != nil {
+		t.Fatalf("connection close failed: %v.", err)
+	}
+	if reason := waitClose(t, "connection", conns); reason != nil {
+		t.Fatalf("connection close reason mismatch: have %v, want nil.", reason)
+	}
+}
+
+// Tests that the close callbacks of connections and their tunnels are notified
+// of the failure dropping them.
+func TestCloseCallbacksDrop(t *testing.T) {
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(relay.Port(), config.cluster, &tunnelDropTestHandler{handler}, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	conn := serv.conn
+
+	tunnel, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	<-handler.tunnels
+
+	tunnels, conns := make(chan error, 1), make(chan error, 1)
+	tunnel.OnClose(func(reason error) { tunnels <- reason })
+	conn.OnClose(func(reason error) { conns <- reason })
+
+	// Drop the link and verify both callbacks report the failure
+	relay.Disconnect("maintenance")
+
+	if reason := waitClose(t, "tunnel", tunnels); reason == nil {
+		t.Fatalf("dropped tunnel reported graceful close.")
+	}
+	if reason := waitClose(t, "connection", conns); reason == nil {
+		t.Fatalf("dropped connection reported graceful close.")
+	}
+}
+
+// Tunnel handler of the drop tests, tolerating the connection drop.
+type tunnelDropTestHandler struct {
+	*tunnelPriorityTestHandler
+}
+
+func (t *tunnelDropTestHandler) HandleDrop(reason error) {}
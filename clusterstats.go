@@ -0,0 +1,102 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the request statistics of a connection broken down by destination
+// cluster, pinpointing the downstream services that degrade.
+
+package iris
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Point in time snapshot of the requests issued towards a single cluster.
+type ClusterStats struct {
+	RequestsSent     uint64    // Requests issued towards the cluster
+	RequestsFailed   uint64    // Issued requests that failed (timeout, remote error, etc)
+	RequestsTimedOut uint64    // Failed requests that timed out
+	Latency          Histogram // Latency distribution of the completed requests
+}
+
+// Returns the ratio of the completed requests that failed, or zero if none
+// completed yet.
+func (s *ClusterStats) ErrorRate() float64 {
+	if s.Latency.Count == 0 {
+		return 0
+	}
+	return float64(s.RequestsFailed) / float64(s.Latency.Count)
+}
+
+// Live request counters of a destination cluster, updated atomically.
+type clusterMetrics struct {
+	sent     uint64
+	failed   uint64
+	timeouts uint64
+
+	latency *latencyHistogram
+}
+
+// Retrieves a snapshot of the request statistics of each cluster the connection
+// issued requests towards (synchronous and asynchronous alike). The counters
+// accumulate over the lifetime of the connection.
+func (c *Connection) ClusterStats() map[string]*ClusterStats {
+	m := c.stats
+
+	m.clusterLock.RLock()
+	defer m.clusterLock.RUnlock()
+
+	stats := make(map[string]*ClusterStats, len(m.clusters))
+	for cluster, cm := range m.clusters {
+		stats[cluster] = &ClusterStats{
+			RequestsSent:     atomic.LoadUint64(&cm.sent),
+			RequestsFailed:   atomic.LoadUint64(&cm.failed),
+			RequestsTimedOut: atomic.LoadUint64(&cm.timeouts),
+			Latency:          cm.latency.snapshot(),
+		}
+	}
+	return stats
+}
+
+// Retrieves the live counters of a destination cluster, creating them if needed.
+func (m *metrics) cluster(name string) *clusterMetrics {
+	m.clusterLock.RLock()
+	cm, ok := m.clusters[name]
+	m.clusterLock.RUnlock()
+	if ok {
+		return cm
+	}
+	m.clusterLock.Lock()
+	defer m.clusterLock.Unlock()
+
+	if cm, ok = m.clusters[name]; !ok {
+		cm = &clusterMetrics{latency: newLatencyHistogram()}
+		m.clusters[name] = cm
+	}
+	return cm
+}
+
+// Records the issuing of a request towards a cluster.
+func (m *metrics) requestSent(cluster string) {
+	atomic.AddUint64(&m.reqSent, 1)
+	atomic.AddUint64(&m.cluster(cluster).sent, 1)
+}
+
+// Records the completion of a request towards a cluster.
+func (m *metrics) requestDone(cluster string, latency time.Duration, err error) {
+	cm := m.cluster(cluster)
+
+	m.latency.observe(latency)
+	cm.latency.observe(latency)
+	if err != nil {
+		atomic.AddUint64(&m.reqFailed, 1)
+		atomic.AddUint64(&cm.failed, 1)
+		if errors.Is(err, ErrTimeout) {
+			atomic.AddUint64(&cm.timeouts, 1)
+		}
+	}
+}
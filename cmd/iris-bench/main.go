@@ -0,0 +1,329 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Command iris-bench drives request, publish or tunnel workloads against an Iris
+// relay and reports the achieved throughput and latency percentiles, allowing
+// deployments to be validated and performance regressions caught.
+//
+// By default the tool also serves the workload itself (registering an echo
+// service and subscribing to the benchmark topic on the same relay), so a single
+// instance measures the full round trip:
+//
+//	iris-bench -relay 55555 -mode request -workers 64 -duration 30s -size 1024
+//
+// Disabling -serve leaves the serving side to other instances (e.g. running the
+// tool with -mode serve on another machine). Requests and tunnels are measured
+// round trip, publishes end to end if served locally, or by the publish call
+// otherwise.
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Benchmark workload parameters.
+type options struct {
+	relay    int           // Local port of the relay to connect to
+	mode     string        // Workload to drive: request, publish, tunnel or serve
+	workers  int           // Number of concurrent workload generators
+	duration time.Duration // Time to drive the workload for
+	warmup   time.Duration // Time to let the serving side propagate before measuring
+	size     int           // Size of the generated messages
+	cluster  string        // Service cluster to send requests and tunnels to
+	topic    string        // Topic to publish the events to
+	timeout  time.Duration // Timeout of the individual operations
+	serve    bool          // Flag whether to serve the workload locally too
+}
+
+// Measurements of a benchmark run.
+type result struct {
+	mode      string          // Workload that was driven
+	workers   int             // Number of concurrent workload generators
+	size      int             // Size of the generated messages
+	ops       uint64          // Number of successful operations
+	errs      uint64          // Number of failed operations
+	elapsed   time.Duration   // Actual duration of the workload
+	latencies []time.Duration // Latencies of the successful operations
+}
+
+func main() {
+	opts := new(options)
+	flag.IntVar(&opts.relay, "relay", 55555, "Local port of the Iris relay")
+	flag.StringVar(&opts.mode, "mode", "request", "Workload to drive (request, publish, tunnel or serve)")
+	flag.IntVar(&opts.workers, "workers", 16, "Number of concurrent workload generators")
+	flag.DurationVar(&opts.duration, "duration", 10*time.Second, "Time to drive the workload for")
+	flag.DurationVar(&opts.warmup, "warmup", time.Second, "Time to let the serving side propagate before measuring")
+	flag.IntVar(&opts.size, "size", 128, "Size of the generated messages in bytes")
+	flag.StringVar(&opts.cluster, "cluster", "iris-bench", "Service cluster to send requests and tunnels to")
+	flag.StringVar(&opts.topic, "topic", "iris-bench", "Topic to publish the events to")
+	flag.DurationVar(&opts.timeout, "timeout", time.Second, "Timeout of the individual operations")
+	flag.BoolVar(&opts.serve, "serve", true, "Serve the workload locally too (echo service and subscription)")
+	verbose := flag.Bool("verbose", false, "Log the informational messages of the binding too")
+	flag.Parse()
+
+	if !*verbose {
+		iris.Log = iris.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelWarn})))
+	}
+	if opts.mode == "serve" {
+		if err := serve(opts); err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to serve the workload: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	res, err := run(opts)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to run the benchmark: %v\n", err)
+		os.Exit(1)
+	}
+	res.report(os.Stdout)
+}
+
+// Checks the workload parameters for obvious mistakes.
+func (o *options) validate() error {
+	switch o.mode {
+	case "request", "tunnel", "serve":
+	case "publish":
+		if o.size < 8 {
+			return fmt.Errorf("publish messages must be at least 8 bytes (timestamp), have %d", o.size)
+		}
+	default:
+		return fmt.Errorf("unknown workload mode %q", o.mode)
+	}
+	if o.workers <= 0 {
+		return fmt.Errorf("non-positive worker count %d", o.workers)
+	}
+	if o.duration <= 0 {
+		return fmt.Errorf("non-positive duration %v", o.duration)
+	}
+	if o.size <= 0 {
+		return fmt.Errorf("non-positive message size %d", o.size)
+	}
+	return nil
+}
+
+// Serves the workload of other benchmark instances until interrupted: requests
+// and tunnels are echoed back, publishes are consumed.
+func serve(opts *options) error {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	serv, err := iris.Register(opts.relay, opts.cluster, new(echoHandler), nil)
+	if err != nil {
+		return err
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(opts.relay)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	if err := conn.SubscribeFunc(opts.topic, func(string, []byte) {}, nil); err != nil {
+		return err
+	}
+	fmt.Printf("Serving cluster %q and topic %q, interrupt to stop...\n", opts.cluster, opts.topic)
+	select {}
+}
+
+// Drives the configured workload against the relay and collects the results.
+func run(opts *options) (*result, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	if opts.mode == "serve" {
+		return nil, errors.New("serve mode has no measurable workload")
+	}
+	conn, err := iris.Connect(opts.relay)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	// Set up the serving side if requested, gathering the publish latencies
+	res := &result{mode: opts.mode, workers: opts.workers, size: opts.size}
+
+	var lock sync.Mutex
+	if opts.serve {
+		serv, err := iris.Register(opts.relay, opts.cluster, new(echoHandler), nil)
+		if err != nil {
+			return nil, err
+		}
+		defer serv.Unregister()
+
+		if opts.mode == "publish" {
+			record := func(_ string, event []byte) {
+				sent := time.Unix(0, int64(binary.BigEndian.Uint64(event)))
+
+				lock.Lock()
+				res.latencies = append(res.latencies, time.Since(sent))
+				lock.Unlock()
+			}
+			if err := conn.SubscribeFunc(opts.topic, record, nil); err != nil {
+				return nil, err
+			}
+			defer conn.Unsubscribe(opts.topic)
+		}
+		time.Sleep(opts.warmup)
+	}
+	// Start the workers and let them run for the requested duration
+	var (
+		pend sync.WaitGroup
+		stop = make(chan struct{})
+	)
+	start := time.Now()
+	for i := 0; i < opts.workers; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+
+			latencies := drive(conn, opts, res, stop)
+			if opts.mode != "publish" || !opts.serve {
+				lock.Lock()
+				res.latencies = append(res.latencies, latencies...)
+				lock.Unlock()
+			}
+		}()
+	}
+	time.Sleep(opts.duration)
+	close(stop)
+	pend.Wait()
+	res.elapsed = time.Since(start)
+
+	// Wait for the in-flight events to arrive if measuring end to end
+	if opts.mode == "publish" && opts.serve {
+		for deadline := time.Now().Add(opts.timeout); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			lock.Lock()
+			done := uint64(len(res.latencies)) >= atomic.LoadUint64(&res.ops)
+			lock.Unlock()
+			if done {
+				break
+			}
+		}
+		lock.Lock()
+		defer lock.Unlock()
+		if lost := atomic.LoadUint64(&res.ops) - uint64(len(res.latencies)); lost > 0 {
+			res.ops -= lost
+			res.errs += lost
+		}
+	}
+	return res, nil
+}
+
+// Runs a single workload generator until stopped, returning the latencies of
+// its successful operations.
+func drive(conn *iris.Connection, opts *options, res *result, stop chan struct{}) []time.Duration {
+	var (
+		latencies []time.Duration
+		message   = make([]byte, opts.size)
+		tunnel    *iris.Tunnel
+	)
+	defer func() {
+		if tunnel != nil {
+			tunnel.Close()
+		}
+	}()
+	for {
+		select {
+		case <-stop:
+			return latencies
+		default:
+		}
+		var (
+			start = time.Now()
+			err   error
+		)
+		switch opts.mode {
+		case "request":
+			_, err = conn.Request(opts.cluster, message, opts.timeout)
+
+		case "publish":
+			binary.BigEndian.PutUint64(message, uint64(start.UnixNano()))
+			err = conn.Publish(opts.topic, append([]byte(nil), message...))
+
+		case "tunnel":
+			if tunnel == nil {
+				if tunnel, err = conn.Tunnel(opts.cluster, opts.timeout); err != nil {
+					tunnel = nil
+					break
+				}
+			}
+			if err = tunnel.Send(message, opts.timeout); err == nil {
+				_, err = tunnel.Recv(opts.timeout)
+			}
+			if err != nil {
+				tunnel.Close()
+				tunnel = nil
+			}
+		}
+		if err != nil {
+			atomic.AddUint64(&res.errs, 1)
+			continue
+		}
+		atomic.AddUint64(&res.ops, 1)
+		latencies = append(latencies, time.Since(start))
+	}
+}
+
+// Returns the given percentile of the sorted latencies.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[int(float64(len(sorted)-1)*p/100+0.5)]
+}
+
+// Prints a human readable summary of the benchmark results.
+func (r *result) report(out io.Writer) {
+	sort.Slice(r.latencies, func(i, j int) bool { return r.latencies[i] < r.latencies[j] })
+
+	seconds := r.elapsed.Seconds()
+	fmt.Fprintf(out, "mode:       %s (%d workers, %d byte messages, %v)\n", r.mode, r.workers, r.size, r.elapsed.Round(time.Millisecond))
+	fmt.Fprintf(out, "operations: %d (%d errors)\n", r.ops, r.errs)
+	fmt.Fprintf(out, "throughput: %.1f ops/s, %.2f MB/s\n", float64(r.ops)/seconds, float64(r.ops)*float64(r.size)/seconds/1024/1024)
+	if len(r.latencies) == 0 {
+		fmt.Fprintf(out, "latency:    n/a\n")
+		return
+	}
+	fmt.Fprintf(out, "latency:    min %v, p50 %v, p90 %v, p99 %v, p99.9 %v, max %v\n",
+		r.latencies[0], percentile(r.latencies, 50), percentile(r.latencies, 90),
+		percentile(r.latencies, 99), percentile(r.latencies, 99.9), r.latencies[len(r.latencies)-1])
+}
+
+// Service handler echoing the benchmark requests and tunnel messages.
+type echoHandler struct{}
+
+func (h *echoHandler) Init(conn *iris.Connection) error         { return nil }
+func (h *echoHandler) HandleBroadcast(msg []byte)               {}
+func (h *echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (h *echoHandler) HandleDrop(reason error)                  {}
+
+// Echoes the tunnel messages until the tunnel is closed.
+func (h *echoHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, 0); err != nil {
+			return
+		}
+	}
+}
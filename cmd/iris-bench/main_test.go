@@ -0,0 +1,101 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Tests that each workload mode runs against a relay and measures its operations.
+func TestRun(t *testing.T) {
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	for _, mode := range []string{"request", "publish", "tunnel"} {
+		res, err := run(&options{
+			relay:    relay.Port(),
+			mode:     mode,
+			workers:  2,
+			duration: 100 * time.Millisecond,
+			warmup:   50 * time.Millisecond,
+			size:     64,
+			cluster:  "bench-" + mode,
+			topic:    "bench-" + mode,
+			timeout:  time.Second,
+			serve:    true,
+		})
+		if err != nil {
+			t.Fatalf("%s: benchmark failed: %v.", mode, err)
+		}
+		if res.ops == 0 || res.errs != 0 {
+			t.Fatalf("%s: operation count mismatch: have %d ok / %d failed, want some / 0.", mode, res.ops, res.errs)
+		}
+		if uint64(len(res.latencies)) != res.ops {
+			t.Fatalf("%s: latency count mismatch: have %d, want %d.", mode, len(res.latencies), res.ops)
+		}
+		out := new(bytes.Buffer)
+		res.report(out)
+		if !strings.Contains(out.String(), "p99") {
+			t.Fatalf("%s: latency percentiles missing from report:\n%s", mode, out)
+		}
+	}
+}
+
+// Tests that invalid workload parameters are rejected.
+func TestValidate(t *testing.T) {
+	valid := options{mode: "request", workers: 1, duration: time.Second, size: 1}
+	if err := valid.validate(); err != nil {
+		t.Fatalf("valid options rejected: %v.", err)
+	}
+	invalid := []func(o *options){
+		func(o *options) { o.mode = "bogus" },
+		func(o *options) { o.workers = 0 },
+		func(o *options) { o.duration = 0 },
+		func(o *options) { o.size = 0 },
+		func(o *options) { o.mode = "publish" },
+	}
+	for i, mutate := range invalid {
+		opts := valid
+		mutate(&opts)
+		if err := opts.validate(); err == nil {
+			t.Errorf("test %d: invalid options accepted: %+v.", i, opts)
+		}
+	}
+}
+
+// Tests the percentile selection over sorted latencies.
+func TestPercentile(t *testing.T) {
+	sorted := make([]time.Duration, 100)
+	for i := range sorted {
+		sorted[i] = time.Duration(i+1) * time.Millisecond
+	}
+	tests := []struct {
+		p    float64
+		want time.Duration
+	}{
+		{0, time.Millisecond},
+		{50, 51 * time.Millisecond},
+		{99, 99 * time.Millisecond},
+		{100, 100 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		if have := percentile(sorted, tt.p); have != tt.want {
+			t.Errorf("p%v mismatch: have %v, want %v.", tt.p, have, tt.want)
+		}
+	}
+	if have := percentile(nil, 50); have != 0 {
+		t.Errorf("empty percentile mismatch: have %v, want 0.", have)
+	}
+}
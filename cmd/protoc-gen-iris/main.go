@@ -0,0 +1,304 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Command protoc-gen-iris is a protocol buffer compiler plugin generating typed
+// Iris client stubs and server interfaces from the services of .proto files.
+//
+// Unary methods are mapped to routed request/reply calls (Connection.CallCtx
+// and iris.Router), server streaming methods to routed streamed requests over
+// tunnels (Connection.CallStream and iris.StreamRouter). Client and bidirectional
+// streaming methods are not supported. The messages themselves are generated by
+// protoc-gen-go, so both plugins need to be invoked:
+//
+//	protoc --go_out=. --go_opt=paths=source_relative \
+//	       --iris_out=. --iris_opt=paths=source_relative \
+//	       greeter.proto
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Import paths referenced by the generated code.
+const (
+	contextPackage = protogen.GoImportPath("context")
+	timePackage    = protogen.GoImportPath("time")
+	protoPackage   = protogen.GoImportPath("google.golang.org/protobuf/proto")
+	irisPackage    = "gopkg.in/project-iris/iris-go.v1"
+)
+
+func main() {
+	var flags flag.FlagSet
+	protogen.Options{ParamFunc: flags.Set}.Run(func(gen *protogen.Plugin) error {
+		gen.SupportedFeatures = uint64(pluginpb.CodeGeneratorResponse_FEATURE_PROTO3_OPTIONAL)
+		for _, file := range gen.Files {
+			if !file.Generate || len(file.Services) == 0 {
+				continue
+			}
+			if err := generateFile(gen, file); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Generates the Iris bindings of all the services within a .proto file.
+func generateFile(gen *protogen.Plugin, file *protogen.File) error {
+	// Reject the streaming kinds that have no Iris equivalent
+	for _, service := range file.Services {
+		for _, method := range service.Methods {
+			if method.Desc.IsStreamingClient() {
+				return fmt.Errorf("%s: client streaming method %s not supported", file.Desc.Path(), method.Desc.FullName())
+			}
+		}
+	}
+	g := gen.NewGeneratedFile(file.GeneratedFilenamePrefix+"_iris.pb.go", file.GoImportPath)
+	g.P("// Code generated by protoc-gen-iris. DO NOT EDIT.")
+	g.P("// source: ", file.Desc.Path())
+	g.P()
+	g.P("package ", file.GoPackageName)
+	g.P()
+	g.P("import iris ", fmt.Sprintf("%q", irisPackage))
+
+	for _, service := range file.Services {
+		generateService(g, service)
+	}
+	return nil
+}
+
+// Generates the method names, client stub and server interface of a service.
+func generateService(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+
+	// Generate the wire names of the methods, as routed by the service
+	g.P()
+	g.P("// Method names of the ", name, " service, as routed on the Iris network.")
+	g.P("const (")
+	for _, method := range service.Methods {
+		g.P(methodConst(method), " = ", fmt.Sprintf("%q", method.Desc.FullName()))
+	}
+	g.P(")")
+
+	generateClient(g, service)
+	generateServer(g, service)
+}
+
+// Generates the client interface and its implementation of a service.
+func generateClient(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+	impl := lowerFirst(name) + "Client"
+
+	g.P()
+	g.P("// Client API of the ", name, " service, calling the members of an Iris cluster.")
+	g.P("type ", name, "Client interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, clientSignature(g, method))
+	}
+	g.P("}")
+	g.P()
+	g.P("type ", impl, " struct {")
+	g.P("conn    *iris.Connection")
+	g.P("cluster string")
+	g.P("}")
+	g.P()
+	g.P("// Creates a client of the ", name, " service, calling the members of cluster")
+	g.P("// through conn.")
+	g.P("func New", name, "Client(conn *iris.Connection, cluster string) ", name, "Client {")
+	g.P("return &", impl, "{conn: conn, cluster: cluster}")
+	g.P("}")
+
+	for _, method := range service.Methods {
+		g.P()
+		g.P("func (c *", impl, ") ", clientSignature(g, method), " {")
+		g.P("request, err := ", g.QualifiedGoIdent(protoPackage.Ident("Marshal")), "(in)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		if !method.Desc.IsStreamingServer() {
+			g.P("reply, err := c.conn.CallCtx(ctx, c.cluster, ", methodConst(method), ", request)")
+			g.P("if err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("out := new(", g.QualifiedGoIdent(method.Output.GoIdent), ")")
+			g.P("if err := ", g.QualifiedGoIdent(protoPackage.Ident("Unmarshal")), "(reply, out); err != nil {")
+			g.P("return nil, err")
+			g.P("}")
+			g.P("return out, nil")
+			g.P("}")
+			continue
+		}
+		g.P("stream, err := c.conn.CallStream(c.cluster, ", methodConst(method), ", request, timeout)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return &", streamClient(method), "{stream: stream}, nil")
+		g.P("}")
+
+		// Generate the typed reply iterator of the streaming method
+		g.P()
+		g.P("// Client side of a ", method.GoName, " stream, iterating over the arriving replies.")
+		g.P("type ", streamClient(method), " struct {")
+		g.P("stream *iris.ReplyStream")
+		g.P("}")
+		g.P()
+		g.P("// Retrieves the next reply from the stream, or io.EOF after the last one.")
+		g.P("func (x *", streamClient(method), ") Recv() (*", g.QualifiedGoIdent(method.Output.GoIdent), ", error) {")
+		g.P("frame, err := x.stream.Next()")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("out := new(", g.QualifiedGoIdent(method.Output.GoIdent), ")")
+		g.P("if err := ", g.QualifiedGoIdent(protoPackage.Ident("Unmarshal")), "(frame, out); err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return out, nil")
+		g.P("}")
+		g.P()
+		g.P("// Closes the stream, cancelling the remote processing if still running.")
+		g.P("func (x *", streamClient(method), ") Close() error {")
+		g.P("return x.stream.Close()")
+		g.P("}")
+	}
+}
+
+// Generates the server interface of a service and the registration of its
+// implementations into the Iris routers.
+func generateServer(g *protogen.GeneratedFile, service *protogen.Service) {
+	name := service.GoName
+
+	g.P()
+	g.P("// Server API of the ", name, " service.")
+	g.P("type ", name, "Server interface {")
+	for _, method := range service.Methods {
+		g.P(method.Comments.Leading, serverSignature(g, method))
+	}
+	g.P("}")
+
+	// Generate the registration of the unary methods
+	g.P()
+	g.P("// Registers the unary methods of srv into router.")
+	g.P("func Register", name, "Routes(router *iris.Router, srv ", name, "Server) {")
+	for _, method := range service.Methods {
+		if method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("router.Handle(", methodConst(method), ", func(ctx ", g.QualifiedGoIdent(contextPackage.Ident("Context")), ", request []byte) ([]byte, error) {")
+		generateDecode(g, method)
+		g.P("out, err := srv.", method.GoName, "(ctx, in)")
+		g.P("if err != nil {")
+		g.P("return nil, err")
+		g.P("}")
+		g.P("return ", g.QualifiedGoIdent(protoPackage.Ident("Marshal")), "(out)")
+		g.P("})")
+	}
+	g.P("}")
+
+	// Generate the registration and reply writers of the streaming methods, if any
+	streaming := false
+	for _, method := range service.Methods {
+		streaming = streaming || method.Desc.IsStreamingServer()
+	}
+	if !streaming {
+		return
+	}
+	g.P()
+	g.P("// Registers the server streaming methods of srv into router.")
+	g.P("func Register", name, "Streams(router *iris.StreamRouter, srv ", name, "Server) {")
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P("router.Handle(", methodConst(method), ", func(request []byte, replies *iris.ReplyWriter) error {")
+		generateDecode(g, method)
+		g.P("return srv.", method.GoName, "(in, &", streamServer(method), "{replies: replies})")
+		g.P("})")
+	}
+	g.P("}")
+
+	for _, method := range service.Methods {
+		if !method.Desc.IsStreamingServer() {
+			continue
+		}
+		g.P()
+		g.P("// Server side of a ", method.GoName, " stream, through which the replies are sent.")
+		g.P("type ", streamServer(method), " struct {")
+		g.P("replies *iris.ReplyWriter")
+		g.P("}")
+		g.P()
+		g.P("// Sends a reply to the caller, blocking until the local Iris node receives it.")
+		g.P("func (x *", streamServer(method), ") Send(m *", g.QualifiedGoIdent(method.Output.GoIdent), ") error {")
+		g.P("frame, err := ", g.QualifiedGoIdent(protoPackage.Ident("Marshal")), "(m)")
+		g.P("if err != nil {")
+		g.P("return err")
+		g.P("}")
+		g.P("return x.replies.Send(frame)")
+		g.P("}")
+	}
+}
+
+// Generates the decoding of a method's request into the variable in, failing
+// malformed ones with a structured error.
+func generateDecode(g *protogen.GeneratedFile, method *protogen.Method) {
+	g.P("in := new(", g.QualifiedGoIdent(method.Input.GoIdent), ")")
+	g.P("if err := ", g.QualifiedGoIdent(protoPackage.Ident("Unmarshal")), "(request, in); err != nil {")
+	if method.Desc.IsStreamingServer() {
+		g.P("return &iris.Error{Code: iris.CodeInvalidArgument, Message: err.Error()}")
+	} else {
+		g.P("return nil, &iris.Error{Code: iris.CodeInvalidArgument, Message: err.Error()}")
+	}
+	g.P("}")
+}
+
+// Assembles the client side signature of a method.
+func clientSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+	if method.Desc.IsStreamingServer() {
+		timeout := g.QualifiedGoIdent(timePackage.Ident("Duration"))
+		return fmt.Sprintf("%s(in *%s, timeout %s) (*%s, error)", method.GoName, in, timeout, streamClient(method))
+	}
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+	return fmt.Sprintf("%s(ctx %s, in *%s) (*%s, error)", method.GoName, ctx, in, out)
+}
+
+// Assembles the server side signature of a method.
+func serverSignature(g *protogen.GeneratedFile, method *protogen.Method) string {
+	in := g.QualifiedGoIdent(method.Input.GoIdent)
+	if method.Desc.IsStreamingServer() {
+		return fmt.Sprintf("%s(in *%s, stream *%s) error", method.GoName, in, streamServer(method))
+	}
+	ctx := g.QualifiedGoIdent(contextPackage.Ident("Context"))
+	out := g.QualifiedGoIdent(method.Output.GoIdent)
+	return fmt.Sprintf("%s(ctx %s, in *%s) (*%s, error)", method.GoName, ctx, in, out)
+}
+
+// Names the constant holding the wire name of a method.
+func methodConst(method *protogen.Method) string {
+	return method.Parent.GoName + "_" + method.GoName + "_Method"
+}
+
+// Names the client side stream type of a server streaming method.
+func streamClient(method *protogen.Method) string {
+	return method.Parent.GoName + "_" + method.GoName + "Client"
+}
+
+// Names the server side stream type of a server streaming method.
+func streamServer(method *protogen.Method) string {
+	return method.Parent.GoName + "_" + method.GoName + "Server"
+}
+
+// Lowercases the first letter of an identifier.
+func lowerFirst(name string) string {
+	if len(name) == 0 {
+		return name
+	}
+	return string(name[0]|0x20) + name[1:]
+}
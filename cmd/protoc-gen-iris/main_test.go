@@ -0,0 +1,120 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+
+	"google.golang.org/protobuf/compiler/protogen"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/pluginpb"
+)
+
+// Assembles a plugin request for a greeter service, optionally making its
+// streaming method client streaming too.
+func newTestRequest(clientStreaming bool) *pluginpb.CodeGeneratorRequest {
+	message := func(name string) *descriptorpb.DescriptorProto {
+		return &descriptorpb.DescriptorProto{
+			Name: proto.String(name),
+			Field: []*descriptorpb.FieldDescriptorProto{{
+				Name:     proto.String("name"),
+				Number:   proto.Int32(1),
+				Type:     descriptorpb.FieldDescriptorProto_TYPE_STRING.Enum(),
+				Label:    descriptorpb.FieldDescriptorProto_LABEL_OPTIONAL.Enum(),
+				JsonName: proto.String("name"),
+			}},
+		}
+	}
+	file := &descriptorpb.FileDescriptorProto{
+		Name:        proto.String("greeter.proto"),
+		Package:     proto.String("greeter"),
+		Syntax:      proto.String("proto3"),
+		Options:     &descriptorpb.FileOptions{GoPackage: proto.String("example.com/greeter")},
+		MessageType: []*descriptorpb.DescriptorProto{message("HelloRequest"), message("HelloReply")},
+		Service: []*descriptorpb.ServiceDescriptorProto{{
+			Name: proto.String("Greeter"),
+			Method: []*descriptorpb.MethodDescriptorProto{
+				{
+					Name:       proto.String("SayHello"),
+					InputType:  proto.String(".greeter.HelloRequest"),
+					OutputType: proto.String(".greeter.HelloReply"),
+				},
+				{
+					Name:            proto.String("StreamHellos"),
+					InputType:       proto.String(".greeter.HelloRequest"),
+					OutputType:      proto.String(".greeter.HelloReply"),
+					ServerStreaming: proto.Bool(true),
+					ClientStreaming: proto.Bool(clientStreaming),
+				},
+			},
+		}},
+	}
+	return &pluginpb.CodeGeneratorRequest{
+		FileToGenerate: []string{"greeter.proto"},
+		ProtoFile:      []*descriptorpb.FileDescriptorProto{file},
+	}
+}
+
+// Runs the generator on a plugin request.
+func runTestGenerator(t *testing.T, req *pluginpb.CodeGeneratorRequest) (*pluginpb.CodeGeneratorResponse, error) {
+	gen, err := protogen.Options{}.New(req)
+	if err != nil {
+		t.Fatalf("failed to create plugin: %v.", err)
+	}
+	for _, file := range gen.Files {
+		if file.Generate {
+			if err := generateFile(gen, file); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return gen.Response(), nil
+}
+
+// Tests that the generated bindings are valid Go, exposing the expected API.
+func TestGenerate(t *testing.T) {
+	res, err := runTestGenerator(t, newTestRequest(false))
+	if err != nil {
+		t.Fatalf("generation failed: %v.", err)
+	}
+	if res.Error != nil {
+		t.Fatalf("generation reported failure: %s.", res.GetError())
+	}
+	if len(res.File) != 1 || res.File[0].GetName() != "example.com/greeter/greeter_iris.pb.go" {
+		t.Fatalf("generated files mismatch: have %v.", res.File)
+	}
+	source := res.File[0].GetContent()
+	if _, err := parser.ParseFile(token.NewFileSet(), "greeter_iris.pb.go", source, 0); err != nil {
+		t.Fatalf("generated code invalid: %v\n%s", err, source)
+	}
+	for _, want := range []string{
+		`Greeter_SayHello_Method     = "greeter.Greeter.SayHello"`,
+		`type GreeterClient interface`,
+		`SayHello(ctx context.Context, in *HelloRequest) (*HelloReply, error)`,
+		`StreamHellos(in *HelloRequest, timeout time.Duration) (*Greeter_StreamHellosClient, error)`,
+		`func NewGreeterClient(conn *iris.Connection, cluster string) GreeterClient`,
+		`type GreeterServer interface`,
+		`StreamHellos(in *HelloRequest, stream *Greeter_StreamHellosServer) error`,
+		`func RegisterGreeterRoutes(router *iris.Router, srv GreeterServer)`,
+		`func RegisterGreeterStreams(router *iris.StreamRouter, srv GreeterServer)`,
+	} {
+		if !strings.Contains(source, want) {
+			t.Errorf("generated code missing %q.", want)
+		}
+	}
+}
+
+// Tests that client streaming methods are rejected.
+func TestGenerateClientStreaming(t *testing.T) {
+	if _, err := runTestGenerator(t, newTestRequest(true)); err == nil {
+		t.Fatalf("client streaming method accepted.")
+	}
+}
@@ -0,0 +1,262 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the negotiated, transparent compression of tunnel messages.
+//
+// Since tunnel construction cannot carry custom fields, the negotiation runs in
+// band right after it: the initiator offers its preferred algorithms through a
+// control message and waits for the acceptor to pick one. Afterwards every data
+// message is prefixed with a flag byte marking whether it is compressed, so that
+// small or incompressible messages can be sent as is.
+
+package iris
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Built in compression algorithm names.
+const (
+	CompressGzip   = "gzip"
+	CompressSnappy = "snappy" // Registered by the iriscompress package
+	CompressZstd   = "zstd"   // Registered by the iriscompress package
+)
+
+// Message compression algorithm usable by tunnels.
+type Compressor interface {
+	Compress(data []byte) ([]byte, error)
+	Decompress(data []byte) ([]byte, error)
+}
+
+// Messages shorter than this are never compressed.
+const compressThreshold = 256
+
+// Message flags marking whether the payload is compressed.
+const (
+	compressRaw  byte = 0x00
+	compressUsed byte = 0x01
+)
+
+// Control message kinds of the compression negotiation, keepalive, encryption
+// switch and peer identity.
+const (
+	tunnelOffer  byte = 0x00
+	tunnelAnswer byte = 0x01
+	tunnelPing   byte = 0x02
+	tunnelPong   byte = 0x03
+	tunnelCipher byte = 0x04
+	tunnelPeer   byte = 0x05
+)
+
+// Prefix identifying an in band tunnel control message.
+var tunnelCtlMagic = []byte("\x00iris-tunctl\x00")
+
+var (
+	compressors     = map[string]Compressor{CompressGzip: gzipCompressor{}}
+	compressorsLock sync.RWMutex
+)
+
+// Registers a compression algorithm under the given name, making it available
+// for tunnel negotiation. Registering an existing name replaces it.
+func RegisterCompressor(name string, comp Compressor) {
+	compressorsLock.Lock()
+	defer compressorsLock.Unlock()
+
+	compressors[name] = comp
+}
+
+// Looks up a registered compression algorithm.
+func lookupCompressor(name string) Compressor {
+	compressorsLock.RLock()
+	defer compressorsLock.RUnlock()
+
+	return compressors[name]
+}
+
+type gzipCompressor struct{}
+
+func (gzipCompressor) Compress(data []byte) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	writer := gzip.NewWriter(buf)
+	if _, err := writer.Write(data); err != nil {
+		return nil, err
+	}
+	if err := writer.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(data []byte) ([]byte, error) {
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	return io.ReadAll(reader)
+}
+
+// Assembles a tunnel control message.
+func wrapTunnelCtl(kind byte, payload string) []byte {
+	return append(append(append([]byte{}, tunnelCtlMagic...), kind), payload...)
+}
+
+// Splits a tunnel control message into its kind and payload, if it is one.
+func unwrapTunnelCtl(message []byte) (byte, string, bool) {
+	if len(message) <= len(tunnelCtlMagic) || !bytes.HasPrefix(message, tunnelCtlMagic) {
+		return 0, "", false
+	}
+	return message[len(tunnelCtlMagic)], string(message[len(tunnelCtlMagic)+1:]), true
+}
+
+// Offers the configured compression algorithms to the remote endpoint of a
+// freshly built outbound tunnel and waits for its choice.
+func (t *Tunnel) negotiate(deadline <-chan time.Time) error {
+	if len(t.limits.Compression) == 0 {
+		return nil
+	}
+	offer := wrapTunnelCtl(tunnelOffer, strings.Join(t.limits.Compression, ","))
+	if err := t.send(context.Background(), offer, deadline); err != nil {
+		return err
+	}
+	select {
+	case name := <-t.answer:
+		if name != "" {
+			t.compress = lookupCompressor(name)
+		}
+		t.Log.Info("tunnel compression negotiated", "algorithm", name)
+		return nil
+	case <-t.term:
+		return ErrClosed
+	case <-deadline:
+		return ErrTunnelBuildTimeout
+	}
+}
+
+// Handles a tunnel control message, returning false if the message is not one.
+// The inbound lock is assumed to be held.
+func (t *Tunnel) handleControl(message []byte) bool {
+	kind, payload, ok := unwrapTunnelCtl(message)
+	if !ok {
+		return false
+	}
+	switch kind {
+	case tunnelOffer:
+		// Pick the first offered algorithm that is registered and allowed locally
+		var choice string
+		for _, name := range strings.Split(payload, ",") {
+			if lookupCompressor(name) == nil {
+				continue
+			}
+			if len(t.limits.Compression) > 0 && !containsString(t.limits.Compression, name) {
+				continue
+			}
+			choice = name
+			break
+		}
+		if choice != "" {
+			t.decompress = lookupCompressor(choice)
+		}
+		t.Log.Info("tunnel compression accepted", "offer", payload, "algorithm", choice)
+
+		// Answer and switch the outbound side atomically with respect to sends
+		go func() {
+			t.sendLock.Lock()
+			defer t.sendLock.Unlock()
+
+			if err := t.sendLocked(context.Background(), wrapTunnelCtl(tunnelAnswer, choice), nil); err != nil {
+				t.Log.Warn("failed to answer compression offer", "reason", err)
+				return
+			}
+			if choice != "" {
+				// High priority frames compress outside of the send lock
+				t.sendGate.acquire(PriorityHigh)
+				t.compress = lookupCompressor(choice)
+				t.sendGate.release()
+			}
+		}()
+
+	case tunnelAnswer:
+		if payload != "" {
+			t.decompress = lookupCompressor(payload)
+		}
+		select {
+		case t.answer <- payload:
+		default:
+			t.Log.Warn("unexpected compression answer", "algorithm", payload)
+		}
+
+	case tunnelPing:
+		go t.answerKeepalive()
+
+	case tunnelPong:
+		// Arrival already counted as peer activity, nothing else to do
+
+	case tunnelCipher:
+		t.Log.Info("tunnel remote side switched to encryption")
+		t.sealedIn = true
+
+	case tunnelPeer:
+		t.handlePeer(payload)
+
+	default:
+		t.Log.Warn("unknown tunnel control message", "kind", kind)
+	}
+	return true
+}
+
+// Compresses an outbound message if compression was negotiated, prefixing it
+// with the compression flag.
+func (t *Tunnel) compressMessage(message []byte) ([]byte, error) {
+	if t.compress == nil {
+		return message, nil
+	}
+	if len(message) >= compressThreshold {
+		packed, err := t.compress.Compress(message)
+		if err != nil {
+			return nil, err
+		}
+		if len(packed) < len(message) {
+			return append([]byte{compressUsed}, packed...), nil
+		}
+	}
+	return append([]byte{compressRaw}, message...), nil
+}
+
+// Strips the compression flag off an inbound message, decompressing it with the
+// negotiated algorithm if needed (nil if compression is disabled). Empty messages
+// carry no flag and are returned as is.
+func decompressMessage(decompress Compressor, message []byte) ([]byte, error) {
+	if decompress == nil || len(message) == 0 {
+		return message, nil
+	}
+	switch message[0] {
+	case compressRaw:
+		return message[1:], nil
+	case compressUsed:
+		return decompress.Decompress(message[1:])
+	default:
+		return nil, fmt.Errorf("%w: invalid compression flag %#x", ErrProtocolViolation, message[0])
+	}
+}
+
+// Checks whether a string slice contains a particular value.
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,211 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the structured configuration of connections and services, assembled
+// from the functional options accepted by Connect and Register, so that new
+// knobs can be added without breaking the constructor signatures.
+
+package iris
+
+import (
+	"crypto/tls"
+)
+
+// Structured configuration of a connection (or the connection of a service),
+// applied before any traffic flows on it. Any nil fields are left at their
+// defaults, equivalent to not calling the corresponding setter afterwards.
+type Config struct {
+	Logger  Logger          // Logger to derive the connection's one from (package level Log if nil)
+	TLS     *tls.Config     // TLS config to encrypt the TCP relay link with (plain TCP if nil)
+	Connect *ConnectOptions // Timeouts and retries of the relay link setup (see ConnectWithOptions)
+
+	Tunnel    *TunnelConfig    // Config of the tunnels (see SetTunnelConfig)
+	Retry     *RetryPolicy     // Retrying of idempotent requests (see EnableRetry)
+	Reconnect *ReconnectPolicy // Reconnection to the relay (see EnableReconnect)
+	Heartbeat *HeartbeatPolicy // Heartbeats on the relay link (see EnableHeartbeat)
+	Tracer    Tracer           // Tracer of the messaging operations (see SetTracer)
+
+	Codec PayloadCodec // Transformation of every payload crossing the connection (nil disables)
+
+	Resubscribe *ResubscribePolicy // Retrying of failed topic subscriptions (see EnableResubscribe)
+	Audit       AuditSink          // Audit log of the inbound requests and tunnels (see SetAuditSink)
+	Frames      FrameDump          // Dump of the relay protocol frames, handshake included (see SetFrameDump)
+}
+
+// Functional option tweaking the configuration of a connection or service.
+type Option func(conf *Config)
+
+// Replaces the whole configuration assembled so far with a structured one, e.g.
+// loaded from a file. Options following it may still tweak it.
+func WithConfig(config *Config) Option {
+	return func(conf *Config) {
+		if config != nil {
+			*conf = *config
+		}
+	}
+}
+
+// Sets the logger to derive the connection's own one from, instead of the
+// package level Log.
+func WithLogger(logger Logger) Option {
+	return func(conf *Config) { conf.Logger = logger }
+}
+
+// Encrypts the TCP link to the relay with TLS, for relays listening on TLS. If
+// tlsConf doesn't specify the server name, it is set to localhost.
+func WithTLS(tlsConf *tls.Config) Option {
+	return func(conf *Config) { conf.TLS = tlsConf }
+}
+
+// Bounds the relay link setup and operations in time and retries the failed
+// initial attempts, as set by the options. See ConnectWithOptions.
+func WithConnectOptions(opts *ConnectOptions) Option {
+	return func(conf *Config) { conf.Connect = finalizeConnectOptions(opts) }
+}
+
+// Sets the buffer and chunking limits of the tunnels. See SetTunnelConfig.
+func WithTunnelConfig(config *TunnelConfig) Option {
+	return func(conf *Config) { conf.Tunnel = config }
+}
+
+// Sets the memory allowance for the pending inbound messages of the tunnels,
+// leaving the rest of the tunnel config as set by earlier options.
+func WithTunnelBuffer(size int) Option {
+	return func(conf *Config) {
+		config := new(TunnelConfig)
+		if conf.Tunnel != nil {
+			*config = *conf.Tunnel
+		}
+		config.BufferSize = size
+		conf.Tunnel = config
+	}
+}
+
+// Enables the automatic retrying of idempotent requests, with the default
+// policy if nil. See EnableRetry.
+func WithRetry(policy *RetryPolicy) Option {
+	return func(conf *Config) { conf.Retry = finalizeRetryPolicy(policy) }
+}
+
+// Enables the automatic reconnection to the relay, with the default policy if
+// nil. See EnableReconnect.
+func WithReconnect(policy *ReconnectPolicy) Option {
+	return func(conf *Config) { conf.Reconnect = finalizeReconnectPolicy(policy) }
+}
+
+// Enables the background retrying of failed topic subscriptions, with the
+// default policy if nil. See EnableResubscribe.
+func WithResubscribe(policy *ResubscribePolicy) Option {
+	return func(conf *Config) { conf.Resubscribe = finalizeResubscribePolicy(policy) }
+}
+
+// Enables the periodic heartbeats on the relay link, with the default policy if
+// nil. See EnableHeartbeat.
+func WithHeartbeat(policy *HeartbeatPolicy) Option {
+	return func(conf *Config) { conf.Heartbeat = finalizeHeartbeatPolicy(policy) }
+}
+
+// Sets the tracer to invoke around all messaging operations. See SetTracer.
+func WithTracer(tracer Tracer) Option {
+	return func(conf *Config) { conf.Tracer = tracer }
+}
+
+// Sets the sink to record the inbound requests and tunnels into. See
+// SetAuditSink.
+func WithAudit(sink AuditSink) Option {
+	return func(conf *Config) { conf.Audit = sink }
+}
+
+// Sets the callback to dump the relay protocol frames into, starting with the
+// initialization handshake. See SetFrameDump.
+func WithFrameDump(dump FrameDump) Option {
+	return func(conf *Config) { conf.Frames = dump }
+}
+
+// Sets the codec to transform every payload crossing the connection with. It
+// cannot be changed after the connection is established.
+func WithPayloadCodec(codec PayloadCodec) Option {
+	return func(conf *Config) { conf.Codec = codec }
+}
+
+// Assembles the configuration requested by the functional options, or nil if
+// there are none.
+func newConfig(opts []Option) *Config {
+	if len(opts) == 0 {
+		return nil
+	}
+	conf := new(Config)
+	for _, opt := range opts {
+		opt(conf)
+	}
+	return conf
+}
+
+// Retrieves the logger to derive the connection's one from.
+func (c *Config) logger() Logger {
+	if c == nil || c.Logger == nil {
+		return Log
+	}
+	return c.Logger
+}
+
+// Retrieves the options of the relay link setup, nil for the defaults. Options
+// of a structured config are merged with the defaults here, as they bypass the
+// merging done by WithConnectOptions.
+func (c *Config) connectOptions() *ConnectOptions {
+	if c == nil || c.Connect == nil {
+		return nil
+	}
+	return finalizeConnectOptions(c.Connect)
+}
+
+// Retrieves the dump of the relay protocol frames, nil if disabled.
+func (c *Config) frameDump() FrameDump {
+	if c == nil {
+		return nil
+	}
+	return c.Frames
+}
+
+// Retrieves the TLS config of the relay link, nil for plain TCP.
+func (c *Config) tlsConfig() *tls.Config {
+	if c == nil {
+		return nil
+	}
+	return c.TLS
+}
+
+// Applies the non-default settings of the configuration to a fresh connection.
+func (c *Connection) applyConfig(conf *Config) {
+	if conf == nil {
+		return
+	}
+	if conf.Tunnel != nil {
+		c.SetTunnelConfig(conf.Tunnel)
+	}
+	if conf.Retry != nil {
+		c.EnableRetry(conf.Retry)
+	}
+	if conf.Reconnect != nil {
+		c.EnableReconnect(conf.Reconnect)
+	}
+	if conf.Heartbeat != nil {
+		c.EnableHeartbeat(conf.Heartbeat)
+	}
+	if conf.Resubscribe != nil {
+		c.EnableResubscribe(conf.Resubscribe)
+	}
+	if conf.Tracer != nil {
+		c.SetTracer(conf.Tracer)
+	}
+	if conf.Audit != nil {
+		c.SetAuditSink(conf.Audit)
+	}
+	if conf.Frames != nil {
+		c.SetFrameDump(conf.Frames)
+	}
+	c.codec = conf.Codec
+}
@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the backpressure aware publishing, letting producers shed load when
+// the relay link cannot keep up.
+
+package iris
+
+import (
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Returned if an operation is rejected due to the relay link being congested.
+var ErrCongested = errors.New("connection congested")
+
+// Default number of pending outbound packets above which the link is deemed
+// congested.
+const defaultCongestionLimit = 64
+
+// Interval between checking whether a congested link freed up.
+var congestionCheckInterval = time.Millisecond
+
+// Sets the number of outbound packets waiting for the relay link above which
+// the link is deemed congested by the backpressure aware operations. Values
+// below one reset the limit to the default.
+func (c *Connection) SetCongestionLimit(pending int) {
+	if pending < 1 {
+		pending = defaultCongestionLimit
+	}
+	atomic.StoreInt32(&c.sockCong, int32(pending))
+}
+
+// Checks whether the relay link is congested.
+func (c *Connection) congested() bool {
+	limit := atomic.LoadInt32(&c.sockCong)
+	if limit == 0 {
+		limit = defaultCongestionLimit
+	}
+	return atomic.LoadInt32(&c.sockWait) >= limit
+}
+
+// Publishes an event to topic, unless the relay link is congested, in which
+// case ErrCongested is returned without blocking. See Publish for the details.
+func (c *Connection) TryPublish(topic string, event []byte) error {
+	if c.congested() {
+		return ErrCongested
+	}
+	return c.Publish(topic, event)
+}
+
+// Publishes an event to topic, waiting at most timeout for a congested relay
+// link to free up, returning ErrTimeout otherwise. See Publish for the details.
+func (c *Connection) PublishTimeout(topic string, event []byte, timeout time.Duration) error {
+	if c.congested() {
+		expire := time.After(timeout)
+		ticker := time.NewTicker(congestionCheckInterval)
+		defer ticker.Stop()
+
+		for c.congested() {
+			select {
+			case <-c.term:
+				return ErrClosed
+			case <-expire:
+				return ErrTimeout
+			case <-ticker.C:
+			}
+		}
+	}
+	return c.Publish(topic, event)
+}
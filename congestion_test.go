@@ -0,0 +1,78 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that backpressure aware publishes reject or wait out congestion.
+func TestPublishCongestion(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		limit int
+		stall time.Duration
+	}{4, 50 * time.Millisecond}
+
+	// Subscribe to a topic through a separate connection
+	sub, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer sub.Close()
+
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, 4)}
+	if err := sub.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer sub.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+	conn.SetCongestionLimit(conf.limit)
+
+	// Simulate a congested link and verify that publishes are rejected
+	atomic.AddInt32(&conn.sockWait, int32(conf.limit))
+	if err := conn.TryPublish(config.topic, []byte{0x00}); err != ErrCongested {
+		t.Fatalf("congested publish mismatch: have %v, want %v.", err, ErrCongested)
+	}
+	if err := conn.PublishTimeout(config.topic, []byte{0x00}, conf.stall); err != ErrTimeout {
+		t.Fatalf("congested timed publish mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Free up the link meanwhile and verify that waiting publishes go through
+	time.AfterFunc(conf.stall, func() { atomic.AddInt32(&conn.sockWait, -int32(conf.limit)) })
+
+	start := time.Now()
+	if err := conn.PublishTimeout(config.topic, []byte{0x01}, time.Second); err != nil {
+		t.Fatalf("timed publish failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed < conf.stall {
+		t.Fatalf("timed publish didn't wait: took %v, want at least %v.", elapsed, conf.stall)
+	}
+	if err := conn.TryPublish(config.topic, []byte{0x02}); err != nil {
+		t.Fatalf("uncongested publish failed: %v.", err)
+	}
+	// Verify the deliveries (events are dispatched concurrently, so unordered)
+	seen := make(map[byte]bool)
+	for i := 0; i < 2; i++ {
+		select {
+		case event := <-handler.delivers:
+			seen[event[0]] = true
+		case <-time.After(time.Second):
+			t.Fatalf("event #%d not delivered.", i)
+		}
+	}
+	if !seen[0x01] || !seen[0x02] {
+		t.Fatalf("delivered events mismatch: have %v, want %v and %v.", seen, 0x01, 0x02)
+	}
+}
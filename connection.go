@@ -8,15 +8,12 @@ package iris
 
 import (
 	"bufio"
-	"errors"
+	"context"
+	"crypto/tls"
 	"fmt"
-	"net"
 	"sync"
 	"sync/atomic"
 	"time"
-
-	"github.com/project-iris/iris/pool"
-	"gopkg.in/inconshreveable/log15.v2"
 )
 
 // Client connection to the Iris network.
@@ -27,49 +24,205 @@ type Connection struct {
 	reqIdx  uint64                 // Index to assign the next request
 	reqReps map[uint64]chan []byte // Reply channels for active requests
 	reqErrs map[uint64]chan error  // Error channels for active requests
+	reqFuts map[uint64]*Future     // Result futures for active async requests
 	reqLock sync.RWMutex           // Mutex to protect the result channel maps
 
-	subIdx  uint64            // Index to assign the next subscription (logging purposes)
-	subLive map[string]*topic // Active subscriptions
-	subLock sync.RWMutex      // Mutex to protect the subscription map
+	subIdx     uint64                // Index to assign the next subscription (logging purposes)
+	subLive    map[string]*topic     // Active subscriptions (including patterns)
+	patLive    map[string]*topicTree // Pattern subscriptions grouped by fan-in topic
+	patPublish bool                  // Whether to forward publishes to the fan-in topics
+	envPublish bool                  // Whether to wrap published events into envelopes
+	envId      string                // Publisher id embedded into envelopes (and identities)
+	envSeq     uint64                // Sequence number of the last enveloped event
+	subLock    sync.RWMutex          // Mutex to protect the subscription maps and modes
+
+	subSync     sync.Mutex         // Serializes the subscription replays, retries and removals
+	resubPolicy *ResubscribePolicy // Retrying of the failed subscriptions, nil if disabled
+	resubBusy   bool               // Flag whether the reconciler is running (subscription lock)
+
+	retained   map[string]*retainedEvent // Events retained as the last values of topics
+	retainLock sync.Mutex                // Mutex to protect the retained events
+
+	annStop     chan struct{} // Channel stopping the membership announcements, nil if not announcing
+	annInterval time.Duration // Interval between the membership announcements
+	annLock     sync.Mutex    // Mutex to protect the announcement state
+
+	advertise int32 // Flag whether to advertise the identity on requests and tunnels
+	correlate int32 // Flag whether to generate correlation identifiers for requests
+	noProbe   int32 // Flag whether to pass health probes to the request handler
+
+	schedIdx  uint64                       // Index to assign the next scheduled publish
+	schedLive map[uint64]*ScheduledPublish // Delayed publishes pending delivery
+	schedLock sync.Mutex                   // Mutex to protect the scheduled publishes
+
+	outbox  *outbox    // Durable queue of the sends issued while the link is down, nil if disabled
+	boxLock sync.Mutex // Mutex to protect the outbox
 
 	tunIdx  uint64             // Index to assign the next tunnel
 	tunLive map[uint64]*Tunnel // Active tunnels
-	tunLock sync.RWMutex       // Mutex to protect the tunnel map
+	tunConf *TunnelConfig      // Limits of tunnels without explicit configs
+	tunLock sync.RWMutex       // Mutex to protect the tunnel map and config
+
+	tunListen *tunnelListener // Listener taking over the inbound tunnels, nil if unset
+
+	grantPend map[uint64]*pendingGrant // Allowance grants queued for the background sender
+	grantSign chan struct{}            // Signals queued grants, sent after the coalescing window
+	grantUrge chan struct{}            // Signals urgent grants, sent right away
+	grantLock sync.Mutex               // Mutex to protect the queued grants
 
 	// Quality of service fields
 	limits *ServiceLimits // Limits on the inbound message processing
 
-	bcastIdx  uint64           // Index to assign the next inbound broadcast (logging purposes)
-	bcastPool *pool.ThreadPool // Queue and concurrency limiter for the broadcast handlers
-	bcastUsed int32            // Actual memory usage of the broadcast queue
+	bcastIdx  uint64       // Index to assign the next inbound broadcast (logging purposes)
+	bcastPool *handlerPool // Queue and concurrency limiter for the broadcast handlers
+	bcastUsed int32        // Actual memory usage of the broadcast queue
+
+	bcastOrder keyedDispatcher // Serializer of the broadcasts sharing an ordering key
+
+	batchMsgs  [][]byte    // Broadcasts collected for the next batched delivery
+	batchUsed  int         // Memory usage of the collected broadcasts
+	batchTimer *time.Timer // Timer delivering the collected broadcasts when the window elapses
+	batchLock  sync.Mutex  // Mutex to protect the broadcast batch
+
+	reqPool *handlerPool // Queue and concurrency limiter for the request handlers
+	reqUsed int32        // Actual memory usage of the request queue
+
+	dedup     *dedupCache // Reply cache of the recent requests, nil if deduplication is disabled
+	dedupLock sync.Mutex  // Mutex to protect the deduplication cache
 
-	reqPool *pool.ThreadPool // Queue and concurrency limiter for the request handlers
-	reqUsed int32            // Actual memory usage of the request queue
+	reqCache  *requestCache // Reply cache of the outbound idempotent requests, nil if disabled
+	cacheLock sync.Mutex    // Mutex to protect the request cache
+
+	// Resilience fields
+	recoPolicy  *ReconnectPolicy // Automatic reconnection policy, nil if disabled
+	recoLock    sync.Mutex       // Mutex to protect the reconnection policy
+	retryPolicy *RetryPolicy     // Automatic request retry policy, nil if disabled
+	retryLock   sync.Mutex       // Mutex to protect the retry policy
+	hedgePolicy *HedgePolicy     // Idempotent request hedging policy, nil if disabled
+	hedgeLock   sync.Mutex       // Mutex to protect the hedging policy
+	rateLimits  *rateLimiters    // Outbound operation rate limiters, nil if disabled
+	rateLock    sync.Mutex       // Mutex to protect the rate limiters
+	closing     int32            // Flag signalling a requested tear-down (no reconnects)
+	draining    int32            // Flag signalling a service drain (no new inbound work)
+
+	health     int32                      // Health state of the relay link (HealthState)
+	healthFunc func(old, new HealthState) // Callback notified of health changes
+	healthLock sync.Mutex                 // Mutex to protect the health callback and heartbeats
+	beatStop   chan struct{}              // Channel to stop the heartbeats, nil if disabled
+
+	closeFuncs []func(reason error) // Callbacks notified of the connection tear-down
+	closeDone  bool                 // Flag whether the connection was torn down
+	closeErr   error                // Reason of the tear-down, nil if graceful
+	closeLock  sync.Mutex           // Mutex to protect the close callbacks
+
+	// Instrumentation fields
+	stats     *metrics      // Live operational counters of the connection
+	tracer    Tracer        // Span creation hooks, nil if tracing is disabled
+	traceLock sync.RWMutex  // Mutex to protect the tracer
+	icptOut   []Interceptor // Interceptor chain of the outbound operations
+	icptIn    []Interceptor // Interceptor chain of the inbound handler dispatch
+	icptLock  sync.RWMutex  // Mutex to protect the interceptor and middleware chains and recovery hook
+	panicHook RecoveryHook  // Hook translating recovered handler panics, nil if unset
+
+	reqMware []RequestMiddleware // Middleware chain wrapping the request handler
+	codec    PayloadCodec        // Transformation of the payloads on the wire, nil if disabled (immutable)
+
+	msgLimits *MessageLimits // Size limits and validator of the inbound messages, nil if disabled
+	msgLock   sync.RWMutex   // Mutex to protect the inbound message limits
+
+	hooks    *Hooks       // Lifecycle callbacks of the operations, nil if disabled
+	hookLock sync.RWMutex // Mutex to protect the lifecycle hooks
+
+	audit     AuditSink    // Sink of the inbound request and tunnel audit log, nil if disabled
+	auditLock sync.RWMutex // Mutex to protect the audit sink
+
+	frameDump FrameDump    // Callback dumping the relay protocol frames, nil if disabled
+	frameLock sync.RWMutex // Mutex to protect the frame dump callback
+	frameOut  *Frame       // Outbound frame being recorded (socket lock)
+	frameIn   *Frame       // Inbound frame being recorded (network receiver)
+
+	slowLimit int64 // Request handler runtime triggering the watchdog (atomic, zero if disabled)
 
 	// Network layer fields
-	sock     net.Conn          // Network connection to the iris node
+	relay    RelayTransport    // Transport to (re)dial the local relay through
+	cluster  string            // Cluster to (re)register as, empty for clients
+	sock     RelayLink         // Network connection to the iris node
 	sockBuf  *bufio.ReadWriter // Buffered access to the network socket
 	sockLock sync.Mutex        // Mutex to atomize message sending
 	sockWait int32             // Counter for the pending writes (batch before flush)
+	sockCong int32             // Pending writes deemed congestion (zero for the default)
+
+	relayVer   string        // Protocol version advertised by the relay
+	relayFeats RelayFeatures // Optional capabilities of the relay
+
+	flushPolicy *FlushPolicy // Send batching policy, nil if flushing when idle
+	flushOut    *batchWriter // Link writer beneath the batching buffer, nil if disabled
+	flushArmed  bool         // Whether a delayed batch flush is scheduled
+
+	faults faultInjector // Fault injection of the inbound messages (irisfaults builds only)
 
 	// Bookkeeping fields
 	init chan struct{}   // Init channel to receive a success signal
 	quit chan chan error // Quit channel to synchronize receiver termination
 	term chan struct{}   // Channel to signal termination to blocked go-routines
+	stop chan struct{}   // Channel to signal a requested tear-down to the reconnection
+
+	started time.Time // Time instance the connection was established at
 
-	Log log15.Logger // Logger with connection id injected
+	Log Logger // Logger with connection id injected
 }
 
 // Id to assign to the next connection (used for logging purposes).
 var nextConnId uint64
 
-// Connects to the Iris network as a simple client.
-func Connect(port int) (*Connection, error) {
-	logger := Log.New("client", atomic.AddUint64(&nextConnId, 1))
-	logger.Info("connecting new client", "relay_port", port)
+// Connects to the Iris network as a simple client, configured by the optional
+// functional options (see Config).
+func Connect(port int, opts ...Option) (*Connection, error) {
+	return ConnectCtx(context.Background(), port, opts...)
+}
+
+// Connects to the Iris network as a simple client, aborting the connection setup
+// if the context is cancelled or its deadline expires before completion.
+func ConnectCtx(ctx context.Context, port int, opts ...Option) (*Connection, error) {
+	conf := newConfig(opts)
+	return connect(ctx, NewTCPTransport(port, conf.tlsConfig()), conf)
+}
+
+// Connects to the Iris network as a simple client over a TLS encrypted link,
+// for relays listening on TLS. It is a shorthand for Connect with the WithTLS
+// option, requiring a non-nil config.
+func ConnectTLS(port int, tlsConf *tls.Config) (*Connection, error) {
+	if tlsConf == nil {
+		return nil, invalidArgument("nil TLS config")
+	}
+	return Connect(port, WithTLS(tlsConf))
+}
+
+// Connects to the Iris network as a simple client through the relay's unix
+// domain socket at path, avoiding the TCP stack on co-located deployments and
+// allowing access control via filesystem permissions. It is a shorthand for
+// ConnectTransport with a unix transport.
+func ConnectUnix(path string) (*Connection, error) {
+	return ConnectTransport(NewUnixTransport(path))
+}
+
+// Connects to the Iris network as a simple client, reaching the relay through a
+// custom transport. The TLS option is ignored, the transport being in charge of
+// the link.
+func ConnectTransport(transport RelayTransport, opts ...Option) (*Connection, error) {
+	if transport == nil {
+		return nil, invalidArgument("nil relay transport")
+	}
+	return connect(context.Background(), transport, newConfig(opts))
+}
 
-	conn, err := newConnection(port, "", nil, nil, logger)
+// Connects to the Iris network as a simple client through the given transport,
+// honoring the configuration if any.
+func connect(ctx context.Context, relay RelayTransport, conf *Config) (*Connection, error) {
+	logger := conf.logger().New("client", atomic.AddUint64(&nextConnId, 1))
+	logger.Info("connecting new client", "relay", relay)
+
+	conn, err := newConnection(ctx, relay, "", nil, nil, conf, logger)
 	if err != nil {
 		logger.Warn("failed to connect new client", "reason", err)
 	} else {
@@ -78,14 +231,12 @@ func Connect(port int) (*Connection, error) {
 	return conn, err
 }
 
-// Connects to a local relay endpoint on port and registers as cluster.
-func newConnection(port int, cluster string, handler ServiceHandler, limits *ServiceLimits, logger log15.Logger) (*Connection, error) {
-	// Connect to the iris relay node
-	addr, err := net.ResolveTCPAddr("tcp", fmt.Sprintf("localhost:%d", port))
-	if err != nil {
-		return nil, err
-	}
-	sock, err := net.DialTCP("tcp", nil, addr)
+// Connects to a local relay endpoint and registers as cluster.
+func newConnection(ctx context.Context, relay RelayTransport, cluster string, handler ServiceHandler, limits *ServiceLimits, conf *Config, logger Logger) (*Connection, error) {
+	// Connect to the iris relay node and initialize the link
+	opts := conf.connectOptions()
+	relay = wrapTransport(relay, opts)
+	link, err := dialInitial(ctx, relay, cluster, opts, conf.frameDump(), logger)
 	if err != nil {
 		return nil, err
 	}
@@ -96,35 +247,109 @@ func newConnection(port int, cluster string, handler ServiceHandler, limits *Ser
 
 		reqReps: make(map[uint64]chan []byte),
 		reqErrs: make(map[uint64]chan error),
+		reqFuts: make(map[uint64]*Future),
 		subLive: make(map[string]*topic),
+		patLive: make(map[string]*topicTree),
 		tunLive: make(map[uint64]*Tunnel),
+		tunConf: &defaultTunnelConfig,
+		envId:   newPublisherId(),
+
+		grantPend: make(map[uint64]*pendingGrant),
+		grantSign: make(chan struct{}, 1),
+		grantUrge: make(chan struct{}, 1),
+
+		// Instrumentation
+		stats: newMetrics(),
 
 		// Network layer
-		sock:    sock,
-		sockBuf: bufio.NewReadWriter(bufio.NewReader(sock), bufio.NewWriter(sock)),
+		relay:   relay,
+		cluster: cluster,
+		sock:    link.sock,
+		sockBuf: link.sockBuf,
+
+		relayVer:   link.relayVer,
+		relayFeats: link.relayFeats,
 
 		// Bookkeeping
 		quit: make(chan chan error),
 		term: make(chan struct{}),
+		stop: make(chan struct{}),
+
+		started: time.Now(),
 
 		Log: logger,
 	}
 	// Initialize service QoS fields
 	if cluster != "" {
 		conn.limits = limits
-		conn.bcastPool = pool.NewThreadPool(limits.BroadcastThreads)
-		conn.reqPool = pool.NewThreadPool(limits.RequestThreads)
+		conn.bcastPool = newHandlerPool(limits.BroadcastThreads, newQueue(limits.Queue))
+
+		tasks := newQueue(limits.Queue)
+		if limits.RequestPriority != nil {
+			tasks = newPrioQueue(limits.Queue)
+		}
+		conn.reqPool = newHandlerPool(limits.RequestThreads, tasks)
 	}
-	// Initialize the connection and wait for a confirmation
-	if err := conn.sendInit(cluster); err != nil {
+	// Apply the requested configuration before any traffic arrives
+	conn.applyConfig(conf)
+
+	// Start the network receiver and the allowance granter, then return
+	go conn.process()
+	go conn.grantAllowances()
+	return conn, nil
+}
+
+// Dials the local relay through the transport and executes the initialization
+// handshake, returning a bare connection holding the live link, its buffered
+// accessor and the negotiated relay capabilities.
+func dialRelay(ctx context.Context, relay RelayTransport, cluster string, dump FrameDump) (*Connection, error) {
+	sock, err := relay.Dial(ctx)
+	if err != nil {
 		return nil, err
 	}
-	if _, err := conn.procInit(); err != nil {
+	// Use a bare connection to run the protocol handshake
+	reader, writer := linkStreams(sock)
+	link := &Connection{
+		sock:      sock,
+		sockBuf:   bufio.NewReadWriter(bufio.NewReader(reader), bufio.NewWriter(writer)),
+		frameDump: dump,
+	}
+	if err := link.handshake(ctx, cluster); err != nil {
+		sock.Close()
 		return nil, err
 	}
-	// Start the network receiver and return
-	go conn.process()
-	return conn, nil
+	return link, nil
+}
+
+// Executes the connection initialization handshake, interrupting any blocking
+// link operation if the context is cancelled before completion.
+func (c *Connection) handshake(ctx context.Context, cluster string) error {
+	// Start a watchdog to tear down the link on context cancellation
+	done := make(chan struct{})
+	stop := make(chan struct{})
+	go func() {
+		defer close(stop)
+		select {
+		case <-ctx.Done():
+			c.sock.Close()
+		case <-done:
+		}
+	}()
+	// Initialize the connection and wait for a confirmation
+	err := c.sendInit(cluster)
+	if err == nil {
+		if c.relayVer, err = c.procInit(); err == nil {
+			c.relayFeats, err = negotiateFeatures(c.relayVer)
+		}
+	}
+	close(done)
+	<-stop
+
+	// Report the cancellation instead of the induced socket failure
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
+	}
+	return err
 }
 
 // Broadcasts a message to all members of a cluster. No guarantees are made that
@@ -132,16 +357,54 @@ func newConnection(port int, cluster string, handler ServiceHandler, limits *Ser
 //
 // The call blocks until the message is forwarded to the local Iris node.
 func (c *Connection) Broadcast(cluster string, message []byte) error {
+	return c.BroadcastCtx(context.Background(), cluster, message)
+}
+
+// Broadcasts a message to all members of a cluster, unless the context is
+// already cancelled. No guarantees are made that all recipients receive the
+// message (best effort).
+//
+// The call blocks until the message is forwarded to the local Iris node.
+func (c *Connection) BroadcastCtx(ctx context.Context, cluster string, message []byte) error {
+	return c.broadcastCtx(ctx, cluster, message, 0)
+}
+
+// Broadcasts a message through the outbound interceptors, attaching an expiration
+// deadline to it if a ttl is given.
+func (c *Connection) broadcastCtx(ctx context.Context, cluster string, message []byte, ttl time.Duration) error {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
-		return errors.New("empty cluster identifier")
+		return invalidArgument("empty cluster identifier")
 	}
 	if message == nil || len(message) == 0 {
-		return errors.New("nil or empty message")
+		return invalidArgument("nil or empty message")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	_, err := c.interceptOutbound(ctx, TraceBroadcast, cluster, message, func(ctx context.Context, _ TraceOp, cluster string, message []byte) ([]byte, error) {
+		if ttl > 0 {
+			message = wrapTTL(ttl, message)
+		}
+		return nil, c.broadcast(ctx, cluster, message)
+	})
+	return err
+}
+
+// Executes a broadcast after the outbound interceptors ran.
+func (c *Connection) broadcast(ctx context.Context, cluster string, message []byte) error {
+	if err := c.throttle(ctx, c.limiters().broadcasts, 1, nil); err != nil {
+		return err
 	}
-	// Broadcast and return
 	c.Log.Debug("sending new broadcast", "cluster", cluster, "data", logLazyBlob(message))
-	return c.sendBroadcast(cluster, message)
+	message, finish := c.traceOutbound(ctx, TraceBroadcast, cluster, message)
+	if err := c.sendOrQueue(opBroadcast, cluster, message); err != nil {
+		finish(err)
+		return err
+	}
+	finish(nil)
+	atomic.AddUint64(&c.stats.bcastSent, 1)
+	return nil
 }
 
 // Executes a synchronous request to be serviced by a member of the specified
@@ -149,16 +412,52 @@ func (c *Connection) Broadcast(cluster string, message []byte) error {
 //
 // The timeout unit is in milliseconds. Anything lower will fail with an error.
 func (c *Connection) Request(cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	return c.request(context.Background(), cluster, request, timeout)
+}
+
+// Executes a synchronous request to be serviced by a member of the specified
+// cluster, load-balanced between all participant, returning the received reply.
+//
+// The timeout of the request is derived from the context deadline, which must
+// be set. Cancelling the context abandons the request, returning the context's
+// error. Requests issued with an Idempotent context are retried on transient
+// failures if retries are enabled (see EnableRetry).
+func (c *Connection) RequestCtx(ctx context.Context, cluster string, request []byte) ([]byte, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, invalidArgument("context without deadline")
+	}
+	timeout := deadline.Sub(time.Now())
+	if timeout < time.Millisecond {
+		return nil, context.DeadlineExceeded
+	}
+	return c.request(ctx, cluster, request, timeout)
+}
+
+// Executes a request through the outbound interceptors, retrying it if allowed.
+func (c *Connection) request(ctx context.Context, cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	ctx = withCorrelation(ctx, c.correlationID(ctx))
+	done := c.hookRequest(ctx, cluster, request)
+	reply, err := c.interceptOutbound(ctx, TraceRequest, cluster, request, func(ctx context.Context, _ TraceOp, cluster string, request []byte) ([]byte, error) {
+		return c.retryRequest(ctx, cluster, request, timeout)
+	})
+	done(reply, err)
+	return reply, err
+}
+
+// Executes a single request attempt, waiting for the reply, a failure or the
+// cancellation of the context, whichever comes first.
+func (c *Connection) requestOnce(ctx context.Context, cluster string, request []byte, timeout time.Duration) ([]byte, error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
-		return nil, errors.New("empty cluster identifier")
+		return nil, invalidArgument("empty cluster identifier")
 	}
 	if request == nil || len(request) == 0 {
-		return nil, errors.New("nil or empty request")
+		return nil, invalidArgument("nil or empty request")
 	}
 	timeoutms := int(timeout.Nanoseconds() / 1000000)
 	if timeoutms < 1 {
-		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+		return nil, invalidArgument("invalid timeout %v < 1ms", timeout)
 	}
 	// Create a reply and error channel for the results
 	repc := make(chan []byte, 1)
@@ -180,11 +479,25 @@ func (c *Connection) Request(cluster string, request []byte, timeout time.Durati
 		close(errc)
 		c.reqLock.Unlock()
 	}()
-	// Send the request
-	c.Log.Debug("sending new request", "local_request", reqId, "cluster", cluster, "data", logLazyBlob(request), "timeout", timeout)
+	// Wait for the rate limiter, bounded by the request timeout
+	if err := c.throttle(ctx, c.limiters().requests, 1, time.After(timeout)); err != nil {
+		return nil, err
+	}
+	// Send the request, tagging the logs with its correlation identifier, if any
+	logger := c.Log
+	corr, _ := CorrelationFromContext(ctx)
+	if len(corr) > 0 {
+		logger = logger.New("correlation", corr)
+	}
+	logger.Debug("sending new request", "local_request", reqId, "cluster", cluster, "data", logLazyBlob(request), "timeout", timeout)
+	start := time.Now()
+	request, finish := c.traceOutbound(ctx, TraceRequest, cluster, wrapCorrelation(corr, c.advertiseRequest(request)))
 	if err := c.sendRequest(reqId, cluster, request, timeoutms); err != nil {
+		finish(err)
 		return nil, err
 	}
+	c.stats.requestSent(cluster)
+
 	// Retrieve the results or fail if terminating
 	var reply []byte
 	var err error
@@ -192,25 +505,52 @@ func (c *Connection) Request(cluster string, request []byte, timeout time.Durati
 	select {
 	case <-c.term:
 		err = ErrClosed
+	case <-ctx.Done():
+		err = ctx.Err()
 	case reply = <-repc:
 	case err = <-errc:
 	}
-	c.Log.Debug("request completed", "local_request", reqId, "data", logLazyBlob(reply), "error", err)
-	return reply, err
+	logger.Debug("request completed", "local_request", reqId, "data", logLazyBlob(reply), "error", err)
+
+	finish(err)
+	c.stats.requestDone(cluster, time.Since(start), err)
+	return reply, stampCorrelation(err, corr)
 }
 
 // Subscribes to a topic, using handler as the callback for arriving events.
 //
+// The topic may also be a hierarchical pattern of dot separated segments, where
+// '*' matches exactly one segment and a trailing '#' zero or more. Patterns are
+// matched client side, receiving events only from publishers with pattern
+// publishing enabled (see SetPatternPublish).
+//
 // The method blocks until the subscription is forwarded to the relay. There
 // might be a small delay between subscription completion and start of event
 // delivery. This is caused by subscription propagation through the network.
+// If resubscription is enabled (see EnableResubscribe), a subscription failing
+// to reach the relay is retained and retried in the background instead.
 func (c *Connection) Subscribe(topic string, handler TopicHandler, limits *TopicLimits) error {
 	// Sanity check on the arguments
 	if len(topic) == 0 {
-		return errors.New("empty topic identifier")
+		return invalidArgument("empty topic identifier")
 	}
 	if handler == nil {
-		return errors.New("nil subscription handler")
+		return invalidArgument("nil subscription handler")
+	}
+	pattern, err := parsePattern(topic)
+	if err != nil {
+		return err
+	}
+	if limits != nil {
+		if limits.Replay && pattern {
+			return invalidArgument("cannot replay retained events of a topic pattern")
+		}
+		if limits.Overflow < OverflowDropNewest || limits.Overflow > OverflowCallback {
+			return invalidArgument("unknown overflow policy %d", limits.Overflow)
+		}
+		if limits.Overflow == OverflowCallback && limits.OnOverflow == nil {
+			return invalidArgument("nil overflow callback")
+		}
 	}
 	// Make sure the subscription limits have valid values
 	limits = finalizeTopicLimits(limits)
@@ -219,45 +559,166 @@ func (c *Connection) Subscribe(topic string, handler TopicHandler, limits *Topic
 	c.subLock.Lock()
 	if _, ok := c.subLive[topic]; ok {
 		c.subLock.Unlock()
-		return errors.New("already subscribed")
+		return ErrAlreadySubscribed
 	}
 	logger := c.Log.New("topic", atomic.AddUint64(&c.subIdx, 1))
 	logger.Info("subscribing to new topic", "name", topic,
-		"limits", log15.Lazy{func() string {
-			return fmt.Sprintf("%dT|%dB", limits.EventThreads, limits.EventMemory)
-		}})
+		"limits", logLazy(func() string {
+			return fmt.Sprintf("%dT|%dB|%dQ", limits.EventThreads, limits.EventMemory, limits.EventQueue)
+		}))
 
-	c.subLive[topic] = newTopic(handler, limits, logger)
+	top := newTopic(c, topic, handler, limits, logger)
+	if limits.Replay {
+		top.replayId = newReplayId()
+	}
+	c.subLive[topic] = top
+
+	// Patterns listen on a shared fan-in topic, subscribe only if first
+	remote := topic
+	if pattern {
+		remote = patternFanIn(topic)
+		if !c.insertPattern(topic, top) {
+			top.inheritStatus(c.faninSibling(remote, top))
+			c.subLock.Unlock()
+			return nil
+		}
+	}
+	retry := c.resubPolicy != nil
 	c.subLock.Unlock()
 
-	// Send the subscription request
-	err := c.sendSubscribe(topic)
+	// Send the subscription request, querying any retained events if requested
+	err = c.sendSubscribe(remote)
+	if err == nil && top.replayId != 0 {
+		err = c.queryRetained(topic, top.replayId)
+	}
+	top.markSubscribed(err)
+
+	// Keep failed subscriptions for retrying if enabled, drop them otherwise
+	if err != nil && retry && atomic.LoadInt32(&c.closing) == 0 {
+		top.logger.Warn("subscription failed, retrying in the background", "reason", err)
+		c.reconcileSubscriptions()
+		return nil
+	}
 	if err != nil {
 		c.subLock.Lock()
 		if top, ok := c.subLive[topic]; ok {
 			top.terminate()
 			delete(c.subLive, topic)
+			if pattern {
+				c.removePattern(topic, top)
+			}
 		}
 		c.subLock.Unlock()
 	}
 	return err
 }
 
+// Subscribes to a topic, using a raw callback for arriving events instead of a
+// TopicHandler. The callback also receives the topic each event was published
+// to, which for pattern subscriptions is the concrete matching one. Otherwise it
+// is equivalent to Subscribe.
+func (c *Connection) SubscribeFunc(topic string, handler func(topic string, event []byte), limits *TopicLimits) error {
+	if handler == nil {
+		return invalidArgument("nil subscription handler")
+	}
+	return c.Subscribe(topic, &topicFuncHandler{handler}, limits)
+}
+
 // Publishes an event asynchronously to topic. No guarantees are made that all
 // subscribers receive the message (best effort).
 //
 // The method blocks until the message is forwarded to the local Iris node.
 func (c *Connection) Publish(topic string, event []byte) error {
+	return c.PublishCtx(context.Background(), topic, event)
+}
+
+// Publishes an event asynchronously to topic, unless the context is already
+// cancelled. No guarantees are made that all subscribers receive the message
+// (best effort). If pattern publishing is enabled, the event is also forwarded
+// to the matching pattern subscriptions.
+//
+// The method blocks until the message is forwarded to the local Iris node.
+func (c *Connection) PublishCtx(ctx context.Context, topic string, event []byte) error {
+	return c.publishCtx(ctx, topic, event, "", false, 0)
+}
+
+// Publishes an event asynchronously to topic, wrapping it into an envelope with
+// the given content type if requested or if envelope publishing is enabled, and
+// attaching an expiration deadline to it if a ttl is given.
+func (c *Connection) publishCtx(ctx context.Context, topic string, event []byte, contentType string, envelope bool, ttl time.Duration) error {
 	// Sanity check on the arguments
 	if len(topic) == 0 {
-		return errors.New("empty topic identifier")
+		return invalidArgument("empty topic identifier")
 	}
 	if event == nil || len(event) == 0 {
-		return errors.New("nil or empty event")
+		return invalidArgument("nil or empty event")
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	fanout, wrap, err := c.checkPublish(topic)
+	if err != nil {
+		return err
+	}
+	envelope = envelope || wrap
+
+	_, err = c.interceptOutbound(ctx, TracePublish, topic, event, func(ctx context.Context, _ TraceOp, topic string, event []byte) ([]byte, error) {
+		if envelope {
+			event = c.wrapEnvelope(contentType, event)
+		}
+		if ttl > 0 {
+			event = wrapTTL(ttl, event)
+		}
+		return nil, c.publish(ctx, topic, event, fanout)
+	})
+	return err
+}
+
+// Checks whether events can be published to topic, returning whether they need
+// forwarding to the fan-in topics of the pattern subscriptions too, and whether
+// they need wrapping into envelopes.
+func (c *Connection) checkPublish(topic string) (bool, bool, error) {
+	c.subLock.RLock()
+	fanout, envelope := c.patPublish, c.envPublish
+	c.subLock.RUnlock()
+
+	if fanout {
+		if pattern, err := parsePattern(topic); err != nil {
+			return false, false, err
+		} else if pattern {
+			return false, false, invalidArgument("cannot publish to a topic pattern")
+		}
+	}
+	return fanout, envelope, nil
+}
+
+// Executes a publish after the outbound interceptors ran, forwarding it to the
+// fan-in topics too if requested.
+func (c *Connection) publish(ctx context.Context, topic string, event []byte, fanout bool) error {
+	if err := c.throttle(ctx, c.limiters().publishes, 1, nil); err != nil {
+		return err
 	}
-	// Publish and return
 	c.Log.Debug("publishing new event", "topic", topic, "data", logLazyBlob(event))
-	return c.sendPublish(topic, event)
+	done := c.hookPublish(topic, event)
+	event, finish := c.traceOutbound(ctx, TracePublish, topic, event)
+	if err := c.sendOrQueue(opPublish, topic, event); err != nil {
+		finish(err)
+		done(err)
+		return err
+	}
+	if fanout {
+		for _, fanin := range topicFanIns(topic) {
+			if err := c.sendOrQueue(opPublish, fanin, wrapFanIn(topic, event)); err != nil {
+				finish(err)
+				done(err)
+				return err
+			}
+		}
+	}
+	finish(nil)
+	done(nil)
+	atomic.AddUint64(&c.stats.pubSent, 1)
+	return nil
 }
 
 // Unsubscribes from topic, receiving no more event notifications for it.
@@ -266,8 +727,12 @@ func (c *Connection) Publish(topic string, event []byte) error {
 func (c *Connection) Unsubscribe(topic string) error {
 	// Sanity check on the arguments
 	if len(topic) == 0 {
-		return errors.New("empty topic identifier")
+		return invalidArgument("empty topic identifier")
 	}
+	// Don't let a concurrent replay or retry resurrect the subscription
+	c.subSync.Lock()
+	defer c.subSync.Unlock()
+
 	// Log the unsubscription request
 	c.subLock.RLock()
 	if top, ok := c.subLive[topic]; ok {
@@ -275,6 +740,25 @@ func (c *Connection) Unsubscribe(topic string) error {
 	}
 	c.subLock.RUnlock()
 
+	// Patterns are removed locally, unsubscribing the fan-in topic if last
+	if pattern, _ := parsePattern(topic); pattern {
+		c.subLock.Lock()
+		top, ok := c.subLive[topic]
+		if !ok {
+			c.subLock.Unlock()
+			return ErrNotSubscribed
+		}
+		top.terminate()
+		delete(c.subLive, topic)
+		last := c.removePattern(topic, top)
+		c.subLock.Unlock()
+
+		if last {
+			return c.sendUnsubscribe(patternFanIn(topic))
+		}
+		return nil
+	}
+
 	// Unsubscribe through the relay and remove if successful
 	err := c.sendUnsubscribe(topic)
 	if err == nil {
@@ -282,7 +766,7 @@ func (c *Connection) Unsubscribe(topic string) error {
 		defer c.subLock.Unlock()
 
 		if top, ok := c.subLive[topic]; !ok {
-			return errors.New("not subscribed")
+			return ErrNotSubscribed
 		} else {
 			top.terminate()
 			delete(c.subLive, topic)
@@ -295,12 +779,110 @@ func (c *Connection) Unsubscribe(topic string) error {
 // exclusive, order-guaranteed and throttled message passing between them.
 //
 // The method blocks until the newly created tunnel is set up, or the time
-// limit is reached.
+// limit is reached, failing with ErrTunnelBuildTimeout (which matches ErrTimeout
+// via errors.Is, but not via ==).
 //
 // The timeout unit is in milliseconds. Anything lower will fail with an error.
 func (c *Connection) Tunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
 	// Simple call indirection to move into the tunnel source file
-	return c.initTunnel(cluster, timeout)
+	return c.initTunnel(context.Background(), cluster, timeout, nil)
+}
+
+// Opens a direct tunnel to a member of a remote cluster, using the specified
+// buffer and chunking limits instead of the connection wide ones.
+//
+// The timeout unit is in milliseconds. Anything lower will fail with an error.
+func (c *Connection) TunnelWithConfig(cluster string, timeout time.Duration, config *TunnelConfig) (*Tunnel, error) {
+	return c.initTunnel(context.Background(), cluster, timeout, finalizeTunnelConfig(config))
+}
+
+// Sets the buffer and chunking limits of all inbound tunnels and of outbound
+// ones opened without an explicit configuration. Already live tunnels are not
+// affected.
+//
+// Any unset fields (i.e. value of zero) will default to the preset ones.
+func (c *Connection) SetTunnelConfig(config *TunnelConfig) {
+	c.tunLock.Lock()
+	defer c.tunLock.Unlock()
+
+	c.tunConf = finalizeTunnelConfig(config)
+}
+
+// Opens a direct tunnel to a member of a remote cluster, allowing pairwise-
+// exclusive, order-guaranteed and throttled message passing between them.
+//
+// The method blocks until the newly created tunnel is set up, or the context
+// is cancelled. The construction timeout is derived from the context deadline,
+// which must be set.
+func (c *Connection) TunnelCtx(ctx context.Context, cluster string) (*Tunnel, error) {
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		return nil, invalidArgument("context without deadline")
+	}
+	timeout := deadline.Sub(time.Now())
+	if timeout < time.Millisecond {
+		return nil, context.DeadlineExceeded
+	}
+	return c.initTunnel(ctx, cluster, timeout, nil)
+}
+
+// Gracefully shuts the connection down: stops accepting new inbound broadcasts,
+// requests and tunnels, waits for the pending outbound requests, the running
+// handlers and the in-flight tunnel sends to finish, and then tears down the
+// connection like Close.
+//
+// If the drain does not complete within the timeout, the connection is closed
+// anyway (aborting the outstanding operations) and ErrTimeout returned. Services
+// should use Service.Drain instead, which also stops their handler pools.
+func (c *Connection) Shutdown(timeout time.Duration) error {
+	c.Log.Info("shutting down connection", "timeout", timeout)
+	atomic.StoreInt32(&c.draining, 1)
+
+	// Wait for all the in-flight work to finish
+	expire := time.After(timeout)
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	var err error
+	for !c.quiesced() {
+		select {
+		case <-expire:
+			c.Log.Warn("connection shutdown timed out")
+			err = ErrTimeout
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	// Tear down the connection, reporting the first failure
+	if cerr := c.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// Checks whether all the in-flight operations of a shutting down connection
+// have finished.
+func (c *Connection) quiesced() bool {
+	if c.limits != nil && (c.bcastPool.Busy() || c.reqPool.Busy() || c.broadcastsBatched()) {
+		return false
+	}
+	c.reqLock.RLock()
+	pending := len(c.reqReps) + len(c.reqFuts)
+	c.reqLock.RUnlock()
+
+	if pending > 0 {
+		return false
+	}
+	c.tunLock.RLock()
+	defer c.tunLock.RUnlock()
+
+	for _, tun := range c.tunLive {
+		if atomic.LoadInt32(&tun.sending) > 0 {
+			return false
+		}
+	}
+	return true
 }
 
 // Gracefully terminates the connection removing all subscriptions and closing
@@ -309,10 +891,30 @@ func (c *Connection) Tunnel(cluster string, timeout time.Duration) (*Tunnel, err
 // The call blocks until the connection tear-down is confirmed by the Iris node.
 func (c *Connection) Close() error {
 	c.Log.Info("detaching from relay")
+	if atomic.CompareAndSwapInt32(&c.closing, 0, 1) {
+		close(c.stop)
+	}
 
-	// Send a graceful close to the relay node
+	// Drop any publishes still scheduled for later
+	c.cancelScheduled()
+
+	// Release the outbox, keeping its queued messages on disk
+	c.closeOutbox()
+
+	// Bid farewell to the watchers of the cluster membership
+	c.stopAnnounce()
+
+	// Drop the events held back by full blocking subscriptions
+	c.releaseBlocked()
+
+	// Send a graceful close to the relay node, or drop the link if it's already
+	// down (e.g. reconnecting), tearing down the local state either way
 	if err := c.sendClose(); err != nil {
-		return err
+		c.Log.Warn("failed to send graceful close", "reason", err)
+
+		c.sockLock.Lock()
+		c.sock.Close()
+		c.sockLock.Unlock()
 	}
 	// Wait till the close syncs and return
 	errc := make(chan error, 1)
@@ -0,0 +1,156 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the registry sharing client connections between the independent
+// components of a process.
+
+package iris
+
+import (
+	"sync"
+	"time"
+)
+
+// User configuration of a connection pool.
+type PoolConfig struct {
+	IdleTimeout time.Duration // Time to keep an unreferenced connection alive
+}
+
+// Default configuration of a connection pool.
+var defaultPoolConfig = PoolConfig{
+	IdleTimeout: 30 * time.Second,
+}
+
+// Reference counted registry of client connections, sharing a single relay link
+// per local relay port between all its users. Connections not referenced by
+// anyone are torn down after an idle timeout.
+//
+// Only client connections are pooled, since services are bound to their own
+// handler and cannot be shared.
+type Pool struct {
+	config *PoolConfig         // Configuration of the pool
+	conns  map[int]*pooledConn // Live connections keyed by relay port
+	lock   sync.Mutex          // Mutex to protect the connection registry
+}
+
+// Shared client connection along with its bookkeeping.
+type pooledConn struct {
+	conn *Connection // Client connection to the relay
+	refs int         // Number of users currently holding the connection
+	idle *time.Timer // Idle teardown timer, nil while referenced
+}
+
+// Process wide connection pool for components without a dedicated one.
+var DefaultPool = NewPool(nil)
+
+// Creates a new connection pool. Any unset fields (i.e. value of zero) of the
+// configuration will default to the preset ones.
+func NewPool(config *PoolConfig) *Pool {
+	return &Pool{
+		config: finalizePoolConfig(config),
+		conns:  make(map[int]*pooledConn),
+	}
+}
+
+// Merges the user requested configuration with the defaults.
+func finalizePoolConfig(user *PoolConfig) *PoolConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultPoolConfig
+	}
+	// Check each field and merge only non-specified ones
+	config := new(PoolConfig)
+	*config = *user
+
+	if user.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultPoolConfig.IdleTimeout
+	}
+	return config
+}
+
+// Retrieves a shared client connection to the local relay port, connecting if
+// none is live. Every acquired connection must be released via Release instead
+// of being closed directly.
+func (p *Pool) Acquire(port int) (*Connection, error) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// Reuse the live connection if one exists
+	if entry, ok := p.conns[port]; ok {
+		if entry.conn.Health() != HealthClosed {
+			if entry.idle != nil {
+				entry.idle.Stop()
+				entry.idle = nil
+			}
+			entry.refs++
+			return entry.conn, nil
+		}
+		// Connection died meanwhile, replace it
+		delete(p.conns, port)
+	}
+	conn, err := Connect(port)
+	if err != nil {
+		return nil, err
+	}
+	p.conns[port] = &pooledConn{conn: conn, refs: 1}
+	return conn, nil
+}
+
+// Returns a previously acquired connection to the pool. When the last user
+// releases it, the connection is torn down after the idle timeout, unless it is
+// reacquired meanwhile.
+func (p *Pool) Release(conn *Connection) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	for port, entry := range p.conns {
+		if entry.conn != conn {
+			continue
+		}
+		if entry.refs == 0 {
+			return invalidArgument("connection already released")
+		}
+		if entry.refs--; entry.refs == 0 {
+			port, entry := port, entry
+			entry.idle = time.AfterFunc(p.config.IdleTimeout, func() { p.expire(port, entry) })
+		}
+		return nil
+	}
+	return invalidArgument("connection not pooled")
+}
+
+// Tears down an idle connection, unless it was reacquired meanwhile.
+func (p *Pool) expire(port int, entry *pooledConn) {
+	p.lock.Lock()
+	if p.conns[port] != entry || entry.refs > 0 {
+		p.lock.Unlock()
+		return
+	}
+	delete(p.conns, port)
+	p.lock.Unlock()
+
+	entry.conn.Log.Info("closing idle pooled connection")
+	entry.conn.Close()
+}
+
+// Tears down all the pooled connections, regardless of their users.
+func (p *Pool) Close() error {
+	p.lock.Lock()
+	conns := p.conns
+	p.conns = make(map[int]*pooledConn)
+	p.lock.Unlock()
+
+	var failure error
+	for _, entry := range conns {
+		if entry.idle != nil {
+			entry.idle.Stop()
+		}
+		if err := entry.conn.Close(); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
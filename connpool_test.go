@@ -0,0 +1,72 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that pooled connections are shared, and torn down when idle.
+func TestConnectionPool(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		idle time.Duration
+	}{50 * time.Millisecond}
+
+	pool := NewPool(&PoolConfig{IdleTimeout: conf.idle})
+	defer pool.Close()
+
+	// Acquire the connection twice and verify that it's shared
+	first, err := pool.Acquire(config.relay)
+	if err != nil {
+		t.Fatalf("first acquisition failed: %v.", err)
+	}
+	second, err := pool.Acquire(config.relay)
+	if err != nil {
+		t.Fatalf("second acquisition failed: %v.", err)
+	}
+	if first != second {
+		t.Fatalf("pooled connection not shared.")
+	}
+	// Release both, reacquiring in between to verify the idle timer reset
+	if err := pool.Release(first); err != nil {
+		t.Fatalf("first release failed: %v.", err)
+	}
+	if err := pool.Release(second); err != nil {
+		t.Fatalf("second release failed: %v.", err)
+	}
+	if err := pool.Release(second); err == nil {
+		t.Fatalf("excess release succeeded.")
+	}
+	time.Sleep(conf.idle / 2)
+	if conn, err := pool.Acquire(config.relay); err != nil || conn != first {
+		t.Fatalf("idle reacquisition mismatch: have %p/%v, want %p.", conn, err, first)
+	}
+	time.Sleep(2 * conf.idle)
+	if state := first.Health(); state != HealthConnected {
+		t.Fatalf("referenced connection state mismatch: have %v, want %v.", state, HealthConnected)
+	}
+	// Release for good and verify the teardown and replacement
+	if err := pool.Release(first); err != nil {
+		t.Fatalf("final release failed: %v.", err)
+	}
+	time.Sleep(2 * conf.idle)
+	if state := first.Health(); state != HealthClosed {
+		t.Fatalf("idle connection state mismatch: have %v, want %v.", state, HealthClosed)
+	}
+	conn, err := pool.Acquire(config.relay)
+	if err != nil {
+		t.Fatalf("replacement acquisition failed: %v.", err)
+	}
+	if conn == first {
+		t.Fatalf("closed connection reused.")
+	}
+	if err := pool.Release(conn); err != nil {
+		t.Fatalf("replacement release failed: %v.", err)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the request correlation identifiers, prefixed to the requests by the
+// caller (older serving bindings hand the prefix to the handler along with the
+// request) and injected into the binding logs of both sides, joining the log
+// lines of a single request across machines.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+)
+
+// Prefix identifying a request carrying a correlation identifier.
+var correlationMagic = []byte("\x00iris-corr\x00")
+
+// Context key under which the correlation identifier of a request is stored.
+type correlationCtxKey struct{}
+
+// Generates a fresh random correlation identifier.
+func NewCorrelationID() string {
+	blob := make([]byte, 8)
+	if _, err := rand.Read(blob); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(blob)
+}
+
+// Attaches a correlation identifier to the context, to be carried along with the
+// requests issued with it (e.g. via RequestCtx or CallCtx).
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationCtxKey{}, id)
+}
+
+// Retrieves the correlation identifier of a request from the context passed to
+// ContextRequestHandler, or from a context created via WithCorrelationID.
+func CorrelationFromContext(ctx context.Context) (string, bool) {
+	id, ok := ctx.Value(correlationCtxKey{}).(string)
+	return id, ok && len(id) > 0
+}
+
+// Enables or disables generating a correlation identifier for every outbound
+// request not already carrying one in its context.
+func (c *Connection) SetRequestCorrelation(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&c.correlate, 1)
+	} else {
+		atomic.StoreInt32(&c.correlate, 0)
+	}
+}
+
+// Retrieves the correlation identifier of the request, or an empty string if it
+// was issued without one.
+func (f *Future) CorrelationID() string {
+	return f.correlation
+}
+
+// Picks the correlation identifier of an outbound request: the one attached to
+// the context, or a fresh one if generation is enabled, or none.
+func (c *Connection) correlationID(ctx context.Context) string {
+	if id, ok := CorrelationFromContext(ctx); ok {
+		return id
+	}
+	if atomic.LoadInt32(&c.correlate) == 1 {
+		return NewCorrelationID()
+	}
+	return ""
+}
+
+// Embeds a correlation identifier into an outbound request, if any.
+func wrapCorrelation(id string, request []byte) []byte {
+	if len(id) == 0 {
+		return request
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(correlationMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(id)))])
+	buf.WriteString(id)
+	buf.Write(request)
+	return buf.Bytes()
+}
+
+// Splits the correlation identifier off an inbound request, if any. Requests
+// without identifiers (or with malformed ones) are returned as is.
+func unwrapCorrelation(request []byte) (string, []byte) {
+	if !bytes.HasPrefix(request, correlationMagic) {
+		return "", request
+	}
+	blob := request[len(correlationMagic):]
+
+	size, n := binary.Uvarint(blob)
+	if n <= 0 || size > uint64(len(blob)-n) {
+		return "", request
+	}
+	return string(blob[n : n+int(size)]), blob[n+int(size):]
+}
+
+// Injects the correlation identifier of a request into the handler context, if
+// any.
+func withCorrelation(ctx context.Context, id string) context.Context {
+	if len(id) == 0 {
+		return ctx
+	}
+	return WithCorrelationID(ctx, id)
+}
+
+// Stamps the correlation identifier of a request onto its remote failure, if
+// both are present.
+func stampCorrelation(err error, id string) error {
+	var remote *RemoteError
+	if len(id) > 0 && errors.As(err, &remote) {
+		remote.CorrelationID = id
+	}
+	return err
+}
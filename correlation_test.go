@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Service handler for the correlation tests, replying with the correlation
+// identifiers of the requests, or failing them if asked to.
+type correlationTestHandler struct {
+	conn *Connection
+}
+
+func (c *correlationTestHandler) Init(conn *Connection) error              { c.conn = conn; return nil }
+func (c *correlationTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (c *correlationTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (c *correlationTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (c *correlationTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (c *correlationTestHandler) HandleRequestCtx(ctx context.Context, req []byte) ([]byte, error) {
+	if string(req) == "fail" {
+		return nil, &Error{Code: CodeInternal, Message: "failed"}
+	}
+	if id, ok := CorrelationFromContext(ctx); ok {
+		return []byte(id), nil
+	}
+	return []byte("none"), nil
+}
+
+// Tests that correlation identifiers reach the handlers and the failures.
+func TestRequestCorrelation(t *testing.T) {
+	// Register a new service to the relay
+	handler := new(correlationTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Verify that requests are not correlated by default
+	if reply, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("uncorrelated request failed: %v.", err)
+	} else if string(reply) != "none" {
+		t.Fatalf("uncorrelated request mismatch: have %s, want %s.", reply, "none")
+	}
+	// Verify that explicit identifiers are carried to both the handler and failures
+	ctx, cancel := context.WithTimeout(WithCorrelationID(context.Background(), "explicit"), time.Second)
+	defer cancel()
+
+	if reply, err := handler.conn.RequestCtx(ctx, config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("correlated request failed: %v.", err)
+	} else if string(reply) != "explicit" {
+		t.Fatalf("correlation mismatch: have %s, want %s.", reply, "explicit")
+	}
+	_, err = handler.conn.RequestCtx(ctx, config.cluster, []byte("fail"))
+
+	var remote *RemoteError
+	if !errors.As(err, &remote) || remote.CorrelationID != "explicit" {
+		t.Fatalf("failure correlation mismatch: have %#v, want %s.", err, "explicit")
+	}
+	// Enable generation and verify fresh identifiers for each request
+	handler.conn.SetRequestCorrelation(true)
+
+	first, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second)
+	if err != nil {
+		t.Fatalf("generated correlation request failed: %v.", err)
+	}
+	second, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second)
+	if err != nil {
+		t.Fatalf("generated correlation request failed: %v.", err)
+	}
+	if len(first) != 16 || len(second) != 16 || string(first) == string(second) {
+		t.Fatalf("generated correlations invalid: %s, %s.", first, second)
+	}
+	// Verify that asynchronous requests expose their generated identifiers
+	future, err := handler.conn.RequestAsync(config.cluster, []byte{0x00}, time.Second)
+	if err != nil {
+		t.Fatalf("async request failed: %v.", err)
+	}
+	if reply, err := future.Result(); err != nil {
+		t.Fatalf("async request failed: %v.", err)
+	} else if string(reply) != future.CorrelationID() {
+		t.Fatalf("async correlation mismatch: have %s, want %s.", reply, future.CorrelationID())
+	}
+}
@@ -0,0 +1,137 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the end-to-end encryption of tunnel messages.
+//
+// Once a tunnel endpoint is secured with a key, it signals the switch to its
+// peer in band and seals all subsequent data messages (after compression) with
+// AES-GCM, so that the relays only ever see the ciphertexts. Since the peer may
+// only learn the key later (e.g. after an exchange over the tunnel itself), the
+// inbound messages following the switch are decrypted upon consumption instead
+// of arrival. Control messages are sent in the clear. Conversely, once secured
+// locally, any data message arriving before the peer's switch is discarded, so
+// that the relays cannot inject plaintext into a secured stream.
+
+package iris
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"sync/atomic"
+)
+
+// Callback deriving the end-to-end key of a freshly built tunnel, typically by
+// exchanging messages with the remote side over the tunnel itself (which are
+// sent in the clear). Since the relays can observe and alter the exchange, it
+// must authenticate the remote side to be of any use.
+type KeyExchange func(tun *Tunnel) ([]byte, error)
+
+// Length of the random nonce prefixed to each encrypted message.
+const sealNonceSize = 12
+
+// Maximum size increase of a message due to encryption (nonce and auth tag).
+const sealOverhead = sealNonceSize + 16
+
+// Enables the end-to-end encryption of the tunnel with the given AES key (16,
+// 24 or 32 bytes long). All messages sent afterwards are encrypted, and the
+// remote side is signalled to expect encrypted messages, requiring it to be
+// secured with the same key too. Unencrypted messages arriving afterwards (i.e.
+// sent by the remote side before securing its own end) are dropped, whereas the
+// ones already queued are still delivered.
+//
+// Tunnels configured with a key or key exchange are secured automatically,
+// before being returned by Connection.Tunnel or handed to HandleTunnel.
+func (t *Tunnel) Secure(key []byte) error {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCMWithNonceSize(block, sealNonceSize)
+	if err != nil {
+		return err
+	}
+	if atomic.LoadInt32(&t.atoiEOF) == 1 {
+		return ErrClosed
+	}
+	// Permit decrypting the inbound messages, whenever they switch over
+	t.itoaLock.Lock()
+	t.open = aead
+	t.itoaLock.Unlock()
+
+	// Signal the switch and encrypt everything afterwards, atomically with sends
+	t.sendLock.Lock()
+	defer t.sendLock.Unlock()
+
+	if t.seal != nil {
+		return invalidArgument("tunnel already secured")
+	}
+	if err := t.sendLocked(context.Background(), wrapTunnelCtl(tunnelCipher, ""), nil); err != nil {
+		return err
+	}
+	// High priority frames encrypt outside of the send lock
+	t.sendGate.acquire(PriorityHigh)
+	t.seal = aead
+	t.sendGate.release()
+
+	t.Log.Info("tunnel secured end-to-end")
+	return nil
+}
+
+// Secures a freshly built tunnel with the configured key or key exchange, if
+// any.
+func (t *Tunnel) secureConfigured() error {
+	key := t.limits.Key
+	if t.limits.KeyExchange != nil {
+		var err error
+		if key, err = t.limits.KeyExchange(t); err != nil {
+			return err
+		}
+	}
+	if key == nil {
+		return nil
+	}
+	return t.Secure(key)
+}
+
+// Encrypts an outbound message if the tunnel was secured. The caller needs to
+// hold either the send lock or the high priority send gate.
+func (t *Tunnel) sealMessage(message []byte) ([]byte, error) {
+	if t.seal == nil {
+		return message, nil
+	}
+	nonce := make([]byte, sealNonceSize, sealNonceSize+len(message)+sealOverhead)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return t.seal.Seal(nonce, nonce, message, nil), nil
+}
+
+// Decrypts (then decompresses and decodes) a queued inbound message arrived after the remote
+// side switched to encryption. The inbound lock is assumed to be held.
+func (t *Tunnel) openMessage(msg *inboundMessage) error {
+	if t.open == nil {
+		return fmt.Errorf("%w: encrypted message arrived on unsecured tunnel", ErrProtocolViolation)
+	}
+	if len(msg.data) < sealNonceSize {
+		return fmt.Errorf("%w: truncated encrypted message", ErrProtocolViolation)
+	}
+	plain, err := t.open.Open(nil, msg.data[:sealNonceSize], msg.data[sealNonceSize:], nil)
+	if err != nil {
+		return err
+	}
+	if plain, err = decompressMessage(msg.decompress, plain); err != nil {
+		return err
+	}
+	if plain, err = t.conn.decodePayload(TraceTunnel, plain); err != nil {
+		return err
+	}
+	msg.meta, msg.data = unwrapMessageMeta(plain)
+	msg.sealed = false
+	return nil
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the net.Conn style deadline tracking used by the tunnels.
+
+package iris
+
+import (
+	"sync"
+	"time"
+)
+
+// Resettable deadline, signalling expiration by closing a channel.
+type deadline struct {
+	timer *time.Timer   // Timer to close the expiration channel
+	exp   chan struct{} // Channel closed when the deadline passes
+	lock  sync.Mutex    // Protects the timer and expiration channel
+}
+
+// Creates a new deadline, initially disabled.
+func newDeadline() *deadline {
+	return &deadline{
+		exp: make(chan struct{}),
+	}
+}
+
+// Sets the deadline to the given point in time, zero disabling it.
+func (d *deadline) set(t time.Time) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	// Stop any previous timer and refresh the channel if already expired
+	if d.timer != nil && !d.timer.Stop() {
+		<-d.exp
+	}
+	d.timer = nil
+
+	select {
+	case <-d.exp:
+		d.exp = make(chan struct{})
+	default:
+	}
+	// Schedule the new expiration, or trigger immediately if in the past
+	if t.IsZero() {
+		return
+	}
+	if wait := t.Sub(time.Now()); wait > 0 {
+		exp := d.exp
+		d.timer = time.AfterFunc(wait, func() { close(exp) })
+	} else {
+		close(d.exp)
+	}
+}
+
+// Returns a channel which is closed when the current deadline expires.
+func (d *deadline) wait() <-chan struct{} {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	return d.exp
+}
+
+// Checks whether the current deadline already expired.
+func (d *deadline) expired() bool {
+	select {
+	case <-d.wait():
+		return true
+	default:
+		return false
+	}
+}
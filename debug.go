@@ -0,0 +1,113 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the runtime debug snapshots of a connection's internal state.
+
+package iris
+
+import (
+	"expvar"
+	"sort"
+	"sync/atomic"
+)
+
+// Point in time dump of the internal bookkeeping of a connection, meant to be
+// served from a debug endpoint when diagnosing leaks or stalls. Contrary to the
+// metrics, these are gauges of the current state, not cumulative counters.
+type DebugSnapshot struct {
+	Cluster string // Cluster the connection is registered as, empty for clients
+	Health  string // Health state of the relay link
+
+	PendingRequests int // Issued requests waiting for a reply (including async ones)
+	Subscriptions   int // Active topic subscriptions (including patterns)
+	Patterns        int // Fan-in topics of the pattern subscriptions
+	LiveTunnels     int // Tunnels currently open
+
+	SocketPending  int // Relay socket writes batched up, waiting for a flush
+	BroadcastQueue int // Inbound broadcasts waiting for a handler
+	RequestQueue   int // Inbound requests waiting for a handler
+
+	TopicQueues  map[string]int // Inbound events waiting for a handler, by topic
+	TunnelQueues map[uint64]int // Arrived messages waiting to be consumed, by tunnel
+}
+
+// Retrieves a snapshot of the connection's internal bookkeeping state.
+func (c *Connection) DebugSnapshot() *DebugSnapshot {
+	snap := &DebugSnapshot{
+		Cluster:       c.cluster,
+		Health:        c.Health().String(),
+		SocketPending: int(atomic.LoadInt32(&c.sockWait)),
+		TopicQueues:   make(map[string]int),
+		TunnelQueues:  make(map[uint64]int),
+	}
+	// Collect the inbound handler queues (only services have them)
+	if c.limits != nil {
+		snap.BroadcastQueue = c.bcastPool.Pending()
+		snap.RequestQueue = c.reqPool.Pending()
+	}
+	// Count the pending requests
+	c.reqLock.RLock()
+	snap.PendingRequests = len(c.reqReps) + len(c.reqFuts)
+	c.reqLock.RUnlock()
+
+	// Collect the subscriptions and their event queues
+	c.subLock.RLock()
+	snap.Subscriptions = len(c.subLive)
+	snap.Patterns = len(c.patLive)
+	for name, top := range c.subLive {
+		snap.TopicQueues[name] = top.pending()
+	}
+	c.subLock.RUnlock()
+
+	// Collect the live tunnels and their inbound queues
+	c.tunLock.RLock()
+	snap.LiveTunnels = len(c.tunLive)
+	for id, tun := range c.tunLive {
+		tun.itoaLock.Lock()
+		snap.TunnelQueues[id] = tun.itoaBuf.Size()
+		tun.itoaLock.Unlock()
+	}
+	c.tunLock.RUnlock()
+
+	return snap
+}
+
+// Retrieves the cluster the connection is registered as, or an empty string for
+// client connections.
+func (c *Connection) ClusterName() string {
+	return c.cluster
+}
+
+// Retrieves the topics (and patterns) the connection is currently subscribed to,
+// in lexicographic order.
+func (c *Connection) Subscriptions() []string {
+	c.subLock.RLock()
+	topics := make([]string, 0, len(c.subLive))
+	for topic := range c.subLive {
+		topics = append(topics, topic)
+	}
+	c.subLock.RUnlock()
+
+	sort.Strings(topics)
+	return topics
+}
+
+// Retrieves the number of tunnels currently open through the connection, both
+// outbound and inbound.
+func (c *Connection) LiveTunnels() int {
+	c.tunLock.RLock()
+	defer c.tunLock.RUnlock()
+
+	return len(c.tunLive)
+}
+
+// Creates an expvar variable reporting the connection's debug snapshot on every
+// access, ready to be exported via expvar.Publish under a chosen name.
+func (c *Connection) Expvar() expvar.Var {
+	return expvar.Func(func() interface{} {
+		return c.DebugSnapshot()
+	})
+}
@@ -0,0 +1,172 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the service side deduplication of requests, answering the repeated
+// deliveries of a request from a short lived reply cache instead of executing
+// them again.
+
+package iris
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Limits of the reply cache deduplicating the inbound requests.
+type DedupConfig struct {
+	Entries int           // Maximum number of replies cached
+	TTL     time.Duration // Time a reply is cached for after its request completed
+}
+
+// Default limits of the reply cache deduplicating the inbound requests.
+var defaultDedupConfig = DedupConfig{
+	Entries: 4096,
+	TTL:     time.Minute,
+}
+
+// Merges the user requested limits with the defaults.
+func finalizeDedupConfig(user *DedupConfig) *DedupConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultDedupConfig
+	}
+	// Check each field and merge only non-specified ones
+	config := new(DedupConfig)
+	*config = *user
+
+	if user.Entries <= 0 {
+		config.Entries = defaultDedupConfig.Entries
+	}
+	if user.TTL <= 0 {
+		config.TTL = defaultDedupConfig.TTL
+	}
+	return config
+}
+
+// Outcome of a deduplicated request, shared by all its deliveries.
+type dedupEntry struct {
+	key     string        // Correlation identifier of the request
+	done    chan struct{} // Channel closed when the first delivery completes
+	reply   []byte        // Reply of the request, once done
+	err     error         // Failure of the request, once done
+	expires time.Time     // Time the cached reply expires, zero while running
+}
+
+// LRU cache of the recent request outcomes, keyed by correlation identifier.
+type dedupCache struct {
+	config  *DedupConfig             // Limits of the cache
+	entries map[string]*list.Element // Cached outcomes by correlation identifier
+	order   *list.List               // Cached outcomes, most recently used first
+	lock    sync.Mutex               // Mutex to protect the cache
+}
+
+// Enables the deduplication of the inbound requests: requests arriving with the
+// correlation identifier of a recent one (see WithCorrelationID) are answered
+// with its reply instead of being executed again. Deliveries arriving while the
+// first one is still running wait for its outcome. This makes retried or hedged
+// requests of mutating endpoints practical, as long as the callers attach a
+// unique correlation identifier to each logical request (retries and hedges of
+// a request share it).
+//
+// Only successful replies are cached, failed requests are executed again upon
+// a repeated delivery. Any unset fields (i.e. value of zero) of the config will
+// default to the preset ones.
+func (c *Connection) EnableDedup(config *DedupConfig) {
+	cache := &dedupCache{
+		config:  finalizeDedupConfig(config),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	c.dedupLock.Lock()
+	defer c.dedupLock.Unlock()
+
+	c.dedup = cache
+}
+
+// Disables the deduplication of the inbound requests, dropping the cached
+// replies.
+func (c *Connection) DisableDedup() {
+	c.dedupLock.Lock()
+	defer c.dedupLock.Unlock()
+
+	c.dedup = nil
+}
+
+// Executes an inbound request via run, unless it's a repeated delivery of a
+// recent one, in which case the original's outcome is returned.
+func (c *Connection) dedupRequest(ctx context.Context, key string, logger Logger, run func() ([]byte, error)) ([]byte, error) {
+	c.dedupLock.Lock()
+	cache := c.dedup
+	c.dedupLock.Unlock()
+
+	if cache == nil || len(key) == 0 {
+		return run()
+	}
+	// Look up the request, registering it if not seen yet
+	entry, seen := cache.claim(key)
+	if seen {
+		logger.Debug("answering repeated request from cache")
+		select {
+		case <-entry.done:
+			return entry.reply, entry.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	// First delivery, execute and share the outcome
+	entry.reply, entry.err = run()
+	cache.complete(entry)
+	close(entry.done)
+
+	return entry.reply, entry.err
+}
+
+// Retrieves the live entry of a request, or registers a new one if none exists
+// (or it expired). Returns whether the request was seen before.
+func (d *dedupCache) claim(key string) (*dedupEntry, bool) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if elem, ok := d.entries[key]; ok {
+		entry := elem.Value.(*dedupEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			d.order.MoveToFront(elem)
+			return entry, true
+		}
+		d.order.Remove(elem)
+		delete(d.entries, key)
+	}
+	entry := &dedupEntry{key: key, done: make(chan struct{})}
+	d.entries[key] = d.order.PushFront(entry)
+
+	// Evict the least recently used entries above the limit
+	for d.order.Len() > d.config.Entries {
+		oldest := d.order.Back()
+		d.order.Remove(oldest)
+		delete(d.entries, oldest.Value.(*dedupEntry).key)
+	}
+	return entry, false
+}
+
+// Marks a request completed, caching its reply if successful or dropping it from
+// the cache otherwise.
+func (d *dedupCache) complete(entry *dedupEntry) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	elem, ok := d.entries[entry.key]
+	if !ok || elem.Value.(*dedupEntry) != entry {
+		return
+	}
+	if entry.err != nil {
+		d.order.Remove(elem)
+		delete(d.entries, entry.key)
+		return
+	}
+	entry.expires = time.Now().Add(d.config.TTL)
+}
@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the deduplication tests, counting the executed requests.
+type dedupTestHandler struct {
+	conn     *Connection
+	executed int32
+}
+
+func (d *dedupTestHandler) Init(conn *Connection) error { d.conn = conn; return nil }
+func (d *dedupTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (d *dedupTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (d *dedupTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (d *dedupTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if string(req) == "fail" {
+		atomic.AddInt32(&d.executed, 1)
+		return nil, &Error{Code: CodeInternal, Message: "failed"}
+	}
+	return []byte(fmt.Sprintf("execution %d", atomic.AddInt32(&d.executed, 1))), nil
+}
+
+// Tests that repeated deliveries of a request are answered from the cache.
+func TestRequestDedup(t *testing.T) {
+	// Register a new service to the relay with deduplication enabled
+	handler := new(dedupTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.EnableDedup(&DedupConfig{Entries: 2, TTL: 250 * time.Millisecond})
+
+	request := func(id string, body string) (string, error) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		if len(id) > 0 {
+			ctx = WithCorrelationID(ctx, id)
+		}
+		reply, err := handler.conn.RequestCtx(ctx, config.cluster, []byte(body))
+		return string(reply), err
+	}
+	check := func(id string, want string) {
+		if reply, err := request(id, "request"); err != nil {
+			t.Fatalf("request %q failed: %v.", id, err)
+		} else if reply != want {
+			t.Fatalf("request %q reply mismatch: have %s, want %s.", id, reply, want)
+		}
+	}
+	// Verify that repeated identifiers are only executed once
+	check("first", "execution 1")
+	check("first", "execution 1")
+	check("second", "execution 2")
+	check("", "execution 3")
+	check("", "execution 4")
+
+	// Verify that failures are not cached
+	for i := 0; i < 2; i++ {
+		if _, err := request("failing", "fail"); err == nil {
+			t.Fatalf("failing request succeeded.")
+		}
+	}
+	if executed := atomic.LoadInt32(&handler.executed); executed != 6 {
+		t.Fatalf("execution count mismatch: have %d, want %d.", executed, 6)
+	}
+	// Verify that least recently used and expired replies are evicted
+	check("third", "execution 7")
+	check("fourth", "execution 8")
+	check("first", "execution 9")
+
+	time.Sleep(300 * time.Millisecond)
+	check("first", "execution 10")
+
+	// Verify that disabling deduplication executes all requests
+	handler.conn.DisableDedup()
+	check("first", "execution 11")
+}
@@ -129,6 +129,130 @@ live iris.Connection object - the service's client connection - through which
 the service itself can initiate outbound requests. Init is called only once and
 is synchronized before any other handler method is invoked.
 
+If the relay endpoint is fronted by TLS, the link can be encrypted (and mutually
+authenticated via client certificates) by attaching through iris.ConnectTLS and
+iris.RegisterTLS instead, passing a standard *tls.Config. Automatic reconnections
+reuse the same configuration.
+
+On co-located deployments, the relay may also be reached through a unix domain
+socket via iris.ConnectUnix and iris.RegisterUnix, passing the socket path instead
+of the port. This avoids the TCP stack altogether and allows locking down access
+with filesystem permissions.
+
+Any other way of reaching the relay (websockets, in-memory pipes, test harnesses)
+can be plugged in by implementing iris.RelayTransport, dialing iris.RelayLinks
+that read and write opaque frames of the protocol stream, and passing it to
+iris.ConnectTransport or iris.RegisterTransport. Transports producing plain
+net.Conn streams can be wrapped via iris.NewConnTransport, whereas the TCP and
+unix socket defaults are available through iris.NewTCPTransport and
+iris.NewUnixTransport. Relays or gateways exposed behind HTTP infrastructure
+passing only websocket traffic can be reached via the iriswebsocket subpackage,
+which also provides a gateway handler bridging websocket sessions to a relay's
+TCP endpoint.
+
+    conn, err := iris.ConnectTransport(iriswebsocket.NewTransport("wss://gateway.example.com/iris", nil))
+
+Hosts running several relays (or reaching a few redundant ones) can fail over
+among them: iris.NewFailoverTransport combines multiple transports, dialing them
+in the order of their health (the ones with the fewest consecutive dial failures
+and link drops first, ties broken by the given order), whereas
+iris.ConnectFailover and iris.RegisterFailover do the same for a list of local
+relay ports and enable automatic reconnection too. Should the active relay become
+unreachable, the connection thus moves on to the next healthy one, restoring its
+registration and subscriptions there.
+
+    service, err := iris.RegisterFailover([]int{55555, 55556}, "echo", new(EchoHandler), nil)
+
+By default, the connection setup waits for the relay as long as it takes.
+Attaching via iris.ConnectWithOptions or iris.RegisterWithOptions instead bounds
+the handshake in time (failing with iris.ErrHandshakeTimeout, 10s by default),
+retries failed initial attempts, and optionally bounds every relay link read and
+write too, tearing down stalled links with iris.ErrLinkTimeout (see
+iris.ConnectOptions). As an idle link is silent, read timeouts need heartbeats
+shorter than them (Connection.EnableHeartbeat).
+
+Connections and services can also be configured upfront, instead of through
+setters after the fact: iris.Connect and iris.Register accept optional
+functional options (iris.WithLogger, iris.WithTLS, iris.WithConnectOptions,
+iris.WithTunnelConfig, iris.WithTunnelBuffer, iris.WithRetry,
+iris.WithReconnect, iris.WithResubscribe, iris.WithHeartbeat, iris.WithTracer,
+iris.WithAudit, iris.WithFrameDump, iris.WithPayloadCodec), which assemble an
+iris.Config applied before any traffic flows. A structured config (e.g. loaded
+from a file) can be passed whole via iris.WithConfig. The dedicated entry points
+(iris.ConnectTLS, iris.ConnectUnix, iris.ConnectWithOptions,
+iris.ConnectFailover and their Register counterparts) are mere shorthands for
+the corresponding options or transports of iris.ConnectTransport and
+iris.RegisterTransport, assembling the same configuration.
+
+    conn, err := iris.Connect(55555,
+      iris.WithLogger(logger),
+      iris.WithTunnelBuffer(16 * 1024 * 1024),
+      iris.WithRetry(nil), // default retry policy
+    )
+
+Payloads may also be transformed uniformly across all messaging primitives, e.g.
+for transparent compression, encryption or schema envelope injection, by
+configuring an iris.PayloadCodec once per connection via iris.WithPayloadCodec.
+Its Encode method is applied to every outbound broadcast, request, reply, event
+and tunnel message right before it leaves for the relay, and Decode to every
+inbound one before any other processing. Inbound messages failing to decode are
+dropped, and such requests are failed back with iris.CodeInvalidArgument. As the
+relay only ever sees the encoded payloads, all the connections exchanging
+messages need to use the same codec. As codecs work on whole messages, tunnels of
+such connections don't stream their inbound messages chunk by chunk.
+
+During the attachment, the relay advertises the highest protocol version it
+supports. Relays speaking an incompatible major version are refused right away
+with iris.ErrIncompatibleRelay, instead of failing later on unknown packets. The
+advertised version and the optional capabilities derived from it are available
+via Connection.RelayVersion and Connection.RelayFeatures (an iris.RelayFeatures
+bitmask), letting applications enable newer features (e.g.
+iris.FeatureLargeChunks for oversized tunnel chunks) only when the relay supports
+them.
+
+A service may also be a member of multiple clusters at once (e.g. an old and a
+new name during a migration) by registering through iris.RegisterGroup with a
+shared or per-cluster handler. Since the relay protocol binds each link to a
+single cluster, the group still maintains one relay link per cluster, but
+manages them as a single unit.
+
+Closing a connection aborts all its outstanding operations. To shut down without
+losing work, Connection.Shutdown first stops accepting inbound messages and waits
+(up to a timeout) for the pending requests, running handlers and in-flight tunnel
+sends to finish before tearing down the link. Services can do the same via
+Service.Drain, which additionally waits for their inbound tunnels to close;
+requests arriving meanwhile are rejected with iris.ErrDraining, so requesters may
+retry them elsewhere.
+
+Application components may react to a tear-down (flushing state, triggering a
+failover) without inspecting the errors of every blocking call by registering
+callbacks via Connection.OnClose and Tunnel.OnClose. They are invoked once, on a
+separate goroutine, with a nil reason after a graceful close, or the failure
+that dropped the connection or tunnel otherwise (e.g. iris.ErrPeerDead for
+unresponsive tunnel peers). Connections with automatic reconnection only report
+a drop after giving up. Until then, pending requests and open tunnels are not
+replayed onto the restored link, as the remote side might have already acted on
+them: they fail with iris.ErrClosed as soon as the link drops, leaving any
+retries to the application.
+
+Edge devices with intermittent connectivity to their local relay may keep
+publishing while the link is down: with automatic reconnection enabled
+(Connection.EnableReconnect), Connection.EnableOutbox queues the broadcasts and
+publishes issued meanwhile into a bounded file (see iris.OutboxConfig) and
+replays them in order once the link is restored. Messages still queued when the
+process exits are replayed when the outbox is next enabled; those exceeding its
+limits fail with iris.ErrOutboxFull.
+
+Subscriptions are restored after every reconnect, and each one's progress can be
+checked via Connection.SubscriptionStatus, reporting whether the topic is
+pending (awaiting a restored link), active on the relay or failed, along with
+the failed attempts and the last error. A subscribe or restore failing to reach
+the relay would otherwise leave the application silently missing the topic: with
+Connection.EnableResubscribe (or iris.WithResubscribe), such subscriptions are
+retained, Subscribe returns nil, and a background reconciler keeps retrying them
+with exponential backoff (see iris.ResubscribePolicy) until they are active
+again or the attempts run out.
+
 Messaging through Iris
 
 Iris supports four messaging schemes: request/reply, broadcast, tunnel and
@@ -154,6 +278,158 @@ under the addressed name.
       fmt.Printf("reply arrived: %v.", string(reply))
     }
 
+Aggregators querying several clusters at once can issue the same request to all
+of them in parallel via Connection.RequestAll, which waits for every cluster and
+returns the reply or failure of each as an iris.ClusterReply.
+Connection.RequestQuorum returns as soon as enough clusters replied successfully,
+abandoning the stragglers, or fails with iris.ErrNoQuorum if too many requests
+failed for the quorum to be reached.
+
+Whereas requests are answered by a single member, Connection.BroadcastGather
+queries all members of a cluster, broadcasting a message and collecting the
+voluntary replies arriving within a time window - the building block of leader
+elections and distributed queries. Members answer by implementing
+iris.GatherHandler (returning nil to stay silent), whereas others receive the
+message as a plain broadcast. The replies travel back on a private topic of the
+gatherer, whose nonce members on older bindings receive prefixed to the message,
+never replying.
+
+Dependent services can follow the membership of a cluster via
+Connection.WatchCluster, which notifies a handler with an iris.MembershipEvent
+whenever a member joins or leaves (along with the resulting member count, e.g.
+to alert below a minimum capacity), and lists the live members via
+ClusterWatch.Members. As the relay does not expose the cluster memberships, only
+services calling Service.Announce are tracked: they announce their presence
+periodically on a companion topic, bid farewell when unregistering, and are
+deemed gone after three missed announcements. Services on older bindings never
+announce, and thus never show up.
+
+Monitoring systems can probe every service uniformly, without each one
+implementing a custom status request: the binding of a registered service
+answers the reserved health probes issued via Connection.ProbeHealth on its own,
+bypassing the request handler and its queue. The returned iris.ServiceHealth
+report (JSON encoded on the wire) carries the liveness, the readiness (false
+while draining, with a degraded relay link or a full request queue, or if the
+handler implementing iris.ReadinessHandler reports an error), the build info of
+the binary and the depths of the handler queues. The probes are a magic request
+payload, which services on older bindings pass to their request handler instead,
+failing the probe with a malformed report; Connection.SetHealthProbe passes them
+to the handler instead.
+
+Published events may optionally be wrapped into envelopes carrying the publish
+time, the publisher's cluster and id, a sequence number and a content type,
+either per event via Connection.PublishEnvelope or for all publishes via
+Connection.SetEnvelopePublish. Topic handlers implementing iris.MetaTopicHandler
+receive the metadata as an iris.Event, whereas plain ones only see the payload.
+Subscribers on older bindings receive the payload with the envelope prefix still
+attached.
+
+For config and state topics, late joiners usually need the current value right
+away: events published via Connection.PublishRetained are retained by the
+publisher as the topic's last value, and replayed to subscribers setting Replay
+in their iris.TopicLimits as soon as they subscribe. As the relay does not
+retain events itself, the publishing connection needs to stay alive to answer
+the replay queries. The replays reach all subscribers of the topic, so the ones
+on older bindings receive them as events, replay header included.
+Connection.ClearRetained drops the retained value.
+
+When a subscriber fails to process an event, the events preceding it are often
+the key to the failure. Setting RecentEvents in the iris.TopicLimits of a
+subscription retains the last that many arrived events (payload, concrete topic
+and arrival time) in a replay buffer, retrievable oldest first via
+Connection.RecentEvents, or decoded via Topic.Recent for typed topics, so they
+can be inspected or logged along with the error without external capture
+tooling. Events are recorded on arrival, whether or not they reached the handler
+afterwards.
+
+Reminders and deferred retries can be published via Connection.PublishAfter,
+which schedules the event client side and returns an iris.ScheduledPublish handle
+whose Cancel method revokes it while still pending. Scheduled events are dropped
+if the connection is closed before they become due.
+
+Events that lose their value with age, such as telemetry, can be published via
+Connection.PublishTTL (and broadcasts sent via Connection.BroadcastTTL) with a
+time-to-live: recipients discard them instead of handling them if they are still
+sitting in the local delivery queue (e.g. behind a backlog or a paused
+subscription) once expired, counting them among the dropped messages. The
+current relay protocol cannot carry the expiration, so it is prefixed to the
+message (older recipient bindings deliver it along with the payload, never
+expiring it), and is measured against the wall clock, requiring the clocks of
+the sender and the recipients to be reasonably in sync.
+
+A consumer busy with a long maintenance operation may hold back the delivery of
+a subscription via Connection.PauseSubscription instead of unsubscribing,
+retaining its topic membership. Events arriving meanwhile are queued within the
+subscription's limits (with the overflow policy applying to the excess, except
+that blocking drops them instead) and handed to the handler once
+Connection.ResumeSubscription is called. Typed topics expose the same via
+Topic.Pause and Topic.Resume.
+
+Handlers run concurrently by default, so messages may be handled out of order.
+Where only related messages need ordering (e.g. the updates of a single order),
+an ordering key can be extracted from each message via TopicLimits.OrderKey (or
+ServiceLimits.BroadcastOrderKey for broadcasts): messages sharing a key are
+handled one at a time in arrival order, while those of different keys still
+proceed concurrently within the thread limits. A message waiting for its
+predecessors doesn't occupy a handler thread, so a slow key never holds back the
+others.
+
+    limits := &iris.TopicLimits{
+      OrderKey: func(event []byte) string { return orderID(event) },
+    }
+    conn.Subscribe("orders", handler, limits)
+
+Internal event buses exchanging structured events can use an iris.Topic instead
+of marshalling the payloads by hand: it encodes the published values and decodes
+the delivered ones through an iris.Codec (iris.JSONCodec, iris.GobCodec or the
+ones in the iriscodec subpackage), dropping (and logging) events that fail to
+decode.
+
+    orders := iris.NewTopic[Order](conn, "orders", iris.JSONCodec)
+    orders.Subscribe(func(order Order) { fmt.Println("new order:", order.ID) }, nil)
+    orders.Publish(Order{ID: 42})
+
+Instead of a monolithic HandleRequest switch, a service may expose many logical
+endpoints through an iris.Router: handlers are registered for named methods, and
+the router (embedded into, or called from the service handler) dispatches the
+requests issued via Connection.Call.
+
+    router := iris.NewRouter()
+    router.Handle("upper", func(ctx context.Context, req []byte) ([]byte, error) {
+      return bytes.ToUpper(req), nil
+    })
+    reply, err := conn.Call("echo", "upper", []byte("hello"), time.Second)
+
+Small services can skip the byte level handling altogether by registering plain
+Go functions via the generic iris.Handle: requests are decoded and replies
+encoded with the router's codec (iris.JSONCodec unless changed via
+Router.SetCodec), requests implementing iris.RequestValidator are validated
+before reaching the handler, and failures are mapped to structured errors
+(iris.CodeInvalidArgument for undecodable or invalid requests,
+iris.CodeUnavailable for expired contexts). Callers issue such calls via
+iris.CallTyped.
+
+    type Sum struct{ A, B int }
+
+    router := iris.NewRouter()
+    iris.Handle(router, "add", func(ctx context.Context, req Sum) (int, error) {
+      return req.A + req.B, nil
+    })
+    reply, err := iris.CallTyped[Sum, int](conn, iris.JSONCodec, "calc", "add", Sum{1, 2}, time.Second)
+
+Streamed requests are routed alike by an iris.StreamRouter, passed to
+iris.ServeStream from the HandleTunnel callback, with the calls issued via
+Connection.CallStream. Teams keeping their service definitions in protocol
+buffers may generate typed client stubs and server interfaces on top of the two
+routers with the protoc-gen-iris plugin (unary and server streaming methods are
+supported):
+
+    go install gopkg.in/project-iris/iris-go.v1/cmd/protoc-gen-iris
+    protoc --go_out=. --iris_out=. greeter.proto
+
+    client := greeter.NewGreeterClient(conn, "greeter")
+    reply, err := client.SayHello(ctx, &greeter.HelloRequest{Name: "Iris"})
+
 An expanded summary of the supported messaging schemes can be found in the core
 concepts [http://iris.karalabe.com/book/core_concepts] section of the book of
 Iris [http://iris.karalabe.com/book]. A detailed presentation and analysis of
@@ -177,21 +453,48 @@ failed locally or remotely, all remote errors are wrapped in an iris.RemoteError
 type.
 
     _, err := conn.Request("cluster", request, timeout)
-    switch err {
-      case nil:
+    var rerr *iris.RemoteError
+    switch {
+      case err == nil:
         // Request completed successfully
-      case iris.ErrTimeout:
+      case errors.Is(err, iris.ErrTimeout):
         // Request timed out
-      case iris.ErrClosed:
+      case errors.Is(err, iris.ErrClosed):
         // Connection terminated
+      case errors.As(err, &rerr):
+        // Request failed remotely, rerr.Reason holds the cause
       default:
-        if _, ok := err.(*iris.RemoteError); ok {
-          // Request failed remotely
-        } else {
-          // Requesting failed locally
-        }
+        // Requesting failed locally
     }
 
+Handlers may also fail with a structured iris.Error, carrying a machine readable
+code (e.g. iris.CodeNotFound) and optional details along with the message. These
+are delivered in the Code and Details fields of the requester's
+iris.RemoteError, whereas requesters on older bindings get them encoded into the
+fault reason.
+
+    // Service side
+    return nil, &iris.Error{Code: iris.CodeNotFound, Message: "no such user"}
+
+    // Client side
+    var rerr *iris.RemoteError
+    if errors.As(err, &rerr) && rerr.Code == iris.CodeNotFound {
+      // Handle the missing entity
+    }
+
+Local failures are classified too, always to be checked with errors.Is as they
+may carry extra context. Invalid arguments (empty identifiers, malformed
+patterns, bad limits) match iris.ErrInvalidArgument, misbehaving remote peers
+iris.ErrProtocolViolation, and relay handshake failures iris.ErrConnectionDenied
+or iris.ErrConnectionDropped. Subscription bookkeeping is reported via
+iris.ErrAlreadySubscribed and iris.ErrNotSubscribed. Some timeouts are refined
+further while still matching iris.ErrTimeout: iris.ErrTunnelBuildTimeout if the
+remote endpoint failed to accept a tunnel in time, and
+iris.ErrAllowanceExhausted if a tunnel send was blocked waiting for the receiver
+to free up buffer space. Note, this is a breaking change for callers comparing
+the errors of Connection.Tunnel and Tunnel.Send against iris.ErrTimeout with ==,
+which need to switch to errors.Is.
+
 Resource capping
 
 To prevent the network from overwhelming an attached process, the binding places
@@ -216,29 +519,222 @@ fields (i.e. value of zero) will default to the preset ones.
     var defaultTopicLimits = TopicLimits{
       EventThreads: 4 * runtime.NumCPU(),
       EventMemory:  64 * 1024 * 1024,
+      AckWindow:    30 * time.Second,
+      AckBuffer:    1024,
+      BlockTimeout: 5 * time.Second,
+      BlockBacklog: 1024,
+    }
+
+Subscriptions may additionally cap the number of pending events via the
+TopicLimits.EventQueue field and pick what happens to events exceeding the queue
+allowance via TopicLimits.Overflow: drop the arriving event (OverflowDropNewest,
+the default), evict the oldest pending ones (OverflowDropOldest), hold back the
+arriving event until a handler catches up (OverflowBlock) or hand the event to
+the TopicLimits.OnOverflow callback (OverflowCallback). A blocking subscription
+holds back only its own deliveries, admitting them one by one in arrival order,
+while the rest of the connection's traffic flows on. As the relay cannot be
+pushed back on, the hold-back is bounded: events waiting longer than
+TopicLimits.BlockTimeout since their arrival, or beyond the
+TopicLimits.BlockBacklog held back ones, are dropped.
+
+Subscription handlers implementing iris.AckTopicHandler switch to at-least-once
+delivery: instead of HandleEvent, they receive each event via HandleDelivery and
+have to call Ack on it within TopicLimits.AckWindow. Unacked events (e.g. of a
+crashed or stuck handler) are redelivered locally with an incremented Attempt
+until acked or until TopicLimits.AckAttempts runs out. At most
+TopicLimits.AckBuffer unacked events are retained, the oldest ones being dropped
+beyond that. Since redelivery is local, events lost before reaching the
+subscription are not recovered.
+
+    func (h *handler) HandleDelivery(delivery *iris.Delivery) {
+      if err := h.store(delivery.Event); err == nil {
+        delivery.Ack()
+      }
+    }
+
+Services may likewise cap the number of pending requests via the
+ServiceLimits.RequestQueue field. By default, requests exceeding the queue or
+memory allowance are silently dropped, leaving the requester to time out.
+Setting ServiceLimits.RejectOverload fails them back right away instead with an
+iris.RemoteError of code iris.CodeUnavailable matching iris.ErrOverloaded via
+errors.Is, so overloaded services degrade predictably and requesters may retry
+elsewhere.
+
+To keep urgent traffic such as health checks and control commands from getting
+stuck behind thousands of bulk queries during overload, services may classify
+their inbound requests via the ServiceLimits.RequestPriority callback,
+inspecting the payload and the caller's identity and correlation ID found in its
+context. Requests classified as iris.PriorityHigh are handled ahead of the
+queued normal ones, in arrival order among themselves, and are counted against
+ServiceLimits.RequestQueue separately, so a queue full of bulk requests doesn't
+shed them. The callback runs on the network receiver, so it should return
+swiftly:
+
+    limits := &iris.ServiceLimits{
+      RequestPriority: func(ctx context.Context, req []byte) iris.Priority {
+        if bytes.HasPrefix(req, []byte("health")) {
+          return iris.PriorityHigh
+        }
+        return iris.PriorityNormal
+      },
     }
 
-There is also a sanity limit on the input buffer of a tunnel, but it is not
-exposed through the API as tunnels are meant as structural primitives, not
-sensitive to load. This may change in the future.
+The pending broadcasts, requests, events and inbound tunnel messages are buffered
+in linked queues by default, allocating as they grow. At high message rates, the
+queues may be replaced through the Queue field of the service, topic and tunnel
+limits with any iris.Queue implementation, such as the ring buffer of
+iris.NewRingQueue with a preallocated capacity and an optional bound on the number
+of items. Messages arriving at a full bounded queue are treated as exceeding the
+corresponding allowance (and are dropped, or rejected with iris.ErrOverloaded).
+
+    limits := &iris.TopicLimits{
+      Queue: func() iris.Queue { return iris.NewRingQueue(1024, 65536) },
+    }
+
+Services receiving broadcasts at high rates may have them delivered in batches by
+implementing the optional iris.BatchBroadcastHandler interface and setting the
+ServiceLimits.BroadcastBatch field. Arriving broadcasts are then collected for up
+to ServiceLimits.BroadcastWindow (1ms by default) or until the batch fills up,
+and handed to HandleBroadcastBatch in one go, sparing the per message scheduling
+and locking of the handler. Batched broadcasts bypass the inbound interceptors and
+tracing.
+
+To protect against memory exhaustion by oversized or malformed payloads, a
+connection may cap the size of its inbound broadcasts, requests, events and
+tunnel messages, and vet them with a validator callback via
+Connection.SetMessageLimits. Rejected messages are dropped before being queued
+for the handlers, and rejected requests are failed back to the caller with
+iris.CodeInvalidArgument. Tunnel messages are size checked upon arrival of their
+first chunk, before any of them is buffered.
+
+Tunnels similarly have a limit on their input buffer (64MB by default) and may
+optionally use a smaller outbound chunk size than the one imposed by the relay.
+Both can be overridden via iris.TunnelConfig, either per connection through
+Connection.SetTunnelConfig (also affecting inbound tunnels of a service) or for
+a single outbound tunnel through Connection.TunnelWithConfig. The same config
+also lists the compression algorithms (gzip built in, snappy and zstd via the
+iriscompress package) to negotiate with the remote endpoint, transparently
+compressing the tunnel traffic if both sides agree. Short control messages may
+be sent via Tunnel.SendPriority with iris.PriorityHigh, letting them jump ahead
+of the remaining chunks of a large in-flight message (older remote bindings take
+such a frame for a new message, discarding the interrupted one). Sends are safe
+for concurrent use: the chunks of different messages never interleave, so each
+arrives whole and a message whose send fails midway is discarded remotely, while
+Tunnel.SendStream holds back the concurrent sends until its transfer completes.
+High rate consumers may avoid a fresh allocation per message by receiving via
+Tunnel.RecvInto into their own buffer, or via Tunnel.RecvPooled, releasing each
+payload after use. Messages too large to buffer whole can be consumed chunk by
+chunk as they arrive via Tunnel.RecvChunks, if StreamChunks is enabled in the
+config (the whole message receives then fail with iris.ErrChunked on them);
+ChunkOverride additionally lets the ChunkLimit exceed the relay's advertised
+one, for relays known to accept larger chunks. Setting the KeepAlive period of
+the config makes idle tunnels probe their peer, closing the tunnel with
+iris.ErrPeerDead after KeepAliveMisses unanswered probes (older remote bindings
+deliver the probes as data instead of answering them). Tunnels leaked by sloppy
+callers can be reclaimed by setting an IdleTimeout, closing the tunnel with
+iris.ErrIdleClosed once no message was sent or received for that long (keepalive
+probes don't count). The memory held by the messages being assembled can be
+bounded too: AssembleLimit drops the inbound messages too large to assemble, and
+AssembleTimeout discards a partially arrived message (granting back its buffer
+space) if its sender stalls mid-transfer, e.g. because it died. Tunnel traffic
+may also be encrypted end-to-end with AES-GCM, hiding the payloads from the
+relays: configure a Key or a KeyExchange callback in the config, or call
+Tunnel.Secure on an already built tunnel (e.g. in HandleTunnel). Both ends need
+to be secured with the same key, and a secured end drops any unencrypted data
+arriving before its peer switched over too, so that the relays cannot inject
+plaintext into the stream.
+
+Consumers may also look at the inbound messages before committing to a receive,
+e.g. to make batching decisions: Tunnel.Pending reports the number of messages
+buffered and ready, Tunnel.Peek returns the next one along with its metadata
+without consuming it, and Tunnel.TryRecv retrieves it without blocking. Both
+fail with iris.ErrNoMessage if nothing is buffered.
+
+Stream protocols such as HTTP, gRPC or SSH can run over tunnels unmodified
+through the standard net interfaces: iris.Listen returns a net.Listener
+accepting the inbound tunnels of a service connection as net.Conns (instead of
+handing them to HandleTunnel), and iris.Dial or iris.DialContext open an
+outbound tunnel to a cluster as a net.Conn. Writes are sent as tunnel messages
+and reads consume them as a byte stream, with deadlines surfacing as standard
+timeouts and a closed remote side as io.EOF:
+
+    listener, err := iris.Listen(h.conn) // connection passed to the handler's Init
+    if err != nil {
+      log.Fatalf("failed to listen: %v.", err)
+    }
+    go http.Serve(listener, mux)
+
+    httpc := &http.Client{Transport: &http.Transport{
+      DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+        return iris.DialContext(ctx, client, "http-cluster")
+      },
+    }}
+
+Large transfers need not restart from zero after a transient failure either:
+Tunnel.SendResumable streams a seekable reader as a transfer identified by an
+ID, to which the receiver, via Tunnel.RecvResumable, replies with the number of
+bytes it already stored, so only the rest is sent. Calling it again with the
+same ID over a new tunnel after a failure resumes from where the previous
+attempt stopped. Tunnel.SendFileResumable and Tunnel.RecvFileResumable do the
+same for files, the latter appending to a file named after the transfer ID
+within a directory and keeping the partial file around on failure.
+
+Messages may carry small metadata - a content type and a header map, at most 4KB
+encoded as an iris.MessageMeta - letting receivers route or deserialize them
+without peeking into the payload. The metadata is attached via Tunnel.SendMeta
+and travels in band at the head of the message (compressed and encrypted along
+with it), surfacing on the remote side via Tunnel.RecvMeta or in the Meta field
+of pooled payloads, whereas the other receives return the bare payload.
+
+Bulk workloads opening a tunnel per logical exchange pay the tunnel construction
+round trip every time. An iris.TunnelPool, created via Connection.NewTunnelPool,
+keeps warm tunnels to a cluster instead: Get checks one out (building a fresh one
+only if none is idle) and Put returns it for reuse after the exchange. The remote
+handler needs to serve multiple exchanges per tunnel in a loop, and tunnels that
+failed midway should be closed before being put back, so the pool replaces them.
+
+In the opposite direction, Connection.EnableRateLimits caps the outbound
+request, broadcast, publish and tunnel data rates of a connection with token
+buckets configured via iris.RateLimits, so a misbehaving component cannot
+saturate the relay link. Operations exceeding their rate block until tokens
+accumulate, their timeout expires or their context is cancelled. Individual
+tunnels may further be shaped via the SendRate field of iris.TunnelConfig or
+Tunnel.SetSendRate, limiting their outbound bytes per second (with a burst) on
+top of the connection wide TunnelData cap, so background bulk transfers don't
+starve the latency sensitive requests sharing the relay link. Producers may also
+shed load when the link itself cannot keep up: Connection.TryPublish fails with
+iris.ErrCongested if too many packets are waiting for the relay link (see
+Connection.SetCongestionLimit), whereas Connection.PublishTimeout waits a
+bounded time for the congestion to clear.
+
+At high message rates, the per-packet socket writes themselves may become the
+bottleneck. Connection.SetFlushPolicy enables Nagle-style send batching via
+iris.FlushPolicy: outbound packets (requests, replies, publishes, tunnel data and
+allowances) are held back until either the flush window elapses (100µs by
+default) or enough data accumulates (64KB by default), trading a bit of latency
+for fewer, larger writes.
 
 Logging
 
-For logging purposes, the Go binding uses inconshreveable's [https://github.com/inconshreveable]
-log15 [https://github.com/inconshreveable/log15] library (version v2). By default,
-INFO level logs are collected and printed to stderr. This level allows tracking
-life-cycle events such as client and service attachments, topic subscriptions and
-tunnel establishments. Further log entries can be requested by lowering the level
-to DEBUG, effectively printing all messages passing through the binding.
+For logging purposes, the Go binding defines a small iris.Logger interface, by
+default backed by the standard library's log/slog package. By default, INFO level
+logs are collected and printed to stderr. This level allows tracking life-cycle
+events such as client and service attachments, topic subscriptions and tunnel
+establishments. Further log entries can be requested by lowering the level to
+DEBUG, effectively printing all messages passing through the binding.
 
-The binding's logger can be fine-tuned through the iris.Log variable. Below are
-a few common configurations.
+The binding's logger can be replaced through the iris.Log variable (before any
+connections are made). Adapters for log15, zap and logrus are available in the
+irislog subpackage. Below are a few common configurations.
 
     // Discard all log entries
-    iris.Log.SetHandler(log15.DiscardHandler())
+    iris.Log = iris.NewDiscardLogger()
 
     // Log DEBUG level entries to STDERR
-    iris.Log.SetHandler(log15.LvlFilterHandler(log15.LvlDebug, log15.StderrHandler))
+    iris.Log = iris.NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+    // Route the entries into an existing zap logger
+    iris.Log = irislog.Zap(logger)
 
 Each iris.Connection, iris.Service and iris.Tunnel has an embedded logger, through
 which contextual log entries may be printed (i.e. tagged with the specific id of
@@ -257,15 +753,208 @@ As you can see below, all log entries have been automatically tagged with the
 log level is INFO, the conn.Log.Debug invocation has no effect. Additionally,
 arbitrarily many key-value pairs may be included in the entry.
 
-    INFO[06-22|18:39:49] connecting new client                    client=1 relay_port=55555
-    INFO[06-22|18:39:49] client connection established            client=1
-    INFO[06-22|18:39:49] info entry, client context included      client=1
-    WARN[06-22|18:39:49] warning entry                            client=1 extra="some value"
-    CRIT[06-22|18:39:49] critical entry                           client=1 bool=false int=1 string=two
-    INFO[06-22|18:39:49] detaching from relay                     client=1
+    time=2014-06-22T18:39:49.012+02:00 level=INFO msg="connecting new client" client=1 relay=tcp://localhost:55555
+    time=2014-06-22T18:39:49.013+02:00 level=INFO msg="client connection established" client=1
+    time=2014-06-22T18:39:49.013+02:00 level=INFO msg="info entry, client context included" client=1
+    time=2014-06-22T18:39:49.013+02:00 level=WARN msg="warning entry" client=1 extra="some value"
+    time=2014-06-22T18:39:49.013+02:00 level=ERROR+4 msg="critical entry" client=1 bool=false int=1 string=two
+    time=2014-06-22T18:39:49.014+02:00 level=INFO msg="detaching from relay" client=1
+
+Debug logging prints every message passing through the binding, which on busy
+connections and tunnels may easily cost more than the messaging itself. Wrapping
+the logger via iris.NewSampledLogger bounds this cost: per message and interval,
+only the first few debug entries are logged, and every n-th afterwards (see
+iris.LogSampling). Sampled out entries never reach the wrapped logger, so their
+payload dumps are not even formatted. Warnings and above are never sampled.
+
+    handler := slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelDebug})
+    iris.Log = iris.NewSampledLogger(iris.NewSlogLogger(slog.New(handler)), &iris.LogSampling{
+      Interval:   time.Second, // Reset the counts every second
+      Initial:    100,         // Log the first 100 entries of each message as is
+      Thereafter: 1000,        // Log every 1000th afterwards
+    })
+
+Metrics
+
+Each connection gathers a few operational metrics - request counts and latencies,
+broadcast and publish rates, tunnel throughput, dropped messages and handler pool
+saturation - a snapshot of which can be retrieved through Connection.Metrics. A
+ready-made Prometheus collector is available in the irisprom subpackage.
+
+    conn, _ := iris.Connect(55555)
+    prometheus.MustRegister(irisprom.NewCollector(conn, "myapp", nil))
+
+The request statistics are also broken down by destination cluster via
+Connection.ClusterStats: request counts, failures, timeouts and a latency
+histogram for each cluster, so a degrading downstream service stands out without
+external tracing (the Prometheus collector exports them with a cluster label):
+
+    for cluster, stats := range conn.ClusterStats() {
+      log.Printf("%s: %.2f%% errors", cluster, 100*stats.ErrorRate())
+    }
+
+For diagnosing leaks or stalls, Connection.DebugSnapshot dumps the current
+internal bookkeeping - pending requests, subscriptions, live tunnels, relay socket
+and handler queue lengths - which can also be exported through the standard
+expvar package. Monitoring agents embedded into the application may query the
+same state piecemeal via Connection.Subscriptions, Connection.LiveTunnels and
+Connection.ClusterName.
+
+    expvar.Publish("iris", conn.Expvar())
+
+Stuck request handlers can be pinpointed without attaching a debugger by enabling
+the watchdog of the service via Service.SetRequestWatchdog: handlers running
+longer than the threshold have the stack trace of their goroutine logged as a
+warning while they're still running, and are counted in the RequestsSlow metric.
+
+Framing mismatches between the binding and the relay (e.g. after a protocol
+version bump) can be chased down at the wire level: Connection.SetFrameDump
+dumps every relay protocol frame sent or received by the connection, decoded
+into its type, fields (ids, sizes, flags) and hex previews of the payloads, as
+an iris.Frame to a callback, or as text lines to a writer via
+iris.NewFrameWriter. Frames cut short by a parse failure are dumped too. To
+capture the initialization handshake as well, enable it upfront via
+iris.WithFrameDump.
+
+    conn, err := iris.Connect(55555, iris.WithFrameDump(iris.NewFrameWriter(os.Stderr)))
+
+Tracing
+
+Distributed traces can be continued through broadcasts, requests, publishes and
+tunnels by setting an iris.Tracer on the connection. The trace headers are
+prefixed to the messages (tunnels open with a header-only message instead),
+which recipients on older bindings see as part of the payload. They are surfaced
+to handlers implementing the optional iris.ContextBroadcastHandler,
+iris.ContextRequestHandler and iris.ContextTopicHandler interfaces, or through
+Tunnel.Context. The request contexts additionally expire along with the
+requester's timeout, so handlers can abandon work nobody waits for anymore. An
+OpenTelemetry based tracer is available in the irisotel subpackage.
+
+    conn.SetTracer(irisotel.NewTracer(nil, nil))
+
+For lighter integrations (e.g. reporting latencies and sizes to an APM agent)
+without adopting a full tracer, Connection.SetHooks installs plain callbacks
+invoked around the individual operations: request start and end, tunnel open
+and close, publishes and processed event deliveries. The callbacks receive the
+timing, payload sizes (tunnels report their total bytes sent and received) and
+outcome of the operation, and run synchronously, so they should return swiftly.
+
+    conn.SetHooks(&iris.Hooks{
+      OnRequestEnd: func(info *iris.RequestInfo) {
+        log.Printf("request to %s took %v: %v", info.Cluster, info.Elapsed, info.Err)
+      },
+    })
+
+Services applying per-caller policies, quotas or audit logs can learn who
+initiated a request or tunnel, provided the caller enabled
+Connection.SetAdvertiseIdentity: the cluster and connection identifier of the
+initiator are then prefixed to requests and sent ahead of the tunnel data (older
+remote bindings deliver them to the application as is), and surfaced as an
+iris.Peer via iris.PeerFromContext in iris.ContextRequestHandler
+implementations, or via Tunnel.Peer once the first message of an inbound tunnel
+arrived. The identity is self-reported and thus not a substitute for
+authentication.
+
+To join the client and server log lines of a single request across machines,
+requests may carry a correlation identifier: either attached explicitly to the
+request context via iris.WithCorrelationID (see iris.NewCorrelationID), or
+generated for every request once Connection.SetRequestCorrelation is enabled.
+The identifier is prefixed to the request (older serving bindings hand it to the
+handler along with the request), injected into the binding logs of both sides,
+surfaced via iris.CorrelationFromContext in iris.ContextRequestHandler
+implementations, and returned to the caller via Future.CorrelationID or the
+CorrelationID field of an iris.RemoteError.
+
+As retried and hedged requests share their correlation identifier, services can
+use it to make mutating endpoints safe to retry: Connection.EnableDedup answers
+the repeated deliveries of a request from an LRU cache of recent replies (see
+iris.DedupConfig) instead of executing them again, with deliveries arriving
+while the first one is still running waiting for its outcome. Failed requests
+are not cached.
+
+Clients repeatedly issuing read-mostly lookups (e.g. hot configuration or
+metadata queries) can cut the redundant round trips on their side: after
+Connection.EnableRequestCache, requests issued via Connection.RequestCached are
+answered from an LRU cache of recent replies keyed by the cluster and the request
+contents, bounded in entries, memory and age (see iris.RequestCacheConfig).
+Concurrent lookups of a request not yet cached share a single round trip, and
+failed requests are not cached.
+
+Compliance-heavy deployments can keep an audit log of everything a service was
+asked to do: Connection.SetAuditSink (or iris.WithAudit) records every inbound
+request and tunnel as an iris.AuditRecord, carrying the caller's cluster and
+connection identifier (if advertised), the correlation identifier, the payload
+size, the latency and the result code. Requests are recorded once replied to,
+including the ones rejected or shed before reaching the handler, and tunnels
+once torn down. Records can be consumed by a plain callback (iris.AuditFunc), or
+written as JSON lines into a file or the system log by the sinks of the
+irisaudit subpackage.
+
+    sink, err := irisaudit.NewFileSink("/var/log/echo/audit.log")
+    if err != nil {
+      log.Fatalf("failed to open audit log: %v.", err)
+    }
+    defer sink.Close()
+
+    service, err := iris.Register(55555, "echo", new(EchoHandler), nil, iris.WithAudit(sink))
+
+Interceptors
+
+Cross-cutting concerns such as auth tokens, auditing or payload transformation
+can be injected through iris.Interceptor chains wrapping all outbound operations
+(Connection.SetOutboundInterceptors) and inbound handler dispatches
+(Connection.SetInboundInterceptors) of a connection. Each interceptor may modify
+the operation before passing it on to the next one, or short circuit it.
+
+    conn.SetOutboundInterceptors(func(ctx context.Context, op iris.TraceOp, target string, payload []byte, next iris.Invoker) ([]byte, error) {
+      log.Printf("%s to %s", op, target)
+      return next(ctx, op, target, payload)
+    })
+
+Services wanting the more familiar HTTP style can wrap just their request
+handler with an iris.RequestMiddleware chain via Service.Use, the middleware
+executing in the order added (within the inbound interceptors).
+
+    serv.Use(func(next iris.RequestFunc) iris.RequestFunc {
+      return func(ctx context.Context, req []byte) ([]byte, error) {
+        start := time.Now()
+        defer func() { log.Printf("request served in %v", time.Since(start)) }()
+        return next(ctx, req)
+      }
+    })
+
+Panics raised by the handlers or inbound interceptors are recovered and logged
+instead of crashing the process, failing requests with a remote error. A
+Connection.SetRecoveryHook callback can additionally report the incidents and
+translate them into custom errors.
+
+Testing
+
+Applications can unit test their handlers, tunnels and subscriptions without
+running a real Iris node via the in-process fake relay of the iristest package,
+which also supports injecting dropped messages, delays and forced disconnects.
+
+    relay, _ := iristest.NewRelay(0)
+    defer relay.Close()
+
+    conn, _ := iris.Connect(relay.Port())
+
+Misbehaving relays can also be simulated on the client side, against any relay:
+building with the irisfaults tag (e.g. go test -tags irisfaults) compiles in
+Connection.SetFaults. It drops a share of the inbound broadcasts, requests,
+replies and events, delays inbound messages, truncates inbound tunnel chunks or
+force closes tunnels. Regular builds contain no-op hooks only:
+
+    conn.SetFaults(&iris.Faults{DropRate: 0.1, Delay: 10 * time.Millisecond, TunnelCloseRate: 0.01})
+
+Deployments can be validated (and performance regressions caught) with the
+iris-bench tool, driving request, publish or tunnel workloads against a relay and
+reporting the throughput and latency percentiles. By default it also serves the
+workload itself. With -serve=false, the serving side is left to other instances
+started with -mode serve:
 
-For further capabilities, configurations and details about the logger, please
-consult the log15 docs [https://godoc.org/github.com/inconshreveable/log15].
+    go install gopkg.in/project-iris/iris-go.v1/cmd/iris-bench
+    iris-bench -relay 55555 -mode request -workers 64 -duration 30s -size 1024
 
 Additional goodies
 
@@ -0,0 +1,109 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Service handler for the drain tests, servicing requests slowly.
+type drainTestHandler struct {
+	conn    *Connection
+	arrived chan struct{}
+}
+
+func (d *drainTestHandler) Init(conn *Connection) error { d.conn = conn; return nil }
+func (d *drainTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (d *drainTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (d *drainTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (d *drainTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	d.arrived <- struct{}{}
+	time.Sleep(250 * time.Millisecond)
+	return req, nil
+}
+
+// Tests that draining a service finishes in-flight requests but rejects new ones.
+func TestServiceDrain(t *testing.T) {
+	// Register a new slow service to the relay
+	handler := &drainTestHandler{arrived: make(chan struct{}, 1)}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Start a request and drain the service while it's being handled
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Request(config.cluster, []byte{0x01}, time.Second)
+		errc <- err
+	}()
+	<-handler.arrived
+
+	drained := make(chan error, 1)
+	go func() { drained <- serv.Drain(time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// Requests arriving during the drain should be rejected
+	if _, err := conn.Request(config.cluster, []byte{0x02}, time.Second); !errors.Is(err, ErrDraining) {
+		t.Fatalf("draining request result mismatch: have %v, want %v.", err, ErrDraining)
+	}
+	// The in-flight request should complete and the drain succeed
+	if err := <-errc; err != nil {
+		t.Fatalf("in-flight request failed: %v.", err)
+	}
+	if err := <-drained; err != nil {
+		t.Fatalf("drain failed: %v.", err)
+	}
+}
+
+// Tests that shutting down a connection waits for its in-flight requests, but
+// aborts them if the timeout expires.
+func TestConnectionShutdown(t *testing.T) {
+	// Register a new slow service to the relay
+	handler := &drainTestHandler{arrived: make(chan struct{}, 1)}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	for _, timeout := range []time.Duration{time.Second, 50 * time.Millisecond} {
+		conn, err := Connect(config.relay)
+		if err != nil {
+			t.Fatalf("connection failed: %v.", err)
+		}
+		// Start a request and shut the connection down while it's being handled
+		errc := make(chan error, 1)
+		go func() {
+			_, err := conn.Request(config.cluster, []byte{0x01}, time.Second)
+			errc <- err
+		}()
+		<-handler.arrived
+
+		shutdown := conn.Shutdown(timeout)
+		inflight := <-errc
+
+		// Verify that the request completed only if the drain didn't time out
+		if timeout == time.Second {
+			if shutdown != nil || inflight != nil {
+				t.Fatalf("drained shutdown mismatch: shutdown %v, request %v.", shutdown, inflight)
+			}
+		} else {
+			if shutdown != ErrTimeout || inflight != ErrClosed {
+				t.Fatalf("timed out shutdown mismatch: shutdown %v, request %v.", shutdown, inflight)
+			}
+		}
+	}
+}
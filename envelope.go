@@ -0,0 +1,128 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the optional envelopes carrying publisher metadata along with the
+// published events.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"sync/atomic"
+	"time"
+)
+
+// Topic event along with the metadata of its publisher. The metadata fields are
+// only set if the event was published within an envelope.
+type Event struct {
+	Topic   string // Topic the event was published to (the concrete one for patterns)
+	Payload []byte // Application payload of the event
+
+	Published   time.Time // Time instant the event was published at
+	Cluster     string    // Cluster of the publisher, empty for clients
+	Publisher   string    // Identifier of the publishing connection
+	Sequence    uint64    // Sequence number of the event within the publisher
+	ContentType string    // Content type of the payload, as set by the publisher
+}
+
+// Optional extension of TopicHandler, receiving the inbound events along with
+// their publisher metadata.
+type MetaTopicHandler interface {
+	HandleEventMeta(event Event)
+}
+
+// Prefix identifying an event wrapped into an envelope.
+var envelopeMagic = []byte("\x00iris-envelope\x00")
+
+// Enables or disables wrapping all published events into envelopes carrying the
+// publisher metadata. The relay delivers them as is, so subscribers on older
+// bindings receive the events with the envelope prefix still attached.
+func (c *Connection) SetEnvelopePublish(enabled bool) {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	c.envPublish = enabled
+}
+
+// Publishes an event within an envelope to topic, tagged with the given content
+// type, regardless of the envelope publish mode. See PublishCtx for the details.
+func (c *Connection) PublishEnvelope(topic string, event []byte, contentType string) error {
+	return c.publishCtx(context.Background(), topic, event, contentType, true, 0)
+}
+
+// Generates a random identifier for a publishing connection.
+func newPublisherId() string {
+	blob := make([]byte, 8)
+	if _, err := rand.Read(blob); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(blob)
+}
+
+// Wraps an event into an envelope, stamping it with the publisher metadata.
+func (c *Connection) wrapEnvelope(contentType string, event []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(envelopeMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	put := func(data string) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(data)))])
+		buf.WriteString(data)
+	}
+	buf.Write(scratch[:binary.PutVarint(scratch[:], time.Now().UnixNano())])
+	put(c.cluster)
+	put(c.envId)
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], atomic.AddUint64(&c.envSeq, 1))])
+	put(contentType)
+	buf.Write(event)
+	return buf.Bytes()
+}
+
+// Splits the publisher metadata off an event, if any. Events without envelopes
+// (or with malformed ones) are returned as is.
+func unwrapEnvelope(event []byte) (*Event, []byte) {
+	if !bytes.HasPrefix(event, envelopeMagic) {
+		return nil, event
+	}
+	reader := bytes.NewReader(event[len(envelopeMagic):])
+	get := func() (string, error) {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return "", err
+		}
+		if size > uint64(reader.Len()) {
+			return "", errors.New("field overflow")
+		}
+		data := make([]byte, size)
+		reader.Read(data)
+		return string(data), nil
+	}
+	meta := new(Event)
+
+	stamp, err := binary.ReadVarint(reader)
+	if err != nil {
+		return nil, event
+	}
+	meta.Published = time.Unix(0, stamp)
+	if meta.Cluster, err = get(); err != nil {
+		return nil, event
+	}
+	if meta.Publisher, err = get(); err != nil {
+		return nil, event
+	}
+	if meta.Sequence, err = binary.ReadUvarint(reader); err != nil {
+		return nil, event
+	}
+	if meta.ContentType, err = get(); err != nil {
+		return nil, event
+	}
+	return meta, event[len(event)-reader.Len():]
+}
@@ -6,7 +6,12 @@
 
 package iris
 
-import "errors"
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
 
 // Returned whenever a time-limited operation expires.
 var ErrTimeout = errors.New("operation timed out")
@@ -14,7 +19,230 @@ var ErrTimeout = errors.New("operation timed out")
 // Returned if an operation is requested on a closed entity.
 var ErrClosed = errors.New("entity closed")
 
-// Wrapper to differentiate between local and remote errors.
+// Returned by the operations of a tunnel closed due to its peer not answering
+// the keepalive probes.
+var ErrPeerDead = errors.New("tunnel peer unresponsive")
+
+// Returned by the operations of a tunnel closed due to exceeding its idle
+// timeout.
+var ErrIdleClosed = errors.New("tunnel closed while idle")
+
+// Returned (remotely) for requests arriving at a draining service.
+var ErrDraining = errors.New("service draining")
+
+// Returned (remotely) for requests shed by an overloaded service with overload
+// rejection enabled.
+var ErrOverloaded = errors.New("service overloaded")
+
+// Returned if an outbound tunnel could not be built within the timeout, e.g. due
+// to no member of the remote cluster being available. It matches ErrTimeout too.
+var ErrTunnelBuildTimeout error = &classError{class: ErrTimeout, msg: "tunnel construction timed out"}
+
+// Returned if a tunnel send timed out waiting for the remote endpoint to grant
+// buffer allowance, i.e. the receiver is not keeping up. It matches ErrTimeout
+// too.
+var ErrAllowanceExhausted error = &classError{class: ErrTimeout, msg: "tunnel allowance exhausted"}
+
+// Class of the failures caused by invalid arguments or misuse of an entity. The
+// concrete errors carry a detailed description, matching the class via errors.Is.
+var ErrInvalidArgument = errors.New("invalid argument")
+
+// Class of the failures caused by the relay or a remote binding violating the
+// protocol. The concrete errors carry a detailed description, matching the
+// class via errors.Is.
+var ErrProtocolViolation = errors.New("protocol violation")
+
+// Returned if subscribing to a topic the connection is already subscribed to.
+var ErrAlreadySubscribed = errors.New("already subscribed")
+
+// Returned if unsubscribing from a topic the connection is not subscribed to.
+var ErrNotSubscribed = errors.New("not subscribed")
+
+// Class of the failures caused by the relay refusing the connection. The
+// concrete errors carry the relay's reason, matching the class via errors.Is.
+var ErrConnectionDenied = errors.New("connection denied")
+
+// Class of the failures caused by the relay dropping the connection. The
+// concrete errors carry the relay's reason, matching the class via errors.Is.
+var ErrConnectionDropped = errors.New("connection dropped")
+
+// Failure with a detailed description, classified under a sentinel error for
+// the errors.Is checks.
+type classError struct {
+	class error  // Sentinel error the failure is classified under
+	msg   string // Detailed description of the failure
+}
+
+func (e *classError) Error() string {
+	return e.msg
+}
+
+// Returns the sentinel error the failure is classified under.
+func (e *classError) Unwrap() error {
+	return e.class
+}
+
+// Creates a failure classified as an invalid argument.
+func invalidArgument(format string, args ...interface{}) error {
+	return &classError{class: ErrInvalidArgument, msg: fmt.Sprintf(format, args...)}
+}
+
+// Machine readable category of an application error. Applications may define
+// their own codes starting from CodeUserDefined.
+type ErrorCode int
+
+const (
+	CodeUnknown          ErrorCode = iota // Unstructured or unclassified failure
+	CodeInvalidArgument                   // Malformed or invalid request
+	CodeNotFound                          // Requested entity does not exist
+	CodeAlreadyExists                     // Entity to create already exists
+	CodePermissionDenied                  // Requester is not allowed to do the operation
+	CodeUnavailable                       // Service temporarily unable to serve
+	CodeInternal                          // Internal failure of the service
+	CodeUserDefined      ErrorCode = 1000 // First code available for applications
+)
+
+func (c ErrorCode) String() string {
+	switch c {
+	case CodeUnknown:
+		return "unknown"
+	case CodeInvalidArgument:
+		return "invalid argument"
+	case CodeNotFound:
+		return "not found"
+	case CodeAlreadyExists:
+		return "already exists"
+	case CodePermissionDenied:
+		return "permission denied"
+	case CodeUnavailable:
+		return "unavailable"
+	case CodeInternal:
+		return "internal"
+	default:
+		return fmt.Sprintf("code %d", int(c))
+	}
+}
+
+// Structured application error, which request and stream handlers may return to
+// deliver a code and optional details along with the message to the requester.
+type Error struct {
+	Code    ErrorCode // Machine readable category of the failure
+	Message string    // Human readable description of the failure
+	Details []byte    // Optional application specific details
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// Failure reported by the remote side of an operation, differentiating it from
+// local errors (use errors.As to extract it). Structured errors returned by the
+// remote handler have their code and details filled in.
 type RemoteError struct {
-	error
+	Reason  string    // Human readable failure reason reported by the remote side
+	Code    ErrorCode // Machine readable category of the failure (CodeUnknown if unstructured)
+	Details []byte    // Optional application specific details
+
+	CorrelationID string // Correlation identifier of the failed request, empty if none
+
+	rejection error // Binding rejection (ErrDraining or ErrOverloaded) the fault stands for, if any
+}
+
+func (e *RemoteError) Error() string {
+	return e.Reason
+}
+
+// Matches the rejections of draining and overloaded services against ErrDraining
+// and ErrOverloaded respectively, so requesters can tell them apart via errors.Is.
+// Handler failures never match, whatever their code and message.
+func (e *RemoteError) Is(target error) bool {
+	return e.rejection != nil && target == e.rejection
+}
+
+// Prefix identifying a structured fault.
+var faultMagic = []byte("\x00iris-fault\x00")
+
+// Prefix identifying a rejection issued by the binding instead of the handler.
+var rejectMagic = []byte("\x00iris-reject\x00")
+
+// Reserved tokens of the binding issued rejections, following the prefix.
+const (
+	rejectDraining   byte = 0x01
+	rejectOverloaded byte = 0x02
+)
+
+// Flattens a handler failure into a fault string to send to the requester. The
+// code and details of structured errors are encoded behind a magic prefix,
+// which requesters on older bindings receive verbatim as the fault reason.
+func encodeFault(err error) string {
+	var structured *Error
+	if !errors.As(err, &structured) {
+		return err.Error()
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(faultMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutVarint(scratch[:], int64(structured.Code))])
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(structured.Message)))])
+	buf.WriteString(structured.Message)
+	buf.Write(structured.Details)
+	return buf.String()
+}
+
+// Flattens a rejection of the binding (ErrDraining or ErrOverloaded) into a
+// fault string, tagging the structured fault with a reserved token so that it
+// cannot be mistaken for a handler failure of the same code and message.
+func encodeRejection(reason error) string {
+	token := rejectOverloaded
+	if reason == ErrDraining {
+		token = rejectDraining
+	}
+	fault := encodeFault(&Error{Code: CodeUnavailable, Message: reason.Error()})
+	return string(rejectMagic) + string(token) + fault
+}
+
+// Reconstructs a remote error from a fault string, extracting any embedded code
+// and details. Malformed structured faults are returned as is.
+func decodeFault(fault string) *RemoteError {
+	// Unwrap binding rejections, recording the sentinel they stand for
+	if bytes.HasPrefix([]byte(fault), rejectMagic) && len(fault) > len(rejectMagic) {
+		var rejection error
+		switch fault[len(rejectMagic)] {
+		case rejectDraining:
+			rejection = ErrDraining
+		case rejectOverloaded:
+			rejection = ErrOverloaded
+		}
+		if rejection != nil {
+			remote := decodeFault(fault[len(rejectMagic)+1:])
+			remote.rejection = rejection
+			return remote
+		}
+	}
+	if !bytes.HasPrefix([]byte(fault), faultMagic) {
+		return &RemoteError{Reason: fault}
+	}
+	reader := bytes.NewReader([]byte(fault[len(faultMagic):]))
+	code, err := binary.ReadVarint(reader)
+	if err != nil {
+		return &RemoteError{Reason: fault}
+	}
+	size, err := binary.ReadUvarint(reader)
+	if err != nil || size > uint64(reader.Len()) {
+		return &RemoteError{Reason: fault}
+	}
+	message := make([]byte, size)
+	reader.Read(message)
+
+	var details []byte
+	if reader.Len() > 0 {
+		details = make([]byte, reader.Len())
+		reader.Read(details)
+	}
+	return &RemoteError{
+		Reason:  string(message),
+		Code:    ErrorCode(code),
+		Details: details,
+	}
 }
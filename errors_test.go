@@ -0,0 +1,83 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that local failures can be told apart via their error classes.
+func TestErrorClasses(t *testing.T) {
+	// Connect to the local relay
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that invalid arguments retain their description but match the class
+	_, err = conn.Request("", []byte{0x00}, time.Second)
+	if !errors.Is(err, ErrInvalidArgument) || err.Error() != "empty cluster identifier" {
+		t.Fatalf("invalid cluster failure mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	handler := func(string, []byte) {}
+	if err := conn.SubscribeFunc(config.topic+".#.event", handler, nil); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("invalid pattern failure mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	// Verify the subscription state failures
+	if err := conn.Unsubscribe(config.topic); err != ErrNotSubscribed {
+		t.Fatalf("stale unsubscription mismatch: have %v, want %v.", err, ErrNotSubscribed)
+	}
+	if err := conn.SubscribeFunc(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+
+	if err := conn.SubscribeFunc(config.topic, handler, nil); err != ErrAlreadySubscribed {
+		t.Fatalf("double subscription mismatch: have %v, want %v.", err, ErrAlreadySubscribed)
+	}
+	// Verify that remote failures can be extracted with their reason
+	var remote *RemoteError
+	if err := error(decodeFault("remote failure")); !errors.As(err, &remote) || remote.Reason != "remote failure" {
+		t.Fatalf("remote failure mismatch: have %v, want reason %q.", err, "remote failure")
+	}
+}
+
+// Tests that draining and overload rejections match their sentinels via their
+// reserved token, not via the failure message.
+func TestRemoteErrorRejections(t *testing.T) {
+	tests := []struct {
+		fault    string
+		draining bool
+		overload bool
+	}{
+		{encodeRejection(ErrDraining), true, false},
+		{encodeRejection(ErrOverloaded), false, true},
+		{encodeFault(&Error{Code: CodeUnavailable, Message: ErrDraining.Error()}), false, false},
+		{encodeFault(&Error{Code: CodeUnavailable, Message: ErrOverloaded.Error()}), false, false},
+		{ErrOverloaded.Error(), false, false},
+	}
+	for i, tt := range tests {
+		err := error(decodeFault(tt.fault))
+		if errors.Is(err, ErrDraining) != tt.draining {
+			t.Errorf("test %d: draining match mismatch: have %v, want %v.", i, !tt.draining, tt.draining)
+		}
+		if errors.Is(err, ErrOverloaded) != tt.overload {
+			t.Errorf("test %d: overload match mismatch: have %v, want %v.", i, !tt.overload, tt.overload)
+		}
+		var remote *RemoteError
+		if !errors.As(err, &remote) || remote.Error() == "" {
+			t.Errorf("test %d: remote error mismatch: have %v.", i, err)
+			continue
+		}
+		if (tt.draining || tt.overload) && (remote.Code != CodeUnavailable || remote.Reason != remote.rejection.Error()) {
+			t.Errorf("test %d: rejection fields mismatch: have %v/%q.", i, remote.Code, remote.Reason)
+		}
+	}
+}
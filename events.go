@@ -9,7 +9,7 @@
 package iris
 
 import (
-	"errors"
+	"context"
 	"sync/atomic"
 	"time"
 )
@@ -17,39 +17,148 @@ import (
 // Schedules an application broadcast message for the service handler to process.
 func (c *Connection) handleBroadcast(message []byte) {
 	id := int(atomic.AddUint64(&c.bcastIdx, 1))
-	c.Log.Debug("scheduling arrived broadcast", "broadcast", id, "data", logLazyBlob(message))
+	headers, payload := unwrapTrace(message)
+	deadline, payload := unwrapTTL(payload)
+	gather, payload := unwrapGather(payload)
+	c.Log.Debug("scheduling arrived broadcast", "broadcast", id, "data", logLazyBlob(payload))
 
+	// Discard the broadcast if the service is draining
+	if atomic.LoadInt32(&c.draining) == 1 {
+		c.Log.Warn("dropping broadcast arrived while draining", "broadcast", id)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		return
+	}
+	// Discard the broadcast if it's oversized or malformed
+	if err := c.validateInbound(TraceBroadcast, c.cluster, len(message), payload); err != nil {
+		c.Log.Warn("dropping rejected broadcast", "broadcast", id, "reason", err)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		return
+	}
+	// Discard the broadcast if it expired in transit
+	if expired(deadline) {
+		c.Log.Warn("dropping expired broadcast", "broadcast", id, "deadline", deadline)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		return
+	}
 	// Make sure there is enough memory for the message
 	used := int(atomic.LoadInt32(&c.bcastUsed)) // Safe, since only 1 thread increments!
 	if used+len(message) <= c.limits.BroadcastMemory {
 		// Increment the memory usage of the queue and schedule the broadcast
 		atomic.AddInt32(&c.bcastUsed, int32(len(message)))
-		c.bcastPool.Schedule(func() {
+		atomic.AddUint64(&c.stats.bcastRecv, 1)
+
+		// Collect the broadcast into a batch if the handler asked for it (gathering
+		// ones are handled individually to be able to reply)
+		if handler, ok := c.batchingBroadcasts(); ok && gather == 0 {
+			c.collectBroadcast(handler, payload, len(message))
+			return
+		}
+		handle := func() {
 			// Start the processing by decrementing the memory usage
 			atomic.AddInt32(&c.bcastUsed, -int32(len(message)))
+
+			// Discard the broadcast if it expired while queued
+			if expired(deadline) {
+				c.Log.Warn("dropping expired broadcast", "broadcast", id, "deadline", deadline)
+				atomic.AddUint64(&c.stats.dropped, 1)
+				return
+			}
+			atomic.AddInt32(&c.stats.bcastActive, 1)
+			defer atomic.AddInt32(&c.stats.bcastActive, -1)
+
 			c.Log.Debug("handling scheduled broadcast", "broadcast", id)
-			c.handler.HandleBroadcast(message)
-		})
+			ctx, finish := c.traceInbound(TraceBroadcast, c.cluster, headers)
+			_, err := c.interceptInbound(ctx, TraceBroadcast, c.cluster, payload, func(ctx context.Context, _ TraceOp, _ string, payload []byte) ([]byte, error) {
+				if handler, ok := c.handler.(GatherHandler); ok && gather != 0 {
+					c.answerGather(gather, handler.HandleGather(payload))
+				} else if handler, ok := c.handler.(ContextBroadcastHandler); ok {
+					handler.HandleBroadcastCtx(ctx, payload)
+				} else {
+					c.handler.HandleBroadcast(payload)
+				}
+				return nil, nil
+			})
+			finish(err)
+		}
+		// Broadcasts sharing an ordering key wait for their predecessors
+		var key string
+		if c.limits.BroadcastOrderKey != nil && gather == 0 {
+			key = c.limits.BroadcastOrderKey(payload)
+		}
+		var err error
+		switch {
+		case key == "":
+			err = c.bcastPool.Schedule(handle)
+
+		case c.bcastOrder.claim(key, handle):
+			if err = c.bcastPool.Schedule(func() { c.bcastOrder.run(key, handle) }); err != nil {
+				c.bcastOrder.release(key)
+			}
+		}
+		if err == ErrOverloaded {
+			// The bounded broadcast queue is full, release the memory and drop
+			atomic.AddInt32(&c.bcastUsed, -int32(len(message)))
+			atomic.AddUint64(&c.stats.dropped, 1)
+			c.Log.Error("broadcast exceeded bounded queue", "broadcast", id, "queued", c.bcastPool.Pending())
+		}
 		return
 	}
 	// Not enough memory in the broadcast queue
+	atomic.AddUint64(&c.stats.dropped, 1)
 	c.Log.Error("broadcast exceeded memory allowance", "broadcast", id, "limit", c.limits.BroadcastMemory, "used", used, "size", len(message))
 }
 
 // Schedules an application request for the service handler to process.
 func (c *Connection) handleRequest(id uint64, request []byte, timeout time.Duration) {
+	arrived := time.Now()
 	logger := c.Log.New("remote_request", id)
-	logger.Debug("scheduling arrived request", "data", logLazyBlob(request), "timeout", timeout)
+	headers, payload := unwrapTrace(request)
+	corr, payload := unwrapCorrelation(payload)
+	peer, payload := unwrapPeer(payload)
+	if len(corr) > 0 {
+		logger = logger.New("correlation", corr)
+	}
+	logger.Debug("scheduling arrived request", "data", logLazyBlob(payload), "timeout", timeout)
 
-	// Make sure there is enough memory for the request
+	// Answer health probes on the service's behalf, bypassing the handler queue
+	if c.answerProbe(id, payload, logger) {
+		return
+	}
+	// Reject the request if the service is draining
+	if atomic.LoadInt32(&c.draining) == 1 {
+		logger.Warn("rejecting request arrived while draining")
+		fault := &Error{Code: CodeUnavailable, Message: ErrDraining.Error()}
+		go c.sendReply(id, nil, encodeRejection(ErrDraining))
+		c.auditRequest(arrived, peer, corr, len(payload), fault)
+		return
+	}
+	// Reject the request if it's oversized or malformed
+	if err := c.validateInbound(TraceRequest, c.cluster, len(request), payload); err != nil {
+		logger.Warn("rejecting invalid request", "reason", err)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		fault := &Error{Code: CodeInvalidArgument, Message: err.Error()}
+		go c.sendReply(id, nil, encodeFault(fault))
+		c.auditRequest(arrived, peer, corr, len(payload), fault)
+		return
+	}
+	// Classify the request if the service prioritizes them
+	priority := PriorityNormal
+	if c.limits.RequestPriority != nil {
+		if c.limits.RequestPriority(withCorrelation(withPeer(context.Background(), peer), corr), payload) == PriorityHigh {
+			priority = PriorityHigh
+		}
+	}
+	// Make sure there is enough memory and queue space for the request
 	used := int(atomic.LoadInt32(&c.reqUsed)) // Safe, since only 1 thread increments!
-	if used+len(request) <= c.limits.RequestMemory {
+	queued := c.reqPool.PendingPriority(priority)
+	if used+len(request) <= c.limits.RequestMemory && (c.limits.RequestQueue == 0 || queued < c.limits.RequestQueue) {
 		// Increment the memory usage of the queue
 		atomic.AddInt32(&c.reqUsed, int32(len(request)))
 
 		// Create the expiration timer and schedule the request
+		deadline := time.Now().Add(timeout)
 		expiration := time.After(timeout)
-		c.reqPool.Schedule(func() {
+		err := c.reqPool.SchedulePriority(func() {
 			// Start the processing by decrementing the memory usage
 			atomic.AddInt32(&c.reqUsed, -int32(len(request)))
 
@@ -58,44 +167,120 @@ func (c *Connection) handleRequest(id uint64, request []byte, timeout time.Durat
 			case expired := <-expiration:
 				exp := time.Since(expired)
 				logger.Error("dumping expired scheduled request", "scheduled", exp+timeout, "timeout", timeout, "expired", exp)
+				atomic.AddUint64(&c.stats.dropped, 1)
+				c.auditRequest(arrived, peer, corr, len(payload), ErrTimeout)
 				return
 			default:
 				// All ok, continue
 			}
 			// Handle the request and return a reply
 			logger.Debug("handling scheduled request")
-			reply, err := c.handler.HandleRequest(request)
+			ctx, finish := c.traceInbound(TraceRequest, c.cluster, headers)
+			ctx = withPeer(ctx, peer)
+			ctx = withCorrelation(ctx, corr)
+
+			// Expire the handler context when the requester gives up
+			ctx, cancel := context.WithDeadline(ctx, deadline)
+			defer cancel()
+
+			atomic.AddInt32(&c.stats.reqActive, 1)
+			reply, err := c.dedupRequest(ctx, corr, logger, func() ([]byte, error) {
+				return c.interceptInbound(ctx, TraceRequest, c.cluster, payload, func(ctx context.Context, _ TraceOp, _ string, payload []byte) ([]byte, error) {
+					return c.serveRequest(ctx, payload)
+				})
+			})
+			atomic.AddInt32(&c.stats.reqActive, -1)
+			finish(err)
+
 			fault := ""
 			if err != nil {
-				fault = err.Error()
+				fault = encodeFault(err)
 			}
 			logger.Debug("replying to handled request", "data", logLazyBlob(reply), "error", err)
 			if err := c.sendReply(id, reply, fault); err != nil {
 				logger.Error("failed to send reply", "reason", err)
+				return
 			}
-		})
-		return
+			atomic.AddUint64(&c.stats.reqServed, 1)
+			c.auditRequest(arrived, peer, corr, len(payload), err)
+		}, priority)
+		if err != ErrOverloaded {
+			return
+		}
+		// The bounded request queue is full, release the memory and shed below
+		atomic.AddInt32(&c.reqUsed, -int32(len(request)))
+		queued = c.reqPool.PendingPriority(priority)
+	}
+	// Not enough memory or space in the request queue, shed the request
+	atomic.AddUint64(&c.stats.dropped, 1)
+	logger.Error("request exceeded admission limits", "memory_limit", c.limits.RequestMemory, "used", used, "size", len(request), "queue_limit", c.limits.RequestQueue, "queued", queued)
+
+	if c.limits.RejectOverload {
+		go c.sendReply(id, nil, encodeRejection(ErrOverloaded))
 	}
-	// Not enough memory in the request queue
-	logger.Error("request exceeded memory allowance", "limit", c.limits.RequestMemory, "used", used, "size", len(request))
+	c.auditRequest(arrived, peer, corr, len(payload), ErrOverloaded)
 }
 
 // Looks up a pending request and delivers the result.
 func (c *Connection) handleReply(id uint64, reply []byte, fault string) {
+	var err error
+	if reply == nil && len(fault) == 0 {
+		err = ErrTimeout
+	} else if reply == nil {
+		err = decodeFault(fault)
+	}
+	c.resolveReply(id, reply, err)
+}
+
+// Hands the outcome of a request over to the waiting requester.
+func (c *Connection) resolveReply(id uint64, reply []byte, err error) {
+	// Resolve the future if it was an async request
+	if c.resolveFuture(id, reply, err) {
+		return
+	}
 	c.reqLock.RLock()
 	defer c.reqLock.RUnlock()
 
-	if reply == nil && len(fault) == 0 {
-		c.reqErrs[id] <- ErrTimeout
-	} else if reply == nil {
-		c.reqErrs[id] <- &RemoteError{errors.New(fault)}
+	// Make sure the request wasn't abandoned in the mean time
+	if _, ok := c.reqReps[id]; !ok {
+		c.Log.Warn("stale reply arrived", "local_request", id)
+		return
+	}
+	if err != nil {
+		c.reqErrs[id] <- err
 	} else {
 		c.reqReps[id] <- reply
 	}
 }
 
+// Hands an arrived event over for handling without blocking the relay link. The
+// events of ordered and blocking subscriptions are admitted one by one in arrival
+// order, the rest are admitted concurrently.
+func (c *Connection) dispatchPublish(topic string, event []byte) {
+	arrived := time.Now()
+
+	c.subLock.RLock()
+	top, ok := c.subLive[topic]
+	c.subLock.RUnlock()
+
+	if ok && top.limits.Overflow == OverflowBlock {
+		top.holdBack(topic, event, arrived)
+		return
+	}
+	if ok && top.ingress != nil {
+		if err := top.ingress.Schedule(func() { c.handlePublish(topic, event, arrived) }); err == nil {
+			return
+		}
+	}
+	go c.handlePublish(topic, event, arrived)
+}
+
 // Forwards a topic publish event to the topic subscription.
-func (c *Connection) handlePublish(topic string, event []byte) {
+func (c *Connection) handlePublish(topic string, event []byte, arrived time.Time) {
+	// Dispatch to the pattern subscriptions if arrived on a fan-in topic
+	if c.handlePatternPublish(topic, event, arrived) {
+		return
+	}
 	// Fetch the handler and release the lock fast
 	c.subLock.RLock()
 	top, ok := c.subLive[topic]
@@ -103,7 +288,7 @@ func (c *Connection) handlePublish(topic string, event []byte) {
 
 	// Make sure the subscription is still live
 	if ok {
-		top.handlePublish(event)
+		top.handlePublish(topic, event, arrived)
 	} else {
 		c.Log.Warn("stale publish arrived", "topic", topic)
 	}
@@ -111,6 +296,8 @@ func (c *Connection) handlePublish(topic string, event []byte) {
 
 // Notifies the application of the relay link going down.
 func (c *Connection) handleClose(reason error) {
+	c.setHealth(HealthClosed)
+
 	// Notify the client of the drop if premature
 	if reason != nil {
 		c.Log.Crit("connection dropped", "reason", reason)
@@ -120,6 +307,9 @@ func (c *Connection) handleClose(reason error) {
 			c.handler.HandleDrop(reason)
 		}
 	}
+	// Fail all pending async requests
+	c.failFutures(ErrClosed)
+
 	// Close all open tunnels
 	c.tunLock.Lock()
 	for _, tun := range c.tunLive {
@@ -127,15 +317,38 @@ func (c *Connection) handleClose(reason error) {
 	}
 	c.tunLive = nil
 	c.tunLock.Unlock()
+
+	// Notify the application components of the tear-down
+	c.notifyClose(reason)
 }
 
 // Opens a new local tunnel endpoint and binds it to the remote side.
 func (c *Connection) handleTunnelInit(id uint64, chunkLimit int) {
+	// Ignore the tunnel if the service is draining (remote side times out)
+	if atomic.LoadInt32(&c.draining) == 1 {
+		c.Log.Warn("ignoring tunnel arrived while draining", "remote_tunnel", id)
+		return
+	}
 	go func() {
-		if tun, err := c.acceptTunnel(id, chunkLimit); err == nil {
+		tun, err := c.acceptTunnel(id, chunkLimit)
+		if err != nil {
+			return // Failure already logged by the acceptor
+		}
+		_, err = c.interceptInbound(context.Background(), TraceTunnel, c.cluster, nil, func(context.Context, TraceOp, string, []byte) ([]byte, error) {
+			if err := tun.secureConfigured(); err != nil {
+				return nil, err
+			}
+			if c.listenTunnel(tun) {
+				return nil, nil
+			}
 			c.handler.HandleTunnel(tun)
+			return nil, nil
+		})
+		if err != nil {
+			tun.Log.Warn("inbound tunnel rejected or failed", "reason", err)
+			tun.auditReject(err)
+			tun.Close()
 		}
-		// Else: failure already logged by the acceptor
 	}()
 }
 
@@ -143,11 +356,16 @@ func (c *Connection) handleTunnelInit(id uint64, chunkLimit int) {
 func (c *Connection) handleTunnelResult(id uint64, chunkLimit int) {
 	// Retrieve the tunnel
 	c.tunLock.RLock()
-	tun := c.tunLive[id]
+	tun, ok := c.tunLive[id]
 	c.tunLock.RUnlock()
 
-	// Finalize initialization
-	tun.handleInitResult(chunkLimit)
+	// Finalize initialization, or tear down if abandoned in the mean time
+	if ok {
+		tun.handleInitResult(chunkLimit)
+	} else if chunkLimit > 0 {
+		c.Log.Warn("stale tunnel construction result arrived", "tunnel", id)
+		go c.sendTunnelClose(id)
+	}
 }
 
 // Forwards a tunnel data allowance to the requested tunnel.
@@ -0,0 +1,188 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the failover among multiple relay endpoints, dialing the healthiest
+// one and moving on to the others when it becomes unreachable.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Relay endpoint of a failover transport, along with its health score.
+type failoverEndpoint struct {
+	transport RelayTransport // Transport dialing the endpoint
+	priority  int            // Position of the endpoint in the user's list
+	fails     int            // Consecutive dial failures and link drops
+}
+
+// Transport dialing one of multiple relay endpoints, preferring the healthiest.
+type failoverTransport struct {
+	endpoints []*failoverEndpoint // Endpoints to dial, in the user's preference order
+	lock      sync.Mutex          // Mutex to protect the health scores
+}
+
+// Creates a transport failing over among multiple relay endpoints. Each dial
+// tries the endpoints in the order of their health (the ones failing the least
+// consecutive dials or links first, ties broken by the given order), until one
+// succeeds. Combined with automatic reconnection, a connection dropped by its
+// relay thus moves on to the next one, restoring its registration and
+// subscriptions there.
+func NewFailoverTransport(relays ...RelayTransport) RelayTransport {
+	t := &failoverTransport{}
+	for i, relay := range relays {
+		t.endpoints = append(t.endpoints, &failoverEndpoint{transport: relay, priority: i})
+	}
+	return t
+}
+
+// Dials the endpoints in the order of their health until one succeeds. If the
+// context has a deadline, it is split evenly among the remaining endpoints, so
+// a hung endpoint cannot consume the whole time allowance.
+func (t *failoverTransport) Dial(ctx context.Context) (RelayLink, error) {
+	if len(t.endpoints) == 0 {
+		return nil, invalidArgument("no relay endpoints")
+	}
+	var errs []error
+	for i, endpoint := range t.ranking() {
+		attempt, cancel := ctx, context.CancelFunc(func() {})
+		if deadline, ok := ctx.Deadline(); ok {
+			share := time.Until(deadline) / time.Duration(len(t.endpoints)-i)
+			attempt, cancel = context.WithTimeout(ctx, share)
+		}
+		link, err := endpoint.transport.Dial(attempt)
+		cancel()
+
+		if err == nil {
+			t.succeeded(endpoint)
+			return &failoverLink{RelayLink: link, owner: t, endpoint: endpoint}, nil
+		}
+		t.failed(endpoint)
+		errs = append(errs, fmt.Errorf("%v: %w", endpoint.transport, err))
+
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Lists the endpoints, in the order of their health and priority.
+func (t *failoverTransport) ranking() []*failoverEndpoint {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	ranking := append([]*failoverEndpoint(nil), t.endpoints...)
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].fails != ranking[j].fails {
+			return ranking[i].fails < ranking[j].fails
+		}
+		return ranking[i].priority < ranking[j].priority
+	})
+	return ranking
+}
+
+// Resets the health score of an endpoint after a successful dial.
+func (t *failoverTransport) succeeded(endpoint *failoverEndpoint) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	endpoint.fails = 0
+}
+
+// Penalizes the health score of an endpoint after a failed dial or link.
+func (t *failoverTransport) failed(endpoint *failoverEndpoint) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	endpoint.fails++
+}
+
+// Reports the endpoints along with their health for the logs.
+func (t *failoverTransport) String() string {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	names := make([]string, len(t.endpoints))
+	for i, endpoint := range t.endpoints {
+		names[i] = fmt.Sprintf("%v", endpoint.transport)
+		if endpoint.fails > 0 {
+			names[i] += fmt.Sprintf("(fails=%d)", endpoint.fails)
+		}
+	}
+	return "failover[" + strings.Join(names, ",") + "]"
+}
+
+// Relay link of a failover transport, penalizing its endpoint if the link fails
+// without being closed locally.
+type failoverLink struct {
+	RelayLink
+	owner    *failoverTransport // Transport tracking the endpoint health
+	endpoint *failoverEndpoint  // Endpoint the link was dialed to
+	closed   int32              // Flag whether the link was closed locally
+	dropped  int32              // Flag whether the failure was already scored
+}
+
+// Retrieves the next frame, scoring a failure against the endpoint if the link
+// drops.
+func (l *failoverLink) ReadFrame() ([]byte, error) {
+	frame, err := l.RelayLink.ReadFrame()
+	if err != nil && atomic.LoadInt32(&l.closed) == 0 && atomic.CompareAndSwapInt32(&l.dropped, 0, 1) {
+		l.owner.failed(l.endpoint)
+	}
+	return frame, err
+}
+
+// Tears down the link, without scoring it as a failure.
+func (l *failoverLink) Close() error {
+	atomic.StoreInt32(&l.closed, 1)
+	return l.RelayLink.Close()
+}
+
+// Connects to the Iris network as a simple client through the first reachable
+// relay of the local ports, failing over to the others (with automatic
+// reconnection enabled) whenever the active relay becomes unreachable. It is a
+// shorthand for ConnectTransport with a failover transport and WithReconnect.
+func ConnectFailover(ports ...int) (*Connection, error) {
+	transport, err := newFailoverTCP(ports)
+	if err != nil {
+		return nil, err
+	}
+	return ConnectTransport(transport, WithReconnect(nil))
+}
+
+// Connects to the Iris network through the first reachable relay of the local
+// ports and registers a new service instance as a member of the specified
+// service cluster, failing over to the other relays (with automatic
+// reconnection enabled) whenever the active one becomes unreachable. It is a
+// shorthand for RegisterTransport with a failover transport and WithReconnect.
+func RegisterFailover(ports []int, cluster string, handler ServiceHandler, limits *ServiceLimits) (*Service, error) {
+	transport, err := newFailoverTCP(ports)
+	if err != nil {
+		return nil, err
+	}
+	return RegisterTransport(transport, cluster, handler, limits, WithReconnect(nil))
+}
+
+// Creates a failover transport among the relays listening on the local ports.
+func newFailoverTCP(ports []int) (RelayTransport, error) {
+	if len(ports) == 0 {
+		return nil, invalidArgument("no relay ports")
+	}
+	relays := make([]RelayTransport, len(ports))
+	for i, port := range ports {
+		relays[i] = NewTCPTransport(port, nil)
+	}
+	return NewFailoverTransport(relays...), nil
+}
@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Tests that connections skip unreachable relays and fail over to the next one
+// when their active relay dies, restoring the service registration there.
+func TestRelayFailover(t *testing.T) {
+	// Start two fake relays and reserve a port with no relay behind it
+	primary, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start primary relay: %v.", err)
+	}
+
+	backup, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start backup relay: %v.", err)
+	}
+	defer backup.Close()
+
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to reserve port: %v.", err)
+	}
+	dead := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	// Attach a service and a client, both preferring the unreachable relay
+	ports := []int{dead, primary.Port(), backup.Port()}
+
+	serv, err := RegisterFailover(ports, config.cluster, new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := ConnectFailover(ports...)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Request(config.cluster, []byte("primary"), time.Second); err != nil {
+		t.Fatalf("request through primary relay failed: %v.", err)
+	}
+	// Kill the primary relay and wait for both ends to move to the backup
+	primary.Close()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if _, err := conn.Request(config.cluster, []byte("backup"), 100*time.Millisecond); err == nil {
+			break
+		} else if time.Now().After(deadline) {
+			t.Fatalf("request through backup relay failed: %v.", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,105 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build irisfaults
+
+// Contains the fault injection hooks of the inbound relay messages, allowing the
+// applications and the binding's own tests to verify their behavior when the
+// relay misbehaves.
+//
+// The hooks are compiled in only with the irisfaults build tag (e.g. go test
+// -tags irisfaults), so that production builds pay nothing for them.
+
+package iris
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// Reason given to the tunnels closed by fault injection.
+const faultCloseReason = "injected tunnel fault"
+
+// Fault injection configuration of the inbound relay messages.
+type Faults struct {
+	DropRate float64       // Probability of dropping an inbound broadcast, request, reply (timing it out) or event
+	Delay    time.Duration // Delay to wait before processing any inbound message
+
+	// Probability of truncating an inbound tunnel chunk to a random length. Since
+	// the tunnel protocol is reliable, the affected message will be corrupt.
+	TruncateRate float64
+
+	// Probability of force closing a tunnel on an inbound chunk, as if the relay
+	// closed it (the remote endpoint is not notified).
+	TunnelCloseRate float64
+}
+
+// Fault injection state of a connection.
+type faultInjector struct {
+	faults *Faults    // Currently active fault injection config, nil if disabled
+	rand   *rand.Rand // Randomness source deciding the injected faults
+	lock   sync.Mutex // Protects the config and the randomness source
+}
+
+// Replaces the active fault injection configuration of the inbound messages. A
+// nil config disables fault injection.
+func (c *Connection) SetFaults(faults *Faults) {
+	c.faults.lock.Lock()
+	defer c.faults.lock.Unlock()
+
+	if faults != nil {
+		copy := *faults
+		faults = &copy
+		if c.faults.rand == nil {
+			c.faults.rand = rand.New(rand.NewSource(time.Now().UnixNano()))
+		}
+	}
+	c.faults.faults = faults
+}
+
+// Applies the configured message delay, returning whether the inbound message
+// should be dropped.
+func (c *Connection) injectDrop() bool {
+	c.faults.lock.Lock()
+	faults := c.faults.faults
+	drop := faults != nil && faults.DropRate > 0 && c.faults.rand.Float64() < faults.DropRate
+	c.faults.lock.Unlock()
+
+	if faults != nil && faults.Delay > 0 {
+		time.Sleep(faults.Delay)
+	}
+	if drop {
+		c.Log.Debug("fault injection dropped inbound message")
+	}
+	return drop
+}
+
+// Applies the configured message delay to an inbound tunnel chunk, returning
+// the (possibly truncated) chunk to deliver, or false if the tunnel got closed.
+func (c *Connection) injectChunk(id uint64, chunk []byte) ([]byte, bool) {
+	c.faults.lock.Lock()
+	faults := c.faults.faults
+	if faults == nil {
+		c.faults.lock.Unlock()
+		return chunk, true
+	}
+	kill := faults.TunnelCloseRate > 0 && c.faults.rand.Float64() < faults.TunnelCloseRate
+	if !kill && len(chunk) > 0 && faults.TruncateRate > 0 && c.faults.rand.Float64() < faults.TruncateRate {
+		chunk = chunk[:c.faults.rand.Intn(len(chunk))]
+	}
+	c.faults.lock.Unlock()
+
+	if faults.Delay > 0 {
+		time.Sleep(faults.Delay)
+	}
+	if kill {
+		c.Log.Debug("fault injection closed tunnel", "tunnel", id)
+		go c.handleTunnelClose(id, faultCloseReason)
+		return nil, false
+	}
+	return chunk, true
+}
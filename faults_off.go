@@ -0,0 +1,24 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build !irisfaults
+
+// Contains the no-op fault injection hooks of regular builds.
+
+package iris
+
+// Fault injection state of a connection, empty without the irisfaults tag.
+type faultInjector struct{}
+
+// Reports that the inbound message should be processed.
+func (c *Connection) injectDrop() bool {
+	return false
+}
+
+// Returns the inbound tunnel chunk unmodified.
+func (c *Connection) injectChunk(id uint64, chunk []byte) ([]byte, bool) {
+	return chunk, true
+}
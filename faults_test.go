@@ -0,0 +1,113 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build irisfaults
+
+package iris
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// Tests that injected drops lose the inbound requests, replies and events, and
+// that clearing the faults restores the delivery.
+func TestFaultDrop(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Drop all the replies and verify the request times out
+	conn.SetFaults(&Faults{DropRate: 1})
+	if _, err := conn.Request(config.cluster, []byte("request"), 250*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("dropped reply error mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Drop all the events and verify none arrive
+	events := make(chan []byte, 1)
+	if err := conn.SubscribeFunc(config.topic, func(_ string, event []byte) { events <- event }, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("dropped event delivered: %s.", event)
+	case <-time.After(250 * time.Millisecond):
+	}
+	// Clear the faults and verify delivery is restored
+	conn.SetFaults(nil)
+	if reply, err := conn.Request(config.cluster, []byte("request"), time.Second); err != nil || string(reply) != "request" {
+		t.Fatalf("restored request mismatch: have %s/%v, want %s/nil.", reply, err, "request")
+	}
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case <-events:
+	case <-time.After(time.Second):
+		t.Fatalf("restored event delivery timed out.")
+	}
+}
+
+// Tests that injected tunnel faults corrupt the inbound messages or close the
+// tunnel on the receiving side.
+func TestFaultTunnel(t *testing.T) {
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	message := bytes.Repeat([]byte{0x42}, 1024)
+
+	// Truncate all the inbound chunks and verify the echo never completes
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	conn.SetFaults(&Faults{TruncateRate: 1})
+	if err := tun.Send(message, time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if msg, err := tun.Recv(250 * time.Millisecond); err == nil && bytes.Equal(msg, message) {
+		t.Fatalf("truncated message delivered intact.")
+	}
+	// Close the tunnel on the next inbound chunk and verify it's torn down
+	tun, err = conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	conn.SetFaults(&Faults{TunnelCloseRate: 1})
+	if err := tun.Send(message, time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if _, err := tun.Recv(time.Second); err == nil || err == ErrTimeout {
+		t.Fatalf("fault closed tunnel receive error mismatch: have %v, want closure.", err)
+	}
+}
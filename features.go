@@ -0,0 +1,128 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the relay protocol version negotiation and the optional capabilities
+// derived from the version the relay advertises.
+//
+// The relay answers the connection initiation with the highest protocol version
+// it supports. Relays of a different major version are refused upfront instead
+// of failing mid-stream on an unknown packet, whereas the optional features of
+// newer minor versions are only enabled if the relay advertises them.
+
+package iris
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Returned if the relay speaks an incompatible version of the relay protocol.
+// It matches ErrProtocolViolation too.
+var ErrIncompatibleRelay error = &classError{class: ErrProtocolViolation, msg: "incompatible relay protocol version"}
+
+// Bitmask of the optional relay protocol capabilities.
+type RelayFeatures uint64
+
+const (
+	// Relay accepts tunnel chunks above its advertised chunk limit, as needed by
+	// TunnelConfig.ChunkOverride.
+	FeatureLargeChunks RelayFeatures = 1 << iota
+)
+
+// Optional capabilities of a known relay protocol version.
+type relayFeatureEntry struct {
+	version  string        // Full version string as advertised by the relay
+	minor    int           // Minor version number, for matching unknown versions
+	features RelayFeatures // Optional capabilities of the version
+}
+
+// Optional capabilities of the known relay protocol versions. Unknown newer minor
+// versions are assumed to support everything the latest known one does.
+var relayFeatureTable = []relayFeatureEntry{
+	{"v1.0-draft2", 0, 0},
+}
+
+// Checks whether all the given capabilities are present in the bitmask.
+func (f RelayFeatures) Has(features RelayFeatures) bool {
+	return f&features == features
+}
+
+func (f RelayFeatures) String() string {
+	var names []string
+	if f.Has(FeatureLargeChunks) {
+		names = append(names, "large-chunks")
+	}
+	if len(names) == 0 {
+		return "none"
+	}
+	return strings.Join(names, ",")
+}
+
+// Retrieves the protocol version advertised by the relay of the current link.
+func (c *Connection) RelayVersion() string {
+	c.sockLock.Lock()
+	defer c.sockLock.Unlock()
+
+	return c.relayVer
+}
+
+// Retrieves the optional capabilities of the relay of the current link.
+func (c *Connection) RelayFeatures() RelayFeatures {
+	c.sockLock.Lock()
+	defer c.sockLock.Unlock()
+
+	return c.relayFeats
+}
+
+// Splits a protocol version of the form vMAJOR.MINOR[-suffix] into its numeric
+// components.
+func parseProtoVersion(version string) (int, int, bool) {
+	if !strings.HasPrefix(version, "v") {
+		return 0, 0, false
+	}
+	version = version[1:]
+	if idx := strings.IndexByte(version, '-'); idx >= 0 {
+		version = version[:idx]
+	}
+	parts := strings.Split(version, ".")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+	major, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, 0, false
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, 0, false
+	}
+	return major, minor, true
+}
+
+// Negotiates the capabilities of a relay based on its advertised version,
+// failing if it is incompatible with the protocol implemented by the binding.
+func negotiateFeatures(relayVersion string) (RelayFeatures, error) {
+	major, minor, ok := parseProtoVersion(relayVersion)
+	if !ok {
+		return 0, fmt.Errorf("%w: malformed version %q", ErrIncompatibleRelay, relayVersion)
+	}
+	local, _, _ := parseProtoVersion(protoVersion)
+	if major != local {
+		return 0, fmt.Errorf("%w: relay %s, binding %s", ErrIncompatibleRelay, relayVersion, protoVersion)
+	}
+	// Exact matches take precedence, otherwise use the latest older minor version
+	var features RelayFeatures
+	for _, known := range relayFeatureTable {
+		if known.version == relayVersion {
+			return known.features, nil
+		}
+		if known.minor <= minor {
+			features = known.features
+		}
+	}
+	return features, nil
+}
@@ -0,0 +1,59 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+)
+
+// Tests that the relay version is negotiated upon connecting.
+func TestRelayVersion(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if version := conn.RelayVersion(); version != protoVersion {
+		t.Fatalf("relay version mismatch: have %s, want %s.", version, protoVersion)
+	}
+	if features := conn.RelayFeatures(); features != 0 {
+		t.Fatalf("relay features mismatch: have %v, want %v.", features, RelayFeatures(0))
+	}
+}
+
+// Tests the derivation of the relay capabilities from the advertised versions.
+func TestNegotiateFeatures(t *testing.T) {
+	defer func(table []relayFeatureEntry) { relayFeatureTable = table }(relayFeatureTable)
+	relayFeatureTable = append(relayFeatureTable, relayFeatureEntry{"v1.2", 2, FeatureLargeChunks})
+
+	tests := []struct {
+		version  string
+		features RelayFeatures
+		fail     bool
+	}{
+		{"v1.0-draft2", 0, false},                // Exact match
+		{"v1.1-draft1", 0, false},                // Unknown, inherits v1.0
+		{"v1.2", FeatureLargeChunks, false},      // Exact match
+		{"v1.5-beta", FeatureLargeChunks, false}, // Unknown, inherits v1.2
+		{"v2.0", 0, true},                        // Incompatible major version
+		{"1.0", 0, true},                         // Malformed version
+	}
+	for i, tt := range tests {
+		features, err := negotiateFeatures(tt.version)
+		if tt.fail {
+			if !errors.Is(err, ErrIncompatibleRelay) || !errors.Is(err, ErrProtocolViolation) {
+				t.Errorf("test %d: failure mismatch: have %v, want %v.", i, err, ErrIncompatibleRelay)
+			}
+			continue
+		}
+		if err != nil || features != tt.features {
+			t.Errorf("test %d: features mismatch: have %v/%v, want %v.", i, features, err, tt.features)
+		}
+	}
+}
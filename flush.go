@@ -0,0 +1,138 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the send batching of the relay link, coalescing small outbound
+// packets into larger socket writes.
+
+package iris
+
+import (
+	"bufio"
+	"io"
+	"time"
+)
+
+// Send batching policy of the relay link. By default, the outbound packets are
+// flushed as soon as no other sends are waiting for the link, costing a write
+// syscall per packet at low concurrency. With a flush policy, the packets are
+// held back Nagle-style until either the window elapses or enough data piles up,
+// trading a bit of latency for throughput at high message rates.
+type FlushPolicy struct {
+	Window time.Duration // Time to hold back buffered packets before flushing
+	Bytes  int           // Buffered data size forcing an immediate flush
+}
+
+// Default send batching policy.
+var defaultFlushPolicy = FlushPolicy{
+	Window: 100 * time.Microsecond,
+	Bytes:  64 * 1024,
+}
+
+// Sets the send batching policy of the relay link, with zero fields replaced by
+// the defaults. A nil policy restores the default flush-when-idle behavior.
+func (c *Connection) SetFlushPolicy(policy *FlushPolicy) {
+	if policy != nil {
+		policy = finalizeFlushPolicy(policy)
+	}
+	c.sockLock.Lock()
+	defer c.sockLock.Unlock()
+
+	// Push out anything batched under the old policy
+	if err := c.sockBuf.Flush(); err != nil {
+		c.Log.Warn("failed to flush relay link", "reason", err)
+	}
+	c.flushPolicy = policy
+	c.resizeSendBuffer()
+
+	if policy != nil {
+		c.Log.Info("send batching enabled", "window", policy.Window, "bytes", policy.Bytes)
+	} else {
+		c.Log.Info("send batching disabled")
+	}
+}
+
+// Creates a copy of the user supplied flush policy, with zero fields replaced
+// by the defaults.
+func finalizeFlushPolicy(user *FlushPolicy) *FlushPolicy {
+	policy := *user
+	if policy.Window <= 0 {
+		policy.Window = defaultFlushPolicy.Window
+	}
+	if policy.Bytes <= 0 {
+		policy.Bytes = defaultFlushPolicy.Bytes
+	}
+	return &policy
+}
+
+// Swaps the outbound buffer of the relay link to one able to hold a whole batch,
+// so the buffer doesn't spill over before the flush is due. The socket lock is
+// assumed to be held and the buffer flushed.
+func (c *Connection) resizeSendBuffer() {
+	size := defaultBufferSize
+	if c.flushPolicy != nil {
+		size = c.flushPolicy.Bytes
+	}
+	if c.flushPolicy == nil && size == c.sockBuf.Writer.Size() {
+		return
+	}
+	_, writer := linkStreams(c.sock)
+	if c.flushPolicy != nil {
+		c.flushOut = &batchWriter{Writer: writer}
+		writer = c.flushOut
+	}
+	c.sockBuf.Writer = bufio.NewWriterSize(writer, size)
+}
+
+// Size of the outbound buffer without send batching.
+var defaultBufferSize = bufio.NewWriter(nil).Size()
+
+// Flushes the relay link after the last pending packet was written, either right
+// away or by scheduling a delayed flush if send batching is enabled. The socket
+// lock is assumed to be held.
+func (c *Connection) flushPacket() error {
+	policy := c.flushPolicy
+	if policy == nil {
+		return c.sockBuf.Flush()
+	}
+	// If the batch already spilled over into the link, don't hold back its tail
+	if c.flushOut.spilled || c.sockBuf.Writer.Buffered() >= policy.Bytes {
+		err := c.sockBuf.Flush()
+		c.flushOut.spilled = false
+		return err
+	}
+	if !c.flushArmed {
+		c.flushArmed = true
+		time.AfterFunc(policy.Window, c.flushBatch)
+	}
+	return nil
+}
+
+// Flushes a batch of packets held back by the send batching policy.
+func (c *Connection) flushBatch() {
+	c.sockLock.Lock()
+	defer c.sockLock.Unlock()
+
+	c.flushArmed = false
+	if c.flushPolicy == nil || c.sockBuf.Writer.Buffered() == 0 {
+		return
+	}
+	if err := c.sockBuf.Flush(); err != nil {
+		c.Log.Debug("failed to flush send batch", "reason", err)
+	}
+	c.flushOut.spilled = false
+}
+
+// Link writer beneath the batching buffer, tracking whether the current batch
+// outgrew the buffer and was partially written already.
+type batchWriter struct {
+	io.Writer
+	spilled bool // Whether data was written since the last flush
+}
+
+func (w *batchWriter) Write(p []byte) (int, error) {
+	w.spilled = true
+	return w.Writer.Write(p)
+}
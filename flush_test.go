@@ -0,0 +1,76 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that batched sends are flushed both by the window and by the size limit.
+func TestFlushPolicy(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		window time.Duration
+		bytes  int
+		small  int
+		large  int
+	}{50 * time.Millisecond, 4096, 16, 8192}
+
+	// Register a new service to the relay and batch its sends
+	handler := new(requestTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.SetFlushPolicy(&FlushPolicy{Window: conf.window, Bytes: conf.bytes})
+
+	// Verify that a lone small request is held back until the window elapses
+	start := time.Now()
+	if _, err := handler.conn.Request(config.cluster, make([]byte, conf.small), time.Second); err != nil {
+		t.Fatalf("small request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed < conf.window {
+		t.Fatalf("small request not batched: took %v, want at least %v.", elapsed, conf.window)
+	}
+	// Verify that a request overflowing the batch is sent right away
+	start = time.Now()
+	if _, err := handler.conn.Request(config.cluster, make([]byte, conf.large), time.Second); err != nil {
+		t.Fatalf("large request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed > conf.window {
+		t.Fatalf("large request held back: took %v, want below %v.", elapsed, conf.window)
+	}
+	// Verify that concurrent requests are pipelined correctly through the batches
+	var pend sync.WaitGroup
+	for i := 0; i < 64; i++ {
+		pend.Add(1)
+		go func(i int) {
+			defer pend.Done()
+			if rep, err := handler.conn.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+				t.Errorf("request #%d failed: %v.", i, err)
+			} else if len(rep) != 1 || rep[0] != byte(i) {
+				t.Errorf("reply #%d mismatch: have %v, want %v.", i, rep, []byte{byte(i)})
+			}
+		}(i)
+	}
+	pend.Wait()
+
+	// Verify that disabling the batching restores immediate flushes
+	handler.conn.SetFlushPolicy(nil)
+
+	start = time.Now()
+	if _, err := handler.conn.Request(config.cluster, make([]byte, conf.small), time.Second); err != nil {
+		t.Fatalf("unbatched request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed > conf.window {
+		t.Fatalf("unbatched request held back: took %v, want below %v.", elapsed, conf.window)
+	}
+}
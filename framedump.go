@@ -0,0 +1,217 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the protocol debug mode, dumping the decoded relay protocol frames of
+// a connection for diagnosing framing mismatches between binding and relay.
+//
+// Frames are recorded field by field as the wire primitives serialize and parse
+// them, so a dump reflects exactly what crossed the link, including frames cut
+// short by a parse failure. When disabled, the primitives only check a nil frame.
+
+package iris
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Number of leading bytes of binary and string fields retained in a dump.
+const framePreview = 16
+
+// Callback receiving the decoded relay protocol frames of a connection. It runs
+// synchronously on the network sender or receiver, so it should return swiftly.
+type FrameDump func(frame *Frame)
+
+// Decoded relay protocol frame, as sent or received on the wire.
+type Frame struct {
+	Time    time.Time    // Time instance the frame was sent or started arriving at
+	Inbound bool         // Whether the frame was received from the relay
+	Opcode  byte         // Opcode identifying the frame type
+	Fields  []FrameField // Fields of the frame following the opcode, in wire order
+	Size    int          // Total size of the frame on the wire (opcode included)
+}
+
+// Single field of a relay protocol frame.
+type FrameField struct {
+	Kind  string // Wire type of the field: "bool", "varint", "binary" or "string"
+	Value uint64 // Value of bool (0 or 1) and varint fields, length of binary and string ones
+	Data  []byte // Leading bytes of binary and string fields (at most 16)
+}
+
+// Retrieves the name of the frame type.
+func (f *Frame) Op() string {
+	switch f.Opcode {
+	case opInit:
+		return "init"
+	case opDeny:
+		return "deny"
+	case opClose:
+		return "close"
+	case opBroadcast:
+		return "broadcast"
+	case opRequest:
+		return "request"
+	case opReply:
+		return "reply"
+	case opSubscribe:
+		return "subscribe"
+	case opUnsubscribe:
+		return "unsubscribe"
+	case opPublish:
+		return "publish"
+	case opTunInit:
+		return "tunnel-init"
+	case opTunConfirm:
+		return "tunnel-confirm"
+	case opTunAllow:
+		return "tunnel-allow"
+	case opTunTransfer:
+		return "tunnel-transfer"
+	case opTunClose:
+		return "tunnel-close"
+	default:
+		return fmt.Sprintf("unknown(0x%02x)", f.Opcode)
+	}
+}
+
+// Formats the frame as a single line, with hex previews of the binary fields.
+func (f *Frame) String() string {
+	dir := "out"
+	if f.Inbound {
+		dir = "in"
+	}
+	parts := []string{fmt.Sprintf("%-3s %s (%d bytes)", dir, f.Op(), f.Size)}
+	for _, field := range f.Fields {
+		parts = append(parts, field.String())
+	}
+	return strings.Join(parts, " ")
+}
+
+// Formats the field as kind=value, with a preview of the contents of binary and
+// string fields.
+func (f FrameField) String() string {
+	switch f.Kind {
+	case "binary", "string":
+		more := ""
+		if uint64(len(f.Data)) < f.Value {
+			more = "..."
+		}
+		if f.Kind == "string" {
+			return fmt.Sprintf("string[%d]=%q%s", f.Value, f.Data, more)
+		}
+		return fmt.Sprintf("binary[%d]=%x%s", f.Value, f.Data, more)
+	default:
+		return fmt.Sprintf("%s=%d", f.Kind, f.Value)
+	}
+}
+
+// Appends a field to the frame being recorded, a no-op if none is.
+func (f *Frame) add(kind string, value uint64, data []byte) {
+	if f == nil {
+		return
+	}
+	field := FrameField{Kind: kind, Value: value}
+	switch kind {
+	case "binary", "string":
+		if len(data) > framePreview {
+			data = data[:framePreview]
+		}
+		field.Data = append([]byte(nil), data...)
+		f.Size += varintSize(value) + int(value)
+	case "bool":
+		f.Size++
+	default:
+		f.Size += varintSize(value)
+	}
+	f.Fields = append(f.Fields, field)
+}
+
+// Calculates the wire size of a varint.
+func varintSize(value uint64) int {
+	size := 1
+	for ; value > 127; value >>= 7 {
+		size++
+	}
+	return size
+}
+
+// Creates a frame dump callback writing each frame as a timestamped text line
+// into w. Concurrently dumped frames are serialized.
+func NewFrameWriter(w io.Writer) FrameDump {
+	var lock sync.Mutex
+	return func(frame *Frame) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		fmt.Fprintf(w, "%s %s\n", frame.Time.Format("15:04:05.000000"), frame)
+	}
+}
+
+// Sets the callback to dump the relay protocol frames of the connection into,
+// e.g. one created by NewFrameWriter. A nil callback disables the dumping. To
+// capture the initialization handshake too, set it via WithFrameDump.
+func (c *Connection) SetFrameDump(dump FrameDump) {
+	c.frameLock.Lock()
+	defer c.frameLock.Unlock()
+
+	c.frameDump = dump
+}
+
+// Retrieves the currently configured frame dump, nil if disabled.
+func (c *Connection) getFrameDump() FrameDump {
+	c.frameLock.RLock()
+	defer c.frameLock.RUnlock()
+
+	return c.frameDump
+}
+
+// Starts recording an outbound frame if dumping is enabled, flushing the
+// previous one of the same packet. The socket lock is assumed to be held.
+func (c *Connection) startFrameOut(op byte) {
+	c.flushFrameOut()
+	if c.getFrameDump() != nil {
+		c.frameOut = &Frame{Time: time.Now(), Opcode: op, Size: 1}
+	}
+}
+
+// Dumps the recorded outbound frame, if any. The socket lock is assumed to be
+// held.
+func (c *Connection) flushFrameOut() {
+	if c.frameOut == nil {
+		return
+	}
+	frame := c.frameOut
+	c.frameOut = nil
+
+	if dump := c.getFrameDump(); dump != nil {
+		dump(frame)
+	}
+}
+
+// Starts recording an inbound frame if dumping is enabled. Only the network
+// receiver may call it.
+func (c *Connection) startFrameIn(op byte) {
+	if c.getFrameDump() != nil {
+		c.frameIn = &Frame{Time: time.Now(), Inbound: true, Opcode: op, Size: 1}
+	}
+}
+
+// Dumps the recorded inbound frame, if any. Only the network receiver may call
+// it.
+func (c *Connection) flushFrameIn() {
+	if c.frameIn == nil {
+		return
+	}
+	frame := c.frameIn
+	c.frameIn = nil
+
+	if dump := c.getFrameDump(); dump != nil {
+		dump(frame)
+	}
+}
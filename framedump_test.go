@@ -0,0 +1,99 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Tests that the relay protocol frames are dumped field by field, starting with
+// the handshake, until the dumping is disabled.
+func TestFrameDump(t *testing.T) {
+	// Connect a client collecting all the dumped frames
+	var (
+		frames []*Frame
+		lock   sync.Mutex
+	)
+	collect := func(frame *Frame) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		frames = append(frames, frame)
+	}
+	text := new(bytes.Buffer)
+	writer := NewFrameWriter(text)
+
+	conn, err := Connect(config.relay, WithFrameDump(func(frame *Frame) { collect(frame); writer(frame) }))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Publish an event to a subscribed topic and wait for it to arrive
+	topic := "frames" // Short enough to be fully previewed
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conn.Subscribe(topic, handler, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	event := []byte("a payload longer than the preview limit")
+	if err := conn.Publish(topic, event); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	select {
+	case <-handler.delivers:
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered.")
+	}
+	if err := conn.Unsubscribe(topic); err != nil {
+		t.Fatalf("failed to unsubscribe: %v.", err)
+	}
+	// Disable the dumping and verify no more frames are recorded
+	conn.SetFrameDump(nil)
+	if err := conn.Publish(topic, event); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	var ops []string
+	for _, frame := range frames {
+		dir := "out"
+		if frame.Inbound {
+			dir = "in"
+		}
+		ops = append(ops, dir+" "+frame.Op())
+	}
+	want := []string{"out init", "in init", "out subscribe", "out publish", "in publish", "out unsubscribe"}
+	if strings.Join(ops, ",") != strings.Join(want, ",") {
+		t.Fatalf("frame sequence mismatch: have %v, want %v.", ops, want)
+	}
+	// Verify the contents of the handshake and the event frames
+	if f := frames[0].Fields; len(f) != 3 || f[0].Kind != "string" || string(f[0].Data) != clientMagic[:framePreview] || string(f[1].Data) != protoVersion || f[2].Value != 0 {
+		t.Fatalf("init frame mismatch: have %v.", frames[0])
+	}
+	publish := frames[4]
+	if f := publish.Fields; len(f) != 2 || string(f[0].Data) != topic || f[1].Kind != "binary" || f[1].Value != uint64(len(event)) || !bytes.Equal(f[1].Data, event[:framePreview]) {
+		t.Fatalf("publish frame mismatch: have %v.", publish)
+	}
+	if size := 1 + 1 + len(topic) + 1 + len(event); publish.Size != size {
+		t.Fatalf("publish frame size mismatch: have %d, want %d.", publish.Size, size)
+	}
+	// Verify the textual dump of the frames
+	line := publish.String()
+	if !strings.HasPrefix(line, "in  publish (") || !strings.Contains(line, "binary[39]=") || !strings.HasSuffix(line, "...") {
+		t.Fatalf("publish frame format mismatch: have %q.", line)
+	}
+	if lines := strings.Count(text.String(), "\n"); lines != len(frames) {
+		t.Fatalf("dumped line count mismatch: have %d, want %d.", lines, len(frames))
+	}
+}
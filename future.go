@@ -0,0 +1,127 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the asynchronous request API, resolving futures straight from the
+// network receiver without any per request go-routines.
+
+package iris
+
+import (
+	"context"
+	"time"
+)
+
+// Pending result of an asynchronous request.
+type Future struct {
+	done  chan struct{} // Channel closed when the result is available
+	reply []byte        // Reply of the request, if successful
+	err   error         // Failure of the request, if any
+
+	start  time.Time   // Time the request was issued (latency tracking)
+	finish func(error) // Callback ending the request's span
+
+	correlation string // Correlation identifier of the request, empty if none
+	cluster     string // Cluster the request was sent to (statistics tracking)
+}
+
+// Returns a channel which is closed when the result of the request arrives.
+func (f *Future) Done() <-chan struct{} {
+	return f.done
+}
+
+// Waits for the request to complete, returning its reply or failure.
+func (f *Future) Result() ([]byte, error) {
+	<-f.done
+	return f.reply, f.err
+}
+
+// Issues an asynchronous request to be serviced by a member of the specified
+// cluster, load-balanced between all participant, returning a future through
+// which the reply can be retrieved.
+//
+// The call only blocks until the request is forwarded to the local Iris node,
+// returning an error if that fails. Asynchronous requests are neither retried
+// nor hedged, and are not passed through the outbound interceptors.
+//
+// The timeout unit is in milliseconds. Anything lower will fail with an error.
+func (c *Connection) RequestAsync(cluster string, request []byte, timeout time.Duration) (*Future, error) {
+	// Sanity check on the arguments
+	if len(cluster) == 0 {
+		return nil, invalidArgument("empty cluster identifier")
+	}
+	if request == nil || len(request) == 0 {
+		return nil, invalidArgument("nil or empty request")
+	}
+	timeoutms := int(timeout.Nanoseconds() / 1000000)
+	if timeoutms < 1 {
+		return nil, invalidArgument("invalid timeout %v < 1ms", timeout)
+	}
+	// Register the future for the result, unless the connection is down
+	future := &Future{
+		done:        make(chan struct{}),
+		cluster:     cluster,
+		start:       time.Now(),
+		correlation: c.correlationID(context.Background()),
+	}
+	select {
+	case <-c.term:
+		return nil, ErrClosed
+	default:
+	}
+	c.reqLock.Lock()
+	reqId := c.reqIdx
+	c.reqIdx++
+	c.reqFuts[reqId] = future
+	c.reqLock.Unlock()
+
+	// Send the request, abandoning the future on failure
+	c.Log.Debug("sending new async request", "local_request", reqId, "cluster", cluster, "correlation", future.correlation, "data", logLazyBlob(request), "timeout", timeout)
+	request, future.finish = c.traceOutbound(context.Background(), TraceRequest, cluster, wrapCorrelation(future.correlation, c.advertiseRequest(request)))
+	if err := c.sendRequest(reqId, cluster, request, timeoutms); err != nil {
+		c.reqLock.Lock()
+		delete(c.reqFuts, reqId)
+		c.reqLock.Unlock()
+
+		future.finish(err)
+		return nil, err
+	}
+	c.stats.requestSent(cluster)
+	return future, nil
+}
+
+// Resolves a pending future with the request result, returning false if there
+// is no such future (e.g. synchronous request or already resolved).
+func (c *Connection) resolveFuture(id uint64, reply []byte, err error) bool {
+	c.reqLock.Lock()
+	future, ok := c.reqFuts[id]
+	delete(c.reqFuts, id)
+	c.reqLock.Unlock()
+
+	if !ok {
+		return false
+	}
+	c.Log.Debug("async request completed", "local_request", id, "correlation", future.correlation, "data", logLazyBlob(reply), "error", err)
+
+	future.reply, future.err = reply, stampCorrelation(err, future.correlation)
+	future.finish(err)
+	c.stats.requestDone(future.cluster, time.Since(future.start), err)
+	close(future.done)
+	return true
+}
+
+// Fails all the pending futures with the given error.
+func (c *Connection) failFutures(err error) {
+	c.reqLock.RLock()
+	ids := make([]uint64, 0, len(c.reqFuts))
+	for id := range c.reqFuts {
+		ids = append(ids, id)
+	}
+	c.reqLock.RUnlock()
+
+	for _, id := range ids {
+		c.resolveFuture(id, nil, err)
+	}
+}
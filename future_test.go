@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Service handler for the async request tests, echoing requests back.
+type futureTestHandler struct{}
+
+func (f *futureTestHandler) Init(conn *Connection) error              { return nil }
+func (f *futureTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (f *futureTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (f *futureTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (f *futureTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Tests that many async requests can be fanned out and gathered.
+func TestRequestAsync(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		requests int
+	}{100}
+
+	// Register a new echo service to the relay
+	serv, err := Register(config.relay, config.cluster, new(futureTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Fan out a batch of requests and gather the replies
+	futures := make([]*Future, conf.requests)
+	for i := 0; i < conf.requests; i++ {
+		if futures[i], err = conn.RequestAsync(config.cluster, []byte(fmt.Sprintf("request-%d", i)), time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	for i, future := range futures {
+		select {
+		case <-future.Done():
+		case <-time.After(time.Second):
+			t.Fatalf("request %d timed out.", i)
+		}
+		reply, err := future.Result()
+		if err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+		if want := []byte(fmt.Sprintf("request-%d", i)); !bytes.Equal(reply, want) {
+			t.Fatalf("reply %d mismatch: have %s, want %s.", i, reply, want)
+		}
+	}
+	if sent := conn.Metrics().RequestsSent; sent != uint64(conf.requests) {
+		t.Fatalf("sent request count mismatch: have %v, want %v.", sent, conf.requests)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the gathering broadcasts, collecting the voluntary replies of the
+// cluster members within a time window.
+//
+// Since the relay does not route replies to broadcasts, the gatherer subscribes
+// to a private companion topic and the members publish their replies on it. The
+// broadcast carries the topic's nonce as a prefix, which members on older
+// bindings receive along with the message, never replying.
+
+package iris
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Magic prefix marking the gathering broadcasts and their reply topics.
+var gatherMagic = []byte("\x00iris-gather\x00")
+
+// Optional extension of ServiceHandler, answering gathering broadcasts (see
+// Connection.BroadcastGather). Services not implementing it receive them as
+// plain broadcasts via HandleBroadcast, without replying.
+type GatherHandler interface {
+	// Callback invoked with a gathering broadcast, returning the voluntary reply
+	// to send back to the gatherer, or nil to stay silent.
+	HandleGather(message []byte) []byte
+}
+
+// Broadcasts a message to all members of a cluster and collects the voluntary
+// replies of those implementing GatherHandler, arriving within the window. The
+// replies are returned in the order of arrival; a member staying silent is not
+// distinguishable from one not receiving the broadcast (best effort).
+func (c *Connection) BroadcastGather(cluster string, message []byte, window time.Duration) ([][]byte, error) {
+	// Sanity check on the arguments
+	if len(message) == 0 {
+		return nil, invalidArgument("nil or empty message")
+	}
+	if window <= 0 {
+		return nil, invalidArgument("non-positive gather window %v", window)
+	}
+	// Listen for the replies on a private topic, then broadcast the query
+	nonce := newReplayId()
+
+	var (
+		replies [][]byte
+		lock    sync.Mutex
+	)
+	collect := func(_ string, reply []byte) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		replies = append(replies, reply)
+	}
+	if err := c.SubscribeFunc(gatherTopic(nonce), collect, &TopicLimits{EventThreads: 1}); err != nil {
+		return nil, err
+	}
+	defer c.Unsubscribe(gatherTopic(nonce))
+
+	if err := c.Broadcast(cluster, wrapGather(nonce, message)); err != nil {
+		return nil, err
+	}
+	// Wait for the replies to arrive and return whatever was gathered
+	timer := time.NewTimer(window)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-c.term:
+		return nil, ErrClosed
+	}
+	lock.Lock()
+	defer lock.Unlock()
+
+	return append([][]byte(nil), replies...), nil
+}
+
+// Sends the reply of a gathering broadcast back to its gatherer, if any.
+func (c *Connection) answerGather(nonce uint64, reply []byte) {
+	if len(reply) == 0 {
+		return
+	}
+	if err := c.sendPublish(gatherTopic(nonce), reply); err != nil {
+		c.Log.Warn("failed to answer gathering broadcast", "reason", err)
+	}
+}
+
+// Returns the private topic the replies of a gathering broadcast are sent to.
+func gatherTopic(nonce uint64) string {
+	return string(gatherMagic) + strconv.FormatUint(nonce, 16)
+}
+
+// Wraps a message into a gathering broadcast with the given reply nonce.
+func wrapGather(nonce uint64, message []byte) []byte {
+	blob := make([]byte, len(gatherMagic)+8+len(message))
+	copy(blob, gatherMagic)
+	binary.BigEndian.PutUint64(blob[len(gatherMagic):], nonce)
+	copy(blob[len(gatherMagic)+8:], message)
+	return blob
+}
+
+// Unwraps a gathering broadcast into its reply nonce and message. Plain
+// broadcasts are returned as is, with a zero nonce.
+func unwrapGather(blob []byte) (uint64, []byte) {
+	if !bytes.HasPrefix(blob, gatherMagic) || len(blob) < len(gatherMagic)+8 {
+		return 0, blob
+	}
+	return binary.BigEndian.Uint64(blob[len(gatherMagic):]), blob[len(gatherMagic)+8:]
+}
@@ -0,0 +1,96 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+)
+
+// Service handler for the gather tests, answering gathering broadcasts if it
+// has a reply set, or forwarding them to a channel as plain broadcasts.
+type gatherTestHandler struct {
+	reply []byte
+	plain chan []byte
+}
+
+func (h *gatherTestHandler) Init(conn *Connection) error              { return nil }
+func (h *gatherTestHandler) HandleBroadcast(msg []byte)               { h.plain <- msg }
+func (h *gatherTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (h *gatherTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (h *gatherTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Answering variant of the gather test handler.
+type gatherReplyTestHandler struct {
+	gatherTestHandler
+}
+
+func (h *gatherReplyTestHandler) HandleGather(msg []byte) []byte {
+	return append(append([]byte(nil), msg...), h.reply...)
+}
+
+// Tests that gathering broadcasts collect the replies of the answering members,
+// while the others receive them as plain broadcasts.
+func TestBroadcastGather(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		members int
+		window  time.Duration
+	}{3, 250 * time.Millisecond}
+
+	// Register the answering members and a plain one
+	for i := 0; i < conf.members; i++ {
+		handler := &gatherReplyTestHandler{gatherTestHandler{reply: []byte(fmt.Sprintf("-%d", i))}}
+		serv, err := Register(config.relay, config.cluster, handler, nil)
+		if err != nil {
+			t.Fatalf("member %d: registration failed: %v.", i, err)
+		}
+		defer serv.Unregister()
+	}
+	plain := &gatherTestHandler{plain: make(chan []byte, 1)}
+	serv, err := Register(config.relay, config.cluster, plain, nil)
+	if err != nil {
+		t.Fatalf("plain registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Gather the replies and verify all members answered
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.BroadcastGather(config.cluster, []byte("query"), 0); err == nil {
+		t.Fatalf("zero gather window accepted.")
+	}
+	replies, err := conn.BroadcastGather(config.cluster, []byte("query"), conf.window)
+	if err != nil {
+		t.Fatalf("gather failed: %v.", err)
+	}
+	if len(replies) != conf.members {
+		t.Fatalf("reply count mismatch: have %d, want %d.", len(replies), conf.members)
+	}
+	sort.Slice(replies, func(i, j int) bool { return bytes.Compare(replies[i], replies[j]) < 0 })
+	for i, reply := range replies {
+		if want := fmt.Sprintf("query-%d", i); string(reply) != want {
+			t.Fatalf("reply %d mismatch: have %q, want %q.", i, reply, want)
+		}
+	}
+	// Verify the plain member received the unwrapped broadcast
+	select {
+	case msg := <-plain.plain:
+		if string(msg) != "query" {
+			t.Fatalf("plain broadcast mismatch: have %q, want %q.", msg, "query")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("plain broadcast not received.")
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the coalescing of the tunnel allowance grants, returning the space
+// freed up by many consumed messages to the relay in a few batched packets from
+// a single background sender, instead of one packet and go-routine per message.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Time window within which allowance grants are coalesced before being sent.
+const grantWindow = time.Millisecond
+
+// Number of coalesced grants of a single tunnel triggering an immediate send.
+const grantBatch = 32
+
+// Allowance accumulated for a tunnel but not yet granted to the relay.
+type pendingGrant struct {
+	space int // Total space freed up since the last grant
+	count int // Number of grants coalesced since the last send
+}
+
+// Queues an allowance grant of a tunnel for the background sender. The grant is
+// sent within the coalescing window, or right away if a batch accumulated or
+// the pending space reached the urgency threshold (e.g. a sizeable chunk of the
+// remote side's allowance, which might be stalling on it).
+func (c *Connection) grantAllowance(id uint64, space int, urgent int) {
+	c.grantLock.Lock()
+	grant, ok := c.grantPend[id]
+	if !ok {
+		grant = new(pendingGrant)
+		c.grantPend[id] = grant
+	}
+	grant.space += space
+	grant.count++
+	hurry := grant.count >= grantBatch || grant.space >= urgent
+	c.grantLock.Unlock()
+
+	// Wake the sender, rushing it if the grant is urgent
+	signal := c.grantSign
+	if hurry {
+		signal = c.grantUrge
+	}
+	select {
+	case signal <- struct{}{}:
+	default:
+	}
+}
+
+// Grants the allowance of a consumed inbound message back to the remote side.
+func (t *Tunnel) grant(space int) {
+	t.conn.grantAllowance(t.id, space, t.limits.BufferSize/4)
+}
+
+// Sends the queued allowance grants in batches until the connection terminates.
+func (c *Connection) grantAllowances() {
+	for {
+		// Wait until grants are queued, and for the coalescing window unless urged
+		select {
+		case <-c.grantSign:
+			timer := time.NewTimer(grantWindow)
+			select {
+			case <-timer.C:
+			case <-c.grantUrge:
+				timer.Stop()
+			case <-c.term:
+				timer.Stop()
+				return
+			}
+		case <-c.grantUrge:
+		case <-c.term:
+			return
+		}
+		c.flushGrants()
+	}
+}
+
+// Sends all the queued allowance grants to the relay in a single packet.
+func (c *Connection) flushGrants() {
+	c.grantLock.Lock()
+	pending := c.grantPend
+	c.grantPend = make(map[uint64]*pendingGrant, len(pending))
+	c.grantLock.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+	err := c.sendPacket(func() error {
+		// Nothing may follow the graceful close, the relay would reset the link
+		if atomic.LoadInt32(&c.closing) == 1 {
+			return nil
+		}
+		for id, grant := range pending {
+			if err := c.sendOp(opTunAllow); err != nil {
+				return err
+			}
+			if err := c.sendVarint(id); err != nil {
+				return err
+			}
+			if err := c.sendVarint(uint64(grant.space)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		c.Log.Debug("failed to grant tunnel allowances", "tunnels", len(pending), "reason", err)
+	}
+}
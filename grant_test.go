@@ -0,0 +1,61 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import "testing"
+
+// Tests that allowance grants are coalesced per tunnel, and that the sender is
+// only rushed when a batch accumulates or the urgency threshold is reached.
+func TestGrantCoalescing(t *testing.T) {
+	conn := &Connection{
+		grantPend: make(map[uint64]*pendingGrant),
+		grantSign: make(chan struct{}, 1),
+		grantUrge: make(chan struct{}, 1),
+	}
+	urged := func() bool {
+		select {
+		case <-conn.grantUrge:
+			return true
+		default:
+			return false
+		}
+	}
+	// Queue a few small grants and verify they're merged without urgency
+	for i := 0; i < 3; i++ {
+		conn.grantAllowance(1, 10, 1000)
+	}
+	conn.grantAllowance(2, 10, 1000)
+
+	if grant := conn.grantPend[1]; grant.space != 30 || grant.count != 3 {
+		t.Fatalf("coalesced grant mismatch: have %+v, want space 30, count 3.", *grant)
+	}
+	if len(conn.grantPend) != 2 {
+		t.Fatalf("pending tunnel count mismatch: have %d, want %d.", len(conn.grantPend), 2)
+	}
+	if len(conn.grantSign) != 1 {
+		t.Fatalf("sender not signalled of the small grants.")
+	}
+	if urged() {
+		t.Fatalf("sender urged by the small grants.")
+	}
+	// Verify that reaching the space threshold rushes the sender
+	conn.grantAllowance(1, 970, 1000)
+	if !urged() {
+		t.Fatalf("sender not urged at the space threshold.")
+	}
+	// Verify that a full batch of tiny grants rushes the sender too
+	for i := 0; i < grantBatch-2; i++ {
+		conn.grantAllowance(2, 1, 1000)
+		if urged() {
+			t.Fatalf("sender urged below the batch limit: %d grants.", i+2)
+		}
+	}
+	conn.grantAllowance(2, 1, 1000)
+	if !urged() {
+		t.Fatalf("sender not urged at the batch limit.")
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the registration of a service under multiple clusters at once.
+//
+// The relay protocol binds each link to a single cluster during the handshake,
+// and inbound messages don't carry the cluster they were addressed to. Hence a
+// multi-cluster service is assembled from one relay link per cluster, managed
+// as a single unit.
+
+package iris
+
+import (
+	"sort"
+)
+
+// Service instance registered as a member of multiple clusters (e.g. an old and
+// a new name during a migration).
+type ServiceGroup struct {
+	services map[string]*Service // Member services keyed by cluster name
+}
+
+// Connects to the Iris network and registers a service instance as a member of
+// each of the specified clusters, dispatching their inbound messages to the
+// associated handlers. The same handler may be shared between clusters, in
+// which case its Init method is called once per cluster.
+//
+// If any of the registrations fails, the already completed ones are torn down.
+func RegisterGroup(port int, handlers map[string]ServiceHandler, limits *ServiceLimits) (*ServiceGroup, error) {
+	// Sanity check on the arguments
+	if len(handlers) == 0 {
+		return nil, invalidArgument("no clusters to register")
+	}
+	// Register the clusters in a deterministic order, rolling back on failure
+	clusters := make([]string, 0, len(handlers))
+	for cluster := range handlers {
+		clusters = append(clusters, cluster)
+	}
+	sort.Strings(clusters)
+
+	group := &ServiceGroup{services: make(map[string]*Service)}
+	for _, cluster := range clusters {
+		serv, err := Register(port, cluster, handlers[cluster], limits)
+		if err != nil {
+			group.Unregister()
+			return nil, err
+		}
+		group.services[cluster] = serv
+	}
+	return group, nil
+}
+
+// Retrieves the member service registered under a cluster, or nil if the group
+// has no such member.
+func (g *ServiceGroup) Service(cluster string) *Service {
+	return g.services[cluster]
+}
+
+// Unregisters all the member services from the Iris network, returning the
+// first failure encountered, if any.
+func (g *ServiceGroup) Unregister() error {
+	var failure error
+	for _, serv := range g.services {
+		if err := serv.Unregister(); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
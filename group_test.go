@@ -0,0 +1,69 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Service handler for the group tests, tagging replies with its name.
+type groupTestHandler struct {
+	name string
+}
+
+func (g *groupTestHandler) Init(conn *Connection) error { return nil }
+func (g *groupTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (g *groupTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (g *groupTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (g *groupTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	return append([]byte(g.name+":"), req...), nil
+}
+
+// Tests that a service group serves all its clusters with the right handlers.
+func TestServiceGroup(t *testing.T) {
+	// Register a group with a shared and a dedicated handler
+	shared := &groupTestHandler{name: "shared"}
+	handlers := map[string]ServiceHandler{
+		config.cluster + "-a": shared,
+		config.cluster + "-b": shared,
+		config.cluster + "-c": &groupTestHandler{name: "dedicated"},
+	}
+	group, err := RegisterGroup(config.relay, handlers, nil)
+	if err != nil {
+		t.Fatalf("group registration failed: %v.", err)
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that each cluster is served by its own handler
+	for cluster, handler := range handlers {
+		if group.Service(cluster) == nil {
+			t.Fatalf("member service of %s missing.", cluster)
+		}
+		reply, err := conn.Request(cluster, []byte("ping"), time.Second)
+		if err != nil {
+			t.Fatalf("request to %s failed: %v.", cluster, err)
+		}
+		if want := handler.(*groupTestHandler).name + ":ping"; string(reply) != want {
+			t.Fatalf("reply from %s mismatch: have %s, want %s.", cluster, reply, want)
+		}
+	}
+	// Tear down the group and verify that all clusters are gone
+	if err := group.Unregister(); err != nil {
+		t.Fatalf("group unregistration failed: %v.", err)
+	}
+	for cluster := range handlers {
+		if _, err := conn.Request(cluster, []byte("ping"), 50*time.Millisecond); err != ErrTimeout {
+			t.Fatalf("request to unregistered %s mismatch: have %v, want %v.", cluster, err, ErrTimeout)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the liveness checking of the relay link.
+//
+// Since the relay protocol has no notion of pings, a round trip is made by a
+// request to a cluster nobody joins, with the minimal timeout: the relay expiring
+// the request proves that the link is alive and the relay responsive.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Health state of a connection's relay link.
+type HealthState int32
+
+const (
+	HealthConnected HealthState = iota // Relay link up and responsive
+	HealthDegraded                     // Relay link unresponsive or being re-established
+	HealthClosed                       // Connection torn down
+)
+
+func (s HealthState) String() string {
+	switch s {
+	case HealthConnected:
+		return "connected"
+	case HealthDegraded:
+		return "degraded"
+	case HealthClosed:
+		return "closed"
+	default:
+		return "unknown"
+	}
+}
+
+// User policy of the background heartbeats of a connection.
+type HeartbeatPolicy struct {
+	Interval time.Duration // Time to wait between two heartbeats
+	Timeout  time.Duration // Maximum time to wait for a heartbeat's round trip
+	Failures int           // Consecutive failures marking the link degraded
+}
+
+// Default policy of the background heartbeats of a connection.
+var defaultHeartbeatPolicy = HeartbeatPolicy{
+	Interval: 5 * time.Second,
+	Timeout:  time.Second,
+	Failures: 2,
+}
+
+// Cluster name used for the relay round trips, never joined by any service.
+const pingCluster = "\x00iris-ping\x00"
+
+// Makes a round trip to the local relay node, returning the time it took. The
+// round trip includes the relay's expiration of a 1ms request.
+func (c *Connection) Ping(timeout time.Duration) (time.Duration, error) {
+	// Create a reply and error channel for the results
+	repc := make(chan []byte, 1)
+	errc := make(chan error, 1)
+
+	c.reqLock.Lock()
+	reqId := c.reqIdx
+	c.reqIdx++
+	c.reqReps[reqId] = repc
+	c.reqErrs[reqId] = errc
+	c.reqLock.Unlock()
+
+	// Make sure the result channels are cleaned up
+	defer func() {
+		c.reqLock.Lock()
+		delete(c.reqReps, reqId)
+		delete(c.reqErrs, reqId)
+		close(repc)
+		close(errc)
+		c.reqLock.Unlock()
+	}()
+	// Send the ping and wait for any answer from the relay
+	start := time.Now()
+	if err := c.sendRequest(reqId, pingCluster, []byte{0x00}, 1); err != nil {
+		return 0, err
+	}
+	select {
+	case <-c.term:
+		return 0, ErrClosed
+	case <-time.After(timeout):
+		return 0, ErrTimeout
+	case <-repc:
+	case err := <-errc:
+		if err == ErrClosed {
+			return 0, err
+		}
+	}
+	return time.Since(start), nil
+}
+
+// Retrieves the current health state of the relay link.
+func (c *Connection) Health() HealthState {
+	return HealthState(atomic.LoadInt32(&c.health))
+}
+
+// Sets a callback to invoke with the old and new health state upon every change.
+// The callback runs synchronously on internal go-routines, so it should return
+// swiftly. Pass nil to remove it.
+func (c *Connection) SetHealthCallback(callback func(old, new HealthState)) {
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+
+	c.healthFunc = callback
+}
+
+// Enables periodic heartbeats on the relay link, marking the connection degraded
+// if too many consecutive ones fail and connected again after a success.
+//
+// Any unset fields (i.e. value of zero) of the policy will default to the
+// preset ones.
+func (c *Connection) EnableHeartbeat(policy *HeartbeatPolicy) {
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+
+	if c.beatStop != nil {
+		close(c.beatStop)
+	}
+	c.beatStop = make(chan struct{})
+	go c.heartbeat(finalizeHeartbeatPolicy(policy), c.beatStop)
+}
+
+// Disables the periodic heartbeats on the relay link.
+func (c *Connection) DisableHeartbeat() {
+	c.healthLock.Lock()
+	defer c.healthLock.Unlock()
+
+	if c.beatStop != nil {
+		close(c.beatStop)
+		c.beatStop = nil
+	}
+}
+
+// Merges the user requested policy with the defaults.
+func finalizeHeartbeatPolicy(user *HeartbeatPolicy) *HeartbeatPolicy {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultHeartbeatPolicy
+	}
+	// Check each field and merge only non-specified ones
+	policy := new(HeartbeatPolicy)
+	*policy = *user
+
+	if user.Interval <= 0 {
+		policy.Interval = defaultHeartbeatPolicy.Interval
+	}
+	if user.Timeout <= 0 {
+		policy.Timeout = defaultHeartbeatPolicy.Timeout
+	}
+	if user.Failures <= 0 {
+		policy.Failures = defaultHeartbeatPolicy.Failures
+	}
+	return policy
+}
+
+// Periodically pings the relay, tracking the health of the link until stopped
+// or the connection is torn down.
+func (c *Connection) heartbeat(policy *HeartbeatPolicy, stop chan struct{}) {
+	ticker := time.NewTicker(policy.Interval)
+	defer ticker.Stop()
+
+	failures := 0
+	for {
+		select {
+		case <-stop:
+			return
+		case <-c.term:
+			return
+		case <-ticker.C:
+		}
+		rtt, err := c.Ping(policy.Timeout)
+		if err != nil {
+			failures++
+			c.Log.Warn("relay heartbeat failed", "failures", failures, "reason", err)
+			if failures >= policy.Failures {
+				c.setHealth(HealthDegraded)
+			}
+			continue
+		}
+		c.Log.Debug("relay heartbeat succeeded", "rtt", rtt)
+		failures = 0
+		c.setHealth(HealthConnected)
+	}
+}
+
+// Transitions the connection into a new health state, notifying the callback if
+// it changed. A closed connection stays closed.
+func (c *Connection) setHealth(state HealthState) {
+	for {
+		old := HealthState(atomic.LoadInt32(&c.health))
+		if old == state || old == HealthClosed {
+			return
+		}
+		if atomic.CompareAndSwapInt32(&c.health, int32(old), int32(state)) {
+			c.Log.Info("connection health changed", "old", old, "new", state)
+
+			c.healthLock.Lock()
+			callback := c.healthFunc
+			c.healthLock.Unlock()
+
+			if callback != nil {
+				callback(old, state)
+			}
+			return
+		}
+	}
+}
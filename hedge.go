@@ -0,0 +1,130 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the hedging of idempotent requests to cut the tail latency.
+
+package iris
+
+import (
+	"context"
+	"time"
+)
+
+// User policy of the hedging of idempotent requests.
+type HedgePolicy struct {
+	Delay  time.Duration // Time to wait for a reply before issuing a duplicate
+	Hedges int           // Maximum number of duplicates issued per request
+}
+
+// Default policy of the hedging of idempotent requests.
+var defaultHedgePolicy = HedgePolicy{
+	Delay:  50 * time.Millisecond,
+	Hedges: 1,
+}
+
+// Enables the hedging of idempotent requests: if no reply arrives within the
+// delay, a duplicate is issued to the cluster (likely load balanced to another
+// member) and the first reply is taken, abandoning the rest. Only requests
+// marked as idempotent are hedged (see Idempotent and RequestIdempotent).
+//
+// Any unset fields (i.e. value of zero) of the policy will default to the
+// preset ones.
+func (c *Connection) EnableHedging(policy *HedgePolicy) {
+	c.hedgeLock.Lock()
+	defer c.hedgeLock.Unlock()
+
+	c.hedgePolicy = finalizeHedgePolicy(policy)
+}
+
+// Disables the hedging of idempotent requests.
+func (c *Connection) DisableHedging() {
+	c.hedgeLock.Lock()
+	defer c.hedgeLock.Unlock()
+
+	c.hedgePolicy = nil
+}
+
+// Merges the user requested policy with the defaults.
+func finalizeHedgePolicy(user *HedgePolicy) *HedgePolicy {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultHedgePolicy
+	}
+	// Check each field and merge only non-specified ones
+	policy := new(HedgePolicy)
+	*policy = *user
+
+	if user.Delay <= 0 {
+		policy.Delay = defaultHedgePolicy.Delay
+	}
+	if user.Hedges <= 0 {
+		policy.Hedges = defaultHedgePolicy.Hedges
+	}
+	return policy
+}
+
+// Result of a single hedged request attempt.
+type hedgeResult struct {
+	reply []byte
+	err   error
+}
+
+// Executes a request, issuing duplicates if it's idempotent, hedging is enabled
+// and the reply is late. The first reply or non-transient failure is returned.
+func (c *Connection) hedgeRequest(ctx context.Context, cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	c.hedgeLock.Lock()
+	policy := c.hedgePolicy
+	c.hedgeLock.Unlock()
+
+	if policy == nil || !isIdempotent(ctx) {
+		return c.requestOnce(ctx, cluster, request, timeout)
+	}
+	// Abandon all the outstanding duplicates when returning
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make(chan hedgeResult, policy.Hedges+1)
+	start := time.Now()
+	issue := func(timeout time.Duration) {
+		go func() {
+			reply, err := c.requestOnce(ctx, cluster, request, timeout)
+			results <- hedgeResult{reply, err}
+		}()
+	}
+	issue(timeout)
+	issued, pending := 1, 1
+
+	hedge := time.NewTimer(policy.Delay)
+	defer hedge.Stop()
+
+	var err error
+	for {
+		select {
+		case <-hedge.C:
+			// Issue a duplicate bounded by the original deadline
+			left := timeout - time.Since(start)
+			if left < time.Millisecond {
+				continue
+			}
+			c.Log.Debug("hedging late request", "cluster", cluster, "hedge", issued)
+			issue(left)
+			issued++
+			pending++
+
+			if issued <= policy.Hedges {
+				hedge.Reset(policy.Delay)
+			}
+		case res := <-results:
+			pending--
+			if res.err == nil || !retryable(res.err) {
+				return res.reply, res.err
+			}
+			if err = res.err; pending == 0 {
+				return nil, err
+			}
+		}
+	}
+}
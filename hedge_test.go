@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the hedging tests, stalling the first two requests.
+type hedgeTestHandler struct {
+	count int32
+}
+
+func (h *hedgeTestHandler) Init(conn *Connection) error { return nil }
+func (h *hedgeTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (h *hedgeTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (h *hedgeTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (h *hedgeTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if atomic.AddInt32(&h.count, 1) <= 2 {
+		time.Sleep(250 * time.Millisecond)
+	}
+	return req, nil
+}
+
+// Tests that late idempotent requests are hedged, while others are left alone.
+func TestRequestHedging(t *testing.T) {
+	// Register a new service to the relay, stalling the first two requests
+	handler := new(hedgeTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	conn.EnableHedging(&HedgePolicy{Delay: 25 * time.Millisecond})
+
+	// Non-idempotent requests should wait for the stalled reply
+	start := time.Now()
+	if _, err := conn.Request(config.cluster, []byte{0x01}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed < 250*time.Millisecond {
+		t.Fatalf("non-idempotent request hedged: took %v.", elapsed)
+	}
+	// Idempotent requests should be hedged, taking the fast reply
+	start = time.Now()
+	rep, err := conn.RequestIdempotent(config.cluster, []byte{0x02}, time.Second)
+	if err != nil {
+		t.Fatalf("idempotent request failed: %v.", err)
+	}
+	if len(rep) != 1 || rep[0] != 0x02 {
+		t.Fatalf("reply mismatch: have %v, want %v.", rep, []byte{0x02})
+	}
+	if elapsed := time.Since(start); elapsed > 200*time.Millisecond {
+		t.Fatalf("idempotent request not hedged: took %v.", elapsed)
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the lifecycle hooks of the messaging operations, reporting their
+// timing and payload sizes to lightweight monitoring integrations.
+
+package iris
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Lifecycle callbacks invoked around the messaging operations of a connection,
+// as a lighter alternative to a full Tracer for thin APM integrations. Unset
+// callbacks are skipped. The set ones run synchronously on the go-routine of the
+// operation, so they should return swiftly.
+type Hooks struct {
+	OnRequestStart   func(info *RequestInfo) // Invoked before an outbound request is issued
+	OnRequestEnd     func(info *RequestInfo) // Invoked after an outbound request completed (same info as at start)
+	OnTunnelOpen     func(info *TunnelInfo)  // Invoked after a tunnel was built or accepted
+	OnTunnelClose    func(info *TunnelInfo)  // Invoked after an open tunnel was torn down (same info as at open)
+	OnPublish        func(info *PublishInfo) // Invoked after an event was published (or failed to)
+	OnEventDelivered func(info *EventInfo)   // Invoked after a subscription handler processed an event
+}
+
+// Details of an outbound request, reported to the request hooks. The fields of
+// the outcome are only set by the time the end hook runs.
+type RequestInfo struct {
+	Context context.Context // Context the request was issued with
+	Cluster string          // Cluster the request was issued to
+	Size    int             // Size of the request payload
+	Started time.Time       // Time instance the request was issued at
+
+	Reply   int           // Size of the reply payload
+	Elapsed time.Duration // Time it took the request to complete
+	Err     error         // Failure of the request, if any
+}
+
+// Details of a tunnel, reported to the tunnel hooks. The fields of the outcome
+// are only set by the time the close hook runs.
+type TunnelInfo struct {
+	ID      uint64    // Local identifier of the tunnel
+	Cluster string    // Remote cluster of an outbound tunnel, empty for inbound ones
+	Inbound bool      // Whether the tunnel was initiated by the remote side
+	Opened  time.Time // Time instance the tunnel was opened at
+
+	Elapsed   time.Duration // Time the tunnel was open for
+	BytesSent uint64        // Total data chunk bytes sent through the tunnel
+	BytesRecv uint64        // Total data chunk bytes received through the tunnel
+	Err       error         // Failure the tunnel was torn down with, if any
+}
+
+// Details of an event publish, reported to the publish hook.
+type PublishInfo struct {
+	Topic   string        // Topic the event was published to
+	Size    int           // Size of the event payload
+	Elapsed time.Duration // Time it took to forward the event to the relay
+	Err     error         // Failure of the publish, if any
+}
+
+// Details of an event delivery, reported to the delivery hook.
+type EventInfo struct {
+	Topic   string        // Topic the event arrived on
+	Size    int           // Size of the event payload
+	Elapsed time.Duration // Time the subscription handler ran for
+}
+
+// Sets the lifecycle hooks to invoke around the messaging operations of the
+// connection. Nil hooks disable them.
+func (c *Connection) SetHooks(hooks *Hooks) {
+	c.hookLock.Lock()
+	defer c.hookLock.Unlock()
+
+	c.hooks = hooks
+}
+
+// Retrieves the currently configured hooks, nil if disabled.
+func (c *Connection) getHooks() *Hooks {
+	c.hookLock.RLock()
+	defer c.hookLock.RUnlock()
+
+	return c.hooks
+}
+
+// Reports the start of an outbound request if hooked, returning a callback to
+// report its outcome. The returned callback always needs to be invoked.
+func (c *Connection) hookRequest(ctx context.Context, cluster string, request []byte) func([]byte, error) {
+	hooks := c.getHooks()
+	if hooks == nil || (hooks.OnRequestStart == nil && hooks.OnRequestEnd == nil) {
+		return func([]byte, error) {}
+	}
+	info := &RequestInfo{Context: ctx, Cluster: cluster, Size: len(request), Started: time.Now()}
+	if hooks.OnRequestStart != nil {
+		hooks.OnRequestStart(info)
+	}
+	return func(reply []byte, err error) {
+		info.Reply, info.Elapsed, info.Err = len(reply), time.Since(info.Started), err
+		if hooks.OnRequestEnd != nil {
+			hooks.OnRequestEnd(info)
+		}
+	}
+}
+
+// Starts timing a publish if hooked, returning a callback to report its outcome.
+// The returned callback always needs to be invoked.
+func (c *Connection) hookPublish(topic string, event []byte) func(error) {
+	hooks := c.getHooks()
+	if hooks == nil || hooks.OnPublish == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	return func(err error) {
+		hooks.OnPublish(&PublishInfo{Topic: topic, Size: len(event), Elapsed: time.Since(start), Err: err})
+	}
+}
+
+// Reports a processed event delivery if hooked.
+func (c *Connection) hookEvent(topic string, event []byte, start time.Time) {
+	if hooks := c.getHooks(); hooks != nil && hooks.OnEventDelivered != nil {
+		hooks.OnEventDelivered(&EventInfo{Topic: topic, Size: len(event), Elapsed: time.Since(start)})
+	}
+}
+
+// Reports a freshly opened tunnel if hooked, retaining its details for the close
+// hook.
+func (t *Tunnel) hookOpen(cluster string, inbound bool) {
+	hooks := t.conn.getHooks()
+	if hooks == nil || (hooks.OnTunnelOpen == nil && hooks.OnTunnelClose == nil) {
+		return
+	}
+	info := &TunnelInfo{ID: t.id, Cluster: cluster, Inbound: inbound, Opened: time.Now()}
+
+	t.itoaLock.Lock()
+	t.hookInfo, t.hookClose = info, hooks.OnTunnelClose
+	t.itoaLock.Unlock()
+
+	if hooks.OnTunnelOpen != nil {
+		hooks.OnTunnelOpen(info)
+	}
+}
+
+// Reports the tear-down of an opened tunnel if hooked.
+func (t *Tunnel) hookDown() {
+	t.itoaLock.Lock()
+	info, close := t.hookInfo, t.hookClose
+	t.hookInfo, t.hookClose = nil, nil
+	t.itoaLock.Unlock()
+
+	if info == nil || close == nil {
+		return
+	}
+	info.Elapsed = time.Since(info.Opened)
+	info.BytesSent = atomic.LoadUint64(&t.stats.bytesOut)
+	info.BytesRecv = atomic.LoadUint64(&t.stats.bytesIn)
+	info.Err = t.stat
+	close(info)
+}
@@ -0,0 +1,132 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Service handler for the hook tests, echoing requests and tunnel messages.
+type hooksTestHandler struct{}
+
+func (h *hooksTestHandler) Init(conn *Connection) error              { return nil }
+func (h *hooksTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (h *hooksTestHandler) HandleRequest(req []byte) ([]byte, error) { return append(req, req...), nil }
+func (h *hooksTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (h *hooksTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, time.Second); err != nil {
+			return
+		}
+	}
+}
+
+// Tests that the lifecycle hooks are invoked around the messaging operations
+// with the expected details.
+func TestHooks(t *testing.T) {
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, new(hooksTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a client with all the hooks set
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	starts, ends := make(chan *RequestInfo, 1), make(chan *RequestInfo, 1)
+	opens, closes := make(chan *TunnelInfo, 1), make(chan *TunnelInfo, 1)
+	publishes, events := make(chan *PublishInfo, 1), make(chan *EventInfo, 1)
+
+	conn.SetHooks(&Hooks{
+		OnRequestStart:   func(info *RequestInfo) { starts <- info },
+		OnRequestEnd:     func(info *RequestInfo) { ends <- info },
+		OnTunnelOpen:     func(info *TunnelInfo) { opens <- info },
+		OnTunnelClose:    func(info *TunnelInfo) { closes <- info },
+		OnPublish:        func(info *PublishInfo) { publishes <- info },
+		OnEventDelivered: func(info *EventInfo) { events <- info },
+	})
+	// Verify the request hooks
+	if _, err := conn.Request(config.cluster, []byte("request"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	start, end := <-starts, <-ends
+	if start != end {
+		t.Fatalf("request info mismatch between start and end hooks.")
+	}
+	if end.Cluster != config.cluster || end.Size != 7 || end.Reply != 14 || end.Err != nil || end.Elapsed <= 0 {
+		t.Fatalf("request info mismatch: have %+v.", end)
+	}
+	// Verify the tunnel hooks
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	open := <-opens
+	if open.Cluster != config.cluster || open.Inbound {
+		t.Fatalf("tunnel open info mismatch: have %+v.", open)
+	}
+	if err := tun.Send([]byte("tunnel"), time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if _, err := tun.Recv(time.Second); err != nil {
+		t.Fatalf("tunnel receive failed: %v.", err)
+	}
+	if err := tun.Close(); err != nil {
+		t.Fatalf("tunnel close failed: %v.", err)
+	}
+	select {
+	case closed := <-closes:
+		if closed != open || closed.BytesSent == 0 || closed.BytesRecv == 0 || closed.Elapsed <= 0 {
+			t.Fatalf("tunnel close info mismatch: have %+v.", closed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("tunnel close hook not invoked.")
+	}
+	// Verify the publish and delivery hooks
+	if err := conn.SubscribeFunc(config.topic, func(string, []byte) {}, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	if info := <-publishes; info.Topic != config.topic || info.Size != 5 || info.Err != nil {
+		t.Fatalf("publish info mismatch: have %+v.", info)
+	}
+	select {
+	case info := <-events:
+		if info.Topic != config.topic || info.Size != 5 {
+			t.Fatalf("delivery info mismatch: have %+v.", info)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("delivery hook not invoked.")
+	}
+	// Verify that removed hooks are not invoked any more
+	conn.SetHooks(nil)
+	if _, err := conn.Request(config.cluster, []byte("request"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	select {
+	case <-starts:
+		t.Fatalf("removed hook invoked.")
+	default:
+	}
+}
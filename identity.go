@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the caller identity advertised along with outbound requests and
+// tunnels, letting services apply per-caller policies.
+//
+// Since the relay does not reveal the origin of requests and tunnels, the
+// identity is self-reported by the initiating binding, prefixed to requests and
+// sent ahead of the tunnel data, both of which older remote bindings deliver to
+// the application as is. It is meant for quotas and auditing among cooperating
+// services, not as an authentication mechanism.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"sync/atomic"
+	"time"
+)
+
+// Identity of the remote side initiating an inbound request or tunnel.
+type Peer struct {
+	Cluster string // Cluster the initiator is registered as, empty for clients
+	Node    string // Identifier of the initiating connection
+}
+
+// Prefix identifying a request carrying the identity of its caller.
+var peerMagic = []byte("\x00iris-peer\x00")
+
+// Context key under which the identity of a request's caller is stored.
+type peerCtxKey struct{}
+
+// Enables or disables advertising the identity of the connection (its cluster
+// and connection identifier) along with all outbound requests and tunnels.
+func (c *Connection) SetAdvertiseIdentity(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&c.advertise, 1)
+	} else {
+		atomic.StoreInt32(&c.advertise, 0)
+	}
+}
+
+// Retrieves the identity of the caller of an inbound request from the context
+// passed to ContextRequestHandler, or false if the caller didn't advertise it.
+func PeerFromContext(ctx context.Context) (*Peer, bool) {
+	peer, ok := ctx.Value(peerCtxKey{}).(*Peer)
+	return peer, ok
+}
+
+// Retrieves the identity of the initiator of an inbound tunnel, or nil if it is
+// not (yet) known. The identity always precedes any application message, so it
+// is available after the first successful Recv if advertised at all. Outbound
+// tunnels have no peer identity.
+func (t *Tunnel) Peer() *Peer {
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	return t.peer
+}
+
+// Embeds the identity of the connection into an outbound request if advertising
+// is enabled.
+func (c *Connection) advertiseRequest(request []byte) []byte {
+	if atomic.LoadInt32(&c.advertise) == 0 {
+		return request
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(peerMagic)
+	buf.Write(encodePeer(c.cluster, c.envId))
+	buf.Write(request)
+	return buf.Bytes()
+}
+
+// Splits the caller identity off an inbound request, if any. Requests without
+// identities (or with malformed ones) are returned as is.
+func unwrapPeer(request []byte) (*Peer, []byte) {
+	if !bytes.HasPrefix(request, peerMagic) {
+		return nil, request
+	}
+	peer, rest, ok := decodePeer(request[len(peerMagic):])
+	if !ok {
+		return nil, request
+	}
+	return peer, rest
+}
+
+// Injects the identity of a request's caller into the handler context, if any.
+func withPeer(ctx context.Context, peer *Peer) context.Context {
+	if peer == nil {
+		return ctx
+	}
+	return context.WithValue(ctx, peerCtxKey{}, peer)
+}
+
+// Announces the identity of the connection to the remote endpoint of a freshly
+// built outbound tunnel if advertising is enabled.
+func (t *Tunnel) advertise(ctx context.Context, timeout time.Duration) error {
+	if atomic.LoadInt32(&t.conn.advertise) == 0 {
+		return nil
+	}
+	ident := encodePeer(t.conn.cluster, t.conn.envId)
+	return t.send(ctx, wrapTunnelCtl(tunnelPeer, string(ident)), time.After(timeout))
+}
+
+// Records the identity announced by the initiator of an inbound tunnel. The
+// inbound lock is assumed to be held.
+func (t *Tunnel) handlePeer(ident string) {
+	peer, _, ok := decodePeer([]byte(ident))
+	if !ok {
+		t.Log.Warn("malformed peer identity discarded")
+		return
+	}
+	t.peer = peer
+	t.Log.Info("tunnel peer identified", "cluster", peer.Cluster, "node", peer.Node)
+}
+
+// Serializes an identity as a pair of length-tagged strings.
+func encodePeer(cluster, node string) []byte {
+	buf := new(bytes.Buffer)
+
+	var scratch [binary.MaxVarintLen64]byte
+	for _, field := range []string{cluster, node} {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(field)))])
+		buf.WriteString(field)
+	}
+	return buf.Bytes()
+}
+
+// Deserializes an identity, returning the remainder of the blob after it.
+func decodePeer(blob []byte) (*Peer, []byte, bool) {
+	var fields [2]string
+	for i := range fields {
+		size, n := binary.Uvarint(blob)
+		if n <= 0 || size > uint64(len(blob)-n) {
+			return nil, nil, false
+		}
+		fields[i] = string(blob[n : n+int(size)])
+		blob = blob[n+int(size):]
+	}
+	return &Peer{Cluster: fields[0], Node: fields[1]}, blob, true
+}
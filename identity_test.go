@@ -0,0 +1,97 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// Service handler for the identity tests, replying with the caller identities.
+type identityTestHandler struct {
+	conn  *Connection
+	peers chan *Peer
+}
+
+func (i *identityTestHandler) Init(conn *Connection) error              { i.conn = conn; return nil }
+func (i *identityTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (i *identityTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (i *identityTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (i *identityTestHandler) HandleRequestCtx(ctx context.Context, req []byte) ([]byte, error) {
+	if peer, ok := PeerFromContext(ctx); ok {
+		return []byte(peer.Cluster + "/" + peer.Node), nil
+	}
+	return []byte("anonymous"), nil
+}
+
+func (i *identityTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	if _, err := tun.Recv(time.Second); err != nil {
+		panic(err)
+	}
+	i.peers <- tun.Peer()
+}
+
+// Tests that advertised caller identities reach the request and tunnel handlers.
+func TestAdvertiseIdentity(t *testing.T) {
+	// Register a new service to the relay
+	handler := &identityTestHandler{peers: make(chan *Peer, 1)}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect a client and verify that requests are anonymous by default
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if reply, err := conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("anonymous request failed: %v.", err)
+	} else if string(reply) != "anonymous" {
+		t.Fatalf("anonymous caller mismatch: have %s, want %s.", reply, "anonymous")
+	}
+	// Enable advertising and verify the identities of both a client and a service
+	conn.SetAdvertiseIdentity(true)
+	handler.conn.SetAdvertiseIdentity(true)
+
+	for _, caller := range []*Connection{conn, handler.conn} {
+		want := caller.cluster + "/" + caller.envId
+		if reply, err := caller.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+			t.Fatalf("identified request failed: %v.", err)
+		} else if string(reply) != want {
+			t.Fatalf("request caller mismatch: have %s, want %s.", reply, want)
+		}
+	}
+	// Open a tunnel and verify the identity of its initiator
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	if peer := tun.Peer(); peer != nil {
+		t.Fatalf("outbound tunnel has peer: %v.", peer)
+	}
+	if err := tun.Send([]byte{0x00}, time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	select {
+	case peer := <-handler.peers:
+		if peer == nil || peer.Cluster != "" || peer.Node != conn.envId {
+			t.Fatalf("tunnel peer mismatch: have %v, want %v.", peer, &Peer{Node: conn.envId})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("tunnel peer not reported.")
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the idle timeout of tunnels, closing the ones that neither sent nor
+// received any message for a while, so tunnels leaked by sloppy callers don't
+// accumulate on long-running services.
+//
+// Unlike the keepalive, the idle timeout looks only at the application messages:
+// keepalive probes and other control traffic do not count as activity.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Number of activity checks per idle timeout period, bounding the overshoot of
+// the close to a fraction of the timeout.
+const idleChecks = 4
+
+// Starts the idle timer of a freshly built tunnel, if enabled.
+func (t *Tunnel) startIdleTimer() {
+	if t.limits.IdleTimeout > 0 {
+		go t.idleTimer(t.limits.IdleTimeout)
+	}
+}
+
+// Closes the tunnel once no message was sent or received for the timeout.
+func (t *Tunnel) idleTimer(timeout time.Duration) {
+	interval := timeout / idleChecks
+	if interval <= 0 {
+		interval = timeout
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seen, active := t.traffic(), time.Now()
+	for {
+		select {
+		case <-t.term:
+			return
+		case now := <-ticker.C:
+			if traffic := t.traffic(); traffic != seen {
+				seen, active = traffic, now
+				continue
+			}
+			if idle := now.Sub(active); idle >= timeout {
+				t.Log.Info("tunnel idle, closing", "idle", idle, "timeout", timeout)
+				atomic.StoreInt32(&t.idle, 1)
+				t.Close()
+				return
+			}
+		}
+	}
+}
+
+// Returns the total number of application messages sent and received.
+func (t *Tunnel) traffic() uint64 {
+	return atomic.LoadUint64(&t.stats.msgsOut) + atomic.LoadUint64(&t.stats.msgsIn)
+}
@@ -0,0 +1,74 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the interceptor chains wrapping the outbound messaging operations
+// and the inbound handler dispatch.
+
+package iris
+
+import "context"
+
+// Continuation of an interceptor chain, executing the remainder of a messaging
+// operation: the next interceptor, or ultimately the operation itself. The
+// returned reply is only meaningful for requests, nil otherwise.
+type Invoker func(ctx context.Context, op TraceOp, target string, payload []byte) ([]byte, error)
+
+// Hook wrapping a messaging operation towards or from target (cluster or topic).
+// An interceptor may inspect or transform the context, target and payload before
+// calling next, inspect or transform the results afterwards, or short circuit the
+// operation altogether by not calling next at all. Tunnel operations carry no
+// payload.
+type Interceptor func(ctx context.Context, op TraceOp, target string, payload []byte, next Invoker) ([]byte, error)
+
+// Sets the interceptors to wrap all outbound operations of the connection with
+// (broadcast, request, publish and tunnel construction), replacing any previous
+// ones. The first interceptor is the outermost one.
+func (c *Connection) SetOutboundInterceptors(chain ...Interceptor) {
+	c.icptLock.Lock()
+	defer c.icptLock.Unlock()
+
+	c.icptOut = append([]Interceptor(nil), chain...)
+}
+
+// Sets the interceptors to wrap all inbound handler dispatches of the connection
+// with (broadcast, request, event and tunnel), replacing any previous ones. The
+// first interceptor is the outermost one.
+func (c *Connection) SetInboundInterceptors(chain ...Interceptor) {
+	c.icptLock.Lock()
+	defer c.icptLock.Unlock()
+
+	c.icptIn = append([]Interceptor(nil), chain...)
+}
+
+// Runs an outbound operation through the outbound interceptor chain.
+func (c *Connection) interceptOutbound(ctx context.Context, op TraceOp, target string, payload []byte, final Invoker) ([]byte, error) {
+	c.icptLock.RLock()
+	chain := c.icptOut
+	c.icptLock.RUnlock()
+
+	return chainInvoker(chain, final)(ctx, op, target, payload)
+}
+
+// Runs an inbound handler dispatch through the inbound interceptor chain.
+func (c *Connection) interceptInbound(ctx context.Context, op TraceOp, target string, payload []byte, final Invoker) ([]byte, error) {
+	c.icptLock.RLock()
+	chain := c.icptIn
+	c.icptLock.RUnlock()
+
+	// Recover handler panics within the chain, interceptor ones around it
+	return c.guard(chainInvoker(chain, c.guard(final)))(ctx, op, target, payload)
+}
+
+// Folds an interceptor chain around the final invoker.
+func chainInvoker(chain []Interceptor, final Invoker) Invoker {
+	for i := len(chain) - 1; i >= 0; i-- {
+		icpt, next := chain[i], final
+		final = func(ctx context.Context, op TraceOp, target string, payload []byte) ([]byte, error) {
+			return icpt(ctx, op, target, payload, next)
+		}
+	}
+	return final
+}
@@ -0,0 +1,78 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Service handler for the interceptor tests, echoing requests back.
+type interceptTestHandler struct {
+	conn *Connection
+}
+
+func (i *interceptTestHandler) Init(conn *Connection) error              { i.conn = conn; return nil }
+func (i *interceptTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (i *interceptTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (i *interceptTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (i *interceptTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Tests that interceptor chains run in order around requests and that they
+// can transform payloads or short circuit the operation.
+func TestInterceptRequest(t *testing.T) {
+	// Register a new service to the relay, rejecting unauthenticated requests
+	handler := new(interceptTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.SetInboundInterceptors(func(ctx context.Context, op TraceOp, target string, payload []byte, next Invoker) ([]byte, error) {
+		if !bytes.HasPrefix(payload, []byte("token:")) {
+			return nil, errors.New("unauthenticated")
+		}
+		return next(ctx, op, target, payload[len("token:"):])
+	})
+	// Connect a client and issue a request without interceptors
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Request(config.cluster, []byte("request"), time.Second); err == nil || err.Error() != "unauthenticated" {
+		t.Fatalf("unauthenticated request error mismatch: have %v, want %v.", err, "unauthenticated")
+	}
+	// Inject the token and a reply decorator, checking the execution order
+	var order []string
+	conn.SetOutboundInterceptors(
+		func(ctx context.Context, op TraceOp, target string, payload []byte, next Invoker) ([]byte, error) {
+			order = append(order, "outer")
+			reply, err := next(ctx, op, target, payload)
+			return append([]byte("reply:"), reply...), err
+		},
+		func(ctx context.Context, op TraceOp, target string, payload []byte, next Invoker) ([]byte, error) {
+			order = append(order, "inner")
+			return next(ctx, op, target, append([]byte("token:"), payload...))
+		},
+	)
+	reply, err := conn.Request(config.cluster, []byte("request"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if want := []byte("reply:request"); !bytes.Equal(reply, want) {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, want)
+	}
+	if len(order) != 2 || order[0] != "outer" || order[1] != "inner" {
+		t.Fatalf("interceptor order mismatch: have %v, want %v.", order, []string{"outer", "inner"})
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irisaudit contains iris.AuditSink implementations recording the
+// inbound requests and tunnels of a service into structured JSON lines (e.g. an
+// append-only file) or the system log.
+//
+//	sink, _ := irisaudit.NewFileSink("/var/log/myapp/audit.log")
+//	defer sink.Close()
+//
+//	service, _ := iris.Register(55555, "myapp", handler, nil, iris.WithAudit(sink))
+package irisaudit
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Structured form of an audit record, as serialized by the sinks.
+type Entry struct {
+	Time        time.Time `json:"time"`
+	Op          string    `json:"op"`
+	Cluster     string    `json:"cluster,omitempty"`
+	Node        string    `json:"node,omitempty"`
+	Correlation string    `json:"correlation,omitempty"`
+	Size        int       `json:"size"`
+	LatencyMs   float64   `json:"latency_ms"`
+	Result      string    `json:"result"`
+	Error       string    `json:"error,omitempty"`
+}
+
+// Converts an audit record into its structured form. Successful operations have
+// an "ok" result, failed ones the name of their error code.
+func NewEntry(record *iris.AuditRecord) *Entry {
+	entry := &Entry{
+		Time:        record.Time.UTC(),
+		Op:          string(record.Op),
+		Cluster:     record.Cluster,
+		Node:        record.Node,
+		Correlation: record.CorrelationID,
+		Size:        record.Size,
+		LatencyMs:   float64(record.Elapsed) / float64(time.Millisecond),
+		Result:      "ok",
+	}
+	if record.Err != nil {
+		entry.Result, entry.Error = record.Code.String(), record.Err.Error()
+	}
+	return entry
+}
+
+// Audit sink writing each record as a JSON line into a stream.
+type JSONSink struct {
+	out    io.Writer  // Stream to write the records into
+	closer io.Closer  // Stream to close along with the sink, nil if not owned
+	lock   sync.Mutex // Serializes the records written concurrently
+}
+
+// Creates an audit sink writing JSON lines into w. Records failing to be written
+// are dropped.
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{out: w}
+}
+
+// Creates an audit sink appending JSON lines to the file at path, creating it
+// if needed. The sink needs to be closed to release the file.
+func NewFileSink(path string) (*JSONSink, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &JSONSink{out: file, closer: file}, nil
+}
+
+// Records an audit entry as a single JSON line.
+func (s *JSONSink) Audit(record *iris.AuditRecord) {
+	blob, err := json.Marshal(NewEntry(record))
+	if err != nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	s.out.Write(append(blob, '\n'))
+}
+
+// Closes the underlying file of a file sink, a no-op for plain stream sinks.
+func (s *JSONSink) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.closer == nil {
+		return nil
+	}
+	return s.closer.Close()
+}
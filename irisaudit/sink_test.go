@@ -0,0 +1,57 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisaudit
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Tests that the file sink appends the records as JSON lines.
+func TestFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+
+	sink, err := NewFileSink(path)
+	if err != nil {
+		t.Fatalf("failed to create file sink: %v.", err)
+	}
+	sink.Audit(&iris.AuditRecord{Time: time.Now(), Op: iris.TraceRequest, Cluster: "caller", Size: 3, Elapsed: 2 * time.Millisecond})
+	sink.Audit(&iris.AuditRecord{Time: time.Now(), Op: iris.TraceTunnel, Code: iris.CodeNotFound, Err: &iris.Error{Code: iris.CodeNotFound, Message: "missing"}})
+	if err := sink.Close(); err != nil {
+		t.Fatalf("failed to close file sink: %v.", err)
+	}
+	// Read the entries back and verify their contents
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("failed to open audit log: %v.", err)
+	}
+	defer file.Close()
+
+	var entries []*Entry
+	for scanner := bufio.NewScanner(file); scanner.Scan(); {
+		entry := new(Entry)
+		if err := json.Unmarshal(scanner.Bytes(), entry); err != nil {
+			t.Fatalf("failed to parse entry %q: %v.", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entry count mismatch: have %d, want %d.", len(entries), 2)
+	}
+	if e := entries[0]; e.Op != "request" || e.Cluster != "caller" || e.Size != 3 || e.LatencyMs != 2 || e.Result != "ok" || e.Error != "" {
+		t.Fatalf("success entry mismatch: have %+v.", e)
+	}
+	if e := entries[1]; e.Op != "tunnel" || e.Result != "not found" || e.Error != "missing" {
+		t.Fatalf("failure entry mismatch: have %+v.", e)
+	}
+}
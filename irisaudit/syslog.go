@@ -0,0 +1,50 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+//go:build !windows && !plan9
+
+package irisaudit
+
+import (
+	"encoding/json"
+	"log/syslog"
+
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Audit sink sending each record as a JSON message to the system log.
+type SyslogSink struct {
+	writer *syslog.Writer
+}
+
+// Creates an audit sink connected to the local syslog daemon, logging with the
+// given facility and tag. Successful operations are logged at info, failed ones
+// at warning severity.
+func NewSyslogSink(facility syslog.Priority, tag string) (*SyslogSink, error) {
+	writer, err := syslog.New(facility|syslog.LOG_INFO, tag)
+	if err != nil {
+		return nil, err
+	}
+	return &SyslogSink{writer: writer}, nil
+}
+
+// Records an audit entry as a JSON syslog message.
+func (s *SyslogSink) Audit(record *iris.AuditRecord) {
+	blob, err := json.Marshal(NewEntry(record))
+	if err != nil {
+		return
+	}
+	if record.Err != nil {
+		s.writer.Warning(string(blob))
+	} else {
+		s.writer.Info(string(blob))
+	}
+}
+
+// Closes the connection to the syslog daemon.
+func (s *SyslogSink) Close() error {
+	return s.writer.Close()
+}
@@ -0,0 +1,58 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package iriscodec contains the iris.Codec implementations relying on third
+// party serialization libraries: protocol buffers and msgpack.
+package iriscodec
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/proto"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Codec using protocol buffers. Only proto.Message values (pointers to
+// generated message types) can be serialized.
+var Protobuf iris.Codec = protobufCodec{}
+
+// Codec using the msgpack encoding.
+var Msgpack iris.Codec = msgpackCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		return nil, fmt.Errorf("not a protocol buffer message: %T", v)
+	}
+	return proto.Marshal(msg)
+}
+
+func (protobufCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(proto.Message)
+	if !ok {
+		// Typed messages are decoded into a pointer to the message pointer
+		ptr := reflect.ValueOf(v)
+		if ptr.Kind() == reflect.Ptr && ptr.Elem().Kind() == reflect.Ptr {
+			if ptr.Elem().IsNil() {
+				ptr.Elem().Set(reflect.New(ptr.Elem().Type().Elem()))
+			}
+			msg, ok = ptr.Elem().Interface().(proto.Message)
+		}
+	}
+	if !ok {
+		return fmt.Errorf("not a protocol buffer message: %T", v)
+	}
+	return proto.Unmarshal(data, msg)
+}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error)      { return msgpack.Marshal(v) }
+func (msgpackCodec) Unmarshal(data []byte, v interface{}) error { return msgpack.Unmarshal(data, v) }
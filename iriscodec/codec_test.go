@@ -0,0 +1,46 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iriscodec
+
+import (
+	"testing"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// Tests that protocol buffer messages can be decoded both directly and via the
+// pointer-to-pointer form used by typed clients.
+func TestProtobuf(t *testing.T) {
+	blob, err := Protobuf.Marshal(wrapperspb.String("hello"))
+	if err != nil {
+		t.Fatalf("failed to marshal: %v.", err)
+	}
+	direct := new(wrapperspb.StringValue)
+	if err := Protobuf.Unmarshal(blob, direct); err != nil || direct.Value != "hello" {
+		t.Fatalf("direct decode mismatch: have %v/%v, want %v.", direct.Value, err, "hello")
+	}
+	var typed *wrapperspb.StringValue
+	if err := Protobuf.Unmarshal(blob, &typed); err != nil || typed.GetValue() != "hello" {
+		t.Fatalf("typed decode mismatch: have %v/%v, want %v.", typed.GetValue(), err, "hello")
+	}
+}
+
+// Tests that msgpack round trips plain structs.
+func TestMsgpack(t *testing.T) {
+	type message struct {
+		Name  string
+		Count int
+	}
+	blob, err := Msgpack.Marshal(message{"iris", 7})
+	if err != nil {
+		t.Fatalf("failed to marshal: %v.", err)
+	}
+	var have message
+	if err := Msgpack.Unmarshal(blob, &have); err != nil || have != (message{"iris", 7}) {
+		t.Fatalf("decode mismatch: have %v/%v, want %v.", have, err, message{"iris", 7})
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package iriscompress registers the snappy and zstd tunnel compression
+// algorithms with the Iris binding. It only needs to be imported for its side
+// effects:
+//
+//	import _ "gopkg.in/project-iris/iris-go.v1/iriscompress"
+package iriscompress
+
+import (
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+func init() {
+	iris.RegisterCompressor(iris.CompressSnappy, snappyCompressor{})
+	iris.RegisterCompressor(iris.CompressZstd, newZstdCompressor())
+}
+
+type snappyCompressor struct{}
+
+func (snappyCompressor) Compress(data []byte) ([]byte, error)   { return snappy.Encode(nil, data), nil }
+func (snappyCompressor) Decompress(data []byte) ([]byte, error) { return snappy.Decode(nil, data) }
+
+// Zstd compressor sharing a stateless encoder and decoder across all tunnels.
+type zstdCompressor struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCompressor() *zstdCompressor {
+	// Construction only fails on invalid options, none are passed
+	encoder, _ := zstd.NewWriter(nil)
+	decoder, _ := zstd.NewReader(nil)
+
+	return &zstdCompressor{
+		encoder: encoder,
+		decoder: decoder,
+	}
+}
+
+func (z *zstdCompressor) Compress(data []byte) ([]byte, error) {
+	return z.encoder.EncodeAll(data, nil), nil
+}
+
+func (z *zstdCompressor) Decompress(data []byte) ([]byte, error) {
+	return z.decoder.DecodeAll(data, nil)
+}
@@ -0,0 +1,37 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iriscompress
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Tests that the registered algorithms round trip data.
+func TestRoundTrip(t *testing.T) {
+	data := bytes.Repeat([]byte("iris compression test "), 128)
+
+	for name, comp := range map[string]interface {
+		Compress([]byte) ([]byte, error)
+		Decompress([]byte) ([]byte, error)
+	}{"snappy": snappyCompressor{}, "zstd": newZstdCompressor()} {
+		packed, err := comp.Compress(data)
+		if err != nil {
+			t.Fatalf("%s: failed to compress: %v.", name, err)
+		}
+		if len(packed) >= len(data) {
+			t.Fatalf("%s: data not compressed: have %v bytes, raw %v.", name, len(packed), len(data))
+		}
+		unpacked, err := comp.Decompress(packed)
+		if err != nil {
+			t.Fatalf("%s: failed to decompress: %v.", name, err)
+		}
+		if !bytes.Equal(unpacked, data) {
+			t.Fatalf("%s: data mismatch after round trip.", name)
+		}
+	}
+}
@@ -0,0 +1,99 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irislog contains iris.Logger adapters for third party logging
+// libraries: log15, zap and logrus. The standard library's slog is supported
+// by the core package itself via iris.NewSlogLogger.
+package irislog
+
+import (
+	"fmt"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"gopkg.in/inconshreveable/log15.v2"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Creates an iris logger forwarding all entries into a log15 logger.
+func Log15(logger log15.Logger) iris.Logger {
+	return &log15Logger{logger}
+}
+
+type log15Logger struct {
+	log15.Logger
+}
+
+func (l *log15Logger) New(ctx ...interface{}) iris.Logger {
+	return &log15Logger{l.Logger.New(ctx...)}
+}
+
+// Creates an iris logger forwarding all entries into a zap logger. Critical
+// entries are logged at error level, since zap's higher levels terminate the
+// process.
+func Zap(logger *zap.Logger) iris.Logger {
+	return &zapLogger{logger.Sugar()}
+}
+
+type zapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func (l *zapLogger) New(ctx ...interface{}) iris.Logger {
+	return &zapLogger{l.logger.With(ctx...)}
+}
+
+func (l *zapLogger) Debug(msg string, ctx ...interface{}) { l.logger.Debugw(msg, ctx...) }
+func (l *zapLogger) Info(msg string, ctx ...interface{})  { l.logger.Infow(msg, ctx...) }
+func (l *zapLogger) Warn(msg string, ctx ...interface{})  { l.logger.Warnw(msg, ctx...) }
+func (l *zapLogger) Error(msg string, ctx ...interface{}) { l.logger.Errorw(msg, ctx...) }
+func (l *zapLogger) Crit(msg string, ctx ...interface{})  { l.logger.Errorw(msg, ctx...) }
+
+// Creates an iris logger forwarding all entries into a logrus logger. Critical
+// entries are logged at error level, since logrus' higher levels terminate the
+// process.
+func Logrus(logger logrus.FieldLogger) iris.Logger {
+	return &logrusLogger{logger}
+}
+
+type logrusLogger struct {
+	logger logrus.FieldLogger
+}
+
+func (l *logrusLogger) New(ctx ...interface{}) iris.Logger {
+	return &logrusLogger{l.logger.WithFields(logrusFields(ctx))}
+}
+
+func (l *logrusLogger) Debug(msg string, ctx ...interface{}) {
+	l.logger.WithFields(logrusFields(ctx)).Debug(msg)
+}
+func (l *logrusLogger) Info(msg string, ctx ...interface{}) {
+	l.logger.WithFields(logrusFields(ctx)).Info(msg)
+}
+func (l *logrusLogger) Warn(msg string, ctx ...interface{}) {
+	l.logger.WithFields(logrusFields(ctx)).Warn(msg)
+}
+func (l *logrusLogger) Error(msg string, ctx ...interface{}) {
+	l.logger.WithFields(logrusFields(ctx)).Error(msg)
+}
+func (l *logrusLogger) Crit(msg string, ctx ...interface{}) {
+	l.logger.WithFields(logrusFields(ctx)).Error(msg)
+}
+
+// Converts alternating key/value pairs into logrus fields. A dangling key is
+// kept with a nil value.
+func logrusFields(ctx []interface{}) logrus.Fields {
+	fields := make(logrus.Fields, (len(ctx)+1)/2)
+	for i := 0; i < len(ctx); i += 2 {
+		key := fmt.Sprint(ctx[i])
+		if i+1 < len(ctx) {
+			fields[key] = ctx[i+1]
+		} else {
+			fields[key] = nil
+		}
+	}
+	return fields
+}
@@ -0,0 +1,53 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irislog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"gopkg.in/inconshreveable/log15.v2"
+)
+
+// Tests that the adapters forward the messages along with the injected and
+// per entry context.
+func TestAdapters(t *testing.T) {
+	// Log15 adapter
+	var records []*log15.Record
+	base := log15.New()
+	base.SetHandler(log15.FuncHandler(func(r *log15.Record) error { records = append(records, r); return nil }))
+
+	Log15(base).New("conn", 1).Info("message", "key", "value")
+	if len(records) != 1 || records[0].Msg != "message" || len(records[0].Ctx) != 4 {
+		t.Fatalf("log15 record mismatch: have %v.", records)
+	}
+	// Zap adapter
+	core, logs := observer.New(zapcore.DebugLevel)
+	Zap(zap.New(core)).New("conn", 1).Crit("message", "key", "value")
+
+	entries := logs.All()
+	if len(entries) != 1 || entries[0].Message != "message" || entries[0].Level != zapcore.ErrorLevel {
+		t.Fatalf("zap entry mismatch: have %v.", entries)
+	}
+	if ctx := entries[0].ContextMap(); ctx["conn"] != int64(1) || ctx["key"] != "value" {
+		t.Fatalf("zap context mismatch: have %v.", ctx)
+	}
+	// Logrus adapter
+	buf := new(bytes.Buffer)
+	logger := logrus.New()
+	logger.SetOutput(buf)
+
+	Logrus(logger).New("conn", 1).Warn("message", "key", "value")
+	if out := buf.String(); !strings.Contains(out, "msg=message") || !strings.Contains(out, "conn=1") || !strings.Contains(out, "key=value") {
+		t.Fatalf("logrus output mismatch: have %q.", out)
+	}
+}
@@ -0,0 +1,90 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irisotel implements the Iris tracing hooks on top of OpenTelemetry,
+// continuing distributed traces through the Iris messaging primitives.
+//
+//	conn, _ := iris.Connect(55555)
+//	conn.SetTracer(irisotel.NewTracer(nil, nil))
+package irisotel
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Name of the instrumentation library reported to OpenTelemetry.
+const instrumentationName = "gopkg.in/project-iris/iris-go.v1/irisotel"
+
+// OpenTelemetry backed implementation of iris.Tracer.
+type Tracer struct {
+	tracer     trace.Tracer
+	propagator propagation.TextMapPropagator
+}
+
+// Creates a new tracer, using the given provider and propagator to create the
+// spans and (de)serialize the trace context. If nil, the global ones are used.
+func NewTracer(provider trace.TracerProvider, propagator propagation.TextMapPropagator) *Tracer {
+	if provider == nil {
+		provider = otel.GetTracerProvider()
+	}
+	if propagator == nil {
+		propagator = otel.GetTextMapPropagator()
+	}
+	return &Tracer{
+		tracer:     provider.Tracer(instrumentationName),
+		propagator: propagator,
+	}
+}
+
+// Implements iris.Tracer, starting a client or producer span and injecting its
+// context into the trace headers.
+func (t *Tracer) StartOutbound(ctx context.Context, op iris.TraceOp, target string) (map[string]string, func(error)) {
+	kind := trace.SpanKindClient
+	if op == iris.TraceBroadcast || op == iris.TracePublish {
+		kind = trace.SpanKindProducer
+	}
+	ctx, span := t.tracer.Start(ctx, "iris."+string(op)+" "+target, trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("messaging.system", "iris"),
+		attribute.String("messaging.destination", target),
+		attribute.String("messaging.operation", string(op)),
+	))
+	headers := make(propagation.MapCarrier)
+	t.propagator.Inject(ctx, headers)
+
+	return headers, func(err error) { end(span, err) }
+}
+
+// Implements iris.Tracer, extracting the remote trace context from the headers
+// and starting a server or consumer span as its child.
+func (t *Tracer) StartInbound(op iris.TraceOp, target string, headers map[string]string) (context.Context, func(error)) {
+	kind := trace.SpanKindServer
+	if op == iris.TraceBroadcast || op == iris.TracePublish {
+		kind = trace.SpanKindConsumer
+	}
+	ctx := t.propagator.Extract(context.Background(), propagation.MapCarrier(headers))
+	ctx, span := t.tracer.Start(ctx, "iris."+string(op)+" "+target, trace.WithSpanKind(kind), trace.WithAttributes(
+		attribute.String("messaging.system", "iris"),
+		attribute.String("messaging.destination", target),
+		attribute.String("messaging.operation", string(op)),
+	))
+	return ctx, func(err error) { end(span, err) }
+}
+
+// Ends a span, recording the failure if any.
+func end(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
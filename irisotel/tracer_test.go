@@ -0,0 +1,154 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisotel
+
+import (
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"gopkg.in/project-iris/iris-go.v1"
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Service handler echoing back requests.
+type echoHandler struct{}
+
+func (e *echoHandler) Init(conn *iris.Connection) error         { return nil }
+func (e *echoHandler) HandleBroadcast(msg []byte)               {}
+func (e *echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (e *echoHandler) HandleTunnel(tun *iris.Tunnel)            { tun.Close() }
+func (e *echoHandler) HandleDrop(reason error)                  {}
+
+// Topic handler forwarding the events into a channel.
+type eventHandler struct {
+	events chan []byte
+}
+
+func (e *eventHandler) HandleEvent(event []byte) { e.events <- event }
+
+// Waits until the recorder collects the given number of ended spans.
+func waitSpans(t *testing.T, recorder *tracetest.SpanRecorder, count int) []sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	for start := time.Now(); len(recorder.Ended()) < count; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("ended span count mismatch: have %d, want %d.", len(recorder.Ended()), count)
+		}
+	}
+	return recorder.Ended()
+}
+
+// Finds the single ended span with the given name and kind.
+func findSpan(t *testing.T, spans []sdktrace.ReadOnlySpan, name string, kind trace.SpanKind) sdktrace.ReadOnlySpan {
+	t.Helper()
+
+	var found sdktrace.ReadOnlySpan
+	for _, span := range spans {
+		if span.Name() == name && span.SpanKind() == kind {
+			if found != nil {
+				t.Fatalf("multiple %v spans named %q.", kind, name)
+			}
+			found = span
+		}
+	}
+	if found == nil {
+		t.Fatalf("no %v span named %q.", kind, name)
+	}
+	return found
+}
+
+// Verifies that the inbound span continues the trace of the outbound one.
+func checkParent(t *testing.T, parent, child sdktrace.ReadOnlySpan) {
+	t.Helper()
+
+	if have, want := child.SpanContext().TraceID(), parent.SpanContext().TraceID(); have != want {
+		t.Errorf("%s: trace id mismatch: have %v, want %v.", child.Name(), have, want)
+	}
+	if have, want := child.Parent().SpanID(), parent.SpanContext().SpanID(); have != want {
+		t.Errorf("%s: parent span id mismatch: have %v, want %v.", child.Name(), have, want)
+	}
+	if !child.Parent().IsRemote() {
+		t.Errorf("%s: parent not extracted from the trace header.", child.Name())
+	}
+	if child.SpanContext().SpanID() == parent.SpanContext().SpanID() {
+		t.Errorf("%s: child reuses the parent span id.", child.Name())
+	}
+}
+
+// Tests that requests and publishes start and end a span on both sides, with
+// the trace context carried across the in-band trace header.
+func TestTracer(t *testing.T) {
+	// Create a tracer recording all the spans into memory
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	tracer := NewTracer(provider, propagation.TraceContext{})
+
+	// Start a fake relay with a traced echo service and client
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	serv, err := iris.Register(relay.Port(), "echo", new(echoHandler), nil, iris.WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(relay.Port(), iris.WithTracer(tracer))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue a request and verify the client and server spans
+	if _, err := conn.Request("echo", []byte("ping"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	spans := waitSpans(t, recorder, 2)
+	if len(recorder.Started()) != 2 {
+		t.Fatalf("started span count mismatch: have %d, want %d.", len(recorder.Started()), 2)
+	}
+	client := findSpan(t, spans, "iris.request echo", trace.SpanKindClient)
+	server := findSpan(t, spans, "iris.request echo", trace.SpanKindServer)
+	checkParent(t, client, server)
+
+	// Publish an event and verify the producer and consumer spans
+	handler := &eventHandler{events: make(chan []byte, 1)}
+	if err := conn.Subscribe("topic", handler, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish("topic", []byte("event")); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	select {
+	case event := <-handler.events:
+		if string(event) != "event" {
+			t.Fatalf("event mismatch: have %q, want %q.", event, "event")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered.")
+	}
+	spans = waitSpans(t, recorder, 4)
+	if len(recorder.Started()) != 4 {
+		t.Fatalf("started span count mismatch: have %d, want %d.", len(recorder.Started()), 4)
+	}
+	producer := findSpan(t, spans, "iris.publish topic", trace.SpanKindProducer)
+	consumer := findSpan(t, spans, "iris.publish topic", trace.SpanKindConsumer)
+	checkParent(t, producer, consumer)
+
+	if producer.SpanContext().TraceID() == client.SpanContext().TraceID() {
+		t.Errorf("unrelated operations share a trace.")
+	}
+}
@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package irisprom exports the operational metrics of an Iris connection as a
+// Prometheus collector.
+//
+//	conn, _ := iris.Connect(55555)
+//	prometheus.MustRegister(irisprom.NewCollector(conn, "myapp", nil))
+package irisprom
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Prometheus collector gathering the metrics of a single Iris connection.
+type Collector struct {
+	conn *iris.Connection
+
+	requestsSent    *prometheus.Desc
+	requestsFailed  *prometheus.Desc
+	requestsServed  *prometheus.Desc
+	requestsSlow    *prometheus.Desc
+	requestLatency  *prometheus.Desc
+	broadcastsSent  *prometheus.Desc
+	broadcastsRecv  *prometheus.Desc
+	publishesSent   *prometheus.Desc
+	publishesRecv   *prometheus.Desc
+	tunnelBytesIn   *prometheus.Desc
+	tunnelBytesOut  *prometheus.Desc
+	messagesDropped *prometheus.Desc
+
+	poolActive  *prometheus.Desc
+	poolThreads *prometheus.Desc
+	poolPending *prometheus.Desc
+	poolQueued  *prometheus.Desc
+	poolMemory  *prometheus.Desc
+
+	clusterSent     *prometheus.Desc
+	clusterFailed   *prometheus.Desc
+	clusterTimedOut *prometheus.Desc
+	clusterLatency  *prometheus.Desc
+}
+
+// Creates a new collector exporting the metrics of conn. All metric names are
+// prefixed with the namespace, and the constant labels attached to each.
+func NewCollector(conn *iris.Connection, namespace string, labels prometheus.Labels) *Collector {
+	desc := func(name, help string, variable ...string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "iris", name), help, variable, labels)
+	}
+	return &Collector{
+		conn: conn,
+
+		requestsSent:    desc("requests_sent_total", "Requests issued by the connection."),
+		requestsFailed:  desc("requests_failed_total", "Issued requests that failed."),
+		requestsServed:  desc("requests_served_total", "Inbound requests handled and replied to."),
+		requestsSlow:    desc("requests_slow_total", "Inbound request handlers exceeding the watchdog threshold."),
+		requestLatency:  desc("request_latency_seconds", "Latency of the issued requests."),
+		broadcastsSent:  desc("broadcasts_sent_total", "Broadcasts issued by the connection."),
+		broadcastsRecv:  desc("broadcasts_received_total", "Inbound broadcasts scheduled for handling."),
+		publishesSent:   desc("publishes_sent_total", "Events published by the connection."),
+		publishesRecv:   desc("publishes_received_total", "Inbound events scheduled for handling."),
+		tunnelBytesIn:   desc("tunnel_received_bytes_total", "Payload bytes received through tunnels."),
+		tunnelBytesOut:  desc("tunnel_sent_bytes_total", "Payload bytes sent through tunnels."),
+		messagesDropped: desc("messages_dropped_total", "Inbound messages discarded before handling."),
+
+		poolActive:  desc("pool_active_handlers", "Handlers currently executing.", "pool"),
+		poolThreads: desc("pool_max_handlers", "Maximum handlers allowed to execute concurrently.", "pool"),
+		poolPending: desc("pool_pending_messages", "Messages queued, waiting for a handler.", "pool"),
+		poolQueued:  desc("pool_queued_bytes", "Memory used by the queued messages.", "pool"),
+		poolMemory:  desc("pool_memory_bytes", "Memory allowance of the handler queue.", "pool"),
+
+		clusterSent:     desc("cluster_requests_sent_total", "Requests issued towards the cluster.", "cluster"),
+		clusterFailed:   desc("cluster_requests_failed_total", "Requests issued towards the cluster that failed.", "cluster"),
+		clusterTimedOut: desc("cluster_requests_timed_out_total", "Requests issued towards the cluster that timed out.", "cluster"),
+		clusterLatency:  desc("cluster_request_latency_seconds", "Latency of the requests issued towards the cluster.", "cluster"),
+	}
+}
+
+// Implements prometheus.Collector, sending the descriptors of all the metrics.
+func (c *Collector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		c.requestsSent, c.requestsFailed, c.requestsServed, c.requestsSlow, c.requestLatency,
+		c.broadcastsSent, c.broadcastsRecv, c.publishesSent, c.publishesRecv,
+		c.tunnelBytesIn, c.tunnelBytesOut, c.messagesDropped,
+		c.poolActive, c.poolThreads, c.poolPending, c.poolQueued, c.poolMemory,
+		c.clusterSent, c.clusterFailed, c.clusterTimedOut, c.clusterLatency,
+	} {
+		ch <- desc
+	}
+}
+
+// Implements prometheus.Collector, snapshotting the connection metrics.
+func (c *Collector) Collect(ch chan<- prometheus.Metric) {
+	stats := c.conn.Metrics()
+
+	counter := func(desc *prometheus.Desc, value uint64) {
+		ch <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, float64(value))
+	}
+	counter(c.requestsSent, stats.RequestsSent)
+	counter(c.requestsFailed, stats.RequestsFailed)
+	counter(c.requestsServed, stats.RequestsServed)
+	counter(c.requestsSlow, stats.RequestsSlow)
+	counter(c.broadcastsSent, stats.BroadcastsSent)
+	counter(c.broadcastsRecv, stats.BroadcastsRecv)
+	counter(c.publishesSent, stats.PublishesSent)
+	counter(c.publishesRecv, stats.PublishesRecv)
+	counter(c.tunnelBytesIn, stats.TunnelBytesIn)
+	counter(c.tunnelBytesOut, stats.TunnelBytesOut)
+	counter(c.messagesDropped, stats.MessagesDropped)
+
+	// Convert the latency histogram into Prometheus format
+	histogram(ch, c.requestLatency, stats.RequestLatency)
+
+	// Export the handler pool saturations
+	for name, pool := range map[string]iris.PoolUsage{
+		"broadcast": stats.BroadcastPool,
+		"request":   stats.RequestPool,
+		"event":     stats.EventPool,
+	} {
+		ch <- prometheus.MustNewConstMetric(c.poolActive, prometheus.GaugeValue, float64(pool.Active), name)
+		ch <- prometheus.MustNewConstMetric(c.poolThreads, prometheus.GaugeValue, float64(pool.Threads), name)
+		ch <- prometheus.MustNewConstMetric(c.poolPending, prometheus.GaugeValue, float64(pool.Pending), name)
+		ch <- prometheus.MustNewConstMetric(c.poolQueued, prometheus.GaugeValue, float64(pool.Queued), name)
+		ch <- prometheus.MustNewConstMetric(c.poolMemory, prometheus.GaugeValue, float64(pool.Memory), name)
+	}
+	// Export the per destination cluster request statistics
+	for cluster, cstats := range c.conn.ClusterStats() {
+		ch <- prometheus.MustNewConstMetric(c.clusterSent, prometheus.CounterValue, float64(cstats.RequestsSent), cluster)
+		ch <- prometheus.MustNewConstMetric(c.clusterFailed, prometheus.CounterValue, float64(cstats.RequestsFailed), cluster)
+		ch <- prometheus.MustNewConstMetric(c.clusterTimedOut, prometheus.CounterValue, float64(cstats.RequestsTimedOut), cluster)
+		histogram(ch, c.clusterLatency, cstats.Latency, cluster)
+	}
+}
+
+// Converts a latency histogram into Prometheus format.
+func histogram(ch chan<- prometheus.Metric, desc *prometheus.Desc, hist iris.Histogram, labels ...string) {
+	buckets := make(map[float64]uint64)
+	for i, bound := range hist.Buckets {
+		buckets[bound.Seconds()] = hist.Counts[i]
+	}
+	ch <- prometheus.MustNewConstHistogram(desc, hist.Count, hist.Sum.Seconds(), buckets, labels...)
+}
@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package irisprom
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"gopkg.in/project-iris/iris-go.v1"
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Service handler echoing back requests.
+type echoHandler struct{}
+
+func (e *echoHandler) Init(conn *iris.Connection) error         { return nil }
+func (e *echoHandler) HandleBroadcast(msg []byte)               {}
+func (e *echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (e *echoHandler) HandleTunnel(tun *iris.Tunnel)            { tun.Close() }
+func (e *echoHandler) HandleDrop(reason error)                  {}
+
+// Topic handler forwarding the events into a channel.
+type eventHandler struct {
+	events chan []byte
+}
+
+func (e *eventHandler) HandleEvent(event []byte) { e.events <- event }
+
+// Tests that a registered collector exports the traffic of its connection with
+// the expected metric families, labels and histogram buckets.
+func TestCollector(t *testing.T) {
+	// Start a fake relay with an echo service and an instrumented client
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	serv, err := iris.Register(relay.Port(), "echo", new(echoHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(NewCollector(conn, "test", prometheus.Labels{"instance": "local"}))
+
+	// Drive some request and publish traffic through the connection
+	requests := 3
+	for i := 0; i < requests; i++ {
+		if _, err := conn.Request("echo", []byte("ping"), time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	handler := &eventHandler{events: make(chan []byte, 1)}
+	if err := conn.Subscribe("topic", handler, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.Publish("topic", []byte("event")); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	select {
+	case <-handler.events:
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered.")
+	}
+	// Gather the metrics and verify the exported families
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("failed to gather metrics: %v.", err)
+	}
+	gathered := make(map[string]*dto.MetricFamily)
+	for _, family := range families {
+		gathered[family.GetName()] = family
+	}
+	for name, want := range map[string]float64{
+		"test_iris_requests_sent_total":      float64(requests),
+		"test_iris_requests_failed_total":    0,
+		"test_iris_publishes_sent_total":     1,
+		"test_iris_publishes_received_total": 1,
+	} {
+		family, ok := gathered[name]
+		if !ok {
+			t.Fatalf("metric family %s missing.", name)
+		}
+		if family.GetType() != dto.MetricType_COUNTER {
+			t.Errorf("%s: type mismatch: have %v, want %v.", name, family.GetType(), dto.MetricType_COUNTER)
+		}
+		metric := family.GetMetric()[0]
+		if have := metric.GetCounter().GetValue(); have != want {
+			t.Errorf("%s: value mismatch: have %v, want %v.", name, have, want)
+		}
+		if labels := metric.GetLabel(); len(labels) != 1 || labels[0].GetName() != "instance" || labels[0].GetValue() != "local" {
+			t.Errorf("%s: labels mismatch: have %v, want instance=local.", name, labels)
+		}
+	}
+	// Verify that the pool saturations are exported for each handler pool
+	pools := gathered["test_iris_pool_max_handlers"]
+	if pools == nil || len(pools.GetMetric()) != 3 {
+		t.Fatalf("pool metrics mismatch: have %v, want 3 pools.", pools)
+	}
+	// Verify that the latency histograms use the configured bucket bounds
+	for _, name := range []string{"test_iris_request_latency_seconds", "test_iris_cluster_request_latency_seconds"} {
+		family, ok := gathered[name]
+		if !ok {
+			t.Fatalf("metric family %s missing.", name)
+		}
+		if family.GetType() != dto.MetricType_HISTOGRAM {
+			t.Fatalf("%s: type mismatch: have %v, want %v.", name, family.GetType(), dto.MetricType_HISTOGRAM)
+		}
+		hist := family.GetMetric()[0].GetHistogram()
+		if hist.GetSampleCount() != uint64(requests) {
+			t.Errorf("%s: sample count mismatch: have %d, want %d.", name, hist.GetSampleCount(), requests)
+		}
+		buckets := hist.GetBucket()
+		if len(buckets) != len(iris.LatencyBuckets) {
+			t.Fatalf("%s: bucket count mismatch: have %d, want %d.", name, len(buckets), len(iris.LatencyBuckets))
+		}
+		for i, bucket := range buckets {
+			if want := iris.LatencyBuckets[i].Seconds(); bucket.GetUpperBound() != want {
+				t.Errorf("%s: bucket %d bound mismatch: have %v, want %v.", name, i, bucket.GetUpperBound(), want)
+			}
+		}
+		if last := buckets[len(buckets)-1].GetCumulativeCount(); last != uint64(requests) {
+			t.Errorf("%s: cumulative count mismatch: have %d, want %d.", name, last, requests)
+		}
+	}
+	// Verify that the per cluster statistics are labelled with the destination
+	cluster := gathered["test_iris_cluster_requests_sent_total"]
+	if cluster == nil || len(cluster.GetMetric()) != 1 {
+		t.Fatalf("cluster metrics mismatch: have %v, want 1 cluster.", cluster)
+	}
+	var label string
+	for _, pair := range cluster.GetMetric()[0].GetLabel() {
+		if pair.GetName() == "cluster" {
+			label = pair.GetValue()
+		}
+	}
+	if label != "echo" {
+		t.Errorf("cluster label mismatch: have %q, want %q.", label, "echo")
+	}
+	if have := cluster.GetMetric()[0].GetCounter().GetValue(); have != float64(requests) {
+		t.Errorf("cluster requests mismatch: have %v, want %v.", have, requests)
+	}
+}
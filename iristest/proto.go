@@ -0,0 +1,146 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the relay side of the wire protocol spoken with the clients.
+
+package iristest
+
+import (
+	"fmt"
+	"io"
+)
+
+// Packet opcodes
+const (
+	opInit  byte = 0x00 // In: connection initiation           | Out: connection acceptance
+	opDeny       = 0x01 // In: <never received>                | Out: connection refusal
+	opClose      = 0x02 // In: connection tear-down initiation | Out: connection tear-down notification
+
+	opBroadcast = 0x03 // In: application broadcast initiation | Out: application broadcast delivery
+	opRequest   = 0x04 // In: application request initiation   | Out: application request delivery
+	opReply     = 0x05 // In: application reply initiation     | Out: application reply delivery
+
+	opSubscribe   = 0x06 // In: topic subscription             | Out: <never sent>
+	opUnsubscribe = 0x07 // In: topic subscription removal     | Out: <never sent>
+	opPublish     = 0x08 // In: topic event publish            | Out: topic event delivery
+
+	opTunInit     = 0x09 // In: tunnel construction request    | Out: tunnel initiation
+	opTunConfirm  = 0x0a // In: tunnel confirmation            | Out: tunnel construction result
+	opTunAllow    = 0x0b // In: tunnel transfer allowance      | Out: <same as in>
+	opTunTransfer = 0x0c // In: tunnel data exchange           | Out: <same as in>
+	opTunClose    = 0x0d // In: tunnel termination request     | Out: tunnel termination notification
+)
+
+// Protocol constants
+var (
+	protoVersion = "v1.0-draft2"
+	clientMagic  = "iris-client-magic"
+	relayMagic   = "iris-relay-magic"
+)
+
+// Serializes a packet through a closure into the client connection, flushing
+// it afterwards.
+func (l *link) send(closure func() error) error {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+
+	if err := closure(); err != nil {
+		return err
+	}
+	return l.buf.Flush()
+}
+
+// Serializes a single byte into the client connection.
+func (l *link) sendByte(data byte) error {
+	return l.buf.WriteByte(data)
+}
+
+// Serializes a boolean into the client connection.
+func (l *link) sendBool(data bool) error {
+	if data {
+		return l.sendByte(1)
+	}
+	return l.sendByte(0)
+}
+
+// Serializes a variable int using base 128 encoding into the client connection.
+func (l *link) sendVarint(data uint64) error {
+	for data > 127 {
+		if err := l.sendByte(byte(128 + data%128)); err != nil {
+			return err
+		}
+		data /= 128
+	}
+	return l.sendByte(byte(data))
+}
+
+// Serializes a length-tagged binary array into the client connection.
+func (l *link) sendBinary(data []byte) error {
+	if err := l.sendVarint(uint64(len(data))); err != nil {
+		return err
+	}
+	_, err := l.buf.Write(data)
+	return err
+}
+
+// Serializes a length-tagged string into the client connection.
+func (l *link) sendString(data string) error {
+	return l.sendBinary([]byte(data))
+}
+
+// Retrieves a boolean from the client connection.
+func (l *link) recvBool() (bool, error) {
+	b, err := l.buf.ReadByte()
+	if err != nil {
+		return false, err
+	}
+	switch b {
+	case 0:
+		return false, nil
+	case 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("protocol violation: invalid boolean value: %v", b)
+	}
+}
+
+// Retrieves a variable int in base 128 encoding from the client connection.
+func (l *link) recvVarint() (uint64, error) {
+	var num uint64
+	for i := uint(0); ; i++ {
+		chunk, err := l.buf.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		num += uint64(chunk&127) << (7 * i)
+		if chunk <= 127 {
+			break
+		}
+	}
+	return num, nil
+}
+
+// Retrieves a length-tagged binary array from the client connection.
+func (l *link) recvBinary() ([]byte, error) {
+	size, err := l.recvVarint()
+	if err != nil {
+		return nil, err
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(l.buf, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Retrieves a length-tagged string from the client connection.
+func (l *link) recvString() (string, error) {
+	data, err := l.recvBinary()
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
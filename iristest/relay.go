@@ -0,0 +1,808 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package iristest contains an in-process fake Iris relay node implementing the
+// relay wire protocol, so that applications can unit test their handlers,
+// tunnels and pub/sub logic without running a real Iris node.
+//
+// The fake relay keeps all state in memory and routes messages only between the
+// clients attached to the same instance. Beside the happy path, it supports the
+// injection of common failures: dropped messages, delayed deliveries and forced
+// connection closures.
+package iristest
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+)
+
+// Chunk limit advertised to both ends of a freshly built tunnel.
+var DefaultChunkLimit = 16 * 1024
+
+// Fault injection configuration of the fake relay.
+type Faults struct {
+	DropRate float64       // Probability of dropping a routed broadcast, request, reply or event
+	Delay    time.Duration // Delay to wait before routing any inbound message
+
+	// Probability of dropping a tunnel transfer, mimicking a hung peer. Since the
+	// tunnel protocol is reliable, any partially dropped stream will be corrupt.
+	TunnelDropRate float64
+}
+
+// In-process fake relay node serving the Iris wire protocol on a local port.
+type Relay struct {
+	sock net.Listener // Listener accepting the inbound client connections
+
+	links map[*link]struct{}            // Currently attached clients
+	clust map[string][]*link            // Service members grouped by cluster
+	subs  map[string]map[*link]struct{} // Subscribers grouped by topic
+
+	reqIdx  uint64                  // Index to assign to the next routed request
+	reqPend map[uint64]*pendRequest // Requests waiting for a reply
+	tunIdx  uint64                  // Index to assign to the next tunnel build
+	tunPend map[uint64]*pendTunnel  // Tunnels waiting for a confirmation
+	tunLive map[tunnelEnd]tunnelEnd // Live tunnel endpoints mapped to their pairs
+	rrIdx   map[string]int          // Round robin counters for load balancing
+
+	faults Faults // Currently active fault injection config
+	rand   *rand.Rand
+	lock   sync.Mutex
+
+	quit chan struct{}
+	done sync.WaitGroup
+}
+
+// Relay side state of an attached client connection.
+type link struct {
+	relay   *Relay
+	cluster string
+	sock    net.Conn
+	buf     *bufio.ReadWriter
+	lock    sync.Mutex
+}
+
+// Request in flight, waiting for the reply of the servicing member.
+type pendRequest struct {
+	origin *link
+	id     uint64
+	timer  *time.Timer
+}
+
+// Tunnel under construction, waiting for the confirmation of the remote member.
+type pendTunnel struct {
+	origin *link
+	id     uint64
+	timer  *time.Timer
+}
+
+// One endpoint of a live tunnel: the attached client and its local tunnel id.
+type tunnelEnd struct {
+	link *link
+	id   uint64
+}
+
+// Starts a new fake relay listening on the given local port. A zero port will
+// pick a random available one, retrievable via Port.
+func NewRelay(port int) (*Relay, error) {
+	sock, err := net.Listen("tcp", fmt.Sprintf("localhost:%d", port))
+	if err != nil {
+		return nil, err
+	}
+	return newRelay(sock), nil
+}
+
+// Starts a new fake relay listening for TLS connections on the given local port.
+// A zero port will pick a random available one, retrievable via Port.
+func NewTLSRelay(port int, config *tls.Config) (*Relay, error) {
+	sock, err := tls.Listen("tcp", fmt.Sprintf("localhost:%d", port), config)
+	if err != nil {
+		return nil, err
+	}
+	return newRelay(sock), nil
+}
+
+// Starts a new fake relay listening on a unix domain socket at path.
+func NewUnixRelay(path string) (*Relay, error) {
+	sock, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	return newRelay(sock), nil
+}
+
+// Creates the relay state around a listener and starts accepting connections.
+func newRelay(sock net.Listener) *Relay {
+	r := &Relay{
+		sock:    sock,
+		links:   make(map[*link]struct{}),
+		clust:   make(map[string][]*link),
+		subs:    make(map[string]map[*link]struct{}),
+		reqPend: make(map[uint64]*pendRequest),
+		tunPend: make(map[uint64]*pendTunnel),
+		tunLive: make(map[tunnelEnd]tunnelEnd),
+		rrIdx:   make(map[string]int),
+		rand:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		quit:    make(chan struct{}),
+	}
+	r.done.Add(1)
+	go r.accept()
+	return r
+}
+
+// Returns the local port the relay is listening on, or zero for unix domain
+// socket relays.
+func (r *Relay) Port() int {
+	if addr, ok := r.sock.Addr().(*net.TCPAddr); ok {
+		return addr.Port
+	}
+	return 0
+}
+
+// Replaces the active fault injection configuration.
+func (r *Relay) SetFaults(faults Faults) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.faults = faults
+}
+
+// Forcefully closes all attached client connections, giving reason as the drop
+// cause. An empty reason results in the sockets being torn down without any
+// notification, simulating a relay crash.
+func (r *Relay) Disconnect(reason string) {
+	r.lock.Lock()
+	links := make([]*link, 0, len(r.links))
+	for l := range r.links {
+		links = append(links, l)
+	}
+	r.lock.Unlock()
+
+	for _, l := range links {
+		if reason != "" {
+			l.send(func() error {
+				if err := l.sendByte(opClose); err != nil {
+					return err
+				}
+				return l.sendString(reason)
+			})
+		}
+		l.sock.Close()
+	}
+}
+
+// Terminates the relay, dropping all attached clients.
+func (r *Relay) Close() error {
+	close(r.quit)
+	err := r.sock.Close()
+	r.Disconnect("")
+	r.done.Wait()
+	return err
+}
+
+// Accepts inbound client connections until the relay is closed.
+func (r *Relay) accept() {
+	defer r.done.Done()
+
+	for {
+		sock, err := r.sock.Accept()
+		if err != nil {
+			return
+		}
+		l := &link{
+			relay: r,
+			sock:  sock,
+			buf:   bufio.NewReadWriter(bufio.NewReader(sock), bufio.NewWriter(sock)),
+		}
+		r.done.Add(1)
+		go func() {
+			defer r.done.Done()
+			l.serve()
+		}()
+	}
+}
+
+// Executes the connection handshake and processes the client messages until
+// the link is torn down.
+func (l *link) serve() {
+	defer l.sock.Close()
+
+	if err := l.handshake(); err != nil {
+		return
+	}
+	r := l.relay
+
+	r.lock.Lock()
+	r.links[l] = struct{}{}
+	if l.cluster != "" {
+		r.clust[l.cluster] = append(r.clust[l.cluster], l)
+	}
+	r.lock.Unlock()
+
+	defer r.detach(l)
+
+	for {
+		op, err := l.buf.ReadByte()
+		if err != nil {
+			return
+		}
+		switch op {
+		case opClose:
+			l.send(func() error {
+				if err := l.sendByte(opClose); err != nil {
+					return err
+				}
+				return l.sendString("")
+			})
+			return
+		case opBroadcast:
+			err = l.procBroadcast()
+		case opRequest:
+			err = l.procRequest()
+		case opReply:
+			err = l.procReply()
+		case opSubscribe:
+			err = l.procSubscribe(true)
+		case opUnsubscribe:
+			err = l.procSubscribe(false)
+		case opPublish:
+			err = l.procPublish()
+		case opTunInit:
+			err = l.procTunnelInit()
+		case opTunConfirm:
+			err = l.procTunnelConfirm()
+		case opTunAllow:
+			err = l.procTunnelAllowance()
+		case opTunTransfer:
+			err = l.procTunnelTransfer()
+		case opTunClose:
+			err = l.procTunnelClose()
+		default:
+			err = fmt.Errorf("unknown opcode: %v", op)
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Verifies the client's connection initiation and accepts it.
+func (l *link) handshake() error {
+	op, err := l.buf.ReadByte()
+	if err != nil {
+		return err
+	}
+	if op != opInit {
+		return fmt.Errorf("invalid init opcode: %v", op)
+	}
+	magic, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	version, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	if l.cluster, err = l.recvString(); err != nil {
+		return err
+	}
+	if magic != clientMagic {
+		return errors.New("invalid client magic")
+	}
+	if version != protoVersion {
+		l.send(func() error {
+			if err := l.sendByte(opDeny); err != nil {
+				return err
+			}
+			if err := l.sendString(relayMagic); err != nil {
+				return err
+			}
+			return l.sendString("unsupported protocol version")
+		})
+		return errors.New("unsupported protocol version")
+	}
+	return l.send(func() error {
+		if err := l.sendByte(opInit); err != nil {
+			return err
+		}
+		if err := l.sendString(relayMagic); err != nil {
+			return err
+		}
+		return l.sendString(protoVersion)
+	})
+}
+
+// Removes all traces of a client connection from the relay.
+func (r *Relay) detach(l *link) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	delete(r.links, l)
+	if l.cluster != "" {
+		members := r.clust[l.cluster]
+		for i, member := range members {
+			if member == l {
+				r.clust[l.cluster] = append(members[:i:i], members[i+1:]...)
+				break
+			}
+		}
+	}
+	for _, subs := range r.subs {
+		delete(subs, l)
+	}
+	for id, req := range r.reqPend {
+		if req.origin == l {
+			req.timer.Stop()
+			delete(r.reqPend, id)
+		}
+	}
+	for id, tun := range r.tunPend {
+		if tun.origin == l {
+			tun.timer.Stop()
+			delete(r.tunPend, id)
+		}
+	}
+	for end, pair := range r.tunLive {
+		if end.link == l {
+			delete(r.tunLive, end)
+			delete(r.tunLive, pair)
+			go pair.link.sendTunnelClose(pair.id, "remote relay link dropped")
+		}
+	}
+}
+
+// Applies the configured message delay, returning whether the message should
+// be dropped.
+func (r *Relay) inject() bool {
+	r.lock.Lock()
+	faults := r.faults
+	drop := faults.DropRate > 0 && r.rand.Float64() < faults.DropRate
+	r.lock.Unlock()
+
+	if faults.Delay > 0 {
+		time.Sleep(faults.Delay)
+	}
+	return drop
+}
+
+// Applies the configured message delay to a tunnel transfer, returning whether
+// it should be dropped.
+func (r *Relay) injectTunnel() bool {
+	r.inject()
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	return r.faults.TunnelDropRate > 0 && r.rand.Float64() < r.faults.TunnelDropRate
+}
+
+// Picks a member of a cluster in a round robin fashion, or nil if none exist.
+// The relay lock is assumed to be held.
+func (r *Relay) pick(cluster string) *link {
+	members := r.clust[cluster]
+	if len(members) == 0 {
+		return nil
+	}
+	r.rrIdx[cluster]++
+	return members[r.rrIdx[cluster]%len(members)]
+}
+
+// Routes a broadcast to all members of the target cluster.
+func (l *link) procBroadcast() error {
+	cluster, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	message, err := l.recvBinary()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+	if r.inject() {
+		return nil
+	}
+	r.lock.Lock()
+	members := append([]*link{}, r.clust[cluster]...)
+	r.lock.Unlock()
+
+	for _, member := range members {
+		member.send(func() error {
+			if err := member.sendByte(opBroadcast); err != nil {
+				return err
+			}
+			return member.sendBinary(message)
+		})
+	}
+	return nil
+}
+
+// Routes a request to a single, load balanced member of the target cluster.
+func (l *link) procRequest() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	cluster, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	request, err := l.recvBinary()
+	if err != nil {
+		return err
+	}
+	timeout, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+
+	// Delay before starting the expiration timer, stalling unroutable ones too
+	drop := r.inject()
+
+	r.lock.Lock()
+	relayId := r.reqIdx
+	r.reqIdx++
+	r.reqPend[relayId] = &pendRequest{
+		origin: l,
+		id:     id,
+		timer: time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+			r.lock.Lock()
+			_, ok := r.reqPend[relayId]
+			delete(r.reqPend, relayId)
+			r.lock.Unlock()
+
+			if ok {
+				l.sendReply(id, nil, "", true)
+			}
+		}),
+	}
+	member := r.pick(cluster)
+	r.lock.Unlock()
+
+	if member == nil || drop {
+		return nil
+	}
+	member.send(func() error {
+		if err := member.sendByte(opRequest); err != nil {
+			return err
+		}
+		if err := member.sendVarint(relayId); err != nil {
+			return err
+		}
+		if err := member.sendBinary(request); err != nil {
+			return err
+		}
+		return member.sendVarint(timeout)
+	})
+	return nil
+}
+
+// Routes a reply back to the originator of the request.
+func (l *link) procReply() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	success, err := l.recvBool()
+	if err != nil {
+		return err
+	}
+	var reply []byte
+	var fault string
+	if success {
+		if reply, err = l.recvBinary(); err != nil {
+			return err
+		}
+	} else {
+		if fault, err = l.recvString(); err != nil {
+			return err
+		}
+	}
+	r := l.relay
+	if r.inject() {
+		return nil
+	}
+	r.lock.Lock()
+	req, ok := r.reqPend[id]
+	if ok {
+		req.timer.Stop()
+		delete(r.reqPend, id)
+	}
+	r.lock.Unlock()
+
+	if ok {
+		req.origin.sendReply(req.id, reply, fault, false)
+	}
+	return nil
+}
+
+// Adds or removes a topic subscription of the client.
+func (l *link) procSubscribe(subscribe bool) error {
+	topic, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if subscribe {
+		if _, ok := r.subs[topic]; !ok {
+			r.subs[topic] = make(map[*link]struct{})
+		}
+		r.subs[topic][l] = struct{}{}
+	} else {
+		delete(r.subs[topic], l)
+	}
+	return nil
+}
+
+// Routes a topic event to all the subscribers.
+func (l *link) procPublish() error {
+	topic, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	event, err := l.recvBinary()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+	if r.inject() {
+		return nil
+	}
+	r.lock.Lock()
+	subs := make([]*link, 0, len(r.subs[topic]))
+	for sub := range r.subs[topic] {
+		subs = append(subs, sub)
+	}
+	r.lock.Unlock()
+
+	for _, sub := range subs {
+		sub.send(func() error {
+			if err := sub.sendByte(opPublish); err != nil {
+				return err
+			}
+			if err := sub.sendString(topic); err != nil {
+				return err
+			}
+			return sub.sendBinary(event)
+		})
+	}
+	return nil
+}
+
+// Starts building a tunnel to a load balanced member of the target cluster.
+func (l *link) procTunnelInit() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	cluster, err := l.recvString()
+	if err != nil {
+		return err
+	}
+	timeout, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+
+	r.lock.Lock()
+	buildId := r.tunIdx
+	r.tunIdx++
+	r.tunPend[buildId] = &pendTunnel{
+		origin: l,
+		id:     id,
+		timer: time.AfterFunc(time.Duration(timeout)*time.Millisecond, func() {
+			r.lock.Lock()
+			_, ok := r.tunPend[buildId]
+			delete(r.tunPend, buildId)
+			r.lock.Unlock()
+
+			if ok {
+				l.sendTunnelResult(id, 0)
+			}
+		}),
+	}
+	member := r.pick(cluster)
+	r.lock.Unlock()
+
+	if member == nil {
+		return nil
+	}
+	member.send(func() error {
+		if err := member.sendByte(opTunInit); err != nil {
+			return err
+		}
+		if err := member.sendVarint(buildId); err != nil {
+			return err
+		}
+		return member.sendVarint(uint64(DefaultChunkLimit))
+	})
+	return nil
+}
+
+// Finalizes a tunnel construction by pairing up the two endpoints.
+func (l *link) procTunnelConfirm() error {
+	buildId, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	tunId, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+
+	r.lock.Lock()
+	pend, ok := r.tunPend[buildId]
+	if ok {
+		pend.timer.Stop()
+		delete(r.tunPend, buildId)
+
+		local, remote := tunnelEnd{l, tunId}, tunnelEnd{pend.origin, pend.id}
+		r.tunLive[local], r.tunLive[remote] = remote, local
+	}
+	r.lock.Unlock()
+
+	if ok {
+		pend.origin.sendTunnelResult(pend.id, DefaultChunkLimit)
+	} else {
+		l.sendTunnelClose(tunId, "tunnel construction timed out")
+	}
+	return nil
+}
+
+// Forwards a data allowance to the tunnel pair.
+func (l *link) procTunnelAllowance() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	space, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	if pair, ok := l.relay.pair(l, id); ok {
+		pair.link.send(func() error {
+			if err := pair.link.sendByte(opTunAllow); err != nil {
+				return err
+			}
+			if err := pair.link.sendVarint(pair.id); err != nil {
+				return err
+			}
+			return pair.link.sendVarint(space)
+		})
+	}
+	return nil
+}
+
+// Forwards a data chunk to the tunnel pair.
+func (l *link) procTunnelTransfer() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	size, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	payload, err := l.recvBinary()
+	if err != nil {
+		return err
+	}
+	if l.relay.injectTunnel() {
+		return nil
+	}
+	if pair, ok := l.relay.pair(l, id); ok {
+		pair.link.send(func() error {
+			if err := pair.link.sendByte(opTunTransfer); err != nil {
+				return err
+			}
+			if err := pair.link.sendVarint(pair.id); err != nil {
+				return err
+			}
+			if err := pair.link.sendVarint(size); err != nil {
+				return err
+			}
+			return pair.link.sendBinary(payload)
+		})
+	}
+	return nil
+}
+
+// Tears down a tunnel, notifying both endpoints.
+func (l *link) procTunnelClose() error {
+	id, err := l.recvVarint()
+	if err != nil {
+		return err
+	}
+	r := l.relay
+
+	r.lock.Lock()
+	local := tunnelEnd{l, id}
+	pair, ok := r.tunLive[local]
+	if ok {
+		delete(r.tunLive, local)
+		delete(r.tunLive, pair)
+	}
+	r.lock.Unlock()
+
+	if ok {
+		pair.link.sendTunnelClose(pair.id, "")
+	}
+	l.sendTunnelClose(id, "")
+	return nil
+}
+
+// Looks up the remote pair of a local tunnel endpoint.
+func (r *Relay) pair(l *link, id uint64) (tunnelEnd, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	pair, ok := r.tunLive[tunnelEnd{l, id}]
+	return pair, ok
+}
+
+// Sends a reply delivery (or timeout) to the client.
+func (l *link) sendReply(id uint64, reply []byte, fault string, timeout bool) error {
+	return l.send(func() error {
+		if err := l.sendByte(opReply); err != nil {
+			return err
+		}
+		if err := l.sendVarint(id); err != nil {
+			return err
+		}
+		if err := l.sendBool(timeout); err != nil || timeout {
+			return err
+		}
+		success := reply != nil
+		if err := l.sendBool(success); err != nil {
+			return err
+		}
+		if success {
+			return l.sendBinary(reply)
+		}
+		return l.sendString(fault)
+	})
+}
+
+// Sends a tunnel construction result to the client. A zero chunk limit signals
+// a construction timeout.
+func (l *link) sendTunnelResult(id uint64, chunkLimit int) error {
+	return l.send(func() error {
+		if err := l.sendByte(opTunConfirm); err != nil {
+			return err
+		}
+		if err := l.sendVarint(id); err != nil {
+			return err
+		}
+		if err := l.sendBool(chunkLimit == 0); err != nil || chunkLimit == 0 {
+			return err
+		}
+		return l.sendVarint(uint64(chunkLimit))
+	})
+}
+
+// Sends a tunnel termination notification to the client.
+func (l *link) sendTunnelClose(id uint64, reason string) error {
+	return l.send(func() error {
+		if err := l.sendByte(opTunClose); err != nil {
+			return err
+		}
+		if err := l.sendVarint(id); err != nil {
+			return err
+		}
+		return l.sendString(reason)
+	})
+}
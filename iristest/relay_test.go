@@ -0,0 +1,316 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iristest_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Service handler echoing back requests and tunnel messages, and reporting
+// connection drops.
+type echoHandler struct {
+	drops chan error
+}
+
+func (e *echoHandler) Init(conn *iris.Connection) error         { return nil }
+func (e *echoHandler) HandleBroadcast(msg []byte)               {}
+func (e *echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (e *echoHandler) HandleDrop(reason error)                  { e.drops <- reason }
+
+func (e *echoHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, time.Second); err != nil {
+			return
+		}
+	}
+}
+
+// Starts a fake relay with a registered echo service and a connected client.
+func setup(t *testing.T) (*iristest.Relay, *echoHandler, *iris.Service, *iris.Connection) {
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	handler := &echoHandler{drops: make(chan error, 1)}
+	serv, err := iris.Register(relay.Port(), "echo", handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	conn, err := iris.Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	return relay, handler, serv, conn
+}
+
+// Tests that requests are routed through the fake relay.
+func TestRequest(t *testing.T) {
+	relay, _, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+	defer conn.Close()
+
+	reply, err := conn.Request("echo", []byte("ping"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, "ping")
+	}
+}
+
+// Tests that dropped messages result in request timeouts.
+func TestFaultDrop(t *testing.T) {
+	relay, _, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+	defer conn.Close()
+
+	relay.SetFaults(iristest.Faults{DropRate: 1})
+	if _, err := conn.Request("echo", []byte("ping"), 50*time.Millisecond); err != iris.ErrTimeout {
+		t.Fatalf("dropped request result mismatch: have %v, want %v.", err, iris.ErrTimeout)
+	}
+	relay.SetFaults(iristest.Faults{})
+	if _, err := conn.Request("echo", []byte("ping"), time.Second); err != nil {
+		t.Fatalf("request failed after clearing faults: %v.", err)
+	}
+}
+
+// Tests that delayed messages are still delivered.
+func TestFaultDelay(t *testing.T) {
+	relay, _, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+	defer conn.Close()
+
+	relay.SetFaults(iristest.Faults{Delay: 50 * time.Millisecond})
+	start := time.Now()
+	if _, err := conn.Request("echo", []byte("ping"), time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Fatalf("request not delayed: have %v, want >= %v.", elapsed, 100*time.Millisecond)
+	}
+}
+
+// Tests that forced closures are reported to the services.
+func TestFaultDisconnect(t *testing.T) {
+	relay, handler, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+	defer conn.Close()
+
+	relay.Disconnect("maintenance")
+	select {
+	case reason := <-handler.drops:
+		if reason == nil {
+			t.Fatalf("nil drop reason reported.")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("connection drop not reported.")
+	}
+}
+
+// Tests that heartbeats track the health of the relay link.
+func TestHeartbeat(t *testing.T) {
+	relay, _, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+
+	if _, err := conn.Ping(time.Second); err != nil {
+		t.Fatalf("ping failed: %v.", err)
+	}
+	changes := make(chan iris.HealthState, 3)
+	conn.SetHealthCallback(func(old, new iris.HealthState) { changes <- new })
+	conn.EnableHeartbeat(&iris.HeartbeatPolicy{
+		Interval: 10 * time.Millisecond,
+		Timeout:  20 * time.Millisecond,
+		Failures: 1,
+	})
+	// Stall the relay and wait for the link to degrade, then recover
+	relay.SetFaults(iristest.Faults{Delay: 50 * time.Millisecond})
+	for i, want := range []iris.HealthState{iris.HealthDegraded, iris.HealthConnected, iris.HealthClosed} {
+		switch i {
+		case 1:
+			relay.SetFaults(iristest.Faults{})
+		case 2:
+			conn.Close()
+		}
+		select {
+		case have := <-changes:
+			if have != want {
+				t.Fatalf("health change %d mismatch: have %v, want %v.", i, have, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("health change %d to %v timed out.", i, want)
+		}
+	}
+	if state := conn.Health(); state != iris.HealthClosed {
+		t.Fatalf("final health mismatch: have %v, want %v.", state, iris.HealthClosed)
+	}
+}
+
+// Tests that idle tunnels are kept alive by a live peer, and torn down if the
+// peer stops answering.
+func TestTunnelKeepalive(t *testing.T) {
+	relay, _, serv, conn := setup(t)
+	defer relay.Close()
+	defer serv.Unregister()
+	defer conn.Close()
+
+	tun, err := conn.TunnelWithConfig("echo", time.Second, &iris.TunnelConfig{
+		KeepAlive:       10 * time.Millisecond,
+		KeepAliveMisses: 2,
+	})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	// Idle for a number of keepalive periods and verify the tunnel survives
+	time.Sleep(100 * time.Millisecond)
+	if err := tun.Send([]byte("ping"), time.Second); err != nil {
+		t.Fatalf("send failed: %v.", err)
+	}
+	if msg, err := tun.Recv(time.Second); err != nil || string(msg) != "ping" {
+		t.Fatalf("echo mismatch: have %s/%v, want %s/%v.", msg, err, "ping", nil)
+	}
+	// Black hole the tunnel traffic and wait for the peer to be deemed dead
+	relay.SetFaults(iristest.Faults{TunnelDropRate: 1})
+	if msg, err := tun.Recv(time.Second); err != iris.ErrPeerDead {
+		t.Fatalf("dead peer receive mismatch: have %v/%v, want %v/%v.", msg, err, nil, iris.ErrPeerDead)
+	}
+}
+
+// Generates a self-signed certificate for localhost, usable both as server and
+// client certificate.
+func selfSigned(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate key: %v.", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		DNSNames:              []string{"localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("failed to create certificate: %v.", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("failed to parse certificate: %v.", err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+// Tests that clients and services can attach over mutually authenticated TLS.
+func TestTLS(t *testing.T) {
+	cert, pool := selfSigned(t)
+
+	relay, err := iristest.NewTLSRelay(0, &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+	})
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	config := &tls.Config{Certificates: []tls.Certificate{cert}, RootCAs: pool}
+
+	serv, err := iris.RegisterTLS(relay.Port(), "echo", &echoHandler{drops: make(chan error, 1)}, nil, config)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.ConnectTLS(relay.Port(), config)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	reply, err := conn.Request("echo", []byte("ping"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, "ping")
+	}
+	// Plain and unauthenticated connections should be rejected
+	ctx, cancel := context.WithTimeout(context.Background(), 250*time.Millisecond)
+	defer cancel()
+	if conn, err := iris.ConnectCtx(ctx, relay.Port()); err == nil {
+		conn.Close()
+		t.Fatalf("plain connection succeeded.")
+	}
+	if conn, err := iris.ConnectTLS(relay.Port(), &tls.Config{RootCAs: pool}); err == nil {
+		conn.Close()
+		t.Fatalf("unauthenticated connection succeeded.")
+	}
+}
+
+// Tests that clients and services can attach over a unix domain socket.
+func TestUnix(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "iris.sock")
+
+	relay, err := iristest.NewUnixRelay(path)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	serv, err := iris.RegisterUnix(path, "echo", &echoHandler{drops: make(chan error, 1)}, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.ConnectUnix(path)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	reply, err := conn.Request("echo", []byte("ping"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if string(reply) != "ping" {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, "ping")
+	}
+}
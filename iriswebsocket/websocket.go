@@ -0,0 +1,174 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Package iriswebsocket carries the Iris relay protocol over websockets, for
+// relays or gateways exposed behind HTTP infrastructure (ingress controllers,
+// load balancers) passing only websocket traffic.
+//
+// The client side is a relay transport, to be passed to iris.ConnectTransport
+// or iris.RegisterTransport:
+//
+//	transport := iriswebsocket.NewTransport("wss://gateway.example.com/iris", nil)
+//	conn, err := iris.ConnectTransport(transport)
+//
+// Each protocol frame travels as a binary websocket message. Relays without
+// native websocket support can be fronted by the Gateway handler, bridging the
+// websocket sessions to the relay's TCP endpoint.
+package iriswebsocket
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"gopkg.in/project-iris/iris-go.v1"
+)
+
+// Settings of the websocket sessions dialed by a transport.
+type Config struct {
+	Header    http.Header // Extra headers of the upgrade request (e.g. authorization)
+	TLSConfig *tls.Config // TLS configuration of wss:// endpoints, nil for the defaults
+}
+
+// Relay transport dialing websocket endpoints.
+type transport struct {
+	url    string            // Websocket endpoint of the relay or gateway
+	header http.Header       // Extra headers of the upgrade request
+	dialer *websocket.Dialer // Dialer of the websocket sessions
+}
+
+// Creates a relay transport reaching the relay through the websocket endpoint at
+// url (ws:// or wss://). The config may be nil.
+func NewTransport(url string, config *Config) iris.RelayTransport {
+	dialer := *websocket.DefaultDialer
+	t := &transport{url: url, dialer: &dialer}
+	if config != nil {
+		t.header = config.Header
+		t.dialer.TLSClientConfig = config.TLSConfig
+	}
+	return t
+}
+
+// Opens a new websocket session to the endpoint.
+func (t *transport) Dial(ctx context.Context) (iris.RelayLink, error) {
+	conn, res, err := t.dialer.DialContext(ctx, t.url, t.header)
+	if err != nil {
+		if res != nil {
+			return nil, fmt.Errorf("websocket upgrade failed: %s: %w", res.Status, err)
+		}
+		return nil, err
+	}
+	return &link{conn: conn}, nil
+}
+
+// Reports the endpoint for the logs.
+func (t *transport) String() string {
+	return t.url
+}
+
+// Relay link carrying the protocol frames as binary websocket messages.
+type link struct {
+	conn *websocket.Conn
+}
+
+// Retrieves the next binary message of the session as the next frame.
+func (l *link) ReadFrame() ([]byte, error) {
+	for {
+		kind, frame, err := l.conn.ReadMessage()
+		if err != nil {
+			return nil, err
+		}
+		if kind == websocket.BinaryMessage && len(frame) > 0 {
+			return frame, nil
+		}
+	}
+}
+
+// Sends the frame as a binary message.
+func (l *link) WriteFrame(frame []byte) error {
+	return l.conn.WriteMessage(websocket.BinaryMessage, frame)
+}
+
+// Tears down the websocket session.
+func (l *link) Close() error {
+	return l.conn.Close()
+}
+
+// HTTP handler bridging websocket sessions to the TCP endpoint of a local relay,
+// fronting relays without native websocket support.
+type Gateway struct {
+	relay    string             // TCP address of the relay endpoint
+	upgrader websocket.Upgrader // Upgrader of the inbound HTTP requests
+}
+
+// Creates a gateway bridging the websocket sessions to the relay listening on
+// the local TCP port. If checkOrigin is nil, cross origin sessions are refused.
+func NewGateway(port int, checkOrigin func(r *http.Request) bool) *Gateway {
+	return &Gateway{
+		relay:    fmt.Sprintf("localhost:%d", port),
+		upgrader: websocket.Upgrader{CheckOrigin: checkOrigin},
+	}
+}
+
+// Upgrades the request to a websocket session and pipes it to a fresh relay
+// connection until either side closes.
+func (g *Gateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	// Connect to the relay before accepting the session
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	sock, err := dialer.DialContext(r.Context(), "tcp", g.relay)
+	if err != nil {
+		http.Error(w, "relay unavailable", http.StatusBadGateway)
+		return
+	}
+	defer sock.Close()
+
+	conn, err := g.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return // Upgrader already replied with the failure
+	}
+	defer conn.Close()
+
+	// Pipe the frames in both directions until either side fails
+	errc := make(chan error, 2)
+	go func() {
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := sock.Read(buf)
+			if n > 0 {
+				if werr := conn.WriteMessage(websocket.BinaryMessage, buf[:n]); werr != nil {
+					errc <- werr
+					return
+				}
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	go func() {
+		for {
+			kind, reader, err := conn.NextReader()
+			if err != nil {
+				errc <- err
+				return
+			}
+			if kind != websocket.BinaryMessage {
+				continue
+			}
+			if _, err := io.Copy(sock, reader); err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	<-errc
+}
@@ -0,0 +1,100 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iriswebsocket
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1"
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Service handler echoing back requests and tunnel messages.
+type echoHandler struct{}
+
+func (e *echoHandler) Init(conn *iris.Connection) error         { return nil }
+func (e *echoHandler) HandleBroadcast(msg []byte)               {}
+func (e *echoHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (e *echoHandler) HandleDrop(reason error)                  {}
+
+func (e *echoHandler) HandleTunnel(tun *iris.Tunnel) {
+	defer tun.Close()
+	for {
+		msg, err := tun.Recv(0)
+		if err != nil {
+			return
+		}
+		if err := tun.Send(msg, time.Second); err != nil {
+			return
+		}
+	}
+}
+
+// Tests that services and clients can communicate through a websocket gateway.
+func TestGateway(t *testing.T) {
+	// Start a fake relay fronted by a websocket gateway
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	server := httptest.NewServer(NewGateway(relay.Port(), nil))
+	defer server.Close()
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	// Register a service and connect a client, both through websockets
+	serv, err := iris.RegisterTransport(NewTransport(url, nil), "echo", new(echoHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := iris.ConnectTransport(NewTransport(url, &Config{Header: http.Header{"X-Test": {"1"}}}))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that requests and tunnels work across the gateway
+	request := bytes.Repeat([]byte("websocket"), 1000)
+	if reply, err := conn.Request("echo", request, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	} else if !bytes.Equal(reply, request) {
+		t.Fatalf("reply mismatch: have %d bytes, want %d.", len(reply), len(request))
+	}
+	tun, err := conn.Tunnel("echo", time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	if err := tun.Send(request, time.Second); err != nil {
+		t.Fatalf("tunnel send failed: %v.", err)
+	}
+	if reply, err := tun.Recv(time.Second); err != nil {
+		t.Fatalf("tunnel receive failed: %v.", err)
+	} else if !bytes.Equal(reply, request) {
+		t.Fatalf("tunnel reply mismatch: have %d bytes, want %d.", len(reply), len(request))
+	}
+}
+
+// Tests that endpoints refusing the websocket upgrade fail the connection.
+func TestUpgradeFailure(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	url := "ws" + strings.TrimPrefix(server.URL, "http")
+	if _, err := iris.ConnectTransport(NewTransport(url, nil)); err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("upgrade failure mismatch: have %v.", err)
+	}
+}
@@ -0,0 +1,103 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the application level keepalive of idle tunnels.
+//
+// The relay does not notice if the remote process of a tunnel hangs or dies
+// uncleanly, so an idle tunnel would wait forever. If enabled, the tunnel probes
+// its peer with in band ping control messages whenever no inbound traffic was
+// seen for a keepalive period, and closes itself after a number of unanswered
+// probes. Any inbound chunk counts as a sign of life.
+
+package iris
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// Starts the keepalive loop of a freshly built tunnel, if enabled.
+func (t *Tunnel) startKeepalive() {
+	if t.limits.KeepAlive > 0 {
+		go t.keepalive(t.limits.KeepAlive, t.limits.KeepAliveMisses)
+	}
+}
+
+// Probes the remote peer whenever the tunnel was idle for an interval, closing
+// the tunnel after too many unanswered probes.
+func (t *Tunnel) keepalive(interval time.Duration, misses int) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	seen, missed := atomic.LoadUint64(&t.stats.chunksIn), 0
+	for {
+		select {
+		case <-t.term:
+			return
+		case <-ticker.C:
+		}
+		// Reset the probe count on any inbound activity
+		if arrived := atomic.LoadUint64(&t.stats.chunksIn); arrived != seen {
+			seen, missed = arrived, 0
+			continue
+		}
+		// Probes cannot be sent after the local write end was closed
+		if atomic.LoadInt32(&t.atoiEOF) == 1 {
+			continue
+		}
+		if missed >= misses {
+			t.Log.Warn("tunnel peer unresponsive, closing", "interval", interval, "misses", missed)
+			atomic.StoreInt32(&t.dead, 1)
+			t.Close()
+			return
+		}
+		missed++
+		go func() {
+			if err := t.sendKeepalive(tunnelPing, time.After(interval)); err != nil {
+				t.Log.Debug("failed to send keepalive probe", "reason", err)
+			}
+		}()
+	}
+}
+
+// Answers a keepalive probe of the remote peer.
+func (t *Tunnel) answerKeepalive() {
+	if atomic.LoadInt32(&t.atoiEOF) == 1 {
+		return
+	}
+	if err := t.sendKeepalive(tunnelPong, nil); err != nil {
+		t.Log.Debug("failed to answer keepalive probe", "reason", err)
+	}
+}
+
+// Sends a keepalive control message, ahead of any in-flight message if it fits
+// into a high priority frame.
+func (t *Tunnel) sendKeepalive(kind byte, deadline <-chan time.Time) error {
+	message := wrapTunnelCtl(kind, "")
+	if len(tunnelPrioMagic)+len(message) > t.chunkLimit {
+		t.sendLock.Lock()
+		defer t.sendLock.Unlock()
+
+		return t.sendLocked(context.Background(), message, deadline)
+	}
+	t.sendGate.acquire(PriorityHigh)
+	defer t.sendGate.release()
+
+	frame := append(append([]byte{}, tunnelPrioMagic...), message...)
+	return t.sendChunk(context.Background(), frame, len(frame), deadline)
+}
+
+// Returns the error to report for operations interrupted by the tunnel closing.
+func (t *Tunnel) closedErr() error {
+	if atomic.LoadInt32(&t.dead) == 1 {
+		return ErrPeerDead
+	}
+	if atomic.LoadInt32(&t.idle) == 1 {
+		return ErrIdleClosed
+	}
+	return ErrClosed
+}
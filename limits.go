@@ -8,7 +8,11 @@
 
 package iris
 
-import "runtime"
+import (
+	"context"
+	"runtime"
+	"time"
+)
 
 // User limits of the threading and memory usage of a registered service.
 type ServiceLimits struct {
@@ -16,14 +20,92 @@ type ServiceLimits struct {
 	BroadcastMemory  int // Memory allowance for pending broadcasts
 	RequestThreads   int // Request handlers to execute concurrently
 	RequestMemory    int // Memory allowance for pending requests
+
+	RequestQueue   int  // Maximum number of pending requests (zero for unlimited)
+	RejectOverload bool // Fail requests exceeding the allowances back to the caller instead of dropping
+
+	BroadcastBatch  int           // Broadcasts delivered at once to a BatchBroadcastHandler (zero disables)
+	BroadcastWindow time.Duration // Time to wait for a batch to fill up before delivering it
+
+	// Callback extracting the ordering key of a broadcast: broadcasts sharing a key
+	// are handled one at a time in arrival order, those of different keys
+	// concurrently (nil or an empty key for no ordering). It runs on the network
+	// receiver, so it should return swiftly.
+	BroadcastOrderKey func(message []byte) string
+
+	// Callback classifying an inbound request, with the peer and correlation id in
+	// its context if advertised: high priority requests (e.g. health checks) are
+	// handled ahead of the queued normal ones and are bounded by RequestQueue
+	// separately (nil for arrival order). It runs on the network receiver, so it
+	// should return swiftly.
+	RequestPriority func(ctx context.Context, request []byte) Priority
+
+	Queue QueueFactory // Constructor of the pending broadcast and request queues (nil for the default)
+}
+
+// User limits of the memory usage and chunking of a tunnel.
+type TunnelConfig struct {
+	BufferSize  int      // Memory allowance for pending inbound messages
+	ChunkLimit  int      // Maximum size of an outbound chunk (capped by the relay's limit)
+	Compression []string // Compression algorithms to offer (outbound) or allow (inbound)
+
+	ChunkOverride bool // Use ChunkLimit even above the relay's limit (the relay must accept such chunks)
+	StreamChunks  bool // Queue multi-chunk inbound messages piecewise for RecvChunks instead of assembling
+
+	KeepAlive       time.Duration // Idle period after which the peer is probed (zero disables)
+	KeepAliveMisses int           // Unanswered probes after which the peer is deemed dead
+	IdleTimeout     time.Duration // Period without messages after which the tunnel is closed (zero disables)
+
+	AssembleLimit   int           // Maximum size of an inbound message assembled from chunks (zero for unlimited)
+	AssembleTimeout time.Duration // Stall after which a partially assembled inbound message is discarded (zero disables)
+
+	Key         []byte      // AES key encrypting the tunnel end-to-end, nil to disable
+	KeyExchange KeyExchange // Callback deriving the end-to-end key, overriding Key
+
+	SendRate RateLimit // Outbound bytes per second of the tunnel (zero for unlimited)
+
+	Queue QueueFactory // Constructor of the pending inbound message queue (nil for the default)
 }
 
 // User limits of the threading and memory usage of a subscription.
 type TopicLimits struct {
-	EventThreads int // Event handlers to execute concurrently
-	EventMemory  int // Memory allowance for pending events
+	EventThreads int            // Event handlers to execute concurrently
+	EventMemory  int            // Memory allowance for pending events
+	EventQueue   int            // Maximum number of pending events (zero for unlimited)
+	Overflow     OverflowPolicy // Handling of events exceeding the queue or memory allowance
+	Replay       bool           // Request the retained last event of the topic upon subscribing
+	RecentEvents int            // Arrived events retained for inspection via RecentEvents (zero disables)
+
+	// Callback invoked with the events rejected under the OverflowCallback policy.
+	// It may be called concurrently and should return swiftly.
+	OnOverflow func(topic string, event []byte)
+
+	BlockTimeout time.Duration // Longest an event is held back by OverflowBlock since arrival before being dropped
+	BlockBacklog int           // Maximum number of events held back by OverflowBlock (newer ones dropped)
+
+	AckWindow   time.Duration // Time an AckTopicHandler has to ack an event before it's redelivered
+	AckBuffer   int           // Maximum number of unacked events retained for redelivery
+	AckAttempts int           // Deliveries of an unacked event before giving up on it (zero for unlimited)
+
+	// Callback extracting the ordering key of an event: events sharing a key are
+	// handled one at a time in arrival order, those of different keys concurrently
+	// (nil or an empty key for no ordering). It runs on the dispatch path, so it
+	// should return swiftly. Pattern subscriptions don't retain the arrival order.
+	OrderKey func(event []byte) string
+
+	Queue QueueFactory // Constructor of the pending event queue (nil for the default)
 }
 
+// Policy of handling events arriving at a full subscription queue.
+type OverflowPolicy int
+
+const (
+	OverflowDropNewest OverflowPolicy = iota // Discard the arriving event (default)
+	OverflowDropOldest                       // Discard the oldest pending events to make room
+	OverflowBlock                            // Hold back the topic's deliveries until room frees up (bounded)
+	OverflowCallback                         // Hand the arriving event to the OnOverflow callback
+)
+
 // Default limits of the threading and memory usage of a registered service.
 var defaultServiceLimits = ServiceLimits{
 	BroadcastThreads: 4 * runtime.NumCPU(),
@@ -36,7 +118,15 @@ var defaultServiceLimits = ServiceLimits{
 var defaultTopicLimits = TopicLimits{
 	EventThreads: 4 * runtime.NumCPU(),
 	EventMemory:  64 * 1024 * 1024,
+	AckWindow:    30 * time.Second,
+	AckBuffer:    1024,
+	BlockTimeout: 5 * time.Second,
+	BlockBacklog: 1024,
 }
 
-// Size of a tunnel's input buffer.
-var defaultTunnelBuffer = 64 * 1024 * 1024
+// Default limits of the memory usage and chunking of a tunnel. The chunk limit
+// is left unset, meaning the one imposed by the relay is used.
+var defaultTunnelConfig = TunnelConfig{
+	BufferSize:      64 * 1024 * 1024,
+	KeepAliveMisses: 3,
+}
@@ -9,42 +9,98 @@
 package iris
 
 import (
+	"context"
 	"fmt"
+	"log/slog"
+	"os"
 	"time"
-
-	"gopkg.in/inconshreveable/log15.v2"
 )
 
-// User configurable leveled logger.
-var Log = log15.New()
+// Leveled, structured logger used by the binding. The context arguments are
+// alternating key/value pairs. Adapters for log15, zap and logrus are available
+// in the irislog subpackage.
+type Logger interface {
+	// Creates a child logger with the given key/value pairs injected into all
+	// of its entries.
+	New(ctx ...interface{}) Logger
+
+	Debug(msg string, ctx ...interface{})
+	Info(msg string, ctx ...interface{})
+	Warn(msg string, ctx ...interface{})
+	Error(msg string, ctx ...interface{})
+	Crit(msg string, ctx ...interface{})
+}
+
+// User configurable leveled logger, printing INFO level entries and above to
+// stderr by default. It needs to be set before creating any connections.
+var Log Logger = NewSlogLogger(slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: slog.LevelInfo})))
+
+// Severity of the critical entries, above the standard slog error level.
+const slogLevelCrit = slog.LevelError + 4
+
+// Logger adapter on top of the standard library's structured logger.
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// Creates an iris logger forwarding all entries into a standard library slog
+// logger. Critical entries are logged at slog.LevelError+4.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{logger: logger}
+}
+
+func (l *slogLogger) New(ctx ...interface{}) Logger {
+	return &slogLogger{logger: l.logger.With(ctx...)}
+}
+
+func (l *slogLogger) Debug(msg string, ctx ...interface{}) { l.logger.Debug(msg, ctx...) }
+func (l *slogLogger) Info(msg string, ctx ...interface{})  { l.logger.Info(msg, ctx...) }
+func (l *slogLogger) Warn(msg string, ctx ...interface{})  { l.logger.Warn(msg, ctx...) }
+func (l *slogLogger) Error(msg string, ctx ...interface{}) { l.logger.Error(msg, ctx...) }
+
+func (l *slogLogger) Crit(msg string, ctx ...interface{}) {
+	l.logger.Log(context.Background(), slogLevelCrit, msg, ctx...)
+}
+
+// Logger discarding all entries.
+type discardLogger struct{}
+
+// Creates an iris logger discarding all entries.
+func NewDiscardLogger() Logger {
+	return discardLogger{}
+}
+
+func (discardLogger) New(ctx ...interface{}) Logger        { return discardLogger{} }
+func (discardLogger) Debug(msg string, ctx ...interface{}) {}
+func (discardLogger) Info(msg string, ctx ...interface{})  {}
+func (discardLogger) Warn(msg string, ctx ...interface{})  {}
+func (discardLogger) Error(msg string, ctx ...interface{}) {}
+func (discardLogger) Crit(msg string, ctx ...interface{})  {}
 
-// Disables logging by default.
-func init() {
-	Log.SetHandler(log15.DiscardHandler())
+// Value evaluated only if the log entry it is part of actually gets formatted.
+type logLazy func() string
 
-	//Log.SetHandler(log15.LvlFilterHandler(log15.LvlCrit, log15.StderrHandler))
-	//Log.SetHandler(log15.LvlFilterHandler(log15.LvlError, log15.StderrHandler))
-	Log.SetHandler(log15.LvlFilterHandler(log15.LvlInfo, log15.StderrHandler))
-	//Log.SetHandler(log15.LvlFilterHandler(log15.LvlDebug, log15.StderrHandler))
+func (l logLazy) String() string {
+	return l()
 }
 
 // Creates a lazy value that flattens and truncates a data blob for logging.
-func logLazyBlob(data []byte) log15.Lazy {
-	return log15.Lazy{func() string {
+func logLazyBlob(data []byte) fmt.Stringer {
+	return logLazy(func() string {
 		if len(data) > 256 {
 			return fmt.Sprintf("%v ...", data[:256])
 		}
 		return fmt.Sprintf("%v", data)
-	}}
+	})
 }
 
 // Creates a lazy value that flattens a timeout, with the possibility of having
 // infinity as the result (timeout == 0).
-func logLazyTimeout(timeout time.Duration) log15.Lazy {
-	return log15.Lazy{func() string {
+func logLazyTimeout(timeout time.Duration) fmt.Stringer {
+	return logLazy(func() string {
 		if timeout == 0 {
 			return "infinity"
 		}
 		return fmt.Sprintf("%v", timeout)
-	}}
+	})
 }
@@ -0,0 +1,133 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the sampling logger, thinning out the repetitive entries of the hot
+// messaging paths before they reach (and get formatted by) the real logger.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Sampling policy of a logger. Entries are counted per message over intervals:
+// the first few of each message are logged, after which only every n-th is.
+// Warnings and above are never sampled, as they are rare and signal failures.
+type LogSampling struct {
+	Interval   time.Duration // Period after which the entry counts are reset
+	Initial    int           // Entries of a message logged as is within an interval
+	Thereafter int           // Sampling rate beyond the initial entries (zero drops all)
+	SampleInfo bool          // Whether to sample the info entries too, not just debug
+}
+
+// Default logger sampling policy.
+var defaultLogSampling = LogSampling{
+	Interval:   time.Second,
+	Initial:    100,
+	Thereafter: 100,
+}
+
+// Number of distinct counters messages are hashed into.
+const logSampleSlots = 4096
+
+// Entry counter of the messages hashed into the same slot.
+type logSampleSlot struct {
+	reset int64  // Unix nano time instance the interval ends at
+	count uint64 // Entries seen within the current interval
+}
+
+// Sampling state shared between a logger and all its children.
+type logSampler struct {
+	policy LogSampling
+	slots  [logSampleSlots]logSampleSlot
+}
+
+// Logger wrapper dropping the entries exceeding the sampling policy.
+type sampledLogger struct {
+	logger  Logger
+	sampler *logSampler
+}
+
+// Creates a logger forwarding into logger a sample of the debug (and optionally
+// info) entries, with zero fields of the policy replaced by the defaults. Since
+// the entries are dropped before reaching the wrapped logger, their lazy values
+// (e.g. the message dumps) are never formatted, keeping the cost of debug logs
+// bounded on busy connections and tunnels.
+func NewSampledLogger(logger Logger, policy *LogSampling) Logger {
+	return &sampledLogger{
+		logger:  logger,
+		sampler: &logSampler{policy: *finalizeLogSampling(policy)},
+	}
+}
+
+// Creates a copy of the user supplied sampling policy, with zero fields replaced
+// by the defaults.
+func finalizeLogSampling(user *LogSampling) *LogSampling {
+	if user == nil {
+		policy := defaultLogSampling
+		return &policy
+	}
+	policy := *user
+	if policy.Interval <= 0 {
+		policy.Interval = defaultLogSampling.Interval
+	}
+	if policy.Initial <= 0 {
+		policy.Initial = defaultLogSampling.Initial
+	}
+	if policy.Thereafter < 0 {
+		policy.Thereafter = 0
+	}
+	return &policy
+}
+
+func (l *sampledLogger) New(ctx ...interface{}) Logger {
+	return &sampledLogger{logger: l.logger.New(ctx...), sampler: l.sampler}
+}
+
+func (l *sampledLogger) Debug(msg string, ctx ...interface{}) {
+	if l.sampler.admit(msg) {
+		l.logger.Debug(msg, ctx...)
+	}
+}
+
+func (l *sampledLogger) Info(msg string, ctx ...interface{}) {
+	if !l.sampler.policy.SampleInfo || l.sampler.admit(msg) {
+		l.logger.Info(msg, ctx...)
+	}
+}
+
+func (l *sampledLogger) Warn(msg string, ctx ...interface{})  { l.logger.Warn(msg, ctx...) }
+func (l *sampledLogger) Error(msg string, ctx ...interface{}) { l.logger.Error(msg, ctx...) }
+func (l *sampledLogger) Crit(msg string, ctx ...interface{})  { l.logger.Crit(msg, ctx...) }
+
+// Counts an entry with the given message, returning whether it should be logged.
+func (s *logSampler) admit(msg string) bool {
+	// Hash the message into its counter slot (FNV-1a)
+	hash := uint32(2166136261)
+	for i := 0; i < len(msg); i++ {
+		hash ^= uint32(msg[i])
+		hash *= 16777619
+	}
+	slot := &s.slots[hash%logSampleSlots]
+
+	// Start a new interval if the current one elapsed
+	now := time.Now().UnixNano()
+	if reset := atomic.LoadInt64(&slot.reset); now > reset {
+		if atomic.CompareAndSwapInt64(&slot.reset, reset, now+int64(s.policy.Interval)) {
+			atomic.StoreUint64(&slot.count, 0)
+		}
+	}
+	// Admit the initial entries and every n-th afterwards
+	count := atomic.AddUint64(&slot.count, 1)
+	if count <= uint64(s.policy.Initial) {
+		return true
+	}
+	if s.policy.Thereafter == 0 {
+		return false
+	}
+	return (count-uint64(s.policy.Initial))%uint64(s.policy.Thereafter) == 0
+}
@@ -0,0 +1,89 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Logger counting the entries reaching it and formatting their values.
+type sampleTestLogger struct {
+	entries *int64
+}
+
+func (s *sampleTestLogger) New(ctx ...interface{}) Logger { return s }
+
+func (s *sampleTestLogger) Debug(msg string, ctx ...interface{}) { s.log(ctx) }
+func (s *sampleTestLogger) Info(msg string, ctx ...interface{})  { s.log(ctx) }
+func (s *sampleTestLogger) Warn(msg string, ctx ...interface{})  { s.log(ctx) }
+func (s *sampleTestLogger) Error(msg string, ctx ...interface{}) { s.log(ctx) }
+func (s *sampleTestLogger) Crit(msg string, ctx ...interface{})  { s.log(ctx) }
+
+func (s *sampleTestLogger) log(ctx []interface{}) {
+	_ = fmt.Sprint(ctx...)
+	atomic.AddInt64(s.entries, 1)
+}
+
+// Tests that the sampling logger thins out repetitive debug entries only.
+func TestSampledLogger(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		initial    int
+		thereafter int
+		entries    int
+	}{10, 5, 60}
+
+	var entries int64
+	logger := NewSampledLogger(&sampleTestLogger{&entries}, &LogSampling{
+		Interval:   time.Hour,
+		Initial:    conf.initial,
+		Thereafter: conf.thereafter,
+	})
+	// Log a batch of repetitive debug entries (via a child) and verify the sampling
+	child := logger.New("tunnel", 1)
+	for i := 0; i < conf.entries; i++ {
+		child.Debug("queuing arrived message", "data", logLazyBlob([]byte{byte(i)}))
+	}
+	want := int64(conf.initial + (conf.entries-conf.initial)/conf.thereafter)
+	if have := atomic.LoadInt64(&entries); have != want {
+		t.Fatalf("sampled debug entries mismatch: have %d, want %d.", have, want)
+	}
+	// Verify that info (by default) and warning entries are never sampled
+	atomic.StoreInt64(&entries, 0)
+	for i := 0; i < conf.entries; i++ {
+		logger.Info("repetitive info")
+		logger.Warn("repetitive warning")
+	}
+	if have := atomic.LoadInt64(&entries); have != int64(2*conf.entries) {
+		t.Fatalf("unsampled entries mismatch: have %d, want %d.", have, 2*conf.entries)
+	}
+}
+
+// Benchmarks the cost of hot path debug logging with and without sampling.
+func BenchmarkDebugLogging(b *testing.B) {
+	var entries int64
+	logger := &sampleTestLogger{&entries}
+	benchmarkDebugLogging(logger, b)
+}
+
+func BenchmarkDebugLoggingSampled(b *testing.B) {
+	var entries int64
+	logger := NewSampledLogger(&sampleTestLogger{&entries}, nil)
+	benchmarkDebugLogging(logger, b)
+}
+
+func benchmarkDebugLogging(logger Logger, b *testing.B) {
+	data := make([]byte, 1024)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		logger.Debug("queuing arrived message", "data", logLazyBlob(data))
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the cluster membership notifications, reporting the members joining
+// and leaving a cluster to the interested watchers.
+//
+// Since the relay does not expose the cluster memberships, the announcing
+// services publish periodic presence announcements on a companion topic of
+// their cluster (and a farewell upon closing), which the watchers track. Older
+// bindings never announce, so their services remain invisible to the watchers.
+
+package iris
+
+import (
+	"bytes"
+	"encoding/binary"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Magic prefix marking the membership announcements and queries.
+var memberMagic = []byte("\x00iris-member\x00")
+
+// Kinds of the membership messages.
+const (
+	memberAlive byte = iota // Presence announcement of a live member
+	memberLeave             // Farewell of a gracefully departing member
+	memberQuery             // Request for the live members to announce themselves
+)
+
+// Default interval between the presence announcements of a service.
+const defaultAnnounceInterval = 5 * time.Second
+
+// Number of missed announcements after which a member is deemed gone.
+const memberMisses = 3
+
+// Change in the membership of a watched cluster.
+type MembershipEvent struct {
+	Cluster string // Cluster whose membership changed
+	Member  string // Identifier of the joining or leaving member
+	Joined  bool   // Whether the member joined (or left otherwise)
+	Members int    // Number of live members after the change
+}
+
+// Watch tracking the live members of a cluster, notifying a handler of joins
+// and departures.
+type ClusterWatch struct {
+	conn    *Connection                 // Connection through which the cluster is watched
+	cluster string                      // Cluster being watched
+	handler func(event MembershipEvent) // Callback notified of membership changes
+
+	members map[string]*time.Timer // Live members with their expiration timers
+	closed  bool                   // Flag whether the watch was closed
+	lock    sync.Mutex             // Mutex to protect the member set
+	notify  sync.Mutex             // Mutex to serialize the handler invocations
+}
+
+// Starts announcing the presence of the service to the watchers of its cluster
+// (see Connection.WatchCluster), every interval (zero for the default of 5s).
+// Watchers deem the service gone after three missed announcements, or right away
+// when it is unregistered gracefully.
+func (s *Service) Announce(interval time.Duration) error {
+	if interval < 0 {
+		return invalidArgument("invalid announce interval %v", interval)
+	}
+	if interval == 0 {
+		interval = defaultAnnounceInterval
+	}
+	c := s.conn
+
+	c.annLock.Lock()
+	defer c.annLock.Unlock()
+
+	if c.annStop != nil {
+		return invalidArgument("membership already announced")
+	}
+	// Answer the queries of fresh watchers, then start the periodic announcements
+	answer := func(string, []byte) { c.announce(memberAlive, interval) }
+	if err := c.SubscribeFunc(memberQueryTopic(c.cluster), answer, nil); err != nil {
+		return err
+	}
+	c.annStop, c.annInterval = make(chan struct{}), interval
+	go c.announceLoop(interval, c.annStop)
+
+	s.Log.Info("announcing cluster membership", "interval", interval)
+	return nil
+}
+
+// Periodically announces the presence of the service until stopped.
+func (c *Connection) announceLoop(interval time.Duration, stop chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		c.announce(memberAlive, interval)
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		case <-c.term:
+			return
+		}
+	}
+}
+
+// Stops any presence announcements, bidding farewell to the watchers.
+func (c *Connection) stopAnnounce() {
+	c.annLock.Lock()
+	defer c.annLock.Unlock()
+
+	if c.annStop == nil {
+		return
+	}
+	close(c.annStop)
+	c.annStop = nil
+
+	c.announce(memberLeave, c.annInterval)
+}
+
+// Publishes a membership message of the service to its cluster's watchers.
+func (c *Connection) announce(kind byte, interval time.Duration) {
+	if err := c.sendPublish(memberTopic(c.cluster), encodeMember(kind, interval, c.envId)); err != nil {
+		c.Log.Debug("failed to announce membership", "reason", err)
+	}
+}
+
+// Starts watching the membership of a cluster, invoking handler whenever a member
+// joins or leaves it. The handler is invoked sequentially and should not close
+// the watch. Only the members announcing themselves (see Service.Announce) are
+// tracked.
+func (c *Connection) WatchCluster(cluster string, handler func(event MembershipEvent)) (*ClusterWatch, error) {
+	if len(cluster) == 0 {
+		return nil, invalidArgument("empty cluster identifier")
+	}
+	if handler == nil {
+		return nil, invalidArgument("nil membership handler")
+	}
+	w := &ClusterWatch{
+		conn:    c,
+		cluster: cluster,
+		handler: handler,
+		members: make(map[string]*time.Timer),
+	}
+	if err := c.SubscribeFunc(memberTopic(cluster), func(_ string, msg []byte) { w.process(msg) }, &TopicLimits{EventThreads: 1}); err != nil {
+		return nil, err
+	}
+	// Ask the live members to announce themselves instead of waiting for them
+	if err := c.sendPublish(memberQueryTopic(cluster), encodeMember(memberQuery, 0, "")); err != nil {
+		c.Unsubscribe(memberTopic(cluster))
+		return nil, err
+	}
+	return w, nil
+}
+
+// Retrieves the identifiers of the live members of the cluster, sorted.
+func (w *ClusterWatch) Members() []string {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+
+	members := make([]string, 0, len(w.members))
+	for member := range w.members {
+		members = append(members, member)
+	}
+	sort.Strings(members)
+	return members
+}
+
+// Stops watching the cluster. No more notifications are delivered afterwards.
+func (w *ClusterWatch) Close() error {
+	w.lock.Lock()
+	w.closed = true
+	for member, timer := range w.members {
+		timer.Stop()
+		delete(w.members, member)
+	}
+	w.lock.Unlock()
+
+	return w.conn.Unsubscribe(memberTopic(w.cluster))
+}
+
+// Processes a membership announcement of the watched cluster.
+func (w *ClusterWatch) process(msg []byte) {
+	kind, interval, member, ok := decodeMember(msg)
+	if !ok {
+		w.conn.Log.Warn("invalid membership announcement", "cluster", w.cluster)
+		return
+	}
+	switch kind {
+	case memberAlive:
+		if interval <= 0 {
+			w.conn.Log.Warn("invalid membership announcement interval", "cluster", w.cluster, "member", member)
+			return
+		}
+		w.update(member, memberMisses*interval, true)
+	case memberLeave:
+		w.update(member, 0, false)
+	}
+}
+
+// Marks a member alive for the given lifetime, or removes it, notifying the
+// handler if the membership changed.
+func (w *ClusterWatch) update(member string, lifetime time.Duration, alive bool) {
+	w.notify.Lock()
+	defer w.notify.Unlock()
+
+	w.lock.Lock()
+	if w.closed {
+		w.lock.Unlock()
+		return
+	}
+	timer, known := w.members[member]
+	if known {
+		timer.Stop()
+		delete(w.members, member)
+	}
+	if alive {
+		var expire *time.Timer
+		expire = time.AfterFunc(lifetime, func() { w.expire(member, &expire) })
+		w.members[member] = expire
+	}
+	count := len(w.members)
+	w.lock.Unlock()
+
+	if known != alive {
+		w.handler(MembershipEvent{Cluster: w.cluster, Member: member, Joined: alive, Members: count})
+	}
+}
+
+// Removes a member whose announcements ceased, unless it announced itself again
+// since the expiring timer was armed. The timer is passed by reference, as it
+// is only safe to access after the notification lock is acquired.
+func (w *ClusterWatch) expire(member string, timer **time.Timer) {
+	w.notify.Lock()
+	defer w.notify.Unlock()
+
+	w.lock.Lock()
+	if w.closed || w.members[member] != *timer {
+		w.lock.Unlock()
+		return
+	}
+	delete(w.members, member)
+	count := len(w.members)
+	w.lock.Unlock()
+
+	w.conn.Log.Warn("cluster member expired", "cluster", w.cluster, "member", member)
+	w.handler(MembershipEvent{Cluster: w.cluster, Member: member, Joined: false, Members: count})
+}
+
+// Returns the companion topic the members of a cluster announce themselves on.
+func memberTopic(cluster string) string {
+	return string(memberMagic) + cluster
+}
+
+// Returns the companion topic the watchers of a cluster query its members on.
+func memberQueryTopic(cluster string) string {
+	return string(memberMagic) + "query\x00" + cluster
+}
+
+// Serializes a membership message: the magic prefix, the message kind, the
+// announcement interval in milliseconds and the member identifier.
+func encodeMember(kind byte, interval time.Duration, member string) []byte {
+	var scratch [binary.MaxVarintLen64]byte
+
+	buf := new(bytes.Buffer)
+	buf.Write(memberMagic)
+	buf.WriteByte(kind)
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(interval/time.Millisecond))])
+	buf.WriteString(member)
+	return buf.Bytes()
+}
+
+// Deserializes a membership message, or returns false if it's malformed.
+func decodeMember(blob []byte) (byte, time.Duration, string, bool) {
+	if !bytes.HasPrefix(blob, memberMagic) || len(blob) < len(memberMagic)+2 {
+		return 0, 0, "", false
+	}
+	blob = blob[len(memberMagic):]
+
+	kind := blob[0]
+	interval, n := binary.Uvarint(blob[1:])
+	if n <= 0 || kind > memberQuery {
+		return 0, 0, "", false
+	}
+	return kind, time.Duration(interval) * time.Millisecond, string(blob[1+n:]), true
+}
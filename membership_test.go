@@ -0,0 +1,88 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that the members of a cluster are tracked through their announcements,
+// departing both gracefully and silently.
+func TestClusterMembership(t *testing.T) {
+	interval := 50 * time.Millisecond
+
+	// Register a service announcing itself before the watch starts
+	first := new(requestTestHandler)
+	serv1, err := Register(config.relay, config.cluster, first, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv1.Unregister()
+
+	if err := serv1.Announce(interval); err != nil {
+		t.Fatalf("announcement failed: %v.", err)
+	}
+	if err := serv1.Announce(interval); err == nil {
+		t.Fatalf("duplicate announcement succeeded.")
+	}
+	// Start watching the cluster and verify the join of the existing member
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	events := make(chan MembershipEvent, 16)
+	watch, err := conn.WatchCluster(config.cluster, func(event MembershipEvent) { events <- event })
+	if err != nil {
+		t.Fatalf("failed to watch cluster: %v.", err)
+	}
+	defer watch.Close()
+
+	expect := func(member string, joined bool, members int) {
+		select {
+		case event := <-events:
+			want := MembershipEvent{Cluster: config.cluster, Member: member, Joined: joined, Members: members}
+			if event != want {
+				t.Fatalf("membership event mismatch: have %+v, want %+v.", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("membership event timeout: want member %s joined %v.", member, joined)
+		}
+	}
+	expect(first.conn.envId, true, 1)
+
+	// Register a second member, and verify its join and graceful departure
+	second := new(requestTestHandler)
+	serv2, err := Register(config.relay, config.cluster, second, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	if err := serv2.Announce(interval); err != nil {
+		t.Fatalf("announcement failed: %v.", err)
+	}
+	expect(second.conn.envId, true, 2)
+	if members := watch.Members(); len(members) != 2 {
+		t.Fatalf("member count mismatch: have %d, want %d.", len(members), 2)
+	}
+	serv2.Unregister()
+	expect(second.conn.envId, false, 1)
+
+	// Silence the first member without a farewell, and verify its expiration
+	first.conn.annLock.Lock()
+	close(first.conn.annStop)
+	first.conn.annStop = nil
+	first.conn.annLock.Unlock()
+
+	expect(first.conn.envId, false, 0)
+	select {
+	case event := <-events:
+		t.Fatalf("unexpected membership event: %+v.", event)
+	case <-time.After(5 * interval):
+	}
+}
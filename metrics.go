@@ -0,0 +1,239 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the operational metrics gathered by a connection.
+
+package iris
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upper bounds of the request latency histogram buckets. Changes only affect the
+// histograms of connections established afterwards.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond, 5 * time.Millisecond, 10 * time.Millisecond, 25 * time.Millisecond,
+	50 * time.Millisecond, 100 * time.Millisecond, 250 * time.Millisecond, 500 * time.Millisecond,
+	time.Second, 2500 * time.Millisecond, 5 * time.Second, 10 * time.Second,
+}
+
+// Point in time snapshot of the operational metrics of a connection.
+type Metrics struct {
+	RequestsSent    uint64 // Requests issued by the connection
+	RequestsFailed  uint64 // Issued requests that failed (timeout, remote error, etc)
+	RequestsServed  uint64 // Inbound requests handled and replied to
+	RequestsSlow    uint64 // Inbound request handlers exceeding the watchdog threshold
+	RequestLatency  Histogram
+	BroadcastsSent  uint64 // Broadcasts issued by the connection
+	BroadcastsRecv  uint64 // Inbound broadcasts scheduled for handling
+	PublishesSent   uint64 // Events published by the connection
+	PublishesRecv   uint64 // Inbound events scheduled for handling
+	TunnelBytesIn   uint64 // Payload bytes received through tunnels
+	TunnelBytesOut  uint64 // Payload bytes sent through tunnels
+	MessagesDropped uint64 // Inbound messages discarded (memory exhaustion or expiration)
+
+	BroadcastPool PoolUsage // Saturation of the broadcast handler pool
+	RequestPool   PoolUsage // Saturation of the request handler pool
+	EventPool     PoolUsage // Saturation of the event handler pools (all topics summed)
+}
+
+// Cumulative histogram of a latency distribution.
+type Histogram struct {
+	Buckets []time.Duration // Upper bounds of the buckets
+	Counts  []uint64        // Cumulative sample counts for each bucket
+	Count   uint64          // Total number of samples
+	Sum     time.Duration   // Total sum of the samples
+}
+
+// Saturation of a handler pool.
+type PoolUsage struct {
+	Active  int // Handlers currently executing
+	Threads int // Maximum handlers allowed to execute concurrently
+	Pending int // Messages queued, waiting for a handler
+	Queued  int // Memory used by the queued messages
+	Memory  int // Memory allowance of the queue
+}
+
+// Point in time snapshot of the throughput and flow control statistics of a
+// tunnel. All byte counts are measured on the wire (i.e. after compression).
+type TunnelStats struct {
+	BytesSent    uint64 // Payload bytes sent to the remote endpoint
+	BytesRecv    uint64 // Payload bytes received from the remote endpoint
+	ChunksSent   uint64 // Chunks the sent messages were split into
+	ChunksRecv   uint64 // Chunks the received messages arrived in
+	MessagesSent uint64 // Application messages sent
+	MessagesRecv uint64 // Application messages received (including unconsumed ones)
+
+	Allowance   int           // Current space allowance granted by the remote endpoint
+	BlockedTime time.Duration // Total time sends spent waiting for space allowance
+	QueuedMsgs  int           // Arrived messages waiting to be consumed
+	QueuedBytes int           // Wire size of the arrived, unconsumed messages
+}
+
+// Live counters of a connection, updated atomically. The 64 bit fields are kept
+// at the front to guarantee their alignment on 32 bit platforms.
+type metrics struct {
+	reqSent   uint64
+	reqFailed uint64
+	reqServed uint64
+	reqSlow   uint64
+
+	bcastSent uint64
+	bcastRecv uint64
+	pubSent   uint64
+	pubRecv   uint64
+	tunIn     uint64
+	tunOut    uint64
+	dropped   uint64
+
+	bcastActive int32
+	reqActive   int32
+	eventActive int32
+
+	latency *latencyHistogram // Latency distribution of the issued requests
+
+	clusters    map[string]*clusterMetrics // Request counters of each destination cluster
+	clusterLock sync.RWMutex               // Protects the cluster counter map
+}
+
+// Creates a new, zeroed set of live counters.
+func newMetrics() *metrics {
+	return &metrics{
+		latency:  newLatencyHistogram(),
+		clusters: make(map[string]*clusterMetrics),
+	}
+}
+
+// Live latency histogram, updated atomically.
+type latencyHistogram struct {
+	sum     int64           // Total sum of the samples
+	buckets []time.Duration // Upper bounds of the buckets, copied from LatencyBuckets
+	counts  []uint64        // Non-cumulative counts, one more than buckets (overflow)
+}
+
+// Creates a new, empty latency histogram over the current LatencyBuckets.
+func newLatencyHistogram() *latencyHistogram {
+	return &latencyHistogram{
+		buckets: append([]time.Duration(nil), LatencyBuckets...),
+		counts:  make([]uint64, len(LatencyBuckets)+1),
+	}
+}
+
+// Records a single latency sample.
+func (h *latencyHistogram) observe(latency time.Duration) {
+	idx := 0
+	for idx < len(h.buckets) && latency > h.buckets[idx] {
+		idx++
+	}
+	atomic.AddUint64(&h.counts[idx], 1)
+	atomic.AddInt64(&h.sum, int64(latency))
+}
+
+// Accumulates the live counts into a cumulative histogram snapshot.
+func (h *latencyHistogram) snapshot() Histogram {
+	snap := Histogram{
+		Buckets: append([]time.Duration(nil), h.buckets...),
+		Counts:  make([]uint64, len(h.buckets)),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range h.counts {
+		snap.Count += atomic.LoadUint64(&h.counts[i])
+		if i < len(h.buckets) {
+			snap.Counts[i] = snap.Count
+		}
+	}
+	return snap
+}
+
+// Live counters of a tunnel, updated atomically.
+type tunnelStats struct {
+	bytesOut  uint64
+	bytesIn   uint64
+	chunksOut uint64
+	chunksIn  uint64
+	msgsOut   uint64
+	msgsIn    uint64
+	blocked   int64
+}
+
+// Retrieves a snapshot of the connection's operational metrics.
+func (c *Connection) Metrics() *Metrics {
+	m := c.stats
+	snap := &Metrics{
+		RequestsSent:    atomic.LoadUint64(&m.reqSent),
+		RequestsFailed:  atomic.LoadUint64(&m.reqFailed),
+		RequestsServed:  atomic.LoadUint64(&m.reqServed),
+		RequestsSlow:    atomic.LoadUint64(&m.reqSlow),
+		BroadcastsSent:  atomic.LoadUint64(&m.bcastSent),
+		BroadcastsRecv:  atomic.LoadUint64(&m.bcastRecv),
+		PublishesSent:   atomic.LoadUint64(&m.pubSent),
+		PublishesRecv:   atomic.LoadUint64(&m.pubRecv),
+		TunnelBytesIn:   atomic.LoadUint64(&m.tunIn),
+		TunnelBytesOut:  atomic.LoadUint64(&m.tunOut),
+		MessagesDropped: atomic.LoadUint64(&m.dropped),
+	}
+	snap.RequestLatency = m.latency.snapshot()
+
+	// Collect the handler pool saturations (only services have inbound pools)
+	if c.limits != nil {
+		snap.BroadcastPool = PoolUsage{
+			Active:  int(atomic.LoadInt32(&m.bcastActive)),
+			Threads: c.bcastPool.Size(),
+			Pending: c.bcastPool.Pending(),
+			Queued:  int(atomic.LoadInt32(&c.bcastUsed)),
+			Memory:  c.limits.BroadcastMemory,
+		}
+		snap.RequestPool = PoolUsage{
+			Active:  int(atomic.LoadInt32(&m.reqActive)),
+			Threads: c.reqPool.Size(),
+			Pending: c.reqPool.Pending(),
+			Queued:  int(atomic.LoadInt32(&c.reqUsed)),
+			Memory:  c.limits.RequestMemory,
+		}
+	}
+	snap.EventPool.Active = int(atomic.LoadInt32(&m.eventActive))
+
+	c.subLock.RLock()
+	for _, top := range c.subLive {
+		snap.EventPool.Threads += top.eventPool.Size()
+		snap.EventPool.Pending += top.pending()
+		snap.EventPool.Queued += int(atomic.LoadInt32(&top.eventUsed))
+		snap.EventPool.Memory += top.limits.EventMemory
+	}
+	c.subLock.RUnlock()
+
+	return snap
+}
+
+// Retrieves a snapshot of the tunnel's throughput and flow control statistics.
+//
+// A steadily zero allowance with growing blocked time means the sender is bound
+// by the receiver (or the relay in between), whereas a growing queue means the
+// receiving application is not keeping up.
+func (t *Tunnel) Stats() *TunnelStats {
+	s := t.stats
+	snap := &TunnelStats{
+		BytesSent:    atomic.LoadUint64(&s.bytesOut),
+		BytesRecv:    atomic.LoadUint64(&s.bytesIn),
+		ChunksSent:   atomic.LoadUint64(&s.chunksOut),
+		ChunksRecv:   atomic.LoadUint64(&s.chunksIn),
+		MessagesSent: atomic.LoadUint64(&s.msgsOut),
+		MessagesRecv: atomic.LoadUint64(&s.msgsIn),
+		BlockedTime:  time.Duration(atomic.LoadInt64(&s.blocked)),
+	}
+	t.atoiLock.Lock()
+	snap.Allowance = t.atoiSpace
+	t.atoiLock.Unlock()
+
+	t.itoaLock.Lock()
+	snap.QueuedMsgs = t.itoaBuf.Size()
+	snap.QueuedBytes = t.itoaUsed
+	t.itoaLock.Unlock()
+
+	return snap
+}
@@ -0,0 +1,205 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// Tests that the request metrics are gathered on both sides of the exchange.
+func TestMetricsRequest(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		requests int
+	}{25}
+
+	// Register a new service to the relay
+	handler := new(requestTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect to the local relay and issue a batch of requests
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < conf.requests; i++ {
+		if _, err := conn.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	// Verify the client side metrics
+	stats := conn.Metrics()
+	if stats.RequestsSent != uint64(conf.requests) {
+		t.Fatalf("sent request count mismatch: have %v, want %v.", stats.RequestsSent, conf.requests)
+	}
+	if stats.RequestsFailed != 0 {
+		t.Fatalf("failed request count mismatch: have %v, want %v.", stats.RequestsFailed, 0)
+	}
+	if stats.RequestLatency.Count != uint64(conf.requests) {
+		t.Fatalf("latency sample count mismatch: have %v, want %v.", stats.RequestLatency.Count, conf.requests)
+	}
+	if last := stats.RequestLatency.Counts[len(stats.RequestLatency.Counts)-1]; last != uint64(conf.requests) {
+		t.Fatalf("latency bucket overflow: have %v, want %v.", last, conf.requests)
+	}
+	// Verify the service side metrics
+	stats = handler.conn.Metrics()
+	if stats.RequestsServed != uint64(conf.requests) {
+		t.Fatalf("served request count mismatch: have %v, want %v.", stats.RequestsServed, conf.requests)
+	}
+	if stats.RequestPool.Threads != defaultServiceLimits.RequestThreads {
+		t.Fatalf("request pool size mismatch: have %v, want %v.", stats.RequestPool.Threads, defaultServiceLimits.RequestThreads)
+	}
+}
+
+// Tests that the request statistics are broken down by destination cluster.
+func TestMetricsCluster(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		requests int
+		timeouts int
+	}{10, 3}
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect to the local relay and issue requests to a live and a missing cluster
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	for i := 0; i < conf.requests; i++ {
+		if _, err := conn.Request(config.cluster, []byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+	}
+	missing := config.cluster + "-missing"
+	for i := 0; i < conf.timeouts; i++ {
+		if _, err := conn.Request(missing, []byte{byte(i)}, 50*time.Millisecond); err == nil {
+			t.Fatalf("request %d to missing cluster succeeded.", i)
+		}
+	}
+	// Verify the per cluster statistics
+	stats := conn.ClusterStats()
+	if len(stats) != 2 {
+		t.Fatalf("cluster count mismatch: have %v, want %v.", len(stats), 2)
+	}
+	live := stats[config.cluster]
+	if live.RequestsSent != uint64(conf.requests) || live.RequestsFailed != 0 || live.Latency.Count != uint64(conf.requests) {
+		t.Fatalf("live cluster stats mismatch: have %d/%d/%d, want %d/0/%d.", live.RequestsSent, live.RequestsFailed, live.Latency.Count, conf.requests, conf.requests)
+	}
+	if rate := live.ErrorRate(); rate != 0 {
+		t.Fatalf("live cluster error rate mismatch: have %v, want %v.", rate, 0)
+	}
+	dead := stats[missing]
+	if dead.RequestsSent != uint64(conf.timeouts) || dead.RequestsFailed != uint64(conf.timeouts) || dead.RequestsTimedOut != uint64(conf.timeouts) {
+		t.Fatalf("missing cluster stats mismatch: have %d/%d/%d, want %d/%d/%d.", dead.RequestsSent, dead.RequestsFailed, dead.RequestsTimedOut, conf.timeouts, conf.timeouts, conf.timeouts)
+	}
+	if rate := dead.ErrorRate(); rate != 1 {
+		t.Fatalf("missing cluster error rate mismatch: have %v, want %v.", rate, 1)
+	}
+	// Verify the connection totals still cover all clusters
+	if total := conn.Metrics().RequestsSent; total != uint64(conf.requests+conf.timeouts) {
+		t.Fatalf("total request count mismatch: have %v, want %v.", total, conf.requests+conf.timeouts)
+	}
+}
+
+// Tests that the debug snapshot reflects the live connection state.
+func TestDebugSnapshot(t *testing.T) {
+	// Register a new tunnel service to the relay
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect to the local relay and set up some subscriptions and tunnels
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	for _, topic := range []string{config.topic, config.topic + ".*.event"} {
+		if err := conn.SubscribeFunc(topic, func(string, []byte) {}, nil); err != nil {
+			t.Fatalf("subscription to %s failed: %v.", topic, err)
+		}
+		defer conn.Unsubscribe(topic)
+	}
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	// Verify the client side snapshot
+	snap := conn.DebugSnapshot()
+	if snap.Subscriptions != 2 {
+		t.Fatalf("subscription count mismatch: have %v, want %v.", snap.Subscriptions, 2)
+	}
+	if snap.Patterns != 1 {
+		t.Fatalf("pattern count mismatch: have %v, want %v.", snap.Patterns, 1)
+	}
+	if snap.LiveTunnels != 1 || len(snap.TunnelQueues) != 1 {
+		t.Fatalf("tunnel count mismatch: have %v/%v, want %v.", snap.LiveTunnels, len(snap.TunnelQueues), 1)
+	}
+	if snap.PendingRequests != 0 {
+		t.Fatalf("pending request count mismatch: have %v, want %v.", snap.PendingRequests, 0)
+	}
+	// Verify the introspection accessors against the snapshot
+	if subs := conn.Subscriptions(); len(subs) != 2 || subs[0] != config.topic || subs[1] != config.topic+".*.event" {
+		t.Fatalf("subscription list mismatch: have %v.", subs)
+	}
+	if live := conn.LiveTunnels(); live != snap.LiveTunnels {
+		t.Fatalf("live tunnel count mismatch: have %v, want %v.", live, snap.LiveTunnels)
+	}
+	if name := conn.ClusterName(); name != "" {
+		t.Fatalf("client cluster name mismatch: have %q, want %q.", name, "")
+	}
+	if name := serv.conn.ClusterName(); name != config.cluster {
+		t.Fatalf("service cluster name mismatch: have %q, want %q.", name, config.cluster)
+	}
+	// Verify the expvar export of the snapshot
+	dump := new(DebugSnapshot)
+	if err := json.Unmarshal([]byte(conn.Expvar().String()), dump); err != nil {
+		t.Fatalf("failed to decode expvar dump: %v.", err)
+	}
+	if dump.Subscriptions != snap.Subscriptions || dump.LiveTunnels != snap.LiveTunnels || dump.Health != snap.Health {
+		t.Fatalf("expvar dump mismatch: have %+v, want %+v.", dump, snap)
+	}
+}
+
+// Tests that changing the latency buckets doesn't affect existing histograms.
+func TestLatencyBucketsChange(t *testing.T) {
+	hist := newLatencyHistogram()
+
+	original := LatencyBuckets
+	defer func() { LatencyBuckets = original }()
+	LatencyBuckets = append(LatencyBuckets, time.Minute)
+
+	hist.observe(time.Hour)
+	snap := hist.snapshot()
+	if len(snap.Buckets) != len(original) || snap.Count != 1 {
+		t.Fatalf("snapshot mismatch: have %d buckets/%d samples, want %d/%d.", len(snap.Buckets), snap.Count, len(original), 1)
+	}
+	if fresh := newLatencyHistogram().snapshot(); len(fresh.Buckets) != len(original)+1 {
+		t.Fatalf("new histogram bucket mismatch: have %d, want %d.", len(fresh.Buckets), len(original)+1)
+	}
+}
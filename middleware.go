@@ -0,0 +1,53 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the middleware chain wrapping the request handler of a service.
+
+package iris
+
+import "context"
+
+// Request handler function, the unit wrapped by the request middleware.
+type RequestFunc func(ctx context.Context, request []byte) ([]byte, error)
+
+// Decorator wrapping a request handler with extra behavior (authentication,
+// logging, metrics, validation), in the style of HTTP middleware. It may act
+// before and after calling next, or reply on its own without calling it.
+type RequestMiddleware func(next RequestFunc) RequestFunc
+
+// Appends middleware to the chain wrapping the request handler of the service.
+// The middleware execute in the order added, the first one being the outermost.
+// They run within the inbound interceptors, just around HandleRequest (or
+// HandleRequestCtx, if implemented).
+func (s *Service) Use(middleware ...RequestMiddleware) {
+	s.conn.icptLock.Lock()
+	defer s.conn.icptLock.Unlock()
+
+	// Copy on write, in flight requests may be iterating the old chain
+	chain := make([]RequestMiddleware, 0, len(s.conn.reqMware)+len(middleware))
+	s.conn.reqMware = append(append(chain, s.conn.reqMware...), middleware...)
+}
+
+// Executes an inbound request through the middleware chain and the service
+// handler.
+func (c *Connection) serveRequest(ctx context.Context, request []byte) ([]byte, error) {
+	c.icptLock.RLock()
+	chain := c.reqMware
+	c.icptLock.RUnlock()
+
+	handler := RequestFunc(func(ctx context.Context, request []byte) ([]byte, error) {
+		defer c.watchRequest()()
+
+		if handler, ok := c.handler.(ContextRequestHandler); ok {
+			return handler.HandleRequestCtx(ctx, request)
+		}
+		return c.handler.HandleRequest(request)
+	})
+	for i := len(chain) - 1; i >= 0; i-- {
+		handler = chain[i](handler)
+	}
+	return handler(ctx, request)
+}
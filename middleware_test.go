@@ -0,0 +1,57 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// Creates a middleware tagging the requests and replies passing through it, and
+// rejecting the requests carrying the deny marker.
+func newMiddlewareTestTagger(tag string) RequestMiddleware {
+	return func(next RequestFunc) RequestFunc {
+		return func(ctx context.Context, request []byte) ([]byte, error) {
+			if bytes.Contains(request, []byte("deny:"+tag)) {
+				return nil, &Error{Code: CodePermissionDenied, Message: "denied by " + tag}
+			}
+			reply, err := next(ctx, append(request, tag...))
+			return append(reply, tag...), err
+		}
+	}
+}
+
+// Tests that the request middleware wrap the handler in order, and may short
+// circuit the requests.
+func TestRequestMiddleware(t *testing.T) {
+	// Register a new echo service to the relay with a middleware chain
+	handler := new(requestTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	serv.Use(newMiddlewareTestTagger("a"), newMiddlewareTestTagger("b"))
+	serv.Use(newMiddlewareTestTagger("c"))
+
+	// Verify the execution order of the chain
+	reply, err := handler.conn.Request(config.cluster, []byte(">"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if want := ">abccba"; string(reply) != want {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, want)
+	}
+	// Verify that a middleware can short circuit the request
+	_, err = handler.conn.Request(config.cluster, []byte("deny:b"), time.Second)
+	if remote, ok := err.(*RemoteError); !ok || remote.Code != CodePermissionDenied {
+		t.Fatalf("short circuit failure mismatch: have %v, want permission denied.", err)
+	}
+}
@@ -0,0 +1,258 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the bridge between tunnels and the standard net.Listener and net.Conn
+// interfaces, letting stream protocols (HTTP, gRPC, SSH) run over Iris unmodified.
+//
+// Tunnels transfer discrete messages, whereas net.Conns transfer a byte stream:
+// writes are sent as one or more messages, and reads consume the arriving ones,
+// keeping any remainder not fitting into the caller's buffer for the next read.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Network name reported by the addresses of the tunnel backed connections.
+const netName = "iris"
+
+// Time limit of the tunnel construction of Dial.
+const dialTimeout = 10 * time.Second
+
+// Address of a tunnel endpoint: the cluster it is a member of (empty for client
+// connections, or if the remote side didn't advertise its identity).
+type Addr struct {
+	Cluster string
+}
+
+// Returns the network name of the address.
+func (a *Addr) Network() string { return netName }
+
+// Returns the cluster of the address.
+func (a *Addr) String() string { return a.Cluster }
+
+// Stream listener handing out the inbound tunnels of a service.
+type tunnelListener struct {
+	conn   *Connection   // Service connection receiving the tunnels
+	accept chan *Tunnel  // Inbound tunnels waiting to be accepted
+	done   chan struct{} // Channel closed when the listener is closed
+	once   sync.Once     // Guard against closing the listener multiple times
+}
+
+// Returns a net.Listener accepting the inbound tunnels of a service connection
+// as net.Conns, instead of handing them to the HandleTunnel callback of the
+// service. The tunnels still pass through the inbound interceptors. After the
+// listener is closed, inbound tunnels are handed to HandleTunnel again.
+//
+// Only one listener may be active on a connection at a time.
+func Listen(conn *Connection) (net.Listener, error) {
+	if conn.cluster == "" {
+		return nil, invalidArgument("not a service connection")
+	}
+	conn.tunLock.Lock()
+	defer conn.tunLock.Unlock()
+
+	if conn.tunListen != nil {
+		return nil, invalidArgument("connection already listening")
+	}
+	listener := &tunnelListener{
+		conn:   conn,
+		accept: make(chan *Tunnel),
+		done:   make(chan struct{}),
+	}
+	conn.tunListen = listener
+	return listener, nil
+}
+
+// Waits for and returns the next inbound tunnel as a net.Conn.
+func (l *tunnelListener) Accept() (net.Conn, error) {
+	select {
+	case tun := <-l.accept:
+		return newTunnelConn(tun, l.conn.cluster, tun.Peer()), nil
+	case <-l.done:
+		return nil, &net.OpError{Op: "accept", Net: netName, Addr: l.Addr(), Err: net.ErrClosed}
+	case <-l.conn.term:
+		return nil, &net.OpError{Op: "accept", Net: netName, Addr: l.Addr(), Err: net.ErrClosed}
+	}
+}
+
+// Stops accepting inbound tunnels, handing them to the service handler again.
+// Already accepted connections are not affected.
+func (l *tunnelListener) Close() error {
+	l.once.Do(func() {
+		close(l.done)
+
+		l.conn.tunLock.Lock()
+		if l.conn.tunListen == l {
+			l.conn.tunListen = nil
+		}
+		l.conn.tunLock.Unlock()
+	})
+	return nil
+}
+
+// Returns the address of the service the listener accepts tunnels for.
+func (l *tunnelListener) Addr() net.Addr {
+	return &Addr{Cluster: l.conn.cluster}
+}
+
+// Hands an inbound tunnel over to the active listener, if any, blocking until it
+// is accepted. Returns false if there is no listener to take it.
+func (c *Connection) listenTunnel(tun *Tunnel) bool {
+	c.tunLock.RLock()
+	listener := c.tunListen
+	c.tunLock.RUnlock()
+
+	if listener == nil {
+		return false
+	}
+	select {
+	case listener.accept <- tun:
+		return true
+	case <-listener.done:
+		return false
+	case <-c.term:
+		tun.Close()
+		return true
+	}
+}
+
+// Opens a tunnel to a member of a remote cluster and returns it as a net.Conn.
+// The tunnel construction is bounded by a 10 second timeout.
+func Dial(conn *Connection, cluster string) (net.Conn, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), dialTimeout)
+	defer cancel()
+
+	return DialContext(ctx, conn, cluster)
+}
+
+// Opens a tunnel to a member of a remote cluster and returns it as a net.Conn.
+// The tunnel construction is bounded by the context deadline, or by a 10 second
+// timeout if it has none.
+func DialContext(ctx context.Context, conn *Connection, cluster string) (net.Conn, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, dialTimeout)
+		defer cancel()
+	}
+	tun, err := conn.TunnelCtx(ctx, cluster)
+	if err != nil {
+		return nil, &net.OpError{Op: "dial", Net: netName, Addr: &Addr{Cluster: cluster}, Err: err}
+	}
+	return newTunnelConn(tun, conn.cluster, &Peer{Cluster: cluster}), nil
+}
+
+// Byte stream connection backed by a tunnel.
+type tunnelConn struct {
+	tun    *Tunnel // Tunnel carrying the stream
+	local  *Addr   // Address of the local endpoint
+	remote *Addr   // Address of the remote endpoint
+
+	pending  []byte     // Remainder of a partially read message
+	readLock sync.Mutex // Serializes the reads consuming the remainder
+
+	closed int32 // Flag whether the connection was closed locally (atomic)
+}
+
+// Wraps a tunnel into a net.Conn.
+func newTunnelConn(tun *Tunnel, local string, peer *Peer) *tunnelConn {
+	conn := &tunnelConn{
+		tun:    tun,
+		local:  &Addr{Cluster: local},
+		remote: new(Addr),
+	}
+	if peer != nil {
+		conn.remote.Cluster = peer.Cluster
+	}
+	return conn
+}
+
+// Reads data from the stream, waiting for the next message if the previous one
+// was fully consumed. A closed remote side is reported as io.EOF.
+func (c *tunnelConn) Read(p []byte) (int, error) {
+	c.readLock.Lock()
+	defer c.readLock.Unlock()
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if len(c.pending) == 0 {
+		msg, err := c.tun.Recv(0)
+		if err != nil {
+			if err == io.EOF || (errors.Is(err, ErrClosed) && atomic.LoadInt32(&c.closed) == 0) {
+				return 0, io.EOF
+			}
+			return 0, c.opError("read", err)
+		}
+		c.pending = msg
+	}
+	n := copy(p, c.pending)
+	c.pending = c.pending[n:]
+	return n, nil
+}
+
+// Writes data to the stream, sending it in messages of at most a tunnel chunk.
+func (c *tunnelConn) Write(p []byte) (int, error) {
+	var sent int
+	for sent < len(p) {
+		end := sent + c.tun.chunkLimit
+		if end > len(p) {
+			end = len(p)
+		}
+		// Sends may still reference the message after returning, detach it
+		if err := c.tun.Send(append([]byte(nil), p[sent:end]...), 0); err != nil {
+			return sent, c.opError("write", err)
+		}
+		sent = end
+	}
+	return sent, nil
+}
+
+// Closes the connection, tearing down the tunnel.
+func (c *tunnelConn) Close() error {
+	atomic.StoreInt32(&c.closed, 1)
+	return c.tun.Close()
+}
+
+// Closes the write side of the connection, delivering an io.EOF to the remote
+// reader after all the data written before. See Tunnel.CloseWrite.
+func (c *tunnelConn) CloseWrite() error {
+	return c.tun.CloseWrite()
+}
+
+// Returns the address of the local endpoint.
+func (c *tunnelConn) LocalAddr() net.Addr { return c.local }
+
+// Returns the address of the remote endpoint.
+func (c *tunnelConn) RemoteAddr() net.Addr { return c.remote }
+
+// Sets the read and write deadlines of the underlying tunnel.
+func (c *tunnelConn) SetDeadline(t time.Time) error { return c.tun.SetDeadline(t) }
+
+// Sets the read deadline of the underlying tunnel.
+func (c *tunnelConn) SetReadDeadline(t time.Time) error { return c.tun.SetReadDeadline(t) }
+
+// Sets the write deadline of the underlying tunnel.
+func (c *tunnelConn) SetWriteDeadline(t time.Time) error { return c.tun.SetWriteDeadline(t) }
+
+// Wraps a tunnel failure into the error types expected from a net.Conn.
+func (c *tunnelConn) opError(op string, err error) error {
+	switch {
+	case errors.Is(err, ErrTimeout):
+		err = os.ErrDeadlineExceeded
+	case errors.Is(err, ErrClosed) && atomic.LoadInt32(&c.closed) == 1:
+		err = net.ErrClosed
+	}
+	return &net.OpError{Op: op, Net: netName, Source: c.local, Addr: c.remote, Err: err}
+}
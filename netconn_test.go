@@ -0,0 +1,207 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// Service handler for the net.Conn bridge tests, expecting all tunnels to be
+// taken over by a listener.
+type netConnTestHandler struct {
+	conn *Connection
+}
+
+func (n *netConnTestHandler) Init(conn *Connection) error              { n.conn = conn; return nil }
+func (n *netConnTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (n *netConnTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (n *netConnTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (n *netConnTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Tests that byte streams can be exchanged through tunnels bridged into the
+// standard net.Listener and net.Conn interfaces.
+func TestNetConn(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		size int
+	}{512 * 1024}
+
+	// Register a new service to the relay and listen for its tunnels
+	handler := new(netConnTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	listener, err := Listen(handler.conn)
+	if err != nil {
+		t.Fatalf("failed to listen: %v.", err)
+	}
+	defer listener.Close()
+
+	if _, err := Listen(handler.conn); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("duplicate listen mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	// Echo a large stream back through an accepted connection
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		io.Copy(conn, conn)
+	}()
+	conn, err := Dial(handler.conn, config.cluster)
+	if err != nil {
+		t.Fatalf("failed to dial: %v.", err)
+	}
+	defer conn.Close()
+
+	if addr := conn.RemoteAddr(); addr.Network() != "iris" || addr.String() != config.cluster {
+		t.Fatalf("remote address mismatch: have %v/%v, want %v/%v.", addr.Network(), addr, "iris", config.cluster)
+	}
+	data := make([]byte, conf.size)
+	rand.Read(data)
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(data)
+		errc <- err
+	}()
+	back := make([]byte, len(data))
+	if _, err := io.ReadFull(conn, back); err != nil {
+		t.Fatalf("failed to read back stream: %v.", err)
+	}
+	if err := <-errc; err != nil {
+		t.Fatalf("failed to write stream: %v.", err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Fatalf("stream content mismatch.")
+	}
+	// Verify that read deadlines surface as standard timeouts
+	conn.SetReadDeadline(time.Now().Add(10 * time.Millisecond))
+
+	var nerr net.Error
+	if _, err := conn.Read(back); !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("deadline mismatch: have %v, want timeout.", err)
+	}
+	conn.SetReadDeadline(time.Time{})
+
+	// Verify that a remote write close surfaces as an end-of-stream
+	if err := conn.(interface{ CloseWrite() error }).CloseWrite(); err != nil {
+		t.Fatalf("failed to close write side: %v.", err)
+	}
+	if n, err := conn.Read(back); err != io.EOF {
+		t.Fatalf("end-of-stream mismatch: have %d/%v, want %v.", n, err, io.EOF)
+	}
+}
+
+// Tests that write deadlines expiring while waiting for the remote side to free
+// up buffer space surface as standard timeouts.
+func TestNetConnWriteDeadline(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		buffer int
+	}{16 * 1024}
+
+	// Register a service with a tiny tunnel buffer, never reading its streams
+	handler := new(netConnTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil, WithTunnelBuffer(conf.buffer))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	listener, err := Listen(handler.conn)
+	if err != nil {
+		t.Fatalf("failed to listen: %v.", err)
+	}
+	defer listener.Close()
+
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		if conn, err := listener.Accept(); err == nil {
+			accepted <- conn
+		}
+	}()
+	conn, err := Dial(handler.conn, config.cluster)
+	if err != nil {
+		t.Fatalf("failed to dial: %v.", err)
+	}
+	defer conn.Close()
+
+	select {
+	case remote := <-accepted:
+		defer remote.Close()
+	case <-time.After(time.Second):
+		t.Fatalf("connection not accepted.")
+	}
+	// Overflow the remote buffer and verify the deadline surfaces as a timeout
+	conn.SetWriteDeadline(time.Now().Add(100 * time.Millisecond))
+
+	var nerr net.Error
+	if _, err := conn.Write(make([]byte, 4*conf.buffer)); !errors.As(err, &nerr) || !nerr.Timeout() || !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Fatalf("write deadline mismatch: have %v, want timeout.", err)
+	}
+}
+
+// Tests that an unmodified HTTP server and client can talk through tunnels.
+func TestNetConnHTTP(t *testing.T) {
+	// Register a new service to the relay and serve HTTP on its tunnels
+	handler := new(netConnTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	listener, err := Listen(handler.conn)
+	if err != nil {
+		t.Fatalf("failed to listen: %v.", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "hello "+r.URL.Path[1:])
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	// Issue a few requests through a client dialing tunnels
+	client := &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return DialContext(ctx, handler.conn, config.cluster)
+			},
+		},
+		Timeout: 5 * time.Second,
+	}
+	defer client.CloseIdleConnections()
+
+	for _, name := range []string{"iris", "tunnel"} {
+		res, err := client.Get("http://" + config.cluster + "/" + name)
+		if err != nil {
+			t.Fatalf("request failed: %v.", err)
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			t.Fatalf("failed to read response: %v.", err)
+		}
+		if want := "hello " + name; string(body) != want {
+			t.Fatalf("response mismatch: have %q, want %q.", body, want)
+		}
+	}
+}
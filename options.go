@@ -0,0 +1,217 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the connection setup options, bounding the relay handshake and link
+// operations in time and retrying failed initial attachments.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Returned if the relay failed to complete the connection handshake within the
+// configured timeout. It matches ErrTimeout too.
+var ErrHandshakeTimeout error = &classError{class: ErrTimeout, msg: "relay handshake timed out"}
+
+// Returned by the operations of a relay link that blocked for longer than the
+// configured I/O timeout. It matches ErrTimeout too.
+var ErrLinkTimeout error = &classError{class: ErrTimeout, msg: "relay link operation timed out"}
+
+// Options of establishing the link to the local relay.
+type ConnectOptions struct {
+	HandshakeTimeout time.Duration // Time allowance of the relay handshake (per attempt)
+	ReadTimeout      time.Duration // Maximum silence on the relay link before deeming it dead, zero for none
+	WriteTimeout     time.Duration // Time allowance of a single relay link write, zero for none
+	InitAttempts     int           // Initial connection attempts before giving up
+	InitBackoff      time.Duration // Delay between consecutive initial connection attempts
+}
+
+// Default options of establishing the link to the local relay.
+var defaultConnectOptions = ConnectOptions{
+	HandshakeTimeout: 10 * time.Second,
+	InitAttempts:     1,
+	InitBackoff:      100 * time.Millisecond,
+}
+
+// Merges the user requested options with the defaults.
+func finalizeConnectOptions(user *ConnectOptions) *ConnectOptions {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultConnectOptions
+	}
+	// Check each field and merge only non-specified ones
+	opts := new(ConnectOptions)
+	*opts = *user
+
+	if user.HandshakeTimeout <= 0 {
+		opts.HandshakeTimeout = defaultConnectOptions.HandshakeTimeout
+	}
+	if user.InitAttempts <= 0 {
+		opts.InitAttempts = defaultConnectOptions.InitAttempts
+	}
+	if user.InitBackoff <= 0 {
+		opts.InitBackoff = defaultConnectOptions.InitBackoff
+	}
+	return opts
+}
+
+// Connects to the Iris network as a simple client through the given transport,
+// bounding the connection setup and the relay link operations in time as set
+// by the options. Automatic reconnections honor the same I/O timeouts.
+//
+// The read timeout tears down the link if nothing arrives for that long, so it
+// should be paired with heartbeats (see EnableHeartbeat) shorter than it, which
+// keep an otherwise idle link busy. Any unset fields (i.e. value of zero) of the
+// options will default to the preset ones.
+//
+// It is a shorthand for ConnectTransport with the WithConnectOptions option.
+func ConnectWithOptions(transport RelayTransport, opts *ConnectOptions) (*Connection, error) {
+	return ConnectTransport(transport, WithConnectOptions(opts))
+}
+
+// Connects to the Iris network through the given transport and registers a new
+// service instance as a member of the specified service cluster, bounding the
+// connection setup and the relay link operations in time as set by the options.
+// It is a shorthand for RegisterTransport with the WithConnectOptions option.
+func RegisterWithOptions(transport RelayTransport, cluster string, handler ServiceHandler, limits *ServiceLimits, opts *ConnectOptions) (*Service, error) {
+	return RegisterTransport(transport, cluster, handler, limits, WithConnectOptions(opts))
+}
+
+// Dials the local relay for a new connection, retrying failed attempts and
+// bounding each handshake in time as requested by the options (if any).
+func dialInitial(ctx context.Context, relay RelayTransport, cluster string, opts *ConnectOptions, dump FrameDump, logger Logger) (*Connection, error) {
+	// Without options, dial once, waiting as long as the context permits
+	if opts == nil {
+		return dialRelay(ctx, relay, cluster, dump)
+	}
+	backoff := opts.InitBackoff
+	for attempt := 1; ; attempt++ {
+		// Dial the relay, bounding the attempt by the handshake timeout
+		attemptCtx, cancel := context.WithTimeout(ctx, opts.HandshakeTimeout)
+		link, err := dialRelay(attemptCtx, relay, cluster, dump)
+		cancel()
+
+		if err == nil {
+			return link, nil
+		}
+		if errors.Is(err, context.DeadlineExceeded) && ctx.Err() == nil {
+			err = ErrHandshakeTimeout
+		}
+		// Bail out if the failure is final or the attempts ran out
+		if ctx.Err() != nil || errors.Is(err, ErrConnectionDenied) || errors.Is(err, ErrProtocolViolation) {
+			return nil, err
+		}
+		if attempt >= opts.InitAttempts {
+			return nil, err
+		}
+		logger.Warn("connection attempt failed", "attempt", attempt, "reason", err)
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Wraps a relay transport to bound the operations of its links in time, if any
+// I/O timeouts are requested by the options.
+func wrapTransport(relay RelayTransport, opts *ConnectOptions) RelayTransport {
+	if opts == nil || (opts.ReadTimeout <= 0 && opts.WriteTimeout <= 0) {
+		return relay
+	}
+	return &timeoutTransport{inner: relay, read: opts.ReadTimeout, write: opts.WriteTimeout}
+}
+
+// Transport wrapping the links of another into time bounded ones.
+type timeoutTransport struct {
+	inner RelayTransport // Transport dialing the actual links
+	read  time.Duration  // Maximum silence before a read fails, zero for none
+	write time.Duration  // Maximum duration of a write, zero for none
+}
+
+// Dials a link through the wrapped transport, bounding its operations in time.
+func (t *timeoutTransport) Dial(ctx context.Context) (RelayLink, error) {
+	link, err := t.inner.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &timeoutLink{inner: link, read: t.read, write: t.write}, nil
+}
+
+// Reports the wrapped transport for the logs.
+func (t *timeoutTransport) String() string {
+	return fmt.Sprint(t.inner)
+}
+
+// Relay link tearing itself down if a read or write blocks for too long.
+type timeoutLink struct {
+	inner RelayLink     // Link carrying the actual frames
+	read  time.Duration // Maximum silence before a read fails, zero for none
+	write time.Duration // Maximum duration of a write, zero for none
+
+	expired int32 // Flag whether the link was torn down due to a timeout
+
+	readDog  *time.Timer // Watchdog tearing the link down on read timeouts
+	writeDog *time.Timer // Watchdog tearing the link down on write timeouts
+}
+
+// Reads the next frame of the wrapped link, tearing it down if none arrives in
+// time.
+func (l *timeoutLink) ReadFrame() ([]byte, error) {
+	if l.read <= 0 {
+		return l.inner.ReadFrame()
+	}
+	if l.readDog == nil {
+		l.readDog = time.AfterFunc(l.read, l.expire)
+	} else {
+		l.readDog.Reset(l.read)
+	}
+
+	frame, err := l.inner.ReadFrame()
+	l.readDog.Stop()
+	return frame, l.failure(err)
+}
+
+// Writes a frame into the wrapped link, tearing it down if it blocks too long.
+func (l *timeoutLink) WriteFrame(frame []byte) error {
+	if l.write <= 0 {
+		return l.inner.WriteFrame(frame)
+	}
+	if l.writeDog == nil {
+		l.writeDog = time.AfterFunc(l.write, l.expire)
+	} else {
+		l.writeDog.Reset(l.write)
+	}
+
+	err := l.inner.WriteFrame(frame)
+	l.writeDog.Stop()
+	return l.failure(err)
+}
+
+// Tears down the wrapped link.
+func (l *timeoutLink) Close() error {
+	return l.inner.Close()
+}
+
+// Tears down the wrapped link after an operation timed out.
+func (l *timeoutLink) expire() {
+	atomic.StoreInt32(&l.expired, 1)
+	l.inner.Close()
+}
+
+// Reports the failures of an expired link as timeouts.
+func (l *timeoutLink) failure(err error) error {
+	if err != nil && atomic.LoadInt32(&l.expired) == 1 {
+		return ErrLinkTimeout
+	}
+	return err
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Transport failing a number of dials before delegating to the default one.
+type optionsTestTransport struct {
+	inner RelayTransport
+	fails int32
+	dials int32
+}
+
+func (t *optionsTestTransport) Dial(ctx context.Context) (RelayLink, error) {
+	if atomic.AddInt32(&t.dials, 1) <= t.fails {
+		return nil, errors.New("relay unreachable")
+	}
+	return t.inner.Dial(ctx)
+}
+
+// Tests that hung relays are bailed out of after the handshake timeout, and that
+// failed initial attempts are retried.
+func TestConnectOptions(t *testing.T) {
+	// Start a relay accepting connections but never answering the handshake
+	listener, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v.", err)
+	}
+	defer listener.Close()
+
+	var accepted int32
+	go func() {
+		for {
+			sock, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			atomic.AddInt32(&accepted, 1)
+			defer sock.Close()
+		}
+	}()
+	hung := NewConnTransport(func(ctx context.Context) (net.Conn, error) {
+		return new(net.Dialer).DialContext(ctx, "tcp", listener.Addr().String())
+	})
+	// Verify that every attempt times out and the failure is reported as such
+	start := time.Now()
+	_, err = ConnectWithOptions(hung, &ConnectOptions{
+		HandshakeTimeout: 100 * time.Millisecond,
+		InitAttempts:     3,
+		InitBackoff:      10 * time.Millisecond,
+	})
+	if !errors.Is(err, ErrHandshakeTimeout) || !errors.Is(err, ErrTimeout) {
+		t.Fatalf("hung relay error mismatch: have %v, want %v.", err, ErrHandshakeTimeout)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("hung relay connection took too long: %v.", elapsed)
+	}
+	if n := atomic.LoadInt32(&accepted); n != 3 {
+		t.Fatalf("connection attempt mismatch: have %d, want %d.", n, 3)
+	}
+	// Verify that transient dial failures are retried
+	flaky := &optionsTestTransport{inner: NewTCPTransport(config.relay, nil), fails: 2}
+	conn, err := ConnectWithOptions(flaky, &ConnectOptions{InitAttempts: 3, InitBackoff: 10 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("retried connection failed: %v.", err)
+	}
+	conn.Close()
+
+	flaky = &optionsTestTransport{inner: NewTCPTransport(config.relay, nil), fails: 2}
+	if _, err := ConnectWithOptions(flaky, &ConnectOptions{InitAttempts: 2, InitBackoff: 10 * time.Millisecond}); err == nil {
+		t.Fatalf("connection succeeded beyond the attempt limit.")
+	}
+}
+
+// Tests that the relay link is torn down if it stays silent beyond the read
+// timeout, but kept alive by heartbeats.
+func TestConnectReadTimeout(t *testing.T) {
+	transport := NewTCPTransport(config.relay, nil)
+
+	// Connect with heartbeats shorter than the read timeout and verify liveness
+	live, err := ConnectWithOptions(transport, &ConnectOptions{ReadTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer live.Close()
+	live.EnableHeartbeat(&HeartbeatPolicy{Interval: 50 * time.Millisecond})
+
+	// Connect without heartbeats and verify that the link is dropped
+	idle, err := ConnectWithOptions(transport, &ConnectOptions{ReadTimeout: 200 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	time.Sleep(500 * time.Millisecond)
+
+	if health := live.Health(); health != HealthConnected {
+		t.Fatalf("heartbeat connection health mismatch: have %v, want %v.", health, HealthConnected)
+	}
+	if health := idle.Health(); health != HealthClosed {
+		t.Fatalf("idle connection health mismatch: have %v, want %v.", health, HealthClosed)
+	}
+}
+
+// Tests that the functional options configure the connections and services
+// before their first use.
+func TestConnectConfig(t *testing.T) {
+	var entries int64
+	logger := &sampleTestLogger{entries: &entries}
+
+	conn, err := Connect(config.relay,
+		WithLogger(logger),
+		WithTunnelBuffer(1234),
+		WithTunnelConfig(&TunnelConfig{ChunkLimit: 4096}),
+		WithTunnelBuffer(4321),
+		WithRetry(nil),
+	)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if atomic.LoadInt64(&entries) == 0 {
+		t.Fatalf("custom logger unused.")
+	}
+	if conf := conn.tunConf; conf.BufferSize != 4321 || conf.ChunkLimit != 4096 {
+		t.Fatalf("tunnel config mismatch: have %d/%d, want %d/%d.", conf.BufferSize, conf.ChunkLimit, 4321, 4096)
+	}
+	if policy := conn.retryPolicy; policy == nil || *policy != defaultRetryPolicy {
+		t.Fatalf("retry policy mismatch: have %v, want %v.", policy, defaultRetryPolicy)
+	}
+	// Services accept the same options, whereas a structured config replaces all
+	handler := &broadcastTestHandler{delivers: make(chan []byte, 1)}
+	serv, err := Register(config.relay, config.cluster, handler, nil,
+		WithRetry(nil),
+		WithConfig(&Config{Tunnel: &TunnelConfig{BufferSize: 1111}}),
+	)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if conf := handler.conn.tunConf; conf.BufferSize != 1111 {
+		t.Fatalf("service tunnel buffer mismatch: have %d, want %d.", conf.BufferSize, 1111)
+	}
+	if policy := handler.conn.retryPolicy; policy != nil {
+		t.Fatalf("replaced retry policy applied: %v.", policy)
+	}
+	// Partially filled connect options of a structured config use the defaults
+	// for the rest
+	partial, err := Connect(config.relay, WithConfig(&Config{Connect: &ConnectOptions{InitAttempts: 2}}))
+	if err != nil {
+		t.Fatalf("partial connect options connection failed: %v.", err)
+	}
+	partial.Close()
+}
@@ -0,0 +1,71 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the per-key ordered dispatch of broadcasts and events, handling the
+// messages sharing an ordering key one at a time in arrival order, while those
+// of different keys proceed concurrently within the handler thread limits.
+//
+// Instead of hashing the keys onto a fixed set of serial workers, the handler
+// working on a key also takes over the messages of the same key arriving in the
+// meantime, so a slow key never holds back the unrelated ones sharing its shard.
+
+package iris
+
+import "sync"
+
+// Dispatcher serializing the handler tasks that share an ordering key. The zero
+// value is ready for use.
+type keyedDispatcher struct {
+	backlog map[string][]func() // Tasks queued behind the running one, per busy key
+	lock    sync.Mutex          // Protects the backlog map
+}
+
+// Claims a key for running a task, or queues the task behind the running one of
+// the same key. Returns whether the caller needs to run the task via run.
+//
+// Claims need to be made in the arrival order of the messages, so callers have
+// to serialize them (e.g. by claiming within the lock guarding their queue).
+func (d *keyedDispatcher) claim(key string, task func()) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	if backlog, ok := d.backlog[key]; ok {
+		d.backlog[key] = append(backlog, task)
+		return false
+	}
+	if d.backlog == nil {
+		d.backlog = make(map[string][]func())
+	}
+	d.backlog[key] = nil
+	return true
+}
+
+// Releases a claimed key without running its task, e.g. because scheduling it
+// failed. No other task may have been queued behind it yet.
+func (d *keyedDispatcher) release(key string) {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	delete(d.backlog, key)
+}
+
+// Runs the task of a claimed key, followed by any queued behind it meanwhile,
+// then releases the key.
+func (d *keyedDispatcher) run(key string, task func()) {
+	for {
+		task()
+
+		d.lock.Lock()
+		backlog := d.backlog[key]
+		if len(backlog) == 0 {
+			delete(d.backlog, key)
+			d.lock.Unlock()
+			return
+		}
+		task, d.backlog[key] = backlog[0], backlog[1:]
+		d.lock.Unlock()
+	}
+}
@@ -0,0 +1,155 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Service and topic handler for the ordered dispatch tests, verifying that the
+// [key, sequence] messages of a key arrive in order and one at a time.
+type orderTestHandler struct {
+	conn     *Connection
+	delivers chan []byte
+
+	active  map[byte]bool // Keys currently being handled
+	next    map[byte]byte // Next sequence number expected for each key
+	running int           // Number of handlers currently running
+	overlap bool          // Flag whether different keys were handled concurrently
+	fails   []error       // Ordering violations encountered
+	lock    sync.Mutex
+}
+
+func newOrderTestHandler(messages int) *orderTestHandler {
+	return &orderTestHandler{
+		delivers: make(chan []byte, messages),
+		active:   make(map[byte]bool),
+		next:     make(map[byte]byte),
+	}
+}
+
+func (o *orderTestHandler) Init(conn *Connection) error              { o.conn = conn; return nil }
+func (o *orderTestHandler) HandleBroadcast(msg []byte)               { o.handle(msg) }
+func (o *orderTestHandler) HandleEvent(event []byte)                 { o.handle(event) }
+func (o *orderTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (o *orderTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (o *orderTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (o *orderTestHandler) handle(msg []byte) {
+	key, seq := msg[0], msg[1]
+
+	o.lock.Lock()
+	if o.active[key] {
+		o.fails = append(o.fails, fmt.Errorf("key %d: concurrent handling", key))
+	}
+	if seq != o.next[key] {
+		o.fails = append(o.fails, fmt.Errorf("key %d: sequence mismatch: have %d, want %d", key, seq, o.next[key]))
+	}
+	o.active[key], o.next[key] = true, seq+1
+	if o.running++; o.running > 1 {
+		o.overlap = true
+	}
+	o.lock.Unlock()
+
+	time.Sleep(time.Millisecond)
+
+	o.lock.Lock()
+	o.active[key] = false
+	o.running--
+	o.lock.Unlock()
+
+	o.delivers <- msg
+}
+
+// Waits for all the messages to be handled and checks the ordering guarantees.
+func (o *orderTestHandler) verify(t *testing.T, messages int) {
+	for i := 0; i < messages; i++ {
+		select {
+		case <-o.delivers:
+		case <-time.After(time.Second):
+			t.Fatalf("message #%d not delivered.", i)
+		}
+	}
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if len(o.fails) > 0 {
+		t.Fatalf("ordering violated: %v.", o.fails)
+	}
+	if !o.overlap {
+		t.Fatalf("different keys not handled concurrently.")
+	}
+}
+
+// Extracts the ordering key of a test message.
+func orderTestKey(msg []byte) string {
+	return string(msg[:1])
+}
+
+// Tests that broadcasts sharing an ordering key are handled in order, while the
+// different keys proceed concurrently.
+func TestBroadcastOrdered(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		keys     int
+		messages int
+	}{4, 25}
+
+	handler := newOrderTestHandler(conf.keys * conf.messages)
+	limits := &ServiceLimits{BroadcastThreads: conf.keys, BroadcastOrderKey: orderTestKey}
+
+	serv, err := Register(config.relay, config.cluster, handler, limits)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	for i := 0; i < conf.messages; i++ {
+		for key := 0; key < conf.keys; key++ {
+			if err := handler.conn.Broadcast(config.cluster, []byte{byte(key), byte(i)}); err != nil {
+				t.Fatalf("broadcast failed: %v.", err)
+			}
+		}
+	}
+	handler.verify(t, conf.keys*conf.messages)
+}
+
+// Tests that events sharing an ordering key are handled in order, while the
+// different keys proceed concurrently.
+func TestPublishOrdered(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		keys     int
+		messages int
+	}{4, 25}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := newOrderTestHandler(conf.keys * conf.messages)
+	limits := &TopicLimits{EventThreads: conf.keys, OrderKey: orderTestKey}
+	if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	for i := 0; i < conf.messages; i++ {
+		for key := 0; key < conf.keys; key++ {
+			if err := conn.Publish(config.topic, []byte{byte(key), byte(i)}); err != nil {
+				t.Fatalf("publish failed: %v.", err)
+			}
+		}
+	}
+	handler.verify(t, conf.keys*conf.messages)
+}
@@ -0,0 +1,364 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the durable outbox, persisting the broadcasts and publishes issued
+// while the relay link is down and replaying them once it is restored.
+
+package iris
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Returned if a message could not be queued into the outbox, as it would exceed
+// the configured limits.
+var ErrOutboxFull = errors.New("outbox full")
+
+// Settings of the durable outbox of a connection.
+type OutboxConfig struct {
+	Path       string // File backing the outbox, created if missing
+	MaxEntries int    // Maximum number of messages queued
+	MaxBytes   int    // Maximum total size of the queued messages
+	Sync       bool   // Whether to sync each queued message to stable storage
+}
+
+// Default settings of the durable outbox.
+var defaultOutboxConfig = OutboxConfig{
+	MaxEntries: 4096,
+	MaxBytes:   16 * 1024 * 1024,
+}
+
+// Merges the user requested outbox settings with the defaults.
+func finalizeOutboxConfig(user *OutboxConfig) *OutboxConfig {
+	config := new(OutboxConfig)
+	*config = *user
+
+	if user.MaxEntries <= 0 {
+		config.MaxEntries = defaultOutboxConfig.MaxEntries
+	}
+	if user.MaxBytes <= 0 {
+		config.MaxBytes = defaultOutboxConfig.MaxBytes
+	}
+	return config
+}
+
+// Single message queued in the outbox.
+type outboxRecord struct {
+	op      byte   // Opcode of the message (broadcast or publish)
+	target  string // Cluster or topic the message is addressed to
+	payload []byte // Contents of the message
+}
+
+// File backed queue of the messages issued while the relay link is down.
+type outbox struct {
+	config *OutboxConfig // Location and limits of the outbox
+	file   *os.File      // File the queued messages are appended to
+
+	entries int   // Number of messages queued
+	bytes   int   // Total size of the queued messages
+	size    int64 // Length of the backing file
+
+	offline bool         // Whether the relay link is down, queueing all messages
+	lock    sync.RWMutex // Mutex to protect the queue and its state
+}
+
+// Opens (or creates) an outbox file, loading the messages left queued in it by
+// a previous run. A partially written trailing message is discarded.
+func openOutbox(config *OutboxConfig) (*outbox, error) {
+	file, err := os.OpenFile(config.Path, os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	blob, err := io.ReadAll(file)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	records, valid := parseOutbox(blob)
+	if err := file.Truncate(int64(valid)); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(int64(valid), io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	box := &outbox{
+		config:  config,
+		file:    file,
+		entries: len(records),
+		size:    int64(valid),
+		offline: len(records) > 0,
+	}
+	for _, record := range records {
+		box.bytes += len(record.target) + len(record.payload)
+	}
+	return box, nil
+}
+
+// Parses the records of an outbox file, returning them along with the length of
+// the well formed prefix of the file.
+func parseOutbox(blob []byte) ([]*outboxRecord, int) {
+	var records []*outboxRecord
+
+	valid := 0
+	for valid < len(blob) {
+		rest := blob[valid+1:]
+
+		size, n := binary.Uvarint(rest)
+		if n <= 0 || size > uint64(len(rest)-n) {
+			break
+		}
+		target, rest := rest[n:n+int(size)], rest[n+int(size):]
+
+		size, m := binary.Uvarint(rest)
+		if m <= 0 || size > uint64(len(rest)-m) {
+			break
+		}
+		payload := rest[m : m+int(size)]
+
+		records = append(records, &outboxRecord{op: blob[valid], target: string(target), payload: payload})
+		valid = len(blob) - len(rest) + m + int(size)
+	}
+	return records, valid
+}
+
+// Appends a message to the outbox, if it fits within the limits.
+func (b *outbox) push(op byte, target string, payload []byte) error {
+	// Make sure the message fits into the outbox
+	size := len(target) + len(payload)
+	if b.entries+1 > b.config.MaxEntries || b.bytes+size > b.config.MaxBytes {
+		return ErrOutboxFull
+	}
+	// Serialize and append the message, rolling back partial writes
+	buf := new(bytes.Buffer)
+	buf.WriteByte(op)
+
+	var scratch [binary.MaxVarintLen64]byte
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(target)))])
+	buf.WriteString(target)
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(payload)))])
+	buf.Write(payload)
+
+	if _, err := b.file.Write(buf.Bytes()); err != nil {
+		b.rewind(b.size)
+		return err
+	}
+	if b.config.Sync {
+		if err := b.file.Sync(); err != nil {
+			b.rewind(b.size)
+			return err
+		}
+	}
+	b.entries++
+	b.bytes += size
+	b.size += int64(buf.Len())
+	return nil
+}
+
+// Cuts the outbox file back to the given length.
+func (b *outbox) rewind(size int64) error {
+	if err := b.file.Truncate(size); err != nil {
+		return err
+	}
+	_, err := b.file.Seek(size, io.SeekStart)
+	return err
+}
+
+// Enables the durable outbox of the connection: broadcasts and publishes issued
+// while the relay link is down (i.e. the connection is reconnecting) are queued
+// into the configured file instead of failing, and are replayed in order after
+// the link is restored. Messages left queued by a previous run are replayed
+// right away.
+//
+// The outbox only covers link failures recovered via EnableReconnect. Messages
+// not fitting into its limits fail with ErrOutboxFull. Delivery is at least
+// once: a replay interrupted by another link failure may repeat some messages.
+// Any unset limits (i.e. value of zero) of the config will default to the preset
+// ones.
+func (c *Connection) EnableOutbox(config *OutboxConfig) error {
+	// Sanity check on the arguments
+	if config == nil || len(config.Path) == 0 {
+		return invalidArgument("empty outbox path")
+	}
+	if atomic.LoadInt32(&c.closing) == 1 {
+		return ErrClosed
+	}
+	box, err := openOutbox(finalizeOutboxConfig(config))
+	if err != nil {
+		return err
+	}
+	// Swap in the new outbox, releasing any previous one
+	c.boxLock.Lock()
+	old := c.outbox
+	c.outbox = box
+	c.boxLock.Unlock()
+
+	if old != nil {
+		old.lock.Lock()
+		old.file.Close()
+		old.lock.Unlock()
+	}
+	c.Log.Info("outbox enabled", "path", config.Path, "pending", box.entries)
+
+	// Replay any messages left over by a previous run, unless the link is down
+	if box.offline && c.Health() == HealthConnected {
+		c.replayOutbox()
+	}
+	return nil
+}
+
+// Disables the durable outbox of the connection. Messages still queued remain in
+// its file, replayed upon enabling the outbox again.
+func (c *Connection) DisableOutbox() error {
+	c.boxLock.Lock()
+	box := c.outbox
+	c.outbox = nil
+	c.boxLock.Unlock()
+
+	if box == nil {
+		return nil
+	}
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	return box.file.Close()
+}
+
+// Retrieves the number of messages queued in the outbox, waiting for the relay
+// link to be restored.
+func (c *Connection) OutboxPending() int {
+	box := c.currentOutbox()
+	if box == nil {
+		return 0
+	}
+	box.lock.RLock()
+	defer box.lock.RUnlock()
+
+	return box.entries
+}
+
+// Retrieves the outbox of the connection, nil if disabled.
+func (c *Connection) currentOutbox() *outbox {
+	c.boxLock.Lock()
+	defer c.boxLock.Unlock()
+
+	return c.outbox
+}
+
+// Sends a broadcast or publish to the relay, or queues it into the outbox if the
+// link is down. Sends failing due to a dropped link are queued too, provided the
+// link is going to be restored.
+func (c *Connection) sendOrQueue(op byte, target string, payload []byte) error {
+	box := c.currentOutbox()
+	if box == nil {
+		return c.sendOutbound(op, target, payload)
+	}
+	// Try to send the message directly while the link is up
+	box.lock.RLock()
+	if !box.offline {
+		err := c.sendOutbound(op, target, payload)
+		box.lock.RUnlock()
+
+		if err == nil || !c.reconnectEnabled() || atomic.LoadInt32(&c.closing) == 1 {
+			return err
+		}
+		c.Log.Warn("relay send failed, queueing into outbox", "reason", err)
+	} else {
+		box.lock.RUnlock()
+	}
+	// Link down, persist the message until it's restored
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	box.offline = true
+	if err := box.push(op, target, payload); err != nil {
+		return err
+	}
+	c.Log.Debug("queued message into outbox", "target", target, "pending", box.entries)
+	return nil
+}
+
+// Sends a broadcast or publish packet to the relay.
+func (c *Connection) sendOutbound(op byte, target string, payload []byte) error {
+	if op == opBroadcast {
+		return c.sendBroadcast(target, payload)
+	}
+	return c.sendPublish(target, payload)
+}
+
+// Checks whether automatic reconnection is enabled.
+func (c *Connection) reconnectEnabled() bool {
+	c.recoLock.Lock()
+	defer c.recoLock.Unlock()
+
+	return c.recoPolicy != nil
+}
+
+// Switches the outbox (if any) into queueing mode after the relay link dropped.
+func (c *Connection) suspendOutbox() {
+	if box := c.currentOutbox(); box != nil {
+		box.lock.Lock()
+		box.offline = true
+		box.lock.Unlock()
+	}
+}
+
+// Replays the messages queued in the outbox onto the relay link, switching back
+// to direct sends if all of them went through. On failure, the messages not yet
+// sent remain queued for the next restored link.
+func (c *Connection) replayOutbox() error {
+	box := c.currentOutbox()
+	if box == nil {
+		return nil
+	}
+	box.lock.Lock()
+	defer box.lock.Unlock()
+
+	if !box.offline {
+		return nil
+	}
+	// Load back all the queued messages
+	if _, err := box.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	blob, err := io.ReadAll(box.file)
+	if err != nil {
+		return err
+	}
+	records, _ := parseOutbox(blob)
+	c.Log.Info("replaying outbox", "pending", len(records))
+
+	// Send them in order, keeping the remainder on failure
+	for i, record := range records {
+		if err := c.sendOutbound(record.op, record.target, record.payload); err != nil {
+			c.Log.Warn("outbox replay failed", "sent", i, "pending", len(records)-i, "reason", err)
+			box.rewind(0)
+			box.entries, box.bytes, box.size = 0, 0, 0
+			for _, rest := range records[i:] {
+				box.push(rest.op, rest.target, rest.payload)
+			}
+			return err
+		}
+	}
+	box.offline = false
+	box.entries, box.bytes, box.size = 0, 0, 0
+	return box.rewind(0)
+}
+
+// Releases the outbox of a closing connection, keeping its queued messages on
+// disk.
+func (c *Connection) closeOutbox() {
+	if err := c.DisableOutbox(); err != nil {
+		c.Log.Warn("failed to close outbox", "reason", err)
+	}
+}
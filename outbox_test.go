@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Transport wrapping the default one, able to drop its current link on demand.
+type outboxTestTransport struct {
+	inner RelayTransport
+	link  RelayLink
+	lock  sync.Mutex
+}
+
+func (t *outboxTestTransport) Dial(ctx context.Context) (RelayLink, error) {
+	link, err := t.inner.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	t.lock.Lock()
+	t.link = link
+	t.lock.Unlock()
+	return link, nil
+}
+
+func (t *outboxTestTransport) drop() {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+
+	t.link.Close()
+}
+
+// Subscribes to the test topic, streaming the arriving events into a channel.
+func subscribeOutboxTest(t *testing.T) (*Connection, chan string) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	events := make(chan string, 64)
+	if err := conn.SubscribeFunc(config.topic, func(topic string, event []byte) { events <- string(event) }, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	return conn, events
+}
+
+// Waits for the expected set of events to arrive, in any order.
+func checkOutboxEvents(t *testing.T, events chan string, want int) {
+	seen := make(map[string]bool)
+	for len(seen) < want {
+		select {
+		case event := <-events:
+			if seen[event] {
+				t.Fatalf("duplicate event: %s.", event)
+			}
+			seen[event] = true
+		case <-time.After(2 * time.Second):
+			t.Fatalf("events missing: have %d, want %d.", len(seen), want)
+		}
+	}
+	for i := 0; i < want; i++ {
+		if event := fmt.Sprintf("event-%d", i); !seen[event] {
+			t.Fatalf("event %s missing.", event)
+		}
+	}
+}
+
+// Tests that publishes issued while the relay link is down are queued into the
+// outbox and replayed after reconnecting.
+func TestOutboxReconnect(t *testing.T) {
+	sub, events := subscribeOutboxTest(t)
+	defer sub.Close()
+
+	// Connect through a droppable transport with the outbox enabled
+	trans := &outboxTestTransport{inner: NewTCPTransport(config.relay, nil)}
+	conn, err := ConnectTransport(trans)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	conn.EnableReconnect(&ReconnectPolicy{MinBackoff: 250 * time.Millisecond})
+	if err := conn.EnableOutbox(&OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox")}); err != nil {
+		t.Fatalf("failed to enable outbox: %v.", err)
+	}
+	// Drop the link and publish while it's being restored
+	trans.drop()
+	for start := time.Now(); conn.Health() != HealthDegraded; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("link drop not detected.")
+		}
+	}
+	for i := 0; i < 10; i++ {
+		if err := conn.Publish(config.topic, []byte(fmt.Sprintf("event-%d", i))); err != nil {
+			t.Fatalf("offline publish %d failed: %v.", i, err)
+		}
+	}
+	if pending := conn.OutboxPending(); pending != 10 {
+		t.Fatalf("outbox size mismatch: have %d, want %d.", pending, 10)
+	}
+	// Verify that the queued events arrive after reconnecting
+	checkOutboxEvents(t, events, 10)
+	if pending := conn.OutboxPending(); pending != 0 {
+		t.Fatalf("outbox not drained: %d pending.", pending)
+	}
+}
+
+// Tests that messages left in an outbox file are replayed when it's opened, and
+// that partially written trailing ones are discarded.
+func TestOutboxRecovery(t *testing.T) {
+	sub, events := subscribeOutboxTest(t)
+	defer sub.Close()
+
+	// Leave a few events queued in an outbox file, with a torn one at the end
+	settings := &OutboxConfig{Path: filepath.Join(t.TempDir(), "outbox"), MaxEntries: 5}
+	box, err := openOutbox(finalizeOutboxConfig(settings))
+	if err != nil {
+		t.Fatalf("failed to open outbox: %v.", err)
+	}
+	for i := 0; i < 5; i++ {
+		if err := box.push(opPublish, config.topic, []byte(fmt.Sprintf("event-%d", i))); err != nil {
+			t.Fatalf("failed to queue event %d: %v.", i, err)
+		}
+	}
+	if err := box.push(opPublish, config.topic, []byte("overflow")); err != ErrOutboxFull {
+		t.Fatalf("overflow error mismatch: have %v, want %v.", err, ErrOutboxFull)
+	}
+	box.file.Write([]byte{opPublish, 0x7f})
+	box.file.Close()
+
+	// Enable the outbox on a live connection and verify the replay
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if err := conn.EnableOutbox(settings); err != nil {
+		t.Fatalf("failed to enable outbox: %v.", err)
+	}
+	checkOutboxEvents(t, events, 5)
+	if pending := conn.OutboxPending(); pending != 0 {
+		t.Fatalf("outbox not drained: %d pending.", pending)
+	}
+	if info, err := os.Stat(settings.Path); err != nil || info.Size() != 0 {
+		t.Fatalf("outbox file not truncated: %v, %v.", info, err)
+	}
+}
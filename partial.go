@@ -0,0 +1,68 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the bounding of the partially assembled inbound tunnel messages,
+// discarding the ones stalled mid-transfer (e.g. the sender died) instead of
+// holding on to their buffers indefinitely.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Discards the message being assembled, if any, granting back its allowance.
+// The chunk lock is assumed to be held.
+func (t *Tunnel) discardPartial(reason string) {
+	if t.chunkTimer != nil {
+		t.chunkTimer.Stop()
+	}
+	if t.chunkBuf == nil {
+		return
+	}
+	t.Log.Warn("incomplete message discarded", "size", t.chunkSize, "arrived", len(t.chunkBuf), "reason", reason)
+
+	t.grant(len(t.chunkBuf))
+	tunnelBuffers.put(t.chunkBuf)
+	t.chunkBuf = nil
+}
+
+// Marks the arrival of a chunk of the message being assembled, (re)arming the
+// stall timer if enabled. The chunk lock is assumed to be held.
+func (t *Tunnel) armPartial() {
+	timeout := t.limits.AssembleTimeout
+	if timeout <= 0 {
+		return
+	}
+	t.chunkLast = time.Now()
+	if t.chunkTimer == nil {
+		t.chunkTimer = time.AfterFunc(timeout, t.expirePartial)
+	} else {
+		t.chunkTimer.Reset(timeout)
+	}
+}
+
+// Discards the message being assembled if no chunk arrived for the configured
+// timeout, skipping any of its late continuations.
+func (t *Tunnel) expirePartial() {
+	t.chunkLock.Lock()
+	defer t.chunkLock.Unlock()
+
+	if t.chunkBuf == nil {
+		return
+	}
+	// A chunk may have arrived since the timer fired, wait for the rest if so
+	timeout := t.limits.AssembleTimeout
+	if idle := time.Since(t.chunkLast); idle < timeout {
+		t.chunkTimer.Reset(timeout - idle)
+		return
+	}
+	atomic.AddUint64(&t.conn.stats.dropped, 1)
+
+	t.chunkSkip = t.chunkSize - len(t.chunkBuf)
+	t.discardPartial("stalled")
+}
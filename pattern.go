@@ -0,0 +1,233 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the client side emulation of hierarchical topic pattern subscriptions.
+//
+// Since the relay only routes exact topic matches, pattern aware publishers also
+// forward each event of a dotted topic a.b.c to the fan-in topics #, a.#, a.b.#
+// and a.b.c.#, embedding the original topic. A pattern subscription listens on
+// the fan-in topic of its literal prefix and matches the embedded topics client
+// side over a topic tree.
+
+package iris
+
+import (
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Topic pattern segment wildcards.
+const (
+	patternSingle = "*" // Matches exactly one segment
+	patternMulti  = "#" // Matches zero or more trailing segments
+)
+
+// Tree of pattern subscriptions sharing the same fan-in topic, keyed by the
+// pattern segments.
+type topicTree struct {
+	subs     []*topic              // Subscriptions whose pattern ends at this node
+	multi    []*topic              // Subscriptions ending with a '#' after this node
+	children map[string]*topicTree // Literal and '*' child segments
+}
+
+// Checks whether a topic is a pattern, validating its wildcard usage.
+func parsePattern(topic string) (bool, error) {
+	segments := strings.Split(topic, ".")
+	pattern := false
+	for i, segment := range segments {
+		switch {
+		case segment == patternSingle:
+			pattern = true
+		case segment == patternMulti:
+			if i != len(segments)-1 {
+				return false, invalidArgument("invalid topic pattern %q: '#' must be the last segment", topic)
+			}
+			pattern = true
+		case strings.ContainsAny(segment, patternSingle+patternMulti):
+			return false, invalidArgument("invalid topic pattern %q: wildcards must span whole segments", topic)
+		}
+	}
+	return pattern, nil
+}
+
+// Returns the fan-in topic a pattern subscription listens on: the literal prefix
+// of the pattern followed by a '#' segment.
+func patternFanIn(pattern string) string {
+	var prefix []string
+	for _, segment := range strings.Split(pattern, ".") {
+		if segment == patternSingle || segment == patternMulti {
+			break
+		}
+		prefix = append(prefix, segment)
+	}
+	return strings.Join(append(prefix, patternMulti), ".")
+}
+
+// Returns all the fan-in topics an event published to topic is forwarded to.
+func topicFanIns(topic string) []string {
+	segments := strings.Split(topic, ".")
+
+	fanins := []string{patternMulti}
+	for i := range segments {
+		fanins = append(fanins, strings.Join(segments[:i+1], ".")+"."+patternMulti)
+	}
+	return fanins
+}
+
+// Embeds the original topic into an event forwarded to a fan-in topic.
+func wrapFanIn(topic string, event []byte) []byte {
+	blob := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(topic)+len(event))
+	blob = blob[:binary.PutUvarint(blob, uint64(len(topic)))]
+	blob = append(blob, topic...)
+	return append(blob, event...)
+}
+
+// Splits the original topic off an event arriving on a fan-in topic.
+func unwrapFanIn(blob []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(blob)
+	if n <= 0 || uint64(len(blob)-n) < size {
+		return "", nil, fmt.Errorf("%w: malformed fan-in event", ErrProtocolViolation)
+	}
+	return string(blob[n : n+int(size)]), blob[n+int(size):], nil
+}
+
+// Inserts a pattern subscription into the tree.
+func (t *topicTree) insert(segments []string, top *topic) {
+	switch {
+	case len(segments) == 0:
+		t.subs = append(t.subs, top)
+	case segments[0] == patternMulti:
+		t.multi = append(t.multi, top)
+	default:
+		if t.children == nil {
+			t.children = make(map[string]*topicTree)
+		}
+		child, ok := t.children[segments[0]]
+		if !ok {
+			child = new(topicTree)
+			t.children[segments[0]] = child
+		}
+		child.insert(segments[1:], top)
+	}
+}
+
+// Removes a pattern subscription from the tree, pruning any emptied branches.
+func (t *topicTree) remove(segments []string, top *topic) {
+	drop := func(list []*topic) []*topic {
+		for i, sub := range list {
+			if sub == top {
+				return append(list[:i:i], list[i+1:]...)
+			}
+		}
+		return list
+	}
+	switch {
+	case len(segments) == 0:
+		t.subs = drop(t.subs)
+	case segments[0] == patternMulti:
+		t.multi = drop(t.multi)
+	default:
+		if child, ok := t.children[segments[0]]; ok {
+			child.remove(segments[1:], top)
+			if child.empty() {
+				delete(t.children, segments[0])
+			}
+		}
+	}
+}
+
+// Checks whether the tree contains any subscriptions.
+func (t *topicTree) empty() bool {
+	return len(t.subs) == 0 && len(t.multi) == 0 && len(t.children) == 0
+}
+
+// Collects all the subscriptions whose pattern matches the topic segments.
+func (t *topicTree) match(segments []string, hits []*topic) []*topic {
+	hits = append(hits, t.multi...)
+	if len(segments) == 0 {
+		return append(hits, t.subs...)
+	}
+	if child, ok := t.children[segments[0]]; ok {
+		hits = child.match(segments[1:], hits)
+	}
+	if child, ok := t.children[patternSingle]; ok {
+		hits = child.match(segments[1:], hits)
+	}
+	return hits
+}
+
+// Enables or disables forwarding published events to the fan-in topics of the
+// pattern subscriptions. Pattern subscribers only receive events from pattern
+// aware publishers, since the relay itself has no notion of topic patterns.
+func (c *Connection) SetPatternPublish(enabled bool) {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	c.patPublish = enabled
+}
+
+// Registers a pattern subscription in the tree of its fan-in topic, returning
+// whether the fan-in topic needs subscribing on the relay. The subscription lock
+// is assumed to be held.
+func (c *Connection) insertPattern(pattern string, top *topic) bool {
+	fanin := patternFanIn(pattern)
+
+	tree, ok := c.patLive[fanin]
+	if !ok {
+		tree = new(topicTree)
+		c.patLive[fanin] = tree
+	}
+	tree.insert(strings.Split(pattern, "."), top)
+	return !ok
+}
+
+// Removes a pattern subscription from the tree of its fan-in topic, returning
+// whether the fan-in topic needs unsubscribing from the relay. The subscription
+// lock is assumed to be held.
+func (c *Connection) removePattern(pattern string, top *topic) bool {
+	fanin := patternFanIn(pattern)
+
+	tree, ok := c.patLive[fanin]
+	if !ok {
+		return false
+	}
+	tree.remove(strings.Split(pattern, "."), top)
+	if tree.empty() {
+		delete(c.patLive, fanin)
+		return true
+	}
+	return false
+}
+
+// Forwards an event arriving on a fan-in topic to all the matching pattern
+// subscriptions, returning false if the topic is not a live fan-in topic.
+func (c *Connection) handlePatternPublish(fanin string, blob []byte, arrived time.Time) bool {
+	c.subLock.RLock()
+	tree, ok := c.patLive[fanin]
+	if !ok {
+		c.subLock.RUnlock()
+		return false
+	}
+	topic, event, err := unwrapFanIn(blob)
+	if err != nil {
+		c.subLock.RUnlock()
+		c.Log.Warn("invalid pattern event dropped", "topic", fanin, "reason", err)
+		return true
+	}
+	hits := tree.match(strings.Split(topic, "."), nil)
+	c.subLock.RUnlock()
+
+	for _, top := range hits {
+		if top.limits.Overflow == OverflowBlock {
+			top.holdBack(topic, event, arrived)
+		} else {
+			top.handlePublish(topic, event, arrived)
+		}
+	}
+	return true
+}
@@ -0,0 +1,171 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sort"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Tests the client side topic tree matching.
+func TestTopicTreeMatch(t *testing.T) {
+	patterns := []string{"a.b.c", "a.*.c", "a.#", "*.b.*", "#", "a.b.c.#"}
+	tests := []struct {
+		topic string
+		want  []string
+	}{
+		{"a.b.c", []string{"#", "*.b.*", "a.#", "a.*.c", "a.b.c", "a.b.c.#"}},
+		{"a.x.c", []string{"#", "a.#", "a.*.c"}},
+		{"a", []string{"#", "a.#"}},
+		{"x.b.y", []string{"#", "*.b.*"}},
+		{"x.y", []string{"#"}},
+	}
+	// Assemble the tree, naming each subscription after its pattern
+	tree := new(topicTree)
+	for _, pattern := range patterns {
+		tree.insert(strings.Split(pattern, "."), &topic{name: pattern})
+	}
+	for _, tt := range tests {
+		var have []string
+		for _, hit := range tree.match(strings.Split(tt.topic, "."), nil) {
+			have = append(have, hit.name)
+		}
+		sort.Strings(have)
+		if strings.Join(have, " ") != strings.Join(tt.want, " ") {
+			t.Errorf("topic %s: match mismatch: have %v, want %v.", tt.topic, have, tt.want)
+		}
+	}
+	// Remove all subscriptions and ensure the tree is pruned
+	for _, hit := range tree.match([]string{"a", "b", "c"}, nil) {
+		tree.remove(strings.Split(hit.name, "."), hit)
+	}
+	for _, hit := range tree.match([]string{"a", "x", "c"}, nil) {
+		tree.remove(strings.Split(hit.name, "."), hit)
+	}
+	if !tree.empty() {
+		t.Fatalf("tree not empty after removals.")
+	}
+}
+
+// Tests that pattern subscriptions receive the matching events.
+func TestPatternSubscribe(t *testing.T) {
+	// Connect to the local relay with pattern publishing enabled
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+	conn.SetPatternPublish(true)
+
+	// Subscribe to a few overlapping patterns
+	single := &publishTestTopicHandler{delivers: make(chan []byte, 10)}
+	multi := &publishTestTopicHandler{delivers: make(chan []byte, 10)}
+
+	if err := conn.Subscribe("sensor.*.temperature", single, nil); err != nil {
+		t.Fatalf("single-level subscription failed: %v.", err)
+	}
+	if err := conn.Subscribe("sensor.#", multi, nil); err != nil {
+		t.Fatalf("multi-level subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish a batch of events and verify the deliveries
+	for _, topic := range []string{"sensor.a.temperature", "sensor.b.humidity", "logs.app"} {
+		if err := conn.Publish(topic, []byte(topic)); err != nil {
+			t.Fatalf("failed to publish to %s: %v.", topic, err)
+		}
+	}
+	for _, check := range []struct {
+		handler *publishTestTopicHandler
+		want    []string
+	}{
+		{single, []string{"sensor.a.temperature"}},
+		{multi, []string{"sensor.a.temperature", "sensor.b.humidity"}},
+	} {
+		var have []string
+		for range check.want {
+			select {
+			case event := <-check.handler.delivers:
+				have = append(have, string(event))
+			case <-time.After(time.Second):
+				t.Fatalf("event delivery timed out: have %v, want %v.", have, check.want)
+			}
+		}
+		sort.Strings(have)
+		if strings.Join(have, " ") != strings.Join(check.want, " ") {
+			t.Fatalf("delivery mismatch: have %v, want %v.", have, check.want)
+		}
+		select {
+		case event := <-check.handler.delivers:
+			t.Fatalf("unexpected event delivered: %s.", event)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+	// Unsubscribe the patterns
+	if err := conn.Unsubscribe("sensor.*.temperature"); err != nil {
+		t.Fatalf("failed to unsubscribe: %v.", err)
+	}
+	if err := conn.Unsubscribe("sensor.#"); err != nil {
+		t.Fatalf("failed to unsubscribe: %v.", err)
+	}
+}
+
+// Tests that raw callback subscriptions receive the concrete event topics.
+func TestSubscribeFunc(t *testing.T) {
+	// Connect to the local relay with pattern publishing enabled
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+	conn.SetPatternPublish(true)
+
+	// Subscribe a raw callback to a pattern and a plain topic
+	topics := make(chan string, 10)
+	handler := func(topic string, event []byte) {
+		if topic != string(event) {
+			t.Errorf("topic mismatch: have %s, want %s.", topic, event)
+		}
+		topics <- topic
+	}
+	if err := conn.SubscribeFunc("sensor.#", handler, &TopicLimits{EventThreads: 1}); err != nil {
+		t.Fatalf("pattern subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("sensor.#")
+
+	if err := conn.SubscribeFunc("logs", handler, nil); err != nil {
+		t.Fatalf("topic subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe("logs")
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish a batch of events and verify the reported topics
+	want := []string{"logs", "sensor.a.temperature", "sensor.b.humidity"}
+	for _, topic := range want {
+		if err := conn.Publish(topic, []byte(topic)); err != nil {
+			t.Fatalf("failed to publish to %s: %v.", topic, err)
+		}
+	}
+	var have []string
+	for range want {
+		select {
+		case topic := <-topics:
+			have = append(have, topic)
+		case <-time.After(time.Second):
+			t.Fatalf("event delivery timed out: have %v, want %v.", have, want)
+		}
+	}
+	sort.Strings(have)
+	if strings.Join(have, " ") != strings.Join(want, " ") {
+		t.Fatalf("topic mismatch: have %v, want %v.", have, want)
+	}
+	if err := conn.SubscribeFunc("nil", nil, nil); err == nil {
+		t.Fatalf("nil callback subscription succeeded.")
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pausing and resuming of subscriptions, holding back the event
+// delivery locally while retaining the topic membership at the relay.
+
+package iris
+
+// Pauses the event delivery of a subscription (plain or pattern) without leaving
+// the topic. Arriving events are queued within the subscription's limits until
+// it is resumed, with the overflow policy applying to the excess ones; blocking
+// overflows drop the arriving events instead, as a pause would outlast their
+// bounded hold-back anyway. Handlers already running are not interrupted.
+func (c *Connection) PauseSubscription(topic string) error {
+	top, err := c.liveTopic(topic)
+	if err != nil {
+		return err
+	}
+	top.eventLock.Lock()
+	defer top.eventLock.Unlock()
+
+	if !top.paused {
+		top.logger.Info("pausing subscription", "pending", top.eventQueue.Size())
+		top.paused = true
+		top.eventCond.Broadcast()
+	}
+	return nil
+}
+
+// Resumes the event delivery of a paused subscription, dispatching the events
+// queued meanwhile.
+func (c *Connection) ResumeSubscription(topic string) error {
+	top, err := c.liveTopic(topic)
+	if err != nil {
+		return err
+	}
+	top.eventLock.Lock()
+	if !top.paused {
+		top.eventLock.Unlock()
+		return nil
+	}
+	top.paused = false
+	pending := top.eventQueue.Size()
+	top.eventLock.Unlock()
+
+	top.logger.Info("resuming subscription", "pending", pending)
+	for i := 0; i < pending; i++ {
+		top.eventPool.Schedule(top.handleEvent)
+	}
+	return nil
+}
+
+// Retrieves the live subscription of a topic.
+func (c *Connection) liveTopic(topic string) (*topic, error) {
+	if len(topic) == 0 {
+		return nil, invalidArgument("empty topic identifier")
+	}
+	c.subLock.RLock()
+	defer c.subLock.RUnlock()
+
+	top, ok := c.subLive[topic]
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+	return top, nil
+}
+
+// Pauses the event delivery of the topic's subscription without leaving it. See
+// Connection.PauseSubscription for details.
+func (t *Topic[T]) Pause() error {
+	return t.conn.PauseSubscription(t.name)
+}
+
+// Resumes the event delivery of the topic's paused subscription, dispatching
+// the events queued meanwhile.
+func (t *Topic[T]) Resume() error {
+	return t.conn.ResumeSubscription(t.name)
+}
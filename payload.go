@@ -0,0 +1,70 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the connection level payload codec, transforming every application
+// payload on its way to and from the relay.
+//
+// Broadcasts, requests, replies and events are encoded right before being put on
+// the wire and decoded right after being read off it, so the codec sees the
+// payloads along with any in-band extensions of the binding. Tunnel messages are
+// encoded as a whole before compression and encryption, and decoded after those,
+// leaving the tunnel control messages untouched.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// Symmetric transformation applied to every payload crossing a connection, such
+// as transparent compression, encryption or schema envelope injection. Decode
+// must invert Encode, and all the connections exchanging messages need to use
+// the same codec. The operation tells the messaging primitive the payload
+// belongs to (replies counting as requests).
+//
+// Inbound broadcasts, requests, replies and events are decoded on the network
+// receiver, so the codec should be swift and needs to be safe for concurrent use.
+type PayloadCodec interface {
+	Encode(op TraceOp, payload []byte) ([]byte, error) // Transforms an outbound payload
+	Decode(op TraceOp, payload []byte) ([]byte, error) // Restores an inbound payload
+}
+
+// Encodes an outbound payload with the codec of the connection, if any.
+func (c *Connection) encodePayload(op TraceOp, payload []byte) ([]byte, error) {
+	if c.codec == nil {
+		return payload, nil
+	}
+	encoded, err := c.codec.Encode(op, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode %s payload: %w", op, err)
+	}
+	return encoded, nil
+}
+
+// Decodes an inbound payload with the codec of the connection, if any.
+func (c *Connection) decodePayload(op TraceOp, payload []byte) ([]byte, error) {
+	if c.codec == nil {
+		return payload, nil
+	}
+	decoded, err := c.codec.Decode(op, payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode %s payload: %w", op, err)
+	}
+	return decoded, nil
+}
+
+// Encodes an application message and sends it over the tunnel.
+func (t *Tunnel) sendData(ctx context.Context, message []byte, deadline <-chan time.Time) error {
+	if len(message) > 0 {
+		var err error
+		if message, err = t.conn.encodePayload(TraceTunnel, message); err != nil {
+			return err
+		}
+	}
+	return t.send(ctx, message, deadline)
+}
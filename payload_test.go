@@ -0,0 +1,144 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Payload codec prefixing every payload with a marker, failing to decode the
+// ones lacking it.
+type payloadTestCodec struct{}
+
+var payloadTestMarker = []byte("encoded:")
+
+func (payloadTestCodec) Encode(op TraceOp, payload []byte) ([]byte, error) {
+	return append(append([]byte{}, payloadTestMarker...), payload...), nil
+}
+
+func (payloadTestCodec) Decode(op TraceOp, payload []byte) ([]byte, error) {
+	if !bytes.HasPrefix(payload, payloadTestMarker) {
+		return nil, errors.New("missing marker")
+	}
+	return payload[len(payloadTestMarker):], nil
+}
+
+// Service handler for the payload codec tests, forwarding broadcasts, echoing
+// requests and echoing a message back on tunnels.
+type payloadTestHandler struct {
+	conn     *Connection
+	delivers chan []byte
+}
+
+func (p *payloadTestHandler) Init(conn *Connection) error              { p.conn = conn; return nil }
+func (p *payloadTestHandler) HandleBroadcast(msg []byte)               { p.delivers <- msg }
+func (p *payloadTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (p *payloadTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (p *payloadTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	if msg, err := tun.Recv(time.Second); err == nil {
+		tun.Send(msg, time.Second)
+	}
+}
+
+// Tests that the payload codec of a connection transforms the payloads of all
+// the messaging primitives on the wire.
+func TestPayloadCodec(t *testing.T) {
+	// Register a new service and connect a client, both using the codec
+	handler := &payloadTestHandler{
+		delivers: make(chan []byte, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil, WithPayloadCodec(payloadTestCodec{}))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay, WithPayloadCodec(payloadTestCodec{}))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that broadcasts, requests and tunnel messages round trip
+	message := []byte("payload")
+	if err := conn.Broadcast(config.cluster, message); err != nil {
+		t.Fatalf("failed to broadcast: %v.", err)
+	}
+	select {
+	case msg := <-handler.delivers:
+		if !bytes.Equal(msg, message) {
+			t.Fatalf("broadcast mismatch: have %q, want %q.", msg, message)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("broadcast not delivered.")
+	}
+	if reply, err := conn.Request(config.cluster, message, time.Second); err != nil || !bytes.Equal(reply, message) {
+		t.Fatalf("reply mismatch: have %q/%v, want %q.", reply, err, message)
+	}
+	tunnel, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if err := tunnel.Send(message, time.Second); err != nil {
+		t.Fatalf("failed to send tunnel message: %v.", err)
+	}
+	if msg, err := tunnel.Recv(time.Second); err != nil || !bytes.Equal(msg, message) {
+		t.Fatalf("tunnel message mismatch: have %q/%v, want %q.", msg, err, message)
+	}
+	// Verify that events are decoded by codec subscribers, but not by plain ones
+	plain, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer plain.Close()
+
+	encoded := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	decoded := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := plain.Subscribe(config.topic, encoded, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	defer plain.Unsubscribe(config.topic)
+
+	if err := handler.conn.Subscribe(config.topic, decoded, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	defer handler.conn.Unsubscribe(config.topic)
+
+	time.Sleep(100 * time.Millisecond)
+	if err := conn.Publish(config.topic, message); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	wants := map[*publishTestTopicHandler][]byte{
+		decoded: message,
+		encoded: append(append([]byte{}, payloadTestMarker...), message...),
+	}
+	for sub, want := range wants {
+		select {
+		case event := <-sub.delivers:
+			if !bytes.Equal(event, want) {
+				t.Fatalf("event mismatch: have %q, want %q.", event, want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("event not delivered.")
+		}
+	}
+	// Verify that requests lacking the encoding are rejected
+	_, err = plain.Request(config.cluster, message, time.Second)
+
+	var rerr *RemoteError
+	if !errors.As(err, &rerr) || rerr.Code != CodeInvalidArgument {
+		t.Fatalf("plain request result mismatch: have %v, want invalid argument failure.", err)
+	}
+}
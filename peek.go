@@ -0,0 +1,82 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the non-blocking inspection and retrieval of the buffered inbound
+// tunnel messages, letting consumers make batching decisions before committing
+// to a receive.
+
+package iris
+
+import (
+	"errors"
+	"io"
+)
+
+// Returned by the non-blocking tunnel operations if no message is buffered.
+var ErrNoMessage = errors.New("no message available")
+
+// Returns the number of inbound messages buffered and ready to be received.
+func (t *Tunnel) Pending() int {
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	return t.itoaBuf.Size()
+}
+
+// Retrieves the next buffered message along with its metadata (nil if the
+// sender attached none) without consuming it, or fails with ErrNoMessage if
+// none is available. The data is shared with the buffered message: it must not
+// be modified and is only valid until the message is received.
+//
+// Streamed messages fail with ErrChunked, as they can only be consumed via
+// RecvChunks. If the buffer is drained and the remote side closed its write
+// end, io.EOF is returned.
+func (t *Tunnel) Peek() ([]byte, *MessageMeta, error) {
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	if t.streamOpen {
+		return nil, nil, ErrChunked
+	}
+	if t.itoaBuf.Empty() {
+		if t.itoaEOF {
+			return nil, nil, io.EOF
+		}
+		return nil, nil, t.noMessage()
+	}
+	if err := t.openFront(); err != nil {
+		return nil, nil, err
+	}
+	front := t.itoaBuf.Front().(*inboundMessage)
+	if front.more {
+		return nil, nil, ErrChunked
+	}
+	return front.data, front.meta, nil
+}
+
+// Retrieves a message from the tunnel if one is already buffered, or fails with
+// ErrNoMessage without blocking otherwise.
+func (t *Tunnel) TryRecv() ([]byte, error) {
+	msg, err := t.fetchMessage(-1, false)
+	if err != nil {
+		return nil, err
+	}
+	if msg == nil {
+		return nil, t.noMessage()
+	}
+	return msg.data, nil
+}
+
+// Returns the error to report if no message is buffered: the reason of closure
+// for torn down tunnels, ErrNoMessage otherwise.
+func (t *Tunnel) noMessage() error {
+	select {
+	case <-t.term:
+		return t.closedErr()
+	default:
+		return ErrNoMessage
+	}
+}
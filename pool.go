@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the resizable handler pool limiting the concurrent message handlers.
+
+package iris
+
+import "sync"
+
+// Task queue and concurrency limiter for message handlers, whose size can be
+// adjusted at runtime.
+type handlerPool struct {
+	tasks   Queue // Handler tasks waiting for execution
+	size    int   // Maximum number of concurrently running handlers
+	running int   // Number of currently live worker goroutines
+	started bool  // Flag whether task execution is enabled
+	closed  bool  // Flag whether the pool was terminated
+
+	lock sync.Mutex     // Protects the task queue and the counters
+	done sync.WaitGroup // Tracks the live worker goroutines
+}
+
+// Creates a new handler pool executing at most size tasks concurrently, queuing
+// the pending ones into the given task queue.
+func newHandlerPool(size int, tasks Queue) *handlerPool {
+	return &handlerPool{
+		tasks: tasks,
+		size:  size,
+	}
+}
+
+// Enables the execution of the scheduled tasks.
+func (p *handlerPool) Start() {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.started = true
+	p.spawn()
+}
+
+// Queues a task for execution, returning ErrClosed if the pool terminated, or
+// ErrOverloaded if the bounded task queue is full.
+func (p *handlerPool) Schedule(task func()) error {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	if !p.tasks.Push(task) {
+		return ErrOverloaded
+	}
+	p.spawn()
+	return nil
+}
+
+// Queues a task for execution with the given priority. Pools not backed by a
+// priority queue ignore the priority and schedule the task in arrival order.
+func (p *handlerPool) SchedulePriority(task func(), priority Priority) error {
+	prio, ok := p.tasks.(*prioQueue)
+	if !ok {
+		return p.Schedule(task)
+	}
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if p.closed {
+		return ErrClosed
+	}
+	if !prio.PushPriority(task, priority) {
+		return ErrOverloaded
+	}
+	p.spawn()
+	return nil
+}
+
+// Changes the maximum number of concurrently running tasks. Shrinking the pool
+// does not interrupt running tasks, excess workers exit after finishing them.
+func (p *handlerPool) Resize(size int) {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	p.size = size
+	p.spawn()
+}
+
+// Returns the maximum number of concurrently running tasks.
+func (p *handlerPool) Size() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.size
+}
+
+// Returns the number of tasks waiting for execution.
+func (p *handlerPool) Pending() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.tasks.Size()
+}
+
+// Returns the number of tasks of the given priority waiting for execution. Pools
+// not backed by a priority queue report all their pending tasks.
+func (p *handlerPool) PendingPriority(priority Priority) int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	if prio, ok := p.tasks.(*prioQueue); ok {
+		return prio.SizePriority(priority)
+	}
+	return p.tasks.Size()
+}
+
+// Checks whether there are tasks pending or running.
+func (p *handlerPool) Busy() bool {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return p.running > 0 || !p.tasks.Empty()
+}
+
+// Terminates the pool, waiting for the running tasks to finish. If clear is set,
+// pending tasks are dropped, otherwise they are executed before returning.
+func (p *handlerPool) Terminate(clear bool) {
+	p.lock.Lock()
+	p.closed = true
+	if clear || !p.started {
+		p.tasks.Reset()
+	}
+	p.lock.Unlock()
+
+	p.done.Wait()
+}
+
+// Starts new workers while there are tasks pending and capacity available. The
+// pool lock is assumed to be held.
+func (p *handlerPool) spawn() {
+	for p.started && p.running < p.size && !p.tasks.Empty() {
+		p.running++
+		p.done.Add(1)
+		go p.work()
+	}
+}
+
+// Executes pending tasks until the queue drains or the pool shrinks.
+func (p *handlerPool) work() {
+	defer p.done.Done()
+
+	for {
+		p.lock.Lock()
+		if p.running > p.size || p.tasks.Empty() {
+			p.running--
+			p.lock.Unlock()
+			return
+		}
+		task := p.tasks.Pop().(func())
+		p.lock.Unlock()
+
+		task()
+	}
+}
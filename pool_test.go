@@ -0,0 +1,59 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Tests that resizing a handler pool adjusts the task concurrency.
+func TestHandlerPoolResize(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		tasks int
+		small int
+		large int
+	}{10, 1, 4}
+
+	pool := newHandlerPool(conf.small, newQueue(nil))
+	pool.Start()
+
+	// Schedule a batch of blocking tasks, tracking the concurrency
+	var running int32
+	release := make(chan struct{})
+	for i := 0; i < conf.tasks; i++ {
+		pool.Schedule(func() {
+			atomic.AddInt32(&running, 1)
+			defer atomic.AddInt32(&running, -1)
+			<-release
+		})
+	}
+	time.Sleep(10 * time.Millisecond)
+	if have := atomic.LoadInt32(&running); have != int32(conf.small) {
+		t.Fatalf("concurrency mismatch: have %v, want %v.", have, conf.small)
+	}
+	if have := pool.Pending(); have != conf.tasks-conf.small {
+		t.Fatalf("pending mismatch: have %v, want %v.", have, conf.tasks-conf.small)
+	}
+	// Grow the pool and verify the new concurrency
+	pool.Resize(conf.large)
+	time.Sleep(10 * time.Millisecond)
+	if have := atomic.LoadInt32(&running); have != int32(conf.large) {
+		t.Fatalf("resized concurrency mismatch: have %v, want %v.", have, conf.large)
+	}
+	if have := pool.Pending(); have != conf.tasks-conf.large {
+		t.Fatalf("resized pending mismatch: have %v, want %v.", have, conf.tasks-conf.large)
+	}
+	// Release all tasks and wait for them to finish
+	close(release)
+	pool.Terminate(false)
+	if have := pool.Pending(); have != 0 {
+		t.Fatalf("tasks left after termination: %v.", have)
+	}
+}
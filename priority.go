@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the prioritized sending of tunnel messages.
+//
+// Normal messages are sent in order, one chunk after the other. High priority
+// messages fitting into a single chunk are instead framed with a marker prefix
+// and squeezed in between the chunks of an in-flight normal message, letting the
+// remote side deliver them without discarding the partially assembled one.
+// Since the relay protocol has no notion of priorities, an older remote binding
+// takes a frame for a new message, discarding the interrupted one and
+// delivering the frame with its marker prefix.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Scheduling priority of an outbound tunnel message or an inbound request.
+type Priority int
+
+const (
+	PriorityNormal Priority = iota // Bulk data, sent in order
+	PriorityHigh                   // Control data, jumping ahead of bulk chunks
+)
+
+// Prefix identifying a high priority message frame.
+var tunnelPrioMagic = []byte("\x00iris-tunpri\x00")
+
+// Scheduler of the outbound chunks of a tunnel, serializing the chunks on the
+// wire while letting high priority frames go before the normal ones.
+type chunkGate struct {
+	busy bool // Flag whether a chunk is currently being sent
+	high int  // Number of high priority frames waiting to be sent
+
+	lock sync.Mutex // Protects the busy flag and the waiter count
+	cond *sync.Cond // Signals the release of the gate
+}
+
+// Creates a new, idle chunk gate.
+func newChunkGate() *chunkGate {
+	gate := new(chunkGate)
+	gate.cond = sync.NewCond(&gate.lock)
+	return gate
+}
+
+// Blocks until the gate can be entered. Normal priority entries wait until no
+// high priority ones are pending.
+func (g *chunkGate) acquire(priority Priority) {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	if priority == PriorityHigh {
+		g.high++
+		defer func() { g.high-- }()
+	}
+	for g.busy || (priority != PriorityHigh && g.high > 0) {
+		g.cond.Wait()
+	}
+	g.busy = true
+}
+
+// Leaves the gate, waking up any waiting senders.
+func (g *chunkGate) release() {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	g.busy = false
+	g.cond.Broadcast()
+}
+
+// Sends a message over the tunnel to the remote pair with the given priority,
+// blocking until the local Iris node receives the message or the operation
+// times out.
+//
+// High priority messages fitting into a single chunk are sent ahead of the
+// remaining chunks of any in-flight normal message. Larger ones are sent as
+// normal messages. Ordering is only guaranteed among messages of the same
+// priority.
+//
+// Infinite blocking is supported with by setting the timeout to zero (0).
+func (t *Tunnel) SendPriority(message []byte, priority Priority, timeout time.Duration) error {
+	t.Log.Debug("sending message", "data", logLazyBlob(message), "priority", priority, "timeout", logLazyTimeout(timeout))
+
+	// Create timeout signaler
+	var deadline <-chan time.Time
+	if timeout != 0 {
+		deadline = time.After(timeout)
+	}
+	if err := t.sendPriority(context.Background(), message, priority, deadline); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.stats.msgsOut, 1)
+	return nil
+}
+
+// Sends a message over the tunnel to the remote pair with the given priority,
+// blocking until the local Iris node receives the message or the context is
+// cancelled. See SendPriority for the scheduling details.
+func (t *Tunnel) SendPriorityCtx(ctx context.Context, message []byte, priority Priority) error {
+	t.Log.Debug("sending message", "data", logLazyBlob(message), "priority", priority)
+	if err := t.sendPriority(ctx, message, priority, nil); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.stats.msgsOut, 1)
+	return nil
+}
+
+// Sends a high priority message as a single framed chunk if it fits, falling
+// back to the ordered sending otherwise.
+func (t *Tunnel) sendPriority(ctx context.Context, message []byte, priority Priority, deadline <-chan time.Time) error {
+	if len(message) > 0 {
+		var err error
+		if message, err = t.conn.encodePayload(TraceTunnel, message); err != nil {
+			return err
+		}
+	}
+	// Compression adds at most a flag byte to the message, encryption the cipher
+	// overhead
+	if priority != PriorityHigh || len(tunnelPrioMagic)+1+sealOverhead+len(message) > t.chunkLimit {
+		return t.send(ctx, message, deadline)
+	}
+	// Sanity check on the arguments
+	if message == nil || len(message) == 0 {
+		return invalidArgument("nil or empty message")
+	}
+	if atomic.LoadInt32(&t.atoiEOF) == 1 {
+		return ErrClosed
+	}
+	if t.writeDl.expired() {
+		return ErrTimeout
+	}
+	atomic.AddInt32(&t.sending, 1)
+	defer atomic.AddInt32(&t.sending, -1)
+
+	// Jump ahead of the normal chunks and send the framed message
+	t.sendGate.acquire(PriorityHigh)
+	defer t.sendGate.release()
+
+	message, err := t.compressMessage(message)
+	if err != nil {
+		return err
+	}
+	if message, err = t.sealMessage(message); err != nil {
+		return err
+	}
+	frame := append(append(make([]byte, 0, len(tunnelPrioMagic)+len(message)), tunnelPrioMagic...), message...)
+	return t.sendChunk(ctx, frame, len(frame), deadline)
+}
+
+// Splits a high priority frame into its message, if it is one.
+func unwrapPriority(chunk []byte) ([]byte, bool) {
+	if len(chunk) <= len(tunnelPrioMagic) || !bytes.HasPrefix(chunk, tunnelPrioMagic) {
+		return nil, false
+	}
+	return chunk[len(tunnelPrioMagic):], true
+}
@@ -0,0 +1,165 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the health probes of the services, answered by the binding itself so
+// that monitoring systems can probe every service uniformly.
+//
+// Since the relay protocol has no notion of request types, a probe is a request
+// consisting of a magic payload, answered with a JSON report before reaching the
+// request handler or its queue. Older bindings pass it to the request handler
+// instead, whose reply then fails the probe as a malformed report.
+
+package iris
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Magic payload of the health probe requests.
+var healthMagic = []byte("\x00iris-health\x00")
+
+// Health report of a service instance, as answered to a health probe.
+type ServiceHealth struct {
+	Cluster string    `json:"cluster"`          // Cluster the instance is a member of
+	Node    string    `json:"node"`             // Identifier of the instance's connection
+	Live    bool      `json:"live"`             // Whether the instance is alive (always, if it answered)
+	Ready   bool      `json:"ready"`            // Whether the instance is ready to serve requests
+	Reason  string    `json:"reason,omitempty"` // Reason the instance is not ready, if so
+	Link    string    `json:"link"`             // Health state of the instance's relay link
+	Started time.Time `json:"started"`          // Time instance the connection was established at
+	Build   BuildInfo `json:"build"`            // Build details of the instance's binary
+
+	RequestQueue   int `json:"request_queue"`   // Inbound requests waiting for a handler
+	RequestActive  int `json:"request_active"`  // Inbound requests being handled
+	BroadcastQueue int `json:"broadcast_queue"` // Inbound broadcasts waiting for a handler
+}
+
+// Build details of a service binary, as embedded by the Go toolchain.
+type BuildInfo struct {
+	Module    string `json:"module,omitempty"`     // Path of the main module
+	Version   string `json:"version,omitempty"`    // Version of the main module
+	Revision  string `json:"revision,omitempty"`   // Version control revision the binary was built from
+	GoVersion string `json:"go_version,omitempty"` // Version of the Go toolchain
+}
+
+// Optional extension of ServiceHandler, reporting application level readiness
+// (e.g. warmed up caches, reachable databases) to the health probes.
+type ReadinessHandler interface {
+	// Callback invoked on each health probe, returning the reason the service is
+	// not ready to serve requests, or nil if it is.
+	Ready() error
+}
+
+// Build details of the running binary, loaded once on the first probe.
+var (
+	buildInfo     BuildInfo
+	buildInfoOnce sync.Once
+)
+
+// Enables or disables answering the health probes of monitoring systems (see
+// ProbeHealth) on behalf of the service. Services answer them by default; if
+// disabled, probes are delivered to the request handler as plain requests.
+func (c *Connection) SetHealthProbe(enabled bool) {
+	if enabled {
+		atomic.StoreInt32(&c.noProbe, 0)
+	} else {
+		atomic.StoreInt32(&c.noProbe, 1)
+	}
+}
+
+// Probes the health of a member of a remote cluster, returning its report. The
+// liveness, readiness, build and queue details are answered by the binding of
+// the probed service, without involving its request handler.
+//
+// The timeout unit is in milliseconds. Anything lower will fail with an error.
+func (c *Connection) ProbeHealth(cluster string, timeout time.Duration) (*ServiceHealth, error) {
+	reply, err := c.Request(cluster, append([]byte(nil), healthMagic...), timeout)
+	if err != nil {
+		return nil, err
+	}
+	report := new(ServiceHealth)
+	if err := json.Unmarshal(reply, report); err != nil {
+		return nil, fmt.Errorf("%w: malformed health report: %v", ErrProtocolViolation, err)
+	}
+	return report, nil
+}
+
+// Answers an inbound request on the service's behalf if it is a health probe,
+// returning whether it was one.
+func (c *Connection) answerProbe(id uint64, request []byte, logger Logger) bool {
+	if atomic.LoadInt32(&c.noProbe) == 1 || !bytes.Equal(request, healthMagic) {
+		return false
+	}
+	go func() {
+		report, err := json.Marshal(c.healthReport())
+		if err != nil {
+			logger.Error("failed to encode health report", "reason", err)
+			return
+		}
+		logger.Debug("answering health probe")
+		if err := c.sendReply(id, report, ""); err != nil {
+			logger.Error("failed to send health report", "reason", err)
+		}
+	}()
+	return true
+}
+
+// Assembles the health report of the service.
+func (c *Connection) healthReport() *ServiceHealth {
+	buildInfoOnce.Do(loadBuildInfo)
+
+	report := &ServiceHealth{
+		Cluster:        c.cluster,
+		Node:           c.envId,
+		Live:           true,
+		Ready:          true,
+		Link:           c.Health().String(),
+		Started:        c.started,
+		Build:          buildInfo,
+		RequestQueue:   c.reqPool.Pending(),
+		RequestActive:  int(atomic.LoadInt32(&c.stats.reqActive)),
+		BroadcastQueue: c.bcastPool.Pending(),
+	}
+	// Check the readiness conditions, the binding's ones first
+	switch {
+	case atomic.LoadInt32(&c.draining) == 1:
+		report.Reason = ErrDraining.Error()
+	case c.Health() != HealthConnected:
+		report.Reason = "relay link " + report.Link
+	case c.limits.RequestQueue > 0 && report.RequestQueue >= c.limits.RequestQueue:
+		report.Reason = "request queue full"
+	default:
+		if handler, ok := c.handler.(ReadinessHandler); ok {
+			if err := handler.Ready(); err != nil {
+				report.Reason = err.Error()
+			}
+		}
+	}
+	report.Ready = report.Reason == ""
+	return report
+}
+
+// Loads the build details of the running binary.
+func loadBuildInfo() {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return
+	}
+	buildInfo.Module = info.Main.Path
+	buildInfo.Version = info.Main.Version
+	buildInfo.GoVersion = info.GoVersion
+	for _, setting := range info.Settings {
+		if setting.Key == "vcs.revision" {
+			buildInfo.Revision = setting.Value
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the health probe tests, echoing requests and reporting a
+// configurable readiness.
+type probeTestHandler struct {
+	conn    *Connection
+	unready int32
+}
+
+func (p *probeTestHandler) Init(conn *Connection) error              { p.conn = conn; return nil }
+func (p *probeTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (p *probeTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (p *probeTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (p *probeTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (p *probeTestHandler) Ready() error {
+	if atomic.LoadInt32(&p.unready) == 1 {
+		return errors.New("warming up")
+	}
+	return nil
+}
+
+// Tests that health probes are answered by the binding, reporting the service
+// details and readiness, unless disabled.
+func TestHealthProbe(t *testing.T) {
+	// Register a new service and connect a prober
+	handler := new(probeTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Probe the ready service and verify the report
+	report, err := conn.ProbeHealth(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("failed to probe health: %v.", err)
+	}
+	if report.Cluster != config.cluster || report.Node != handler.conn.envId || !report.Live || !report.Ready || report.Reason != "" {
+		t.Fatalf("ready report mismatch: have %+v.", report)
+	}
+	if report.Link != HealthConnected.String() || report.Started.IsZero() || report.Build.GoVersion == "" {
+		t.Fatalf("report details mismatch: have %+v.", report)
+	}
+	// Make the service unready and verify the reason is reported
+	atomic.StoreInt32(&handler.unready, 1)
+	if report, err = conn.ProbeHealth(config.cluster, time.Second); err != nil {
+		t.Fatalf("failed to probe health: %v.", err)
+	}
+	if !report.Live || report.Ready || report.Reason != "warming up" {
+		t.Fatalf("unready report mismatch: have %+v.", report)
+	}
+	// Disable the probes and verify they reach the handler instead
+	handler.conn.SetHealthProbe(false)
+	if _, err := conn.ProbeHealth(config.cluster, time.Second); !errors.Is(err, ErrProtocolViolation) {
+		t.Fatalf("disabled probe error mismatch: have %v, want %v.", err, ErrProtocolViolation)
+	}
+}
@@ -51,16 +51,30 @@ func (c *Connection) sendByte(data byte) error {
 	return c.sockBuf.WriteByte(data)
 }
 
+// Serializes a frame opcode into the relay connection.
+func (c *Connection) sendOp(op byte) error {
+	c.startFrameOut(op)
+	return c.sendByte(op)
+}
+
 // Serializes a boolean into the relay connection.
 func (c *Connection) sendBool(data bool) error {
 	if data {
+		c.frameOut.add("bool", 1, nil)
 		return c.sendByte(1)
 	}
+	c.frameOut.add("bool", 0, nil)
 	return c.sendByte(0)
 }
 
 // Serializes a variable int using base 128 encoding into the relay connection.
 func (c *Connection) sendVarint(data uint64) error {
+	c.frameOut.add("varint", data, nil)
+	return c.writeVarint(data)
+}
+
+// Serializes a variable int into the relay connection without dumping it.
+func (c *Connection) writeVarint(data uint64) error {
 	for data > 127 {
 		// Internal byte, set the continuation flag and send
 		if err := c.sendByte(byte(128 + data%128)); err != nil {
@@ -74,7 +88,20 @@ func (c *Connection) sendVarint(data uint64) error {
 
 // Serializes a length-tagged binary array into the relay connection.
 func (c *Connection) sendBinary(data []byte) error {
-	if err := c.sendVarint(uint64(len(data))); err != nil {
+	c.frameOut.add("binary", uint64(len(data)), data)
+	return c.writeBinary(data)
+}
+
+// Serializes a length-tagged string into the relay connection.
+func (c *Connection) sendString(data string) error {
+	c.frameOut.add("string", uint64(len(data)), []byte(data))
+	return c.writeBinary([]byte(data))
+}
+
+// Serializes a length-tagged binary array into the relay connection without
+// dumping it.
+func (c *Connection) writeBinary(data []byte) error {
+	if err := c.writeVarint(uint64(len(data))); err != nil {
 		return err
 	}
 	if _, err := c.sockBuf.Write([]byte(data)); err != nil {
@@ -83,11 +110,6 @@ func (c *Connection) sendBinary(data []byte) error {
 	return nil
 }
 
-// Serializes a length-tagged string into the relay connection.
-func (c *Connection) sendString(data string) error {
-	return c.sendBinary([]byte(data))
-}
-
 // Serializes a packet through a closure into the relay connection.
 func (c *Connection) sendPacket(closure func() error) error {
 	// Increment the pending write count
@@ -97,15 +119,17 @@ func (c *Connection) sendPacket(closure func() error) error {
 	c.sockLock.Lock()
 	defer c.sockLock.Unlock()
 
-	// Send the packet itself
-	if err := closure(); err != nil {
+	// Send the packet itself, dumping its frames if enabled
+	err := closure()
+	c.flushFrameOut()
+	if err != nil {
 		// Decrement the pending count and error out
 		atomic.AddInt32(&c.sockWait, -1)
 		return err
 	}
 	// Flush the stream if no more messages are pending
 	if atomic.AddInt32(&c.sockWait, -1) == 0 {
-		return c.sockBuf.Flush()
+		return c.flushPacket()
 	}
 	return nil
 }
@@ -113,7 +137,7 @@ func (c *Connection) sendPacket(closure func() error) error {
 // Sends a connection initiation.
 func (c *Connection) sendInit(cluster string) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opInit); err != nil {
+		if err := c.sendOp(opInit); err != nil {
 			return err
 		}
 		if err := c.sendString(clientMagic); err != nil {
@@ -129,14 +153,18 @@ func (c *Connection) sendInit(cluster string) error {
 // Sends a connection tear-down initiation.
 func (c *Connection) sendClose() error {
 	return c.sendPacket(func() error {
-		return c.sendByte(opClose)
+		return c.sendOp(opClose)
 	})
 }
 
 // Sends an application broadcast initiation.
 func (c *Connection) sendBroadcast(cluster string, message []byte) error {
+	message, err := c.encodePayload(TraceBroadcast, message)
+	if err != nil {
+		return err
+	}
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opBroadcast); err != nil {
+		if err := c.sendOp(opBroadcast); err != nil {
 			return err
 		}
 		if err := c.sendString(cluster); err != nil {
@@ -148,8 +176,12 @@ func (c *Connection) sendBroadcast(cluster string, message []byte) error {
 
 // Sends an application request initiation.
 func (c *Connection) sendRequest(id uint64, cluster string, request []byte, timeout int) error {
+	request, err := c.encodePayload(TraceRequest, request)
+	if err != nil {
+		return err
+	}
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opRequest); err != nil {
+		if err := c.sendOp(opRequest); err != nil {
 			return err
 		}
 		if err := c.sendVarint(id); err != nil {
@@ -167,8 +199,14 @@ func (c *Connection) sendRequest(id uint64, cluster string, request []byte, time
 
 // Sends an application reply initiation.
 func (c *Connection) sendReply(id uint64, reply []byte, fault string) error {
+	if len(fault) == 0 {
+		var err error
+		if reply, err = c.encodePayload(TraceRequest, reply); err != nil {
+			return err
+		}
+	}
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opReply); err != nil {
+		if err := c.sendOp(opReply); err != nil {
 			return err
 		}
 		if err := c.sendVarint(id); err != nil {
@@ -189,7 +227,7 @@ func (c *Connection) sendReply(id uint64, reply []byte, fault string) error {
 // Sends a topic subscription.
 func (c *Connection) sendSubscribe(topic string) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opSubscribe); err != nil {
+		if err := c.sendOp(opSubscribe); err != nil {
 			return err
 		}
 		return c.sendString(topic)
@@ -199,7 +237,7 @@ func (c *Connection) sendSubscribe(topic string) error {
 // Sends a topic subscription removal.
 func (c *Connection) sendUnsubscribe(topic string) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opUnsubscribe); err != nil {
+		if err := c.sendOp(opUnsubscribe); err != nil {
 			return err
 		}
 		return c.sendString(topic)
@@ -208,8 +246,12 @@ func (c *Connection) sendUnsubscribe(topic string) error {
 
 // Sends a topic event publish.
 func (c *Connection) sendPublish(topic string, event []byte) error {
+	event, err := c.encodePayload(TracePublish, event)
+	if err != nil {
+		return err
+	}
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opPublish); err != nil {
+		if err := c.sendOp(opPublish); err != nil {
 			return err
 		}
 		if err := c.sendString(topic); err != nil {
@@ -219,10 +261,38 @@ func (c *Connection) sendPublish(topic string, event []byte) error {
 	})
 }
 
+// Sends a batch of topic event publishes, flushing the stream only once.
+func (c *Connection) sendPublishBatch(topics []string, events [][]byte) error {
+	if c.codec != nil {
+		encoded := make([][]byte, len(events))
+		for i, event := range events {
+			var err error
+			if encoded[i], err = c.encodePayload(TracePublish, event); err != nil {
+				return err
+			}
+		}
+		events = encoded
+	}
+	return c.sendPacket(func() error {
+		for i, topic := range topics {
+			if err := c.sendOp(opPublish); err != nil {
+				return err
+			}
+			if err := c.sendString(topic); err != nil {
+				return err
+			}
+			if err := c.sendBinary(events[i]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
 // Sends a tunnel construction request.
 func (c *Connection) sendTunnelInit(id uint64, cluster string, timeout int) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunInit); err != nil {
+		if err := c.sendOp(opTunInit); err != nil {
 			return err
 		}
 		if err := c.sendVarint(id); err != nil {
@@ -238,7 +308,7 @@ func (c *Connection) sendTunnelInit(id uint64, cluster string, timeout int) erro
 // Sends a tunnel confirmation.
 func (c *Connection) sendTunnelConfirm(buildId, tunId uint64) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunConfirm); err != nil {
+		if err := c.sendOp(opTunConfirm); err != nil {
 			return err
 		}
 		if err := c.sendVarint(buildId); err != nil {
@@ -251,7 +321,7 @@ func (c *Connection) sendTunnelConfirm(buildId, tunId uint64) error {
 // Sends a tunnel transfer allowance.
 func (c *Connection) sendTunnelAllowance(id uint64, space int) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunAllow); err != nil {
+		if err := c.sendOp(opTunAllow); err != nil {
 			return err
 		}
 		if err := c.sendVarint(id); err != nil {
@@ -264,7 +334,7 @@ func (c *Connection) sendTunnelAllowance(id uint64, space int) error {
 // Sends a tunnel data exchange.
 func (c *Connection) sendTunnelTransfer(id uint64, sizeOrCont int, payload []byte) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunTransfer); err != nil {
+		if err := c.sendOp(opTunTransfer); err != nil {
 			return err
 		}
 		if err := c.sendVarint(id); err != nil {
@@ -280,7 +350,7 @@ func (c *Connection) sendTunnelTransfer(id uint64, sizeOrCont int, payload []byt
 // Sends a tunnel termination request.
 func (c *Connection) sendTunnelClose(id uint64) error {
 	return c.sendPacket(func() error {
-		if err := c.sendByte(opTunClose); err != nil {
+		if err := c.sendOp(opTunClose); err != nil {
 			return err
 		}
 		return c.sendVarint(id)
@@ -298,18 +368,28 @@ func (c *Connection) recvBool() (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	c.frameIn.add("bool", uint64(b), nil)
 	switch b {
 	case 0:
 		return false, nil
 	case 1:
 		return true, nil
 	default:
-		return false, fmt.Errorf("protocol violation: invalid boolean value: %v", b)
+		return false, fmt.Errorf("%w: invalid boolean value: %v", ErrProtocolViolation, b)
 	}
 }
 
 // Retrieves a variable int in base 128 encoding from the relay connection.
 func (c *Connection) recvVarint() (uint64, error) {
+	num, err := c.readVarint()
+	if err == nil {
+		c.frameIn.add("varint", num, nil)
+	}
+	return num, err
+}
+
+// Retrieves a variable int from the relay connection without dumping it.
+func (c *Connection) readVarint() (uint64, error) {
 	var num uint64
 	for i := uint(0); ; i++ {
 		chunk, err := c.recvByte()
@@ -326,8 +406,18 @@ func (c *Connection) recvVarint() (uint64, error) {
 
 // Retrieves a length-tagged binary array from the relay connection.
 func (c *Connection) recvBinary() ([]byte, error) {
+	data, err := c.readBinary()
+	if err == nil {
+		c.frameIn.add("binary", uint64(len(data)), data)
+	}
+	return data, err
+}
+
+// Retrieves a length-tagged binary array from the relay connection without
+// dumping it.
+func (c *Connection) readBinary() ([]byte, error) {
 	// Fetch the length of the binary blob
-	size, err := c.recvVarint()
+	size, err := c.readVarint()
 	if err != nil {
 		return nil, err
 	}
@@ -341,9 +431,10 @@ func (c *Connection) recvBinary() ([]byte, error) {
 
 // Retrieves a length-tagged string from the relay connection.
 func (c *Connection) recvString() (string, error) {
-	if data, err := c.recvBinary(); err != nil {
+	if data, err := c.readBinary(); err != nil {
 		return "", err
 	} else {
+		c.frameIn.add("string", uint64(len(data)), data)
 		return string(data), nil
 	}
 }
@@ -355,16 +446,19 @@ func (c *Connection) procInit() (string, error) {
 	if err != nil {
 		return "", err
 	}
+	c.startFrameIn(op)
+	defer c.flushFrameIn()
+
 	// Verify the opcode validity and relay magic string
 	switch {
 	case op == opInit || op == opDeny:
 		if magic, err := c.recvString(); err != nil {
 			return "", err
 		} else if magic != relayMagic {
-			return "", fmt.Errorf("protocol violation: invalid relay magic: %s", magic)
+			return "", fmt.Errorf("%w: invalid relay magic: %s", ErrProtocolViolation, magic)
 		}
 	default:
-		return "", fmt.Errorf("protocol violation: invalid init response opcode: %v", op)
+		return "", fmt.Errorf("%w: invalid init response opcode: %v", ErrProtocolViolation, op)
 	}
 	// Depending on success or failure, proceed and return
 	switch op {
@@ -380,7 +474,7 @@ func (c *Connection) procInit() (string, error) {
 		if reason, err := c.recvString(); err != nil {
 			return "", err
 		} else {
-			return "", fmt.Errorf("connection denied: %s", reason)
+			return "", fmt.Errorf("%w: %s", ErrConnectionDenied, reason)
 		}
 	default:
 		panic("unreachable code")
@@ -398,6 +492,14 @@ func (c *Connection) procBroadcast() error {
 	if err != nil {
 		return err
 	}
+	if c.injectDrop() {
+		return nil
+	}
+	if message, err = c.decodePayload(TraceBroadcast, message); err != nil {
+		c.Log.Error("dropping undecodable broadcast", "reason", err)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		return nil
+	}
 	c.handleBroadcast(message)
 	return nil
 }
@@ -416,6 +518,15 @@ func (c *Connection) procRequest() error {
 	if err != nil {
 		return err
 	}
+	if c.injectDrop() {
+		return nil
+	}
+	if request, err = c.decodePayload(TraceRequest, request); err != nil {
+		c.Log.Error("rejecting undecodable request", "remote_request", id, "reason", err)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		go c.sendReply(id, nil, encodeFault(&Error{Code: CodeInvalidArgument, Message: err.Error()}))
+		return nil
+	}
 	c.handleRequest(id, request, time.Duration(timeout)*time.Millisecond)
 	return nil
 }
@@ -439,19 +550,34 @@ func (c *Connection) procReply() error {
 	if err != nil {
 		return err
 	}
+	var (
+		reply []byte
+		fault string
+	)
 	if success {
-		reply, err := c.recvBinary()
-		if err != nil {
+		if reply, err = c.recvBinary(); err != nil {
 			return err
 		}
-		c.handleReply(id, reply, "")
 	} else {
-		fault, err := c.recvString()
-		if err != nil {
+		if fault, err = c.recvString(); err != nil {
 			return err
 		}
-		c.handleReply(id, nil, fault)
 	}
+	// Injected drops surface as relay timeouts, the requester having no timer
+	if c.injectDrop() {
+		reply, fault = nil, ""
+	}
+	if reply != nil {
+		if reply, err = c.decodePayload(TraceRequest, reply); err != nil {
+			c.Log.Error("failing undecodable reply", "local_request", id, "reason", err)
+			c.resolveReply(id, nil, err)
+			return nil
+		}
+		if reply == nil {
+			reply = []byte{} // Nil replies denote timeouts
+		}
+	}
+	c.handleReply(id, reply, fault)
 	return nil
 }
 
@@ -465,7 +591,15 @@ func (c *Connection) procPublish() error {
 	if err != nil {
 		return err
 	}
-	go c.handlePublish(topic, event)
+	if c.injectDrop() {
+		return nil
+	}
+	if event, err = c.decodePayload(TracePublish, event); err != nil {
+		c.Log.Error("dropping undecodable event", "topic", topic, "reason", err)
+		atomic.AddUint64(&c.stats.dropped, 1)
+		return nil
+	}
+	c.dispatchPublish(topic, event)
 	return nil
 }
 
@@ -534,6 +668,10 @@ func (c *Connection) procTunnelTransfer() error {
 	if err != nil {
 		return err
 	}
+	payload, ok := c.injectChunk(id, payload)
+	if !ok {
+		return nil
+	}
 	c.handleTunnelTransfer(id, int(size), payload)
 	return nil
 }
@@ -553,13 +691,49 @@ func (c *Connection) procTunnelClose() error {
 }
 
 // Retrieves messages from the client connection and keeps processing them until
-// either the relay closes (graceful close) or the connection drops.
+// either the relay closes (graceful close) or the connection drops. Dropped links
+// are restored if automatic reconnection is enabled.
 func (c *Connection) process() {
+	var err error
+	for {
+		// Process the inbound messages until the link goes down
+		err = c.processLink()
+		c.sock.Close()
+
+		// Restore the link if it dropped and reconnection is enabled
+		if err == nil {
+			break
+		}
+		restored, aborted := c.reconnect(err)
+		if restored {
+			continue
+		}
+		if aborted {
+			// Tear-down requested while reconnecting, not a failure
+			err = nil
+		}
+		break
+	}
+	// Signal termination to all blocked threads
+	close(c.term)
+
+	// Notify the application of the connection closure
+	c.handleClose(err)
+
+	// Wait for termination sync
+	errc := <-c.quit
+	errc <- err
+}
+
+// Retrieves messages from the current relay link until either the relay closes
+// it gracefully (nil error) or a failure occurs.
+func (c *Connection) processLink() error {
 	var op byte
 	var err error
 	for closed := false; !closed && err == nil; {
 		// Retrieve the next opcode and call the specific handler for the rest
 		if op, err = c.recvByte(); err == nil {
+			c.startFrameIn(op)
 			switch op {
 			case opBroadcast:
 				err = c.procBroadcast()
@@ -584,23 +758,15 @@ func (c *Connection) process() {
 				if reason, cerr := c.procClose(); cerr != nil {
 					err = cerr
 				} else if len(reason) > 0 {
-					err = fmt.Errorf("connection dropped: %s", reason)
+					err = fmt.Errorf("%w: %s", ErrConnectionDropped, reason)
 				} else {
 					closed = true
 				}
 			default:
-				err = fmt.Errorf("protocol violation: unknown opcode: %v", op)
+				err = fmt.Errorf("%w: unknown opcode: %v", ErrProtocolViolation, op)
 			}
+			c.flushFrameIn()
 		}
 	}
-	// Close the socket and signal termination to all blocked threads
-	c.sock.Close()
-	close(c.term)
-
-	// Notify the application of the connection closure
-	c.handleClose(err)
-
-	// Wait for termination sync
-	errc := <-c.quit
-	errc <- err
+	return err
 }
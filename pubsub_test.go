@@ -7,8 +7,11 @@
 package iris
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
+	"runtime"
+	"sort"
 	"sync"
 	"testing"
 	"time"
@@ -335,6 +338,266 @@ func TestPublishMemoryLimit(t *testing.T) {
 	}
 }
 
+// Tests that a flood of events overflowing a blocking subscription holds back
+// that topic only, without parking unbounded deliveries or stalling the rest of
+// the connection's traffic, and that the admitted events retain their order.
+func TestPublishOverflowBlockFlood(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		events  int
+		queue   int
+		backlog int
+	}{2000, 4, 64}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe with a stalled handler, a short queue and a blocking policy
+	handler := &publishOverflowTestTopicHandler{
+		delivers: make(chan []byte, conf.events),
+		gate:     make(chan struct{}),
+	}
+	release := func() {
+		select {
+		case <-handler.gate:
+		default:
+			close(handler.gate)
+		}
+	}
+	defer release() // Don't hang the close if failing midway
+
+	limits := &TopicLimits{EventThreads: 1, EventQueue: conf.queue, Overflow: OverflowBlock, BlockBacklog: conf.backlog}
+	if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	other := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conn.Subscribe(config.topic+"-other", other, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Flood the subscription and verify the deliveries don't pile up
+	publisher, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer publisher.Close()
+
+	routines := runtime.NumGoroutine()
+	for i := 0; i < conf.events; i++ {
+		if err := publisher.Publish(config.topic, []byte{byte(i >> 8), byte(i)}); err != nil {
+			t.Fatalf("event %d publish failed: %v.", i, err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+	if have := runtime.NumGoroutine(); have > routines+conf.events/10 {
+		t.Fatalf("held back deliveries piled up: have %d go-routines, had %d.", have, routines)
+	}
+	conn.subLock.RLock()
+	top := conn.subLive[config.topic]
+	conn.subLock.RUnlock()
+
+	if pending := top.pending(); pending > conf.queue {
+		t.Fatalf("pending events mismatch: have %d, want at most %d.", pending, conf.queue)
+	}
+	// Verify that other topics are still delivered while the blocking one is full
+	if err := publisher.Publish(config.topic+"-other", []byte{0x42}); err != nil {
+		t.Fatalf("other publish failed: %v.", err)
+	}
+	select {
+	case <-other.delivers:
+	case <-time.After(time.Second):
+		t.Fatalf("other topic stalled by the blocking subscription.")
+	}
+	// Release the handler and verify the admitted events arrive in order
+	release()
+
+	delivered, last := 0, -1
+	for done := false; !done; {
+		select {
+		case event := <-handler.delivers:
+			idx := int(event[0])<<8 | int(event[1])
+			if idx <= last {
+				t.Fatalf("event %d delivered after %d.", idx, last)
+			}
+			delivered, last = delivered+1, idx
+		case <-time.After(250 * time.Millisecond):
+			done = true
+		}
+	}
+	if limit := 2 + conf.queue + conf.backlog; delivered < conf.queue || delivered > limit {
+		t.Fatalf("delivered event count mismatch: have %d, want %d-%d.", delivered, conf.queue, limit)
+	}
+	if dropped := conn.Metrics().MessagesDropped; dropped != uint64(conf.events-delivered) {
+		t.Fatalf("dropped event count mismatch: have %d, want %d.", dropped, conf.events-delivered)
+	}
+}
+
+// Tests that a blocking subscription drops the events held back for longer than
+// the block timeout.
+func TestPublishOverflowBlockTimeout(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		events  int
+		timeout time.Duration
+	}{4, 200 * time.Millisecond}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe with a stalled handler and room for a single pending event
+	handler := &publishOverflowTestTopicHandler{
+		delivers: make(chan []byte, conf.events),
+		gate:     make(chan struct{}),
+	}
+	limits := &TopicLimits{EventThreads: 1, EventQueue: 1, Overflow: OverflowBlock, BlockTimeout: conf.timeout}
+	if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Stall the handler with the first event, queue the second and hold back the rest
+	for i := 0; i < conf.events; i++ {
+		if err := conn.Publish(config.topic, []byte{byte(i)}); err != nil {
+			t.Fatalf("event %d publish failed: %v.", i, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(2 * conf.timeout)
+	close(handler.gate)
+	time.Sleep(100 * time.Millisecond)
+
+	// Verify that only the running and the queued events were delivered
+	delivers := []byte{}
+	for done := false; !done; {
+		select {
+		case event := <-handler.delivers:
+			delivers = append(delivers, event...)
+		default:
+			done = true
+		}
+	}
+	if !bytes.Equal(delivers, []byte{0, 1}) {
+		t.Fatalf("delivery mismatch: have %v, want %v.", delivers, []byte{0, 1})
+	}
+	if dropped := conn.Metrics().MessagesDropped; dropped != uint64(conf.events-2) {
+		t.Fatalf("dropped event count mismatch: have %d, want %d.", dropped, conf.events-2)
+	}
+}
+
+// Topic handler for the overflow tests, stalling until a gate opens.
+type publishOverflowTestTopicHandler struct {
+	delivers chan []byte
+	gate     chan struct{}
+}
+
+func (p *publishOverflowTestTopicHandler) HandleEvent(event []byte) {
+	p.delivers <- event
+	<-p.gate
+}
+
+// Tests the subscription queue overflow policies.
+func TestPublishOverflow(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		messages int
+		queue    int
+		sleep    time.Duration
+	}{5, 2, 50 * time.Millisecond}
+
+	tests := []struct {
+		policy   OverflowPolicy
+		delivers []byte
+		overflow []byte
+	}{
+		{OverflowDropNewest, []byte{0, 1, 2}, nil},
+		{OverflowDropOldest, []byte{0, 3, 4}, nil},
+		{OverflowBlock, []byte{0, 1, 2, 3, 4}, nil},
+		{OverflowCallback, []byte{0, 1, 2}, []byte{3, 4}},
+	}
+	for i, tt := range tests {
+		// Connect to the local relay
+		conn, err := Connect(config.relay)
+		if err != nil {
+			t.Fatalf("test %d: connection failed: %v", i, err)
+		}
+		// Subscribe to a topic with a single thread and a short queue
+		handler := &publishOverflowTestTopicHandler{
+			delivers: make(chan []byte, conf.messages),
+			gate:     make(chan struct{}),
+		}
+		var lock sync.Mutex
+		var overflow []byte
+
+		limits := &TopicLimits{
+			EventThreads: 1,
+			EventQueue:   conf.queue,
+			Overflow:     tt.policy,
+		}
+		if tt.policy == OverflowCallback {
+			limits.OnOverflow = func(topic string, event []byte) {
+				lock.Lock()
+				defer lock.Unlock()
+				overflow = append(overflow, event...)
+			}
+		}
+		if err := conn.Subscribe(config.topic, handler, limits); err != nil {
+			t.Fatalf("test %d: subscription failed: %v", i, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		// Stall the handler with the first event, then overflow the queue
+		for j := 0; j < conf.messages; j++ {
+			if err := conn.Publish(config.topic, []byte{byte(j)}); err != nil {
+				t.Fatalf("test %d: event publish failed: %v.", i, err)
+			}
+			time.Sleep(conf.sleep / 5)
+		}
+		time.Sleep(conf.sleep)
+		close(handler.gate)
+		time.Sleep(conf.sleep)
+
+		// Verify the delivered and overflown events (blocked ones wake up unordered)
+		delivers := []byte{}
+		for done := false; !done; {
+			select {
+			case event := <-handler.delivers:
+				delivers = append(delivers, event...)
+			default:
+				done = true
+			}
+		}
+		sort.Slice(delivers, func(x, y int) bool { return delivers[x] < delivers[y] })
+		if !bytes.Equal(delivers, tt.delivers) {
+			t.Errorf("test %d: delivery mismatch: have %v, want %v", i, delivers, tt.delivers)
+		}
+		lock.Lock()
+		if !bytes.Equal(overflow, tt.overflow) {
+			t.Errorf("test %d: overflow mismatch: have %v, want %v", i, overflow, tt.overflow)
+		}
+		lock.Unlock()
+
+		conn.Unsubscribe(config.topic)
+		conn.Close()
+	}
+	// Make sure the callback policy cannot be used without a callback
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v", err)
+	}
+	defer conn.Close()
+
+	if err := conn.Subscribe(config.topic, &publishTestTopicHandler{}, &TopicLimits{Overflow: OverflowCallback}); err == nil {
+		t.Fatalf("subscription succeeded without overflow callback")
+	}
+}
+
 // Benchmarks the latency of a single publish operation.
 func BenchmarkPublishLatency(b *testing.B) {
 	// Connect to the local relay
@@ -437,3 +700,359 @@ func benchmarkPublishThroughput(threads int, b *testing.B) {
 	// Stop the timer (don't measure deferred cleanup)
 	b.StopTimer()
 }
+
+// Topic handler for the envelope tests, collecting the events with metadata.
+type publishMetaTestTopicHandler struct {
+	delivers chan Event
+}
+
+func (p *publishMetaTestTopicHandler) HandleEvent(event []byte) { panic("not implemented") }
+func (p *publishMetaTestTopicHandler) HandleEventMeta(event Event) {
+	p.delivers <- event
+}
+
+// Tests that enveloped events carry the publisher metadata to aware handlers,
+// while plain handlers only see the payloads.
+func TestPublishEnvelope(t *testing.T) {
+	// Register a publishing service and subscribe to the topic twice
+	serv, err := Register(config.relay, config.cluster, new(publishTestServiceHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	meta := &publishMetaTestTopicHandler{delivers: make(chan Event, 2)}
+	if err := conn.Subscribe(config.topic, meta, nil); err != nil {
+		t.Fatalf("meta subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+
+	plain := &publishTestTopicHandler{delivers: make(chan []byte, 2)}
+	if err := conn.Subscribe(config.topic+".plain", plain, nil); err != nil {
+		t.Fatalf("plain subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic + ".plain")
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish an explicit envelope, then a plain event and verify the metadata
+	start := time.Now()
+	if err := serv.conn.PublishEnvelope(config.topic, []byte("first"), "text/plain"); err != nil {
+		t.Fatalf("envelope publish failed: %v.", err)
+	}
+	var first Event
+	select {
+	case first = <-meta.delivers:
+	case <-time.After(time.Second):
+		t.Fatalf("enveloped event not received.")
+	}
+	if string(first.Payload) != "first" || first.Topic != config.topic {
+		t.Fatalf("event mismatch: have %s/%s, want %s/%s.", first.Topic, first.Payload, config.topic, "first")
+	}
+	if first.Cluster != config.cluster || first.Publisher == "" || first.ContentType != "text/plain" {
+		t.Fatalf("publisher metadata mismatch: have %+v.", first)
+	}
+	if first.Published.Before(start) || time.Since(first.Published) > time.Second {
+		t.Fatalf("publish time mismatch: have %v, want after %v.", first.Published, start)
+	}
+	if err := serv.conn.Publish(config.topic, []byte("second")); err != nil {
+		t.Fatalf("plain publish failed: %v.", err)
+	}
+	select {
+	case event := <-meta.delivers:
+		if string(event.Payload) != "second" || !event.Published.IsZero() || event.Publisher != "" {
+			t.Fatalf("plain event mismatch: have %+v.", event)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("plain event not received.")
+	}
+	// Enable envelopes for all publishes and verify sequencing and unwrapping
+	serv.conn.SetEnvelopePublish(true)
+	if err := serv.conn.Publish(config.topic, []byte("third")); err != nil {
+		t.Fatalf("enveloped publish failed: %v.", err)
+	}
+	select {
+	case event := <-meta.delivers:
+		if event.Publisher != first.Publisher || event.Sequence != first.Sequence+1 {
+			t.Fatalf("sequencing mismatch: have %s/%d, want %s/%d.", event.Publisher, event.Sequence, first.Publisher, first.Sequence+1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("enveloped event not received.")
+	}
+	if err := serv.conn.Publish(config.topic+".plain", []byte("fourth")); err != nil {
+		t.Fatalf("enveloped publish failed: %v.", err)
+	}
+	select {
+	case event := <-plain.delivers:
+		if string(event) != "fourth" {
+			t.Fatalf("unwrapped payload mismatch: have %s, want %s.", event, "fourth")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("enveloped event not received.")
+	}
+}
+
+// Tests that retained events are replayed to late subscribers requesting them,
+// and only to them.
+func TestPublishRetained(t *testing.T) {
+	// Connect a retaining publisher and a few late subscribers
+	pub, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("publisher connection failed: %v.", err)
+	}
+	defer pub.Close()
+
+	conns := make([]*Connection, 3)
+	for i := 0; i < len(conns); i++ {
+		if conns[i], err = Connect(config.relay); err != nil {
+			t.Fatalf("subscriber #%d connection failed: %v.", i, err)
+		}
+		defer conns[i].Close()
+	}
+	// Publish a few retained events, only the last should be replayed
+	for _, event := range []string{"stale", "fresh"} {
+		if err := pub.PublishRetained(config.topic, []byte(event)); err != nil {
+			t.Fatalf("retained publish failed: %v.", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Subscribe without replay, then with replay, and verify the deliveries
+	plain := &publishTestTopicHandler{delivers: make(chan []byte, 2)}
+	if err := conns[0].Subscribe(config.topic, plain, nil); err != nil {
+		t.Fatalf("plain subscription failed: %v.", err)
+	}
+	replay := &publishTestTopicHandler{delivers: make(chan []byte, 2)}
+	if err := conns[1].Subscribe(config.topic, replay, &TopicLimits{Replay: true}); err != nil {
+		t.Fatalf("replay subscription failed: %v.", err)
+	}
+	select {
+	case event := <-replay.delivers:
+		if string(event) != "fresh" {
+			t.Fatalf("replayed event mismatch: have %s, want %s.", event, "fresh")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("retained event not replayed.")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(plain.delivers) != 0 || len(replay.delivers) != 0 {
+		t.Fatalf("extra deliveries: plain %v, replay %v.", len(plain.delivers), len(replay.delivers))
+	}
+	// Clear the retention and verify that new subscribers get nothing
+	if err := pub.ClearRetained(config.topic); err != nil {
+		t.Fatalf("failed to clear retained event: %v.", err)
+	}
+	if err := pub.ClearRetained(config.topic); err == nil {
+		t.Fatalf("double clear succeeded.")
+	}
+	late := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conns[2].Subscribe(config.topic, late, &TopicLimits{Replay: true}); err != nil {
+		t.Fatalf("late subscription failed: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if len(late.delivers) != 0 {
+		t.Fatalf("cleared event replayed.")
+	}
+	// Patterns cannot request replays
+	if err := conns[2].Subscribe(config.topic+".*", late, &TopicLimits{Replay: true}); err == nil {
+		t.Fatalf("pattern replay subscription succeeded.")
+	}
+}
+
+// Tests that delayed publishes are delivered when due, unless cancelled.
+func TestPublishAfter(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		delay time.Duration
+	}{100 * time.Millisecond}
+
+	// Connect to the local relay and subscribe to the test topic
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, 2)}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Schedule a pair of publishes and cancel the second one
+	start := time.Now()
+	kept, err := conn.PublishAfter(config.topic, []byte{0x00}, conf.delay)
+	if err != nil {
+		t.Fatalf("delayed publish failed: %v.", err)
+	}
+	dropped, err := conn.PublishAfter(config.topic, []byte{0x01}, conf.delay)
+	if err != nil {
+		t.Fatalf("delayed publish failed: %v.", err)
+	}
+	if !dropped.Cancel() {
+		t.Fatalf("pending publish not cancelled.")
+	}
+	// Verify that only the kept event arrives, and only when due
+	select {
+	case event := <-handler.delivers:
+		if elapsed := time.Since(start); elapsed < conf.delay {
+			t.Fatalf("delayed event arrived early: have %v, want at least %v.", elapsed, conf.delay)
+		}
+		if event[0] != 0x00 {
+			t.Fatalf("delayed event mismatch: have %v, want %v.", event, []byte{0x00})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("delayed event not delivered.")
+	}
+	select {
+	case event := <-handler.delivers:
+		t.Fatalf("cancelled event delivered: %v.", event)
+	case <-time.After(2 * conf.delay):
+	}
+	if kept.Cancel() {
+		t.Fatalf("executed publish cancelled.")
+	}
+}
+
+// Tests that expiring events are discarded if they time out while queued.
+func TestPublishTTL(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		ttl time.Duration
+	}{50 * time.Millisecond}
+
+	// Connect to the local relay and subscribe to the test topic
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, 3)}
+	if err := conn.Subscribe(config.topic, handler, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	if err := conn.PublishTTL(config.topic, []byte{0x00}, -time.Second); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("negative ttl mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	// Hold back a short and a long lived event until the first expires
+	if err := conn.PauseSubscription(config.topic); err != nil {
+		t.Fatalf("pause failed: %v.", err)
+	}
+	if err := conn.PublishTTL(config.topic, []byte{0x01}, conf.ttl); err != nil {
+		t.Fatalf("short lived publish failed: %v.", err)
+	}
+	if err := conn.PublishTTL(config.topic, []byte{0x02}, time.Minute); err != nil {
+		t.Fatalf("long lived publish failed: %v.", err)
+	}
+	time.Sleep(2 * conf.ttl)
+
+	dropped := conn.Metrics().MessagesDropped
+	if err := conn.ResumeSubscription(config.topic); err != nil {
+		t.Fatalf("resume failed: %v.", err)
+	}
+	// Verify that only the expired event is discarded
+	select {
+	case event := <-handler.delivers:
+		if event[0] != 0x02 {
+			t.Fatalf("event mismatch: have %v, want %v.", event, []byte{0x02})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("long lived event not delivered.")
+	}
+	select {
+	case event := <-handler.delivers:
+		t.Fatalf("expired event delivered: %v.", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+	if have := conn.Metrics().MessagesDropped - dropped; have != 1 {
+		t.Fatalf("dropped count mismatch: have %d, want %d.", have, 1)
+	}
+}
+
+// Tests that paused subscriptions hold back and later deliver their events.
+func TestSubscriptionPause(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		events int
+		queue  int
+	}{8, 4}
+
+	// Connect to the local relay and subscribe to the test topic
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	handler := &publishTestTopicHandler{delivers: make(chan []byte, conf.events)}
+	if err := conn.Subscribe(config.topic, handler, &TopicLimits{EventThreads: 1, EventQueue: conf.queue}); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer conn.Unsubscribe(config.topic)
+	time.Sleep(100 * time.Millisecond)
+
+	// Check the argument failures
+	if err := conn.PauseSubscription(""); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("empty topic pause mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+	if err := conn.PauseSubscription(config.topic + "-unknown"); err != ErrNotSubscribed {
+		t.Fatalf("unknown topic pause mismatch: have %v, want %v.", err, ErrNotSubscribed)
+	}
+	// Pause the subscription and publish more events than the queue allowance
+	if err := conn.PauseSubscription(config.topic); err != nil {
+		t.Fatalf("pause failed: %v.", err)
+	}
+	for i := 0; i < conf.events; i++ {
+		if err := conn.Publish(config.topic, []byte{byte(i)}); err != nil {
+			t.Fatalf("publish failed: %v.", err)
+		}
+	}
+	select {
+	case event := <-handler.delivers:
+		t.Fatalf("event delivered while paused: %v.", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Resume and verify that the queued events arrive
+	if err := conn.ResumeSubscription(config.topic); err != nil {
+		t.Fatalf("resume failed: %v.", err)
+	}
+	seen := make(map[byte]bool)
+	for i := 0; i < conf.queue; i++ {
+		select {
+		case event := <-handler.delivers:
+			if seen[event[0]] {
+				t.Fatalf("event #%d duplicated: %v.", i, event)
+			}
+			seen[event[0]] = true
+		case <-time.After(time.Second):
+			t.Fatalf("queued event #%d not delivered.", i)
+		}
+	}
+	select {
+	case event := <-handler.delivers:
+		t.Fatalf("overflowed event delivered: %v.", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+	// Verify that live delivery continues after resuming
+	if err := conn.Publish(config.topic, []byte{0xff}); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case event := <-handler.delivers:
+		if event[0] != 0xff {
+			t.Fatalf("live event mismatch: have %v, want %v.", event, []byte{0xff})
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("live event not delivered.")
+	}
+}
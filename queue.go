@@ -0,0 +1,227 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pluggable queues buffering the pending handler tasks, events and
+// inbound tunnel messages, along with a ring buffer implementation avoiding the
+// per-item allocations of the default linked queue at high message rates, and
+// the priority queue serving urgent requests ahead of bulk ones.
+
+package iris
+
+import "github.com/project-iris/iris/container/queue"
+
+// Initial capacity of a ring queue if none was requested.
+const defaultRingCapacity = 16
+
+// FIFO buffer of pending messages or tasks. Implementations need not be thread
+// safe, the binding guards each queue with its own lock.
+type Queue interface {
+	Push(item interface{}) bool // Appends an item, reporting false if the queue is full
+	Pop() interface{}           // Removes and returns the oldest item (nil if empty)
+	Front() interface{}         // Returns the oldest item without removing it (nil if empty)
+	Size() int                  // Returns the number of queued items
+	Empty() bool                // Checks whether the queue has no items
+	Reset()                     // Removes all the queued items
+}
+
+// Constructor of the queues used by a service, subscription or tunnel.
+type QueueFactory func() Queue
+
+// Creates a new queue through the user factory, or the default linked one.
+func newQueue(factory QueueFactory) Queue {
+	if factory == nil {
+		return linkedQueue{queue.New()}
+	}
+	return factory()
+}
+
+// Unbounded linked queue, allocating a block per few items. The default.
+type linkedQueue struct {
+	*queue.Queue
+}
+
+// Appends an item to the queue, which is never full.
+func (q linkedQueue) Push(item interface{}) bool {
+	q.Queue.Push(item)
+	return true
+}
+
+// Queue backed by a circular buffer, growing by doubling when full (up to the
+// limit, if any) and shrinking back towards its initial capacity when drained.
+type ringQueue struct {
+	items []interface{} // Circular buffer of the queued items
+	head  int           // Index of the oldest item in the buffer
+	size  int           // Number of items queued in the buffer
+
+	base  int // Initial capacity the buffer may shrink back to
+	limit int // Maximum number of items queued (zero for unlimited)
+}
+
+// Creates a queue backed by a ring buffer with the given preallocated capacity
+// (zero for a small default), holding at most limit items (zero for unlimited).
+// Pushing into a full bounded queue fails, which the binding treats as the
+// corresponding allowance being exceeded (e.g. dropping or rejecting messages).
+func NewRingQueue(capacity int, limit int) Queue {
+	if capacity <= 0 {
+		capacity = defaultRingCapacity
+	}
+	if limit > 0 && capacity > limit {
+		capacity = limit
+	}
+	return &ringQueue{
+		items: make([]interface{}, capacity),
+		base:  capacity,
+		limit: limit,
+	}
+}
+
+// Appends an item to the queue, growing the buffer if needed and allowed.
+func (q *ringQueue) Push(item interface{}) bool {
+	if q.size == len(q.items) {
+		if q.limit > 0 && q.size >= q.limit {
+			return false
+		}
+		capacity := 2 * len(q.items)
+		if q.limit > 0 && capacity > q.limit {
+			capacity = q.limit
+		}
+		q.resize(capacity)
+	}
+	q.items[(q.head+q.size)%len(q.items)] = item
+	q.size++
+	return true
+}
+
+// Removes and returns the oldest item, shrinking a mostly empty grown buffer.
+func (q *ringQueue) Pop() interface{} {
+	if q.size == 0 {
+		return nil
+	}
+	item := q.items[q.head]
+	q.items[q.head] = nil
+	q.head = (q.head + 1) % len(q.items)
+	q.size--
+
+	if len(q.items) > q.base && q.size < len(q.items)/4 {
+		capacity := len(q.items) / 2
+		if capacity < q.base {
+			capacity = q.base
+		}
+		q.resize(capacity)
+	}
+	return item
+}
+
+// Returns the oldest item without removing it.
+func (q *ringQueue) Front() interface{} {
+	if q.size == 0 {
+		return nil
+	}
+	return q.items[q.head]
+}
+
+// Returns the number of queued items.
+func (q *ringQueue) Size() int {
+	return q.size
+}
+
+// Checks whether the queue has no items.
+func (q *ringQueue) Empty() bool {
+	return q.size == 0
+}
+
+// Removes all the queued items, releasing any grown buffer.
+func (q *ringQueue) Reset() {
+	q.items = make([]interface{}, q.base)
+	q.head, q.size = 0, 0
+}
+
+// Moves the queued items into a new buffer of the given capacity.
+func (q *ringQueue) resize(capacity int) {
+	items := make([]interface{}, capacity)
+	n := copy(items, q.items[q.head:])
+	if n < q.size {
+		copy(items[n:], q.items[:q.size-n])
+	}
+	q.items, q.head = items, 0
+}
+
+// Queue of the pending request tasks of a service classifying requests by
+// priority, popping high priority items ahead of the normal ones while keeping
+// each level in arrival order.
+type prioQueue struct {
+	levels [PriorityHigh + 1]Queue // Queues of the pending items, indexed by priority
+}
+
+// Creates a priority queue, constructing each level through the user factory.
+func newPrioQueue(factory QueueFactory) *prioQueue {
+	q := new(prioQueue)
+	for i := range q.levels {
+		q.levels[i] = newQueue(factory)
+	}
+	return q
+}
+
+// Appends an item with normal priority.
+func (q *prioQueue) Push(item interface{}) bool {
+	return q.PushPriority(item, PriorityNormal)
+}
+
+// Appends an item to the level of the given priority.
+func (q *prioQueue) PushPriority(item interface{}, priority Priority) bool {
+	return q.levels[priority].Push(item)
+}
+
+// Removes and returns the oldest item of the highest non-empty priority.
+func (q *prioQueue) Pop() interface{} {
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		if !q.levels[i].Empty() {
+			return q.levels[i].Pop()
+		}
+	}
+	return nil
+}
+
+// Returns the oldest item of the highest non-empty priority without removing it.
+func (q *prioQueue) Front() interface{} {
+	for i := len(q.levels) - 1; i >= 0; i-- {
+		if !q.levels[i].Empty() {
+			return q.levels[i].Front()
+		}
+	}
+	return nil
+}
+
+// Returns the number of queued items across all priorities.
+func (q *prioQueue) Size() int {
+	size := 0
+	for _, level := range q.levels {
+		size += level.Size()
+	}
+	return size
+}
+
+// Returns the number of queued items of the given priority.
+func (q *prioQueue) SizePriority(priority Priority) int {
+	return q.levels[priority].Size()
+}
+
+// Checks whether the queue has no items at any priority.
+func (q *prioQueue) Empty() bool {
+	for _, level := range q.levels {
+		if !level.Empty() {
+			return false
+		}
+	}
+	return true
+}
+
+// Removes all the queued items.
+func (q *prioQueue) Reset() {
+	for _, level := range q.levels {
+		level.Reset()
+	}
+}
@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import "testing"
+
+// Tests that the ring queue retains FIFO order while wrapping around, growing
+// and shrinking its buffer.
+func TestRingQueue(t *testing.T) {
+	queue := NewRingQueue(4, 0).(*ringQueue)
+
+	// Interleave pushes and pops to wrap the buffer around, then grow it
+	next, want := 0, 0
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 3; i++ {
+			queue.Push(next)
+			next++
+		}
+		for i := 0; i < 2; i++ {
+			if have := queue.Pop().(int); have != want {
+				t.Fatalf("round %d: popped item mismatch: have %d, want %d.", round, have, want)
+			}
+			want++
+		}
+	}
+	for i := 0; i < 100; i++ {
+		queue.Push(next)
+		next++
+	}
+	if have := len(queue.items); have < queue.Size() || have <= 4 {
+		t.Fatalf("buffer not grown: capacity %d, size %d.", have, queue.Size())
+	}
+	// Drain the queue and verify the order and the shrinking
+	for !queue.Empty() {
+		if have := queue.Front().(int); have != want {
+			t.Fatalf("front item mismatch: have %d, want %d.", have, want)
+		}
+		if have := queue.Pop().(int); have != want {
+			t.Fatalf("popped item mismatch: have %d, want %d.", have, want)
+		}
+		want++
+	}
+	if want != next {
+		t.Fatalf("drained item count mismatch: have %d, want %d.", want, next)
+	}
+	if have := len(queue.items); have != 4 {
+		t.Fatalf("buffer not shrunk: have capacity %d, want %d.", have, 4)
+	}
+	if queue.Pop() != nil || queue.Front() != nil {
+		t.Fatalf("empty queue returned items.")
+	}
+}
+
+// Tests that a bounded ring queue rejects items beyond its limit, and that the
+// handler pools report it as an overload.
+func TestRingQueueBounded(t *testing.T) {
+	queue := NewRingQueue(2, 5)
+	for i := 0; i < 5; i++ {
+		if !queue.Push(i) {
+			t.Fatalf("push %d rejected below the limit.", i)
+		}
+	}
+	if queue.Push(5) {
+		t.Fatalf("push accepted beyond the limit.")
+	}
+	queue.Pop()
+	if !queue.Push(5) {
+		t.Fatalf("push rejected after freeing up space.")
+	}
+	queue.Reset()
+	if !queue.Empty() || queue.Size() != 0 {
+		t.Fatalf("reset queue not empty: size %d.", queue.Size())
+	}
+	// Verify that a full task queue overloads a (stopped) handler pool
+	pool := newHandlerPool(1, NewRingQueue(1, 1))
+	if err := pool.Schedule(func() {}); err != nil {
+		t.Fatalf("failed to schedule task: %v.", err)
+	}
+	if err := pool.Schedule(func() {}); err != ErrOverloaded {
+		t.Fatalf("overflowing schedule error mismatch: have %v, want %v.", err, ErrOverloaded)
+	}
+	pool.Terminate(true)
+}
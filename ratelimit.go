@@ -0,0 +1,213 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the client side rate limiting of the outbound operations.
+
+package iris
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Token bucket limit of a single operation type.
+type RateLimit struct {
+	Rate  float64 // Tokens replenished per second, zero for unlimited
+	Burst int     // Tokens accumulated while idle (defaults to one second's worth)
+}
+
+// User limits of the outbound operation rates of a connection.
+type RateLimits struct {
+	Requests   RateLimit // Request attempts per second (retries and hedges included)
+	Broadcasts RateLimit // Broadcasts per second
+	Publishes  RateLimit // Published events per second (fan-in copies excluded)
+	TunnelData RateLimit // Tunnel bytes per second, shared by all tunnels (see also TunnelConfig.SendRate)
+}
+
+// Token bucket limiting the rate of an outbound operation type.
+type tokenBucket struct {
+	rate   float64    // Tokens replenished per second
+	burst  float64    // Maximum number of tokens accumulated
+	tokens float64    // Currently available tokens (negative if reserved ahead)
+	last   time.Time  // Time instant of the last replenishment
+	lock   sync.Mutex // Mutex to protect the token count
+}
+
+// Token buckets of the rate limited outbound operations.
+type rateLimiters struct {
+	requests   *tokenBucket // Limiter of the request attempts, nil if unlimited
+	broadcasts *tokenBucket // Limiter of the broadcasts, nil if unlimited
+	publishes  *tokenBucket // Limiter of the publishes, nil if unlimited
+	tunnels    *tokenBucket // Limiter of the tunnel data, nil if unlimited
+}
+
+// Enables the client side rate limiting of the outbound operations. Operations
+// exceeding their rate block until enough tokens accumulate, their deadline
+// expires or their context is cancelled. Any unset rates (i.e. value of zero)
+// leave the operation type unlimited.
+//
+// Replacing the limits resets all the buckets to full.
+func (c *Connection) EnableRateLimits(limits *RateLimits) {
+	if limits == nil {
+		limits = new(RateLimits)
+	}
+	c.rateLock.Lock()
+	defer c.rateLock.Unlock()
+
+	c.rateLimits = &rateLimiters{
+		requests:   newTokenBucket(limits.Requests),
+		broadcasts: newTokenBucket(limits.Broadcasts),
+		publishes:  newTokenBucket(limits.Publishes),
+		tunnels:    newTokenBucket(limits.TunnelData),
+	}
+}
+
+// Disables the client side rate limiting of the outbound operations.
+func (c *Connection) DisableRateLimits() {
+	c.rateLock.Lock()
+	defer c.rateLock.Unlock()
+
+	c.rateLimits = nil
+}
+
+// Retrieves the active rate limiters, or an empty set if disabled.
+func (c *Connection) limiters() *rateLimiters {
+	c.rateLock.Lock()
+	defer c.rateLock.Unlock()
+
+	if c.rateLimits == nil {
+		return new(rateLimiters)
+	}
+	return c.rateLimits
+}
+
+// Creates a full token bucket for the limit, or nil if unlimited.
+func newTokenBucket(limit RateLimit) *tokenBucket {
+	if limit.Rate <= 0 {
+		return nil
+	}
+	burst := float64(limit.Burst)
+	if burst <= 0 {
+		burst = math.Max(1, math.Ceil(limit.Rate))
+	}
+	return &tokenBucket{
+		rate:   limit.Rate,
+		burst:  burst,
+		tokens: burst,
+		last:   time.Now(),
+	}
+}
+
+// Takes a number of tokens from the bucket, going into debt if not enough are
+// available, and returns the time to wait until the debt is repaid.
+func (b *tokenBucket) reserve(tokens int) time.Duration {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Replenish the tokens accumulated since the last operation
+	now := time.Now()
+	b.tokens = math.Min(b.burst, b.tokens+now.Sub(b.last).Seconds()*b.rate)
+	b.last = now
+
+	b.tokens -= float64(tokens)
+	if b.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-b.tokens / b.rate * float64(time.Second))
+}
+
+// Returns previously reserved tokens to the bucket, if the operation was aborted
+// before their use.
+func (b *tokenBucket) cancel(tokens int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	b.tokens = math.Min(b.burst, b.tokens+float64(tokens))
+}
+
+// Waits until the bucket permits an operation consuming the given number of
+// tokens, or until the deadline expires, the context is cancelled or the
+// connection is closed.
+func (c *Connection) throttle(ctx context.Context, bucket *tokenBucket, tokens int, deadline <-chan time.Time) error {
+	if bucket == nil {
+		return nil
+	}
+	delay := bucket.reserve(tokens)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-timer.C:
+		return nil
+	case <-deadline:
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-c.term:
+		err = ErrClosed
+	}
+	bucket.cancel(tokens)
+	return err
+}
+
+// Sets the outbound byte rate limit of the tunnel, applied on top of the
+// connection wide TunnelData one, so bulk transfers can be shaped to leave room
+// for the rest of the traffic sharing the relay link. A zero rate removes the
+// limit. The initial one is taken from TunnelConfig.SendRate.
+//
+// Replacing the limit resets the bucket to full.
+func (t *Tunnel) SetSendRate(limit RateLimit) {
+	t.rateLock.Lock()
+	defer t.rateLock.Unlock()
+
+	t.sendRate = newTokenBucket(limit)
+}
+
+// Retrieves the outbound byte rate limiter of the tunnel, nil if unlimited.
+func (t *Tunnel) sendLimiter() *tokenBucket {
+	t.rateLock.Lock()
+	defer t.rateLock.Unlock()
+
+	return t.sendRate
+}
+
+// Waits until the tunnel's own rate limiter permits sending the given number of
+// bytes, or until the deadline expires, the context is cancelled or the tunnel
+// is closed.
+func (t *Tunnel) throttle(ctx context.Context, bytes int, deadline <-chan time.Time) error {
+	bucket := t.sendLimiter()
+	if bucket == nil {
+		return nil
+	}
+	delay := bucket.reserve(bytes)
+	if delay == 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var err error
+	select {
+	case <-timer.C:
+		return nil
+	case <-deadline:
+		err = ErrTimeout
+	case <-t.writeDl.wait():
+		err = ErrTimeout
+	case <-ctx.Done():
+		err = ctx.Err()
+	case <-t.term:
+		err = t.closedErr()
+	}
+	bucket.cancel(bytes)
+	return err
+}
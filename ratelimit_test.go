@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the rate limiting tests, counting the arrived requests.
+type rateLimitTestHandler struct {
+	count int32
+}
+
+func (r *rateLimitTestHandler) Init(conn *Connection) error { return nil }
+func (r *rateLimitTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *rateLimitTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *rateLimitTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (r *rateLimitTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	atomic.AddInt32(&r.count, 1)
+	return req, nil
+}
+
+// Tests that outbound operations are throttled according to the rate limits.
+func TestRateLimits(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		rate   float64
+		events int
+	}{50, 6}
+
+	// Register a new service to the relay
+	handler := new(rateLimitTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	conn.EnableRateLimits(&RateLimits{
+		Requests:  RateLimit{Rate: 1, Burst: 1},
+		Publishes: RateLimit{Rate: conf.rate, Burst: 1},
+	})
+	// Publish a series of events and verify that they're spread out
+	start := time.Now()
+	for i := 0; i < conf.events; i++ {
+		if err := conn.Publish(config.topic, []byte{byte(i)}); err != nil {
+			t.Fatalf("publish %d failed: %v.", i, err)
+		}
+	}
+	want := time.Duration(float64(conf.events-1) / conf.rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want*9/10 {
+		t.Fatalf("publishes not throttled: have %v, want at least %v.", elapsed, want)
+	}
+	// Exhaust the request bucket and verify that the next one times out locally
+	if _, err := conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if _, err := conn.Request(config.cluster, []byte{0x00}, 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("throttled request mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	if count := atomic.LoadInt32(&handler.count); count != 1 {
+		t.Fatalf("request count mismatch: have %d, want %d.", count, 1)
+	}
+	// Disable the limits and verify that requests flow freely
+	conn.DisableRateLimits()
+	start = time.Now()
+	for i := 0; i < 3; i++ {
+		if _, err := conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+			t.Fatalf("unlimited request %d failed: %v.", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("unlimited requests throttled: took %v.", elapsed)
+	}
+}
+
+// Tests that tunnel sends are throttled according to the tunnel's own rate limit
+// and that the limit can be replaced or lifted on the fly.
+func TestTunnelSendRate(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		rate     float64
+		messages int
+		size     int
+	}{2000, 5, 200}
+
+	// Register an echo service and open a rate limited tunnel to it
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tun, err := conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{
+		SendRate: RateLimit{Rate: conf.rate, Burst: conf.size},
+	})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tun.Close()
+
+	// Send a series of messages and verify that they're spread out
+	start := time.Now()
+	for i := 0; i < conf.messages; i++ {
+		if err := tun.Send(make([]byte, conf.size), time.Second); err != nil {
+			t.Fatalf("send %d failed: %v.", i, err)
+		}
+		if _, err := tun.Recv(time.Second); err != nil {
+			t.Fatalf("echo %d failed: %v.", i, err)
+		}
+	}
+	want := time.Duration(float64((conf.messages-1)*conf.size) / conf.rate * float64(time.Second))
+	if elapsed := time.Since(start); elapsed < want*9/10 {
+		t.Fatalf("sends not throttled: have %v, want at least %v.", elapsed, want)
+	}
+	// Lower the limit, exhaust the bucket and verify that the next send times out
+	tun.SetSendRate(RateLimit{Rate: 100, Burst: conf.size})
+	if err := tun.Send(make([]byte, conf.size/2), time.Second); err != nil {
+		t.Fatalf("send failed: %v.", err)
+	}
+	if _, err := tun.Recv(time.Second); err != nil {
+		t.Fatalf("echo failed: %v.", err)
+	}
+	if err := tun.Send(make([]byte, conf.size), 50*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("throttled send mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Lift the limit and verify that sends flow freely
+	tun.SetSendRate(RateLimit{})
+	start = time.Now()
+	for i := 0; i < conf.messages; i++ {
+		if err := tun.Send(make([]byte, conf.size), time.Second); err != nil {
+			t.Fatalf("unlimited send %d failed: %v.", i, err)
+		}
+		if _, err := tun.Recv(time.Second); err != nil {
+			t.Fatalf("unlimited echo %d failed: %v.", i, err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("unlimited sends throttled: took %v.", elapsed)
+	}
+}
@@ -0,0 +1,123 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the replay buffers of the subscriptions, retaining the last arrived
+// events for inspection when a subscriber runs into trouble processing them.
+
+package iris
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Event retained in the replay buffer of a subscription.
+type RecentEvent struct {
+	Time    time.Time // Time instance the event arrived at
+	Topic   string    // Topic the event was published to (the concrete one for patterns)
+	Payload []byte    // Application payload of the event
+}
+
+// Buffer of the last events arrived to a subscription, ordered by arrival time.
+// As unordered subscriptions admit their events concurrently, events may be
+// recorded out of order, so the buffer keeps the newest ones sorted instead of
+// overwriting in place.
+type recentBuffer struct {
+	events []RecentEvent // Retained events, oldest first
+	limit  int           // Maximum number of events to retain
+	lock   sync.Mutex    // Protects the buffer from concurrent arrivals and reads
+}
+
+// Creates a replay buffer retaining the given number of events, or nil if the
+// retention is disabled.
+func newRecentBuffer(limit int) *recentBuffer {
+	if limit <= 0 {
+		return nil
+	}
+	return &recentBuffer{
+		events: make([]RecentEvent, 0, limit),
+		limit:  limit,
+	}
+}
+
+// Retains a copy of an arrived event, evicting the oldest one if full. It is a
+// no-op if the retention is disabled.
+func (b *recentBuffer) record(arrived time.Time, topic string, payload []byte) {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	// Discard the event if it's older than all the retained ones
+	if len(b.events) == b.limit {
+		if arrived.Before(b.events[0].Time) {
+			return
+		}
+		b.events = append(b.events[:0], b.events[1:]...)
+	}
+	// Insert the event after any retained ones that arrived no later
+	pos := sort.Search(len(b.events), func(i int) bool { return b.events[i].Time.After(arrived) })
+
+	b.events = append(b.events, RecentEvent{})
+	copy(b.events[pos+1:], b.events[pos:])
+	b.events[pos] = RecentEvent{
+		Time:    arrived,
+		Topic:   topic,
+		Payload: append([]byte(nil), payload...),
+	}
+}
+
+// Retrieves the retained events, oldest first.
+func (b *recentBuffer) snapshot() []RecentEvent {
+	if b == nil {
+		return nil
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	return append([]RecentEvent(nil), b.events...)
+}
+
+// Retrieves the last events arrived to a subscription, oldest first, as retained
+// according to the RecentEvents field of its TopicLimits. Events are recorded on
+// arrival, whether or not they reached the handler afterwards (e.g. dropped by an
+// overflowing queue), so the ones preceding a processing failure can be examined.
+//
+// ErrNotSubscribed is returned if the topic is not subscribed to. The result is
+// empty if the subscription retains no events.
+func (c *Connection) RecentEvents(topic string) ([]RecentEvent, error) {
+	c.subLock.RLock()
+	top, ok := c.subLive[topic]
+	c.subLock.RUnlock()
+
+	if !ok {
+		return nil, ErrNotSubscribed
+	}
+	return top.recent.snapshot(), nil
+}
+
+// Retrieves the last events arrived to the topic's subscription, oldest first,
+// decoded through the topic's codec. See Connection.RecentEvents for details.
+// If an event fails to decode, the ones decoded before it are returned with the
+// error; the raw events remain available via Connection.RecentEvents.
+func (t *Topic[T]) Recent() ([]T, error) {
+	events, err := t.conn.RecentEvents(t.name)
+	if err != nil {
+		return nil, err
+	}
+	decoded := make([]T, 0, len(events))
+	for i, event := range events {
+		var value T
+		if err := t.codec.Unmarshal(event.Payload, &value); err != nil {
+			return decoded, fmt.Errorf("recent event %d: %w", i, err)
+		}
+		decoded = append(decoded, value)
+	}
+	return decoded, nil
+}
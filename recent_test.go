@@ -0,0 +1,86 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+)
+
+// Tests that subscriptions retain the last arrived events for inspection, if
+// requested to.
+func TestRecentEvents(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		retain int
+		events int
+	}{3, 5}
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe a retaining typed topic and a plain one
+	topic := NewTopic[int](conn, config.topic, JSONCodec)
+	if _, err := topic.Recent(); err != ErrNotSubscribed {
+		t.Fatalf("unsubscribed recent events error mismatch: have %v, want %v.", err, ErrNotSubscribed)
+	}
+	delivers := make(chan int, conf.events)
+	if err := topic.Subscribe(func(event int) { delivers <- event }, &TopicLimits{RecentEvents: conf.retain}); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	plain := config.topic + "-plain"
+	if err := conn.Subscribe(plain, &publishTestTopicHandler{delivers: make(chan []byte, conf.events)}, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	// Publish a series of events and wait for them to arrive
+	for i := 0; i < conf.events; i++ {
+		if err := topic.Publish(i); err != nil {
+			t.Fatalf("failed to publish event %d: %v.", i, err)
+		}
+		if err := conn.Publish(plain, []byte{byte(i)}); err != nil {
+			t.Fatalf("failed to publish plain event %d: %v.", i, err)
+		}
+	}
+	for i := 0; i < conf.events; i++ {
+		select {
+		case <-delivers:
+		case <-time.After(time.Second):
+			t.Fatalf("event %d not delivered.", i)
+		}
+	}
+	// Verify that only the last few events are retained, oldest first
+	recent, err := topic.Recent()
+	if err != nil {
+		t.Fatalf("failed to retrieve recent events: %v.", err)
+	}
+	if len(recent) != conf.retain {
+		t.Fatalf("recent event count mismatch: have %d, want %d.", len(recent), conf.retain)
+	}
+	for i, event := range recent {
+		if want := conf.events - conf.retain + i; event != want {
+			t.Fatalf("recent event %d mismatch: have %d, want %d.", i, event, want)
+		}
+	}
+	raw, err := conn.RecentEvents(config.topic)
+	if err != nil {
+		t.Fatalf("failed to retrieve raw recent events: %v.", err)
+	}
+	for i, event := range raw {
+		if event.Topic != config.topic || event.Time.IsZero() {
+			t.Fatalf("raw recent event %d mismatch: have %+v.", i, event)
+		}
+	}
+	// Verify that subscriptions retain nothing by default
+	if events, err := conn.RecentEvents(plain); err != nil || len(events) != 0 {
+		t.Fatalf("plain recent events mismatch: have %v/%v, want none.", events, err)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the automatic reconnection logic of the relay link.
+
+package iris
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// User policy of the automatic reconnection to a dropped relay node.
+type ReconnectPolicy struct {
+	Attempts   int           // Reconnection attempts before giving up (negative for unlimited)
+	Timeout    time.Duration // Time allowance of a single reconnection attempt
+	MinBackoff time.Duration // Delay before the first reconnection attempt
+	MaxBackoff time.Duration // Maximum delay between consecutive attempts
+}
+
+// Default policy of the automatic reconnection to a dropped relay node.
+var defaultReconnectPolicy = ReconnectPolicy{
+	Attempts:   10,
+	Timeout:    10 * time.Second,
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 10 * time.Second,
+}
+
+// Enables the automatic reconnection to the local relay after a connection
+// drop. On success, the service cluster registration and all active topic
+// subscriptions are restored transparently. Pending requests and open tunnels
+// cannot survive a link failure and are not replayed (the remote side may have
+// already acted on them): they fail with ErrClosed as soon as the link drops,
+// and it's up to the caller to retry them once it's restored.
+//
+// The service handler's HandleDrop is only invoked if all reconnection attempts
+// fail. Any unset fields (i.e. value of zero) of the policy will default to the
+// preset ones.
+func (c *Connection) EnableReconnect(policy *ReconnectPolicy) {
+	c.recoLock.Lock()
+	defer c.recoLock.Unlock()
+
+	c.recoPolicy = finalizeReconnectPolicy(policy)
+}
+
+// Disables the automatic reconnection to the local relay.
+func (c *Connection) DisableReconnect() {
+	c.recoLock.Lock()
+	defer c.recoLock.Unlock()
+
+	c.recoPolicy = nil
+}
+
+// Merges the user requested policy with the defaults.
+func finalizeReconnectPolicy(user *ReconnectPolicy) *ReconnectPolicy {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultReconnectPolicy
+	}
+	// Check each field and merge only non-specified ones
+	policy := new(ReconnectPolicy)
+	*policy = *user
+
+	if user.Attempts == 0 {
+		policy.Attempts = defaultReconnectPolicy.Attempts
+	}
+	if user.Timeout == 0 {
+		policy.Timeout = defaultReconnectPolicy.Timeout
+	}
+	if user.MinBackoff == 0 {
+		policy.MinBackoff = defaultReconnectPolicy.MinBackoff
+	}
+	if user.MaxBackoff == 0 {
+		policy.MaxBackoff = defaultReconnectPolicy.MaxBackoff
+	}
+	return policy
+}
+
+// Tries to re-establish a dropped relay link according to the reconnection
+// policy, restoring the cluster registration and topic subscriptions. Returns
+// whether the link was restored, or whether a requested tear-down aborted the
+// reconnection.
+func (c *Connection) reconnect(reason error) (bool, bool) {
+	// Make sure reconnection is enabled and the drop wasn't requested
+	c.recoLock.Lock()
+	policy := c.recoPolicy
+	c.recoLock.Unlock()
+
+	if policy == nil || atomic.LoadInt32(&c.closing) == 1 {
+		return false, false
+	}
+	c.Log.Warn("relay connection dropped, reconnecting", "reason", reason)
+	c.suspendOutbox()
+	c.setHealth(HealthDegraded)
+
+	// Fail all operations bound to the dropped link
+	c.dropLink()
+	c.markSubscriptionsPending()
+
+	// Keep redialing the relay until success or the attempts run out
+	backoff := policy.MinBackoff
+	for attempt := 1; policy.Attempts < 0 || attempt <= policy.Attempts; attempt++ {
+		select {
+		case <-time.After(backoff):
+		case <-c.stop:
+			return false, true
+		}
+		// Redial the relay, aborting if a tear-down is requested meanwhile
+		ctx, cancel := context.WithTimeout(context.Background(), policy.Timeout)
+		go func() {
+			select {
+			case <-c.stop:
+				cancel()
+			case <-ctx.Done():
+			}
+		}()
+		link, err := dialRelay(ctx, c.relay, c.cluster, c.getFrameDump())
+		cancel()
+
+		if err == nil {
+			// Swap in the new link, unless a tear-down was requested meanwhile
+			c.sockLock.Lock()
+			if atomic.LoadInt32(&c.closing) == 1 {
+				c.sockLock.Unlock()
+				link.sock.Close()
+				return false, true
+			}
+			c.sock, c.sockBuf = link.sock, link.sockBuf
+			c.relayVer, c.relayFeats = link.relayVer, link.relayFeats
+			c.resizeSendBuffer()
+			c.sockLock.Unlock()
+
+			c.Log.Info("relay connection restored", "attempt", attempt)
+			c.setHealth(HealthConnected)
+			c.resubscribe()
+			c.replayOutbox()
+			return true, false
+		}
+		c.Log.Warn("reconnection attempt failed", "attempt", attempt, "reason", err)
+
+		// Increase the backoff for the next attempt
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+	}
+	c.Log.Error("reconnection attempts exhausted", "attempts", policy.Attempts)
+	return false, false
+}
+
+// Fails all the pending requests and open tunnels bound to a dropped link.
+func (c *Connection) dropLink() {
+	c.reqLock.RLock()
+	for _, errc := range c.reqErrs {
+		select {
+		case errc <- ErrClosed:
+		default:
+		}
+	}
+	c.reqLock.RUnlock()
+	c.failFutures(ErrClosed)
+
+	c.tunLock.Lock()
+	for id, tun := range c.tunLive {
+		tun.handleClose("connection dropped")
+		delete(c.tunLive, id)
+	}
+	c.tunLock.Unlock()
+}
+
+// Replays all the active topic subscriptions onto a freshly restored link,
+// recording the outcome in their status and retrying the failed ones if enabled.
+func (c *Connection) resubscribe() {
+	c.subSync.Lock()
+	c.subLock.RLock()
+
+	fanins := make(map[string][]*topic)
+	for name, top := range c.subLive {
+		if pattern, _ := parsePattern(name); pattern {
+			fanin := patternFanIn(name)
+			fanins[fanin] = append(fanins[fanin], top)
+			continue
+		}
+		top.logger.Info("restoring subscription")
+		err := c.sendSubscribe(name)
+		if err != nil {
+			top.logger.Error("failed to restore subscription", "reason", err)
+		}
+		top.markSubscribed(err)
+	}
+	for fanin, tops := range fanins {
+		c.Log.Info("restoring pattern fan-in subscription", "topic", fanin)
+		err := c.sendSubscribe(fanin)
+		if err != nil {
+			c.Log.Error("failed to restore pattern subscription", "topic", fanin, "reason", err)
+		}
+		for _, top := range tops {
+			top.markSubscribed(err)
+		}
+	}
+	c.subLock.RUnlock()
+	c.subSync.Unlock()
+
+	c.reconcileSubscriptions()
+}
@@ -0,0 +1,242 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Service handler for the reconnection tests, echoing requests and counting the
+// reported drops.
+type reconnectTestHandler struct {
+	drops chan error
+}
+
+func (r *reconnectTestHandler) Init(conn *Connection) error              { return nil }
+func (r *reconnectTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (r *reconnectTestHandler) HandleRequest(req []byte) ([]byte, error) { return req, nil }
+func (r *reconnectTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (r *reconnectTestHandler) HandleDrop(reason error)                  { r.drops <- reason }
+
+// Waits until the health of the relay link reaches the given state.
+func waitHealthState(t *testing.T, conn *Connection, state HealthState) {
+	t.Helper()
+
+	for start := time.Now(); conn.Health() != state; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("health mismatch: have %v, want %v.", conn.Health(), state)
+		}
+	}
+}
+
+// Tests that a dropped relay link is restored along with the cluster
+// registration and the topic subscriptions, without reporting a drop.
+func TestReconnect(t *testing.T) {
+	// Start a private relay and register a reconnecting, subscribed service
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	handler := &reconnectTestHandler{drops: make(chan error, 1)}
+	serv, err := Register(relay.Port(), config.cluster, handler, nil,
+		WithReconnect(&ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	topic := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := serv.conn.Subscribe(config.topic, topic, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	waitSubscriptionState(t, serv.conn, config.topic, SubscriptionActive)
+
+	// Crash the relay links and wait for the service to come back
+	relay.Disconnect("")
+	waitHealthState(t, serv.conn, HealthDegraded)
+	waitHealthState(t, serv.conn, HealthConnected)
+	waitSubscriptionState(t, serv.conn, config.topic, SubscriptionActive)
+
+	// Verify that both the registration and the subscription were restored
+	conn, err := Connect(relay.Port())
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	if reply, err := conn.Request(config.cluster, []byte("ping"), time.Second); err != nil || string(reply) != "ping" {
+		t.Fatalf("request after reconnect mismatch: have %q/%v, want %q.", reply, err, "ping")
+	}
+	if err := conn.Publish(config.topic, []byte("event")); err != nil {
+		t.Fatalf("failed to publish: %v.", err)
+	}
+	select {
+	case event := <-topic.delivers:
+		if string(event) != "event" {
+			t.Fatalf("event mismatch: have %q, want %q.", event, "event")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("event not delivered after reconnect.")
+	}
+	select {
+	case reason := <-handler.drops:
+		t.Fatalf("restored link reported as dropped: %v.", reason)
+	default:
+	}
+}
+
+// Service handler for the in-flight reconnection tests, holding requests and
+// tunnels until released.
+type reconnectInFlightTestHandler struct {
+	reqs chan struct{}
+	tuns chan *Tunnel
+	hold chan struct{}
+}
+
+func (r *reconnectInFlightTestHandler) Init(conn *Connection) error { return nil }
+func (r *reconnectInFlightTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *reconnectInFlightTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (r *reconnectInFlightTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	r.reqs <- struct{}{}
+	<-r.hold
+	return req, nil
+}
+
+func (r *reconnectInFlightTestHandler) HandleTunnel(tun *Tunnel) {
+	r.tuns <- tun
+	<-r.hold
+	tun.Close()
+}
+
+// Tests that the requests and tunnels in flight when the relay link drops are
+// failed instead of replayed, while the restored link remains usable.
+func TestReconnectInFlight(t *testing.T) {
+	// Start a private relay and attach a reconnecting service and client
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	defer relay.Close()
+
+	policy := &ReconnectPolicy{MinBackoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+	handler := &reconnectInFlightTestHandler{
+		reqs: make(chan struct{}, 2),
+		tuns: make(chan *Tunnel, 1),
+		hold: make(chan struct{}),
+	}
+	serv, err := Register(relay.Port(), config.cluster, handler, nil, WithReconnect(policy))
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(relay.Port(), WithReconnect(policy))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Open a tunnel and issue a request, both held up by the service
+	tun, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("failed to open tunnel: %v.", err)
+	}
+	<-handler.tuns
+
+	errc := make(chan error, 1)
+	go func() {
+		_, err := conn.Request(config.cluster, []byte("ping"), 5*time.Second)
+		errc <- err
+	}()
+	select {
+	case <-handler.reqs:
+	case <-time.After(time.Second):
+		t.Fatalf("request not delivered.")
+	}
+	// Drop the relay links and verify that the in-flight operations fail
+	relay.Disconnect("")
+
+	select {
+	case err := <-errc:
+		if !errors.Is(err, ErrClosed) {
+			t.Fatalf("in-flight request error mismatch: have %v, want %v.", err, ErrClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("in-flight request not failed.")
+	}
+	if _, err := tun.Recv(time.Second); !errors.Is(err, ErrClosed) {
+		t.Fatalf("tunnel receive error mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	if err := tun.Send([]byte("data"), time.Second); !errors.Is(err, ErrClosed) {
+		t.Fatalf("tunnel send error mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	// Release the held up handlers and verify that new requests go through
+	close(handler.hold)
+
+	waitHealthState(t, serv.conn, HealthConnected)
+	waitHealthState(t, conn, HealthConnected)
+
+	if reply, err := conn.Request(config.cluster, []byte("pong"), time.Second); err != nil || string(reply) != "pong" {
+		t.Fatalf("request after reconnect mismatch: have %q/%v, want %q.", reply, err, "pong")
+	}
+}
+
+// Tests that closing a connection while it's reconnecting tears it down swiftly
+// and cleanly, instead of waiting out the reconnection attempts.
+func TestReconnectClose(t *testing.T) {
+	// Start a private relay and connect with slow reconnection attempts
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	conn, err := Connect(relay.Port(),
+		WithReconnect(&ReconnectPolicy{Attempts: -1, MinBackoff: 10 * time.Second}))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	topic := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conn.Subscribe(config.topic, topic, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	// Kill the relay and close the connection during the reconnection
+	relay.Close()
+	waitHealthState(t, conn, HealthDegraded)
+
+	done := make(chan error, 1)
+	go func() { done <- conn.Close() }()
+
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatalf("close during reconnect failed: %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("close during reconnect timed out.")
+	}
+	// Verify that the local state was torn down
+	if health := conn.Health(); health != HealthClosed {
+		t.Fatalf("health mismatch: have %v, want %v.", health, HealthClosed)
+	}
+	conn.subLock.RLock()
+	top := conn.subLive[config.topic]
+	conn.subLock.RUnlock()
+
+	top.eventLock.Lock()
+	terminated := top.eventTerm
+	top.eventLock.Unlock()
+
+	if !terminated {
+		t.Fatalf("subscription not terminated.")
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the panic recovery around the inbound handler dispatch.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+)
+
+// Hook invoked with the value and stack trace of a panic recovered while
+// dispatching an inbound operation (handler or interceptor). The returned error
+// is reported in place of the panic (for requests, sent back to the requester);
+// if nil, a generic one is used.
+type RecoveryHook func(op TraceOp, target string, value interface{}, stack []byte) error
+
+// Sets the hook to invoke on panics recovered from the handlers and inbound
+// interceptors of the connection, replacing any previous one. Panics are always
+// recovered and logged, the hook only allows custom reporting and translation.
+func (c *Connection) SetRecoveryHook(hook RecoveryHook) {
+	c.icptLock.Lock()
+	defer c.icptLock.Unlock()
+
+	c.panicHook = hook
+}
+
+// Wraps an inbound invoker, converting any panic into an error.
+func (c *Connection) guard(invoker Invoker) Invoker {
+	return func(ctx context.Context, op TraceOp, target string, payload []byte) (reply []byte, err error) {
+		defer func() {
+			if value := recover(); value != nil {
+				reply, err = nil, c.recoverPanic(op, target, value)
+			}
+		}()
+		return invoker(ctx, op, target, payload)
+	}
+}
+
+// Logs a recovered panic and translates it into an error via the user hook, if
+// one is set.
+func (c *Connection) recoverPanic(op TraceOp, target string, value interface{}) error {
+	stack := debug.Stack()
+	c.Log.Crit("inbound handler panicked", "op", op, "target", target, "panic", value, "stack", string(stack))
+
+	c.icptLock.RLock()
+	hook := c.panicHook
+	c.icptLock.RUnlock()
+
+	if hook != nil {
+		if err := hook(op, target, value, stack); err != nil {
+			return err
+		}
+	}
+	return &Error{Code: CodeInternal, Message: fmt.Sprintf("handler panicked: %v", value)}
+}
@@ -0,0 +1,79 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// Service handler for the recovery tests, panicking on every inbound operation.
+type recoverTestHandler struct {
+	conn *Connection
+}
+
+func (r *recoverTestHandler) Init(conn *Connection) error              { r.conn = conn; return nil }
+func (r *recoverTestHandler) HandleBroadcast(msg []byte)               { panic("broadcast panic") }
+func (r *recoverTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("request panic") }
+func (r *recoverTestHandler) HandleTunnel(tun *Tunnel)                 { panic("tunnel panic") }
+func (r *recoverTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Tests that handler panics are recovered, reported and translated.
+func TestRecoverPanic(t *testing.T) {
+	// Register a new service to the relay
+	handler := new(recoverTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Verify that panicking requests fail remotely
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err == nil {
+		t.Fatalf("panicking request succeeded.")
+	} else if _, ok := err.(*RemoteError); !ok {
+		t.Fatalf("request error mismatch: have %v, want remote error.", err)
+	}
+	// Install a translating hook and verify that it's invoked for all operations
+	panics := make(chan string, 3)
+	handler.conn.SetRecoveryHook(func(op TraceOp, target string, value interface{}, stack []byte) error {
+		panics <- fmt.Sprint(value)
+		return errors.New("translated")
+	})
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err == nil || err.Error() != "translated" {
+		t.Fatalf("translated error mismatch: have %v, want %v.", err, "translated")
+	}
+	if err := handler.conn.Broadcast(config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("broadcast failed: %v.", err)
+	}
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	seen := make(map[string]bool)
+	for i := 0; i < 3; i++ {
+		select {
+		case value := <-panics:
+			seen[value] = true
+		case <-time.After(time.Second):
+			t.Fatalf("panic #%d not recovered.", i)
+		}
+	}
+	for _, value := range []string{"request panic", "broadcast panic", "tunnel panic"} {
+		if !seen[value] {
+			t.Fatalf("panic %q not reported.", value)
+		}
+	}
+	// The failed tunnel should be torn down
+	if _, err := tunnel.Recv(time.Second); err != ErrClosed {
+		t.Fatalf("panicked tunnel receive mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
@@ -0,0 +1,195 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the client side caching of idempotent requests, answering repeated
+// read-mostly lookups from a short lived reply cache instead of a round trip.
+
+package iris
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"sync"
+	"time"
+)
+
+// Limits of the reply cache of the outbound idempotent requests.
+type RequestCacheConfig struct {
+	Entries int           // Maximum number of replies cached
+	Memory  int           // Maximum total size of the cached replies
+	TTL     time.Duration // Time a reply is cached for after it arrived
+}
+
+// Default limits of the reply cache of the outbound idempotent requests.
+var defaultRequestCacheConfig = RequestCacheConfig{
+	Entries: 1024,
+	Memory:  16 * 1024 * 1024,
+	TTL:     10 * time.Second,
+}
+
+// Merges the user requested limits with the defaults.
+func finalizeRequestCacheConfig(user *RequestCacheConfig) *RequestCacheConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultRequestCacheConfig
+	}
+	// Check each field and merge only non-specified ones
+	config := new(RequestCacheConfig)
+	*config = *user
+
+	if user.Entries <= 0 {
+		config.Entries = defaultRequestCacheConfig.Entries
+	}
+	if user.Memory <= 0 {
+		config.Memory = defaultRequestCacheConfig.Memory
+	}
+	if user.TTL <= 0 {
+		config.TTL = defaultRequestCacheConfig.TTL
+	}
+	return config
+}
+
+// Outcome of a cached request, shared by all the lookups of the same request.
+type requestCacheEntry struct {
+	key     string        // Cluster and request digest the reply belongs to
+	done    chan struct{} // Channel closed when the request completes
+	reply   []byte        // Reply of the request, once done
+	err     error         // Failure of the request, once done
+	expires time.Time     // Time the cached reply expires, zero while running
+}
+
+// LRU cache of the recent request replies, keyed by cluster and request digest.
+type requestCache struct {
+	config  *RequestCacheConfig      // Limits of the cache
+	entries map[string]*list.Element // Cached replies by cluster and request digest
+	order   *list.List               // Cached replies, most recently used first
+	used    int                      // Total size of the cached replies
+	lock    sync.Mutex               // Mutex to protect the cache
+}
+
+// Enables the caching of the replies to the requests issued via RequestCached:
+// repeating a request to the same cluster within the TTL is answered from the
+// cache instead of a round trip, and concurrent lookups of a request not cached
+// yet wait for a single one to complete. Only suitable for idempotent read-mostly
+// lookups (e.g. configuration or metadata queries), as the staleness of a reply
+// is only bounded by the TTL.
+//
+// Only successful replies are cached. Any unset fields (i.e. value of zero) of
+// the config will default to the preset ones.
+func (c *Connection) EnableRequestCache(config *RequestCacheConfig) {
+	cache := &requestCache{
+		config:  finalizeRequestCacheConfig(config),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	c.reqCache = cache
+}
+
+// Disables the caching of the request replies, dropping the cached ones.
+func (c *Connection) DisableRequestCache() {
+	c.cacheLock.Lock()
+	defer c.cacheLock.Unlock()
+
+	c.reqCache = nil
+}
+
+// Executes a synchronous request like Request, answering it from the reply cache
+// if the same request was recently issued to the same cluster (see
+// EnableRequestCache). If the cache is disabled, the request is always issued.
+//
+// The returned reply may be shared with other callers, and must not be modified.
+func (c *Connection) RequestCached(cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	c.cacheLock.Lock()
+	cache := c.reqCache
+	c.cacheLock.Unlock()
+
+	if cache == nil {
+		return c.Request(cluster, request, timeout)
+	}
+	// Look up the request, registering it if not cached yet
+	entry, seen := cache.claim(requestCacheKey(cluster, request))
+	if seen {
+		select {
+		case <-entry.done:
+			return entry.reply, entry.err
+		case <-time.After(timeout):
+			return nil, ErrTimeout
+		}
+	}
+	// First lookup, execute and share the outcome
+	entry.reply, entry.err = c.Request(cluster, request, timeout)
+	cache.complete(entry)
+	close(entry.done)
+
+	return entry.reply, entry.err
+}
+
+// Derives the cache key of a request issued to a cluster.
+func requestCacheKey(cluster string, request []byte) string {
+	digest := sha256.Sum256(request)
+	return cluster + "\x00" + string(digest[:])
+}
+
+// Retrieves the live entry of a request, or registers a new one if none exists
+// (or it expired). Returns whether the request was cached before.
+func (r *requestCache) claim(key string) (*requestCacheEntry, bool) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if elem, ok := r.entries[key]; ok {
+		entry := elem.Value.(*requestCacheEntry)
+		if entry.expires.IsZero() || time.Now().Before(entry.expires) {
+			r.order.MoveToFront(elem)
+			return entry, true
+		}
+		r.remove(elem)
+	}
+	entry := &requestCacheEntry{key: key, done: make(chan struct{})}
+	r.entries[key] = r.order.PushFront(entry)
+	r.evict()
+
+	return entry, false
+}
+
+// Marks a request completed, caching its reply if successful or dropping it from
+// the cache otherwise.
+func (r *requestCache) complete(entry *requestCacheEntry) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	elem, ok := r.entries[entry.key]
+	if !ok || elem.Value.(*requestCacheEntry) != entry {
+		return
+	}
+	if entry.err != nil || len(entry.reply) > r.config.Memory {
+		r.remove(elem)
+		return
+	}
+	entry.expires = time.Now().Add(r.config.TTL)
+	r.used += len(entry.reply)
+	r.evict()
+}
+
+// Evicts the least recently used entries above the limits. The cache lock is
+// assumed to be held.
+func (r *requestCache) evict() {
+	for r.order.Len() > r.config.Entries || r.used > r.config.Memory {
+		r.remove(r.order.Back())
+	}
+}
+
+// Drops an entry from the cache. The cache lock is assumed to be held.
+func (r *requestCache) remove(elem *list.Element) {
+	entry := elem.Value.(*requestCacheEntry)
+	if !entry.expires.IsZero() {
+		r.used -= len(entry.reply)
+	}
+	r.order.Remove(elem)
+	delete(r.entries, entry.key)
+}
@@ -0,0 +1,136 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"container/list"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the request cache tests, counting the served requests.
+type requestCacheTestHandler struct {
+	served int32
+}
+
+func (h *requestCacheTestHandler) Init(conn *Connection) error { return nil }
+func (h *requestCacheTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (h *requestCacheTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (h *requestCacheTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (h *requestCacheTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	atomic.AddInt32(&h.served, 1)
+	time.Sleep(10 * time.Millisecond)
+	return req, nil
+}
+
+// Tests that cached requests are answered locally within their TTL, and that
+// concurrent lookups are coalesced into a single round trip.
+func TestRequestCache(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		ttl     time.Duration
+		lookups int
+	}{250 * time.Millisecond, 16}
+
+	// Register a new service to the relay and connect a caching client
+	handler := new(requestCacheTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	check := func(stage string, want int32) {
+		if have := atomic.LoadInt32(&handler.served); have != want {
+			t.Fatalf("%s: served request count mismatch: have %d, want %d.", stage, have, want)
+		}
+	}
+	// Verify that requests are always issued while the cache is disabled
+	for i := 0; i < 2; i++ {
+		if _, err := conn.RequestCached(config.cluster, []byte("query"), time.Second); err != nil {
+			t.Fatalf("uncached request failed: %v.", err)
+		}
+	}
+	check("disabled", 2)
+
+	// Enable the cache and issue concurrent lookups of the same request
+	conn.EnableRequestCache(&RequestCacheConfig{TTL: conf.ttl})
+
+	var pend sync.WaitGroup
+	for i := 0; i < conf.lookups; i++ {
+		pend.Add(1)
+		go func() {
+			defer pend.Done()
+			if reply, err := conn.RequestCached(config.cluster, []byte("query"), time.Second); err != nil || string(reply) != "query" {
+				t.Errorf("cached request mismatch: have %q/%v, want %q.", reply, err, "query")
+			}
+		}()
+	}
+	pend.Wait()
+	check("coalesced", 3)
+
+	// Verify that a different request misses, and a repeated one hits
+	for _, req := range []string{"other", "other", "query"} {
+		if _, err := conn.RequestCached(config.cluster, []byte(req), time.Second); err != nil {
+			t.Fatalf("cached request failed: %v.", err)
+		}
+	}
+	check("hits", 4)
+
+	// Verify that the replies expire after the TTL
+	time.Sleep(conf.ttl)
+	if _, err := conn.RequestCached(config.cluster, []byte("query"), time.Second); err != nil {
+		t.Fatalf("expired request failed: %v.", err)
+	}
+	check("expired", 5)
+}
+
+// Tests that the request cache evicts the least recently used replies beyond
+// its entry and memory limits.
+func TestRequestCacheEviction(t *testing.T) {
+	cache := &requestCache{
+		config:  finalizeRequestCacheConfig(&RequestCacheConfig{Entries: 3, Memory: 10}),
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+	}
+	store := func(key string, size int) {
+		entry, _ := cache.claim(key)
+		entry.reply = bytes.Repeat([]byte{0x00}, size)
+		cache.complete(entry)
+	}
+	store("a", 4)
+	store("b", 4)
+	store("c", 1)
+	if _, ok := cache.entries["a"]; !ok || cache.used != 9 {
+		t.Fatalf("cache contents mismatch: a cached %v, used %d.", ok, cache.used)
+	}
+	// Overflow the entry limit, evicting the oldest
+	store("d", 1)
+	if _, ok := cache.entries["a"]; ok {
+		t.Fatalf("least recently used entry not evicted by count.")
+	}
+	// Overflow the memory limit, evicting the oldest until it fits
+	store("e", 9)
+	if len(cache.entries) != 2 || cache.used != 10 {
+		t.Fatalf("memory eviction mismatch: have %d entries, %d bytes.", len(cache.entries), cache.used)
+	}
+	// Verify that oversized replies are not cached at all
+	store("f", 11)
+	if _, ok := cache.entries["f"]; ok || cache.used != 10 {
+		t.Fatalf("oversized reply cached: used %d.", cache.used)
+	}
+}
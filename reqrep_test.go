@@ -7,6 +7,8 @@
 package iris
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"sync"
@@ -42,6 +44,22 @@ func (r *requestFailTestHandler) HandleRequest(req []byte) ([]byte, error) {
 	return nil, errors.New(string(req))
 }
 
+// Service handler for the structured error tests, failing with the request's
+// first byte as the error code.
+type requestStructuredFailTestHandler struct {
+	conn *Connection
+}
+
+func (r *requestStructuredFailTestHandler) Init(conn *Connection) error { r.conn = conn; return nil }
+func (r *requestStructuredFailTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *requestStructuredFailTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *requestStructuredFailTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (r *requestStructuredFailTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	err := &Error{Code: ErrorCode(req[0]), Message: "structured failure", Details: req[1:]}
+	return nil, fmt.Errorf("wrapped: %w", err)
+}
+
 // Tests multiple concurrent client and service requests.
 func TestRequest(t *testing.T) {
 	// Test specific configurations
@@ -157,6 +175,52 @@ func TestRequestFail(t *testing.T) {
 	}
 }
 
+// Tests the context based request deadlines and cancellations.
+func TestRequestContext(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		sleep time.Duration
+	}{25 * time.Millisecond}
+
+	// Create the service handler
+	handler := &requestTestTimedHandler{
+		sleep: conf.sleep,
+	}
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Check that requests without a deadline are rejected
+	if rep, err := handler.conn.RequestCtx(context.Background(), config.cluster, []byte{0x00}); err == nil {
+		t.Fatalf("deadline-less request succeeded: %v.", rep)
+	}
+	// Check that the context deadline is complied with
+	ctx, cancel := context.WithTimeout(context.Background(), conf.sleep*2)
+	defer cancel()
+	if _, err := handler.conn.RequestCtx(ctx, config.cluster, []byte{0x00}); err != nil {
+		t.Fatalf("longer deadline failed: %v.", err)
+	}
+	ctx, cancel = context.WithTimeout(context.Background(), conf.sleep/2)
+	defer cancel()
+	if rep, err := handler.conn.RequestCtx(ctx, config.cluster, []byte{0x00}); err == nil {
+		t.Fatalf("shorter deadline succeeded: %v.", rep)
+	}
+	// Check that cancellation aborts the request before the deadline
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	time.AfterFunc(conf.sleep/2, cancel)
+	if rep, err := handler.conn.RequestCtx(ctx, config.cluster, []byte{0x00}); err != context.Canceled {
+		t.Fatalf("cancellation mismatch: have %v/%v, want %v/%v.", rep, err, nil, context.Canceled)
+	}
+	// Make sure the abandoned request's reply doesn't break the connection
+	time.Sleep(conf.sleep)
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, time.Second); err != nil {
+		t.Fatalf("request after cancellation failed: %v.", err)
+	}
+}
+
 // Service handler for the request/reply limit tests.
 type requestTestTimedHandler struct {
 	conn  *Connection
@@ -283,6 +347,132 @@ func TestRequestMemoryLimit(t *testing.T) {
 	}
 }
 
+// Tests that overloaded services fail excess requests fast if requested.
+func TestRequestOverloadRejection(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		sleep time.Duration
+	}{100 * time.Millisecond}
+
+	// Create the service handler and limiter
+	handler := &requestTestTimedHandler{
+		sleep: conf.sleep,
+	}
+	limits := &ServiceLimits{RequestThreads: 1, RequestQueue: 1, RejectOverload: true}
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, limits)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Occupy the single handler thread and the single queue slot
+	errc := make(chan error, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			_, err := handler.conn.Request(config.cluster, []byte{0x00}, 4*conf.sleep)
+			errc <- err
+		}()
+		time.Sleep(conf.sleep / 5)
+	}
+	// Check that an excess request is rejected without waiting for the timeout
+	start := time.Now()
+	_, err = handler.conn.Request(config.cluster, []byte{0x00}, 4*conf.sleep)
+
+	var rerr *RemoteError
+	if !errors.As(err, &rerr) || rerr.Code != CodeUnavailable || !errors.Is(err, ErrOverloaded) {
+		t.Fatalf("excess request result mismatch: have %v, want %v.", err, ErrOverloaded)
+	}
+	if elapsed := time.Since(start); elapsed > conf.sleep {
+		t.Fatalf("excess request rejection too slow: have %v, want below %v.", elapsed, conf.sleep)
+	}
+	// Check that the admitted requests complete
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("admitted request #%d failed: %v.", i, err)
+		}
+	}
+}
+
+// Service handler for the request prioritization tests, recording the order in
+// which the requests are handled.
+type requestTestPrioHandler struct {
+	conn    *Connection
+	sleep   time.Duration
+	handled []byte
+	lock    sync.Mutex
+}
+
+func (r *requestTestPrioHandler) Init(conn *Connection) error { r.conn = conn; return nil }
+func (r *requestTestPrioHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *requestTestPrioHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *requestTestPrioHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (r *requestTestPrioHandler) HandleRequest(req []byte) ([]byte, error) {
+	time.Sleep(r.sleep)
+
+	r.lock.Lock()
+	r.handled = append(r.handled, req[1])
+	r.lock.Unlock()
+
+	return req, nil
+}
+
+// Tests that high priority requests are handled ahead of the queued normal ones
+// and are admitted even if the normal queue is full.
+func TestRequestPriority(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		sleep time.Duration
+	}{50 * time.Millisecond}
+
+	// Create the service handler and limiter, prioritizing [1, id] requests
+	handler := &requestTestPrioHandler{
+		sleep: conf.sleep,
+	}
+	limits := &ServiceLimits{
+		RequestThreads: 1,
+		RequestQueue:   2,
+		RequestPriority: func(ctx context.Context, req []byte) Priority {
+			if req[0] == 1 {
+				return PriorityHigh
+			}
+			return PriorityNormal
+		},
+	}
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, limits)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Occupy the handler thread and fill the queue, then issue an urgent request
+	reqs := [][]byte{{0, 0}, {0, 1}, {0, 2}, {1, 3}}
+
+	errc := make(chan error, len(reqs))
+	for _, req := range reqs {
+		go func(req []byte) {
+			_, err := handler.conn.Request(config.cluster, req, 10*conf.sleep)
+			errc <- err
+		}(req)
+		time.Sleep(conf.sleep / 5)
+	}
+	for i := 0; i < len(reqs); i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("request #%d failed: %v.", i, err)
+		}
+	}
+	// Verify that the urgent request jumped ahead of the queued ones
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
+
+	if want := []byte{0, 3, 1, 2}; !bytes.Equal(handler.handled, want) {
+		t.Fatalf("handling order mismatch: have %v, want %v.", handler.handled, want)
+	}
+}
+
 // Service handler for the request/reply expiry tests.
 type requestTestExpiryHandler struct {
 	conn  *Connection
@@ -339,6 +529,95 @@ func TestRequestExpiration(t *testing.T) {
 	}
 }
 
+// Service handler for the deadline propagation tests.
+type requestDeadlineTestHandler struct {
+	conn      *Connection
+	abandoned chan time.Duration
+}
+
+func (r *requestDeadlineTestHandler) Init(conn *Connection) error { r.conn = conn; return nil }
+func (r *requestDeadlineTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *requestDeadlineTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *requestDeadlineTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+func (r *requestDeadlineTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	panic("not implemented")
+}
+
+// Waits for the request context to expire, reporting the time it took.
+func (r *requestDeadlineTestHandler) HandleRequestCtx(ctx context.Context, req []byte) ([]byte, error) {
+	start := time.Now()
+	if _, ok := ctx.Deadline(); !ok {
+		return nil, errors.New("no deadline")
+	}
+	select {
+	case <-ctx.Done():
+		r.abandoned <- time.Since(start)
+	case <-time.After(time.Second):
+	}
+	return req, nil
+}
+
+// Tests that structured errors deliver their code and details to the requester.
+func TestRequestStructuredFail(t *testing.T) {
+	// Register a new failing service to the relay
+	handler := new(requestStructuredFailTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Request a few failures and verify the codes and details
+	for _, req := range [][]byte{{byte(CodeNotFound)}, {byte(CodeInternal), 0x01, 0x02}} {
+		_, err := handler.conn.Request(config.cluster, req, time.Second)
+
+		var rerr *RemoteError
+		if !errors.As(err, &rerr) {
+			t.Fatalf("error type mismatch: have %v, want remote error.", err)
+		}
+		if rerr.Error() != "structured failure" {
+			t.Fatalf("message mismatch: have %s, want %s.", rerr.Error(), "structured failure")
+		}
+		if rerr.Code != ErrorCode(req[0]) {
+			t.Fatalf("code mismatch: have %v, want %v.", rerr.Code, ErrorCode(req[0]))
+		}
+		if string(rerr.Details) != string(req[1:]) {
+			t.Fatalf("details mismatch: have %v, want %v.", rerr.Details, req[1:])
+		}
+	}
+}
+
+// Tests that the requester's timeout is propagated to the handler's context.
+func TestRequestDeadline(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		timeout time.Duration
+	}{50 * time.Millisecond}
+
+	// Register a new service to the relay
+	handler := &requestDeadlineTestHandler{
+		abandoned: make(chan time.Duration, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Issue a request the handler never finishes in time
+	if _, err := handler.conn.Request(config.cluster, []byte{0x00}, conf.timeout); err != ErrTimeout {
+		t.Fatalf("request result mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	select {
+	case elapsed := <-handler.abandoned:
+		if elapsed > 2*conf.timeout {
+			t.Fatalf("handler abandoned too late: have %v, want ~%v.", elapsed, conf.timeout)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("handler context not expired.")
+	}
+}
+
 // Benchmarks the latency of a single request/reply operation.
 func BenchmarkRequestLatency(b *testing.B) {
 	// Create the service handler
@@ -364,38 +643,52 @@ func BenchmarkRequestLatency(b *testing.B) {
 
 // Benchmarks the throughput of a stream of concurrent requests.
 func BenchmarkRequestThroughput1Threads(b *testing.B) {
-	benchmarkRequestThroughput(1, b)
+	benchmarkRequestThroughput(1, nil, b)
 }
 
 func BenchmarkRequestThroughput2Threads(b *testing.B) {
-	benchmarkRequestThroughput(2, b)
+	benchmarkRequestThroughput(2, nil, b)
 }
 
 func BenchmarkRequestThroughput4Threads(b *testing.B) {
-	benchmarkRequestThroughput(4, b)
+	benchmarkRequestThroughput(4, nil, b)
 }
 
 func BenchmarkRequestThroughput8Threads(b *testing.B) {
-	benchmarkRequestThroughput(8, b)
+	benchmarkRequestThroughput(8, nil, b)
 }
 
 func BenchmarkRequestThroughput16Threads(b *testing.B) {
-	benchmarkRequestThroughput(16, b)
+	benchmarkRequestThroughput(16, nil, b)
 }
 
 func BenchmarkRequestThroughput32Threads(b *testing.B) {
-	benchmarkRequestThroughput(32, b)
+	benchmarkRequestThroughput(32, nil, b)
 }
 
 func BenchmarkRequestThroughput64Threads(b *testing.B) {
-	benchmarkRequestThroughput(64, b)
+	benchmarkRequestThroughput(64, nil, b)
 }
 
 func BenchmarkRequestThroughput128Threads(b *testing.B) {
-	benchmarkRequestThroughput(128, b)
+	benchmarkRequestThroughput(128, nil, b)
+}
+
+// Benchmarks the throughput of a stream of concurrent requests pipelined through
+// batched socket writes.
+func BenchmarkRequestThroughputBatched1Threads(b *testing.B) {
+	benchmarkRequestThroughput(1, &FlushPolicy{}, b)
+}
+
+func BenchmarkRequestThroughputBatched16Threads(b *testing.B) {
+	benchmarkRequestThroughput(16, &FlushPolicy{}, b)
+}
+
+func BenchmarkRequestThroughputBatched128Threads(b *testing.B) {
+	benchmarkRequestThroughput(128, &FlushPolicy{}, b)
 }
 
-func benchmarkRequestThroughput(threads int, b *testing.B) {
+func benchmarkRequestThroughput(threads int, flush *FlushPolicy, b *testing.B) {
 	// Create the service handler
 	handler := new(requestTestHandler)
 
@@ -406,6 +699,9 @@ func benchmarkRequestThroughput(threads int, b *testing.B) {
 	}
 	defer serv.Unregister()
 
+	if flush != nil {
+		handler.conn.SetFlushPolicy(flush)
+	}
 	// Create the thread pool with the concurrent requests
 	workers := pool.NewThreadPool(threads)
 	for i := 0; i < b.N; i++ {
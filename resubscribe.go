@@ -0,0 +1,286 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the tracking of the topic subscriptions' state on the relay link and
+// the reconciliation of the failed ones.
+//
+// The subscriptions in the connection's map are the desired state, while their
+// status reflects whether the relay was actually told about them. Failures of the
+// initial subscribe or of the replay after a reconnect mark a subscription
+// failed, and if resubscription is enabled, a background reconciler keeps
+// retrying the failed ones with exponential backoff until they all succeed.
+
+package iris
+
+import (
+	"time"
+)
+
+// State of a topic subscription on the relay link.
+type SubscriptionState int
+
+const (
+	SubscriptionPending SubscriptionState = iota // Being forwarded to the relay (e.g. awaiting a reconnect)
+	SubscriptionActive                           // Forwarded to the relay, events flowing
+	SubscriptionFailed                           // Forwarding failed, retried if resubscription is enabled
+)
+
+func (s SubscriptionState) String() string {
+	switch s {
+	case SubscriptionPending:
+		return "pending"
+	case SubscriptionActive:
+		return "active"
+	case SubscriptionFailed:
+		return "failed"
+	default:
+		return "unknown"
+	}
+}
+
+// Status of a topic subscription on the relay link.
+type SubscriptionStatus struct {
+	State    SubscriptionState // Current state of the subscription
+	Attempts int               // Failed attempts since the subscription was last active
+	Err      error             // Failure of the last attempt, nil if it succeeded
+	Since    time.Time         // Time the subscription entered its current state
+}
+
+// User policy of the retrying of failed topic subscriptions.
+type ResubscribePolicy struct {
+	Attempts   int           // Retries of a failed subscription before giving up (negative for unlimited)
+	MinBackoff time.Duration // Delay before the first retry
+	MaxBackoff time.Duration // Maximum delay between consecutive retries
+}
+
+// Default policy of the retrying of failed topic subscriptions.
+var defaultResubscribePolicy = ResubscribePolicy{
+	Attempts:   -1,
+	MinBackoff: 100 * time.Millisecond,
+	MaxBackoff: 30 * time.Second,
+}
+
+// Enables the retrying of failed topic subscriptions in the background. Once
+// enabled, a Subscribe failing to reach the relay keeps the subscription and
+// returns nil, the failure being reported by SubscriptionStatus until a retry
+// succeeds. Subscriptions failing to be restored after a reconnect are retried
+// too.
+//
+// Any unset fields (i.e. value of zero) of the policy will default to the preset
+// ones.
+func (c *Connection) EnableResubscribe(policy *ResubscribePolicy) {
+	c.subLock.Lock()
+	c.resubPolicy = finalizeResubscribePolicy(policy)
+	c.subLock.Unlock()
+
+	c.reconcileSubscriptions()
+}
+
+// Disables the retrying of failed topic subscriptions. Already failed ones are
+// left failed.
+func (c *Connection) DisableResubscribe() {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	c.resubPolicy = nil
+}
+
+// Merges the user requested policy with the defaults.
+func finalizeResubscribePolicy(user *ResubscribePolicy) *ResubscribePolicy {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultResubscribePolicy
+	}
+	// Check each field and merge only non-specified ones
+	policy := new(ResubscribePolicy)
+	*policy = *user
+
+	if user.Attempts == 0 {
+		policy.Attempts = defaultResubscribePolicy.Attempts
+	}
+	if user.MinBackoff == 0 {
+		policy.MinBackoff = defaultResubscribePolicy.MinBackoff
+	}
+	if user.MaxBackoff == 0 {
+		policy.MaxBackoff = defaultResubscribePolicy.MaxBackoff
+	}
+	return policy
+}
+
+// Retrieves the status of a topic subscription on the relay link, or fails with
+// ErrNotSubscribed if the topic is not subscribed to.
+func (c *Connection) SubscriptionStatus(topic string) (SubscriptionStatus, error) {
+	c.subLock.RLock()
+	top, ok := c.subLive[topic]
+	c.subLock.RUnlock()
+
+	if !ok {
+		return SubscriptionStatus{}, ErrNotSubscribed
+	}
+	return top.subscription(), nil
+}
+
+// Retrieves the status of the subscription on the relay link.
+func (t *topic) subscription() SubscriptionStatus {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	return t.status
+}
+
+// Records the outcome of forwarding the subscription to the relay.
+func (t *topic) markSubscribed(err error) {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	state := SubscriptionActive
+	if err != nil {
+		state = SubscriptionFailed
+		t.status.Attempts++
+	} else {
+		t.status.Attempts = 0
+	}
+	if state != t.status.State {
+		t.status.State, t.status.Since = state, time.Now()
+	}
+	t.status.Err = err
+}
+
+// Adopts the status of another subscription sharing the same relay topic.
+func (t *topic) inheritStatus(sibling *topic) {
+	if sibling == nil {
+		return
+	}
+	status := sibling.subscription()
+
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	t.status = status
+}
+
+// Marks the subscription as waiting to be forwarded anew to the relay.
+func (t *topic) markPending() {
+	t.statusLock.Lock()
+	defer t.statusLock.Unlock()
+
+	if t.status.State != SubscriptionPending {
+		t.status.State, t.status.Since = SubscriptionPending, time.Now()
+	}
+}
+
+// Marks all the subscriptions as waiting to be restored on a new relay link.
+func (c *Connection) markSubscriptionsPending() {
+	c.subLock.RLock()
+	defer c.subLock.RUnlock()
+
+	for _, top := range c.subLive {
+		top.markPending()
+	}
+}
+
+// Retrieves the topic a subscription is forwarded to the relay as: the fan-in
+// topic for patterns, the topic itself otherwise.
+func remoteTopic(name string) string {
+	if pattern, _ := parsePattern(name); pattern {
+		return patternFanIn(name)
+	}
+	return name
+}
+
+// Finds another pattern subscription sharing a fan-in topic, nil if none. The
+// subscription lock is assumed to be held.
+func (c *Connection) faninSibling(fanin string, top *topic) *topic {
+	for name, sibling := range c.subLive {
+		if sibling != top && remoteTopic(name) == fanin {
+			return sibling
+		}
+	}
+	return nil
+}
+
+// Starts the background reconciler if resubscription is enabled, there are
+// failed subscriptions to retry and it is not running yet.
+func (c *Connection) reconcileSubscriptions() {
+	c.subLock.Lock()
+	defer c.subLock.Unlock()
+
+	if c.resubPolicy == nil || c.resubBusy || len(c.retriableSubscriptions()) == 0 {
+		return
+	}
+	c.resubBusy = true
+	go c.reconcile()
+}
+
+// Collects the failed subscriptions to retry, grouped by the topic to forward
+// to the relay. The subscription lock is assumed to be held.
+func (c *Connection) retriableSubscriptions() map[string][]*topic {
+	retry := make(map[string][]*topic)
+	for name, top := range c.subLive {
+		status := top.subscription()
+		if status.State != SubscriptionFailed {
+			continue
+		}
+		if c.resubPolicy.Attempts >= 0 && status.Attempts > c.resubPolicy.Attempts {
+			continue
+		}
+		remote := remoteTopic(name)
+		retry[remote] = append(retry[remote], top)
+	}
+	return retry
+}
+
+// Keeps retrying the failed subscriptions with exponential backoff until none
+// are left to retry, resubscription is disabled or the connection terminates.
+func (c *Connection) reconcile() {
+	c.subLock.RLock()
+	backoff := c.resubPolicy.MinBackoff
+	c.subLock.RUnlock()
+
+	for {
+		select {
+		case <-c.term:
+			c.subLock.Lock()
+			c.resubBusy = false
+			c.subLock.Unlock()
+			return
+		case <-time.After(backoff):
+		}
+		// Retry the failed subscriptions, serialized with the reconnect replays
+		c.subSync.Lock()
+		c.subLock.RLock()
+		var retry map[string][]*topic
+		if c.resubPolicy != nil {
+			retry = c.retriableSubscriptions()
+		}
+		c.subLock.RUnlock()
+
+		for remote, tops := range retry {
+			err := c.sendSubscribe(remote)
+			if err != nil {
+				c.Log.Warn("failed to retry subscription", "topic", remote, "reason", err)
+			} else {
+				c.Log.Info("failed subscription restored", "topic", remote)
+			}
+			for _, top := range tops {
+				top.markSubscribed(err)
+			}
+		}
+		c.subSync.Unlock()
+
+		// Stop if nothing is left to retry, otherwise back off further
+		c.subLock.Lock()
+		if c.resubPolicy == nil || len(c.retriableSubscriptions()) == 0 {
+			c.resubBusy = false
+			c.subLock.Unlock()
+			return
+		}
+		if backoff *= 2; backoff > c.resubPolicy.MaxBackoff {
+			backoff = c.resubPolicy.MaxBackoff
+		}
+		c.subLock.Unlock()
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"testing"
+	"time"
+
+	"gopkg.in/project-iris/iris-go.v1/iristest"
+)
+
+// Waits until the subscription of a topic reaches the given state.
+func waitSubscriptionState(t *testing.T, conn *Connection, topic string, state SubscriptionState) SubscriptionStatus {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status, err := conn.SubscriptionStatus(topic)
+		if err != nil {
+			t.Fatalf("failed to retrieve %s subscription status: %v.", topic, err)
+		}
+		if status.State == state {
+			return status
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%s subscription state mismatch: have %v, want %v.", topic, status.State, state)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// Tests that subscriptions failing during a relay outage are retained and get
+// reconciled once the relay is back, with their status tracking the progress.
+func TestResubscribe(t *testing.T) {
+	// Start a private relay and connect with reconnection and resubscription
+	relay, err := iristest.NewRelay(0)
+	if err != nil {
+		t.Fatalf("failed to start relay: %v.", err)
+	}
+	port := relay.Port()
+
+	conn, err := Connect(port,
+		WithReconnect(&ReconnectPolicy{Attempts: -1, MinBackoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}),
+		WithResubscribe(&ResubscribePolicy{MinBackoff: 50 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}))
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Subscribe while the relay is up and verify the subscription is active
+	live := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conn.Subscribe("live", live, nil); err != nil {
+		t.Fatalf("failed to subscribe: %v.", err)
+	}
+	if status := waitSubscriptionState(t, conn, "live", SubscriptionActive); status.Err != nil || status.Attempts != 0 {
+		t.Fatalf("active status mismatch: have %+v.", status)
+	}
+	// Kill the relay, subscribe during the outage and verify the failure is kept
+	relay.Close()
+	waitSubscriptionState(t, conn, "live", SubscriptionPending)
+
+	late := &publishTestTopicHandler{delivers: make(chan []byte, 1)}
+	if err := conn.Subscribe("late", late, nil); err != nil {
+		t.Fatalf("failed to subscribe during outage: %v.", err)
+	}
+	if status, err := conn.SubscriptionStatus("late"); err != nil || status.State == SubscriptionActive {
+		t.Fatalf("outage status mismatch: have %+v/%v, want inactive.", status, err)
+	}
+	if status := waitSubscriptionState(t, conn, "late", SubscriptionFailed); status.Err == nil || status.Attempts == 0 {
+		t.Fatalf("failed status mismatch: have %+v.", status)
+	}
+	// Restart the relay and verify both subscriptions get restored
+	if relay, err = iristest.NewRelay(port); err != nil {
+		t.Fatalf("failed to restart relay: %v.", err)
+	}
+	defer relay.Close()
+
+	waitSubscriptionState(t, conn, "live", SubscriptionActive)
+	waitSubscriptionState(t, conn, "late", SubscriptionActive)
+
+	publisher, err := Connect(port)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer publisher.Close()
+
+	for topic, handler := range map[string]*publishTestTopicHandler{"live": live, "late": late} {
+		if err := publisher.Publish(topic, []byte(topic)); err != nil {
+			t.Fatalf("failed to publish: %v.", err)
+		}
+		select {
+		case event := <-handler.delivers:
+			if string(event) != topic {
+				t.Fatalf("event mismatch: have %q, want %q.", event, topic)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s event not delivered.", topic)
+		}
+	}
+	// Unsubscribe and verify the status is gone
+	if err := conn.Unsubscribe("late"); err != nil {
+		t.Fatalf("failed to unsubscribe: %v.", err)
+	}
+	if _, err := conn.SubscriptionStatus("late"); err != ErrNotSubscribed {
+		t.Fatalf("unsubscribed status error mismatch: have %v, want %v.", err, ErrNotSubscribed)
+	}
+}
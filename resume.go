@@ -0,0 +1,173 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the resumable content transfers, which, if interrupted by a failing
+// tunnel, can be continued over a new one from the content the receiver already
+// stored, instead of restarting from zero.
+//
+// A resumable transfer opens with a header carrying its ID, to which the
+// receiver replies with the number of bytes it already has (or a fault if it
+// refuses the transfer). The sender then streams the rest of the content
+// exactly as SendStream would.
+
+package iris
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+)
+
+// Callback opening the destination of a resumable transfer, returning the writer
+// to append the content to and the number of content bytes already stored from
+// previous attempts.
+type TransferOpener func(id string) (io.Writer, int64, error)
+
+// Streams the content of a seekable reader to the remote endpoint as a resumable
+// transfer identified by id. The remote side needs to consume it via
+// RecvResumable, which reports the number of bytes it already has; only the
+// content from that offset onward is sent. If the tunnel fails, calling the
+// method again with the same id over a new tunnel continues the transfer.
+//
+// The returned count and the progress callback are absolute, including the
+// content stored by the previous attempts. See SendStream for the details.
+func (t *Tunnel) SendResumable(id string, content io.ReadSeeker, progress TransferProgress) (int64, error) {
+	if id == "" {
+		return 0, invalidArgument("empty transfer id")
+	}
+	atomic.AddInt32(&t.sending, 1)
+	defer atomic.AddInt32(&t.sending, -1)
+
+	// Hold the tunnel for the whole transfer
+	t.sendLock.Lock()
+	defer t.sendLock.Unlock()
+
+	// Announce the transfer and wait for the offset to resume from
+	if err := t.sendPiece(append([]byte{transferResume}, id...)); err != nil {
+		return 0, err
+	}
+	frame, err := t.Recv(0)
+	switch {
+	case err == io.EOF:
+		return 0, io.ErrUnexpectedEOF
+	case err != nil:
+		return 0, err
+	case frame[0] == transferFault:
+		return 0, &RemoteError{Reason: string(frame[1:])}
+	case frame[0] != transferOffset:
+		return 0, fmt.Errorf("%w: invalid transfer frame", ErrProtocolViolation)
+	}
+	offset, n := binary.Uvarint(frame[1:])
+	if n <= 0 {
+		return 0, fmt.Errorf("%w: invalid transfer offset", ErrProtocolViolation)
+	}
+	// Skip the content the remote side already has, and stream the rest
+	size, err := content.Seek(0, io.SeekEnd)
+	if err == nil {
+		if int64(offset) > size {
+			err = fmt.Errorf("resume offset %d beyond content size %d", offset, size)
+		} else {
+			_, err = content.Seek(int64(offset), io.SeekStart)
+		}
+	}
+	if err != nil {
+		t.Log.Debug("resumable transfer failed", "id", id, "reason", err)
+		if err := t.sendPiece(append([]byte{transferFault}, err.Error()...)); err != nil {
+			return 0, err
+		}
+		return 0, err
+	}
+	if offset > 0 {
+		t.Log.Debug("resuming transfer", "id", id, "offset", offset)
+	}
+	return t.sendPieces(content, int64(offset), progress)
+}
+
+// Receives a resumable transfer sent via SendResumable from the remote endpoint.
+// The opener is called with the transfer's id to retrieve the destination and
+// the number of bytes already stored in it, and the content is appended from
+// that offset onward. If opening fails, the transfer is refused on the remote
+// side too.
+//
+// The returned count and the progress callback are absolute, including the
+// content stored by the previous attempts. See RecvStream for the details.
+func (t *Tunnel) RecvResumable(open TransferOpener, progress TransferProgress) (string, int64, error) {
+	// Fetch the transfer header and open its destination
+	frame, err := t.Recv(0)
+	switch {
+	case err == io.EOF:
+		return "", 0, io.ErrUnexpectedEOF
+	case err != nil:
+		return "", 0, err
+	case frame[0] != transferResume:
+		return "", 0, fmt.Errorf("%w: invalid transfer frame", ErrProtocolViolation)
+	}
+	id := string(frame[1:])
+
+	writer, offset, err := open(id)
+	if err == nil && offset < 0 {
+		err = fmt.Errorf("negative resume offset %d", offset)
+	}
+	if err != nil {
+		if err := t.Send(append([]byte{transferFault}, err.Error()...), 0); err != nil {
+			return id, 0, err
+		}
+		return id, 0, err
+	}
+	// Report the stored content and receive the rest
+	reply := make([]byte, 1+binary.MaxVarintLen64)
+	reply[0] = transferOffset
+	if err := t.Send(reply[:1+binary.PutUvarint(reply[1:], uint64(offset))], 0); err != nil {
+		return id, offset, err
+	}
+	recvd, err := t.recvPieces(writer, offset, progress)
+	return id, recvd, err
+}
+
+// Streams the content of a local file to the remote endpoint as a resumable
+// transfer. See SendResumable for the details.
+func (t *Tunnel) SendFileResumable(id string, path string, progress TransferProgress) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return t.SendResumable(id, file, progress)
+}
+
+// Receives a resumable transfer from the remote endpoint into a file named after
+// the transfer's id within the given directory, appending to it if already
+// present. If the transfer fails, the partial file is kept for a later resume.
+// Transfer ids that are not plain file names are refused. See RecvResumable for
+// the details.
+func (t *Tunnel) RecvFileResumable(dir string, progress TransferProgress) (string, int64, error) {
+	var file *os.File
+	open := func(id string) (io.Writer, int64, error) {
+		if id != filepath.Base(id) || id == "." || id == ".." {
+			return nil, 0, invalidArgument("invalid transfer id %q", id)
+		}
+		var err error
+		if file, err = os.OpenFile(filepath.Join(dir, id), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644); err != nil {
+			return nil, 0, err
+		}
+		info, err := file.Stat()
+		if err != nil {
+			return nil, 0, err
+		}
+		return file, info.Size(), nil
+	}
+	id, recvd, err := t.RecvResumable(open, progress)
+	if file != nil {
+		if cerr := file.Close(); err == nil {
+			err = cerr
+		}
+	}
+	return id, recvd, err
+}
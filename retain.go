@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the retained last-value events of topics, replayed to late joining
+// subscribers.
+//
+// Since the relay does not retain events, the retaining publishers listen for
+// replay queries on a companion topic and answer them on the original topic
+// behind a magic prefix, which subscribers on older bindings receive as part of
+// the event.
+
+package iris
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"time"
+)
+
+// Magic prefix marking retained event queries and replays.
+var retainMagic = []byte("\x00iris-retain\x00")
+
+// Event retained as the last value of a topic.
+type retainedEvent struct {
+	event     []byte    // Application payload of the event
+	published time.Time // Time instance the event was published at
+}
+
+// Publishes an event to topic like Publish, additionally retaining it as the
+// last value of the topic. Subscribers joining later with TopicLimits.Replay set
+// will receive the retained event upon subscribing, as long as the connection
+// is alive and the retention is not cleared.
+func (c *Connection) PublishRetained(topic string, event []byte) error {
+	if err := c.Publish(topic, event); err != nil {
+		return err
+	}
+	c.retainLock.Lock()
+	defer c.retainLock.Unlock()
+
+	if c.retained == nil {
+		c.retained = make(map[string]*retainedEvent)
+	}
+	// Start answering replay queries with the first retained event
+	if _, ok := c.retained[topic]; !ok {
+		answer := func(_ string, query []byte) { c.answerReplay(topic, query) }
+		if err := c.SubscribeFunc(retainTopic(topic), answer, nil); err != nil {
+			return err
+		}
+	}
+	c.retained[topic] = &retainedEvent{
+		event:     append([]byte(nil), event...),
+		published: time.Now(),
+	}
+	return nil
+}
+
+// Drops the retained event of topic, answering no more replay queries for it.
+func (c *Connection) ClearRetained(topic string) error {
+	c.retainLock.Lock()
+	defer c.retainLock.Unlock()
+
+	if _, ok := c.retained[topic]; !ok {
+		return invalidArgument("no retained event")
+	}
+	delete(c.retained, topic)
+	return c.Unsubscribe(retainTopic(topic))
+}
+
+// Answers a replay query with the retained event of the topic, if any.
+func (c *Connection) answerReplay(topic string, query []byte) {
+	if !bytes.HasPrefix(query, retainMagic) || len(query) != len(retainMagic)+8 {
+		c.Log.Warn("invalid replay query", "topic", topic)
+		return
+	}
+	nonce := binary.BigEndian.Uint64(query[len(retainMagic):])
+
+	c.retainLock.Lock()
+	retained := c.retained[topic]
+	c.retainLock.Unlock()
+
+	if retained == nil {
+		return
+	}
+	c.Log.Debug("replaying retained event", "topic", topic, "data", logLazyBlob(retained.event))
+	if err := c.sendPublish(topic, wrapReplay(nonce, retained.published, retained.event)); err != nil {
+		c.Log.Warn("failed to replay retained event", "topic", topic, "reason", err)
+	}
+}
+
+// Queries the retained event of a freshly subscribed topic.
+func (c *Connection) queryRetained(topic string, nonce uint64) error {
+	query := make([]byte, len(retainMagic)+8)
+	copy(query, retainMagic)
+	binary.BigEndian.PutUint64(query[len(retainMagic):], nonce)
+
+	return c.sendPublish(retainTopic(topic), query)
+}
+
+// Returns the companion topic the replay queries of topic are published to.
+func retainTopic(topic string) string {
+	return string(retainMagic) + topic
+}
+
+// Generates a random, non-zero nonce to match replays to their query.
+func newReplayId() uint64 {
+	blob := make([]byte, 8)
+	if _, err := rand.Read(blob); err != nil {
+		panic(err)
+	}
+	return binary.BigEndian.Uint64(blob) | 1
+}
+
+// Wraps a retained event into a replay answering the query with the given nonce.
+func wrapReplay(nonce uint64, published time.Time, event []byte) []byte {
+	blob := make([]byte, len(retainMagic)+16+len(event))
+	n := copy(blob, retainMagic)
+	binary.BigEndian.PutUint64(blob[n:], nonce)
+	binary.BigEndian.PutUint64(blob[n+8:], uint64(published.UnixNano()))
+	copy(blob[n+16:], event)
+	return blob
+}
+
+// Splits a replay into the query nonce, publish time and retained event, or
+// returns false if the event is not a replay.
+func unwrapReplay(blob []byte) (uint64, int64, []byte, bool) {
+	if !bytes.HasPrefix(blob, retainMagic) || len(blob) < len(retainMagic)+16 {
+		return 0, 0, nil, false
+	}
+	blob = blob[len(retainMagic):]
+	return binary.BigEndian.Uint64(blob), int64(binary.BigEndian.Uint64(blob[8:])), blob[16:], true
+}
+
+// Filters the replays arriving at a subscription, admitting only the answers to
+// its own query, fresher than any already admitted and not outdated by a live
+// event. Non-replay events are passed through.
+func (t *topic) admitReplay(event []byte) ([]byte, bool) {
+	nonce, published, payload, ok := unwrapReplay(event)
+	if !ok {
+		// Live event, any replay arriving afterwards is stale
+		if t.replayId != 0 {
+			t.eventLock.Lock()
+			t.replayLive = true
+			t.eventLock.Unlock()
+		}
+		return event, true
+	}
+	t.eventLock.Lock()
+	defer t.eventLock.Unlock()
+
+	if nonce != t.replayId || t.replayLive || published <= t.replayLast {
+		return nil, false
+	}
+	t.replayLast = published
+	return payload, true
+}
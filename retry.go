@@ -0,0 +1,153 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the automatic retry logic of failed idempotent requests.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"sync/atomic"
+	"time"
+)
+
+// User policy of the automatic retrying of failed idempotent requests.
+type RetryPolicy struct {
+	Attempts   int           // Total attempts of a request, including the first one
+	MinBackoff time.Duration // Delay before the first retry
+	MaxBackoff time.Duration // Maximum delay between consecutive attempts
+	Jitter     float64       // Fraction of each delay randomized (between 0 and 1)
+}
+
+// Default policy of the automatic retrying of failed idempotent requests.
+var defaultRetryPolicy = RetryPolicy{
+	Attempts:   3,
+	MinBackoff: 50 * time.Millisecond,
+	MaxBackoff: 2 * time.Second,
+	Jitter:     0.2,
+}
+
+// Context key marking the requests issued with it as idempotent.
+type idempotentKey struct{}
+
+// Enables the automatic retrying of idempotent requests failing with ErrTimeout
+// or ErrClosed, backing off exponentially between the attempts. Requests are
+// only retried if marked as idempotent (see Idempotent and RequestIdempotent),
+// since a timed out request might have been executed by the remote service.
+//
+// Any unset fields (i.e. value of zero) of the policy will default to the
+// preset ones.
+func (c *Connection) EnableRetry(policy *RetryPolicy) {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	c.retryPolicy = finalizeRetryPolicy(policy)
+}
+
+// Disables the automatic retrying of idempotent requests.
+func (c *Connection) DisableRetry() {
+	c.retryLock.Lock()
+	defer c.retryLock.Unlock()
+
+	c.retryPolicy = nil
+}
+
+// Merges the user requested policy with the defaults.
+func finalizeRetryPolicy(user *RetryPolicy) *RetryPolicy {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultRetryPolicy
+	}
+	// Check each field and merge only non-specified ones
+	policy := new(RetryPolicy)
+	*policy = *user
+
+	if user.Attempts <= 0 {
+		policy.Attempts = defaultRetryPolicy.Attempts
+	}
+	if user.MinBackoff == 0 {
+		policy.MinBackoff = defaultRetryPolicy.MinBackoff
+	}
+	if user.MaxBackoff == 0 {
+		policy.MaxBackoff = defaultRetryPolicy.MaxBackoff
+	}
+	if user.Jitter == 0 {
+		policy.Jitter = defaultRetryPolicy.Jitter
+	}
+	if policy.Jitter < 0 || policy.Jitter > 1 {
+		policy.Jitter = defaultRetryPolicy.Jitter
+	}
+	return policy
+}
+
+// Marks all requests issued with the returned context as idempotent, allowing
+// the connection to retry them automatically according to its retry policy.
+func Idempotent(ctx context.Context) context.Context {
+	return context.WithValue(ctx, idempotentKey{}, true)
+}
+
+// Checks whether a context marks its requests as idempotent.
+func isIdempotent(ctx context.Context) bool {
+	idempotent, _ := ctx.Value(idempotentKey{}).(bool)
+	return idempotent
+}
+
+// Executes a synchronous idempotent request to be serviced by a member of the
+// specified cluster, retrying it according to the connection's retry policy.
+//
+// The timeout is applied to each attempt individually.
+func (c *Connection) RequestIdempotent(cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	return c.request(Idempotent(context.Background()), cluster, request, timeout)
+}
+
+// Executes a request, retrying it on transient failures if it's idempotent and
+// retries are enabled. Attempts are bounded by the context deadline, if any.
+func (c *Connection) retryRequest(ctx context.Context, cluster string, request []byte, timeout time.Duration) ([]byte, error) {
+	c.retryLock.Lock()
+	policy := c.retryPolicy
+	c.retryLock.Unlock()
+
+	if policy == nil || !isIdempotent(ctx) {
+		return c.hedgeRequest(ctx, cluster, request, timeout)
+	}
+	backoff := policy.MinBackoff
+	for attempt := 1; ; attempt++ {
+		reply, err := c.hedgeRequest(ctx, cluster, request, timeout)
+		if err == nil || attempt >= policy.Attempts || !retryable(err) || atomic.LoadInt32(&c.closing) == 1 {
+			return reply, err
+		}
+		// Wait a jittered backoff before the next attempt
+		delay := backoff
+		if policy.Jitter > 0 {
+			delay += time.Duration((rand.Float64()*2 - 1) * policy.Jitter * float64(backoff))
+		}
+		c.Log.Debug("retrying failed request", "cluster", cluster, "attempt", attempt, "reason", err, "backoff", delay)
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if backoff *= 2; backoff > policy.MaxBackoff {
+			backoff = policy.MaxBackoff
+		}
+		// Shrink the timeout if the context deadline is closer
+		if deadline, ok := ctx.Deadline(); ok {
+			if left := deadline.Sub(time.Now()); left < timeout {
+				if timeout = left; timeout < time.Millisecond {
+					return nil, err
+				}
+			}
+		}
+	}
+}
+
+// Checks whether a request failure is transient, worth retrying.
+func retryable(err error) bool {
+	return errors.Is(err, ErrTimeout) || errors.Is(err, ErrClosed)
+}
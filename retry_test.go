@@ -0,0 +1,65 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Service handler for the retry tests, stalling the first few requests.
+type retryTestHandler struct {
+	stalls int32
+}
+
+func (r *retryTestHandler) Init(conn *Connection) error { return nil }
+func (r *retryTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *retryTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *retryTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (r *retryTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if atomic.AddInt32(&r.stalls, -1) >= 0 {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return req, nil
+}
+
+// Tests that only idempotent requests are retried after a timeout.
+func TestRequestRetry(t *testing.T) {
+	// Register a new service to the relay, stalling the first two requests
+	handler := &retryTestHandler{stalls: 2}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	conn.EnableRetry(&RetryPolicy{Attempts: 3, MinBackoff: time.Millisecond})
+
+	// Non-idempotent requests should fail on the first timeout
+	if _, err := conn.Request(config.cluster, []byte{0x01}, 25*time.Millisecond); err != ErrTimeout {
+		t.Fatalf("non-idempotent request error mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Idempotent requests should be retried until success
+	rep, err := conn.RequestIdempotent(config.cluster, []byte{0x02}, 25*time.Millisecond)
+	if err != nil {
+		t.Fatalf("idempotent request failed: %v.", err)
+	}
+	if len(rep) != 1 || rep[0] != 0x02 {
+		t.Fatalf("reply mismatch: have %v, want %v.", rep, []byte{0x02})
+	}
+	if sent := conn.Metrics().RequestsSent; sent != 3 {
+		t.Fatalf("request attempts mismatch: have %v, want %v.", sent, 3)
+	}
+}
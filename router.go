@@ -0,0 +1,197 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the method based routing of requests and streamed requests within a
+// single service.
+
+package iris
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Callback servicing the requests of a single routed method.
+type RouteHandler func(ctx context.Context, request []byte) ([]byte, error)
+
+// Request multiplexer dispatching method calls to the handlers registered for
+// them, allowing a single cluster registration to expose many logical
+// endpoints. It implements both HandleRequest and HandleRequestCtx, so service
+// handlers may embed it, or forward their requests to it.
+type Router struct {
+	routes map[string]RouteHandler // Handlers keyed by method name
+	codec  Codec                   // Codec of the typed handlers (see Handle)
+	lock   sync.RWMutex            // Mutex to protect the routing table and codec
+}
+
+// Creates a new, empty method router, its typed handlers using JSONCodec.
+func NewRouter() *Router {
+	return &Router{
+		routes: make(map[string]RouteHandler),
+		codec:  JSONCodec,
+	}
+}
+
+// Sets the codec the typed handlers registered via Handle serialize their
+// requests and replies with. Callers need to use the same one.
+func (r *Router) SetCodec(codec Codec) {
+	if codec == nil {
+		codec = JSONCodec
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	r.codec = codec
+}
+
+// Retrieves the codec of the typed handlers.
+func (r *Router) getCodec() Codec {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+
+	return r.codec
+}
+
+// Registers the handler of a method. It panics if the method is empty or
+// already registered, or if the handler is nil.
+func (r *Router) Handle(method string, handler RouteHandler) {
+	if len(method) == 0 {
+		panic("iris: empty method name")
+	}
+	if handler == nil {
+		panic("iris: nil route handler")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.routes[method]; ok {
+		panic(fmt.Sprintf("iris: duplicate route for method %s", method))
+	}
+	r.routes[method] = handler
+}
+
+// Dispatches a method call to the registered handler.
+func (r *Router) HandleRequest(request []byte) ([]byte, error) {
+	return r.HandleRequestCtx(context.Background(), request)
+}
+
+// Dispatches a method call to the registered handler, passing along the request
+// context. Malformed calls and unknown methods fail with a structured error.
+func (r *Router) HandleRequestCtx(ctx context.Context, request []byte) ([]byte, error) {
+	method, payload, err := decodeCall(request)
+	if err != nil {
+		return nil, &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	r.lock.RLock()
+	handler, ok := r.routes[method]
+	r.lock.RUnlock()
+
+	if !ok {
+		return nil, &Error{Code: CodeNotFound, Message: fmt.Sprintf("unknown method %s", method)}
+	}
+	return handler(ctx, payload)
+}
+
+// Executes a synchronous method call to be serviced by a member of the
+// specified cluster, routed by its Router. See Request for the details.
+func (c *Connection) Call(cluster string, method string, request []byte, timeout time.Duration) ([]byte, error) {
+	if len(method) == 0 {
+		return nil, invalidArgument("empty method name")
+	}
+	return c.request(context.Background(), cluster, encodeCall(method, request), timeout)
+}
+
+// Executes a synchronous method call to be serviced by a member of the
+// specified cluster, routed by its Router. See RequestCtx for the details.
+func (c *Connection) CallCtx(ctx context.Context, cluster string, method string, request []byte) ([]byte, error) {
+	if len(method) == 0 {
+		return nil, invalidArgument("empty method name")
+	}
+	return c.RequestCtx(ctx, cluster, encodeCall(method, request))
+}
+
+// Callback servicing the streamed requests of a single routed method.
+type StreamRouteHandler func(request []byte, replies *ReplyWriter) error
+
+// Streamed request multiplexer dispatching method calls to the handlers
+// registered for them. It implements StreamHandler, so services may pass it to
+// ServeStream from their HandleTunnel callback.
+type StreamRouter struct {
+	routes map[string]StreamRouteHandler // Handlers keyed by method name
+	lock   sync.RWMutex                  // Mutex to protect the routing table
+}
+
+// Creates a new, empty streamed method router.
+func NewStreamRouter() *StreamRouter {
+	return &StreamRouter{
+		routes: make(map[string]StreamRouteHandler),
+	}
+}
+
+// Registers the handler of a streamed method. It panics if the method is empty
+// or already registered, or if the handler is nil.
+func (r *StreamRouter) Handle(method string, handler StreamRouteHandler) {
+	if len(method) == 0 {
+		panic("iris: empty method name")
+	}
+	if handler == nil {
+		panic("iris: nil stream route handler")
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if _, ok := r.routes[method]; ok {
+		panic(fmt.Sprintf("iris: duplicate stream route for method %s", method))
+	}
+	r.routes[method] = handler
+}
+
+// Dispatches a streamed method call to the registered handler. Malformed calls
+// and unknown methods fail with a structured error.
+func (r *StreamRouter) HandleStream(request []byte, replies *ReplyWriter) error {
+	method, payload, err := decodeCall(request)
+	if err != nil {
+		return &Error{Code: CodeInvalidArgument, Message: err.Error()}
+	}
+	r.lock.RLock()
+	handler, ok := r.routes[method]
+	r.lock.RUnlock()
+
+	if !ok {
+		return &Error{Code: CodeNotFound, Message: fmt.Sprintf("unknown method %s", method)}
+	}
+	return handler(payload, replies)
+}
+
+// Executes a streamed method call to be serviced by a member of the specified
+// cluster, routed by its StreamRouter. See RequestStream for the details.
+func (c *Connection) CallStream(cluster string, method string, request []byte, timeout time.Duration) (*ReplyStream, error) {
+	if len(method) == 0 {
+		return nil, invalidArgument("empty method name")
+	}
+	return c.RequestStream(cluster, encodeCall(method, request), timeout)
+}
+
+// Prefixes a request payload with the method it calls.
+func encodeCall(method string, request []byte) []byte {
+	blob := make([]byte, binary.MaxVarintLen64, binary.MaxVarintLen64+len(method)+len(request))
+	blob = blob[:binary.PutUvarint(blob, uint64(len(method)))]
+	blob = append(blob, method...)
+	return append(blob, request...)
+}
+
+// Splits the called method off a request payload.
+func decodeCall(blob []byte) (string, []byte, error) {
+	size, n := binary.Uvarint(blob)
+	if n <= 0 || size == 0 || uint64(len(blob)-n) < size {
+		return "", nil, errors.New("malformed method call")
+	}
+	return string(blob[n : n+int(size)]), blob[n+int(size):], nil
+}
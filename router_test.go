@@ -0,0 +1,159 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Service handler for the router tests, embedding the method router.
+type routerTestHandler struct {
+	*Router
+}
+
+func (r *routerTestHandler) Init(conn *Connection) error { return nil }
+func (r *routerTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (r *routerTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (r *routerTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+// Tests that method calls are dispatched to the right handlers.
+func TestRouter(t *testing.T) {
+	// Register a service routing a few methods
+	router := NewRouter()
+	router.Handle("upper", func(ctx context.Context, req []byte) ([]byte, error) {
+		return []byte(strings.ToUpper(string(req))), nil
+	})
+	router.Handle("lower", func(ctx context.Context, req []byte) ([]byte, error) {
+		return []byte(strings.ToLower(string(req))), nil
+	})
+	router.Handle("deadline", func(ctx context.Context, req []byte) ([]byte, error) {
+		if _, ok := ctx.Deadline(); !ok {
+			return nil, errors.New("no deadline")
+		}
+		return []byte{0x00}, nil
+	})
+	serv, err := Register(config.relay, config.cluster, &routerTestHandler{router}, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Call each method and verify the replies
+	tests := []struct {
+		method string
+		input  string
+		output string
+	}{
+		{"upper", "Hello", "HELLO"},
+		{"lower", "Hello", "hello"},
+		{"deadline", "", "\x00"},
+	}
+	for i, tt := range tests {
+		reply, err := conn.Call(config.cluster, tt.method, []byte(tt.input), time.Second)
+		if err != nil {
+			t.Fatalf("test %d: call failed: %v.", i, err)
+		}
+		if string(reply) != tt.output {
+			t.Fatalf("test %d: reply mismatch: have %q, want %q.", i, reply, tt.output)
+		}
+	}
+	// Verify that unknown methods and raw requests fail with structured errors
+	_, err = conn.Call(config.cluster, "unknown", []byte{0x00}, time.Second)
+	if remote, ok := err.(*RemoteError); !ok || remote.Code != CodeNotFound {
+		t.Fatalf("unknown method error mismatch: have %v, want code %v.", err, CodeNotFound)
+	}
+	_, err = conn.Request(config.cluster, []byte{0xff}, time.Second)
+	if remote, ok := err.(*RemoteError); !ok || remote.Code != CodeInvalidArgument {
+		t.Fatalf("raw request error mismatch: have %v, want code %v.", err, CodeInvalidArgument)
+	}
+}
+
+// Service handler for the stream router tests, serving all tunnels as streams.
+type streamRouterTestHandler struct {
+	router *StreamRouter
+}
+
+func (s *streamRouterTestHandler) Init(conn *Connection) error              { return nil }
+func (s *streamRouterTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (s *streamRouterTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (s *streamRouterTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (s *streamRouterTestHandler) HandleTunnel(tun *Tunnel) {
+	ServeStream(tun, s.router)
+}
+
+// Tests that streamed method calls are dispatched to the right handlers.
+func TestStreamRouter(t *testing.T) {
+	// Register a service routing a few streamed methods
+	router := NewStreamRouter()
+	router.Handle("chars", func(req []byte, replies *ReplyWriter) error {
+		for _, c := range req {
+			if err := replies.Send([]byte{c}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	router.Handle("fail", func(req []byte, replies *ReplyWriter) error {
+		return &Error{Code: CodePermissionDenied, Message: "denied"}
+	})
+	serv, err := Register(config.relay, config.cluster, &streamRouterTestHandler{router}, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Call the streaming method and verify the replies
+	stream, err := conn.CallStream(config.cluster, "chars", []byte("abc"), time.Second)
+	if err != nil {
+		t.Fatalf("stream call failed: %v.", err)
+	}
+	var chars []byte
+	for {
+		frame, err := stream.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("stream failed: %v.", err)
+		}
+		chars = append(chars, frame...)
+	}
+	if string(chars) != "abc" {
+		t.Fatalf("stream mismatch: have %q, want %q.", chars, "abc")
+	}
+	// Verify that failures and unknown methods terminate the stream with structured errors
+	for method, code := range map[string]ErrorCode{"fail": CodePermissionDenied, "unknown": CodeNotFound} {
+		stream, err := conn.CallStream(config.cluster, method, []byte{0x00}, time.Second)
+		if err != nil {
+			t.Fatalf("%s: stream call failed: %v.", method, err)
+		}
+		_, err = stream.Next()
+
+		var remote *RemoteError
+		if !errors.As(err, &remote) || remote.Code != code {
+			t.Fatalf("%s: stream error mismatch: have %v, want code %v.", method, err, code)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the typed method handlers of the request router, wiring the codec,
+// validation and error mapping around plain Go functions.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Optional extension of typed requests, validating a decoded request before it
+// reaches its handler. Failures are returned to the caller as invalid arguments.
+type RequestValidator interface {
+	Validate() error
+}
+
+// Registers a typed handler of a method on the router. Requests are decoded and
+// replies encoded with the router's codec (see Router.SetCodec), requests that
+// implement RequestValidator are validated before the handler is invoked, and
+// failures are mapped to structured errors:
+//
+//   - undecodable or invalid requests fail with CodeInvalidArgument
+//   - cancelled or expired handler contexts fail with CodeUnavailable
+//   - remote errors of downstream calls retain their code and details
+//   - unencodable replies fail with CodeInternal
+//
+// Errors of type *Error are returned as is. It panics in the same cases as
+// Router.Handle.
+func Handle[Req, Resp any](router *Router, method string, handler func(ctx context.Context, request Req) (Resp, error)) {
+	if handler == nil {
+		panic("iris: nil typed route handler")
+	}
+	router.Handle(method, func(ctx context.Context, blob []byte) ([]byte, error) {
+		codec := router.getCodec()
+
+		var request Req
+		if err := codec.Unmarshal(blob, &request); err != nil {
+			return nil, &Error{Code: CodeInvalidArgument, Message: fmt.Sprintf("malformed request: %v", err)}
+		}
+		if err := validateRequest(&request); err != nil {
+			return nil, &Error{Code: CodeInvalidArgument, Message: err.Error()}
+		}
+		reply, err := handler(ctx, request)
+		if err != nil {
+			return nil, mapHandlerError(err)
+		}
+		blob, err = codec.Marshal(reply)
+		if err != nil {
+			return nil, &Error{Code: CodeInternal, Message: fmt.Sprintf("malformed reply: %v", err)}
+		}
+		return blob, nil
+	})
+}
+
+// Executes a synchronous typed method call to be serviced by a member of the
+// specified cluster, routed to a handler registered via Handle. The codec needs
+// to match the remote router's one. See Request for the details.
+func CallTyped[Req, Resp any](conn *Connection, codec Codec, cluster string, method string, request Req, timeout time.Duration) (Resp, error) {
+	var reply Resp
+
+	blob, err := codec.Marshal(request)
+	if err != nil {
+		return reply, err
+	}
+	if blob, err = conn.Call(cluster, method, blob, timeout); err != nil {
+		return reply, err
+	}
+	err = codec.Unmarshal(blob, &reply)
+	return reply, err
+}
+
+// Validates a decoded request if it supports validation, either by value or by
+// pointer.
+func validateRequest[Req any](request *Req) error {
+	if validator, ok := any(request).(RequestValidator); ok {
+		return validator.Validate()
+	}
+	if validator, ok := any(*request).(RequestValidator); ok {
+		return validator.Validate()
+	}
+	return nil
+}
+
+// Maps a typed handler failure to the structured error to return to the caller.
+func mapHandlerError(err error) error {
+	var structured *Error
+	if errors.As(err, &structured) {
+		return err
+	}
+	var remote *RemoteError
+	if errors.As(err, &remote) && remote.Code != CodeUnknown {
+		return &Error{Code: remote.Code, Message: remote.Reason, Details: remote.Details}
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return &Error{Code: CodeUnavailable, Message: err.Error()}
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Typed request of the RPC tests, validating its operands.
+type rpcTestRequest struct {
+	A, B int
+}
+
+func (r *rpcTestRequest) Validate() error {
+	if r.B == 0 {
+		return errors.New("division by zero")
+	}
+	return nil
+}
+
+// Typed reply of the RPC tests.
+type rpcTestReply struct {
+	Quotient int
+}
+
+// Tests that typed handlers decode, validate and encode the messages, mapping
+// their failures to structured errors.
+func TestTypedHandle(t *testing.T) {
+	// Register a service routing a few typed methods
+	router := NewRouter()
+	Handle(router, "divide", func(ctx context.Context, req rpcTestRequest) (rpcTestReply, error) {
+		return rpcTestReply{Quotient: req.A / req.B}, nil
+	})
+	Handle(router, "missing", func(ctx context.Context, req rpcTestRequest) (rpcTestReply, error) {
+		return rpcTestReply{}, &Error{Code: CodeNotFound, Message: "missing"}
+	})
+	Handle(router, "expired", func(ctx context.Context, req rpcTestRequest) (*rpcTestReply, error) {
+		return nil, context.DeadlineExceeded
+	})
+	serv, err := Register(config.relay, config.cluster, &routerTestHandler{router}, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Call a typed method and verify the reply
+	reply, err := CallTyped[rpcTestRequest, rpcTestReply](conn, JSONCodec, config.cluster, "divide", rpcTestRequest{A: 7, B: 2}, time.Second)
+	if err != nil {
+		t.Fatalf("typed call failed: %v.", err)
+	}
+	if reply.Quotient != 3 {
+		t.Fatalf("reply mismatch: have %d, want %d.", reply.Quotient, 3)
+	}
+	// Verify that failures are mapped to structured errors
+	tests := []struct {
+		method  string
+		request []byte
+		code    ErrorCode
+	}{
+		{"divide", []byte("not json"), CodeInvalidArgument},
+		{"divide", []byte(`{"A":1,"B":0}`), CodeInvalidArgument},
+		{"missing", []byte(`{"A":1,"B":1}`), CodeNotFound},
+		{"expired", []byte(`{"A":1,"B":1}`), CodeUnavailable},
+	}
+	for i, tt := range tests {
+		_, err := conn.Call(config.cluster, tt.method, tt.request, time.Second)
+
+		var remote *RemoteError
+		if !errors.As(err, &remote) || remote.Code != tt.code {
+			t.Fatalf("test %d: error mismatch: have %v, want code %v.", i, err, tt.code)
+		}
+	}
+	// Switch the codec and verify that calls need to follow suit
+	router.SetCodec(GobCodec)
+	if _, err := CallTyped[rpcTestRequest, rpcTestReply](conn, JSONCodec, config.cluster, "divide", rpcTestRequest{A: 7, B: 2}, time.Second); err == nil {
+		t.Fatalf("mismatching codec call succeeded.")
+	}
+	if reply, err = CallTyped[rpcTestRequest, rpcTestReply](conn, GobCodec, config.cluster, "divide", rpcTestRequest{A: 9, B: 3}, time.Second); err != nil || reply.Quotient != 3 {
+		t.Fatalf("gob call mismatch: have %v/%v, want %d.", reply.Quotient, err, 3)
+	}
+}
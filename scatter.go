@@ -0,0 +1,115 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the scatter-gather requests, issuing the same request to multiple
+// clusters in parallel and collecting the outcomes per cluster.
+
+package iris
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// Returned by a quorum request if too many of its requests failed for the
+// quorum to be reached.
+var ErrNoQuorum = errors.New("quorum not reached")
+
+// Outcome of the request issued to a single cluster within a scatter-gather.
+type ClusterReply struct {
+	Cluster string // Cluster the request was issued to
+	Reply   []byte // Reply of the cluster, nil on failure
+	Err     error  // Failure of the request, nil on success
+}
+
+// Issues the same request to each of the clusters in parallel, waiting for all
+// of them to reply or fail, and returns the outcomes in the order of clusters.
+// Each request goes through the same interceptors, retries and hedging as the
+// ones issued via Request.
+//
+// Failures of individual requests are reported in their outcomes; the returned
+// error is only set for invalid arguments.
+func (c *Connection) RequestAll(clusters []string, request []byte, timeout time.Duration) ([]*ClusterReply, error) {
+	return c.scatter(clusters, request, 0, timeout)
+}
+
+// Issues the same request to each of the clusters in parallel, returning as soon
+// as quorum of them replied successfully, abandoning the rest. The outcomes are
+// returned in the order of clusters, the abandoned requests failing with
+// context.Canceled.
+//
+// If so many requests fail that the quorum cannot be reached anymore, the
+// outcomes collected so far are returned along with ErrNoQuorum.
+func (c *Connection) RequestQuorum(clusters []string, request []byte, quorum int, timeout time.Duration) ([]*ClusterReply, error) {
+	if quorum < 1 || quorum > len(clusters) {
+		return nil, invalidArgument("quorum %d out of range [1, %d]", quorum, len(clusters))
+	}
+	replies, err := c.scatter(clusters, request, quorum, timeout)
+	if err != nil {
+		return replies, err
+	}
+	successes := 0
+	for _, reply := range replies {
+		if reply.Err == nil {
+			successes++
+		}
+	}
+	if successes < quorum {
+		return replies, ErrNoQuorum
+	}
+	return replies, nil
+}
+
+// Issues a request to each of the clusters in parallel, collecting the outcomes
+// until either quorum successes arrive or the quorum becomes unreachable. A zero
+// quorum collects all the outcomes.
+func (c *Connection) scatter(clusters []string, request []byte, quorum int, timeout time.Duration) ([]*ClusterReply, error) {
+	// Sanity check on the arguments
+	if len(clusters) == 0 {
+		return nil, invalidArgument("no clusters to request")
+	}
+	for _, cluster := range clusters {
+		if len(cluster) == 0 {
+			return nil, invalidArgument("empty cluster identifier")
+		}
+	}
+	// Abandon all the outstanding requests when returning
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	type result struct {
+		index int
+		reply []byte
+		err   error
+	}
+	results := make(chan result, len(clusters))
+	for i, cluster := range clusters {
+		go func(index int, cluster string) {
+			reply, err := c.request(ctx, cluster, request, timeout)
+			results <- result{index, reply, err}
+		}(i, cluster)
+	}
+	// Gather the outcomes until done or the quorum becomes unreachable
+	replies := make([]*ClusterReply, len(clusters))
+	for i, cluster := range clusters {
+		replies[i] = &ClusterReply{Cluster: cluster, Err: context.Canceled}
+	}
+	successes, failures := 0, 0
+	for pending := len(clusters); pending > 0; pending-- {
+		res := <-results
+		replies[res.index].Reply, replies[res.index].Err = res.reply, res.err
+		if res.err == nil {
+			successes++
+		} else {
+			failures++
+		}
+		if quorum > 0 && (successes >= quorum || failures > len(clusters)-quorum) {
+			break
+		}
+	}
+	return replies, nil
+}
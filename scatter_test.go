@@ -0,0 +1,72 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Tests that scatter-gather requests collect the outcomes of all clusters, and
+// that quorum requests return once enough replies arrived.
+func TestRequestScatter(t *testing.T) {
+	// Register a few echo services, each in its own cluster
+	live := []string{config.cluster + "-scatter-0", config.cluster + "-scatter-1"}
+	for _, cluster := range live {
+		serv, err := Register(config.relay, cluster, new(requestTestHandler), nil)
+		if err != nil {
+			t.Fatalf("registration of %s failed: %v.", cluster, err)
+		}
+		defer serv.Unregister()
+	}
+	dead := config.cluster + "-scatter-dead"
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Verify that all outcomes are gathered in cluster order
+	request := []byte("scatter")
+	clusters := append(append([]string{}, live...), dead)
+
+	replies, err := conn.RequestAll(clusters, request, 250*time.Millisecond)
+	if err != nil {
+		t.Fatalf("scatter-gather failed: %v.", err)
+	}
+	for i, cluster := range live {
+		if replies[i].Cluster != cluster || replies[i].Err != nil || !bytes.Equal(replies[i].Reply, request) {
+			t.Fatalf("reply %d mismatch: have %+v.", i, replies[i])
+		}
+	}
+	if replies[2].Cluster != dead || !errors.Is(replies[2].Err, ErrTimeout) {
+		t.Fatalf("dead cluster outcome mismatch: have %+v.", replies[2])
+	}
+	// Verify that a quorum request doesn't wait for the dead cluster
+	start := time.Now()
+	replies, err = conn.RequestQuorum(clusters, request, 2, time.Second)
+	if err != nil {
+		t.Fatalf("quorum request failed: %v.", err)
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("quorum request waited for stragglers: %v.", elapsed)
+	}
+	if replies[2].Err != context.Canceled {
+		t.Fatalf("abandoned outcome mismatch: have %v, want %v.", replies[2].Err, context.Canceled)
+	}
+	// Verify that unreachable quorums are reported
+	if _, err := conn.RequestQuorum([]string{live[0], dead}, request, 2, 250*time.Millisecond); err != ErrNoQuorum {
+		t.Fatalf("unreachable quorum error mismatch: have %v, want %v.", err, ErrNoQuorum)
+	}
+	if _, err := conn.RequestQuorum(clusters, request, 4, time.Second); !errors.Is(err, ErrInvalidArgument) {
+		t.Fatalf("invalid quorum error mismatch: have %v, want %v.", err, ErrInvalidArgument)
+	}
+}
@@ -0,0 +1,111 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the delayed publishes, scheduled client side and cancelable until
+// their delivery is due.
+
+package iris
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Handle of an event scheduled to be published later via PublishAfter.
+type ScheduledPublish struct {
+	Topic string    // Topic the event will be published to
+	Due   time.Time // Time instance the publish is scheduled for
+
+	conn  *Connection // Connection to publish the event through
+	id    uint64      // Identifier of the publish within the connection
+	timer *time.Timer // Timer firing the publish when due
+}
+
+// Schedules an event to be published to topic after delay elapses, returning a
+// handle to cancel it meanwhile. The event is copied, so the caller may reuse
+// the buffer. Pending publishes are dropped when the connection is closed.
+//
+// Since the scheduling is done client side, the delivery is best effort: any
+// failure of the eventual publish is only logged.
+func (c *Connection) PublishAfter(topic string, event []byte, delay time.Duration) (*ScheduledPublish, error) {
+	// Sanity check on the arguments
+	if len(topic) == 0 {
+		return nil, invalidArgument("empty topic identifier")
+	}
+	if event == nil || len(event) == 0 {
+		return nil, invalidArgument("nil or empty event")
+	}
+	if delay < 0 {
+		return nil, invalidArgument("negative publish delay")
+	}
+	if atomic.LoadInt32(&c.closing) == 1 {
+		return nil, ErrClosed
+	}
+	// Register the publish and arm its timer
+	sched := &ScheduledPublish{
+		Topic: topic,
+		Due:   time.Now().Add(delay),
+		conn:  c,
+		id:    atomic.AddUint64(&c.schedIdx, 1),
+	}
+	event = append([]byte(nil), event...)
+
+	c.schedLock.Lock()
+	if c.schedLive == nil {
+		c.schedLive = make(map[uint64]*ScheduledPublish)
+	}
+	c.schedLive[sched.id] = sched
+	sched.timer = time.AfterFunc(delay, func() { sched.fire(event) })
+	c.schedLock.Unlock()
+
+	c.Log.Debug("scheduled delayed publish", "topic", topic, "delay", delay, "data", logLazyBlob(event))
+	return sched, nil
+}
+
+// Cancels the scheduled publish, returning whether it was still pending (i.e.
+// not yet published nor cancelled).
+func (s *ScheduledPublish) Cancel() bool {
+	s.conn.schedLock.Lock()
+	defer s.conn.schedLock.Unlock()
+
+	if _, ok := s.conn.schedLive[s.id]; !ok {
+		return false
+	}
+	delete(s.conn.schedLive, s.id)
+	s.timer.Stop()
+	return true
+}
+
+// Publishes the scheduled event, unless cancelled meanwhile.
+func (s *ScheduledPublish) fire(event []byte) {
+	c := s.conn
+
+	c.schedLock.Lock()
+	_, ok := c.schedLive[s.id]
+	delete(c.schedLive, s.id)
+	c.schedLock.Unlock()
+
+	if !ok {
+		return
+	}
+	if err := c.Publish(s.Topic, event); err != nil {
+		c.Log.Warn("failed to execute delayed publish", "topic", s.Topic, "reason", err)
+	}
+}
+
+// Drops all the pending scheduled publishes.
+func (c *Connection) cancelScheduled() {
+	c.schedLock.Lock()
+	defer c.schedLock.Unlock()
+
+	if n := len(c.schedLive); n > 0 {
+		c.Log.Warn("dropping pending delayed publishes", "count", n)
+	}
+	for id, sched := range c.schedLive {
+		sched.timer.Stop()
+		delete(c.schedLive, id)
+	}
+}
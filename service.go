@@ -7,11 +7,11 @@
 package iris
 
 import (
-	"errors"
+	"context"
+	"crypto/tls"
 	"fmt"
 	"sync/atomic"
-
-	"gopkg.in/inconshreveable/log15.v2"
+	"time"
 )
 
 // Callback interface for processing inbound messages designated to a particular
@@ -47,37 +47,77 @@ type ServiceHandler interface {
 
 // Service instance belonging to a particular cluster in the network.
 type Service struct {
-	conn *Connection  // Network connection to the local Iris relay
-	Log  log15.Logger // Logger with service id injected
+	conn *Connection // Network connection to the local Iris relay
+	Log  Logger      // Logger with service id injected
 }
 
+// Interval between checking whether a draining service finished its work.
+var drainCheckInterval = 10 * time.Millisecond
+
 // Id to assign to the next service (used for logging purposes).
 var nextServId uint64
 
 // Connects to the Iris network and registers a new service instance as a member
-// of the specified service cluster.
-func Register(port int, cluster string, handler ServiceHandler, limits *ServiceLimits) (*Service, error) {
+// of the specified service cluster, configured by the optional functional
+// options (see Config).
+func Register(port int, cluster string, handler ServiceHandler, limits *ServiceLimits, opts ...Option) (*Service, error) {
+	conf := newConfig(opts)
+	return register(NewTCPTransport(port, conf.tlsConfig()), cluster, handler, limits, conf)
+}
+
+// Connects to the Iris network over a TLS encrypted link and registers a new
+// service instance as a member of the specified service cluster, for relays
+// listening on TLS. It is a shorthand for Register with the WithTLS option,
+// requiring a non-nil config.
+func RegisterTLS(port int, cluster string, handler ServiceHandler, limits *ServiceLimits, tlsConf *tls.Config) (*Service, error) {
+	if tlsConf == nil {
+		return nil, invalidArgument("nil TLS config")
+	}
+	return Register(port, cluster, handler, limits, WithTLS(tlsConf))
+}
+
+// Connects to the Iris network through the relay's unix domain socket at path
+// and registers a new service instance as a member of the specified service
+// cluster. It is a shorthand for RegisterTransport with a unix transport.
+func RegisterUnix(path string, cluster string, handler ServiceHandler, limits *ServiceLimits) (*Service, error) {
+	return RegisterTransport(NewUnixTransport(path), cluster, handler, limits)
+}
+
+// Connects to the Iris network through a custom relay transport and registers a
+// new service instance as a member of the specified service cluster. The TLS
+// option is ignored, the transport being in charge of the link.
+func RegisterTransport(transport RelayTransport, cluster string, handler ServiceHandler, limits *ServiceLimits, opts ...Option) (*Service, error) {
+	if transport == nil {
+		return nil, invalidArgument("nil relay transport")
+	}
+	return register(transport, cluster, handler, limits, newConfig(opts))
+}
+
+// Connects to the Iris network through the given transport and registers a new
+// service instance as a member of the specified service cluster, honoring the
+// configuration if any.
+func register(relay RelayTransport, cluster string, handler ServiceHandler, limits *ServiceLimits, conf *Config) (*Service, error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
-		return nil, errors.New("empty cluster identifier")
+		return nil, invalidArgument("empty cluster identifier")
 	}
 	if handler == nil {
-		return nil, errors.New("nil service handler")
+		return nil, invalidArgument("nil service handler")
 	}
 	// Make sure the service limits have valid values
 	limits = finalizeServiceLimits(limits)
 
-	logger := Log.New("service", atomic.AddUint64(&nextServId, 1))
-	logger.Info("registering new service", "relay_port", port, "cluster", cluster,
-		"broadcast_limits", log15.Lazy{func() string {
+	logger := conf.logger().New("service", atomic.AddUint64(&nextServId, 1))
+	logger.Info("registering new service", "relay", relay, "cluster", cluster,
+		"broadcast_limits", logLazy(func() string {
 			return fmt.Sprintf("%dT|%dB", limits.BroadcastThreads, limits.BroadcastMemory)
-		}},
-		"request_limits", log15.Lazy{func() string {
+		}),
+		"request_limits", logLazy(func() string {
 			return fmt.Sprintf("%dT|%dB", limits.RequestThreads, limits.RequestMemory)
-		}})
+		}))
 
 	// Connect to the Iris relay as a service
-	conn, err := newConnection(port, cluster, handler, limits, logger)
+	conn, err := newConnection(context.Background(), relay, cluster, handler, limits, conf, logger)
 	if err != nil {
 		logger.Warn("failed to register new service", "reason", err)
 		return nil, err
@@ -123,9 +163,76 @@ func finalizeServiceLimits(user *ServiceLimits) *ServiceLimits {
 	if user.RequestMemory == 0 {
 		limits.RequestMemory = defaultServiceLimits.RequestMemory
 	}
+	if user.BroadcastBatch > 0 && user.BroadcastWindow <= 0 {
+		limits.BroadcastWindow = defaultBroadcastWindow
+	}
 	return limits
 }
 
+// Resizes the broadcast and request handler pools of the service at runtime,
+// allowing at most threads handlers of each kind to execute concurrently.
+// Shrinking the pools does not interrupt the already running handlers.
+func (s *Service) SetHandlerThreads(threads int) error {
+	if threads <= 0 {
+		return invalidArgument("invalid handler thread count %d", threads)
+	}
+	s.Log.Info("resizing handler pools", "threads", threads)
+	s.conn.bcastPool.Resize(threads)
+	s.conn.reqPool.Resize(threads)
+	return nil
+}
+
+// Returns the number of broadcasts and requests queued, waiting for a handler
+// thread to become available.
+func (s *Service) QueueDepth() (broadcasts int, requests int) {
+	return s.conn.bcastPool.Pending(), s.conn.reqPool.Pending()
+}
+
+// Gracefully unregisters the service instance: stops accepting new inbound
+// broadcasts, requests and tunnels, waits for the queued and running handlers
+// to finish and the open tunnels to be closed, and then unregisters.
+//
+// Requests arriving during the drain are rejected with ErrDraining, so that the
+// requesters may retry them elsewhere. If the drain does not complete within
+// the timeout, the service is unregistered anyway and ErrTimeout returned.
+func (s *Service) Drain(timeout time.Duration) error {
+	s.Log.Info("draining service", "timeout", timeout)
+	atomic.StoreInt32(&s.conn.draining, 1)
+
+	// Wait for all the in-flight work to finish
+	expire := time.After(timeout)
+	ticker := time.NewTicker(drainCheckInterval)
+	defer ticker.Stop()
+
+	var err error
+	for !s.drained() {
+		select {
+		case <-expire:
+			s.Log.Warn("service drain timed out")
+			err = ErrTimeout
+		case <-ticker.C:
+			continue
+		}
+		break
+	}
+	// Tear down the service, reporting the first failure
+	if uerr := s.Unregister(); err == nil {
+		err = uerr
+	}
+	return err
+}
+
+// Checks whether all the inbound work of a draining service has finished.
+func (s *Service) drained() bool {
+	if s.conn.bcastPool.Busy() || s.conn.reqPool.Busy() || s.conn.broadcastsBatched() {
+		return false
+	}
+	s.conn.tunLock.RLock()
+	defer s.conn.tunLock.RUnlock()
+
+	return len(s.conn.tunLive) == 0
+}
+
 // Unregisters the service instance from the Iris network, removing all
 // subscriptions and closing all active tunnels.
 //
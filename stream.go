@@ -0,0 +1,142 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the streamed request/reply scheme built on top of tunnels.
+
+package iris
+
+import (
+	"fmt"
+	"io"
+	"time"
+)
+
+// Stream frame tags
+const (
+	streamData  byte = 0x00 // Reply data frame
+	streamFault      = 0x01 // Remote failure, terminating the stream
+)
+
+// Callback interface for serving a streamed request.
+type StreamHandler interface {
+	// Callback invoked with the request arriving through a stream tunnel. The
+	// reply frames should be sent through the writer. Returning an error will
+	// terminate the stream, delivering the error to the requester.
+	HandleStream(request []byte, replies *ReplyWriter) error
+}
+
+// Client side of a streamed request, iterating over the arriving reply frames.
+type ReplyStream struct {
+	tunnel  *Tunnel       // Tunnel through which the replies arrive
+	timeout time.Duration // Maximum time to wait for a single frame
+	err     error         // Terminal failure of the stream, if any
+}
+
+// Service side of a streamed request, through which reply frames can be sent.
+type ReplyWriter struct {
+	tunnel *Tunnel // Tunnel through which to send the replies
+}
+
+// Executes a streamed request to be serviced by a member of the specified
+// cluster, load-balanced between all participant, returning a stream of reply
+// frames. The remote service needs to serve the arriving tunnel via ServeStream.
+//
+// The timeout limits both the stream construction, as well as the wait for any
+// single reply frame. Infinite waits are not supported.
+func (c *Connection) RequestStream(cluster string, request []byte, timeout time.Duration) (*ReplyStream, error) {
+	// Sanity check on the arguments
+	if request == nil || len(request) == 0 {
+		return nil, invalidArgument("nil or empty request")
+	}
+	// Open a tunnel to the remote service and forward the request
+	tun, err := c.Tunnel(cluster, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if err := tun.Send(request, timeout); err != nil {
+		tun.Close()
+		return nil, err
+	}
+	if err := tun.CloseWrite(); err != nil {
+		tun.Close()
+		return nil, err
+	}
+	return &ReplyStream{
+		tunnel:  tun,
+		timeout: timeout,
+	}, nil
+}
+
+// Retrieves the next reply frame from the stream, blocking until one arrives.
+// After the last frame io.EOF is returned. Remote failures are reported as a
+// RemoteError. Upon termination, the stream is closed automatically.
+func (s *ReplyStream) Next() ([]byte, error) {
+	// Short circuit if the stream already terminated
+	if s.err != nil {
+		return nil, s.err
+	}
+	defer func() {
+		if s.err != nil {
+			s.tunnel.Close()
+		}
+	}()
+	// Fetch the next frame and interpret it
+	frame, err := s.tunnel.Recv(s.timeout)
+	switch {
+	case err == io.EOF:
+		s.err = io.EOF
+	case err != nil:
+		return nil, err
+	case frame[0] == streamData:
+		return frame[1:], nil
+	case frame[0] == streamFault:
+		s.err = decodeFault(string(frame[1:]))
+	default:
+		s.err = fmt.Errorf("%w: invalid stream frame", ErrProtocolViolation)
+	}
+	return nil, s.err
+}
+
+// Closes the reply stream, cancelling the remote processing if still running.
+func (s *ReplyStream) Close() error {
+	return s.tunnel.Close()
+}
+
+// Serves a streamed request arriving through an inbound tunnel, invoking the
+// handler with the request and closing the tunnel afterwards. Services should
+// call it from their HandleTunnel callback.
+func ServeStream(tunnel *Tunnel, handler StreamHandler) error {
+	defer tunnel.Close()
+
+	// Fetch the request, which must be followed by an end-of-stream
+	request, err := tunnel.Recv(0)
+	if err != nil {
+		return err
+	}
+	if _, err := tunnel.Recv(0); err != io.EOF {
+		return fmt.Errorf("%w: multi-message stream request", ErrProtocolViolation)
+	}
+	// Execute the handler and terminate the stream with the result
+	writer := &ReplyWriter{tunnel: tunnel}
+	if err := handler.HandleStream(request, writer); err != nil {
+		tunnel.Log.Debug("stream handler failed", "reason", err)
+		if err := tunnel.Send(append([]byte{streamFault}, encodeFault(err)...), 0); err != nil {
+			return err
+		}
+	}
+	if err := tunnel.CloseWrite(); err != nil {
+		return err
+	}
+	// Wait for the requester to tear the stream down
+	<-tunnel.term
+	return nil
+}
+
+// Sends a reply frame to the requester, blocking until the local Iris node
+// receives it. If the requester closed the stream, ErrClosed is returned.
+func (w *ReplyWriter) Send(frame []byte) error {
+	return w.tunnel.Send(append([]byte{streamData}, frame...), 0)
+}
@@ -0,0 +1,81 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"testing"
+	"time"
+)
+
+// Service handler for the streamed request tests.
+type streamTestHandler struct {
+	conn *Connection
+}
+
+func (s *streamTestHandler) Init(conn *Connection) error              { s.conn = conn; return nil }
+func (s *streamTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (s *streamTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (s *streamTestHandler) HandleTunnel(tun *Tunnel)                 { ServeStream(tun, s) }
+func (s *streamTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+// Streams back as many frames as the request's first byte, failing if the
+// second byte is set.
+func (s *streamTestHandler) HandleStream(req []byte, replies *ReplyWriter) error {
+	for i := 0; i < int(req[0]); i++ {
+		if err := replies.Send([]byte(fmt.Sprintf("frame #%d", i))); err != nil {
+			return err
+		}
+	}
+	if len(req) > 1 && req[1] != 0 {
+		return errors.New("stream failure")
+	}
+	return nil
+}
+
+// Tests that streamed replies are delivered in order and terminated properly.
+func TestRequestStream(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		frames int
+	}{25}
+
+	// Register a new stream service to the relay
+	handler := new(streamTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Execute a successful and a failing stream, verifying the frames
+	for _, fail := range []byte{0, 1} {
+		stream, err := handler.conn.RequestStream(config.cluster, []byte{byte(conf.frames), fail}, time.Second)
+		if err != nil {
+			t.Fatalf("stream request failed: %v.", err)
+		}
+		for i := 0; i < conf.frames; i++ {
+			frame, err := stream.Next()
+			if err != nil {
+				t.Fatalf("frame #%d retrieval failed: %v.", i, err)
+			}
+			if want := fmt.Sprintf("frame #%d", i); string(frame) != want {
+				t.Fatalf("frame #%d mismatch: have %s, want %s.", i, frame, want)
+			}
+		}
+		_, err = stream.Next()
+		if fail == 0 && err != io.EOF {
+			t.Fatalf("stream termination mismatch: have %v, want %v.", err, io.EOF)
+		}
+		if _, ok := err.(*RemoteError); fail != 0 && !ok {
+			t.Fatalf("stream failure mismatch: have %v, want remote error.", err)
+		}
+		stream.Close()
+	}
+}
@@ -7,10 +7,11 @@
 package iris
 
 import (
+	"container/list"
+	"context"
+	"sync"
 	"sync/atomic"
-
-	"github.com/project-iris/iris/pool"
-	"gopkg.in/inconshreveable/log15.v2"
+	"time"
 )
 
 // Callback interface for processing events from a single subscribed topic.
@@ -20,35 +21,91 @@ type TopicHandler interface {
 	HandleEvent(event []byte)
 }
 
+// Topic handler wrapping a raw callback, which also receives the topic each
+// event was published to (the concrete one for pattern subscriptions).
+type topicFuncHandler struct {
+	handler func(topic string, event []byte)
+}
+
+// Invokes the raw callback without topic information. The subscription calls
+// the callback directly instead.
+func (h *topicFuncHandler) HandleEvent(event []byte) {
+	h.handler("", event)
+}
+
 // Topic subscription, responsible for enforcing the quality of service limits.
 type topic struct {
 	// Application layer fields
+	conn    *Connection  // Connection owning the subscription
+	name    string       // Name of the subscribed topic
 	handler TopicHandler // Handler for topic events
 
 	// Quality of service fields
 	limits *TopicLimits // Limits on the inbound message processing
 
-	eventIdx  uint64           // Index to assign to inbound events for logging purposes
-	eventPool *pool.ThreadPool // Queue and concurrency limiter for the event handlers
-	eventUsed int32            // Actual memory usage of the event queue
+	eventIdx  uint64       // Index to assign to inbound events for logging purposes
+	eventPool *handlerPool // Concurrency limiter for the event handlers
+	eventUsed int32        // Actual memory usage of the event queue
+
+	eventQueue Queue      // Events waiting for an event handler
+	eventLock  sync.Mutex // Protects the event queue and its memory usage
+	eventCond  *sync.Cond // Signals freed queue space to held back admissions
+	eventTerm  bool       // Flag whether the subscription was terminated
+	paused     bool       // Flag whether the event delivery is held back
+
+	ackPend map[uint64]*list.Element // Deliveries awaiting acknowledgement, by index
+	ackList *list.List               // Deliveries awaiting acknowledgement, oldest first
+	ackIdx  uint64                   // Index to assign to the next tracked delivery
+	ackTerm bool                     // Flag whether tracking was abandoned (subscription ended)
+	ackLock sync.Mutex               // Protects the acknowledgement tracking
+
+	replayId   uint64 // Nonce of the retained event query, zero if none was made
+	replayLast int64  // Publish time of the last admitted replay (event lock)
+	replayLive bool   // Flag whether a live event arrived, outdating replays (event lock)
+
+	order   keyedDispatcher // Serializer of the events sharing an ordering key
+	ingress *handlerPool    // Serial admission of the arrived events of ordered or blocking subscriptions
+
+	status     SubscriptionStatus // State of the subscription on the relay link
+	statusLock sync.Mutex         // Protects the subscription status
+
+	recent *recentBuffer // Replay buffer of the last arrived events, nil if disabled
 
 	// Bookkeeping fields
-	logger log15.Logger
+	logger Logger
 }
 
-// Creates a new topic subscription.
-func newTopic(handler TopicHandler, limits *TopicLimits, logger log15.Logger) *topic {
+// Creates a new topic subscription. The handler tasks are always queued into an
+// unbounded ring, as the events they process are bounded by the event queue.
+func newTopic(conn *Connection, name string, handler TopicHandler, limits *TopicLimits, logger Logger) *topic {
 	top := &topic{
 		// Application layer
+		conn:    conn,
+		name:    name,
 		handler: handler,
 
 		// Quality of service
-		limits:    limits,
-		eventPool: pool.NewThreadPool(limits.EventThreads),
+		limits:     limits,
+		eventPool:  newHandlerPool(limits.EventThreads, NewRingQueue(0, 0)),
+		eventQueue: newQueue(limits.Queue),
+		recent:     newRecentBuffer(limits.RecentEvents),
 
 		// Bookkeeping
 		logger: logger,
 	}
+	top.status = SubscriptionStatus{State: SubscriptionPending, Since: time.Now()}
+	top.eventCond = sync.NewCond(&top.eventLock)
+
+	// Admit the events one by one if ordered or blocking, retaining their arrival
+	// order. Blocking subscriptions hold back a bounded backlog meanwhile.
+	switch {
+	case limits.Overflow == OverflowBlock:
+		top.ingress = newHandlerPool(1, NewRingQueue(0, limits.BlockBacklog))
+		top.ingress.Start()
+	case limits.OrderKey != nil:
+		top.ingress = newHandlerPool(1, NewRingQueue(0, 0))
+		top.ingress.Start()
+	}
 	// Start the event processing and return
 	top.eventPool.Start()
 	return top
@@ -70,33 +127,264 @@ func finalizeTopicLimits(user *TopicLimits) *TopicLimits {
 	if user.EventMemory == 0 {
 		limits.EventMemory = defaultTopicLimits.EventMemory
 	}
+	if user.AckWindow == 0 {
+		limits.AckWindow = defaultTopicLimits.AckWindow
+	}
+	if user.AckBuffer == 0 {
+		limits.AckBuffer = defaultTopicLimits.AckBuffer
+	}
+	if user.BlockTimeout == 0 {
+		limits.BlockTimeout = defaultTopicLimits.BlockTimeout
+	}
+	if user.BlockBacklog == 0 {
+		limits.BlockBacklog = defaultTopicLimits.BlockBacklog
+	}
 	return limits
 }
 
-// Schedules a topic event for the subscription handler to process.
-func (t *topic) handlePublish(event []byte) {
+// Event waiting in a subscription's queue for a handler.
+type topicEvent struct {
+	id      int               // Index of the event for logging purposes
+	topic   string            // Topic the event was published to
+	headers map[string]string // Trace headers propagated with the event
+	meta    *Event            // Publisher metadata of enveloped events
+	expiry  time.Time         // Deadline after which to discard the event, if any
+	payload []byte            // Application payload of the event
+	size    int               // Memory usage of the event (including headers)
+}
+
+// Schedules a topic event for the subscription handler to process, enforcing
+// the queue limits according to the overflow policy. The source is the topic
+// the event was published to, differing from the subscribed one for patterns.
+// The arrival time orders the events retained in the replay buffer.
+func (t *topic) handlePublish(source string, event []byte, arrived time.Time) {
+	// Discard any replays of retained events not meant for this subscription
+	event, ok := t.admitReplay(event)
+	if !ok {
+		t.logger.Debug("discarding foreign or stale replay")
+		return
+	}
 	id := int(atomic.AddUint64(&t.eventIdx, 1))
-	t.logger.Debug("scheduling arrived event", "event", id, "data", logLazyBlob(event))
-
-	// Make sure there is enough memory for the event
-	used := int(atomic.LoadInt32(&t.eventUsed)) // Safe, since only 1 thread increments!
-	if used+len(event) <= t.limits.EventMemory {
-		// Increment the memory usage of the queue and schedule the event
-		atomic.AddInt32(&t.eventUsed, int32(len(event)))
-		t.eventPool.Schedule(func() {
-			// Start the processing by decrementing the memory usage
-			atomic.AddInt32(&t.eventUsed, -int32(len(event)))
-			t.logger.Debug("handling scheduled event", "event", id)
-			t.handler.HandleEvent(event)
-		})
+	headers, payload := unwrapTrace(event)
+	deadline, payload := unwrapTTL(payload)
+	meta, payload := unwrapEnvelope(payload)
+	t.logger.Debug("scheduling arrived event", "event", id, "data", logLazyBlob(payload))
+
+	// Discard the event if it's oversized or malformed
+	if err := t.conn.validateInbound(TracePublish, source, len(event), payload); err != nil {
+		t.logger.Warn("dropping rejected event", "event", id, "reason", err)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
 		return
 	}
-	// Not enough memory in the event queue
-	t.logger.Error("event exceeded memory allowance", "event", id, "limit", t.limits.EventMemory, "used", used, "size", len(event))
+	// Discard the event if it expired in transit
+	if expired(deadline) {
+		t.logger.Warn("dropping expired event", "event", id, "deadline", deadline)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		return
+	}
+	t.recent.record(arrived, source, payload)
+
+	// Make sure there is enough space for the event
+	t.eventLock.Lock()
+	for !t.eventTerm && !t.fits(len(event)) {
+		// If the event cannot fit even into an empty queue (or would stall a paused
+		// or closing subscription, or was held back for too long), drop it
+		if t.eventQueue.Empty() || t.limits.Overflow == OverflowDropNewest || t.limits.Overflow == OverflowCallback || (t.limits.Overflow == OverflowBlock && (t.paused || atomic.LoadInt32(&t.conn.closing) == 1 || time.Since(arrived) >= t.limits.BlockTimeout)) {
+			used := int(atomic.LoadInt32(&t.eventUsed))
+			t.eventLock.Unlock()
+
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			if t.limits.Overflow == OverflowCallback {
+				t.logger.Warn("event exceeded queue allowance, overflowing", "event", id, "used", used, "size", len(event))
+				t.limits.OnOverflow(t.name, payload)
+				return
+			}
+			t.logger.Error("event exceeded queue allowance", "event", id, "limit", t.limits.EventMemory, "queue", t.limits.EventQueue, "used", used, "size", len(event))
+			return
+		}
+		switch t.limits.Overflow {
+		case OverflowDropOldest:
+			// Evict the oldest pending event to make room
+			old := t.eventQueue.Pop().(*topicEvent)
+			atomic.AddInt32(&t.eventUsed, -int32(old.size))
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			t.logger.Warn("evicted pending event", "event", old.id, "size", old.size)
+
+		case OverflowBlock:
+			// Hold back the topic's deliveries until a handler frees up some space or
+			// the event was held back for too long. Only this topic's ingress waits.
+			timer := time.AfterFunc(t.limits.BlockTimeout-time.Since(arrived), func() {
+				t.eventLock.Lock()
+				t.eventCond.Broadcast()
+				t.eventLock.Unlock()
+			})
+			t.eventCond.Wait()
+			timer.Stop()
+		}
+	}
+	if t.eventTerm {
+		t.eventLock.Unlock()
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		t.logger.Warn("dropping event of terminated subscription", "event", id)
+		return
+	}
+	// Increment the memory usage of the queue and schedule the event
+	if !t.eventQueue.Push(&topicEvent{id: id, topic: source, headers: headers, meta: meta, expiry: deadline, payload: payload, size: len(event)}) {
+		queued := t.eventQueue.Size()
+		t.eventLock.Unlock()
+
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		t.logger.Error("event exceeded bounded queue", "event", id, "queued", queued)
+		return
+	}
+	atomic.AddInt32(&t.eventUsed, int32(len(event)))
+	paused := t.paused
+	t.eventLock.Unlock()
+
+	atomic.AddUint64(&t.conn.stats.pubRecv, 1)
+	if !paused {
+		t.eventPool.Schedule(t.handleEvent)
+	}
+}
+
+// Checks whether an event of the given size fits into the queue limits. The
+// event lock must be held.
+func (t *topic) fits(size int) bool {
+	if t.limits.EventQueue > 0 && t.eventQueue.Size() >= t.limits.EventQueue {
+		return false
+	}
+	return int(atomic.LoadInt32(&t.eventUsed))+size <= t.limits.EventMemory
+}
+
+// Returns the number of events waiting for a handler.
+func (t *topic) pending() int {
+	t.eventLock.Lock()
+	defer t.eventLock.Unlock()
+
+	return t.eventQueue.Size()
+}
+
+// Hands an arrived event over to the ingress of a blocking subscription, so that
+// a full queue holds back the deliveries of this topic only, never the relay
+// link. Events exceeding the held back backlog are dropped.
+func (t *topic) holdBack(source string, event []byte, arrived time.Time) {
+	err := t.ingress.Schedule(func() { t.handlePublish(source, event, arrived) })
+	if err == ErrOverloaded {
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		t.logger.Error("event exceeded held back backlog", "backlog", t.limits.BlockBacklog, "size", len(event))
+	}
+}
+
+// Wakes up any event admissions held back by full blocking subscriptions, so
+// they notice the connection closing and drop their events.
+func (c *Connection) releaseBlocked() {
+	c.subLock.RLock()
+	defer c.subLock.RUnlock()
+
+	for _, top := range c.subLive {
+		top.eventLock.Lock()
+		top.eventCond.Broadcast()
+		top.eventLock.Unlock()
+	}
+}
+
+// Retrieves the oldest queued event and executes the subscription handler on
+// it. Since events may be evicted from the queue (or held back by a pause), the
+// task might find nothing to process.
+func (t *topic) handleEvent() {
+	t.eventLock.Lock()
+	if t.eventQueue.Empty() || t.paused {
+		t.eventLock.Unlock()
+		return
+	}
+	event := t.eventQueue.Pop().(*topicEvent)
+
+	// Events sharing an ordering key wait for their predecessors, still occupying
+	// their queue memory meanwhile
+	if t.limits.OrderKey != nil {
+		if key := t.limits.OrderKey(event.payload); key != "" {
+			task := func() {
+				t.releaseEvent(event)
+				t.processEvent(event)
+			}
+			claimed := t.order.claim(key, task)
+			t.eventLock.Unlock()
+
+			if claimed {
+				t.order.run(key, task)
+			}
+			return
+		}
+	}
+	// Start the processing by decrementing the memory usage
+	atomic.AddInt32(&t.eventUsed, -int32(event.size))
+	t.eventCond.Broadcast()
+	t.eventLock.Unlock()
+
+	t.processEvent(event)
+}
+
+// Releases the queue memory of an event held back by its ordering key.
+func (t *topic) releaseEvent(event *topicEvent) {
+	t.eventLock.Lock()
+	defer t.eventLock.Unlock()
+
+	atomic.AddInt32(&t.eventUsed, -int32(event.size))
+	t.eventCond.Broadcast()
+}
+
+// Executes the subscription handler on a dequeued event.
+func (t *topic) processEvent(event *topicEvent) {
+	// Discard the event if it expired while queued
+	if expired(event.expiry) {
+		t.logger.Warn("dropping expired event", "event", event.id, "deadline", event.expiry)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		return
+	}
+	atomic.AddInt32(&t.conn.stats.eventActive, 1)
+	defer atomic.AddInt32(&t.conn.stats.eventActive, -1)
+
+	t.logger.Debug("handling scheduled event", "event", event.id)
+	defer t.conn.hookEvent(event.topic, event.payload, time.Now())
+	ctx, finish := t.conn.traceInbound(TracePublish, t.name, event.headers)
+	_, err := t.conn.interceptInbound(ctx, TracePublish, t.name, event.payload, func(ctx context.Context, _ TraceOp, _ string, payload []byte) ([]byte, error) {
+		if handler, ok := t.handler.(AckTopicHandler); ok {
+			handler.HandleDelivery(t.trackDelivery(event.topic, payload))
+		} else if handler, ok := t.handler.(*topicFuncHandler); ok {
+			handler.handler(event.topic, payload)
+		} else if handler, ok := t.handler.(MetaTopicHandler); ok {
+			meta := Event{Topic: event.topic}
+			if event.meta != nil {
+				meta = *event.meta
+				meta.Topic = event.topic
+			}
+			meta.Payload = payload
+			handler.HandleEventMeta(meta)
+		} else if handler, ok := t.handler.(ContextTopicHandler); ok {
+			handler.HandleEventCtx(ctx, payload)
+		} else {
+			t.handler.HandleEvent(payload)
+		}
+		return nil, nil
+	})
+	finish(err)
 }
 
 // Terminates a topic subscription's internal processing pool.
 func (t *topic) terminate() {
+	// Release any admissions held back on the (now abandoned) queue
+	t.eventLock.Lock()
+	t.eventTerm = true
+	t.eventCond.Broadcast()
+	t.eventLock.Unlock()
+
+	// Drop any events not yet admitted into the queue
+	if t.ingress != nil {
+		t.ingress.Terminate(true)
+	}
 	// Wait for queued events to finish running
 	t.eventPool.Terminate(false)
+
+	// Abandon any events awaiting acknowledgement
+	t.abandonDeliveries()
 }
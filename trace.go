@@ -0,0 +1,204 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the trace context propagation through the messaging primitives.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Messaging operation being traced.
+type TraceOp string
+
+const (
+	TraceBroadcast TraceOp = "broadcast"
+	TraceRequest   TraceOp = "request"
+	TracePublish   TraceOp = "publish"
+	TraceTunnel    TraceOp = "tunnel"
+)
+
+// Span creation hooks invoked around each traced messaging operation. The
+// irisotel subpackage provides an OpenTelemetry based implementation.
+type Tracer interface {
+	// Starts a span for an outbound operation towards target (cluster or topic),
+	// returning the trace headers to embed into the message and a callback to
+	// end the span with the outcome of the operation.
+	StartOutbound(ctx context.Context, op TraceOp, target string) (headers map[string]string, finish func(error))
+
+	// Starts a span for an inbound operation arriving on target (cluster or
+	// topic) with the embedded trace headers (nil if none), returning the
+	// context to pass to the handler and a callback to end the span.
+	StartInbound(op TraceOp, target string, headers map[string]string) (ctx context.Context, finish func(error))
+}
+
+// Optional extension of ServiceHandler, receiving the trace context of inbound
+// broadcasts.
+type ContextBroadcastHandler interface {
+	HandleBroadcastCtx(ctx context.Context, message []byte)
+}
+
+// Optional extension of ServiceHandler, receiving the trace context of inbound
+// requests. The context expires when the requester's timeout does, allowing the
+// handler to abandon work nobody waits for anymore.
+type ContextRequestHandler interface {
+	HandleRequestCtx(ctx context.Context, request []byte) ([]byte, error)
+}
+
+// Optional extension of TopicHandler, receiving the trace context of inbound
+// events.
+type ContextTopicHandler interface {
+	HandleEventCtx(ctx context.Context, event []byte)
+}
+
+// Prefix identifying a message carrying embedded trace headers.
+var traceMagic = []byte("\x00iris-trace\x00")
+
+// Sets the tracer to invoke around all messaging operations of the connection.
+// A nil tracer disables tracing.
+//
+// Since the relay protocol has no notion of message headers, they are prefixed
+// to the messages, which older bindings deliver as part of the payload.
+func (c *Connection) SetTracer(tracer Tracer) {
+	c.traceLock.Lock()
+	defer c.traceLock.Unlock()
+
+	c.tracer = tracer
+}
+
+// Retrieves the currently configured tracer, nil if tracing is disabled.
+func (c *Connection) getTracer() Tracer {
+	c.traceLock.RLock()
+	defer c.traceLock.RUnlock()
+
+	return c.tracer
+}
+
+// Starts an outbound span if tracing is enabled, embedding the trace headers
+// into the message. The returned callback always needs to be invoked.
+func (c *Connection) traceOutbound(ctx context.Context, op TraceOp, target string, message []byte) ([]byte, func(error)) {
+	tracer := c.getTracer()
+	if tracer == nil {
+		return message, func(error) {}
+	}
+	headers, finish := tracer.StartOutbound(ctx, op, target)
+	return wrapTrace(headers, message), finish
+}
+
+// Starts an inbound span if tracing is enabled, returning the context to pass
+// to the handler. The returned callback always needs to be invoked.
+func (c *Connection) traceInbound(op TraceOp, target string, headers map[string]string) (context.Context, func(error)) {
+	tracer := c.getTracer()
+	if tracer == nil {
+		return context.Background(), func(error) {}
+	}
+	return tracer.StartInbound(op, target, headers)
+}
+
+// Embeds the trace headers into a message.
+func wrapTrace(headers map[string]string, message []byte) []byte {
+	buf := new(bytes.Buffer)
+	buf.Write(traceMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	put := func(data string) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(data)))])
+		buf.WriteString(data)
+	}
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(headers)))])
+	for key, value := range headers {
+		put(key)
+		put(value)
+	}
+	buf.Write(message)
+	return buf.Bytes()
+}
+
+// Splits the embedded trace headers off a message, if any. Messages without
+// headers (or with malformed ones) are returned as is.
+func unwrapTrace(message []byte) (map[string]string, []byte) {
+	if !bytes.HasPrefix(message, traceMagic) {
+		return nil, message
+	}
+	reader := bytes.NewReader(message[len(traceMagic):])
+	get := func() (string, error) {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return "", err
+		}
+		if size > uint64(reader.Len()) {
+			return "", errors.New("header overflow")
+		}
+		data := make([]byte, size)
+		reader.Read(data)
+		return string(data), nil
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(reader.Len()) {
+		return nil, message
+	}
+	headers := make(map[string]string, count)
+	for i := uint64(0); i < count; i++ {
+		key, err := get()
+		if err != nil {
+			return nil, message
+		}
+		value, err := get()
+		if err != nil {
+			return nil, message
+		}
+		headers[key] = value
+	}
+	return headers, message[len(message)-reader.Len():]
+}
+
+// Retrieves the trace context of the tunnel. For inbound tunnels it becomes
+// available once the remote trace headers arrive, which always precede any
+// application message (i.e. after the first successful Recv). If the tunnel
+// is not traced, the background context is returned.
+func (t *Tunnel) Context() context.Context {
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	if t.traceCtx == nil {
+		return context.Background()
+	}
+	return t.traceCtx
+}
+
+// Starts the span of a freshly constructed outbound tunnel if tracing is
+// enabled, forwarding the trace headers in band as a header-only message.
+func (t *Tunnel) traceOutbound(ctx context.Context, cluster string, timeout time.Duration) error {
+	tracer := t.conn.getTracer()
+	if tracer == nil {
+		return nil
+	}
+	headers, finish := tracer.StartOutbound(ctx, TraceTunnel, cluster)
+	if err := t.send(ctx, wrapTrace(headers, nil), time.After(timeout)); err != nil {
+		finish(err)
+		return err
+	}
+	t.itoaLock.Lock()
+	t.traceCtx, t.traceEnd = ctx, finish
+	t.itoaLock.Unlock()
+
+	return nil
+}
+
+// Starts the span of an inbound tunnel upon the arrival of the remote trace
+// headers. The caller needs to hold the inbound lock.
+func (t *Tunnel) traceInbound(headers map[string]string) {
+	if t.traceEnd != nil {
+		t.Log.Warn("duplicate trace headers discarded")
+		return
+	}
+	t.traceCtx, t.traceEnd = t.conn.traceInbound(TraceTunnel, t.conn.cluster, headers)
+}
@@ -0,0 +1,112 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// Context key under which the test tracer stores the propagated trace id.
+type traceTestKey struct{}
+
+// Tracer propagating a fixed trace id and recording the finished spans.
+type traceTestTracer struct {
+	id    string
+	spans chan string
+}
+
+func (t *traceTestTracer) StartOutbound(ctx context.Context, op TraceOp, target string) (map[string]string, func(error)) {
+	return map[string]string{"trace-id": t.id}, func(error) { t.spans <- "out:" + string(op) }
+}
+
+func (t *traceTestTracer) StartInbound(op TraceOp, target string, headers map[string]string) (context.Context, func(error)) {
+	ctx := context.WithValue(context.Background(), traceTestKey{}, headers["trace-id"])
+	return ctx, func(error) { t.spans <- "in:" + string(op) }
+}
+
+// Service handler for the tracing tests, replying with the received trace id.
+type traceTestHandler struct {
+	conn *Connection
+}
+
+func (t *traceTestHandler) Init(conn *Connection) error              { t.conn = conn; return nil }
+func (t *traceTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (t *traceTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (t *traceTestHandler) HandleTunnel(tun *Tunnel)                 { panic("not implemented") }
+func (t *traceTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (t *traceTestHandler) HandleRequestCtx(ctx context.Context, req []byte) ([]byte, error) {
+	id, _ := ctx.Value(traceTestKey{}).(string)
+	return append(req, id...), nil
+}
+
+// Tests that trace headers are propagated through requests and stripped from
+// the delivered payloads.
+func TestTraceRequest(t *testing.T) {
+	// Register a new traced service to the relay
+	handler := new(traceTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	servTracer := &traceTestTracer{spans: make(chan string, 1)}
+	handler.conn.SetTracer(servTracer)
+
+	// Connect a traced client and issue a request
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	connTracer := &traceTestTracer{id: "0xdeadbeef", spans: make(chan string, 1)}
+	conn.SetTracer(connTracer)
+
+	reply, err := conn.Request(config.cluster, []byte("request:"), time.Second)
+	if err != nil {
+		t.Fatalf("request failed: %v.", err)
+	}
+	if want := []byte("request:0xdeadbeef"); !bytes.Equal(reply, want) {
+		t.Fatalf("reply mismatch: have %s, want %s.", reply, want)
+	}
+	// Verify that both sides finished their spans
+	for _, check := range []struct {
+		spans chan string
+		want  string
+	}{{connTracer.spans, "out:request"}, {servTracer.spans, "in:request"}} {
+		select {
+		case span := <-check.spans:
+			if span != check.want {
+				t.Fatalf("span mismatch: have %s, want %s.", span, check.want)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("span not finished: %s.", check.want)
+		}
+	}
+}
+
+// Tests the trace header encoding, including payloads without headers.
+func TestTraceEnvelope(t *testing.T) {
+	headers := map[string]string{"traceparent": "00-abc-def-01", "empty": ""}
+	payload := []byte("some payload")
+
+	have, data := unwrapTrace(wrapTrace(headers, payload))
+	if len(have) != len(headers) || have["traceparent"] != headers["traceparent"] {
+		t.Fatalf("headers mismatch: have %v, want %v.", have, headers)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("payload mismatch: have %s, want %s.", data, payload)
+	}
+	if have, data := unwrapTrace(payload); have != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("plain message altered: headers %v, payload %s.", have, data)
+	}
+}
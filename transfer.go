@@ -0,0 +1,167 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the streaming of arbitrarily large content over tunnels, without
+// requiring the whole payload to be held in memory.
+
+package iris
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+)
+
+// Transfer frame tags
+const (
+	transferData   byte = 0x00 // Content piece
+	transferEnd         = 0x01 // Successful end of the content
+	transferFault       = 0x02 // Local failure, aborting the transfer
+	transferResume      = 0x03 // Resumable transfer header, carrying its ID
+	transferOffset      = 0x04 // Content offset to resume a transfer from
+)
+
+// Callback reporting the number of content bytes transferred so far.
+type TransferProgress func(bytes int64)
+
+// Streams the content of a reader to the remote endpoint in chunk limit sized
+// pieces, blocking until the whole content is handed to the local Iris node.
+// The remote side needs to consume it via RecvStream. If reading fails, the
+// transfer is aborted on the remote side too.
+//
+// The progress callback, if not nil, is invoked after every piece. Use the
+// tunnel's write deadline to bound the transfer. Concurrent sends are held back
+// until the transfer completes, so they cannot interleave with its pieces.
+func (t *Tunnel) SendStream(reader io.Reader, progress TransferProgress) (int64, error) {
+	atomic.AddInt32(&t.sending, 1)
+	defer atomic.AddInt32(&t.sending, -1)
+
+	// Hold the tunnel for the whole transfer
+	t.sendLock.Lock()
+	defer t.sendLock.Unlock()
+
+	return t.sendPieces(reader, 0, progress)
+}
+
+// Streams the content of a reader in pieces, terminated by an end or a fault
+// frame. The sent count starts from the given offset (resumed transfers). The
+// send lock is assumed to be held.
+func (t *Tunnel) sendPieces(reader io.Reader, sent int64, progress TransferProgress) (int64, error) {
+	// Keep pieces (along with the tag and a compression flag) within one chunk
+	size := t.chunkLimit - 2
+	if size < 1 {
+		size = 1
+	}
+	buffer := make([]byte, 1+size)
+	buffer[0] = transferData
+
+	for {
+		n, err := reader.Read(buffer[1:])
+		if n > 0 {
+			if err := t.sendPiece(buffer[:1+n]); err != nil {
+				return sent, err
+			}
+			sent += int64(n)
+			if progress != nil {
+				progress(sent)
+			}
+		}
+		switch {
+		case err == io.EOF:
+			return sent, t.sendPiece([]byte{transferEnd})
+		case err != nil:
+			t.Log.Debug("stream transfer failed", "reason", err)
+			if err := t.sendPiece(append([]byte{transferFault}, err.Error()...)); err != nil {
+				return sent, err
+			}
+			return sent, err
+		}
+	}
+}
+
+// Sends a single frame of a content stream. The send lock is assumed to be held.
+func (t *Tunnel) sendPiece(frame []byte) error {
+	frame, err := t.conn.encodePayload(TraceTunnel, frame)
+	if err != nil {
+		return err
+	}
+	if err := t.sendMessage(context.Background(), frame, nil); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.stats.msgsOut, 1)
+	return nil
+}
+
+// Receives a content stream sent via SendStream from the remote endpoint,
+// writing it into the writer piece by piece. Remote failures are reported as a
+// RemoteError.
+//
+// The progress callback, if not nil, is invoked after every piece. Use the
+// tunnel's read deadline to bound the transfer.
+func (t *Tunnel) RecvStream(writer io.Writer, progress TransferProgress) (int64, error) {
+	return t.recvPieces(writer, 0, progress)
+}
+
+// Receives the pieces of a content stream until its end or fault frame. The
+// received count starts from the given offset (resumed transfers).
+func (t *Tunnel) recvPieces(writer io.Writer, recvd int64, progress TransferProgress) (int64, error) {
+	for {
+		// Fetch the next frame and interpret it
+		frame, err := t.Recv(0)
+		switch {
+		case err == io.EOF:
+			return recvd, io.ErrUnexpectedEOF
+		case err != nil:
+			return recvd, err
+		case frame[0] == transferData:
+			if _, err := writer.Write(frame[1:]); err != nil {
+				return recvd, err
+			}
+			recvd += int64(len(frame) - 1)
+			if progress != nil {
+				progress(recvd)
+			}
+		case frame[0] == transferEnd:
+			return recvd, nil
+		case frame[0] == transferFault:
+			return recvd, &RemoteError{Reason: string(frame[1:])}
+		default:
+			return recvd, fmt.Errorf("%w: invalid transfer frame", ErrProtocolViolation)
+		}
+	}
+}
+
+// Streams the content of a local file to the remote endpoint. See SendStream
+// for the details.
+func (t *Tunnel) SendFile(path string, progress TransferProgress) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return t.SendStream(file, progress)
+}
+
+// Receives a content stream from the remote endpoint into a local file, which
+// is created or truncated. If the transfer fails, the partial file is removed.
+// See RecvStream for the details.
+func (t *Tunnel) RecvFile(path string, progress TransferProgress) (int64, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return 0, err
+	}
+	recvd, err := t.RecvStream(file, progress)
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(path)
+	}
+	return recvd, err
+}
@@ -0,0 +1,267 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// Service handler for the transfer tests, storing inbound streams into files.
+type transferTestHandler struct {
+	conn  *Connection
+	path  string
+	recvd chan error
+}
+
+func (t *transferTestHandler) Init(conn *Connection) error              { t.conn = conn; return nil }
+func (t *transferTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (t *transferTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (t *transferTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (t *transferTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	_, err := tun.RecvFile(t.path, nil)
+	t.recvd <- err
+}
+
+// Reader failing after returning some data.
+type transferFailingReader struct {
+	data []byte
+}
+
+func (r *transferFailingReader) Read(p []byte) (int, error) {
+	if len(r.data) == 0 {
+		return 0, errors.New("reader failure")
+	}
+	n := copy(p, r.data)
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// Tests that large files can be streamed through a tunnel.
+func TestTunnelTransfer(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		size int
+	}{256 * 1024}
+
+	// Create a file with random content to transfer
+	dir := t.TempDir()
+	src, dst := filepath.Join(dir, "src"), filepath.Join(dir, "dst")
+
+	data := make([]byte, conf.size)
+	rand.Read(data)
+	if err := os.WriteFile(src, data, 0600); err != nil {
+		t.Fatalf("failed to create source file: %v.", err)
+	}
+	// Register a new transfer service to the relay
+	handler := &transferTestHandler{
+		path:  dst,
+		recvd: make(chan error, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Stream the file over and verify the progress and the content
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	var progress int64
+	sent, err := tunnel.SendFile(src, func(bytes int64) { progress = bytes })
+	if err != nil {
+		t.Fatalf("failed to send file: %v.", err)
+	}
+	if sent != int64(conf.size) || progress != int64(conf.size) {
+		t.Fatalf("progress mismatch: have %d sent/%d reported, want %d.", sent, progress, conf.size)
+	}
+	select {
+	case err := <-handler.recvd:
+		if err != nil {
+			t.Fatalf("failed to receive file: %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("file reception timed out.")
+	}
+	back, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read destination file: %v.", err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Fatalf("content mismatch.")
+	}
+	// Abort a transfer midway and verify the partial file is cleaned up
+	tunnel, err = handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if _, err := tunnel.SendStream(&transferFailingReader{data: data}, nil); err == nil {
+		t.Fatalf("failing stream succeeded.")
+	}
+	select {
+	case err := <-handler.recvd:
+		if _, ok := err.(*RemoteError); !ok {
+			t.Fatalf("failure mismatch: have %v, want remote error.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("file reception timed out.")
+	}
+	if _, err := os.Stat(dst); !os.IsNotExist(err) {
+		t.Fatalf("partial file not removed: %v.", err)
+	}
+}
+
+// Service handler for the resumable transfer tests, storing inbound transfers
+// into a directory.
+type resumeTestHandler struct {
+	conn  *Connection
+	dir   string
+	recvd chan error
+}
+
+func (r *resumeTestHandler) Init(conn *Connection) error              { r.conn = conn; return nil }
+func (r *resumeTestHandler) HandleBroadcast(msg []byte)               { panic("not implemented") }
+func (r *resumeTestHandler) HandleRequest(req []byte) ([]byte, error) { panic("not implemented") }
+func (r *resumeTestHandler) HandleDrop(reason error)                  { panic("not implemented") }
+
+func (r *resumeTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	_, _, err := tun.RecvFileResumable(r.dir, nil)
+	r.recvd <- err
+}
+
+// Seekable reader failing once reaching a limit offset.
+type transferFailingSeeker struct {
+	*bytes.Reader
+	limit int64
+}
+
+func (r *transferFailingSeeker) Read(p []byte) (int, error) {
+	pos := r.Size() - int64(r.Len())
+	if pos >= r.limit {
+		return 0, errors.New("reader failure")
+	}
+	if rest := r.limit - pos; int64(len(p)) > rest {
+		p = p[:rest]
+	}
+	return r.Reader.Read(p)
+}
+
+// Tests that an interrupted transfer can be resumed over a new tunnel from the
+// content the remote side already stored.
+func TestTunnelTransferResume(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		size  int
+		limit int64
+	}{256 * 1024, 100 * 1024}
+
+	data := make([]byte, conf.size)
+	rand.Read(data)
+
+	// Register a new resumable transfer service to the relay
+	handler := &resumeTestHandler{
+		dir:   t.TempDir(),
+		recvd: make(chan error, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Interrupt a transfer midway and verify the partial content is kept
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	failing := &transferFailingSeeker{Reader: bytes.NewReader(data), limit: conf.limit}
+	if sent, err := tunnel.SendResumable("blob", failing, nil); err == nil || sent != conf.limit {
+		t.Fatalf("interrupted transfer mismatch: have %d/%v, want %d/failure.", sent, err, conf.limit)
+	}
+	select {
+	case err := <-handler.recvd:
+		if _, ok := err.(*RemoteError); !ok {
+			t.Fatalf("failure mismatch: have %v, want remote error.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("transfer reception timed out.")
+	}
+	dst := filepath.Join(handler.dir, "blob")
+	if info, err := os.Stat(dst); err != nil || info.Size() != conf.limit {
+		t.Fatalf("partial file mismatch: have %v/%v, want %d bytes.", info, err, conf.limit)
+	}
+	// Resume the transfer over a new tunnel and verify only the rest is sent
+	tunnel, err = handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	first := int64(-1)
+	sent, err := tunnel.SendResumable("blob", bytes.NewReader(data), func(bytes int64) {
+		if first < 0 {
+			first = bytes
+		}
+	})
+	if err != nil {
+		t.Fatalf("failed to resume transfer: %v.", err)
+	}
+	if sent != int64(conf.size) || first <= conf.limit {
+		t.Fatalf("progress mismatch: have %d sent/%d first reported, want %d/above %d.", sent, first, conf.size, conf.limit)
+	}
+	select {
+	case err := <-handler.recvd:
+		if err != nil {
+			t.Fatalf("failed to receive transfer: %v.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("transfer reception timed out.")
+	}
+	back, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatalf("failed to read destination file: %v.", err)
+	}
+	if !bytes.Equal(back, data) {
+		t.Fatalf("content mismatch.")
+	}
+	// Verify that transfers escaping the directory are refused
+	tunnel, err = handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if _, err := tunnel.SendResumable("../blob", bytes.NewReader(data), nil); err == nil {
+		t.Fatalf("escaping transfer accepted.")
+	}
+	select {
+	case err := <-handler.recvd:
+		if !errors.Is(err, ErrInvalidArgument) {
+			t.Fatalf("failure mismatch: have %v, want invalid argument.", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("transfer reception timed out.")
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the pluggable transports carrying the relay link, and the default
+// network based implementations.
+
+package iris
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+)
+
+// Transport establishing the links to the local relay node, through which the
+// binary relay protocol is carried. Custom transports allow tunnelling the
+// protocol through websockets, in-memory pipes or test harnesses.
+type RelayTransport interface {
+	// Dial establishes a new link to the relay, aborting if the context is
+	// cancelled. It is invoked again for every reconnection attempt.
+	Dial(ctx context.Context) (RelayLink, error)
+}
+
+// Live link to the relay node established by a transport.
+//
+// Frames are opaque, ordered segments of the relay protocol stream: protocol
+// message boundaries need not align with them. Reads and writes are each issued
+// from a single go-routine at a time, but may run concurrently with each other.
+// Close may be called at any time, and should unblock any pending operation.
+type RelayLink interface {
+	// ReadFrame retrieves the next segment of the inbound stream. The returned
+	// slice needs to remain valid only until the next call.
+	ReadFrame() ([]byte, error)
+
+	// WriteFrame sends the next segment of the outbound stream.
+	WriteFrame(frame []byte) error
+
+	// Close tears down the link, failing any blocked reads and writes.
+	Close() error
+}
+
+// Transport dialing the relay through a stream oriented network.
+type netTransport struct {
+	network string      // Network to dial the relay on ("tcp" or "unix")
+	address string      // Address of the relay on the network
+	tlsConf *tls.Config // TLS configuration of the link, nil if plain
+}
+
+// Creates the default transport, dialing the relay on the local TCP port,
+// optionally secured by TLS. If tlsConf doesn't specify the server name, it is
+// set to localhost.
+func NewTCPTransport(port int, tlsConf *tls.Config) RelayTransport {
+	return &netTransport{network: "tcp", address: fmt.Sprintf("localhost:%d", port), tlsConf: tlsConf}
+}
+
+// Creates a transport dialing the relay through the unix domain socket at path.
+func NewUnixTransport(path string) RelayTransport {
+	return &netTransport{network: "unix", address: path}
+}
+
+// Dials the relay endpoint (over TLS if configured).
+func (t *netTransport) Dial(ctx context.Context) (RelayLink, error) {
+	var dialer interface {
+		DialContext(ctx context.Context, network, addr string) (net.Conn, error)
+	}
+	if t.tlsConf != nil {
+		dialer = &tls.Dialer{Config: t.tlsConf}
+	} else {
+		dialer = new(net.Dialer)
+	}
+	sock, err := dialer.DialContext(ctx, t.network, t.address)
+	if err != nil {
+		return nil, err
+	}
+	return newConnLink(sock), nil
+}
+
+func (t *netTransport) String() string {
+	if t.tlsConf != nil {
+		return t.network + "+tls://" + t.address
+	}
+	return t.network + "://" + t.address
+}
+
+// Transport carrying the relay link over arbitrary stream connections (e.g. a
+// websocket adapter or an in-memory pipe), opened by a dial callback.
+type connTransport struct {
+	dial func(ctx context.Context) (net.Conn, error)
+}
+
+// Creates a transport carrying the relay link over the stream connections opened
+// by the dial callback.
+func NewConnTransport(dial func(ctx context.Context) (net.Conn, error)) RelayTransport {
+	return &connTransport{dial: dial}
+}
+
+// Opens a new stream connection via the dial callback.
+func (t *connTransport) Dial(ctx context.Context) (RelayLink, error) {
+	sock, err := t.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newConnLink(sock), nil
+}
+
+// Relay link backed by a stream connection, read and written directly by the
+// connection's buffers instead of frame by frame.
+type connLink struct {
+	net.Conn
+	frame []byte // Buffer to read frames into, if used as a plain link
+}
+
+// Wraps a stream connection into a relay link.
+func newConnLink(sock net.Conn) *connLink {
+	return &connLink{Conn: sock}
+}
+
+// Reads whatever the stream has available as the next frame.
+func (l *connLink) ReadFrame() ([]byte, error) {
+	if l.frame == nil {
+		l.frame = make([]byte, 4096)
+	}
+	n, err := l.Read(l.frame)
+	if n > 0 {
+		return l.frame[:n], nil
+	}
+	return nil, err
+}
+
+// Writes the frame into the stream.
+func (l *connLink) WriteFrame(frame []byte) error {
+	_, err := l.Write(frame)
+	return err
+}
+
+// Stream reader consuming the frames of a relay link.
+type frameReader struct {
+	link  RelayLink
+	frame []byte // Unconsumed part of the last frame
+}
+
+func (r *frameReader) Read(p []byte) (int, error) {
+	for len(r.frame) == 0 {
+		frame, err := r.link.ReadFrame()
+		if err != nil {
+			return 0, err
+		}
+		r.frame = frame
+	}
+	n := copy(p, r.frame)
+	r.frame = r.frame[n:]
+	return n, nil
+}
+
+// Stream writer sending each flushed batch as a frame of a relay link.
+type frameWriter struct {
+	link RelayLink
+}
+
+func (w *frameWriter) Write(p []byte) (int, error) {
+	if err := w.link.WriteFrame(p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Creates the stream accessors of a relay link. Links backed by plain streams
+// are accessed directly, skipping the framing.
+func linkStreams(link RelayLink) (io.Reader, io.Writer) {
+	if stream, ok := link.(io.ReadWriter); ok {
+		return stream, stream
+	}
+	return &frameReader{link: link}, &frameWriter{link: link}
+}
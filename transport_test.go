@@ -0,0 +1,91 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Transport wrapping the default one into frame-only links, counting the frames.
+type transportTestTransport struct {
+	inner  RelayTransport
+	reads  int32
+	writes int32
+}
+
+func (t *transportTestTransport) Dial(ctx context.Context) (RelayLink, error) {
+	link, err := t.inner.Dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &transportTestLink{inner: link, owner: t}, nil
+}
+
+// Relay link exposing only the frame based methods of the wrapped link.
+type transportTestLink struct {
+	inner RelayLink
+	owner *transportTestTransport
+}
+
+func (l *transportTestLink) ReadFrame() ([]byte, error) {
+	atomic.AddInt32(&l.owner.reads, 1)
+	return l.inner.ReadFrame()
+}
+
+func (l *transportTestLink) WriteFrame(frame []byte) error {
+	atomic.AddInt32(&l.owner.writes, 1)
+	return l.inner.WriteFrame(frame)
+}
+
+func (l *transportTestLink) Close() error { return l.inner.Close() }
+
+// Tests that services and clients can communicate through custom transports.
+func TestTransport(t *testing.T) {
+	// Register a new service through a custom transport
+	servTrans := &transportTestTransport{inner: NewTCPTransport(config.relay, nil)}
+	serv, err := RegisterTransport(servTrans, config.cluster, new(requestTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Connect to the local relay through a custom transport too
+	connTrans := &transportTestTransport{inner: NewTCPTransport(config.relay, nil)}
+	conn, err := ConnectTransport(connTrans)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Exchange a few requests and verify the frame based links were used
+	for i := 0; i < 10; i++ {
+		request := bytes.Repeat([]byte{byte(i)}, 100*(i+1))
+		reply, err := conn.Request(config.cluster, request, time.Second)
+		if err != nil {
+			t.Fatalf("request %d failed: %v.", i, err)
+		}
+		if bytes.Compare(reply, request) != 0 {
+			t.Fatalf("reply %d mismatch: have %v, want %v.", i, reply, request)
+		}
+	}
+	for i, trans := range []*transportTestTransport{servTrans, connTrans} {
+		if atomic.LoadInt32(&trans.reads) == 0 || atomic.LoadInt32(&trans.writes) == 0 {
+			t.Fatalf("transport #%d unused: %d reads, %d writes.", i, trans.reads, trans.writes)
+		}
+	}
+	// Verify that nil transports are rejected
+	if _, err := ConnectTransport(nil); err == nil {
+		t.Fatalf("nil transport connection succeeded.")
+	}
+	if _, err := RegisterTransport(nil, config.cluster, new(requestTestHandler), nil); err == nil {
+		t.Fatalf("nil transport registration succeeded.")
+	}
+}
@@ -0,0 +1,73 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the expiring broadcasts and events, discarded by the recipients if
+// still waiting in their local delivery queues once their time-to-live passed.
+//
+// The current relay protocol (v1.0-draft2) has no notion of message expiration,
+// so the deadline is prefixed to the message and enforced by the receiving
+// bindings only, older ones delivering it along with the payload and never
+// expiring it. The deadline is absolute, so the clocks of the sender and the
+// recipients need to be reasonably in sync.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"time"
+)
+
+// Magic prefix marking the messages carrying an expiration deadline.
+var ttlMagic = []byte("\x00iris-ttl\x00")
+
+// Publishes an event to topic like Publish, attaching a time-to-live to it. The
+// subscribers discard the event instead of handling it if it's still waiting in
+// their local delivery queue (e.g. behind a backlog or a paused subscription)
+// once the ttl passed since publishing.
+func (c *Connection) PublishTTL(topic string, event []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return invalidArgument("non-positive ttl %v", ttl)
+	}
+	return c.publishCtx(context.Background(), topic, event, "", false, ttl)
+}
+
+// Broadcasts a message to all members of a cluster like Broadcast, attaching a
+// time-to-live to it. The members discard the message instead of handling it if
+// it's still waiting in their local delivery queue once the ttl passed since
+// broadcasting.
+func (c *Connection) BroadcastTTL(cluster string, message []byte, ttl time.Duration) error {
+	if ttl <= 0 {
+		return invalidArgument("non-positive ttl %v", ttl)
+	}
+	return c.broadcastCtx(context.Background(), cluster, message, ttl)
+}
+
+// Prepends the expiration deadline of the given time-to-live to a message.
+func wrapTTL(ttl time.Duration, message []byte) []byte {
+	blob := make([]byte, len(ttlMagic)+8+len(message))
+	copy(blob, ttlMagic)
+	binary.BigEndian.PutUint64(blob[len(ttlMagic):], uint64(time.Now().Add(ttl).UnixNano()))
+	copy(blob[len(ttlMagic)+8:], message)
+	return blob
+}
+
+// Splits the expiration deadline off a message, if any. Messages without one
+// are returned as is, along with the zero time.
+func unwrapTTL(message []byte) (time.Time, []byte) {
+	if !bytes.HasPrefix(message, ttlMagic) || len(message) < len(ttlMagic)+8 {
+		return time.Time{}, message
+	}
+	deadline := int64(binary.BigEndian.Uint64(message[len(ttlMagic):]))
+	return time.Unix(0, deadline), message[len(ttlMagic)+8:]
+}
+
+// Checks whether a message with the given deadline expired already. Messages
+// without a deadline never expire.
+func expired(deadline time.Time) bool {
+	return !deadline.IsZero() && time.Now().After(deadline)
+}
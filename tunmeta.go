@@ -0,0 +1,133 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the per-message metadata of tunnels, letting receivers route or
+// deserialize messages without peeking into their payloads.
+//
+// Since the relay protocol has no notion of message headers, the metadata is
+// embedded behind a magic prefix at the head of the message (thus travelling in
+// its first chunk, compressed and encrypted along with it), which older remote
+// bindings deliver as part of the payload.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"time"
+)
+
+// Prefix identifying a tunnel message carrying embedded metadata.
+var tunnelMetaMagic = []byte("\x00iris-tunmeta\x00")
+
+// Maximum size of the encoded metadata of a single tunnel message.
+const maxMessageMeta = 4 * 1024
+
+// Metadata attached to a single tunnel message.
+type MessageMeta struct {
+	ContentType string            // Media type of the payload, empty if unset
+	Headers     map[string]string // Application defined key-value pairs
+}
+
+// Sends a message over the tunnel like Send, attaching the metadata to it. The
+// encoded metadata may be at most 4KB. Receivers not using RecvMeta (or
+// RecvPooled) get the payload alone.
+func (t *Tunnel) SendMeta(message []byte, meta *MessageMeta, timeout time.Duration) error {
+	if len(message) == 0 {
+		return invalidArgument("nil or empty message")
+	}
+	wrapped, err := wrapMessageMeta(meta, message)
+	if err != nil {
+		return err
+	}
+	return t.Send(wrapped, timeout)
+}
+
+// Retrieves a message from the tunnel like Recv, along with its metadata (nil if
+// the sender attached none).
+//
+// Infinite blocking is supported with by setting the timeout to zero (0).
+func (t *Tunnel) RecvMeta(timeout time.Duration) ([]byte, *MessageMeta, error) {
+	msg, err := t.recv(context.Background(), timeout, -1, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	return msg.data, msg.meta, nil
+}
+
+// Embeds the metadata at the head of a message. A nil metadata leaves the
+// message as is.
+func wrapMessageMeta(meta *MessageMeta, message []byte) ([]byte, error) {
+	if meta == nil {
+		return message, nil
+	}
+	buf := new(bytes.Buffer)
+	buf.Write(tunnelMetaMagic)
+
+	var scratch [binary.MaxVarintLen64]byte
+	put := func(data string) {
+		buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(data)))])
+		buf.WriteString(data)
+	}
+	put(meta.ContentType)
+	buf.Write(scratch[:binary.PutUvarint(scratch[:], uint64(len(meta.Headers)))])
+	for key, value := range meta.Headers {
+		put(key)
+		put(value)
+	}
+	if size := buf.Len() - len(tunnelMetaMagic); size > maxMessageMeta {
+		return nil, invalidArgument("message metadata too large: %d > %d bytes", size, maxMessageMeta)
+	}
+	buf.Write(message)
+	return buf.Bytes(), nil
+}
+
+// Splits the embedded metadata off a message, if any. Messages without metadata
+// (or with malformed one) are returned as is.
+func unwrapMessageMeta(message []byte) (*MessageMeta, []byte) {
+	if !bytes.HasPrefix(message, tunnelMetaMagic) {
+		return nil, message
+	}
+	reader := bytes.NewReader(message[len(tunnelMetaMagic):])
+	get := func() (string, error) {
+		size, err := binary.ReadUvarint(reader)
+		if err != nil {
+			return "", err
+		}
+		if size > uint64(reader.Len()) {
+			return "", errors.New("metadata overflow")
+		}
+		data := make([]byte, size)
+		reader.Read(data)
+		return string(data), nil
+	}
+	contentType, err := get()
+	if err != nil {
+		return nil, message
+	}
+	count, err := binary.ReadUvarint(reader)
+	if err != nil || count > uint64(reader.Len()) {
+		return nil, message
+	}
+	meta := &MessageMeta{ContentType: contentType}
+	if count > 0 {
+		meta.Headers = make(map[string]string, count)
+	}
+	for i := uint64(0); i < count; i++ {
+		key, err := get()
+		if err != nil {
+			return nil, message
+		}
+		value, err := get()
+		if err != nil {
+			return nil, message
+		}
+		meta.Headers[key] = value
+	}
+	return meta, message[len(message)-reader.Len():]
+}
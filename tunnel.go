@@ -7,13 +7,12 @@
 package iris
 
 import (
-	"errors"
-	"fmt"
+	"context"
+	"crypto/cipher"
+	"io"
 	"sync"
+	"sync/atomic"
 	"time"
-
-	"github.com/project-iris/iris/container/queue"
-	"gopkg.in/inconshreveable/log15.v2"
 )
 
 // Communication stream between the local application and a remote endpoint. The
@@ -25,26 +24,100 @@ type Tunnel struct {
 
 	// Chunking fields
 	chunkLimit int    // Maximum length of a data payload
-	chunkBuf   []byte // Current message being assembled
+	chunkBuf   []byte // Current message being assembled (pooled buffer)
+	chunkSize  int    // Total size of the message being assembled
+	chunkLeft  int    // Bytes missing from the message being streamed, zero if none
+	chunkSkip  int    // Bytes missing from the message being rejected, zero if none
+
+	chunkLast  time.Time   // Arrival time of the last chunk of the message being assembled
+	chunkTimer *time.Timer // Timer discarding the message being assembled if stalled
+	chunkLock  sync.Mutex  // Protects the assembly fields from the stall timer
+
+	limits *TunnelConfig // Buffer and chunking limits of the tunnel
 
 	// Quality of service fields
-	itoaBuf  *queue.Queue  // Iris to application message buffer
+	itoaBuf  Queue         // Iris to application message buffer
 	itoaSign chan struct{} // Message arrival signaler
-	itoaLock sync.Mutex    // Protects the buffer and signaler
+	itoaEOF  bool          // Flag whether the remote side closed its write end
+	itoaUsed int           // Wire size of the buffered messages
+	itoaLock sync.Mutex    // Protects the buffer, signaler, EOF flag and usage
+
+	streamBuf  Queue // Continuation chunks of the streamed inbound messages (unbounded)
+	streamOpen bool  // Flag whether a streamed message is partially consumed
 
 	atoiSpace int           // Application to Iris space allowance
 	atoiSign  chan struct{} // Allowance grant signaler
 	atoiLock  sync.Mutex    // Protects the allowance and signaler
+	atoiEOF   int32         // Flag whether the local write end was closed
+	sendLock  sync.Mutex    // Serializes message sends (chunks must not interleave)
+	sendGate  *chunkGate    // Schedules the outbound chunks by priority
+	sending   int32         // Number of sends in flight (graceful shutdown)
+
+	sendRate *tokenBucket // Outbound byte rate limiter of the tunnel, nil if unlimited
+	rateLock sync.Mutex   // Protects the rate limiter from replacement
+
+	compress   Compressor  // Negotiated outbound compression, nil if disabled
+	decompress Compressor  // Negotiated inbound compression, nil if disabled
+	answer     chan string // Compression negotiation result for outbound tunnels
+
+	seal     cipher.AEAD // End-to-end encryption of outbound messages, nil if disabled
+	open     cipher.AEAD // End-to-end decryption of inbound messages, nil if unset
+	sealedIn bool        // Flag whether the remote side switched to encryption
+
+	readDl  *deadline // Deadline of the receive operations
+	writeDl *deadline // Deadline of the send operations
+
+	// Instrumentation fields
+	stats *tunnelStats // Live throughput and flow control counters
+
+	// Tracing fields
+	traceCtx context.Context // Trace context of the tunnel (inbound: set by the header message)
+	traceEnd func(error)     // Callback ending the tunnel's span, if any
+
+	hookInfo  *TunnelInfo       // Details reported to the lifecycle hooks, nil if unhooked
+	hookClose func(*TunnelInfo) // Close hook to report the tear-down to, if any
+
+	auditSink AuditSink // Audit sink to record the tear-down to, nil if unaudited
+	auditTime time.Time // Time instance the inbound tunnel was accepted at
+	auditFail error     // Rejection of the inbound tunnel, overriding the tear-down reason
+
+	closeFuncs []func(reason error) // Callbacks notified of the tunnel tear-down (inbound lock)
+
+	// Identity fields
+	peer *Peer // Identity announced by the initiator of an inbound tunnel
 
 	// Bookkeeping fields
 	init chan bool     // Initialization channel for outbound tunnels
 	term chan struct{} // Channel to signal termination to blocked go-routines
 	stat error         // Failure reason, if any received
+	dead int32         // Flag whether the keepalive deemed the peer dead
+	idle int32         // Flag whether the idle timeout closed the tunnel
 
-	Log log15.Logger // Logger with connection and tunnel ids injected
+	Log Logger // Logger with connection and tunnel ids injected
 }
 
-func (c *Connection) newTunnel() (*Tunnel, error) {
+// Inbound message queued for the application, along with its size on the wire
+// (i.e. the allowance to grant back upon consumption), the pooled buffer it was
+// assembled in (which the data may or may not alias) and any attached metadata.
+// Encrypted messages also retain the decompressor to apply after decryption. The
+// chunks of streamed messages are flagged whether more follow or the message was
+// cut short.
+type inboundMessage struct {
+	data []byte
+	size int
+	buf  []byte
+	meta *MessageMeta
+
+	sealed     bool
+	decompress Compressor
+
+	more  bool
+	abort bool
+}
+
+// Creates a new local tunnel endpoint with the given limits, or the connection
+// wide ones if nil.
+func (c *Connection) newTunnel(limits *TunnelConfig) (*Tunnel, error) {
 	c.tunLock.Lock()
 	defer c.tunLock.Unlock()
 
@@ -52,20 +125,33 @@ func (c *Connection) newTunnel() (*Tunnel, error) {
 	if c.tunLive == nil {
 		return nil, ErrClosed
 	}
+	if limits == nil {
+		limits = c.tunConf
+	}
 	// Assign a new locally unique id to the tunnel
 	tunId := c.tunIdx
 	c.tunIdx++
 
 	// Assemble and store the live tunnel
 	tun := &Tunnel{
-		id:   tunId,
-		conn: c,
+		id:     tunId,
+		conn:   c,
+		limits: limits,
 
-		itoaBuf:  queue.New(),
+		itoaBuf:  newQueue(limits.Queue),
 		itoaSign: make(chan struct{}, 1),
 		atoiSign: make(chan struct{}, 1),
+		sendGate: newChunkGate(),
+		readDl:   newDeadline(),
+		writeDl:  newDeadline(),
+
+		streamBuf: NewRingQueue(0, 0),
+		sendRate:  newTokenBucket(limits.SendRate),
 
-		init: make(chan bool),
+		answer: make(chan string, 1),
+		stats:  new(tunnelStats),
+
+		init: make(chan bool, 1),
 		term: make(chan struct{}),
 
 		Log: c.Log.New("tunnel", tunId),
@@ -76,17 +162,35 @@ func (c *Connection) newTunnel() (*Tunnel, error) {
 }
 
 // Initiates a new tunnel to a remote cluster.
-func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel, error) {
+func (c *Connection) initTunnel(ctx context.Context, cluster string, timeout time.Duration, limits *TunnelConfig) (*Tunnel, error) {
+	var tun *Tunnel
+	_, err := c.interceptOutbound(ctx, TraceTunnel, cluster, nil, func(ctx context.Context, _ TraceOp, cluster string, _ []byte) ([]byte, error) {
+		var err error
+		tun, err = c.constructTunnel(ctx, cluster, timeout, limits)
+		return nil, err
+	})
+	// Tear down the tunnel if an interceptor failed after construction
+	if err != nil {
+		if tun != nil {
+			tun.Close()
+		}
+		return nil, err
+	}
+	return tun, nil
+}
+
+// Constructs an outbound tunnel after the outbound interceptors ran.
+func (c *Connection) constructTunnel(ctx context.Context, cluster string, timeout time.Duration, limits *TunnelConfig) (*Tunnel, error) {
 	// Sanity check on the arguments
 	if len(cluster) == 0 {
-		return nil, errors.New("empty cluster identifier")
+		return nil, invalidArgument("empty cluster identifier")
 	}
 	timeoutms := int(timeout.Nanoseconds() / 1000000)
 	if timeoutms < 1 {
-		return nil, fmt.Errorf("invalid timeout %v < 1ms", timeout)
+		return nil, invalidArgument("invalid timeout %v < 1ms", timeout)
 	}
 	// Create a potential tunnel
-	tun, err := c.newTunnel()
+	tun, err := c.newTunnel(limits)
 	if err != nil {
 		return nil, err
 	}
@@ -100,15 +204,33 @@ func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel,
 		case init := <-tun.init:
 			if init {
 				// Send the data allowance
-				if err = c.sendTunnelAllowance(tun.id, defaultTunnelBuffer); err == nil {
-					tun.Log.Info("tunnel construction completed", "chunk_limit", tun.chunkLimit)
-					return tun, nil
+				if err = c.sendTunnelAllowance(tun.id, tun.limits.BufferSize); err == nil {
+					if err = tun.advertise(ctx, timeout); err == nil {
+						err = tun.traceOutbound(ctx, cluster, timeout)
+					}
+					if err == nil {
+						if err = tun.negotiate(time.After(timeout)); err == nil {
+							err = tun.secureConfigured()
+						}
+					}
+					if err == nil {
+						tun.Log.Info("tunnel construction completed", "chunk_limit", tun.chunkLimit)
+						tun.startKeepalive()
+						tun.startIdleTimer()
+						tun.hookOpen(cluster, false)
+						return tun, nil
+					}
+					tun.Close()
 				}
 			} else {
-				err = ErrTimeout
+				err = ErrTunnelBuildTimeout
 			}
+		case <-tun.term:
+			err = ErrClosed
 		case <-c.term:
 			err = ErrClosed
+		case <-ctx.Done():
+			err = ctx.Err()
 		}
 	}
 	// Clean up and return the failure
@@ -123,20 +245,24 @@ func (c *Connection) initTunnel(cluster string, timeout time.Duration) (*Tunnel,
 // Accepts an incoming tunneling request and confirms its local id.
 func (c *Connection) acceptTunnel(initId uint64, chunkLimit int) (*Tunnel, error) {
 	// Create the local tunnel endpoint
-	tun, err := c.newTunnel()
+	tun, err := c.newTunnel(nil)
 	if err != nil {
 		return nil, err
 	}
-	tun.chunkLimit = chunkLimit
-	tun.Log.Info("accepting inbound tunnel", "chunk_limit", chunkLimit)
+	tun.setChunkLimit(chunkLimit)
+	tun.Log.Info("accepting inbound tunnel", "chunk_limit", tun.chunkLimit)
 
 	// Confirm the tunnel creation to the relay node
 	err = c.sendTunnelConfirm(initId, tun.id)
 	if err == nil {
 		// Send the data allowance
-		err = c.sendTunnelAllowance(tun.id, defaultTunnelBuffer)
+		err = c.sendTunnelAllowance(tun.id, tun.limits.BufferSize)
 		if err == nil {
 			tun.Log.Info("tunnel acceptance completed")
+			tun.startKeepalive()
+			tun.startIdleTimer()
+			tun.hookOpen("", true)
+			tun.auditOpen()
 			return tun, nil
 		}
 	}
@@ -148,52 +274,249 @@ func (c *Connection) acceptTunnel(initId uint64, chunkLimit int) (*Tunnel, error
 	return nil, err
 }
 
+// Merges the user requested limits with the defaults.
+func finalizeTunnelConfig(user *TunnelConfig) *TunnelConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultTunnelConfig
+	}
+	// Check each field and merge only non-specified ones
+	limits := new(TunnelConfig)
+	*limits = *user
+
+	if user.BufferSize == 0 {
+		limits.BufferSize = defaultTunnelConfig.BufferSize
+	}
+	if user.ChunkLimit == 0 {
+		limits.ChunkLimit = defaultTunnelConfig.ChunkLimit
+	}
+	if user.KeepAliveMisses == 0 {
+		limits.KeepAliveMisses = defaultTunnelConfig.KeepAliveMisses
+	}
+	return limits
+}
+
+// Sets the outbound chunk limit to the one imposed by the relay, capped by the
+// user configured one, if any, or replaced by it if overriding is enabled.
+func (t *Tunnel) setChunkLimit(relayLimit int) {
+	t.chunkLimit = relayLimit
+	if t.limits.ChunkLimit > 0 && (t.limits.ChunkLimit < relayLimit || t.limits.ChunkOverride) {
+		t.chunkLimit = t.limits.ChunkLimit
+		if t.chunkLimit > relayLimit && !t.conn.RelayFeatures().Has(FeatureLargeChunks) {
+			t.Log.Warn("chunk limit override not advertised by relay", "relay_limit", relayLimit, "chunk_limit", t.chunkLimit)
+		}
+	}
+}
+
 // Sends a message over the tunnel to the remote pair, blocking until the local
 // Iris node receives the message or the operation times out.
 //
 // Infinite blocking is supported with by setting the timeout to zero (0).
+//
+// Sends are safe for concurrent use: the chunks of a message never interleave
+// with those of another, so concurrent messages arrive whole, in the order their
+// sends got hold of the tunnel. A message whose send fails midway is discarded
+// by the remote side instead of being delivered partially.
+//
+// A send timing out while waiting for the remote side to free up buffer space
+// fails with ErrAllowanceExhausted (which matches ErrTimeout via errors.Is, but
+// not via ==).
 func (t *Tunnel) Send(message []byte, timeout time.Duration) error {
 	t.Log.Debug("sending message", "data", logLazyBlob(message), "timeout", logLazyTimeout(timeout))
 
-	// Sanity check on the arguments
-	if message == nil || len(message) == 0 {
-		return errors.New("nil or empty message")
-	}
 	// Create timeout signaler
 	var deadline <-chan time.Time
 	if timeout != 0 {
 		deadline = time.After(timeout)
 	}
-	// Split the original message into bounded chunks
+	if err := t.sendData(context.Background(), message, deadline); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.stats.msgsOut, 1)
+	return nil
+}
+
+// Sends a message over the tunnel to the remote pair, blocking until the local
+// Iris node receives the message or the context is cancelled. See Send for the
+// concurrency guarantees.
+func (t *Tunnel) SendCtx(ctx context.Context, message []byte) error {
+	t.Log.Debug("sending message", "data", logLazyBlob(message))
+	if err := t.sendData(ctx, message, nil); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.stats.msgsOut, 1)
+	return nil
+}
+
+// Splits a message into chunks and sends them one by one to the remote pair,
+// until either completion, deadline expiration or context cancellation.
+func (t *Tunnel) send(ctx context.Context, message []byte, deadline <-chan time.Time) error {
+	atomic.AddInt32(&t.sending, 1)
+	defer atomic.AddInt32(&t.sending, -1)
+
+	// Sanity check on the arguments
+	if message == nil || len(message) == 0 {
+		return invalidArgument("nil or empty message")
+	}
+	// Serialize the sends, keeping the chunks of concurrent messages apart
+	t.sendLock.Lock()
+	defer t.sendLock.Unlock()
+
+	return t.sendMessage(ctx, message, deadline)
+}
+
+// Compresses and encrypts a message if negotiated, and sends it chunk by chunk
+// to the remote pair. The send lock is assumed to be held.
+func (t *Tunnel) sendMessage(ctx context.Context, message []byte, deadline <-chan time.Time) error {
+	if atomic.LoadInt32(&t.atoiEOF) == 1 {
+		return ErrClosed
+	}
+	select {
+	case <-t.term:
+		return t.closedErr()
+	default:
+	}
+	if t.writeDl.expired() {
+		return ErrTimeout
+	}
+	message, err := t.compressMessage(message)
+	if err != nil {
+		return err
+	}
+	if message, err = t.sealMessage(message); err != nil {
+		return err
+	}
+	return t.sendLocked(ctx, message, deadline)
+}
+
+// Splits a message into chunks and sends them to the remote pair. The send lock
+// is assumed to be held.
+//
+// Messages spanning multiple chunks are pipelined: a transmitter goroutine writes
+// the chunks already granted allowance to the relay link, while the next one is
+// waiting for its own allowance (double buffering). High priority frames may
+// still jump ahead between the allowance reservations of the chunks.
+func (t *Tunnel) sendLocked(ctx context.Context, message []byte, deadline <-chan time.Time) error {
+	if len(message) <= t.chunkLimit {
+		// Let any pending high priority frames go first
+		t.sendGate.acquire(PriorityNormal)
+		defer t.sendGate.release()
+
+		return t.sendChunk(ctx, message, len(message), deadline)
+	}
+	var (
+		pipe = make(chan tunnelChunk, 1)
+		done = make(chan error, 1)
+	)
+	go t.transmitChunks(pipe, done)
+
+	var err error
 	for pos := 0; pos < len(message); pos += t.chunkLimit {
 		end := pos + t.chunkLimit
 		if end > len(message) {
 			end = len(message)
 		}
-		sizeOrCont := len(message)
+		chunk := tunnelChunk{data: message[pos:end], sizeOrCont: len(message)}
 		if pos != 0 {
-			sizeOrCont = 0
+			chunk.sizeOrCont = 0
+		}
+		// Let any pending high priority frames go first
+		t.sendGate.acquire(PriorityNormal)
+		err = t.reserveChunk(ctx, len(chunk.data), deadline)
+		t.sendGate.release()
+
+		if err != nil {
+			break
 		}
-		if err := t.sendChunk(message[pos:end], sizeOrCont, deadline); err != nil {
+		select {
+		case pipe <- chunk:
+		case err = <-done:
+			// Transmission failed, the transmitter is gone
 			return err
 		}
 	}
-	return nil
+	close(pipe)
+	if terr := <-done; err == nil {
+		err = terr
+	}
+	return err
+}
+
+// Single message chunk granted allowance, waiting for transmission.
+type tunnelChunk struct {
+	data       []byte // Payload of the chunk
+	sizeOrCont int    // Total message size for the first chunk, zero afterwards
+}
+
+// Transmits the chunks arriving on the pipe until it's closed or a transmission
+// fails, reporting the outcome on the done channel.
+func (t *Tunnel) transmitChunks(pipe chan tunnelChunk, done chan error) {
+	for chunk := range pipe {
+		if err := t.transmitChunk(chunk.data, chunk.sizeOrCont); err != nil {
+			done <- err
+			return
+		}
+	}
+	done <- nil
 }
 
 // Sends a single message chunk to the remote endpoint.
-func (t *Tunnel) sendChunk(chunk []byte, sizeOrCont int, deadline <-chan time.Time) error {
+func (t *Tunnel) sendChunk(ctx context.Context, chunk []byte, sizeOrCont int, deadline <-chan time.Time) error {
+	if err := t.reserveChunk(ctx, len(chunk), deadline); err != nil {
+		return err
+	}
+	return t.transmitChunk(chunk, sizeOrCont)
+}
+
+// Writes a single message chunk, already granted allowance, to the relay link.
+func (t *Tunnel) transmitChunk(chunk []byte, sizeOrCont int) error {
+	if err := t.conn.sendTunnelTransfer(t.id, sizeOrCont, chunk); err != nil {
+		return err
+	}
+	atomic.AddUint64(&t.conn.stats.tunOut, uint64(len(chunk)))
+	atomic.AddUint64(&t.stats.bytesOut, uint64(len(chunk)))
+	atomic.AddUint64(&t.stats.chunksOut, 1)
+	return nil
+}
+
+// Waits until the remote endpoint grants enough space allowance for a chunk of
+// the given size, and reserves it.
+func (t *Tunnel) reserveChunk(ctx context.Context, size int, deadline <-chan time.Time) error {
+	// Wait for the tunnel's own rate limiter, then the connection wide one
+	if err := t.throttle(ctx, size, deadline); err != nil {
+		return err
+	}
+	if err := t.conn.throttle(ctx, t.conn.limiters().tunnels, size, deadline); err != nil {
+		if bucket := t.sendLimiter(); bucket != nil {
+			bucket.cancel(size)
+		}
+		return err
+	}
+	// Track the time spent waiting for allowance
+	var blocked time.Time
+	defer func() {
+		if !blocked.IsZero() {
+			atomic.AddInt64(&t.stats.blocked, int64(time.Since(blocked)))
+		}
+	}()
 	for {
 		// Short circuit if there's enough space allowance already
-		if t.drainAllowance(len(chunk)) {
-			return t.conn.sendTunnelTransfer(t.id, sizeOrCont, chunk)
+		if t.drainAllowance(size) {
+			return nil
+		}
+		if blocked.IsZero() {
+			blocked = time.Now()
 		}
 		// Query for a send allowance
 		select {
 		case <-t.term:
-			return ErrClosed
+			return t.closedErr()
 		case <-deadline:
-			return ErrTimeout
+			return ErrAllowanceExhausted
+		case <-t.writeDl.wait():
+			return ErrAllowanceExhausted
+		case <-ctx.Done():
+			return ctx.Err()
 		case <-t.atoiSign:
 			// Potentially enough space allowance, retry
 			continue
@@ -224,50 +547,226 @@ func (t *Tunnel) drainAllowance(need int) bool {
 //
 // Infinite blocking is supported with by setting the timeout to zero (0).
 func (t *Tunnel) Recv(timeout time.Duration) ([]byte, error) {
+	msg, err := t.recv(context.Background(), timeout, -1, false)
+	if err != nil {
+		return nil, err
+	}
+	return msg.data, nil
+}
+
+// Retrieves a message from the tunnel, blocking until one is available or the
+// context is cancelled.
+func (t *Tunnel) RecvCtx(ctx context.Context) ([]byte, error) {
+	msg, err := t.recv(ctx, 0, -1, false)
+	if err != nil {
+		return nil, err
+	}
+	return msg.data, nil
+}
+
+// Retrieves a message from the tunnel into a caller supplied buffer, blocking
+// until one is available or the operation times out, and returns the message
+// length. If the buffer is too small, io.ErrShortBuffer is returned and the
+// message is left queued.
+//
+// Infinite blocking is supported with by setting the timeout to zero (0).
+func (t *Tunnel) RecvInto(buf []byte, timeout time.Duration) (int, error) {
+	msg, err := t.recv(context.Background(), timeout, len(buf), false)
+	if err != nil {
+		return 0, err
+	}
+	n := copy(buf, msg.data)
+	tunnelBuffers.put(msg.buf)
+	return n, nil
+}
+
+// Inbound tunnel message backed by a pooled buffer.
+type Payload struct {
+	Data []byte       // Contents of the message, valid until released
+	Meta *MessageMeta // Metadata attached by the sender, nil if none
+
+	buf []byte // Pooled buffer backing the message
+}
+
+// Recycles the buffer backing the payload. Neither the payload nor its data may
+// be used afterwards.
+func (p *Payload) Release() {
+	tunnelBuffers.put(p.buf)
+	p.Data, p.buf = nil, nil
+}
+
+// Retrieves a message from the tunnel, blocking until one is available or the
+// operation times out. The payload is backed by a pooled buffer, which should
+// be released after use to recycle it for future messages.
+//
+// Infinite blocking is supported with by setting the timeout to zero (0).
+func (t *Tunnel) RecvPooled(timeout time.Duration) (*Payload, error) {
+	msg, err := t.recv(context.Background(), timeout, -1, false)
+	if err != nil {
+		return nil, err
+	}
+	return &Payload{Data: msg.data, Meta: msg.meta, buf: msg.buf}, nil
+}
+
+// Retrieves a message no longer than limit (negative for any) from the tunnel,
+// blocking until one is available, the timeout expires or the context is
+// cancelled. If chunked, streamed messages are retrieved chunk by chunk.
+func (t *Tunnel) recv(ctx context.Context, timeout time.Duration, limit int, chunked bool) (*inboundMessage, error) {
+	// Fail if the read deadline already expired
+	if t.readDl.expired() {
+		return nil, ErrTimeout
+	}
 	// Short circuit if there's a message already buffered
-	if msg := t.fetchMessage(); msg != nil {
-		return msg, nil
+	if msg, err := t.fetchMessage(limit, chunked); msg != nil || err != nil {
+		return msg, err
 	}
 	// Create the timeout signaler
-	var after <-chan time.Time
+	var deadline <-chan time.Time
 	if timeout != 0 {
-		after = time.After(timeout)
+		deadline = time.After(timeout)
 	}
-	// Wait for a message to arrive
-	select {
-	case <-t.term:
-		return nil, ErrClosed
-	case <-after:
-		return nil, ErrTimeout
-	case <-t.itoaSign:
-		if msg := t.fetchMessage(); msg != nil {
-			return msg, nil
+	for {
+		select {
+		case <-t.term:
+			return nil, t.closedErr()
+		case <-deadline:
+			return nil, ErrTimeout
+		case <-t.readDl.wait():
+			return nil, ErrTimeout
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-t.itoaSign:
+			if msg, err := t.fetchMessage(limit, chunked); msg != nil || err != nil {
+				return msg, err
+			}
+			// Chunk reads may be woken by whole messages, and concurrent receives
+			// may take the signalled message first, keep waiting in both cases
 		}
-		panic("signal raised but message unavailable")
 	}
 }
 
+// Sets both the read and write deadlines of the tunnel. It is equivalent to
+// calling both SetReadDeadline and SetWriteDeadline.
+func (t *Tunnel) SetDeadline(deadline time.Time) error {
+	t.readDl.set(deadline)
+	t.writeDl.set(deadline)
+	return nil
+}
+
+// Sets the deadline for future Recv calls and any currently-blocked one. After
+// expiration, receives fail with ErrTimeout until the deadline is moved into
+// the future or disabled with the zero time. The deadline is enforced on top of
+// any per call timeout, whichever expires first.
+func (t *Tunnel) SetReadDeadline(deadline time.Time) error {
+	t.readDl.set(deadline)
+	return nil
+}
+
+// Sets the deadline for future Send calls and any currently-blocked one. After
+// expiration, sends fail with ErrTimeout until the deadline is moved into the
+// future or disabled with the zero time. The deadline is enforced on top of any
+// per call timeout, whichever expires first.
+func (t *Tunnel) SetWriteDeadline(deadline time.Time) error {
+	t.writeDl.set(deadline)
+	return nil
+}
+
 // Fetches the next buffered message, or nil if none is available. If a message
-// was available, grants the remote side the space allowance just consumed.
-func (t *Tunnel) fetchMessage() []byte {
+// was available, grants the remote side the space allowance just consumed. If
+// the message is longer than the limit (unless negative), io.ErrShortBuffer is
+// returned, leaving it queued. If the buffer is drained and the remote side
+// closed its write end, io.EOF is returned.
+//
+// Streamed messages are only retrieved if chunked, in which case the head chunk
+// is returned, followed by the continuations on subsequent calls. Otherwise they
+// fail with ErrChunked, leaving them queued.
+func (t *Tunnel) fetchMessage(limit int, chunked bool) (*inboundMessage, error) {
 	t.itoaLock.Lock()
 	defer t.itoaLock.Unlock()
 
+	// Continue any partially consumed streamed message first
+	if t.streamOpen {
+		if !chunked {
+			return nil, ErrChunked
+		}
+		return t.fetchContinuation()
+	}
 	if !t.itoaBuf.Empty() {
-		message := t.itoaBuf.Pop().([]byte)
-		go t.conn.sendTunnelAllowance(t.id, len(message))
+		if err := t.openFront(); err != nil {
+			return nil, err
+		}
+		front := t.itoaBuf.Front().(*inboundMessage)
+		if front.more && !chunked {
+			return nil, ErrChunked
+		}
+		if limit >= 0 && len(front.data) > limit {
+			return nil, io.ErrShortBuffer
+		}
+		message := t.itoaBuf.Pop().(*inboundMessage)
+		t.itoaUsed -= message.size
+		t.streamOpen = message.more
+		t.grant(message.size)
 
-		t.Log.Debug("fetching queued message", "data", logLazyBlob(message))
-		return message
+		t.Log.Debug("fetching queued message", "data", logLazyBlob(message.data))
+		return message, nil
+	}
+	if t.itoaEOF {
+		return nil, io.EOF
 	}
 	// No message, reset arrival flag
 	select {
 	case <-t.itoaSign:
 	default:
 	}
+	return nil, nil
+}
+
+// Decrypts the next buffered message if needed, dropping it on failure. The
+// inbound lock is assumed to be held and the buffer to be non-empty.
+func (t *Tunnel) openFront() error {
+	front := t.itoaBuf.Front().(*inboundMessage)
+	if !front.sealed {
+		return nil
+	}
+	if err := t.openMessage(front); err != nil {
+		t.itoaBuf.Pop()
+		t.itoaUsed -= front.size
+		t.grant(front.size)
+		tunnelBuffers.put(front.buf)
+
+		t.Log.Error("failed to decrypt message", "reason", err)
+		return err
+	}
 	return nil
 }
 
+// Closes the write side of the tunnel, signalling an end-of-stream (io.EOF) to
+// the remote endpoint after all previously sent messages are consumed. Local
+// receives remain operational, but the tunnel still needs to be closed after
+// use to release it.
+//
+// Since the relay protocol has no notion of half-closes, the signal is an empty
+// chunk, which older remote bindings deliver as an empty message instead.
+func (t *Tunnel) CloseWrite() error {
+	// Make sure the tunnel is still fully operational
+	if !atomic.CompareAndSwapInt32(&t.atoiEOF, 0, 1) {
+		return ErrClosed
+	}
+	select {
+	case <-t.term:
+		return ErrClosed
+	default:
+	}
+	// Send an empty continuation chunk (which never occurs otherwise) after any
+	// in-flight message
+	t.Log.Info("closing tunnel write side")
+
+	t.sendLock.Lock()
+	defer t.sendLock.Unlock()
+
+	return t.conn.sendTunnelTransfer(t.id, 0, nil)
+}
+
 // Closes the tunnel between the pair. Any blocked read and write operation will
 // terminate with a failure.
 //
@@ -291,7 +790,7 @@ func (t *Tunnel) Close() error {
 // Finalizes the tunnel construction.
 func (t *Tunnel) handleInitResult(chunkLimit int) {
 	if chunkLimit > 0 {
-		t.chunkLimit = chunkLimit
+		t.setChunkLimit(chunkLimit)
 	}
 	t.init <- (chunkLimit > 0)
 }
@@ -311,40 +810,201 @@ func (t *Tunnel) handleAllowance(space int) {
 // Adds the chunk to the currently building message and delivers it upon
 // completion. If a new message starts, the old is discarded.
 func (t *Tunnel) handleTransfer(size int, chunk []byte) {
+	// An empty continuation chunk signals the remote write end closing
+	if size == 0 && len(chunk) == 0 {
+		t.handleCloseWrite()
+		return
+	}
+	atomic.AddUint64(&t.conn.stats.tunIn, uint64(len(chunk)))
+	atomic.AddUint64(&t.stats.bytesIn, uint64(len(chunk)))
+	atomic.AddUint64(&t.stats.chunksIn, 1)
+
+	t.chunkLock.Lock()
+	defer t.chunkLock.Unlock()
+
+	// High priority frames may interleave with the chunks of a normal message
+	if size != 0 && size == len(chunk) {
+		if message, ok := unwrapPriority(chunk); ok {
+			t.itoaLock.Lock()
+			defer t.itoaLock.Unlock()
+
+			t.deliverMessage(message, len(chunk), nil)
+			return
+		}
+	}
 	// If a new message is arriving, dump anything stored before
 	if size != 0 {
-		if t.chunkBuf != nil {
-			t.Log.Warn("incomplete message discarded", "size", cap(t.chunkBuf), "arrived", len(t.chunkBuf))
+		t.abortStream()
+
+		// A large transfer timed out, new started, grant the partials allowance
+		t.discardPartial("superseded")
 
-			// A large transfer timed out, new started, grant the partials allowance
-			go t.conn.sendTunnelAllowance(t.id, len(t.chunkBuf))
+		// Reject oversized messages before buffering any of them
+		t.chunkSkip = 0
+		if err := t.conn.checkTunnelSize(size); err != nil {
+			t.Log.Warn("dropping rejected message", "reason", err)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+
+			t.chunkSkip = size - len(chunk)
+			t.grant(len(chunk))
+			return
+		}
+		// Queue multi-chunk messages piecewise instead of assembling, if enabled
+		if size > len(chunk) && t.streamable(chunk) {
+			t.streamChunk(size, chunk)
+			return
 		}
-		t.chunkBuf = make([]byte, 0, size)
+		// Reject messages too large to assemble
+		if limit := t.limits.AssembleLimit; limit > 0 && size > limit {
+			t.Log.Warn("dropping unassemblable message", "size", size, "limit", limit)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+
+			t.chunkSkip = size - len(chunk)
+			t.grant(len(chunk))
+			return
+		}
+		t.chunkBuf, t.chunkSize = tunnelBuffers.get(size)[:0], size
+	}
+	// Discard the continuations of rejected messages
+	if t.chunkSkip > 0 {
+		t.chunkSkip -= len(chunk)
+		t.grant(len(chunk))
+		return
+	}
+	// Queue the continuations of streamed messages piecewise too
+	if t.chunkLeft > 0 {
+		t.streamChunk(0, chunk)
+		return
 	}
 	// Append the new chunk and check completion
 	t.chunkBuf = append(t.chunkBuf, chunk...)
-	if len(t.chunkBuf) == cap(t.chunkBuf) {
+	if len(t.chunkBuf) == t.chunkSize {
 		t.itoaLock.Lock()
 		defer t.itoaLock.Unlock()
 
-		t.Log.Debug("queuing arrived message", "data", logLazyBlob(t.chunkBuf))
-		t.itoaBuf.Push(t.chunkBuf)
+		t.deliverMessage(t.chunkBuf, len(t.chunkBuf), t.chunkBuf)
 		t.chunkBuf = nil
+		return
+	}
+	t.armPartial()
+}
 
-		select {
-		case t.itoaSign <- struct{}{}:
-		default:
+// Queues a fully assembled message for the application, consuming any trace
+// header or control message instead. The size is the wire allowance to grant
+// back upon consumption, buf the pooled buffer backing the message (if any).
+// The inbound lock is assumed to be held.
+func (t *Tunnel) deliverMessage(message []byte, size int, buf []byte) {
+	// Consume any trace header or control message instead of delivering it
+	if headers, payload := unwrapTrace(message); headers != nil && len(payload) == 0 {
+		t.grant(size)
+		t.traceInbound(headers)
+		tunnelBuffers.put(buf)
+		return
+	}
+	if t.handleControl(message) {
+		t.grant(size)
+		tunnelBuffers.put(buf)
+		return
+	}
+	// Once secured locally, only accept data after the remote side switched too
+	if t.open != nil && !t.sealedIn {
+		t.Log.Error("dropping unencrypted message on secured tunnel", "size", size)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		t.grant(size)
+		tunnelBuffers.put(buf)
+		return
+	}
+	// Decompress the message if negotiated, tracking the original size. Encrypted
+	// messages are processed upon consumption, as the key might not be set yet.
+	msg := &inboundMessage{data: message, size: size, buf: buf}
+	if t.sealedIn {
+		msg.sealed, msg.decompress = true, t.decompress
+	} else {
+		var err error
+		if msg.data, err = decompressMessage(t.decompress, message); err != nil {
+			t.Log.Error("failed to decompress message", "reason", err)
+			t.grant(size)
+			tunnelBuffers.put(buf)
+			return
+		}
+		if msg.data, err = t.conn.decodePayload(TraceTunnel, msg.data); err != nil {
+			t.Log.Error("failed to decode message", "reason", err)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			t.grant(size)
+			tunnelBuffers.put(buf)
+			return
+		}
+		msg.meta, msg.data = unwrapMessageMeta(msg.data)
+
+		// Discard the message if it's oversized or malformed
+		if err := t.conn.validateInbound(TraceTunnel, t.conn.cluster, size, msg.data); err != nil {
+			t.Log.Warn("dropping rejected message", "reason", err)
+			atomic.AddUint64(&t.conn.stats.dropped, 1)
+			t.grant(size)
+			tunnelBuffers.put(buf)
+			return
 		}
 	}
+	t.Log.Debug("queuing arrived message", "data", logLazyBlob(msg.data))
+	if !t.itoaBuf.Push(msg) {
+		t.Log.Error("message exceeded bounded buffer", "queued", t.itoaBuf.Size(), "size", size)
+		atomic.AddUint64(&t.conn.stats.dropped, 1)
+		t.grant(size)
+		tunnelBuffers.put(buf)
+		return
+	}
+	t.itoaUsed += size
+	atomic.AddUint64(&t.stats.msgsIn, 1)
+
+	select {
+	case t.itoaSign <- struct{}{}:
+	default:
+	}
+}
+
+// Marks the end of the inbound message stream, discarding any partial message.
+func (t *Tunnel) handleCloseWrite() {
+	t.chunkLock.Lock()
+	t.abortStream()
+	t.chunkSkip = 0
+	t.discardPartial("write end closed")
+	t.chunkLock.Unlock()
+
+	t.itoaLock.Lock()
+	defer t.itoaLock.Unlock()
+
+	t.Log.Info("tunnel remote write side closed")
+	t.itoaEOF = true
+	select {
+	case t.itoaSign <- struct{}{}:
+	default:
+	}
 }
 
 // Handles the graceful remote closure of the tunnel.
 func (t *Tunnel) handleClose(reason string) {
 	if reason != "" {
 		t.Log.Warn("tunnel dropped", "reason", reason)
-		t.stat = fmt.Errorf("remote error: %s", reason)
+		t.stat = &RemoteError{Reason: reason}
 	} else {
 		t.Log.Info("tunnel closed gracefully")
 	}
+	t.itoaLock.Lock()
+	if t.traceEnd != nil {
+		t.traceEnd(t.stat)
+		t.traceEnd = nil
+	}
+	t.itoaLock.Unlock()
+
+	t.chunkLock.Lock()
+	if t.chunkTimer != nil {
+		t.chunkTimer.Stop()
+	}
+	t.chunkLock.Unlock()
+
+	t.hookDown()
+	t.auditDown()
 	close(t.term)
+
+	t.notifyClose()
 }
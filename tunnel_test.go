@@ -8,8 +8,13 @@ package iris
 
 import (
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"runtime"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -42,6 +47,45 @@ func (t *tunnelTestHandler) HandleTunnel(tun *Tunnel) {
 	}
 }
 
+// Service handler for the tunnel half-close tests.
+type tunnelHalfCloseTestHandler struct {
+	conn *Connection
+}
+
+func (t *tunnelHalfCloseTestHandler) Init(conn *Connection) error { t.conn = conn; return nil }
+func (t *tunnelHalfCloseTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (t *tunnelHalfCloseTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+func (t *tunnelHalfCloseTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	panic("not implemented")
+}
+
+func (t *tunnelHalfCloseTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	// Collect all the messages until the remote side finishes
+	msgs := [][]byte{}
+	for {
+		msg, err := tun.Recv(0)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			panic(fmt.Sprintf("tunnel receive failed: %v", err))
+		}
+		msgs = append(msgs, msg)
+	}
+	// Stream back the collected messages and signal completion
+	for _, msg := range msgs {
+		if err := tun.Send(msg, 0); err != nil {
+			panic(fmt.Sprintf("tunnel send failed: %v", err))
+		}
+	}
+	if err := tun.CloseWrite(); err != nil {
+		panic(fmt.Sprintf("tunnel write close failed: %v", err))
+	}
+	// Wait for the remote side to tear down the tunnel
+	<-tun.term
+}
+
 // Tests multiple concurrent client and service tunnels.
 func TestTunnel(t *testing.T) {
 	// Test specific configurations
@@ -170,13 +214,13 @@ func TestTunnelTimeout(t *testing.T) {
 	defer conn.Close()
 
 	// Open a new tunnel to a non existent server
-	if tun, err := conn.Tunnel(config.cluster, 100*time.Millisecond); err != ErrTimeout {
+	if tun, err := conn.Tunnel(config.cluster, 100*time.Millisecond); !errors.Is(err, ErrTimeout) {
 		t.Fatalf("mismatching tunneling result: have %v/%v, want %v/%v", tun, err, nil, ErrTimeout)
 	}
 }
 
-// Tests that large messages get delivered properly.
-func TestTunnelChunking(t *testing.T) {
+// Tests the context based tunnel construction and data exchange.
+func TestTunnelContext(t *testing.T) {
 	// Create the service handler
 	handler := new(tunnelTestHandler)
 
@@ -188,33 +232,37 @@ func TestTunnelChunking(t *testing.T) {
 	defer serv.Unregister()
 
 	// Construct the tunnel
-	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	tunnel, err := handler.conn.TunnelCtx(ctx, config.cluster)
 	if err != nil {
 		t.Fatalf("tunnel construction failed: %v.", err)
 	}
 	defer tunnel.Close()
 
-	// Create and transfer a huge message
-	blob := make([]byte, 16*1024*1024)
-	for i := 0; i < len(blob); i++ {
-		blob[i] = byte(i)
-	}
-	if err := tunnel.Send(blob, 10*time.Second); err != nil {
-		t.Fatalf("failed to send blob: %v.", err)
+	// Exchange a message with the echo service
+	data := []byte{0x00, 0x01, 0x00, 0x02}
+	if err := tunnel.SendCtx(ctx, data); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
 	}
-	back, err := tunnel.Recv(10 * time.Second)
+	back, err := tunnel.RecvCtx(ctx)
 	if err != nil {
-		t.Fatalf("failed to retrieve blob: %v.", err)
+		t.Fatalf("failed to retrieve data: %v.", err)
 	}
-	// Verify that they indeed match
-	if bytes.Compare(back, blob) != 0 {
-		t.Fatalf("data blob mismatch")
+	if bytes.Compare(back, data) != 0 {
+		t.Fatalf("data mismatch: have %v, want %v.", back, data)
+	}
+	// Verify that a cancelled context aborts a pending receive
+	ctx, cancel = context.WithCancel(context.Background())
+	time.AfterFunc(25*time.Millisecond, cancel)
+	if msg, err := tunnel.RecvCtx(ctx); err != context.Canceled {
+		t.Fatalf("cancellation mismatch: have %v/%v, want %v/%v.", msg, err, nil, context.Canceled)
 	}
 }
 
-// Tests that a tunnel remains operational even after overloads (partially
-// transferred huge messages timeouting).
-func TestTunnelOverload(t *testing.T) {
+// Tests receiving tunnel messages into caller and pool supplied buffers.
+func TestTunnelRecvInto(t *testing.T) {
 	// Create the service handler
 	handler := new(tunnelTestHandler)
 
@@ -232,98 +280,1253 @@ func TestTunnelOverload(t *testing.T) {
 	}
 	defer tunnel.Close()
 
-	// Overload the tunnel by partially transferring huge messages
-	blob := make([]byte, 64*1024*1024)
-	for i := 0; i < 10; i++ {
-		if err := tunnel.Send(blob, 10*time.Millisecond); err != ErrTimeout {
-			t.Fatalf("unexpected send result: have %v, want %v.", err, ErrTimeout)
-		}
+	// Exchange a message and verify that short buffers leave it queued
+	data := []byte{0x00, 0x01, 0x00, 0x02}
+	if err := tunnel.Send(data, time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
 	}
-	// Verify that the tunnel is still operational
-	data := []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04}
-	for i := 0; i < 10; i++ { // Iteration's important, the first will always cross (allowance ignore)
+	short := make([]byte, len(data)-1)
+	if n, err := tunnel.RecvInto(short, time.Second); err != io.ErrShortBuffer {
+		t.Fatalf("short receive mismatch: have %v/%v, want %v/%v.", n, err, 0, io.ErrShortBuffer)
+	}
+	buf := make([]byte, 16)
+	n, err := tunnel.RecvInto(buf, time.Second)
+	if err != nil {
+		t.Fatalf("failed to retrieve data: %v.", err)
+	}
+	if bytes.Compare(buf[:n], data) != 0 {
+		t.Fatalf("data mismatch: have %v, want %v.", buf[:n], data)
+	}
+	// Exchange a few messages via pooled payloads, releasing them in between
+	for i := 0; i < 3; i++ {
+		data := bytes.Repeat([]byte{byte(i)}, 100*(i+1))
 		if err := tunnel.Send(data, time.Second); err != nil {
-			t.Fatalf("failed to send data: %v.", err)
+			t.Fatalf("failed to send data #%d: %v.", i, err)
 		}
-		back, err := tunnel.Recv(time.Second)
+		payload, err := tunnel.RecvPooled(time.Second)
 		if err != nil {
-			t.Fatalf("failed to retrieve data: %v.", err)
+			t.Fatalf("failed to retrieve data #%d: %v.", i, err)
 		}
-		// Verify that they indeed match
-		if bytes.Compare(back, data) != 0 {
-			t.Fatalf("data mismatch: have %v, want %v.", back, data)
+		if bytes.Compare(payload.Data, data) != 0 {
+			t.Fatalf("data #%d mismatch: have %v, want %v.", i, payload.Data, data)
 		}
+		payload.Release()
 	}
 }
 
-// Benchmarks the latency of the tunnel data transfer (actually two way, so
-// halves it).
-func BenchmarkTunnelLatency(b *testing.B) {
+// Tests the non-blocking inspection and retrieval of buffered tunnel messages.
+func TestTunnelPeek(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		messages int
+	}{3}
+
 	// Create the service handler
 	handler := new(tunnelTestHandler)
 
 	// Register a new service to the relay
 	serv, err := Register(config.relay, config.cluster, handler, nil)
 	if err != nil {
-		b.Fatalf("registration failed: %v.", err)
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct the tunnel and verify that nothing is available yet
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if msg, _, err := tunnel.Peek(); err != ErrNoMessage {
+		t.Fatalf("empty peek mismatch: have %v/%v, want %v.", msg, err, ErrNoMessage)
+	}
+	if msg, err := tunnel.TryRecv(); err != ErrNoMessage {
+		t.Fatalf("empty receive mismatch: have %v/%v, want %v.", msg, err, ErrNoMessage)
+	}
+	// Exchange a batch of messages and wait for all of them to be buffered
+	for i := 0; i < conf.messages; i++ {
+		if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("failed to send message #%d: %v.", i, err)
+		}
+	}
+	for start := time.Now(); tunnel.Pending() < conf.messages; time.Sleep(time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("pending mismatch: have %d, want %d.", tunnel.Pending(), conf.messages)
+		}
+	}
+	// Peek at each message and retrieve it without blocking
+	for i := 0; i < conf.messages; i++ {
+		for j := 0; j < 2; j++ {
+			if msg, meta, err := tunnel.Peek(); err != nil || len(msg) != 1 || msg[0] != byte(i) || meta != nil {
+				t.Fatalf("peek #%d.%d mismatch: have %v/%v/%v, want %v.", i, j, msg, meta, err, []byte{byte(i)})
+			}
+		}
+		if have := tunnel.Pending(); have != conf.messages-i {
+			t.Fatalf("pending #%d mismatch: have %d, want %d.", i, have, conf.messages-i)
+		}
+		if msg, err := tunnel.TryRecv(); err != nil || len(msg) != 1 || msg[0] != byte(i) {
+			t.Fatalf("receive #%d mismatch: have %v/%v, want %v.", i, msg, err, []byte{byte(i)})
+		}
+	}
+	if msg, err := tunnel.TryRecv(); err != ErrNoMessage {
+		t.Fatalf("drained receive mismatch: have %v/%v, want %v.", msg, err, ErrNoMessage)
+	}
+	// Verify that closed tunnels report the closure
+	tunnel.Close()
+	if msg, err := tunnel.TryRecv(); err != ErrClosed {
+		t.Fatalf("closed receive mismatch: have %v/%v, want %v.", msg, err, ErrClosed)
+	}
+}
+
+// Tests that blocking and non-blocking receives may race for the same messages
+// without losing or duplicating any.
+func TestTunnelTryRecvConcurrent(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		messages int
+	}{10000}
+
+	// Register a new service to the relay and construct a tunnel to it
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Receive the echoes with blocking and polling consumers concurrently
+	var (
+		received int32
+		pend     sync.WaitGroup
+	)
+	for i := 0; i < 2; i++ {
+		pend.Add(2)
+		go func() {
+			defer pend.Done()
+			for {
+				if _, err := tunnel.Recv(0); err != nil {
+					return
+				}
+				atomic.AddInt32(&received, 1)
+			}
+		}()
+		go func() {
+			defer pend.Done()
+			for {
+				switch _, err := tunnel.TryRecv(); err {
+				case nil:
+					atomic.AddInt32(&received, 1)
+				case ErrNoMessage:
+					runtime.Gosched()
+				default:
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < conf.messages; i++ {
+		if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("failed to send message #%d: %v.", i, err)
+		}
+	}
+	for start := time.Now(); atomic.LoadInt32(&received) < int32(conf.messages); time.Sleep(time.Millisecond) {
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("received mismatch: have %d, want %d.", atomic.LoadInt32(&received), conf.messages)
+		}
+	}
+	tunnel.Close()
+	pend.Wait()
+
+	if have := atomic.LoadInt32(&received); have != int32(conf.messages) {
+		t.Fatalf("received mismatch: have %d, want %d.", have, conf.messages)
+	}
+}
+
+// Tests that large messages can be streamed chunk by chunk, even beyond the
+// buffer allowance, and that chunk limits may override the relay's.
+func TestTunnelRecvChunks(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		buffer int
+		chunk  int
+		size   int
+	}{64 * 1024, 32 * 1024, 1024 * 1024}
+
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct a streaming tunnel with a chunk limit above the relay's
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{
+		BufferSize:    conf.buffer,
+		ChunkLimit:    conf.chunk,
+		ChunkOverride: true,
+		StreamChunks:  true,
+	})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.chunkLimit != conf.chunk {
+		t.Fatalf("chunk limit mismatch: have %v, want %v.", tunnel.chunkLimit, conf.chunk)
+	}
+	// Exchange a message larger than the buffer and verify it's streamed back
+	data := make([]byte, conf.size)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if err := tunnel.Send(data, time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
+	}
+	if _, err := tunnel.Recv(time.Second); err != ErrChunked {
+		t.Fatalf("whole receive error mismatch: have %v, want %v.", err, ErrChunked)
+	}
+	var (
+		recvd  []byte
+		chunks int
+	)
+	n, err := tunnel.RecvChunks(time.Second, func(chunk []byte) error {
+		recvd = append(recvd, chunk...)
+		chunks++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to stream data: %v.", err)
+	}
+	if n != conf.size || chunks < 2 {
+		t.Fatalf("streamed size mismatch: have %v bytes in %v chunks, want %v in many.", n, chunks, conf.size)
+	}
+	if bytes.Compare(recvd, data) != 0 {
+		t.Fatalf("streamed data mismatch.")
+	}
+	// Verify that single chunk messages are still received whole
+	if err := tunnel.Send(data[:16], time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
+	}
+	if msg, err := tunnel.Recv(time.Second); err != nil || bytes.Compare(msg, data[:16]) != 0 {
+		t.Fatalf("whole message mismatch: have %v/%v, want %v/%v.", msg, err, data[:16], nil)
+	}
+}
+
+// Tests that closing the write end of a tunnel delivers an end-of-stream to the
+// remote side while still permitting local receives.
+func TestTunnelCloseWrite(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		messages int
+	}{10}
+
+	// Create the service handler
+	handler := new(tunnelHalfCloseTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
 	}
 	defer serv.Unregister()
 
 	// Construct the tunnel
 	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
 	if err != nil {
-		b.Fatalf("tunnel construction failed: %v.", err)
+		t.Fatalf("tunnel construction failed: %v.", err)
 	}
 	defer tunnel.Close()
 
-	// Reset the timer and measure the latency
-	b.ResetTimer()
-	for i := 0; i < b.N/2; i++ {
+	// Send a batch of messages and close the write end
+	for i := 0; i < conf.messages; i++ {
 		if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
-			b.Fatalf("tunnel send failed: %v.", err)
+			t.Fatalf("failed to send message #%d: %v.", i, err)
 		}
-		if _, err := tunnel.Recv(time.Second); err != nil {
-			b.Fatalf("tunnel receive failed: %v.", err)
+	}
+	if err := tunnel.CloseWrite(); err != nil {
+		t.Fatalf("failed to close write end: %v.", err)
+	}
+	if err := tunnel.Send([]byte{0x00}, time.Second); err != ErrClosed {
+		t.Fatalf("send after write close mismatch: have %v, want %v.", err, ErrClosed)
+	}
+	// Read back the entire stream and verify the end-of-stream
+	for i := 0; i < conf.messages; i++ {
+		msg, err := tunnel.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("failed to retrieve message #%d: %v.", i, err)
+		}
+		if len(msg) != 1 || msg[0] != byte(i) {
+			t.Fatalf("message #%d mismatch: have %v, want %v.", i, msg, []byte{byte(i)})
 		}
 	}
-	// Stop the timer (don't measure deferred cleanup)
-	b.StopTimer()
+	if msg, err := tunnel.Recv(time.Second); err != io.EOF {
+		t.Fatalf("end-of-stream mismatch: have %v/%v, want %v/%v.", msg, err, nil, io.EOF)
+	}
 }
 
-// Measures the throughput of the tunnel data transfer (actually two ways, so
-// halves it).
-func BenchmarkTunnelThroughput(b *testing.B) {
+// Tests that tunnel wide deadlines are enforced on sends and receives.
+func TestTunnelDeadline(t *testing.T) {
 	// Create the service handler
 	handler := new(tunnelTestHandler)
 
 	// Register a new service to the relay
 	serv, err := Register(config.relay, config.cluster, handler, nil)
 	if err != nil {
-		b.Fatalf("registration failed: %v.", err)
+		t.Fatalf("registration failed: %v.", err)
 	}
 	defer serv.Unregister()
 
 	// Construct the tunnel
 	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
 	if err != nil {
-		b.Fatalf("tunnel construction failed: %v.", err)
+		t.Fatalf("tunnel construction failed: %v.", err)
 	}
 	defer tunnel.Close()
 
-	// Reset the timer and measure the throughput
-	b.ResetTimer()
-	go func() {
-		for i := 0; i < b.N/2; i++ {
-			if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
-				b.Fatalf("tunnel send failed: %v.", err)
-			}
+	// Verify that a blocked receive is interrupted by the read deadline
+	tunnel.SetReadDeadline(time.Now().Add(25 * time.Millisecond))
+	if msg, err := tunnel.Recv(0); err != ErrTimeout {
+		t.Fatalf("deadline receive mismatch: have %v/%v, want %v/%v.", msg, err, nil, ErrTimeout)
+	}
+	// Verify that an expired write deadline blocks sending
+	tunnel.SetWriteDeadline(time.Now().Add(-time.Second))
+	if err := tunnel.Send([]byte{0x00}, 0); err != ErrTimeout {
+		t.Fatalf("deadline send mismatch: have %v, want %v.", err, ErrTimeout)
+	}
+	// Reset the deadlines and verify that the tunnel is still operational
+	tunnel.SetDeadline(time.Time{})
+
+	data := []byte{0x00, 0x01, 0x00, 0x02}
+	if err := tunnel.Send(data, time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
+	}
+	back, err := tunnel.Recv(time.Second)
+	if err != nil {
+		t.Fatalf("failed to retrieve data: %v.", err)
+	}
+	if bytes.Compare(back, data) != 0 {
+		t.Fatalf("data mismatch: have %v, want %v.", back, data)
+	}
+}
+
+// Tests that user configured tunnel limits are respected.
+func TestTunnelConfig(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		chunk  int
+		buffer int
+	}{4, 64}
+
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay and limit its inbound tunnels
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+	handler.conn.SetTunnelConfig(&TunnelConfig{BufferSize: conf.buffer})
+
+	// Construct a tunnel with a custom chunk limit
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.chunkLimit != conf.chunk {
+		t.Fatalf("chunk limit mismatch: have %v, want %v.", tunnel.chunkLimit, conf.chunk)
+	}
+	// Transfer messages larger than the chunk limit, saturating the buffer
+	for i := 0; i < 4; i++ {
+		data := make([]byte, conf.buffer)
+		for j := 0; j < len(data); j++ {
+			data[j] = byte(i + j)
 		}
-	}()
-	for i := 0; i < b.N/2; i++ {
-		if _, err := tunnel.Recv(time.Second); err != nil {
-			b.Fatalf("tunnel receive failed: %v.", err)
+		if err := tunnel.Send(data, time.Second); err != nil {
+			t.Fatalf("failed to send data: %v.", err)
+		}
+		back, err := tunnel.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("failed to retrieve data: %v.", err)
+		}
+		if bytes.Compare(back, data) != 0 {
+			t.Fatalf("data mismatch: have %v, want %v.", back, data)
 		}
 	}
-	// Stop the timer (don't measure deferred cleanup)
-	b.StopTimer()
+}
+
+// Service handler for the tunnel priority tests, handing out inbound tunnels.
+type tunnelPriorityTestHandler struct {
+	conn    *Connection
+	tunnels chan *Tunnel
+}
+
+func (t *tunnelPriorityTestHandler) Init(conn *Connection) error { t.conn = conn; return nil }
+func (t *tunnelPriorityTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (t *tunnelPriorityTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+func (t *tunnelPriorityTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	panic("not implemented")
+}
+func (t *tunnelPriorityTestHandler) HandleTunnel(tun *Tunnel) { t.tunnels <- tun }
+
+// Tests that high priority messages jump ahead of the chunks of a normal one.
+func TestTunnelPriority(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		chunk int
+		size  int
+	}{256, 4096}
+
+	// Register a new service to the relay with room for a single large message
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+	handler.conn.SetTunnelConfig(&TunnelConfig{BufferSize: conf.size + conf.chunk})
+
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+	remote := <-handler.tunnels
+
+	// Fill the remote buffer, and start a second large message blocking midway
+	first, second := bytes.Repeat([]byte{0x01}, conf.size), bytes.Repeat([]byte{0x02}, conf.size)
+	if err := tunnel.Send(first, time.Second); err != nil {
+		t.Fatalf("failed to send first message: %v.", err)
+	}
+	errc := make(chan error, 2)
+	go func() { errc <- tunnel.Send(second, time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// Queue up a high priority message behind the blocked chunks
+	urgent := []byte("urgent")
+	go func() { errc <- tunnel.SendPriority(urgent, PriorityHigh, time.Second) }()
+	time.Sleep(50 * time.Millisecond)
+
+	// Verify that the urgent message overtook the remainder of the second one
+	for i, want := range [][]byte{first, urgent, second} {
+		have, err := remote.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("message %d: failed to retrieve data: %v.", i, err)
+		}
+		if !bytes.Equal(have, want) {
+			t.Fatalf("message %d: data mismatch: have %d bytes, want %d.", i, len(have), len(want))
+		}
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("failed to send data: %v.", err)
+		}
+	}
+}
+
+// Tests that negotiated compression is transparent and reduces the traffic.
+func TestTunnelCompression(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		size     int
+		messages int
+	}{64 * 1024, 4}
+
+	// Register a new service to the relay and connect a separate client
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Construct a tunnel offering compression
+	tunnel, err := conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{Compression: []string{"unknown", CompressGzip}})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if tunnel.compress == nil {
+		t.Fatalf("compression not negotiated.")
+	}
+	// Exchange highly compressible and tiny messages
+	for i := 0; i < conf.messages; i++ {
+		for _, data := range [][]byte{bytes.Repeat([]byte{byte(i)}, conf.size), {byte(i)}} {
+			if err := tunnel.Send(data, time.Second); err != nil {
+				t.Fatalf("failed to send data: %v.", err)
+			}
+			back, err := tunnel.Recv(time.Second)
+			if err != nil {
+				t.Fatalf("failed to retrieve data: %v.", err)
+			}
+			if !bytes.Equal(back, data) {
+				t.Fatalf("data mismatch: have %d bytes, want %d.", len(back), len(data))
+			}
+		}
+	}
+	// Verify that the traffic was actually compressed
+	if sent := conn.Metrics().TunnelBytesOut; sent > uint64(conf.size) {
+		t.Fatalf("traffic not compressed: sent %v bytes, raw %v.", sent, conf.messages*conf.size)
+	}
+}
+
+// Tests that empty inbound messages pass decompression without a flag.
+func TestTunnelCompressionEmpty(t *testing.T) {
+	for i, message := range [][]byte{nil, {}} {
+		data, err := decompressMessage(gzipCompressor{}, message)
+		if err != nil || len(data) != 0 {
+			t.Fatalf("test %d: empty message mismatch: have %v/%v, want empty.", i, data, err)
+		}
+	}
+}
+
+// Service handler for the tunnel encryption tests, securing inbound tunnels
+// explicitly and echoing back messages, or a failure notice if undecryptable.
+type tunnelSecureTestHandler struct {
+	conn *Connection
+	key  []byte
+}
+
+func (t *tunnelSecureTestHandler) Init(conn *Connection) error { t.conn = conn; return nil }
+func (t *tunnelSecureTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (t *tunnelSecureTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+func (t *tunnelSecureTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	panic("not implemented")
+}
+
+func (t *tunnelSecureTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	if err := tun.Secure(t.key); err != nil {
+		panic(fmt.Sprintf("tunnel securing failed: %v", err))
+	}
+	for {
+		msg, err := tun.Recv(0)
+		switch {
+		case err == ErrClosed:
+			return
+		case err != nil:
+			msg = []byte("undecryptable")
+		}
+		if err := tun.Send(msg, 0); err != nil {
+			panic(fmt.Sprintf("tunnel send failed: %v", err))
+		}
+	}
+}
+
+// Tests that tunnels can be encrypted end-to-end, also along with compression.
+func TestTunnelEncryption(t *testing.T) {
+	// Register a new service to the relay and connect a separate client
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	handler := &tunnelSecureTestHandler{key: key}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Exchange messages over tunnels secured by a static key and a key exchange
+	configs := []*TunnelConfig{
+		{Key: key},
+		{Compression: []string{CompressGzip}, KeyExchange: func(tun *Tunnel) ([]byte, error) { return key, nil }},
+	}
+	for i, tunConf := range configs {
+		tunnel, err := conn.TunnelWithConfig(config.cluster, time.Second, tunConf)
+		if err != nil {
+			t.Fatalf("test %d: tunnel construction failed: %v.", i, err)
+		}
+		for _, data := range [][]byte{bytes.Repeat([]byte{byte(i)}, 4096), {byte(i)}} {
+			if err := tunnel.Send(data, time.Second); err != nil {
+				t.Fatalf("test %d: failed to send data: %v.", i, err)
+			}
+			back, err := tunnel.Recv(time.Second)
+			if err != nil {
+				t.Fatalf("test %d: failed to retrieve data: %v.", i, err)
+			}
+			if !bytes.Equal(back, data) {
+				t.Fatalf("test %d: data mismatch: have %d bytes, want %d.", i, len(back), len(data))
+			}
+		}
+		tunnel.Close()
+	}
+	// Verify that mismatching keys prevent both ends from reading the messages
+	tunnel, err := conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{Key: bytes.Repeat([]byte{0x24}, 32)})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	if err := tunnel.Send([]byte("secret"), time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
+	}
+	if back, err := tunnel.Recv(time.Second); err == nil {
+		t.Fatalf("mismatching key decrypted data: %s.", back)
+	}
+	// Verify that invalid keys are rejected
+	if _, err := conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{Key: []byte{0x00}}); err == nil {
+		t.Fatalf("invalid key accepted.")
+	}
+}
+
+// Service handler exposing its inbound tunnels to the test for manual driving.
+type tunnelInjectTestHandler struct {
+	tunnels chan *Tunnel
+	done    chan struct{}
+}
+
+func (t *tunnelInjectTestHandler) Init(conn *Connection) error          { return nil }
+func (t *tunnelInjectTestHandler) HandleBroadcast(msg []byte)           { panic("not implemented") }
+func (t *tunnelInjectTestHandler) HandleDrop(reason error)              { panic("not implemented") }
+func (t *tunnelInjectTestHandler) HandleRequest([]byte) ([]byte, error) { panic("not implemented") }
+
+func (t *tunnelInjectTestHandler) HandleTunnel(tun *Tunnel) {
+	defer tun.Close()
+
+	t.tunnels <- tun
+	<-t.done
+}
+
+// Tests that a secured tunnel drops plaintext data arriving before the remote
+// side switched to encryption too.
+func TestTunnelEncryptionInjection(t *testing.T) {
+	key := bytes.Repeat([]byte{0x42}, 32)
+
+	// Register a service exposing its tunnels and connect a separate client
+	handler := &tunnelInjectTestHandler{tunnels: make(chan *Tunnel, 1), done: make(chan struct{})}
+	defer close(handler.done)
+
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	tunnel, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	remote := <-handler.tunnels
+
+	// Secure the local end only and inject plaintext from the remote one
+	if err := tunnel.Secure(key); err != nil {
+		t.Fatalf("failed to secure tunnel: %v.", err)
+	}
+	if err := remote.Send([]byte("injected"), time.Second); err != nil {
+		t.Fatalf("failed to inject data: %v.", err)
+	}
+	if msg, err := tunnel.Recv(250 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("plaintext receive mismatch: have %q/%v, want timeout.", msg, err)
+	}
+	if dropped := conn.Metrics().MessagesDropped; dropped != 1 {
+		t.Fatalf("dropped message count mismatch: have %d, want %d.", dropped, 1)
+	}
+	// Secure the remote end too and verify that data flows again
+	if err := remote.Secure(key); err != nil {
+		t.Fatalf("failed to secure remote tunnel: %v.", err)
+	}
+	if err := remote.Send([]byte("sealed"), time.Second); err != nil {
+		t.Fatalf("failed to send data: %v.", err)
+	}
+	if msg, err := tunnel.Recv(time.Second); err != nil || string(msg) != "sealed" {
+		t.Fatalf("sealed receive mismatch: have %q/%v, want %q.", msg, err, "sealed")
+	}
+}
+
+// Tests that the tunnel statistics track the throughput and queueing.
+func TestTunnelStats(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		size     int
+		messages int
+	}{2000, 3}
+
+	// Register a new echo service to the relay
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Send a few messages and wait for the echoes to queue up
+	for i := 0; i < conf.messages; i++ {
+		if err := tunnel.Send(make([]byte, conf.size), time.Second); err != nil {
+			t.Fatalf("failed to send data: %v.", err)
+		}
+	}
+	time.Sleep(100 * time.Millisecond)
+
+	chunks := uint64(conf.messages * ((conf.size + tunnel.chunkLimit - 1) / tunnel.chunkLimit))
+	stats := tunnel.Stats()
+	if stats.MessagesSent != uint64(conf.messages) || stats.ChunksSent != chunks || stats.BytesSent != uint64(conf.messages*conf.size) {
+		t.Fatalf("send stats mismatch: have %d msgs/%d chunks/%d bytes, want %d/%d/%d.", stats.MessagesSent, stats.ChunksSent, stats.BytesSent, conf.messages, chunks, conf.messages*conf.size)
+	}
+	if stats.QueuedMsgs != conf.messages || stats.QueuedBytes != conf.messages*conf.size {
+		t.Fatalf("queue stats mismatch: have %d msgs/%d bytes, want %d/%d.", stats.QueuedMsgs, stats.QueuedBytes, conf.messages, conf.messages*conf.size)
+	}
+	// Consume the echoes and verify the receive side
+	for i := 0; i < conf.messages; i++ {
+		if _, err := tunnel.Recv(time.Second); err != nil {
+			t.Fatalf("failed to retrieve data: %v.", err)
+		}
+	}
+	stats = tunnel.Stats()
+	if stats.MessagesRecv != uint64(conf.messages) || stats.ChunksRecv != chunks || stats.BytesRecv != uint64(conf.messages*conf.size) {
+		t.Fatalf("recv stats mismatch: have %d msgs/%d chunks/%d bytes, want %d/%d/%d.", stats.MessagesRecv, stats.ChunksRecv, stats.BytesRecv, conf.messages, chunks, conf.messages*conf.size)
+	}
+	if stats.QueuedMsgs != 0 || stats.QueuedBytes != 0 {
+		t.Fatalf("queue not drained: have %d msgs/%d bytes.", stats.QueuedMsgs, stats.QueuedBytes)
+	}
+}
+
+// Tests that large messages get delivered properly.
+func TestTunnelChunking(t *testing.T) {
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct the tunnel
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Create and transfer a huge message
+	blob := make([]byte, 16*1024*1024)
+	for i := 0; i < len(blob); i++ {
+		blob[i] = byte(i)
+	}
+	if err := tunnel.Send(blob, 10*time.Second); err != nil {
+		t.Fatalf("failed to send blob: %v.", err)
+	}
+	back, err := tunnel.Recv(10 * time.Second)
+	if err != nil {
+		t.Fatalf("failed to retrieve blob: %v.", err)
+	}
+	// Verify that they indeed match
+	if bytes.Compare(back, blob) != 0 {
+		t.Fatalf("data blob mismatch")
+	}
+}
+
+// Tests that tunnel timeouts report their refined causes, still matching the
+// generic timeout via errors.Is.
+func TestTunnelTimeoutErrors(t *testing.T) {
+	// Connect to the local relay without any service registered
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	_, err = conn.Tunnel(config.cluster, 100*time.Millisecond)
+	if err != ErrTunnelBuildTimeout {
+		t.Fatalf("build failure mismatch: have %v, want %v.", err, ErrTunnelBuildTimeout)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("build failure doesn't match %v.", ErrTimeout)
+	}
+	// Register a service with a tiny tunnel buffer and never consume from it
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+	handler.conn.SetTunnelConfig(&TunnelConfig{BufferSize: 1024})
+
+	tunnel, err := conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+	<-handler.tunnels
+
+	err = tunnel.Send(make([]byte, 4096), 100*time.Millisecond)
+	if err != ErrAllowanceExhausted {
+		t.Fatalf("send failure mismatch: have %v, want %v.", err, ErrAllowanceExhausted)
+	}
+	if !errors.Is(err, ErrTimeout) {
+		t.Fatalf("send failure doesn't match %v.", ErrTimeout)
+	}
+}
+
+// Tests that a pipelined multi-chunk send running out of allowance midway still
+// transmits all the chunks granted allowance before failing.
+func TestTunnelPipelinedSend(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		chunk  int
+		buffer int
+		size   int
+	}{256, 1024, 4096}
+
+	// Register a new service to the relay with room for a few chunks only
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+	handler.conn.SetTunnelConfig(&TunnelConfig{BufferSize: conf.buffer})
+
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+	<-handler.tunnels
+
+	// Send a message larger than the remote buffer without consuming it
+	if err := tunnel.Send(make([]byte, conf.size), 100*time.Millisecond); !errors.Is(err, ErrAllowanceExhausted) {
+		t.Fatalf("send error mismatch: have %v, want %v.", err, ErrAllowanceExhausted)
+	}
+	if have, want := tunnel.Stats().ChunksSent, uint64(conf.buffer/conf.chunk); have != want {
+		t.Fatalf("transmitted chunk count mismatch: have %d, want %d.", have, want)
+	}
+}
+
+// Tests that tunnels are kept open while exchanging messages, but get closed
+// after staying idle for the configured timeout.
+func TestTunnelIdleTimeout(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		timeout time.Duration
+		rounds  int
+	}{100 * time.Millisecond, 5}
+
+	// Register a new echo service to the relay
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{IdleTimeout: conf.timeout})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	closed := make(chan error, 1)
+	tunnel.OnClose(func(reason error) { closed <- reason })
+
+	// Exchange messages for longer than the timeout, but with shorter pauses
+	for i := 0; i < conf.rounds; i++ {
+		time.Sleep(conf.timeout / 2)
+		if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
+			t.Fatalf("round %d: failed to send data: %v.", i, err)
+		}
+		if _, err := tunnel.Recv(time.Second); err != nil {
+			t.Fatalf("round %d: failed to retrieve data: %v.", i, err)
+		}
+	}
+	// Stay idle and verify the tunnel gets closed
+	if _, err := tunnel.Recv(10 * conf.timeout); err != ErrIdleClosed {
+		t.Fatalf("idle receive error mismatch: have %v, want %v.", err, ErrIdleClosed)
+	}
+	select {
+	case reason := <-closed:
+		if reason != ErrIdleClosed {
+			t.Fatalf("close reason mismatch: have %v, want %v.", reason, ErrIdleClosed)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("close callback not invoked.")
+	}
+}
+
+// Tests that concurrently sent multi-chunk messages arrive whole and ordered per
+// sender.
+func TestTunnelConcurrentSend(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		senders  int
+		messages int
+		chunk    int
+		size     int
+	}{8, 32, 16, 100}
+
+	// Register a new service to the relay
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct a tunnel splitting every message into many chunks
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Send from many goroutines concurrently, each message filled with its origin
+	errc := make(chan error, conf.senders)
+	for i := 0; i < conf.senders; i++ {
+		go func(sender int) {
+			for j := 0; j < conf.messages; j++ {
+				if err := tunnel.Send(bytes.Repeat([]byte{byte(sender), byte(j)}, conf.size/2), time.Second); err != nil {
+					errc <- err
+					return
+				}
+			}
+			errc <- nil
+		}(i)
+	}
+	// Verify that the echoed messages are intact and in order per sender
+	next := make([]int, conf.senders)
+	for i := 0; i < conf.senders*conf.messages; i++ {
+		msg, err := tunnel.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("failed to retrieve message #%d: %v.", i, err)
+		}
+		sender, seq := int(msg[0]), int(msg[1])
+		if want := bytes.Repeat([]byte{msg[0], msg[1]}, conf.size/2); !bytes.Equal(msg, want) {
+			t.Fatalf("message #%d corrupted: %v.", i, msg)
+		}
+		if seq != next[sender] {
+			t.Fatalf("sender #%d order mismatch: have %d, want %d.", sender, seq, next[sender])
+		}
+		next[sender]++
+	}
+	for i := 0; i < conf.senders; i++ {
+		if err := <-errc; err != nil {
+			t.Fatalf("concurrent send failed: %v.", err)
+		}
+	}
+}
+
+// Tests that a tunnel remains operational even after overloads (partially
+// transferred huge messages timeouting).
+func TestTunnelOverload(t *testing.T) {
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct the tunnel
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Overload the tunnel by partially transferring huge messages
+	blob := make([]byte, 64*1024*1024)
+	for i := 0; i < 10; i++ {
+		if err := tunnel.Send(blob, 10*time.Millisecond); !errors.Is(err, ErrTimeout) {
+			t.Fatalf("unexpected send result: have %v, want %v.", err, ErrTimeout)
+		}
+	}
+	// Verify that the tunnel is still operational
+	data := []byte{0x00, 0x01, 0x00, 0x02, 0x00, 0x03, 0x00, 0x04}
+	for i := 0; i < 10; i++ { // Iteration's important, the first will always cross (allowance ignore)
+		if err := tunnel.Send(data, time.Second); err != nil {
+			t.Fatalf("failed to send data: %v.", err)
+		}
+		back, err := tunnel.Recv(time.Second)
+		if err != nil {
+			t.Fatalf("failed to retrieve data: %v.", err)
+		}
+		// Verify that they indeed match
+		if bytes.Compare(back, data) != 0 {
+			t.Fatalf("data mismatch: have %v, want %v.", back, data)
+		}
+	}
+}
+
+// Benchmarks the latency of the tunnel data transfer (actually two way, so
+// halves it).
+func BenchmarkTunnelLatency(b *testing.B) {
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		b.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct the tunnel
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		b.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Reset the timer and measure the latency
+	b.ResetTimer()
+	for i := 0; i < b.N/2; i++ {
+		if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
+			b.Fatalf("tunnel send failed: %v.", err)
+		}
+		if _, err := tunnel.Recv(time.Second); err != nil {
+			b.Fatalf("tunnel receive failed: %v.", err)
+		}
+	}
+	// Stop the timer (don't measure deferred cleanup)
+	b.StopTimer()
+}
+
+// Measures the throughput of the tunnel data transfer (actually two ways, so
+// halves it).
+func BenchmarkTunnelThroughput(b *testing.B) {
+	// Create the service handler
+	handler := new(tunnelTestHandler)
+
+	// Register a new service to the relay
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		b.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	// Construct the tunnel
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		b.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Reset the timer and measure the throughput
+	b.ResetTimer()
+	go func() {
+		for i := 0; i < b.N/2; i++ {
+			if err := tunnel.Send([]byte{byte(i)}, time.Second); err != nil {
+				b.Fatalf("tunnel send failed: %v.", err)
+			}
+		}
+	}()
+	for i := 0; i < b.N/2; i++ {
+		if _, err := tunnel.Recv(time.Second); err != nil {
+			b.Fatalf("tunnel receive failed: %v.", err)
+		}
+	}
+	// Stop the timer (don't measure deferred cleanup)
+	b.StopTimer()
+}
+
+// Benchmarks the throughput of large, multi-chunk messages, exercising the send
+// pipelining.
+func BenchmarkTunnelLargeThroughput(b *testing.B) {
+	// Register a new echo service to the relay
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		b.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tunnel, err := handler.conn.Tunnel(config.cluster, time.Second)
+	if err != nil {
+		b.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	// Reset the timer and measure the throughput
+	blob := make([]byte, 1024*1024)
+	b.SetBytes(int64(len(blob)))
+	b.ResetTimer()
+
+	errc := make(chan error, 1)
+	go func() {
+		for i := 0; i < b.N; i++ {
+			if err := tunnel.Send(blob, 10*time.Second); err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+	for i := 0; i < b.N; i++ {
+		if _, err := tunnel.Recv(10 * time.Second); err != nil {
+			b.Fatalf("tunnel receive failed: %v.", err)
+		}
+	}
+	if err := <-errc; err != nil {
+		b.Fatalf("tunnel send failed: %v.", err)
+	}
+	// Stop the timer (don't measure deferred cleanup)
+	b.StopTimer()
+}
+
+// Tests that partially assembled messages are discarded if stalled or too large,
+// without disrupting the subsequent ones.
+func TestTunnelAssembleLimits(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		chunk   int
+		limit   int
+		timeout time.Duration
+	}{4, 16, 100 * time.Millisecond}
+
+	// Register a new service to the relay bounding its message assembly
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+	handler.conn.SetTunnelConfig(&TunnelConfig{AssembleLimit: conf.limit, AssembleTimeout: conf.timeout})
+
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+	remote := <-handler.tunnels
+
+	// Start a message, stall it beyond the timeout and finish it afterwards
+	if err := handler.conn.sendTunnelTransfer(tunnel.id, conf.limit, []byte("head")); err != nil {
+		t.Fatalf("failed to send head chunk: %v.", err)
+	}
+	time.Sleep(3 * conf.timeout)
+
+	remote.chunkLock.Lock()
+	stalled := remote.chunkBuf != nil
+	remote.chunkLock.Unlock()
+	if stalled {
+		t.Fatalf("stalled message not discarded.")
+	}
+	for i := 0; i < 3; i++ {
+		if err := handler.conn.sendTunnelTransfer(tunnel.id, 0, []byte("tail")); err != nil {
+			t.Fatalf("failed to send continuation chunk: %v.", err)
+		}
+	}
+	// Send an oversized message, followed by a valid one
+	if err := tunnel.Send(make([]byte, 2*conf.limit), time.Second); err != nil {
+		t.Fatalf("failed to send oversized message: %v.", err)
+	}
+	valid := []byte("assembled")
+	if err := tunnel.Send(valid, time.Second); err != nil {
+		t.Fatalf("failed to send valid message: %v.", err)
+	}
+	// Verify that only the valid message arrived
+	if have, err := remote.Recv(time.Second); err != nil {
+		t.Fatalf("failed to retrieve data: %v.", err)
+	} else if !bytes.Equal(have, valid) {
+		t.Fatalf("data mismatch: have %q, want %q.", have, valid)
+	}
+	if dropped := handler.conn.Metrics().MessagesDropped; dropped != 2 {
+		t.Fatalf("dropped message count mismatch: have %d, want %d.", dropped, 2)
+	}
+}
+
+// Tests that message metadata is delivered alongside the payload, even if the
+// message is chunked, and stripped off for plain receives.
+func TestTunnelMeta(t *testing.T) {
+	// Register a new service to the relay and open a tunnel with tiny chunks
+	handler := &tunnelPriorityTestHandler{
+		tunnels: make(chan *Tunnel, 1),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: 64})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+	remote := <-handler.tunnels
+
+	// Send a few messages with and without metadata
+	meta := &MessageMeta{ContentType: "application/json", Headers: map[string]string{"route": "orders"}}
+	payload := bytes.Repeat([]byte("{}"), 100)
+
+	if err := tunnel.SendMeta(payload, meta, time.Second); err != nil {
+		t.Fatalf("failed to send message with metadata: %v.", err)
+	}
+	if err := tunnel.Send(payload, time.Second); err != nil {
+		t.Fatalf("failed to send plain message: %v.", err)
+	}
+	if err := tunnel.SendMeta(payload, meta, time.Second); err != nil {
+		t.Fatalf("failed to send message with metadata: %v.", err)
+	}
+	huge := &MessageMeta{ContentType: string(make([]byte, maxMessageMeta))}
+	if err := tunnel.SendMeta(payload, huge, time.Second); err == nil {
+		t.Fatalf("oversized metadata accepted.")
+	}
+	// Verify the metadata is surfaced or stripped as requested
+	data, have, err := remote.RecvMeta(time.Second)
+	if err != nil {
+		t.Fatalf("failed to retrieve message with metadata: %v.", err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Fatalf("payload mismatch: have %d bytes, want %d.", len(data), len(payload))
+	}
+	if have == nil || have.ContentType != meta.ContentType || have.Headers["route"] != "orders" {
+		t.Fatalf("metadata mismatch: have %+v, want %+v.", have, meta)
+	}
+	if data, have, err = remote.RecvMeta(time.Second); err != nil || have != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("plain message mismatch: have %d bytes, meta %+v, error %v.", len(data), have, err)
+	}
+	if data, err = remote.Recv(time.Second); err != nil || !bytes.Equal(data, payload) {
+		t.Fatalf("stripped message mismatch: have %d bytes, error %v.", len(data), err)
+	}
 }
@@ -0,0 +1,281 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the tunnel pool, keeping warm tunnels to a remote cluster to hand
+// out per logical exchange, amortizing the tunnel construction round trips.
+
+package iris
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// User configuration of a tunnel pool.
+type TunnelPoolConfig struct {
+	MinIdle      int           // Warm tunnels to maintain (checked out ones included)
+	MaxIdle      int           // Idle tunnels retained upon return, excess ones are closed
+	IdleTimeout  time.Duration // Time after which unused tunnels above MinIdle are closed
+	BuildTimeout time.Duration // Timeout of the tunnel constructions
+	Tunnel       *TunnelConfig // Limits of the pooled tunnels, nil for the connection wide ones
+}
+
+// Default configuration of a tunnel pool.
+var defaultTunnelPoolConfig = TunnelPoolConfig{
+	MinIdle:      1,
+	MaxIdle:      8,
+	IdleTimeout:  30 * time.Second,
+	BuildTimeout: 5 * time.Second,
+}
+
+// Pool of tunnels to a remote cluster, checked out for a logical exchange and
+// returned afterwards for reuse, sparing the construction round trip of a fresh
+// tunnel per exchange.
+//
+// Since a pooled tunnel carries many exchanges, the remote handler needs to
+// serve them in a loop instead of closing the tunnel after the first one, and
+// every exchange needs to consume all its messages before the tunnel is put
+// back. Tunnels that failed midway should be closed, but still put back so the
+// pool can replace them.
+type TunnelPool struct {
+	conn    *Connection       // Connection to build the tunnels through
+	cluster string            // Remote cluster the tunnels lead to
+	config  *TunnelPoolConfig // Configuration of the pool
+
+	idle     []*idleTunnel // Warm tunnels ready for checkout (most recent last)
+	building int           // Number of warm-up constructions in progress
+	out      int           // Number of tunnels currently checked out
+	closed   bool          // Flag whether the pool was torn down
+	lock     sync.Mutex    // Mutex to protect the idle tunnels and flags
+
+	quit chan struct{} // Channel to stop the idle reaper
+
+	Log Logger // Logger with connection and cluster injected
+}
+
+// Tunnel parked in a pool along with the time it was returned.
+type idleTunnel struct {
+	tun   *Tunnel
+	since time.Time
+}
+
+// Creates a pool of tunnels to the given remote cluster, warming up MinIdle of
+// them in the background. Any unset fields (i.e. value of zero) of the config
+// will default to the preset ones.
+func (c *Connection) NewTunnelPool(cluster string, config *TunnelPoolConfig) (*TunnelPool, error) {
+	if len(cluster) == 0 {
+		return nil, invalidArgument("empty cluster identifier")
+	}
+	config = finalizeTunnelPoolConfig(config)
+	if config.MinIdle > config.MaxIdle {
+		return nil, invalidArgument("min idle tunnels %d above max %d", config.MinIdle, config.MaxIdle)
+	}
+	pool := &TunnelPool{
+		conn:    c,
+		cluster: cluster,
+		config:  config,
+		quit:    make(chan struct{}),
+		Log:     c.Log.New("tunnel_pool", cluster),
+	}
+	pool.Log.Info("tunnel pool created", "min_idle", config.MinIdle, "max_idle", config.MaxIdle)
+
+	pool.lock.Lock()
+	pool.refill()
+	pool.lock.Unlock()
+
+	go pool.reaper()
+	return pool, nil
+}
+
+// Merges the user requested configuration with the defaults.
+func finalizeTunnelPoolConfig(user *TunnelPoolConfig) *TunnelPoolConfig {
+	// If the user didn't specify anything, load the full default set
+	if user == nil {
+		return &defaultTunnelPoolConfig
+	}
+	// Check each field and merge only non-specified ones
+	config := new(TunnelPoolConfig)
+	*config = *user
+
+	if user.MinIdle < 0 {
+		config.MinIdle = 0
+	}
+	if user.MaxIdle <= 0 {
+		config.MaxIdle = defaultTunnelPoolConfig.MaxIdle
+	}
+	if user.IdleTimeout <= 0 {
+		config.IdleTimeout = defaultTunnelPoolConfig.IdleTimeout
+	}
+	if user.BuildTimeout <= 0 {
+		config.BuildTimeout = defaultTunnelPoolConfig.BuildTimeout
+	}
+	if user.Tunnel != nil {
+		config.Tunnel = finalizeTunnelConfig(user.Tunnel)
+	}
+	return config
+}
+
+// Checks out a tunnel for an exchange, reusing a warm one if available or else
+// building a fresh one, unless the context is cancelled meanwhile. The tunnel
+// should be returned via Put after the exchange, or closed if it failed.
+func (p *TunnelPool) Get(ctx context.Context) (*Tunnel, error) {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return nil, ErrClosed
+	}
+	// Hand out the most recently returned live tunnel
+	for len(p.idle) > 0 {
+		entry := p.idle[len(p.idle)-1]
+		p.idle = p.idle[:len(p.idle)-1]
+
+		if entry.tun.live() {
+			p.out++
+			p.lock.Unlock()
+			return entry.tun, nil
+		}
+	}
+	p.refill()
+	p.lock.Unlock()
+
+	// No warm tunnel available, build a fresh one
+	p.Log.Debug("no warm tunnel available, building new")
+	tun, err := p.build(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.lock.Lock()
+	p.out++
+	p.lock.Unlock()
+
+	return tun, nil
+}
+
+// Returns a checked out tunnel to the pool for reuse. Closed tunnels are replaced
+// by fresh ones, whereas tunnels exceeding the idle capacity (or returned to a
+// closed pool) are closed.
+func (p *TunnelPool) Put(tun *Tunnel) {
+	p.lock.Lock()
+	if p.out > 0 {
+		p.out--
+	}
+	p.park(tun)
+}
+
+// Parks a live tunnel among the idle ones, or disposes of it. The pool lock is
+// assumed to be held, and is released before returning.
+func (p *TunnelPool) park(tun *Tunnel) {
+	if !tun.live() {
+		p.refill()
+		p.lock.Unlock()
+		return
+	}
+	if p.closed || len(p.idle) >= p.config.MaxIdle {
+		p.lock.Unlock()
+		tun.Close()
+		return
+	}
+	p.idle = append(p.idle, &idleTunnel{tun: tun, since: time.Now()})
+	p.lock.Unlock()
+}
+
+// Returns the number of warm tunnels ready for checkout.
+func (p *TunnelPool) Idle() int {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	return len(p.idle)
+}
+
+// Tears down the pool, closing all the idle tunnels. Checked out tunnels are not
+// affected, but are closed upon return.
+func (p *TunnelPool) Close() error {
+	p.lock.Lock()
+	if p.closed {
+		p.lock.Unlock()
+		return ErrClosed
+	}
+	p.closed = true
+	idle := p.idle
+	p.idle = nil
+	p.lock.Unlock()
+
+	close(p.quit)
+	p.Log.Info("closing tunnel pool", "idle", len(idle))
+
+	var failure error
+	for _, entry := range idle {
+		if err := entry.tun.Close(); err != nil && failure == nil {
+			failure = err
+		}
+	}
+	return failure
+}
+
+// Builds a fresh tunnel to the pool's cluster.
+func (p *TunnelPool) build(ctx context.Context) (*Tunnel, error) {
+	return p.conn.initTunnel(ctx, p.cluster, p.config.BuildTimeout, p.config.Tunnel)
+}
+
+// Starts building warm tunnels in the background if fewer than MinIdle are idle,
+// checked out or under construction. The pool lock is assumed to be held.
+func (p *TunnelPool) refill() {
+	for ; !p.closed && len(p.idle)+p.out+p.building < p.config.MinIdle; p.building++ {
+		go func() {
+			tun, err := p.build(context.Background())
+
+			p.lock.Lock()
+			p.building--
+			if err != nil {
+				p.lock.Unlock()
+				p.Log.Warn("failed to warm up tunnel", "reason", err)
+				return
+			}
+			p.park(tun)
+		}()
+	}
+}
+
+// Periodically closes the tunnels idling for longer than the timeout, retaining
+// at least MinIdle of them.
+func (p *TunnelPool) reaper() {
+	ticker := time.NewTicker(p.config.IdleTimeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.quit:
+			return
+		case <-ticker.C:
+		}
+		// Collect the stale tunnels (the oldest are at the front)
+		var stale []*Tunnel
+
+		p.lock.Lock()
+		for len(p.idle) > p.config.MinIdle && time.Since(p.idle[0].since) > p.config.IdleTimeout {
+			stale = append(stale, p.idle[0].tun)
+			p.idle = p.idle[1:]
+		}
+		p.lock.Unlock()
+
+		for _, tun := range stale {
+			p.Log.Debug("closing idle pooled tunnel")
+			tun.Close()
+		}
+	}
+}
+
+// Checks whether the tunnel is still usable, i.e. neither side closed it.
+func (t *Tunnel) live() bool {
+	select {
+	case <-t.term:
+		return false
+	default:
+		return atomic.LoadInt32(&t.atoiEOF) == 0
+	}
+}
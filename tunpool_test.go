@@ -0,0 +1,93 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+)
+
+// Tests that pooled tunnels are warmed up, reused across exchanges and torn down.
+func TestTunnelPool(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		warm      int
+		exchanges int
+	}{2, 8}
+
+	// Register a new echo service to the relay
+	serv, err := Register(config.relay, config.cluster, new(tunnelTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Create the pool and wait for the warm-up to complete
+	pool, err := conn.NewTunnelPool(config.cluster, &TunnelPoolConfig{MinIdle: conf.warm, MaxIdle: conf.warm})
+	if err != nil {
+		t.Fatalf("pool creation failed: %v.", err)
+	}
+	for start := time.Now(); pool.Idle() < conf.warm; time.Sleep(10 * time.Millisecond) {
+		if time.Since(start) > time.Second {
+			t.Fatalf("pool not warmed up: have %d, want %d.", pool.Idle(), conf.warm)
+		}
+	}
+	// Run a sequence of exchanges, verifying that the tunnels are reused
+	seen := make(map[*Tunnel]struct{})
+	for i := 0; i < conf.exchanges; i++ {
+		tun, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("exchange %d: checkout failed: %v.", i, err)
+		}
+		seen[tun] = struct{}{}
+
+		msg := []byte{byte(i)}
+		if err := tun.Send(msg, time.Second); err != nil {
+			t.Fatalf("exchange %d: send failed: %v.", i, err)
+		}
+		if reply, err := tun.Recv(time.Second); err != nil || !bytes.Equal(reply, msg) {
+			t.Fatalf("exchange %d: reply mismatch: have %v/%v, want %v.", i, reply, err, msg)
+		}
+		pool.Put(tun)
+	}
+	if len(seen) > conf.warm {
+		t.Fatalf("tunnels not reused: have %d distinct, want at most %d.", len(seen), conf.warm)
+	}
+	// Verify that closed tunnels are dropped instead of reused
+	tun, err := pool.Get(context.Background())
+	if err != nil {
+		t.Fatalf("checkout failed: %v.", err)
+	}
+	tun.Close()
+	pool.Put(tun)
+
+	for i := 0; i < conf.warm; i++ {
+		reused, err := pool.Get(context.Background())
+		if err != nil {
+			t.Fatalf("checkout failed: %v.", err)
+		}
+		if reused == tun {
+			t.Fatalf("closed tunnel reused.")
+		}
+		defer reused.Close()
+	}
+	// Tear down the pool and verify that checkouts fail
+	if err := pool.Close(); err != nil {
+		t.Fatalf("pool close failed: %v.", err)
+	}
+	if _, err := pool.Get(context.Background()); err != ErrClosed {
+		t.Fatalf("closed pool checkout mismatch: have %v, want %v.", err, ErrClosed)
+	}
+}
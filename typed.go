@@ -0,0 +1,160 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the typed request/reply and publish/subscribe layers built on top of
+// pluggable codecs.
+
+package iris
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"time"
+)
+
+// Serialization format used to convert typed messages to and from binary blobs.
+type Codec interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// Codec using the standard library's JSON encoding.
+var JSONCodec Codec = jsonCodec{}
+
+// Codec using the standard library's gob encoding. Each message is encoded as
+// a standalone stream, including its type information.
+var GobCodec Codec = gobCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+
+type gobCodec struct{}
+
+func (gobCodec) Marshal(v interface{}) ([]byte, error) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobCodec) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+// Request/reply client bound to a single cluster, exchanging typed messages
+// serialized through a codec.
+type TypedClient[Req, Resp any] struct {
+	conn    *Connection // Connection through which to issue the requests
+	cluster string      // Cluster servicing the requests
+	codec   Codec       // Codec to serialize the messages with
+}
+
+// Creates a typed client issuing requests through conn to cluster.
+func NewTypedClient[Req, Resp any](conn *Connection, cluster string, codec Codec) *TypedClient[Req, Resp] {
+	return &TypedClient[Req, Resp]{
+		conn:    conn,
+		cluster: cluster,
+		codec:   codec,
+	}
+}
+
+// Executes a synchronous typed request, returning the decoded reply.
+func (c *TypedClient[Req, Resp]) Request(request Req, timeout time.Duration) (Resp, error) {
+	return c.request(context.Background(), request, timeout)
+}
+
+// Executes a synchronous typed request, deriving the timeout from the context
+// deadline (see Connection.RequestCtx), returning the decoded reply.
+func (c *TypedClient[Req, Resp]) RequestCtx(ctx context.Context, request Req) (Resp, error) {
+	return c.request(ctx, request, 0)
+}
+
+// Encodes a request, executes it and decodes the reply. A zero timeout means
+// the context's deadline is used.
+func (c *TypedClient[Req, Resp]) request(ctx context.Context, request Req, timeout time.Duration) (Resp, error) {
+	var reply Resp
+
+	blob, err := c.codec.Marshal(request)
+	if err != nil {
+		return reply, err
+	}
+	if timeout == 0 {
+		blob, err = c.conn.RequestCtx(ctx, c.cluster, blob)
+	} else {
+		blob, err = c.conn.request(ctx, c.cluster, blob, timeout)
+	}
+	if err != nil {
+		return reply, err
+	}
+	err = c.codec.Unmarshal(blob, &reply)
+	return reply, err
+}
+
+// Decodes a raw request, invokes the typed handler with it and encodes the
+// reply. It is meant to be called from ServiceHandler.HandleRequest:
+//
+//	func (s *service) HandleRequest(req []byte) ([]byte, error) {
+//	  return iris.HandleTyped(iris.JSONCodec, req, s.handleQuery)
+//	}
+func HandleTyped[Req, Resp any](codec Codec, request []byte, handler func(Req) (Resp, error)) ([]byte, error) {
+	var req Req
+	if err := codec.Unmarshal(request, &req); err != nil {
+		return nil, err
+	}
+	reply, err := handler(req)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Marshal(reply)
+}
+
+// Publish/subscribe topic exchanging typed events serialized through a codec.
+type Topic[T any] struct {
+	conn  *Connection // Connection through which to publish and subscribe
+	name  string      // Name of the topic
+	codec Codec       // Codec to serialize the events with
+}
+
+// Creates a typed topic publishing and subscribing through conn.
+func NewTopic[T any](conn *Connection, name string, codec Codec) *Topic[T] {
+	return &Topic[T]{
+		conn:  conn,
+		name:  name,
+		codec: codec,
+	}
+}
+
+// Encodes an event and publishes it to the topic.
+func (t *Topic[T]) Publish(event T) error {
+	blob, err := t.codec.Marshal(event)
+	if err != nil {
+		return err
+	}
+	return t.conn.Publish(t.name, blob)
+}
+
+// Subscribes to the topic, invoking handler with each decoded event. Events
+// failing to decode are logged and dropped. The limits may be nil.
+func (t *Topic[T]) Subscribe(handler func(event T), limits *TopicLimits) error {
+	return t.conn.SubscribeFunc(t.name, func(topic string, blob []byte) {
+		var event T
+		if err := t.codec.Unmarshal(blob, &event); err != nil {
+			t.conn.Log.Warn("dropping undecodable event", "topic", topic, "reason", err)
+			return
+		}
+		handler(event)
+	}, limits)
+}
+
+// Unsubscribes from the topic.
+func (t *Topic[T]) Unsubscribe() error {
+	return t.conn.Unsubscribe(t.name)
+}
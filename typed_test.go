@@ -0,0 +1,154 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// Typed messages exchanged in the typed request tests.
+type typedTestRequest struct {
+	A, B int
+}
+
+type typedTestReply struct {
+	Sum int
+}
+
+// Service handler for the typed request tests, summing up the operands.
+type typedTestHandler struct {
+	conn  *Connection
+	codec Codec
+}
+
+func (t *typedTestHandler) Init(conn *Connection) error { t.conn = conn; return nil }
+func (t *typedTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (t *typedTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (t *typedTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (t *typedTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	return HandleTyped(t.codec, req, func(req typedTestRequest) (typedTestReply, error) {
+		if req.A < 0 {
+			return typedTestReply{}, errors.New("negative operand")
+		}
+		return typedTestReply{Sum: req.A + req.B}, nil
+	})
+}
+
+// Tests typed requests through all the built in codecs.
+func TestTypedRequest(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec} {
+		// Register a new typed service to the relay
+		handler := &typedTestHandler{codec: codec}
+		serv, err := Register(config.relay, config.cluster, handler, nil)
+		if err != nil {
+			t.Fatalf("%s: registration failed: %v.", name, err)
+		}
+		client := NewTypedClient[typedTestRequest, typedTestReply](handler.conn, config.cluster, codec)
+
+		// Verify a successful and a failing typed request
+		reply, err := client.Request(typedTestRequest{A: 1, B: 2}, time.Second)
+		if err != nil {
+			t.Fatalf("%s: request failed: %v.", name, err)
+		}
+		if reply.Sum != 3 {
+			t.Fatalf("%s: reply mismatch: have %v, want %v.", name, reply.Sum, 3)
+		}
+		if _, err := client.Request(typedTestRequest{A: -1}, time.Second); err == nil {
+			t.Fatalf("%s: failing request succeeded.", name)
+		} else if _, ok := err.(*RemoteError); !ok {
+			t.Fatalf("%s: failure type mismatch: have %v, want remote error.", name, err)
+		}
+		serv.Unregister()
+	}
+}
+
+// Tests typed publish/subscribe through all the built in codecs, dropping the
+// undecodable events.
+func TestTypedTopic(t *testing.T) {
+	for name, codec := range map[string]Codec{"json": JSONCodec, "gob": GobCodec} {
+		conn, err := Connect(config.relay)
+		if err != nil {
+			t.Fatalf("%s: connection failed: %v.", name, err)
+		}
+		topic := NewTopic[typedTestRequest](conn, config.topic, codec)
+
+		events := make(chan typedTestRequest, 2)
+		if err := topic.Subscribe(func(event typedTestRequest) { events <- event }, nil); err != nil {
+			t.Fatalf("%s: subscription failed: %v.", name, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+
+		// Publish a garbage event followed by a valid one, expecting only the latter
+		if err := conn.Publish(config.topic, []byte("garbage")); err != nil {
+			t.Fatalf("%s: raw publish failed: %v.", name, err)
+		}
+		if err := topic.Publish(typedTestRequest{A: 1, B: 2}); err != nil {
+			t.Fatalf("%s: typed publish failed: %v.", name, err)
+		}
+		select {
+		case event := <-events:
+			if event.A != 1 || event.B != 2 {
+				t.Fatalf("%s: event mismatch: have %+v, want %+v.", name, event, typedTestRequest{A: 1, B: 2})
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("%s: typed event not delivered.", name)
+		}
+		if err := topic.Unsubscribe(); err != nil {
+			t.Fatalf("%s: unsubscription failed: %v.", name, err)
+		}
+		conn.Close()
+	}
+}
+
+// Tests that typed topics hold back and release their deliveries when paused
+// and resumed.
+func TestTypedTopicPause(t *testing.T) {
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	topic := NewTopic[int](conn, config.topic, JSONCodec)
+	if err := topic.Pause(); err != ErrNotSubscribed {
+		t.Fatalf("unsubscribed pause error mismatch: have %v, want %v.", err, ErrNotSubscribed)
+	}
+	events := make(chan int, 1)
+	if err := topic.Subscribe(func(event int) { events <- event }, nil); err != nil {
+		t.Fatalf("subscription failed: %v.", err)
+	}
+	defer topic.Unsubscribe()
+	time.Sleep(100 * time.Millisecond)
+
+	// Pause the topic and verify that events are held back
+	if err := topic.Pause(); err != nil {
+		t.Fatalf("pause failed: %v.", err)
+	}
+	if err := topic.Publish(1); err != nil {
+		t.Fatalf("publish failed: %v.", err)
+	}
+	select {
+	case event := <-events:
+		t.Fatalf("event delivered while paused: %v.", event)
+	case <-time.After(100 * time.Millisecond):
+	}
+	// Resume the topic and verify that the held back event is delivered
+	if err := topic.Resume(); err != nil {
+		t.Fatalf("resume failed: %v.", err)
+	}
+	select {
+	case event := <-events:
+		if event != 1 {
+			t.Fatalf("event mismatch: have %v, want %v.", event, 1)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("held back event not delivered.")
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the inbound message size limits and validation hook, vetting the
+// arriving payloads before they are queued for the handlers.
+
+package iris
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Returned (remotely for requests) if an inbound message was rejected by the
+// size limits or the validator of the receiving connection.
+var ErrMessageRejected = errors.New("message rejected")
+
+// Callback vetting an inbound payload of the given operation arriving for target
+// (the local cluster or the topic). A non-nil error rejects the message.
+type MessageValidator func(op TraceOp, target string, payload []byte) error
+
+// User limits on the inbound messages of a connection.
+type MessageLimits struct {
+	MaxBroadcast int // Largest inbound broadcast accepted (zero for unlimited)
+	MaxRequest   int // Largest inbound request accepted (zero for unlimited)
+	MaxEvent     int // Largest inbound topic event accepted (zero for unlimited)
+	MaxTunnel    int // Largest inbound tunnel message accepted (zero for unlimited)
+
+	Validator MessageValidator // Callback vetting the inbound payloads, nil to accept all
+}
+
+// Sets the limits and validator to vet the inbound messages of the connection
+// with, replacing any previous ones. Nil disables the checks.
+//
+// Rejected broadcasts, events and tunnel messages are dropped (and logged), while
+// rejected requests are failed back to the caller with CodeInvalidArgument. The
+// size of a tunnel message is checked against its announced total before any of
+// it is buffered; the validator sees it fully assembled, unless the tunnel is
+// end-to-end encrypted (in which case only the size is checked).
+func (c *Connection) SetMessageLimits(limits *MessageLimits) {
+	c.msgLock.Lock()
+	defer c.msgLock.Unlock()
+
+	if limits == nil {
+		c.msgLimits = nil
+		return
+	}
+	config := *limits
+	c.msgLimits = &config
+}
+
+// Checks an inbound message of the given wire size against the configured size
+// limit of its operation, and its payload against the validator, if any.
+func (c *Connection) validateInbound(op TraceOp, target string, size int, payload []byte) error {
+	c.msgLock.RLock()
+	limits := c.msgLimits
+	c.msgLock.RUnlock()
+
+	if limits == nil {
+		return nil
+	}
+	if err := limits.checkSize(op, size); err != nil {
+		return err
+	}
+	if limits.Validator != nil {
+		if err := limits.Validator(op, target, payload); err != nil {
+			return fmt.Errorf("%w: %v", ErrMessageRejected, err)
+		}
+	}
+	return nil
+}
+
+// Checks the size of an inbound message against the limit of its operation.
+func (l *MessageLimits) checkSize(op TraceOp, size int) error {
+	var limit int
+	switch op {
+	case TraceBroadcast:
+		limit = l.MaxBroadcast
+	case TraceRequest:
+		limit = l.MaxRequest
+	case TracePublish:
+		limit = l.MaxEvent
+	case TraceTunnel:
+		limit = l.MaxTunnel
+	}
+	if limit > 0 && size > limit {
+		return fmt.Errorf("%w: %s of %d bytes exceeds limit of %d", ErrMessageRejected, op, size, limit)
+	}
+	return nil
+}
+
+// Checks the announced size of an inbound tunnel message against the limit,
+// before any of it is buffered.
+func (c *Connection) checkTunnelSize(size int) error {
+	c.msgLock.RLock()
+	limits := c.msgLimits
+	c.msgLock.RUnlock()
+
+	if limits == nil {
+		return nil
+	}
+	return limits.checkSize(TraceTunnel, size)
+}
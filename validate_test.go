@@ -0,0 +1,129 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+)
+
+// Validator rejecting all payloads starting with an 'x'.
+func validateTestPayload(op TraceOp, target string, payload []byte) error {
+	if bytes.HasPrefix(payload, []byte("x")) {
+		return errors.New("forbidden prefix")
+	}
+	return nil
+}
+
+// Tests that oversized and malformed requests are rejected back to the caller.
+func TestMessageLimitsRequest(t *testing.T) {
+	// Register a new service to the relay and limit its inbound requests
+	handler := new(requestTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.SetMessageLimits(&MessageLimits{MaxRequest: 8, Validator: validateTestPayload})
+
+	// Check that an acceptable request passes
+	if rep, err := handler.conn.Request(config.cluster, []byte("hello"), time.Second); err != nil || string(rep) != "hello" {
+		t.Fatalf("valid request mismatch: have %q/%v, want %q/nil.", rep, err, "hello")
+	}
+	// Check that oversized and malformed ones are rejected without timing out
+	for _, req := range []string{"hello world", "xenon"} {
+		_, err := handler.conn.Request(config.cluster, []byte(req), time.Second)
+
+		var rerr *RemoteError
+		if !errors.As(err, &rerr) || rerr.Code != CodeInvalidArgument {
+			t.Fatalf("request %q result mismatch: have %v, want code %v.", req, err, CodeInvalidArgument)
+		}
+	}
+	// Check that removing the limits accepts everything again
+	handler.conn.SetMessageLimits(nil)
+	if _, err := handler.conn.Request(config.cluster, []byte("hello world"), time.Second); err != nil {
+		t.Fatalf("unlimited request failed: %v.", err)
+	}
+}
+
+// Tests that oversized and malformed broadcasts are dropped.
+func TestMessageLimitsBroadcast(t *testing.T) {
+	// Register a new service to the relay and limit its inbound broadcasts
+	handler := &broadcastTestHandler{
+		delivers: make(chan []byte, 4),
+	}
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.SetMessageLimits(&MessageLimits{MaxBroadcast: 8, Validator: validateTestPayload})
+
+	// Send a mix of broadcasts and check that only the valid one arrives
+	for _, msg := range []string{"hello world", "xenon", "hello"} {
+		if err := handler.conn.Broadcast(config.cluster, []byte(msg)); err != nil {
+			t.Fatalf("broadcast failed: %v.", err)
+		}
+	}
+	select {
+	case msg := <-handler.delivers:
+		if string(msg) != "hello" {
+			t.Fatalf("broadcast mismatch: have %q, want %q.", msg, "hello")
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("valid broadcast not received.")
+	}
+	select {
+	case msg := <-handler.delivers:
+		t.Fatalf("rejected broadcast received: %q.", msg)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+// Tests that oversized tunnel messages are dropped without disrupting the stream.
+func TestMessageLimitsTunnel(t *testing.T) {
+	// Test specific configurations
+	conf := struct {
+		limit int
+		chunk int
+	}{1024, 256}
+
+	// Register a new service to the relay and limit its inbound tunnel messages
+	handler := new(tunnelTestHandler)
+	serv, err := Register(config.relay, config.cluster, handler, nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	handler.conn.SetMessageLimits(&MessageLimits{MaxTunnel: conf.limit, Validator: validateTestPayload})
+
+	// Open a chunked tunnel and send an oversized, a malformed and a valid message
+	tunnel, err := handler.conn.TunnelWithConfig(config.cluster, time.Second, &TunnelConfig{ChunkLimit: conf.chunk})
+	if err != nil {
+		t.Fatalf("tunnel construction failed: %v.", err)
+	}
+	defer tunnel.Close()
+
+	for _, msg := range [][]byte{make([]byte, 4*conf.limit), []byte("xenon"), []byte("hello")} {
+		if err := tunnel.Send(msg, time.Second); err != nil {
+			t.Fatalf("tunnel send failed: %v.", err)
+		}
+	}
+	// Check that only the valid message is echoed back
+	msg, err := tunnel.Recv(time.Second)
+	if err != nil || string(msg) != "hello" {
+		t.Fatalf("echo mismatch: have %q/%v, want %q/nil.", msg, err, "hello")
+	}
+	if msg, err := tunnel.Recv(50 * time.Millisecond); err != ErrTimeout {
+		t.Fatalf("rejected message echoed: have %q/%v, want timeout.", msg, err)
+	}
+}
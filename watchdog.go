@@ -0,0 +1,87 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+// Contains the watchdog of the request handlers, reporting the ones running for
+// too long along with the stack trace of their goroutine, captured while they
+// are still stuck.
+
+package iris
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// Sets the duration after which a running request handler is deemed slow (zero
+// disables): its goroutine's stack trace is logged as a warning while it's still
+// running, and it's counted in the RequestsSlow metric. Detection costs a timer
+// and a stack header capture per request, so the watchdog is disabled by default.
+func (s *Service) SetRequestWatchdog(threshold time.Duration) error {
+	if threshold < 0 {
+		return invalidArgument("invalid watchdog threshold %v", threshold)
+	}
+	s.Log.Info("setting request watchdog", "threshold", threshold)
+	atomic.StoreInt64(&s.conn.slowLimit, int64(threshold))
+	return nil
+}
+
+// Starts watching the request handler running on the calling goroutine, returning
+// the function to call when it finishes.
+func (c *Connection) watchRequest() func() {
+	threshold := time.Duration(atomic.LoadInt64(&c.slowLimit))
+	if threshold <= 0 {
+		return func() {}
+	}
+	start, id := time.Now(), goroutineId()
+
+	timer := time.AfterFunc(threshold, func() {
+		atomic.AddUint64(&c.stats.reqSlow, 1)
+		c.Log.Warn("request handler running slow", "elapsed", time.Since(start), "threshold", threshold, "stack", goroutineStack(id))
+	})
+	return func() {
+		if !timer.Stop() {
+			c.Log.Warn("slow request handler finished", "elapsed", time.Since(start), "threshold", threshold)
+		}
+	}
+}
+
+// Retrieves the identifier of the calling goroutine from its stack trace header
+// (e.g. "goroutine 42 [running]:").
+func goroutineId() uint64 {
+	var buf [64]byte
+	header := buf[:runtime.Stack(buf[:], false)]
+	header = bytes.TrimPrefix(header, []byte("goroutine "))
+	if idx := bytes.IndexByte(header, ' '); idx > 0 {
+		header = header[:idx]
+	}
+	id, _ := strconv.ParseUint(string(header), 10, 64)
+	return id
+}
+
+// Captures the stack trace of a goroutine, or an empty string if it's gone.
+func goroutineStack(id uint64) string {
+	// Dump all the goroutines, growing the buffer until they fit
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+	// Goroutine traces are separated by empty lines, find the requested one
+	header := []byte("goroutine " + strconv.FormatUint(id, 10) + " [")
+	for _, trace := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(trace, header) {
+			return string(trace)
+		}
+	}
+	return ""
+}
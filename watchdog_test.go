@@ -0,0 +1,75 @@
+// Copyright (c) 2014 Project Iris. All rights reserved.
+//
+// The current language binding is an official support library of the Iris
+// cloud messaging framework, and as such, the same licensing terms apply.
+// For details please see http://iris.karalabe.com/downloads#License
+
+package iris
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Service handler for the watchdog tests, stalling the requests asking for it.
+type watchdogTestHandler struct{}
+
+func (h *watchdogTestHandler) Init(conn *Connection) error { return nil }
+func (h *watchdogTestHandler) HandleBroadcast(msg []byte)  { panic("not implemented") }
+func (h *watchdogTestHandler) HandleTunnel(tun *Tunnel)    { panic("not implemented") }
+func (h *watchdogTestHandler) HandleDrop(reason error)     { panic("not implemented") }
+
+func (h *watchdogTestHandler) HandleRequest(req []byte) ([]byte, error) {
+	if string(req) == "slow" {
+		time.Sleep(100 * time.Millisecond)
+	}
+	return req, nil
+}
+
+// Tests that the request watchdog counts only the handlers exceeding its
+// threshold, and that it captures the stack of the right goroutine.
+func TestRequestWatchdog(t *testing.T) {
+	// Verify that the stack capture finds the calling goroutine
+	if stack := goroutineStack(goroutineId()); !strings.Contains(stack, "TestRequestWatchdog") {
+		t.Fatalf("captured stack mismatch: have %q.", stack)
+	}
+	// Register a new service to the relay with the watchdog enabled
+	serv, err := Register(config.relay, config.cluster, new(watchdogTestHandler), nil)
+	if err != nil {
+		t.Fatalf("registration failed: %v.", err)
+	}
+	defer serv.Unregister()
+
+	if err := serv.SetRequestWatchdog(-time.Second); err == nil {
+		t.Fatalf("negative watchdog threshold accepted.")
+	}
+	if err := serv.SetRequestWatchdog(25 * time.Millisecond); err != nil {
+		t.Fatalf("failed to set watchdog: %v.", err)
+	}
+	conn, err := Connect(config.relay)
+	if err != nil {
+		t.Fatalf("connection failed: %v.", err)
+	}
+	defer conn.Close()
+
+	// Issue a fast and a slow request, and verify only the latter is reported
+	for _, req := range []string{"fast", "slow"} {
+		if _, err := conn.Request(config.cluster, []byte(req), time.Second); err != nil {
+			t.Fatalf("%s request failed: %v.", req, err)
+		}
+	}
+	if slow := serv.conn.Metrics().RequestsSlow; slow != 1 {
+		t.Fatalf("slow request count mismatch: have %d, want %d.", slow, 1)
+	}
+	// Disable the watchdog and verify slow requests are not reported any more
+	if err := serv.SetRequestWatchdog(0); err != nil {
+		t.Fatalf("failed to disable watchdog: %v.", err)
+	}
+	if _, err := conn.Request(config.cluster, []byte("slow"), time.Second); err != nil {
+		t.Fatalf("slow request failed: %v.", err)
+	}
+	if slow := serv.conn.Metrics().RequestsSlow; slow != 1 {
+		t.Fatalf("slow request count mismatch: have %d, want %d.", slow, 1)
+	}
+}